@@ -0,0 +1,49 @@
+// Package a11y finds accessibility problems in rendered entry content.
+package a11y
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Check returns a description of each accessibility problem found in html:
+// images without alt text, headings that skip levels, and links without any
+// text.
+func Check(html string) []string {
+	ret := []string{}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return []string{fmt.Sprintf("Failed to parse content: %s", err)}
+	}
+	doc.Find("img").Each(func(i int, s *goquery.Selection) {
+		if alt, ok := s.Attr("alt"); !ok || strings.TrimSpace(alt) == "" {
+			ret = append(ret, fmt.Sprintf("Image %q has no alt text.", s.AttrOr("src", "")))
+		}
+	})
+	last := 0
+	doc.Find("h1, h2, h3, h4, h5, h6").Each(func(i int, s *goquery.Selection) {
+		level := int(goquery.NodeName(s)[1] - '0')
+		if last != 0 && level > last+1 {
+			ret = append(ret, fmt.Sprintf("Heading %q skips from h%d to h%d.", strings.TrimSpace(s.Text()), last, level))
+		}
+		last = level
+	})
+	doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+		if strings.TrimSpace(s.Text()) != "" || s.AttrOr("aria-label", "") != "" {
+			return
+		}
+		// An image with alt text is an accessible name for the link.
+		named := false
+		s.Find("img[alt]").Each(func(i int, img *goquery.Selection) {
+			if strings.TrimSpace(img.AttrOr("alt", "")) != "" {
+				named = true
+			}
+		})
+		if !named {
+			ret = append(ret, fmt.Sprintf("Link to %q has no text.", s.AttrOr("href", "")))
+		}
+	})
+	return ret
+}
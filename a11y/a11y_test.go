@@ -0,0 +1,32 @@
+package a11y
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck_Clean(t *testing.T) {
+	assert.Equal(t, []string{}, Check(`<h2>Title</h2><h3>Sub</h3><h2>Next</h2>
+<p><img src="/images/a.jpg" alt="A cat"> <a href="https://example.org">Example</a>
+<a href="/entry/1"><img src="/images/b.jpg" alt="A dog"></a></p>`))
+}
+
+func TestCheck_Images(t *testing.T) {
+	assert.Equal(t, []string{
+		`Image "/images/a.jpg" has no alt text.`,
+		`Image "/images/b.jpg" has no alt text.`,
+	}, Check(`<img src="/images/a.jpg" alt=""><img src="/images/b.jpg">`))
+}
+
+func TestCheck_Headings(t *testing.T) {
+	assert.Equal(t, []string{
+		`Heading "Deep" skips from h2 to h4.`,
+	}, Check(`<h2>Top</h2><h4>Deep</h4><h5>Deeper</h5><h2>Back</h2>`))
+}
+
+func TestCheck_Links(t *testing.T) {
+	assert.Equal(t, []string{
+		`Link to "https://example.org" has no text.`,
+	}, Check(`<a href="https://example.org"> </a><a href="/x" aria-label="X"></a><a href="/entry/1"><img src="/images/a.jpg" alt="x"></a>`))
+}
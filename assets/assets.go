@@ -0,0 +1,139 @@
+// Package assets serves the site's static files, such as CSS, JS, and icons,
+// at paths that include a hash of their contents, so they can be cached
+// forever. The files are embedded in the binary, and can be overridden by a
+// directory on disk, e.g. when developing locally.
+package assets
+
+import (
+	"crypto/sha256"
+	"embed"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jcgregorio/stream-run/cachecontrol"
+)
+
+// PREFIX is the path the assets are served under.
+const PREFIX = "/assets/"
+
+//go:embed static
+var embedded embed.FS
+
+// Embedded returns the files embedded in the binary.
+func Embedded() fs.FS {
+	sub, err := fs.Sub(embedded, "static")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// Assets fingerprints and serves the files in a file system.
+type Assets struct {
+	fsys fs.FS
+
+	// cache is false when files may change while running, e.g. locally.
+	cache bool
+
+	mutex  sync.Mutex
+	hashes map[string]string
+}
+
+// New returns Assets for the files in fsys. If cache is false the files are
+// hashed on every request, so edits show up without a restart.
+func New(fsys fs.FS, cache bool) *Assets {
+	return &Assets{
+		fsys:   fsys,
+		cache:  cache,
+		hashes: map[string]string{},
+	}
+}
+
+// Hash returns the hash of the file at name, or "" if there isn't one.
+func (a *Assets) Hash(name string) string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if h, ok := a.hashes[name]; ok && a.cache {
+		return h
+	}
+	if !fs.ValidPath(name) {
+		return ""
+	}
+	b, err := fs.ReadFile(a.fsys, name)
+	if err != nil {
+		return ""
+	}
+	h := fmt.Sprintf("%x", sha256.Sum256(b))[:12]
+	a.hashes[name] = h
+	return h
+}
+
+// URL returns the path the current version of the file at name is served
+// at, e.g. "/assets/1a2b3c4d5e6f/stream.css". Unknown files get a path that
+// will 404, so typos show up.
+func (a *Assets) URL(name string) string {
+	h := a.Hash(name)
+	if h == "" {
+		return PREFIX + "missing/" + name
+	}
+	return PREFIX + h + "/" + name
+}
+
+// Names returns the names of all the files, sorted.
+func (a *Assets) Names() ([]string, error) {
+	ret := []string{}
+	err := fs.WalkDir(a.fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			ret = append(ret, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list assets: %s", err)
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// ServeHTTP serves requests for paths under PREFIX. The current version of a
+// file is IMMUTABLE. Requests for old versions, e.g. from pages cached before
+// a deploy, get the current file, but only cached briefly.
+func (a *Assets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hash, name, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, PREFIX), "/")
+	current := a.Hash(name)
+	if !ok || current == "" {
+		http.NotFound(w, r)
+		return
+	}
+	b, err := fs.ReadFile(a.fsys, name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if hash == current {
+		cachecontrol.Set(w, cachecontrol.IMMUTABLE)
+	} else {
+		cachecontrol.Set(w, cachecontrol.STATIC)
+	}
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		w.Header().Set("Content-Type", t)
+	}
+	w.Header().Set("ETag", `"`+current+`"`)
+	if r.Header.Get("If-None-Match") == `"`+current+`"` {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if r.Method == "HEAD" {
+		return
+	}
+	w.Write(b)
+}
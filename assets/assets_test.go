@@ -0,0 +1,53 @@
+package assets
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jcgregorio/stream-run/cachecontrol"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmbedded(t *testing.T) {
+	a := New(Embedded(), true)
+	names, err := a.Names()
+	assert.NoError(t, err)
+	assert.Contains(t, names, "stream.css")
+	assert.Contains(t, names, "icon-192x192.png")
+}
+
+func TestServeHTTP(t *testing.T) {
+	fsys := fstest.MapFS{
+		"stream.css": {Data: []byte("body {}")},
+	}
+	a := New(fsys, false)
+	u := a.URL("stream.css")
+	assert.Regexp(t, `^/assets/[0-9a-f]{12}/stream.css$`, u)
+	assert.Equal(t, "/assets/missing/nope.css", a.URL("nope.css"))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		a.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+	w := get(u)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "body {}", w.Body.String())
+	assert.Equal(t, string(cachecontrol.IMMUTABLE), w.Header().Get("Cache-Control"))
+	assert.Contains(t, w.Header().Get("Content-Type"), "text/css")
+
+	// Old versions get the current file, but not forever.
+	w = get("/assets/000000000000/stream.css")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, string(cachecontrol.STATIC), w.Header().Get("Cache-Control"))
+
+	// Edits change the URL when not caching hashes.
+	fsys["stream.css"] = &fstest.MapFile{Data: []byte("body { margin: 0 }")}
+	assert.NotEqual(t, u, a.URL("stream.css"))
+
+	assert.Equal(t, http.StatusNotFound, get("/assets/missing/nope.css").Code)
+	assert.Equal(t, http.StatusNotFound, get("/assets/stream.css").Code)
+	assert.Equal(t, http.StatusNotFound, get("/assets/x/../../etc/passwd").Code)
+}
@@ -0,0 +1,7 @@
+// Only load mermaid on pages that have diagrams.
+if (document.querySelector("pre.mermaid")) {
+  import("https://cdn.jsdelivr.net/npm/mermaid@10/dist/mermaid.esm.min.mjs").then(m => {
+    m.default.initialize({ startOnLoad: false });
+    m.default.run();
+  });
+}
@@ -0,0 +1,377 @@
+// Package auth decides who is an admin. Google sign-in, the default, is
+// handled by go-lib/admin, and this package adds GitHub OAuth, HTTP basic
+// auth, and client certificates for deployments that don't use Google.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/jcgregorio/slog"
+)
+
+const (
+	// IDENTITY_COOKIE holds the signed admin identity after a GitHub sign
+	// in.
+	IDENTITY_COOKIE = "auth_identity"
+
+	// STATE_COOKIE holds the OAuth state while signing in with GitHub.
+	STATE_COOKIE = "auth_state"
+
+	// IDENTITY_LENGTH is how long a GitHub sign in lasts, which only needs
+	// to be long enough to start a session at NEXT.
+	IDENTITY_LENGTH = 10 * time.Minute
+
+	// NEXT is where the browser is sent after signing in, which starts an
+	// admin session.
+	NEXT = "/auth/session"
+
+	// BASIC_REALM is the realm sent in basic auth challenges.
+	BASIC_REALM = "stream"
+)
+
+// Authenticator decides if a request is from an admin. *admin.Admin from
+// go-lib, which does Google sign-in, is an Authenticator.
+type Authenticator interface {
+	IsAdmin(r *http.Request, log slog.Logger) bool
+}
+
+// Login is implemented by Authenticators that serve their own sign in pages,
+// which are mounted under /auth/. Signing in starts at /auth/login.
+type Login interface {
+	Authenticator
+	http.Handler
+}
+
+// contains returns true if s is in list, ignoring case.
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if strings.EqualFold(l, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Basic accepts HTTP basic auth for the users in an htpasswd file, with
+// bcrypt hashed passwords, i.e. as written by "htpasswd -B". It is meant to
+// be run behind a proxy that provides TLS.
+type Basic struct {
+	users map[string][]byte
+}
+
+// NewBasic returns a Basic for the given htpasswd lines.
+func NewBasic(lines []string) (*Basic, error) {
+	users := map[string][]byte{}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[1], "$2") {
+			return nil, fmt.Errorf("Not a bcrypt htpasswd line for %q.", parts[0])
+		}
+		users[parts[0]] = []byte(parts[1])
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("No basic auth users.")
+	}
+	return &Basic{users: users}, nil
+}
+
+// valid returns nil if password is right for user.
+func (b *Basic) valid(user, password string) error {
+	hash, ok := b.users[user]
+	if !ok {
+		return fmt.Errorf("Unknown user.")
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password))
+}
+
+func (b *Basic) IsAdmin(r *http.Request, log slog.Logger) bool {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	if err := b.valid(user, password); err != nil {
+		log.Warningf("Failed basic auth for %q: %s", user, err)
+		return false
+	}
+	return true
+}
+
+// ServeHTTP asks the browser for a user name and password at /auth/login,
+// and sends it on to NEXT once they are valid.
+func (b *Basic) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/auth/login" {
+		http.NotFound(w, r)
+		return
+	}
+	if user, password, ok := r.BasicAuth(); ok && b.valid(user, password) == nil {
+		http.Redirect(w, r, NEXT, http.StatusFound)
+		return
+	}
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", BASIC_REALM))
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+// ClientCert accepts requests made with a verified TLS client certificate
+// whose email address, or common name, is in admins. The server has to be
+// configured to verify client certificates for this to work.
+type ClientCert struct {
+	admins []string
+}
+
+func NewClientCert(admins []string) *ClientCert {
+	return &ClientCert{admins: admins}
+}
+
+func (c *ClientCert) IsAdmin(r *http.Request, log slog.Logger) bool {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return false
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	for _, email := range cert.EmailAddresses {
+		if contains(c.admins, email) {
+			return true
+		}
+	}
+	return contains(c.admins, cert.Subject.CommonName)
+}
+
+// GitHub signs in with GitHub OAuth. The primary, verified, email address of
+// the GitHub account must be in admins, the same as for Google sign-in.
+type GitHub struct {
+	clientID     string
+	clientSecret string
+
+	// host is the stream's URL, used to build the OAuth callback URL.
+	host   string
+	admins []string
+
+	// secret signs the identity cookie.
+	secret []byte
+
+	client *http.Client
+
+	// The GitHub endpoints are replaceable for testing.
+	authorizeURL string
+	tokenURL     string
+	emailsURL    string
+
+	// now is replaceable for testing.
+	now func() time.Time
+}
+
+// NewGitHub returns a GitHub for the OAuth app with the given client id and
+// secret, whose callback URL is host + "/auth/callback". The identity cookie
+// is signed with secret.
+func NewGitHub(clientID, clientSecret, host string, admins []string, secret string, client *http.Client) (*GitHub, error) {
+	if clientID == "" || clientSecret == "" {
+		return nil, fmt.Errorf("GitHub sign in needs both a client id and secret.")
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("GitHub sign in needs a session secret.")
+	}
+	return &GitHub{
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		host:         strings.TrimSuffix(host, "/"),
+		admins:       admins,
+		secret:       []byte(secret),
+		client:       client,
+		authorizeURL: "https://github.com/login/oauth/authorize",
+		tokenURL:     "https://github.com/login/oauth/access_token",
+		emailsURL:    "https://api.github.com/user/emails",
+		now:          time.Now,
+	}, nil
+}
+
+func (g *GitHub) sign(value string) string {
+	mac := hmac.New(sha256.New, g.secret)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// identity returns the signed cookie value for email.
+func (g *GitHub) identity(email string) string {
+	value := base64.RawURLEncoding.EncodeToString([]byte(email)) + "." + strconv.FormatInt(g.now().Add(IDENTITY_LENGTH).Unix(), 10)
+	return value + "." + g.sign(value)
+}
+
+// email returns the email address in an identity cookie value, or "" if the
+// value isn't validly signed or has expired.
+func (g *GitHub) email(identity string) string {
+	parts := strings.Split(identity, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(g.sign(parts[0]+"."+parts[1]))) {
+		return ""
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || g.now().Unix() > expires {
+		return ""
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func (g *GitHub) IsAdmin(r *http.Request, log slog.Logger) bool {
+	c, err := r.Cookie(IDENTITY_COOKIE)
+	if err != nil {
+		return false
+	}
+	email := g.email(c.Value)
+	return email != "" && contains(g.admins, email)
+}
+
+// ServeHTTP handles /auth/login, which sends the browser to GitHub, and
+// /auth/callback, where GitHub sends it back.
+func (g *GitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/auth/login":
+		g.login(w, r)
+	case "/auth/callback":
+		g.callback(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (g *GitHub) login(w http.ResponseWriter, r *http.Request) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(w, "Failed to start sign in.", http.StatusInternalServerError)
+		return
+	}
+	state := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     STATE_COOKIE,
+		Value:    state,
+		Path:     "/auth/",
+		MaxAge:   10 * 60,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(g.host, "https:"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	v := url.Values{}
+	v.Set("client_id", g.clientID)
+	v.Set("redirect_uri", g.host+"/auth/callback")
+	v.Set("scope", "user:email")
+	v.Set("state", state)
+	http.Redirect(w, r, g.authorizeURL+"?"+v.Encode(), http.StatusFound)
+}
+
+func (g *GitHub) callback(w http.ResponseWriter, r *http.Request) {
+	c, err := r.Cookie(STATE_COOKIE)
+	if err != nil || c.Value == "" || subtle.ConstantTimeCompare([]byte(c.Value), []byte(r.FormValue("state"))) != 1 {
+		http.Error(w, "Sign in state didn't match, try again.", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: STATE_COOKIE, Path: "/auth/", MaxAge: -1})
+	token, err := g.token(r.FormValue("code"))
+	if err != nil {
+		http.Error(w, "Failed to sign in with GitHub.", http.StatusBadGateway)
+		return
+	}
+	email, err := g.primaryEmail(token)
+	if err != nil {
+		http.Error(w, "Failed to get the email address of the GitHub account.", http.StatusBadGateway)
+		return
+	}
+	if !contains(g.admins, email) {
+		http.Error(w, "Not an admin.", http.StatusForbidden)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     IDENTITY_COOKIE,
+		Value:    g.identity(email),
+		Path:     "/auth/",
+		MaxAge:   int(IDENTITY_LENGTH.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(g.host, "https:"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, NEXT, http.StatusFound)
+}
+
+// token exchanges an OAuth code for an access token.
+func (g *GitHub) token(code string) (string, error) {
+	v := url.Values{}
+	v.Set("client_id", g.clientID)
+	v.Set("client_secret", g.clientSecret)
+	v.Set("code", code)
+	req, err := http.NewRequest("POST", g.tokenURL, strings.NewReader(v.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Failed to request token: %s", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("Failed to decode token: %s", err)
+	}
+	if body.AccessToken == "" {
+		return "", fmt.Errorf("No token returned: %s", body.Error)
+	}
+	return body.AccessToken, nil
+}
+
+// primaryEmail returns the primary, verified, email address of the account
+// that token belongs to.
+func (g *GitHub) primaryEmail(token string) (string, error) {
+	req, err := http.NewRequest("GET", g.emailsURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Failed to request emails: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Failed to request emails: %s", resp.Status)
+	}
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&emails); err != nil {
+		return "", fmt.Errorf("Failed to decode emails: %s", err)
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			return e.Email, nil
+		}
+	}
+	return "", fmt.Errorf("No primary verified email.")
+}
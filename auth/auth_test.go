@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jcgregorio/logger"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBasic(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	b, err := NewBasic([]string{"joe:" + string(hash), ""})
+	assert.NoError(t, err)
+
+	r := httptest.NewRequest("GET", "/admin", nil)
+	assert.False(t, b.IsAdmin(r, logger.New()))
+	r.SetBasicAuth("joe", "secret")
+	assert.True(t, b.IsAdmin(r, logger.New()))
+	r.SetBasicAuth("joe", "wrong")
+	assert.False(t, b.IsAdmin(r, logger.New()))
+	r.SetBasicAuth("fred", "secret")
+	assert.False(t, b.IsAdmin(r, logger.New()))
+
+	w := httptest.NewRecorder()
+	b.ServeHTTP(w, httptest.NewRequest("GET", "/auth/login", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Basic")
+
+	_, err = NewBasic([]string{"joe:{SHA}abc"})
+	assert.Error(t, err)
+	_, err = NewBasic(nil)
+	assert.Error(t, err)
+}
+
+func TestClientCert(t *testing.T) {
+	c := NewClientCert([]string{"Joe@example.com"})
+	r := httptest.NewRequest("GET", "/admin", nil)
+	assert.False(t, c.IsAdmin(r, logger.New()))
+
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{EmailAddresses: []string{"joe@example.com"}}}}}
+	assert.True(t, c.IsAdmin(r, logger.New()))
+
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "joe@example.com"}}}}}
+	assert.True(t, c.IsAdmin(r, logger.New()))
+
+	// Unverified certificates don't count.
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{EmailAddresses: []string{"joe@example.com"}}}}
+	assert.False(t, c.IsAdmin(r, logger.New()))
+}
+
+func TestGitHub(t *testing.T) {
+	gh := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			assert.Equal(t, "the-code", r.FormValue("code"))
+			assert.Equal(t, "shh", r.FormValue("client_secret"))
+			fmt.Fprint(w, `{"access_token": "tok"}`)
+		case "/emails":
+			assert.Equal(t, "Bearer tok", r.Header.Get("Authorization"))
+			fmt.Fprint(w, `[{"email": "other@example.com", "primary": false, "verified": true}, {"email": "joe@example.com", "primary": true, "verified": true}]`)
+		}
+	}))
+	defer gh.Close()
+
+	g, err := NewGitHub("id", "shh", "https://example.org/", []string{"joe@example.com"}, "session-secret", gh.Client())
+	assert.NoError(t, err)
+	g.authorizeURL = gh.URL + "/authorize"
+	g.tokenURL = gh.URL + "/token"
+	g.emailsURL = gh.URL + "/emails"
+
+	// Login redirects to GitHub with a state that is also set in a cookie.
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest("GET", "/auth/login", nil))
+	assert.Equal(t, http.StatusFound, w.Code)
+	loc, err := url.Parse(w.Header().Get("Location"))
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.org/auth/callback", loc.Query().Get("redirect_uri"))
+	state := loc.Query().Get("state")
+	assert.NotEmpty(t, state)
+
+	// A callback with the wrong state fails.
+	r := httptest.NewRequest("GET", "/auth/callback?code=the-code&state=wrong", nil)
+	r.AddCookie(&http.Cookie{Name: STATE_COOKIE, Value: state})
+	w = httptest.NewRecorder()
+	g.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	r = httptest.NewRequest("GET", "/auth/callback?code=the-code&state="+state, nil)
+	r.AddCookie(&http.Cookie{Name: STATE_COOKIE, Value: state})
+	w = httptest.NewRecorder()
+	g.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, NEXT, w.Header().Get("Location"))
+	var identity *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == IDENTITY_COOKIE {
+			identity = c
+		}
+	}
+	assert.NotNil(t, identity)
+
+	r = httptest.NewRequest("GET", NEXT, nil)
+	r.AddCookie(identity)
+	assert.True(t, g.IsAdmin(r, logger.New()))
+
+	// Tampered and expired identities are rejected.
+	r = httptest.NewRequest("GET", NEXT, nil)
+	r.AddCookie(&http.Cookie{Name: IDENTITY_COOKIE, Value: identity.Value + "0"})
+	assert.False(t, g.IsAdmin(r, logger.New()))
+
+	g.now = func() time.Time { return time.Now().Add(IDENTITY_LENGTH + time.Minute) }
+	r = httptest.NewRequest("GET", NEXT, nil)
+	r.AddCookie(identity)
+	assert.False(t, g.IsAdmin(r, logger.New()))
+}
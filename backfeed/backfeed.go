@@ -0,0 +1,174 @@
+// Package backfeed fetches interactions with syndicated copies of entries,
+// such as replies and likes on Mastodon, so they can be stored as mentions of
+// the original entry.
+package backfeed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/jcgregorio/stream-run/mentions"
+)
+
+// statusPaths match the paths of Mastodon status URLs, for example
+// /@user/1234 or /users/user/statuses/1234.
+var statusPaths = []*regexp.Regexp{
+	regexp.MustCompile(`^/@[^/]+/(\d+)$`),
+	regexp.MustCompile(`^/users/[^/]+/statuses/(\d+)$`),
+}
+
+// ParseStatusURL returns the instance base URL and status id of a Mastodon
+// status URL, or an error if the URL doesn't look like one.
+func ParseStatusURL(s string) (string, string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return "", "", fmt.Errorf("Not a Mastodon status URL: %q", s)
+	}
+	for _, re := range statusPaths {
+		if m := re.FindStringSubmatch(u.Path); m != nil {
+			return "https://" + u.Host, m[1], nil
+		}
+	}
+	return "", "", fmt.Errorf("Not a Mastodon status URL: %q", s)
+}
+
+type account struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name"`
+	Username    string `json:"username"`
+	URL         string `json:"url"`
+	Avatar      string `json:"avatar"`
+}
+
+type status struct {
+	URL       string    `json:"url"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+	Account   account   `json:"account"`
+}
+
+type statusContext struct {
+	Descendants []status `json:"descendants"`
+}
+
+// Mastodon fetches interactions using the Mastodon client API.
+type Mastodon struct {
+	client *http.Client
+
+	// token is an optional access token, needed for instances that don't
+	// allow unauthenticated API access.
+	token string
+}
+
+// NewMastodon returns a new Mastodon.
+func NewMastodon(client *http.Client, token string) *Mastodon {
+	return &Mastodon{
+		client: client,
+		token:  token,
+	}
+}
+
+func (m *Mastodon) get(ctx context.Context, u string, value interface{}) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if m.token != "" {
+		req.Header.Set("Authorization", "Bearer "+m.token)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to request %q: %s", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to request %q: %s", u, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(value); err != nil {
+		return fmt.Errorf("Failed to decode %q: %s", u, err)
+	}
+	return nil
+}
+
+// plainText strips the markup from HTML content, since it comes from
+// untrusted sites.
+func plainText(s string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(s))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(doc.Text())
+}
+
+func authorName(a account) string {
+	if a.DisplayName != "" {
+		return a.DisplayName
+	}
+	return a.Username
+}
+
+// Fetch returns the replies, likes, and reposts of the status at statusURL
+// as mentions of the entry with the given id.
+func (m *Mastodon) Fetch(ctx context.Context, entryID, statusURL string) ([]*mentions.Mention, error) {
+	base, id, err := ParseStatusURL(statusURL)
+	if err != nil {
+		return nil, err
+	}
+	api := fmt.Sprintf("%s/api/v1/statuses/%s", base, id)
+	ret := []*mentions.Mention{}
+
+	var c statusContext
+	if err := m.get(ctx, api+"/context", &c); err != nil {
+		return nil, err
+	}
+	for _, s := range c.Descendants {
+		ret = append(ret, &mentions.Mention{
+			EntryID:     entryID,
+			Source:      s.URL,
+			Type:        mentions.REPLY,
+			AuthorName:  authorName(s.Account),
+			AuthorURL:   s.Account.URL,
+			AuthorPhoto: s.Account.Avatar,
+			Content:     plainText(s.Content),
+			Published:   s.CreatedAt,
+		})
+	}
+
+	for _, t := range []struct {
+		path     string
+		fragment string
+		typ      mentions.Type
+	}{
+		{"/favourited_by", "favourited-by", mentions.LIKE},
+		{"/reblogged_by", "reblogged-by", mentions.REPOST},
+	} {
+		var accounts []account
+		if err := m.get(ctx, api+t.path, &accounts); err != nil {
+			return nil, err
+		}
+		for _, a := range accounts {
+			ret = append(ret, &mentions.Mention{
+				EntryID:     entryID,
+				Source:      fmt.Sprintf("%s#%s-%s", statusURL, t.fragment, a.ID),
+				Type:        t.typ,
+				AuthorName:  authorName(a),
+				AuthorURL:   a.URL,
+				AuthorPhoto: a.Avatar,
+			})
+		}
+	}
+	return ret, nil
+}
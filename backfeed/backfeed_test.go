@@ -0,0 +1,101 @@
+package backfeed
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jcgregorio/stream-run/mentions"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStatusURL(t *testing.T) {
+	base, id, err := ParseStatusURL("https://mastodon.social/@bitworking/1234")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://mastodon.social", base)
+	assert.Equal(t, "1234", id)
+
+	base, id, err = ParseStatusURL("https://example.org/users/bitworking/statuses/5678")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.org", base)
+	assert.Equal(t, "5678", id)
+
+	_, _, err = ParseStatusURL("https://twitter.com/bitworking/status/1234")
+	assert.Error(t, err)
+
+	_, _, err = ParseStatusURL("http://mastodon.social/@bitworking/1234")
+	assert.Error(t, err)
+}
+
+func TestFetch(t *testing.T) {
+	responses := map[string]string{
+		"/api/v1/statuses/1234/context":       `{"ancestors": [], "descendants": [{"url": "https://a.example/@fred/99", "content": "<p>Nice.</p>", "created_at": "2019-06-01T12:00:00.000Z", "account": {"id": "1", "username": "fred", "display_name": "Fred", "url": "https://a.example/@fred", "avatar": "https://a.example/fred.png"}}]}`,
+		"/api/v1/statuses/1234/favourited_by": `[{"id": "2", "username": "barney", "display_name": "", "url": "https://b.example/@barney"}]`,
+		"/api/v1/statuses/1234/reblogged_by":  `[]`,
+	}
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	defer ts.Close()
+
+	m := NewMastodon(ts.Client(), "")
+	statusURL := ts.URL + "/@bitworking/1234"
+	got, err := m.Fetch(context.Background(), "abc", statusURL)
+	assert.NoError(t, err)
+	assert.Len(t, got, 2)
+
+	assert.Equal(t, mentions.REPLY, got[0].Type)
+	assert.Equal(t, "abc", got[0].EntryID)
+	assert.Equal(t, "https://a.example/@fred/99", got[0].Source)
+	assert.Equal(t, "Fred", got[0].AuthorName)
+	assert.Equal(t, "Nice.", got[0].Content)
+
+	assert.Equal(t, mentions.LIKE, got[1].Type)
+	assert.Equal(t, "barney", got[1].AuthorName)
+	assert.Equal(t, statusURL+"#favourited-by-2", got[1].Source)
+}
+
+func TestFeedLinks_Atom(t *testing.T) {
+	body := `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom" xmlns:thr="http://purl.org/syndication/thread/1.0">
+  <title>Notifications</title>
+  <link rel="self" href="https://granary.io/feed"/>
+  <entry>
+    <title>Reply</title>
+    <link rel="alternate" href="https://example.org/@fred/1"/>
+    <thr:in-reply-to ref="https://stream.example.com/entry/abc"/>
+    <content type="html">Nice post &lt;a href="https://stream.example.com/entry/def"&gt;here&lt;/a&gt;</content>
+  </entry>
+  <entry>
+    <title>Unrelated</title>
+    <link href="https://example.org/@fred/2"/>
+    <content type="html">&lt;a href="https://elsewhere.example.net/"&gt;x&lt;/a&gt;</content>
+  </entry>
+</feed>`
+	links := FeedLinks([]byte(body), "https://granary.io/feed", "https://stream.example.com/entry/")
+	assert.Equal(t, []Link{
+		{Source: "https://example.org/@fred/1", Target: "https://stream.example.com/entry/abc"},
+		{Source: "https://example.org/@fred/1", Target: "https://stream.example.com/entry/def"},
+	}, links)
+}
+
+func TestFeedLinks_HFeed(t *testing.T) {
+	body := `<html><body><div class="h-feed">
+  <article class="h-entry">
+    <a class="u-url" href="/likes/1">#</a>
+    <a class="u-like-of" href="https://stream.example.com/entry/abc">liked</a>
+  </article>
+  <article class="h-entry"><p>No URL <a href="https://stream.example.com/entry/abc">link</a></p></article>
+</div></body></html>`
+	links := FeedLinks([]byte(body), "https://bridgy.example.com/feed", "https://stream.example.com/entry/")
+	assert.Equal(t, []Link{
+		{Source: "https://bridgy.example.com/likes/1", Target: "https://stream.example.com/entry/abc"},
+	}, links)
+}
@@ -0,0 +1,91 @@
+package backfeed
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Link is an item in a feed that links to one of our pages.
+type Link struct {
+	// Source is the URL of the item.
+	Source string
+
+	// Target is the URL of our page.
+	Target string
+}
+
+// itemURL returns the URL of an Atom entry or h-entry.
+func itemURL(item *goquery.Selection) string {
+	if u, ok := item.Find(".u-url[href]").First().Attr("href"); ok {
+		return u
+	}
+	ret := ""
+	item.Find("link[href]").EachWithBreak(func(i int, link *goquery.Selection) bool {
+		rel := link.AttrOr("rel", "alternate")
+		if rel == "alternate" {
+			ret = link.AttrOr("href", "")
+			return false
+		}
+		return true
+	})
+	return ret
+}
+
+// FeedLinks returns the items in an Atom or HTML (h-feed) document that link to
+// URLs starting with prefix, such as those pushed by a WebSub hub for a
+// Bridgy or granary feed. Links are found in attributes, like in-reply-to
+// refs, and in escaped HTML content.
+//
+// The items come from another site, so they should be verified like any
+// other webmention before being trusted.
+func FeedLinks(body []byte, base, prefix string) []Link {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return nil
+	}
+	ret := []Link{}
+	seen := map[Link]bool{}
+	doc.Find("entry, .h-entry").Each(func(i int, item *goquery.Selection) {
+		source, err := baseURL.Parse(itemURL(item))
+		if err != nil || source.String() == base || (source.Scheme != "https" && source.Scheme != "http") {
+			return
+		}
+		add := func(target string) {
+			if !strings.HasPrefix(target, prefix) {
+				return
+			}
+			l := Link{Source: source.String(), Target: target}
+			if !seen[l] && l.Source != l.Target {
+				seen[l] = true
+				ret = append(ret, l)
+			}
+		}
+		item.Find("*").Each(func(i int, el *goquery.Selection) {
+			for _, attr := range []string{"href", "ref"} {
+				if v, ok := el.Attr(attr); ok {
+					add(v)
+				}
+			}
+		})
+		// Atom content is escaped HTML, so the links in it are text. Find rather
+		// than children, since the HTML parser nests elements after unknown
+		// self-closing ones like thr:in-reply-to.
+		item.Find("content, summary").Each(func(i int, content *goquery.Selection) {
+			inner, err := goquery.NewDocumentFromReader(strings.NewReader(content.Text()))
+			if err != nil {
+				return
+			}
+			inner.Find("a[href]").Each(func(i int, a *goquery.Selection) {
+				add(a.AttrOr("href", ""))
+			})
+		})
+	})
+	return ret
+}
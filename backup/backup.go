@@ -0,0 +1,107 @@
+// Package backup exports and restores the stored data as newline delimited
+// JSON.
+package backup
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/jcgregorio/stream-run/entries"
+	"github.com/jcgregorio/stream-run/mentions"
+)
+
+// Kinds of Records.
+const (
+	ENTRY   = "entry"
+	MENTION = "mention"
+)
+
+// Record is a single line of a backup. Exactly one of the values is set,
+// determined by Kind.
+type Record struct {
+	Kind    string            `json:"kind"`
+	Entry   *entries.Entry    `json:"entry,omitempty"`
+	Mention *mentions.Mention `json:"mention,omitempty"`
+}
+
+// Entries are the entries in a Store, such as entries.Entries.
+type Entries interface {
+	All(ctx context.Context, f func(*entries.Entry) error) error
+	Restore(ctx context.Context, entry *entries.Entry) error
+}
+
+// Mentions are the mentions in a Store, such as mentions.Mentions.
+type Mentions interface {
+	All(ctx context.Context, f func(*mentions.Mention) error) error
+	Restore(ctx context.Context, mention *mentions.Mention) error
+}
+
+// Store is where records are read from and restored to.
+type Store struct {
+	Entries  Entries
+	Mentions Mentions
+}
+
+// Write streams every record in the store to w.
+func (s *Store) Write(ctx context.Context, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	if err := s.Entries.All(ctx, func(e *entries.Entry) error {
+		return enc.Encode(&Record{Kind: ENTRY, Entry: e})
+	}); err != nil {
+		return err
+	}
+	return s.Mentions.All(ctx, func(m *mentions.Mention) error {
+		return enc.Encode(&Record{Kind: MENTION, Mention: m})
+	})
+}
+
+// Restore reads records from r and writes them to the store, returning the
+// number restored. Records keep their IDs so restoring is idempotent.
+func (s *Store) Restore(ctx context.Context, r io.Reader) (int, error) {
+	n := 0
+	err := Read(r, func(rec *Record) error {
+		var err error
+		switch rec.Kind {
+		case ENTRY:
+			err = s.Entries.Restore(ctx, rec.Entry)
+		case MENTION:
+			err = s.Mentions.Restore(ctx, rec.Mention)
+		}
+		if err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// Read calls f for each record in r, stopping at the first error.
+func Read(r io.Reader, f func(*Record) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("Line %d: %s", line, err)
+		}
+		switch {
+		case rec.Kind == ENTRY && rec.Entry != nil:
+		case rec.Kind == MENTION && rec.Mention != nil:
+		default:
+			return fmt.Errorf("Line %d: invalid record of kind %q", line, rec.Kind)
+		}
+		if err := f(&rec); err != nil {
+			return fmt.Errorf("Line %d: %s", line, err)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,32 @@
+package backup
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRead(t *testing.T) {
+	in := `{"kind": "entry", "entry": {"ID": "abc", "Title": "A title", "Content": "Content."}}
+
+{"kind": "mention", "mention": {"ID": "def", "EntryID": "abc", "Type": "like"}}
+`
+	got := []*Record{}
+	err := Read(strings.NewReader(in), func(rec *Record) error {
+		got = append(got, rec)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, got, 2)
+	assert.Equal(t, "abc", got[0].Entry.ID)
+	assert.Equal(t, "A title", got[0].Entry.Title)
+	assert.Equal(t, "abc", got[1].Mention.EntryID)
+}
+
+func TestRead_Invalid(t *testing.T) {
+	noop := func(rec *Record) error { return nil }
+	assert.Error(t, Read(strings.NewReader(`{"kind": "entry"}`), noop))
+	assert.Error(t, Read(strings.NewReader(`{"kind": "unknown", "entry": {}}`), noop))
+	assert.Error(t, Read(strings.NewReader(`not json`), noop))
+}
@@ -0,0 +1,49 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// ToGCS writes a backup to a new object in the bucket named with the prefix
+// and the current time, and returns the object name.
+func (s *Store) ToGCS(ctx context.Context, bucket *storage.BucketHandle, prefix string) (string, error) {
+	name := fmt.Sprintf("%s%s.json", prefix, time.Now().UTC().Format("2006-01-02T15-04-05Z"))
+	w := bucket.Object(name).NewWriter(ctx)
+	w.ContentType = "application/x-ndjson"
+	if err := s.Write(ctx, w); err != nil {
+		w.Close()
+		return "", fmt.Errorf("Failed to write backup %q: %s", name, err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("Failed to write backup %q: %s", name, err)
+	}
+	return name, nil
+}
+
+// Prune deletes backups in the bucket with the given prefix that were
+// created before the given time, and returns how many were deleted.
+func Prune(ctx context.Context, bucket *storage.BucketHandle, prefix string, before time.Time) (int, error) {
+	n := 0
+	it := bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return n, nil
+		}
+		if err != nil {
+			return n, fmt.Errorf("Failed to list backups: %s", err)
+		}
+		if !attrs.Created.Before(before) {
+			continue
+		}
+		if err := bucket.Object(attrs.Name).Delete(ctx); err != nil {
+			return n, fmt.Errorf("Failed to delete backup %q: %s", attrs.Name, err)
+		}
+		n++
+	}
+}
@@ -0,0 +1,220 @@
+// Package blocklist rejects interactions from blocked domains and actors.
+//
+// Patterns are one of:
+//
+//	example.com                       Blocks the host example.com.
+//	*.example.com                     Blocks example.com and all its subdomains.
+//	@user@example.com                 Blocks the Mastodon-style actor.
+//	https://example.com/users/fred    Blocks all URLs with this prefix.
+package blocklist
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+
+	"github.com/jcgregorio/go-lib/ds"
+	"github.com/jcgregorio/slog"
+)
+
+const (
+	BLOCK ds.Kind = "Block"
+)
+
+// Block is a pattern added through the admin interface.
+type Block struct {
+	Pattern string    `datastore:"-"`
+	Reason  string    `datastore:"reason,noindex"`
+	Created time.Time `datastore:"created"`
+}
+
+// Matcher matches URLs against a fixed set of patterns.
+type Matcher struct {
+	hosts    map[string]bool
+	suffixes []string
+	prefixes []string
+}
+
+// NewMatcher returns a Matcher for the given patterns. Empty patterns are
+// ignored.
+func NewMatcher(patterns []string) *Matcher {
+	m := &Matcher{
+		hosts: map[string]bool{},
+	}
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		switch {
+		case p == "":
+		case strings.HasPrefix(p, "*."):
+			m.suffixes = append(m.suffixes, p[1:])
+			m.hosts[p[2:]] = true
+		case strings.HasPrefix(p, "@"):
+			parts := strings.SplitN(p[1:], "@", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			m.prefixes = append(m.prefixes, fmt.Sprintf("https://%s/@%s", parts[1], parts[0]), fmt.Sprintf("https://%s/users/%s", parts[1], parts[0]))
+		case strings.Contains(p, "://"):
+			m.prefixes = append(m.prefixes, p)
+		default:
+			m.hosts[p] = true
+		}
+	}
+	return m
+}
+
+// Blocked returns true if the URL u matches any of the patterns.
+func (m *Matcher) Blocked(u string) bool {
+	if u == "" {
+		return false
+	}
+	u = strings.ToLower(u)
+	for _, prefix := range m.prefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if u == prefix || strings.HasPrefix(u, prefix+"/") {
+			return true
+		}
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	host := parsed.Hostname()
+	if m.hosts[host] {
+		return true
+	}
+	for _, suffix := range m.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Blocklist combines patterns from config with those stored in Datastore.
+type Blocklist struct {
+	DS  *ds.DS
+	log slog.Logger
+
+	mutex   sync.Mutex
+	config  []string
+	matcher *Matcher
+}
+
+func New(ctx context.Context, project, ns string, config []string, log slog.Logger) (*Blocklist, error) {
+	d, err := ds.New(ctx, project, ns)
+	if err != nil {
+		return nil, err
+	}
+	b := &Blocklist{
+		DS:      d,
+		log:     log,
+		config:  config,
+		matcher: NewMatcher(config),
+	}
+	if err := b.Reload(ctx); err != nil {
+		log.Warningf("Failed to load blocklist: %s", err)
+	}
+	return b, nil
+}
+
+func (b *Blocklist) key(pattern string) *datastore.Key {
+	key := b.DS.NewKey(BLOCK)
+	key.Name = pattern
+	return key
+}
+
+// Blocked returns true if any of the URLs are blocked.
+func (b *Blocklist) Blocked(urls ...string) bool {
+	b.mutex.Lock()
+	m := b.matcher
+	b.mutex.Unlock()
+	for _, u := range urls {
+		if m.Blocked(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetConfig replaces the patterns that come from config, such as when
+// config.json is changed.
+func (b *Blocklist) SetConfig(ctx context.Context, config []string) error {
+	b.mutex.Lock()
+	b.config = config
+	b.mutex.Unlock()
+	return b.Reload(ctx)
+}
+
+// Reload rebuilds the matcher from config and Datastore.
+func (b *Blocklist) Reload(ctx context.Context) error {
+	blocks, err := b.List(ctx)
+	if err != nil {
+		return err
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	patterns := append([]string{}, b.config...)
+	for _, block := range blocks {
+		patterns = append(patterns, block.Pattern)
+	}
+	b.matcher = NewMatcher(patterns)
+	return nil
+}
+
+// Add stores a new pattern.
+func (b *Blocklist) Add(ctx context.Context, pattern, reason string) error {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if pattern == "" {
+		return fmt.Errorf("Pattern must not be empty.")
+	}
+	block := &Block{
+		Reason:  reason,
+		Created: time.Now(),
+	}
+	if _, err := b.DS.Client.Put(ctx, b.key(pattern), block); err != nil {
+		return fmt.Errorf("Failed to add block: %s", err)
+	}
+	return b.Reload(ctx)
+}
+
+// Remove deletes a stored pattern. Patterns from config can't be removed.
+func (b *Blocklist) Remove(ctx context.Context, pattern string) error {
+	if err := b.DS.Client.Delete(ctx, b.key(pattern)); err != nil {
+		return fmt.Errorf("Failed to remove block: %s", err)
+	}
+	return b.Reload(ctx)
+}
+
+// List returns the stored patterns, newest first.
+func (b *Blocklist) List(ctx context.Context) ([]*Block, error) {
+	ret := []*Block{}
+	q := b.DS.NewQuery(BLOCK).Order("-created")
+	it := b.DS.Client.Run(ctx, q)
+	for {
+		block := &Block{}
+		key, err := it.Next(block)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed while reading blocks: %s", err)
+		}
+		block.Pattern = key.Name
+		ret = append(ret, block)
+	}
+	return ret, nil
+}
+
+// Config returns the patterns that come from config.
+func (b *Blocklist) Config() []string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.config
+}
@@ -0,0 +1,38 @@
+package blocklist
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatcher_Host(t *testing.T) {
+	m := NewMatcher([]string{"Spam.example", ""})
+	assert.True(t, m.Blocked("https://spam.example/post/1"))
+	assert.False(t, m.Blocked("https://www.spam.example/post/1"))
+	assert.False(t, m.Blocked("https://example.org/spam.example"))
+	assert.False(t, m.Blocked(""))
+}
+
+func TestMatcher_Wildcard(t *testing.T) {
+	m := NewMatcher([]string{"*.spam.example"})
+	assert.True(t, m.Blocked("https://spam.example/"))
+	assert.True(t, m.Blocked("https://a.b.spam.example/"))
+	assert.False(t, m.Blocked("https://notspam.example/"))
+}
+
+func TestMatcher_Actor(t *testing.T) {
+	m := NewMatcher([]string{"@troll@mastodon.example"})
+	assert.True(t, m.Blocked("https://mastodon.example/@troll"))
+	assert.True(t, m.Blocked("https://mastodon.example/@troll/1234"))
+	assert.True(t, m.Blocked("https://mastodon.example/users/troll"))
+	assert.False(t, m.Blocked("https://mastodon.example/@trollhunter"))
+	assert.False(t, m.Blocked("https://mastodon.example/@fred"))
+}
+
+func TestMatcher_Prefix(t *testing.T) {
+	m := NewMatcher([]string{"https://example.org/users/fred/"})
+	assert.True(t, m.Blocked("https://example.org/users/fred"))
+	assert.True(t, m.Blocked("https://example.org/users/fred/statuses/1"))
+	assert.False(t, m.Blocked("https://example.org/users/freddie"))
+}
@@ -0,0 +1,99 @@
+// Package bridges decides where links to syndication bridges, such as Bridgy
+// Publish, are added to entries and renders them.
+//
+// Bridges are told about an entry by a webmention, and then check that the
+// entry links to them, so the links must at least appear on the permalink
+// for syndication to work.
+package bridges
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+)
+
+// Context is a place where entries are rendered.
+type Context string
+
+const (
+	INDEX     Context = "index"
+	PERMALINK Context = "permalink"
+	FEED      Context = "feed"
+
+	// NONE disables bridge links everywhere when it is the only context.
+	NONE Context = "none"
+)
+
+// Link is the data the link text template is executed with.
+type Link struct {
+	// URL is the bridge's URL.
+	URL string
+
+	// Host is the bridge's hostname, e.g. "brid.gy".
+	Host string
+}
+
+// Rules are where bridge links are added, and the text they have.
+type Rules struct {
+	contexts map[Context]bool
+
+	// text is nil if the links are empty anchors.
+	text *template.Template
+}
+
+// New returns Rules that add links in the given contexts, or everywhere if
+// there are none, with text from the html/template text, or no text if it's
+// empty.
+func New(contexts []string, text string) (*Rules, error) {
+	ret := &Rules{
+		contexts: map[Context]bool{},
+	}
+	if len(contexts) == 0 {
+		contexts = []string{string(INDEX), string(PERMALINK), string(FEED)}
+	}
+	for _, s := range contexts {
+		switch c := Context(strings.ToLower(strings.TrimSpace(s))); c {
+		case INDEX, PERMALINK, FEED:
+			ret.contexts[c] = true
+		case NONE:
+		default:
+			return nil, fmt.Errorf("Unknown bridge context %q.", s)
+		}
+	}
+	if text != "" {
+		t, err := template.New("bridge").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse bridge link text: %s", err)
+		}
+		ret.text = t
+	}
+	return ret, nil
+}
+
+// In returns true if bridge links are added in the given context.
+func (r *Rules) In(c Context) bool {
+	return r.contexts[c]
+}
+
+// Links returns the HTML for links to each of the bridges.
+func (r *Rules) Links(bridges []string) (string, error) {
+	ret := []string{}
+	for _, href := range bridges {
+		text := ""
+		if r.text != nil {
+			link := Link{URL: href}
+			if u, err := url.Parse(href); err == nil {
+				link.Host = u.Hostname()
+			}
+			var b bytes.Buffer
+			if err := r.text.Execute(&b, link); err != nil {
+				return "", fmt.Errorf("Failed to render bridge link text for %q: %s", href, err)
+			}
+			text = b.String()
+		}
+		ret = append(ret, fmt.Sprintf("<a href='%s'>%s</a>", template.HTMLEscapeString(href), text))
+	}
+	return strings.Join(ret, " "), nil
+}
@@ -0,0 +1,46 @@
+package bridges
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew_DefaultsToAllContexts(t *testing.T) {
+	r, err := New(nil, "")
+	assert.NoError(t, err)
+	assert.True(t, r.In(INDEX))
+	assert.True(t, r.In(PERMALINK))
+	assert.True(t, r.In(FEED))
+}
+
+func TestNew_None(t *testing.T) {
+	r, err := New([]string{"none"}, "")
+	assert.NoError(t, err)
+	assert.False(t, r.In(INDEX))
+	assert.False(t, r.In(PERMALINK))
+	assert.False(t, r.In(FEED))
+}
+
+func TestNew_UnknownContext(t *testing.T) {
+	_, err := New([]string{"permalink", "sidebar"}, "")
+	assert.Error(t, err)
+}
+
+func TestLinks_Empty(t *testing.T) {
+	r, err := New([]string{"Permalink"}, "")
+	assert.NoError(t, err)
+	assert.True(t, r.In(PERMALINK))
+	assert.False(t, r.In(FEED))
+	s, err := r.Links([]string{"https://brid.gy/publish/twitter", "https://fed.brid.gy/"})
+	assert.NoError(t, err)
+	assert.Equal(t, "<a href='https://brid.gy/publish/twitter'></a> <a href='https://fed.brid.gy/'></a>", s)
+}
+
+func TestLinks_Text(t *testing.T) {
+	r, err := New(nil, "via {{.Host}}")
+	assert.NoError(t, err)
+	s, err := r.Links([]string{"https://fed.brid.gy/"})
+	assert.NoError(t, err)
+	assert.Equal(t, "<a href='https://fed.brid.gy/'>via fed.brid.gy</a>", s)
+}
@@ -0,0 +1,130 @@
+// Package cachecontrol is the caching policy for each kind of response, and
+// versions static files so they can be cached forever.
+package cachecontrol
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Policy is a Cache-Control header value.
+type Policy string
+
+const (
+	// IMMUTABLE is for responses whose URL changes when their content does,
+	// such as versioned static files.
+	IMMUTABLE Policy = "public, max-age=31536000, immutable"
+
+	// MEDIA is for images and other media, which rarely change.
+	MEDIA Policy = "public, max-age=2592000"
+
+	// STATIC is for unversioned static files, which may change on deploy.
+	STATIC Policy = "public, max-age=3600"
+
+	// PRIVATE is for responses that depend on who is asking, such as pages
+	// seen by a signed in admin.
+	PRIVATE Policy = "private, no-cache"
+
+	// NO_STORE is for responses no cache may keep, such as private entries
+	// seen through a capability URL.
+	NO_STORE Policy = "private, no-store"
+
+	// NO_CACHE is for responses that must be revalidated every time, such as
+	// the service worker.
+	NO_CACHE Policy = "no-cache"
+)
+
+// Shared returns the policy for HTML pages and feeds, which browsers
+// revalidate every time, but shared caches, like a CDN, keep for sMaxAge
+// seconds and then serve stale for up to staleWhileRevalidate seconds while
+// fetching a fresh copy.
+func Shared(sMaxAge, staleWhileRevalidate int) Policy {
+	return Policy(fmt.Sprintf("public, max-age=0, s-maxage=%d, stale-while-revalidate=%d", sMaxAge, staleWhileRevalidate))
+}
+
+// Set sets the Cache-Control header of the response.
+func Set(w http.ResponseWriter, p Policy) {
+	w.Header().Set("Cache-Control", string(p))
+}
+
+// Middleware wraps h so its responses have the policy. Handlers can still
+// set their own, e.g. for errors or private content.
+func Middleware(p Policy, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Set(w, p)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// VERSION_PARAM is the query parameter that holds a file's version.
+const VERSION_PARAM = "v"
+
+// Versions computes the versions of files served from a directory, which are
+// the hashes of their contents.
+type Versions struct {
+	dir string
+
+	// cache is false when files may change while running, e.g. locally.
+	cache bool
+
+	mutex  sync.Mutex
+	hashes map[string]string
+}
+
+// NewVersions returns Versions for the files in dir. If cache is false the
+// files are hashed on every request, so edits show up without a restart.
+func NewVersions(dir string, cache bool) *Versions {
+	return &Versions{
+		dir:    dir,
+		cache:  cache,
+		hashes: map[string]string{},
+	}
+}
+
+// Version returns the version of the file at name, relative to the
+// directory, or "" if it can't be read.
+func (v *Versions) Version(name string) string {
+	name = filepath.Clean("/" + name)
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if h, ok := v.hashes[name]; ok && v.cache {
+		return h
+	}
+	b, err := os.ReadFile(filepath.Join(v.dir, filepath.FromSlash(name)))
+	if err != nil {
+		return ""
+	}
+	h := fmt.Sprintf("%x", sha256.Sum256(b))[:12]
+	v.hashes[name] = h
+	return h
+}
+
+// URL returns path, where the file at name is served from prefix, with its
+// version appended, so it can be cached forever. For example
+// URL("/images/", "/images/icon.png") returns "/images/icon.png?v=1a2b3c4d5e6f".
+func (v *Versions) URL(prefix, path string) string {
+	version := v.Version(strings.TrimPrefix(path, prefix))
+	if version == "" {
+		return path
+	}
+	return path + "?" + VERSION_PARAM + "=" + version
+}
+
+// Middleware wraps h, which serves the directory with paths relative to it,
+// so that requests for the current version of a file are IMMUTABLE and
+// everything else gets the fallback policy.
+func (v *Versions) Middleware(fallback Policy, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := fallback
+		if version := r.URL.Query().Get(VERSION_PARAM); version != "" && version == v.Version(r.URL.Path) {
+			p = IMMUTABLE
+		}
+		Set(w, p)
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,56 @@
+package cachecontrol
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShared(t *testing.T) {
+	assert.Equal(t, Policy("public, max-age=0, s-maxage=60, stale-while-revalidate=3600"), Shared(60, 3600))
+}
+
+func TestVersions(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "icon.png"), []byte("one"), 0644))
+
+	v := NewVersions(dir, false)
+	first := v.Version("icon.png")
+	assert.Len(t, first, 12)
+	assert.Equal(t, "/images/icon.png?v="+first, v.URL("/images/", "/images/icon.png"))
+	assert.Equal(t, "/images/missing.png", v.URL("/images/", "/images/missing.png"))
+	assert.Equal(t, "", v.Version("../../etc/passwd"))
+
+	// Uncached versions follow edits.
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "icon.png"), []byte("two"), 0644))
+	assert.NotEqual(t, first, v.Version("icon.png"))
+
+	h := v.Middleware(STATIC, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	get := func(path string) string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Header().Get("Cache-Control")
+	}
+	assert.Equal(t, string(IMMUTABLE), get("/icon.png?v="+v.Version("icon.png")))
+	assert.Equal(t, string(STATIC), get("/icon.png?v="+first))
+	assert.Equal(t, string(STATIC), get("/icon.png"))
+}
+
+func TestMiddleware(t *testing.T) {
+	h := Middleware(MEDIA, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/private" {
+			Set(w, PRIVATE)
+		}
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, string(MEDIA), w.Header().Get("Cache-Control"))
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/private", nil))
+	assert.Equal(t, string(PRIVATE), w.Header().Get("Cache-Control"))
+}
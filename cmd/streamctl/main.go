@@ -0,0 +1,226 @@
+// streamctl administers a stream from the command line using the JSON API.
+//
+// The server and an admin scoped token, created at /admin/tokens, are given
+// by the STREAM_SERVER and STREAM_TOKEN environment variables or the
+// -server and -token flags.
+//
+//	echo "Hello world" | streamctl post
+//	streamctl list -n 5
+//	streamctl delete <id>
+//	streamctl webmentions <id>
+//	streamctl backup
+//	streamctl mentions -f
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// flags
+var (
+	server = flag.String("server", os.Getenv("STREAM_SERVER"), "The URL of the stream, e.g. https://stream.example.com.")
+	token  = flag.String("token", os.Getenv("STREAM_TOKEN"), "An admin scoped API token.")
+)
+
+const usage = `Usage: streamctl [-server URL] [-token TOKEN] <command> [args]
+
+Commands:
+  post [-title TITLE] [-visibility public|unlisted|private]
+                     Post a note with the content read from stdin.
+  list [-n N] [-offset N]
+                     List recent entries, including private ones.
+  delete ID          Delete an entry.
+  webmentions ID     Resend the webmentions for an entry.
+  backup             Back up to the configured bucket now.
+  mentions [-f] [-since DURATION]
+                     Print recent mentions, and with -f keep printing new ones.
+`
+
+type entry struct {
+	ID         string    `json:"id"`
+	URL        string    `json:"url"`
+	Title      string    `json:"title"`
+	Content    string    `json:"content"`
+	Visibility string    `json:"visibility"`
+	Created    time.Time `json:"created"`
+}
+
+type mention struct {
+	EntryID    string
+	Source     string
+	Type       string
+	AuthorName string
+	Content    string
+	Created    time.Time
+}
+
+// do sends a request to the API and returns the body of a successful
+// response.
+func do(method, path string, body io.Reader, contentType string) ([]byte, error) {
+	req, err := http.NewRequest(method, strings.TrimSuffix(*server, "/")+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+*token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s failed: %s: %s", method, path, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return b, nil
+}
+
+func post(args []string) error {
+	fs := flag.NewFlagSet("post", flag.ExitOnError)
+	title := fs.String("title", "", "The title of the entry.")
+	visibility := fs.String("visibility", "public", "One of public, unlisted, or private.")
+	fs.Parse(args)
+	content, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(content)) == "" {
+		return fmt.Errorf("No content on stdin.")
+	}
+	body, err := json.Marshal(map[string]string{
+		"title":      *title,
+		"content":    string(content),
+		"visibility": *visibility,
+	})
+	if err != nil {
+		return err
+	}
+	b, err := do("POST", "/api/quick", strings.NewReader(string(body)), "application/json")
+	if err != nil {
+		return err
+	}
+	fmt.Print(string(b))
+	return nil
+}
+
+func list(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	n := fs.Int("n", 20, "The number of entries.")
+	offset := fs.Int("offset", 0, "The number of entries to skip.")
+	fs.Parse(args)
+	b, err := do("GET", fmt.Sprintf("/api/entries?n=%d&offset=%d", *n, *offset), nil, "")
+	if err != nil {
+		return err
+	}
+	var entries []*entry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		title := e.Title
+		if title == "" {
+			title = strings.Join(strings.Fields(e.Content), " ")
+		}
+		if len(title) > 60 {
+			title = title[:60] + "…"
+		}
+		fmt.Printf("%s  %s  %-8s  %s\n", e.ID, e.Created.Local().Format("2006-01-02 15:04"), e.Visibility, title)
+	}
+	return nil
+}
+
+// withID runs a command that takes a single entry id.
+func withID(method, format string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("An entry id must be given.")
+	}
+	_, err := do(method, fmt.Sprintf(format, url.PathEscape(args[0])), nil, "")
+	return err
+}
+
+func printMentions(since time.Time) (time.Time, error) {
+	b, err := do("GET", "/api/mentions?since="+url.QueryEscape(since.Format(time.RFC3339Nano)), nil, "")
+	if err != nil {
+		return since, err
+	}
+	var mentions []*mention
+	if err := json.Unmarshal(b, &mentions); err != nil {
+		return since, err
+	}
+	for _, m := range mentions {
+		fmt.Printf("%s  %s from %s on %s\n  %s\n", m.Created.Local().Format("2006-01-02 15:04"), m.Type, m.AuthorName, m.EntryID, m.Source)
+		if m.Content != "" {
+			fmt.Printf("  %s\n", strings.Join(strings.Fields(m.Content), " "))
+		}
+		since = m.Created
+	}
+	return since, nil
+}
+
+func mentions(args []string) error {
+	fs := flag.NewFlagSet("mentions", flag.ExitOnError)
+	follow := fs.Bool("f", false, "Keep printing new mentions.")
+	ago := fs.Duration("since", 24*time.Hour, "How far back to start.")
+	fs.Parse(args)
+	since := time.Now().Add(-*ago)
+	for {
+		var err error
+		since, err = printMentions(since)
+		if err != nil {
+			return err
+		}
+		if !*follow {
+			return nil
+		}
+		time.Sleep(30 * time.Second)
+	}
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	flag.Parse()
+	if *server == "" || *token == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	args := flag.Args()[1:]
+	var err error
+	switch flag.Arg(0) {
+	case "post":
+		err = post(args)
+	case "list":
+		err = list(args)
+	case "delete":
+		err = withID("DELETE", "/api/entries/%s", args)
+	case "webmentions":
+		err = withID("POST", "/api/entries/%s/webmentions", args)
+	case "backup":
+		_, err = do("POST", "/api/backup", nil, "")
+	case "mentions":
+		err = mentions(args)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,118 @@
+// Package configcheck collects problems with config values so they can all be
+// reported at once on startup.
+package configcheck
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Checker accumulates problems found by its checks.
+type Checker struct {
+	problems []string
+}
+
+func (c *Checker) addf(format string, args ...interface{}) {
+	c.problems = append(c.problems, fmt.Sprintf(format, args...))
+}
+
+// Required checks that value isn't empty.
+func (c *Checker) Required(key, value string) {
+	if strings.TrimSpace(value) == "" {
+		c.addf("%s is required.", key)
+	}
+}
+
+func validURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("scheme must be http or https")
+	}
+	if u.Host == "" {
+		return fmt.Errorf("missing host")
+	}
+	return nil
+}
+
+// URL checks that value is an absolute http or https URL.
+func (c *Checker) URL(key, value string) {
+	if value == "" {
+		c.addf("%s is required.", key)
+		return
+	}
+	if err := validURL(value); err != nil {
+		c.addf("%s %q is not a valid URL: %s", key, value, err)
+	}
+}
+
+// OptionalURL is like URL but allows value to be empty.
+func (c *Checker) OptionalURL(key, value string) {
+	if value != "" {
+		c.URL(key, value)
+	}
+}
+
+// NonEmpty checks that values has at least one non-empty member.
+func (c *Checker) NonEmpty(key string, values []string) {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return
+		}
+	}
+	c.addf("%s must have at least one value.", key)
+}
+
+// URLs checks that every member of values is an absolute http or https URL.
+func (c *Checker) URLs(key string, values []string) {
+	for i, v := range values {
+		if err := validURL(v); err != nil {
+			c.addf("%s[%d] %q is not a valid URL: %s", key, i, v, err)
+		}
+	}
+}
+
+// Paths checks that every member of values is an absolute path.
+func (c *Checker) Paths(key string, values []string) {
+	for i, v := range values {
+		if !strings.HasPrefix(v, "/") {
+			c.addf("%s[%d] %q must start with /.", key, i, v)
+		}
+	}
+}
+
+// Location checks that value, if not empty, is a timezone name.
+func (c *Checker) Location(key, value string) {
+	if value == "" {
+		return
+	}
+	if _, err := time.LoadLocation(value); err != nil {
+		c.addf("%s %q is not a known timezone: %s", key, value, err)
+	}
+}
+
+// Valid records err, from parsing the value of key elsewhere, if it isn't
+// nil.
+func (c *Checker) Valid(key string, err error) {
+	if err != nil {
+		c.addf("%s is not valid: %s", key, err)
+	}
+}
+
+// Problems returns all the problems found.
+func (c *Checker) Problems() []string {
+	return c.problems
+}
+
+// Err returns an error listing all the problems found, or nil if there were
+// none.
+func (c *Checker) Err() error {
+	if len(c.problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("Found %d problem(s) with config:\n  %s", len(c.problems), strings.Join(c.problems, "\n  "))
+}
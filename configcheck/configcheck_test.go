@@ -0,0 +1,50 @@
+package configcheck
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecker_Valid(t *testing.T) {
+	c := &Checker{}
+	c.Required("PROJECT", "my-project")
+	c.URL("HOST", "https://example.org")
+	c.OptionalURL("WEBSUB", "")
+	c.NonEmpty("ADMINS", []string{"fred@example.org"})
+	c.URLs("BRIDGES", []string{"https://brid.gy/publish/twitter"})
+	c.Paths("BRIDGE_PATHS", []string{"/.well-known/webfinger"})
+	c.Location("TIMEZONE", "America/New_York")
+	c.Valid("BRIDGE_CONTEXTS", nil)
+	assert.NoError(t, c.Err())
+	assert.Len(t, c.Problems(), 0)
+}
+
+func TestChecker_Invalid(t *testing.T) {
+	c := &Checker{}
+	c.Required("PROJECT", " ")
+	c.URL("HOST", "example.org")
+	c.URL("WEBSUB", "")
+	c.NonEmpty("ADMINS", []string{""})
+	c.URLs("BRIDGES", []string{"https://brid.gy/", "ftp://example.org", "https://"})
+	c.Paths("BRIDGE_PATHS", []string{"well-known"})
+	c.Location("TIMEZONE", "Mars/Olympus_Mons")
+	assert.Equal(t, []string{
+		"PROJECT is required.",
+		`HOST "example.org" is not a valid URL: scheme must be http or https`,
+		"WEBSUB is required.",
+		"ADMINS must have at least one value.",
+		`BRIDGES[1] "ftp://example.org" is not a valid URL: scheme must be http or https`,
+		`BRIDGES[2] "https://" is not a valid URL: missing host`,
+		`BRIDGE_PATHS[0] "well-known" must start with /.`,
+	}, c.Problems()[:7])
+	assert.Len(t, c.Problems(), 8)
+	assert.Contains(t, c.Err().Error(), "Found 8 problem(s) with config:\n  PROJECT is required.\n  HOST")
+}
+
+func TestChecker_ValidRecordsError(t *testing.T) {
+	c := &Checker{}
+	c.Valid("BRIDGE_CONTEXTS", fmt.Errorf("Unknown bridge context \"sidebar\"."))
+	assert.Equal(t, []string{`BRIDGE_CONTEXTS is not valid: Unknown bridge context "sidebar".`}, c.Problems())
+}
@@ -0,0 +1,112 @@
+// Package discovery advertises the endpoints other sites find through a page,
+// such as where to send webmentions and the WebSub hub of its feed, as both
+// HTTP Link headers and <link> elements, so templates don't have to.
+package discovery
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+)
+
+// Link is an endpoint advertised with a rel, e.g. "webmention" or "hub".
+type Link struct {
+	Rel  string
+	Href string
+}
+
+// Header returns the Link header value of the link.
+func (l Link) Header() string {
+	return fmt.Sprintf(`<%s>; rel="%s"`, l.Href, l.Rel)
+}
+
+// Element returns the <link> element of the link.
+func (l Link) Element() string {
+	return fmt.Sprintf(`<link rel="%s" href="%s">`, html.EscapeString(l.Rel), html.EscapeString(l.Href))
+}
+
+// head is where the <link> elements are inserted into HTML pages.
+var head = []byte("</head>")
+
+// writer holds back an HTML body so the <link> elements can be added to its
+// head, and passes anything else straight through.
+type writer struct {
+	http.ResponseWriter
+	links []Link
+
+	// html is true if the response is HTML, which is decided on the first
+	// call to WriteHeader or Write.
+	html        bool
+	wroteHeader bool
+	status      int
+	body        bytes.Buffer
+}
+
+func (w *writer) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+	w.html = status == http.StatusOK && strings.HasPrefix(w.Header().Get("Content-Type"), "text/html")
+	if !w.html {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	// The body is about to change length.
+	w.Header().Del("Content-Length")
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.html {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.body.Write(b)
+}
+
+// flush writes the held back HTML body with the <link> elements added.
+func (w *writer) flush() {
+	if !w.html {
+		return
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	body := w.body.Bytes()
+	i := bytes.Index(body, head)
+	if i == -1 {
+		w.ResponseWriter.Write(body)
+		return
+	}
+	var elements bytes.Buffer
+	for _, l := range w.links {
+		elements.WriteString("  " + l.Element() + "\n")
+	}
+	w.ResponseWriter.Write(body[:i])
+	w.ResponseWriter.Write(elements.Bytes())
+	w.ResponseWriter.Write(body[i:])
+}
+
+// Middleware wraps h so its responses advertise the links returned by
+// links, as Link headers, and as <link> elements in the head of HTML pages.
+func Middleware(links func(r *http.Request) []Link, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		list := links(r)
+		if len(list) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		for _, l := range list {
+			w.Header().Add("Link", l.Header())
+		}
+		dw := &writer{ResponseWriter: w, links: list}
+		h.ServeHTTP(dw, r)
+		dw.flush()
+	})
+}
@@ -0,0 +1,65 @@
+package discovery
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var links = func(r *http.Request) []Link {
+	return []Link{
+		{Rel: "webmention", Href: "https://example.org/webmention"},
+		{Rel: "hub", Href: "https://hub.example.com/?a=1&b=2"},
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	h := Middleware(links, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, "<html><head>\n  <title>Hi</title>\n")
+		fmt.Fprint(w, "</head><body></body></html>")
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, []string{
+		`<https://example.org/webmention>; rel="webmention"`,
+		`<https://hub.example.com/?a=1&b=2>; rel="hub"`,
+	}, w.Header().Values("Link"))
+	assert.Equal(t, `<html><head>
+  <title>Hi</title>
+  <link rel="webmention" href="https://example.org/webmention">
+  <link rel="hub" href="https://hub.example.com/?a=1&amp;b=2">
+</head><body></body></html>`, w.Body.String())
+}
+
+func TestMiddleware_NotHTML(t *testing.T) {
+	h := Middleware(links, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, "{}</head>")
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Len(t, w.Header().Values("Link"), 2)
+	assert.Equal(t, "{}</head>", w.Body.String())
+
+	h = Middleware(links, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Not found.", http.StatusNotFound)
+	}))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "Not found.\n", w.Body.String())
+}
+
+func TestMiddleware_NoLinks(t *testing.T) {
+	h := Middleware(func(r *http.Request) []Link { return nil }, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html><head></head></html>")
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Empty(t, w.Header().Values("Link"))
+	assert.Equal(t, "<html><head></head></html>", w.Body.String())
+}
@@ -0,0 +1,137 @@
+// Package emailreply creates per-entry reply addresses and verifies the
+// inbound mail webhooks that deliver replies sent to them.
+package emailreply
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PREFIX starts the local part of every reply address.
+const PREFIX = "reply+"
+
+// SIGNATURE_LENGTH is the number of hex digits of the signature kept in an
+// address, enough to stop guessing while keeping addresses short.
+const SIGNATURE_LENGTH = 16
+
+func sign(entryID, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(entryID))
+	return hex.EncodeToString(mac.Sum(nil))[:SIGNATURE_LENGTH]
+}
+
+// Address returns the address that replies to the given entry are sent to,
+// for example reply+<id>.<signature>@domain.
+func Address(entryID, secret, domain string) string {
+	return fmt.Sprintf("%s%s.%s@%s", PREFIX, entryID, sign(entryID, secret), domain)
+}
+
+// Parse returns the entry id from a reply address created by Address, or an
+// error if the address wasn't created with secret.
+func Parse(address, secret string) (string, error) {
+	if a, err := mail.ParseAddress(address); err == nil {
+		address = a.Address
+	}
+	at := strings.LastIndex(address, "@")
+	if at == -1 {
+		return "", fmt.Errorf("Not an email address: %q", address)
+	}
+	local := address[:at]
+	if !strings.HasPrefix(strings.ToLower(local), PREFIX) {
+		return "", fmt.Errorf("Not a reply address: %q", address)
+	}
+	local = local[len(PREFIX):]
+	dot := strings.LastIndex(local, ".")
+	if dot == -1 {
+		return "", fmt.Errorf("Not a reply address: %q", address)
+	}
+	entryID, sig := local[:dot], strings.ToLower(local[dot+1:])
+	if !hmac.Equal([]byte(sig), []byte(sign(entryID, secret))) {
+		return "", fmt.Errorf("Invalid signature in reply address: %q", address)
+	}
+	return entryID, nil
+}
+
+// VerifyMailgun returns true if the timestamp, token, and signature fields
+// of a Mailgun webhook were signed with signingKey. It doesn't stop replays,
+// see Mailgun.Verify.
+func VerifyMailgun(signingKey, timestamp, token, signature string) bool {
+	if signingKey == "" {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(signingKey))
+	mac.Write([]byte(timestamp + token))
+	return hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(signature))
+}
+
+// MAX_AGE is how far from now the timestamp of a Mailgun webhook may be.
+const MAX_AGE = 5 * time.Minute
+
+// Mailgun verifies Mailgun webhooks, and also rejects those that are stale or
+// that replay one already accepted.
+type Mailgun struct {
+	// now is replaceable for testing.
+	now func() time.Time
+
+	mutex sync.Mutex
+
+	// used maps the tokens of accepted webhooks to when they are old enough
+	// to be rejected by their timestamp, and so can be forgotten.
+	used map[string]time.Time
+}
+
+func NewMailgun() *Mailgun {
+	return &Mailgun{
+		now:  time.Now,
+		used: map[string]time.Time{},
+	}
+}
+
+// Verify returns true if the webhook was signed with signingKey, its
+// timestamp is within MAX_AGE of now, and its token hasn't been seen before.
+func (m *Mailgun) Verify(signingKey, timestamp, token, signature string) bool {
+	if !VerifyMailgun(signingKey, timestamp, token, signature) {
+		return false
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	sent := time.Unix(seconds, 0)
+	now := m.now()
+	if now.Sub(sent) > MAX_AGE || sent.Sub(now) > MAX_AGE {
+		return false
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for t, expires := range m.used {
+		if now.After(expires) {
+			delete(m.used, t)
+		}
+	}
+	if _, ok := m.used[token]; ok {
+		return false
+	}
+	m.used[token] = sent.Add(MAX_AGE)
+	return true
+}
+
+// Author returns the display name from a From header, falling back to the
+// part of the address before the @ so the full address isn't published.
+func Author(from string) string {
+	a, err := mail.ParseAddress(from)
+	if err != nil {
+		return "Anonymous"
+	}
+	if a.Name != "" {
+		return a.Name
+	}
+	return strings.SplitN(a.Address, "@", 2)[0]
+}
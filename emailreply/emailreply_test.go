@@ -0,0 +1,83 @@
+package emailreply
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddress_RoundTrip(t *testing.T) {
+	a := Address("abc123", "secret", "reply.example.com")
+	assert.Contains(t, a, "reply+abc123.")
+	assert.Contains(t, a, "@reply.example.com")
+
+	id, err := Parse(a, "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc123", id)
+
+	id, err = Parse("Stream <"+a+">", "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc123", id)
+}
+
+func TestParse_Invalid(t *testing.T) {
+	a := Address("abc123", "secret", "reply.example.com")
+	_, err := Parse(a, "other secret")
+	assert.Error(t, err)
+
+	_, err = Parse("joe@example.com", "secret")
+	assert.Error(t, err)
+
+	_, err = Parse("reply+abc123@example.com", "secret")
+	assert.Error(t, err)
+
+	_, err = Parse("not an address", "secret")
+	assert.Error(t, err)
+}
+
+func TestVerifyMailgun(t *testing.T) {
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte("1600000000" + "token"))
+	sig := hex.EncodeToString(mac.Sum(nil))
+
+	assert.True(t, VerifyMailgun("key", "1600000000", "token", sig))
+	assert.False(t, VerifyMailgun("key", "1600000001", "token", sig))
+	assert.False(t, VerifyMailgun("", "1600000000", "token", sig))
+}
+
+func TestMailgun_Verify(t *testing.T) {
+	sign := func(timestamp, token string) string {
+		mac := hmac.New(sha256.New, []byte("key"))
+		mac.Write([]byte(timestamp + token))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	m := NewMailgun()
+	now := time.Unix(1600000000, 0)
+	m.now = func() time.Time { return now }
+
+	assert.True(t, m.Verify("key", "1600000000", "a", sign("1600000000", "a")))
+	assert.False(t, m.Verify("other", "1600000000", "b", sign("1600000000", "b")))
+
+	// Replays are rejected.
+	assert.False(t, m.Verify("key", "1600000000", "a", sign("1600000000", "a")))
+
+	// So are stale and future timestamps.
+	assert.False(t, m.Verify("key", "1599999000", "c", sign("1599999000", "c")))
+	assert.False(t, m.Verify("key", "1600001000", "d", sign("1600001000", "d")))
+	assert.False(t, m.Verify("key", "soon", "e", sign("soon", "e")))
+
+	// Tokens are forgotten once their timestamp is too old to be accepted.
+	now = now.Add(MAX_AGE + time.Second)
+	assert.True(t, m.Verify("key", "1600000300", "f", sign("1600000300", "f")))
+	assert.Len(t, m.used, 1)
+}
+
+func TestAuthor(t *testing.T) {
+	assert.Equal(t, "Jane Doe", Author(`"Jane Doe" <jane@example.com>`))
+	assert.Equal(t, "jane", Author("jane@example.com"))
+	assert.Equal(t, "Anonymous", Author(""))
+}
@@ -0,0 +1,190 @@
+// Package endpoints caches the webmention endpoints discovered for each
+// target domain, so repeated sends to the same site skip the discovery
+// round trips. Domains without an endpoint, and domains whose discovery is
+// failing, are cached too, so they aren't fetched on every send.
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/jcgregorio/go-lib/ds"
+	"github.com/jcgregorio/slog"
+)
+
+const (
+	ENDPOINT ds.Kind = "WebmentionEndpoint"
+
+	// FOUND_TTL is how long a discovered endpoint is used.
+	FOUND_TTL = 24 * time.Hour
+
+	// NONE_TTL is how long a domain without an endpoint is remembered.
+	NONE_TTL = 7 * 24 * time.Hour
+
+	// FAILED_TTL is how long discovery isn't retried after it fails, which
+	// doubles with each failure in a row, up to MAX_FAILED_TTL.
+	FAILED_TTL     = 15 * time.Minute
+	MAX_FAILED_TTL = 24 * time.Hour
+)
+
+// ErrNoEndpoint is returned for targets that don't have a webmention
+// endpoint.
+var ErrNoEndpoint = errors.New("No webmention endpoint.")
+
+var (
+	hits         = expvar.NewInt("webmention_endpoint_cache_hits")
+	negativeHits = expvar.NewInt("webmention_endpoint_cache_negative_hits")
+	misses       = expvar.NewInt("webmention_endpoint_cache_misses")
+	failures     = expvar.NewInt("webmention_endpoint_discovery_failures")
+)
+
+// Endpoint is the result of discovery for a domain.
+type Endpoint struct {
+	// Endpoint is "" if the domain doesn't have one.
+	Endpoint string    `datastore:"endpoint,noindex"`
+	Checked  time.Time `datastore:"checked,noindex"`
+
+	// Failures is the number of times in a row discovery has failed, and
+	// Error is the last failure.
+	Failures int    `datastore:"failures,noindex"`
+	Error    string `datastore:"error,noindex"`
+}
+
+// expires returns when the result should be discovered again.
+func (e *Endpoint) expires() time.Time {
+	if e.Failures > 0 {
+		ttl := FAILED_TTL
+		for i := 1; i < e.Failures && ttl < MAX_FAILED_TTL; i++ {
+			ttl *= 2
+		}
+		if ttl > MAX_FAILED_TTL {
+			ttl = MAX_FAILED_TTL
+		}
+		return e.Checked.Add(ttl)
+	}
+	if e.Endpoint == "" {
+		return e.Checked.Add(NONE_TTL)
+	}
+	return e.Checked.Add(FOUND_TTL)
+}
+
+// DiscoverFunc finds the webmention endpoint of target, returning "" and no
+// error if it doesn't have one.
+type DiscoverFunc func(target string) (string, error)
+
+type Endpoints struct {
+	DS  *ds.DS
+	log slog.Logger
+
+	discover DiscoverFunc
+
+	// now is replaceable for testing.
+	now func() time.Time
+}
+
+// New returns an Endpoints that finds endpoints that aren't cached with
+// discover.
+func New(ctx context.Context, project, ns string, discover DiscoverFunc, log slog.Logger) (*Endpoints, error) {
+	d, err := ds.New(ctx, project, ns)
+	if err != nil {
+		return nil, err
+	}
+	return &Endpoints{
+		DS:       d,
+		log:      log,
+		discover: discover,
+		now:      time.Now,
+	}, nil
+}
+
+// domain returns the lowercased host of target.
+func domain(target string) (string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("Invalid target %q: %s", target, err)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("Invalid target %q: no host", target)
+	}
+	return strings.ToLower(u.Host), nil
+}
+
+func (e *Endpoints) key(domain string) *datastore.Key {
+	key := e.DS.NewKey(ENDPOINT)
+	key.Name = domain
+	return key
+}
+
+// cached returns the result from a cached Endpoint.
+func cached(c *Endpoint) (string, error) {
+	if c.Failures > 0 {
+		negativeHits.Add(1)
+		return "", fmt.Errorf("Discovery failed recently: %s", c.Error)
+	}
+	if c.Endpoint == "" {
+		negativeHits.Add(1)
+		return "", ErrNoEndpoint
+	}
+	hits.Add(1)
+	return c.Endpoint, nil
+}
+
+// Discover returns the webmention endpoint for target, from the cache if the
+// target's domain was discovered recently. Returns ErrNoEndpoint if there
+// isn't one.
+func (e *Endpoints) Discover(ctx context.Context, target string) (string, error) {
+	d, err := domain(target)
+	if err != nil {
+		return "", err
+	}
+	c := &Endpoint{}
+	getErr := e.DS.Client.Get(ctx, e.key(d), c)
+	if getErr == nil && e.now().Before(c.expires()) {
+		return cached(c)
+	}
+	if getErr != nil && getErr != datastore.ErrNoSuchEntity {
+		e.log.Warningf("Failed to read cached endpoint for %q: %s", d, getErr)
+	}
+	misses.Add(1)
+	endpoint, err := e.discover(target)
+	if err != nil {
+		failures.Add(1)
+		c.Failures++
+		c.Error = err.Error()
+	} else {
+		c.Endpoint = endpoint
+		c.Failures = 0
+		c.Error = ""
+	}
+	c.Checked = e.now()
+	if _, putErr := e.DS.Client.Put(ctx, e.key(d), c); putErr != nil {
+		e.log.Warningf("Failed to cache endpoint for %q: %s", d, putErr)
+	}
+	if err != nil {
+		return "", err
+	}
+	if endpoint == "" {
+		return "", ErrNoEndpoint
+	}
+	return endpoint, nil
+}
+
+// Forget removes the cached endpoint for target's domain, e.g. after a send
+// to it fails.
+func (e *Endpoints) Forget(ctx context.Context, target string) error {
+	d, err := domain(target)
+	if err != nil {
+		return err
+	}
+	if err := e.DS.Client.Delete(ctx, e.key(d)); err != nil {
+		return fmt.Errorf("Failed to forget endpoint for %q: %s", d, err)
+	}
+	return nil
+}
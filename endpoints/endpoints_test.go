@@ -0,0 +1,82 @@
+package endpoints
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jcgregorio/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpires(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, now.Add(FOUND_TTL), (&Endpoint{Endpoint: "https://example.org/wm", Checked: now}).expires())
+	assert.Equal(t, now.Add(NONE_TTL), (&Endpoint{Checked: now}).expires())
+	assert.Equal(t, now.Add(FAILED_TTL), (&Endpoint{Checked: now, Failures: 1}).expires())
+	assert.Equal(t, now.Add(4*FAILED_TTL), (&Endpoint{Checked: now, Failures: 3}).expires())
+	assert.Equal(t, now.Add(MAX_FAILED_TTL), (&Endpoint{Checked: now, Failures: 50}).expires())
+}
+
+func TestDomain(t *testing.T) {
+	d, err := domain("https://Example.org/post/1")
+	assert.NoError(t, err)
+	assert.Equal(t, "example.org", d)
+	_, err = domain("/post/1")
+	assert.Error(t, err)
+}
+
+func TestEndpoints(t *testing.T) {
+	if os.Getenv("DATASTORE_EMULATOR_HOST") == "" {
+		t.Skip("Requires a running Cloud Datastore emulator, see entries/entries_test.go.")
+	}
+	calls := 0
+	result := "https://example.org/webmention"
+	var resultErr error
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	e, err := New(context.Background(), "test-project", fmt.Sprintf("test-namespace-%d", r.Uint64()), func(target string) (string, error) {
+		calls++
+		return result, resultErr
+	}, logger.New())
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	endpoint, err := e.Discover(ctx, "https://example.org/a")
+	assert.NoError(t, err)
+	assert.Equal(t, result, endpoint)
+	endpoint, err = e.Discover(ctx, "https://example.org/b")
+	assert.NoError(t, err)
+	assert.Equal(t, result, endpoint)
+	assert.Equal(t, 1, calls)
+
+	// Negative caching.
+	result = ""
+	_, err = e.Discover(ctx, "https://example.com/a")
+	assert.Equal(t, ErrNoEndpoint, err)
+	_, err = e.Discover(ctx, "https://example.com/b")
+	assert.Equal(t, ErrNoEndpoint, err)
+	assert.Equal(t, 2, calls)
+
+	// Failures back off.
+	resultErr = fmt.Errorf("timeout")
+	_, err = e.Discover(ctx, "https://example.net/a")
+	assert.Error(t, err)
+	_, err = e.Discover(ctx, "https://example.net/a")
+	assert.Error(t, err)
+	assert.Equal(t, 3, calls)
+	e.now = func() time.Time { return time.Now().Add(FAILED_TTL + time.Minute) }
+	resultErr = nil
+	result = "https://example.net/wm"
+	endpoint, err = e.Discover(ctx, "https://example.net/a")
+	assert.NoError(t, err)
+	assert.Equal(t, result, endpoint)
+	assert.Equal(t, 4, calls)
+
+	assert.NoError(t, e.Forget(ctx, "https://example.org/c"))
+	_, err = e.Discover(ctx, "https://example.org/a")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, calls)
+}
@@ -0,0 +1,102 @@
+package entries
+
+import (
+	"container/list"
+	"expvar"
+	"sync"
+	"time"
+)
+
+const (
+	// CACHE_SIZE is the number of query results kept in memory.
+	CACHE_SIZE = 100
+
+	// CACHE_TTL is how long a query result is kept. Writes only clear the
+	// cache of the instance that made them, so this bounds how stale other
+	// instances can be.
+	CACHE_TTL = 30 * time.Second
+)
+
+var (
+	cacheHits   = expvar.NewInt("entries_cache_hits")
+	cacheMisses = expvar.NewInt("entries_cache_misses")
+)
+
+// cache is an LRU cache of query results. It is cleared on every write since
+// any write can change the results of a list, and results expire after ttl
+// since writes from other instances don't clear it.
+type cache struct {
+	mutex sync.Mutex
+	max   int
+	ttl   time.Duration
+	lru   *list.List
+	items map[string]*list.Element
+
+	// now is replaceable for testing.
+	now func() time.Time
+}
+
+type cacheItem struct {
+	key     string
+	entries []*Entry
+	expires time.Time
+}
+
+func newCache(max int, ttl time.Duration) *cache {
+	return &cache{
+		max:   max,
+		ttl:   ttl,
+		lru:   list.New(),
+		items: map[string]*list.Element{},
+		now:   time.Now,
+	}
+}
+
+// copyEntries makes shallow copies of entries so callers that modify the
+// returned entries don't modify the cached ones.
+func copyEntries(in []*Entry) []*Entry {
+	ret := make([]*Entry, len(in))
+	for i, e := range in {
+		c := *e
+		ret[i] = &c
+	}
+	return ret
+}
+
+func (c *cache) get(key string) ([]*Entry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if e, ok := c.items[key]; ok {
+		item := e.Value.(*cacheItem)
+		if c.now().Before(item.expires) {
+			c.lru.MoveToFront(e)
+			cacheHits.Add(1)
+			return copyEntries(item.entries), true
+		}
+		c.lru.Remove(e)
+		delete(c.items, key)
+	}
+	cacheMisses.Add(1)
+	return nil, false
+}
+
+func (c *cache) put(key string, entries []*Entry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.lru.Remove(e)
+	}
+	c.items[key] = c.lru.PushFront(&cacheItem{key: key, entries: copyEntries(entries), expires: c.now().Add(c.ttl)})
+	for c.lru.Len() > c.max {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheItem).key)
+	}
+}
+
+func (c *cache) clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lru.Init()
+	c.items = map[string]*list.Element{}
+}
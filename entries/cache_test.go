@@ -0,0 +1,59 @@
+package entries
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	c := newCache(2, time.Minute)
+	hits, misses := cacheHits.Value(), cacheMisses.Value()
+
+	_, ok := c.get("a")
+	assert.False(t, ok)
+
+	c.put("a", []*Entry{{ID: "1"}})
+	got, ok := c.get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "1", got[0].ID)
+
+	// Modifying the result doesn't modify the cache.
+	got[0].ID = "2"
+	got, _ = c.get("a")
+	assert.Equal(t, "1", got[0].ID)
+
+	// "b" is evicted as the least recently used.
+	c.put("b", []*Entry{})
+	c.get("a")
+	c.put("c", []*Entry{})
+	_, ok = c.get("b")
+	assert.False(t, ok)
+	_, ok = c.get("a")
+	assert.True(t, ok)
+
+	c.clear()
+	_, ok = c.get("a")
+	assert.False(t, ok)
+
+	assert.Equal(t, hits+4, cacheHits.Value())
+	assert.Equal(t, misses+3, cacheMisses.Value())
+}
+
+func TestCache_TTL(t *testing.T) {
+	c := newCache(2, time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.put("a", []*Entry{{ID: "1"}})
+	now = now.Add(59 * time.Second)
+	_, ok := c.get("a")
+	assert.True(t, ok)
+
+	// Expired results are dropped, so writes from other instances show up.
+	now = now.Add(time.Second)
+	_, ok = c.get("a")
+	assert.False(t, ok)
+	assert.Empty(t, c.items)
+}
@@ -3,12 +3,18 @@ package entries
 import (
 	"context"
 	"crypto/md5"
+	"errors"
 	"fmt"
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
+	"cloud.google.com/go/datastore"
 	"google.golang.org/api/iterator"
 
 	"github.com/jcgregorio/go-lib/ds"
@@ -17,11 +23,21 @@ import (
 
 const (
 	ENTRY ds.Kind = "Entry"
+
+	// DELETED_ENTRY holds deleted entries until they are purged, so a delete
+	// can be undone.
+	DELETED_ENTRY ds.Kind = "DeletedEntry"
 )
 
 type Entries struct {
 	DS  *ds.DS
 	log slog.Logger
+
+	// cache holds the results of Get, List, and ListPublic.
+	cache *cache
+
+	// stats counts the reads done, see Stats.
+	stats *stats
 }
 
 func New(ctx context.Context, project, ns string, log slog.Logger) (*Entries, error) {
@@ -30,66 +46,785 @@ func New(ctx context.Context, project, ns string, log slog.Logger) (*Entries, er
 		return nil, err
 	}
 	return &Entries{
-		DS:  d,
-		log: log,
+		DS:    d,
+		log:   log,
+		cache: newCache(CACHE_SIZE, CACHE_TTL),
+		stats: newStats(),
 	}, nil
 }
 
+// Visibility controls where an entry is displayed.
+type Visibility string
+
+const (
+	// PUBLIC entries appear everywhere. Entries written before visibility
+	// was added have an empty Visibility, which is also public.
+	PUBLIC Visibility = "public"
+
+	// UNLISTED entries are available at their permalink but don't appear in
+	// any list or feed.
+	UNLISTED Visibility = "unlisted"
+
+	// PRIVATE entries are only visible to admins, or via a capability URL.
+	PRIVATE Visibility = "private"
+)
+
+// ToVisibility converts a string, such as a form value, into a Visibility,
+// defaulting to PUBLIC for unknown values.
+func ToVisibility(s string) Visibility {
+	switch v := Visibility(s); v {
+	case UNLISTED, PRIVATE:
+		return v
+	default:
+		return PUBLIC
+	}
+}
+
+// Kind is the kind of post an entry is.
+type Kind string
+
+const (
+	// NOTE is a plain post. Entries written before kinds were added have an
+	// empty Kind, which is also a note.
+	NOTE Kind = "note"
+
+	// CHECKIN is a post made at a location.
+	CHECKIN Kind = "checkin"
+
+	// BOOKMARK is a post about the page at its Link, as on a link blog.
+	BOOKMARK Kind = "bookmark"
+
+	// AUDIO and VIDEO are posts of the file at their MediaURL, with the
+	// content as show notes.
+	AUDIO Kind = "audio"
+	VIDEO Kind = "video"
+)
+
+type kindLabel struct {
+	singular, plural string
+}
+
+// kindLabels are the names of the kinds as displayed in titles.
+var kindLabels = map[Kind]kindLabel{
+	NOTE:     {"Note", "Notes"},
+	CHECKIN:  {"Checkin", "Checkins"},
+	BOOKMARK: {"Bookmark", "Bookmarks"},
+	AUDIO:    {"Audio", "Audios"},
+	VIDEO:    {"Video", "Videos"},
+}
+
+// Label returns the name of the kind for display, e.g. "Bookmark".
+func (k Kind) Label() string {
+	return kindLabels[ToKind(string(k))].singular
+}
+
+// PluralLabel returns the name of the entries of the kind for display, e.g.
+// "Bookmarks".
+func (k Kind) PluralLabel() string {
+	return kindLabels[ToKind(string(k))].plural
+}
+
+// ToKind converts a string, such as a form value, into a Kind, defaulting to
+// NOTE for unknown values.
+func ToKind(s string) Kind {
+	switch k := Kind(s); k {
+	case CHECKIN, BOOKMARK, AUDIO, VIDEO:
+		return k
+	default:
+		return NOTE
+	}
+}
+
 type Entry struct {
-	Title   string    `datastore:"title,noindex"`
-	Content string    `datastore:"content,noindex"`
-	ID      string    `datastore:"-"`
-	Created time.Time `datastore:"created"`
-	Updated time.Time `datastore:"updated"`
+	Title      string     `datastore:"title,noindex"`
+	Content    string     `datastore:"content,noindex"`
+	ID         string     `datastore:"-"`
+	Created    time.Time  `datastore:"created"`
+	Updated    time.Time  `datastore:"updated"`
+	Visibility Visibility `datastore:"visibility,noindex"`
+
+	// Summary is optional and is used as a content warning, with the content
+	// collapsed behind it.
+	Summary string `datastore:"summary,noindex"`
+
+	// Syndication are the URLs of copies of this entry on other sites.
+	Syndication []string `datastore:"syndication,noindex"`
+
+	// Targets are all the URLs webmentions have been sent to for this entry,
+	// including ones no longer linked from the content.
+	Targets []string `datastore:"targets,noindex"`
+
+	// Likes, Reposts, and Replies are the numbers of displayed mentions of
+	// the entry of each type, maintained by SetInteractions.
+	Likes   int `datastore:"likes,noindex"`
+	Reposts int `datastore:"reposts,noindex"`
+	Replies int `datastore:"replies,noindex"`
+
+	// HasPhotos is true if the content contains images. It is maintained by
+	// Insert and Update.
+	HasPhotos bool `datastore:"has_photos"`
+
+	// Tags are the #hashtags in the content, lowercased. They are maintained
+	// by Insert and Update.
+	Tags []string `datastore:"tags"`
+
+	// URLs are the pages the entry links to, normalized by NormalizeURL, so
+	// that posting about a page again can be noticed. They are maintained by
+	// Insert and Update.
+	URLs []string `datastore:"urls"`
+
+	// Version is incremented on every Update.
+	Version int64 `datastore:"version,noindex"`
+
+	Kind Kind `datastore:"kind"`
+
+	// Link is the URL of the page a BOOKMARK entry is about.
+	Link string `datastore:"link,noindex"`
+
+	// MediaURL is the audio or video file of an AUDIO or VIDEO entry, either
+	// under /images/ or absolute. MediaType is its MIME type, MediaLength
+	// its size in bytes, if known, and Duration its length in seconds, if
+	// known.
+	MediaURL    string `datastore:"media_url,noindex"`
+	MediaType   string `datastore:"media_type,noindex"`
+	MediaLength int64  `datastore:"media_length,noindex"`
+	Duration    int64  `datastore:"duration,noindex"`
+
+	// Poster is the image shown before a VIDEO entry plays, if any.
+	Poster string `datastore:"poster,noindex"`
+
+	// Latitude, Longitude, and Venue are only used by CHECKIN entries.
+	Latitude  float64 `datastore:"latitude,noindex"`
+	Longitude float64 `datastore:"longitude,noindex"`
+	Venue     string  `datastore:"venue,noindex"`
+
+	// Aliases are previous identifiers for this entry, such as old slugs or
+	// IDs, which redirect to the entry's permalink.
+	Aliases []string `datastore:"aliases"`
+
+	// NoBridges is true if the entry shouldn't link to the BRIDGES, so it
+	// isn't syndicated.
+	NoBridges bool `datastore:"no_bridges,noindex"`
+
+	// ParentID is the ID of the entry this one continues, which chains a
+	// series of notes into a thread.
+	ParentID string `datastore:"parent_id"`
+
+	// Locked is true if the entry no longer accepts webmentions or replies,
+	// either because it was locked by hand or by LockBefore.
+	Locked bool `datastore:"locked,noindex"`
+
+	// KeepOpen is true if the entry was unlocked by hand, so LockBefore
+	// leaves it alone.
+	KeepOpen bool `datastore:"keep_open,noindex"`
+}
+
+// SetLocked locks or unlocks the entry by hand. Unlocking a locked entry
+// keeps it open from then on.
+func (e *Entry) SetLocked(locked bool) {
+	if e.Locked && !locked {
+		e.KeepOpen = true
+	}
+	if locked {
+		e.KeepOpen = false
+	}
+	e.Locked = locked
+}
+
+// HasMedia returns true if the entry is an AUDIO or VIDEO entry with a
+// MediaURL.
+func (e *Entry) HasMedia() bool {
+	return (e.Kind == AUDIO || e.Kind == VIDEO) && e.MediaURL != ""
+}
+
+// FuzzLocation rounds the coordinates to the given number of decimal places,
+// e.g. 2 places is roughly 1km, so the exact location isn't published.
+func (e *Entry) FuzzLocation(places int) {
+	scale := math.Pow(10, float64(places))
+	e.Latitude = math.Round(e.Latitude*scale) / scale
+	e.Longitude = math.Round(e.Longitude*scale) / scale
+}
+
+// photoRegex matches Markdown images and HTML img tags.
+var photoRegex = regexp.MustCompile(`!\[[^\]]*\]\(|<img\b`)
+
+// tagRegex matches #hashtags at the start of the content or after
+// whitespace, which excludes URL fragments and Markdown headings.
+var tagRegex = regexp.MustCompile(`(?:^|\s)#(\pL[\pL\pN_-]*)`)
+
+// tags returns the distinct lowercased hashtags in the content.
+func tags(content string) []string {
+	ret := []string{}
+	seen := map[string]bool{}
+	for _, m := range tagRegex.FindAllStringSubmatch(content, -1) {
+		t := strings.ToLower(m[1])
+		if !seen[t] {
+			seen[t] = true
+			ret = append(ret, t)
+		}
+	}
+	return ret
+}
+
+// tagNameRegex matches a valid hashtag name, without the #.
+var tagNameRegex = regexp.MustCompile(`^\pL[\pL\pN_-]*$`)
+
+// SetTags returns the content changed so that its hashtags are tags, which
+// may include a leading #. Hashtags that aren't in tags lose their # so the
+// surrounding text still reads, and tags that are missing are appended as a
+// final paragraph. An error is returned if a tag isn't a valid hashtag.
+func SetTags(content string, tags []string) (string, error) {
+	want := map[string]bool{}
+	for _, t := range tags {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "#")
+		if !tagNameRegex.MatchString(t) {
+			return "", fmt.Errorf("Invalid tag: %q", t)
+		}
+		want[strings.ToLower(t)] = true
+	}
+	have := map[string]bool{}
+	content = tagRegex.ReplaceAllStringFunc(content, func(m string) string {
+		i := strings.Index(m, "#")
+		name := strings.ToLower(m[i+1:])
+		if want[name] {
+			have[name] = true
+			return m
+		}
+		return m[:i] + m[i+1:]
+	})
+	missing := []string{}
+	for _, t := range tags {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "#")
+		if name := strings.ToLower(t); !have[name] {
+			have[name] = true
+			missing = append(missing, "#"+t)
+		}
+	}
+	if len(missing) > 0 {
+		content = strings.TrimRight(content, " \t\n")
+		if content != "" {
+			content += "\n\n"
+		}
+		content += strings.Join(missing, " ")
+	}
+	return content, nil
+}
+
+// hasPhotos returns true if the Markdown content contains images.
+func hasPhotos(content string) bool {
+	return photoRegex.MatchString(content)
+}
+
+// IsPublic returns true if the entry should appear in lists and feeds.
+func (e *Entry) IsPublic() bool {
+	return e.Visibility == "" || e.Visibility == PUBLIC
 }
 
 func (e *Entries) Get(ctx context.Context, id string) (*Entry, error) {
+	cacheKey := "get:" + id
+	if cached, ok := e.cache.get(cacheKey); ok {
+		return cached[0], nil
+	}
 	key := e.DS.NewKey(ENTRY)
 	key.Name = id
 
+	defer e.stats.record(ctx, "Get", time.Now(), 1)
 	var entry Entry
-	if err := e.DS.Client.Get(ctx, key, &entry); err != nil {
+	if err := e.DS.Client.Get(ctx, key, &entry); err == datastore.ErrNoSuchEntity {
+		return nil, ErrNotFound
+	} else if err != nil {
 		return nil, fmt.Errorf("Failed to load %s: %s", key, err)
 	} else {
 		entry.ID = id
+		e.cache.put(cacheKey, []*Entry{&entry})
 		return &entry, nil
 	}
 }
 
-func (e *Entries) Insert(ctx context.Context, content, title string) (string, error) {
+// GetMulti loads the entries with the given ids in a single batch. The
+// returned slice is the same length as ids, with nil for ids that don't
+// exist.
+func (e *Entries) GetMulti(ctx context.Context, ids []string) ([]*Entry, error) {
+	keys := make([]*datastore.Key, len(ids))
+	for i, id := range ids {
+		keys[i] = e.DS.NewKey(ENTRY)
+		keys[i].Name = id
+	}
+	defer e.stats.record(ctx, "GetMulti", time.Now(), len(ids))
+	dst := make([]Entry, len(ids))
+	err := e.DS.Client.GetMulti(ctx, keys, dst)
+	merr, isMulti := err.(datastore.MultiError)
+	if err != nil && !isMulti {
+		return nil, fmt.Errorf("Failed to load entries: %s", err)
+	}
+	ret := make([]*Entry, len(ids))
+	for i := range dst {
+		if isMulti && merr[i] != nil {
+			if merr[i] == datastore.ErrNoSuchEntity {
+				continue
+			}
+			return nil, fmt.Errorf("Failed to load %s: %s", keys[i], merr[i])
+		}
+		dst[i].ID = ids[i]
+		ret[i] = &dst[i]
+	}
+	return ret, nil
+}
+
+// Count returns the total number of entries, of any visibility.
+func (e *Entries) Count(ctx context.Context) (int, error) {
+	start := time.Now()
+	n, err := e.DS.Client.Count(ctx, e.DS.NewQuery(ENTRY).KeysOnly())
+	// Counts are billed one read per 1000 index entries.
+	e.stats.record(ctx, "Count", start, n/1000+1)
+	return n, err
+}
+
+// Insert writes a new entry and returns its id. The Created and Updated
+// times are set to now.
+func (e *Entries) Insert(ctx context.Context, entry *Entry) (string, error) {
+	defer e.cache.clear()
 	key := e.DS.NewKey(ENTRY)
-	key.Name = fmt.Sprintf("%x", md5.Sum([]byte(content+title+time.Now().Format(time.RFC3339Nano))))
+	key.Name = fmt.Sprintf("%x", md5.Sum([]byte(entry.Content+entry.Title+time.Now().Format(time.RFC3339Nano))))
 
 	now := time.Now()
-	entry := &Entry{
-		Content: content,
-		Title:   title,
-		Created: now,
-		Updated: now,
+	entry.Created = now
+	entry.Updated = now
+	entry.HasPhotos = hasPhotos(entry.Content)
+	entry.Tags = tags(entry.Content)
+	entry.URLs = urls(entry)
+	if entry.Visibility == "" {
+		entry.Visibility = PUBLIC
+	}
+	if entry.Kind == "" {
+		entry.Kind = NOTE
 	}
 	_, err := e.DS.Client.Put(context.Background(), key, entry)
+	entry.ID = key.Name
 	return key.Name, err
 }
 
+// Import writes an entry copied from another site, keeping its Created time,
+// and returns its id. The id is derived from source, the identifier of the
+// original post, which is also added as an alias, so importing the same
+// post again returns the existing id and false.
+func (e *Entries) Import(ctx context.Context, entry *Entry, source string) (string, bool, error) {
+	defer e.cache.clear()
+	key := e.DS.NewKey(ENTRY)
+	key.Name = fmt.Sprintf("%x", md5.Sum([]byte(source)))
+
+	if entry.Updated.IsZero() {
+		entry.Updated = entry.Created
+	}
+	entry.HasPhotos = hasPhotos(entry.Content)
+	entry.Tags = tags(entry.Content)
+	entry.URLs = urls(entry)
+	if entry.Visibility == "" {
+		entry.Visibility = PUBLIC
+	}
+	if entry.Kind == "" {
+		entry.Kind = NOTE
+	}
+	entry.Aliases, _ = union(entry.Aliases, []string{source})
+	isNew := false
+	_, err := e.DS.Client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var existing Entry
+		err := tx.Get(key, &existing)
+		if err == nil {
+			return nil
+		}
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		isNew = true
+		_, err = tx.Put(key, entry)
+		return err
+	})
+	if err != nil {
+		return "", false, fmt.Errorf("Failed to import %q: %s", source, err)
+	}
+	entry.ID = key.Name
+	return key.Name, isNew, nil
+}
+
+// ErrNotFound is returned from Get if there is no entry with the ID, as
+// opposed to the Datastore failing.
+var ErrNotFound = errors.New("Entry not found.")
+
+// Ping returns an error if the Datastore can't be read, bypassing the cache.
+func (e *Entries) Ping(ctx context.Context) error {
+	defer e.stats.record(ctx, "Ping", time.Now(), 1)
+	if _, err := e.DS.Client.GetAll(ctx, e.DS.NewQuery(ENTRY).KeysOnly().Limit(1), nil); err != nil {
+		return fmt.Errorf("Failed to reach the Datastore: %s", err)
+	}
+	return nil
+}
+
+// ErrConflict is returned from Update if the entry was changed since it was
+// read.
+var ErrConflict = errors.New("Entry was modified since it was loaded.")
+
+// Update writes the entry. The entry's Version must match the stored Version,
+// otherwise ErrConflict is returned, which prevents one edit from silently
+// overwriting another. On success Version is incremented.
 func (e *Entries) Update(ctx context.Context, entry *Entry) error {
+	defer e.cache.clear()
 	key := e.DS.NewKey(ENTRY)
 	key.Name = entry.ID
 
-	entry.Updated = time.Now()
-	_, err := e.DS.Client.Put(context.Background(), key, entry)
+	_, err := e.DS.Client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var stored Entry
+		if err := tx.Get(key, &stored); err != nil {
+			return err
+		}
+		if stored.Version != entry.Version {
+			return ErrConflict
+		}
+		// Bookkeeping fields aren't part of an edit.
+		entry.Syndication = stored.Syndication
+		entry.Targets = stored.Targets
+		entry.Aliases = stored.Aliases
+		entry.Likes = stored.Likes
+		entry.Reposts = stored.Reposts
+		entry.Replies = stored.Replies
+		entry.Version = stored.Version + 1
+		entry.Updated = time.Now()
+		entry.HasPhotos = hasPhotos(entry.Content)
+		entry.Tags = tags(entry.Content)
+		entry.URLs = urls(entry)
+		_, err := tx.Put(key, entry)
+		return err
+	})
+	if err == ErrConflict {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to update %q: %s", entry.ID, err)
+	}
+	return nil
+}
+
+// AddSyndication records a URL where a copy of the entry can be found.
+func (e *Entries) AddSyndication(ctx context.Context, id, u string) error {
+	return e.modify(ctx, id, func(entry *Entry) bool {
+		var changed bool
+		entry.Syndication, changed = union(entry.Syndication, []string{u})
+		return changed
+	})
+}
+
+// SetInteractions records the numbers of likes, reposts, and replies of the
+// entry.
+func (e *Entries) SetInteractions(ctx context.Context, id string, likes, reposts, replies int) error {
+	return e.modify(ctx, id, func(entry *Entry) bool {
+		if entry.Likes == likes && entry.Reposts == reposts && entry.Replies == replies {
+			return false
+		}
+		entry.Likes, entry.Reposts, entry.Replies = likes, reposts, replies
+		return true
+	})
+}
+
+// HasInteractions returns true if the entry has any likes, reposts, or
+// replies.
+func (e *Entry) HasInteractions() bool {
+	return e.Likes+e.Reposts+e.Replies > 0
+}
+
+// AddTargets records URLs that webmentions have been sent to.
+func (e *Entries) AddTargets(ctx context.Context, id string, targets []string) error {
+	return e.modify(ctx, id, func(entry *Entry) bool {
+		var changed bool
+		entry.Targets, changed = union(entry.Targets, targets)
+		return changed
+	})
+}
+
+// AddAlias records a previous identifier for the entry.
+func (e *Entries) AddAlias(ctx context.Context, id, alias string) error {
+	if alias == "" || alias == id {
+		return fmt.Errorf("Invalid alias %q.", alias)
+	}
+	return e.modify(ctx, id, func(entry *Entry) bool {
+		var changed bool
+		entry.Aliases, changed = union(entry.Aliases, []string{alias})
+		return changed
+	})
+}
+
+// FindByAlias returns the ID of the entry that has the given alias.
+func (e *Entries) FindByAlias(ctx context.Context, alias string) (string, error) {
+	defer e.stats.record(ctx, "FindByAlias", time.Now(), 1)
+	keys, err := e.DS.Client.GetAll(ctx, e.DS.NewQuery(ENTRY).Filter("aliases =", alias).KeysOnly().Limit(1), nil)
+	if err != nil {
+		return "", fmt.Errorf("Failed to find alias %q: %s", alias, err)
+	}
+	if len(keys) == 0 {
+		return "", fmt.Errorf("No entry has alias %q.", alias)
+	}
+	return keys[0].Name, nil
+}
+
+// FindByURL returns the entries that link to the page at u, newest first. Only
+// entries written or edited since the URL index was added are found.
+func (e *Entries) FindByURL(ctx context.Context, u string) ([]*Entry, error) {
+	n := NormalizeURL(u)
+	if n == "" {
+		return []*Entry{}, nil
+	}
+	ret := []*Entry{}
+	start := time.Now()
+	keys, err := e.DS.Client.GetAll(ctx, e.DS.NewQuery(ENTRY).Filter("urls =", n), &ret)
+	e.stats.record(ctx, "FindByURL", start, len(keys))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find entries linking to %q: %s", u, err)
+	}
+	for i, key := range keys {
+		ret[i].ID = key.Name
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Created.After(ret[j].Created)
+	})
+	return ret, nil
+}
+
+// Children returns the entries that continue the entry with the given id,
+// oldest first.
+//
+// Sorting is done here to avoid needing a composite index.
+func (e *Entries) Children(ctx context.Context, id string) ([]*Entry, error) {
+	ret := []*Entry{}
+	start := time.Now()
+	keys, err := e.DS.Client.GetAll(ctx, e.DS.NewQuery(ENTRY).Filter("parent_id =", id), &ret)
+	e.stats.record(ctx, "Children", start, len(keys))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find children of %q: %s", id, err)
+	}
+	for i, key := range keys {
+		ret[i].ID = key.Name
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Created.Before(ret[j].Created)
+	})
+	return ret, nil
+}
+
+// MAX_THREAD is the most entries Thread returns, which also stops a cycle of
+// parents from looping forever.
+const MAX_THREAD = 100
+
+// Thread returns every entry that continues the entry with the given id,
+// directly or not, in reading order: each entry is followed by its own
+// continuations before its younger siblings.
+func (e *Entries) Thread(ctx context.Context, id string) ([]*Entry, error) {
+	ret := []*Entry{}
+	var walk func(id string) error
+	walk = func(id string) error {
+		children, err := e.Children(ctx, id)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			if len(ret) >= MAX_THREAD {
+				return nil
+			}
+			ret = append(ret, child)
+			if err := walk(child.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(id); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// modify transactionally applies f to the entry with the given id. The
+// entry is only written if f returns true. Updated isn't changed since these
+// are bookkeeping changes, not edits.
+func (e *Entries) modify(ctx context.Context, id string, f func(*Entry) bool) error {
+	defer e.cache.clear()
+	key := e.DS.NewKey(ENTRY)
+	key.Name = id
+	_, err := e.DS.Client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var entry Entry
+		if err := tx.Get(key, &entry); err != nil {
+			return err
+		}
+		if !f(&entry) {
+			return nil
+		}
+		_, err := tx.Put(key, &entry)
+		return err
+	})
 	return err
 }
 
+// union appends the values in b not already in a, and returns true if any
+// were added.
+func union(a, b []string) ([]string, bool) {
+	changed := false
+	for _, s := range b {
+		found := false
+		for _, t := range a {
+			if s == t {
+				found = true
+				break
+			}
+		}
+		if !found {
+			a = append(a, s)
+			changed = true
+		}
+	}
+	return a, changed
+}
+
+// deletedEntry is an entry waiting to be purged.
+type deletedEntry struct {
+	Entry   Entry     `datastore:"entry,noindex"`
+	Deleted time.Time `datastore:"deleted"`
+}
+
+// Delete removes the entry with the given id, keeping a copy that
+// RestoreDeleted can bring back until PurgeDeleted removes it.
 func (e *Entries) Delete(ctx context.Context, id string) error {
+	defer e.cache.clear()
 	key := e.DS.NewKey(ENTRY)
 	key.Name = id
-	return e.DS.Client.Delete(context.Background(), key)
+	deletedKey := e.DS.NewKey(DELETED_ENTRY)
+	deletedKey.Name = id
+	_, err := e.DS.Client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		d := &deletedEntry{
+			Deleted: time.Now(),
+		}
+		if err := tx.Get(key, &d.Entry); err != nil {
+			return err
+		}
+		if _, err := tx.Put(deletedKey, d); err != nil {
+			return err
+		}
+		return tx.Delete(key)
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to delete %q: %s", id, err)
+	}
+	return nil
+}
+
+// RestoreDeleted undoes the Delete of the entry with the given id, if it
+// hasn't been purged yet.
+func (e *Entries) RestoreDeleted(ctx context.Context, id string) error {
+	defer e.cache.clear()
+	key := e.DS.NewKey(ENTRY)
+	key.Name = id
+	deletedKey := e.DS.NewKey(DELETED_ENTRY)
+	deletedKey.Name = id
+	_, err := e.DS.Client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var d deletedEntry
+		if err := tx.Get(deletedKey, &d); err != nil {
+			return err
+		}
+		if _, err := tx.Put(key, &d.Entry); err != nil {
+			return err
+		}
+		return tx.Delete(deletedKey)
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to restore deleted entry %q: %s", id, err)
+	}
+	return nil
+}
+
+// LockBefore locks the entries created before the given time, except those
+// that are already locked or were kept open, and returns how many there were.
+func (e *Entries) LockBefore(ctx context.Context, before time.Time) (int, error) {
+	found := []*Entry{}
+	start := time.Now()
+	keys, err := e.DS.Client.GetAll(ctx, e.DS.NewQuery(ENTRY).Filter("created <", before), &found)
+	e.stats.record(ctx, "LockBefore", start, len(keys))
+	if err != nil {
+		return 0, fmt.Errorf("Failed to find entries to lock: %s", err)
+	}
+	locked := 0
+	for i, key := range keys {
+		if found[i].Locked || found[i].KeepOpen {
+			continue
+		}
+		err := e.modify(ctx, key.Name, func(entry *Entry) bool {
+			if entry.Locked || entry.KeepOpen {
+				return false
+			}
+			entry.Locked = true
+			return true
+		})
+		if err != nil {
+			return locked, fmt.Errorf("Failed to lock %q: %s", key.Name, err)
+		}
+		locked++
+	}
+	return locked, nil
+}
+
+// BackfillPhotos sets HasPhotos on the entries stored without it, such as
+// those written before it was added or restored from an older backup, and
+// returns how many were changed.
+func (e *Entries) BackfillPhotos(ctx context.Context) (int, error) {
+	stale := []string{}
+	err := e.All(ctx, func(entry *Entry) error {
+		if entry.HasPhotos != hasPhotos(entry.Content) {
+			stale = append(stale, entry.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("Failed to find entries to backfill: %s", err)
+	}
+	changed := 0
+	for _, id := range stale {
+		err := e.modify(ctx, id, func(entry *Entry) bool {
+			has := hasPhotos(entry.Content)
+			if entry.HasPhotos == has {
+				return false
+			}
+			entry.HasPhotos = has
+			return true
+		})
+		if err != nil {
+			return changed, fmt.Errorf("Failed to backfill %q: %s", id, err)
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+// PurgeDeleted permanently removes the entries deleted before the given
+// time and returns how many there were.
+func (e *Entries) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	defer e.stats.record(ctx, "PurgeDeleted", time.Now(), 1)
+	keys, err := e.DS.Client.GetAll(ctx, e.DS.NewQuery(DELETED_ENTRY).Filter("deleted <", before).KeysOnly(), nil)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to find deleted entries: %s", err)
+	}
+	if err := e.DS.Client.DeleteMulti(ctx, keys); err != nil {
+		return 0, fmt.Errorf("Failed to purge deleted entries: %s", err)
+	}
+	return len(keys), nil
 }
 
 func (e *Entries) List(ctx context.Context, n int, offset int) ([]*Entry, error) {
+	cacheKey := fmt.Sprintf("list:%d:%d", n, offset)
+	if cached, ok := e.cache.get(cacheKey); ok {
+		return cached, nil
+	}
 	ret := []*Entry{}
 	q := e.DS.NewQuery(ENTRY).Order("-created").Limit(n).Offset(offset)
 
+	// Skipped entities are billed too.
+	start := time.Now()
+	defer func() { e.stats.record(ctx, "List", start, len(ret)+offset) }()
 	it := e.DS.Client.Run(ctx, q)
 	for {
 		entry := &Entry{}
@@ -98,11 +833,163 @@ func (e *Entries) List(ctx context.Context, n int, offset int) ([]*Entry, error)
 			break
 		}
 		if err != nil {
-			e.log.Infof("Failed while reading: %s", err)
+			// Don't cache, or return, a partial list.
+			return nil, fmt.Errorf("Failed while reading: %s", err)
+		}
+		entry.ID = key.Name
+		ret = append(ret, entry)
+	}
+	e.cache.put(cacheKey, ret)
+	return ret, nil
+}
+
+// ListPublic is like List but only returns public entries, and offset counts
+// only public entries.
+//
+// Visibility isn't indexed since older entries don't have it set, so this
+// reads past hidden entries rather than filtering in the query.
+func (e *Entries) ListPublic(ctx context.Context, n int, offset int) ([]*Entry, error) {
+	cacheKey := fmt.Sprintf("public:%d:%d", n, offset)
+	if cached, ok := e.cache.get(cacheKey); ok {
+		return cached, nil
+	}
+	ret, err := e.listPublic(ctx, "ListPublic", e.DS.NewQuery(ENTRY).Order("-created"), n, offset)
+	if err != nil {
+		return nil, err
+	}
+	e.cache.put(cacheKey, ret)
+	return ret, nil
+}
+
+// CountPublic returns the number of public entries.
+func (e *Entries) CountPublic(ctx context.Context) (int, error) {
+	n := 0
+	err := e.All(ctx, func(entry *Entry) error {
+		if entry.IsPublic() {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// ListPhotos is like ListPublic but only returns entries with photos.
+func (e *Entries) ListPhotos(ctx context.Context, n int, offset int) ([]*Entry, error) {
+	return e.listPublic(ctx, "ListPhotos", e.DS.NewQuery(ENTRY).Filter("has_photos =", true).Order("-created"), n, offset)
+}
+
+// ListByTag returns the public entries with the given tag, newest first.
+func (e *Entries) ListByTag(ctx context.Context, tag string, n int, offset int) ([]*Entry, error) {
+	return e.listPublic(ctx, "ListByTag", e.DS.NewQuery(ENTRY).Filter("tags =", strings.ToLower(tag)).Order("-created"), n, offset)
+}
+
+// ListByKind returns the public entries of the given kind, newest first.
+// Entries written before kinds were added have no kind, so they aren't
+// returned even for NOTE.
+func (e *Entries) ListByKind(ctx context.Context, kind Kind, n int, offset int) ([]*Entry, error) {
+	return e.listPublic(ctx, "ListByKind", e.DS.NewQuery(ENTRY).Filter("kind =", string(kind)).Order("-created"), n, offset)
+}
+
+// ListByMonthDay returns the public entries created on the same month and
+// day as t in every year before t's year, newest first. Days are in t's
+// location.
+func (e *Entries) ListByMonthDay(ctx context.Context, t time.Time) ([]*Entry, error) {
+	var oldest []*Entry
+	start := time.Now()
+	_, err := e.DS.Client.GetAll(ctx, e.DS.NewQuery(ENTRY).Order("created").Limit(1), &oldest)
+	e.stats.record(ctx, "ListByMonthDay", start, 1)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find oldest entry: %s", err)
+	}
+	ret := []*Entry{}
+	if len(oldest) == 0 {
+		return ret, nil
+	}
+	for year := t.Year() - 1; year >= oldest[0].Created.In(t.Location()).Year(); year-- {
+		start := time.Date(year, t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		// Skip Feb 29th in non-leap years, which time.Date normalizes to Mar 1st.
+		if start.Day() != t.Day() {
+			continue
+		}
+		q := e.DS.NewQuery(ENTRY).Filter("created >=", start).Filter("created <", start.AddDate(0, 0, 1)).Order("-created")
+		found, err := e.listPublic(ctx, "ListByMonthDay", q, 100, 0)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, found...)
+	}
+	return ret, nil
+}
+
+// ListSince returns up to n public entries created after the given time,
+// newest first.
+func (e *Entries) ListSince(ctx context.Context, since time.Time, n int) ([]*Entry, error) {
+	return e.listPublic(ctx, "ListSince", e.DS.NewQuery(ENTRY).Filter("created >", since).Order("-created"), n, 0)
+}
+
+// listPublic runs q, skipping hidden entries, and counts the reads as op.
+func (e *Entries) listPublic(ctx context.Context, op string, q *datastore.Query, n int, offset int) ([]*Entry, error) {
+	ret := []*Entry{}
+	reads := 0
+	start := time.Now()
+	defer func() { e.stats.record(ctx, op, start, reads) }()
+	it := e.DS.Client.Run(ctx, q)
+	for len(ret) < n {
+		entry := &Entry{}
+		key, err := it.Next(entry)
+		if err == iterator.Done {
 			break
 		}
+		reads++
+		if err != nil {
+			return nil, fmt.Errorf("Failed while reading: %s", err)
+		}
+		if !entry.IsPublic() {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
 		entry.ID = key.Name
 		ret = append(ret, entry)
 	}
 	return ret, nil
 }
+
+// All calls f for every entry, in no particular order, stopping at the first
+// error.
+func (e *Entries) All(ctx context.Context, f func(*Entry) error) error {
+	reads := 0
+	start := time.Now()
+	defer func() { e.stats.record(ctx, "All", start, reads) }()
+	it := e.DS.Client.Run(ctx, e.DS.NewQuery(ENTRY))
+	for {
+		entry := &Entry{}
+		key, err := it.Next(entry)
+		if err == iterator.Done {
+			return nil
+		}
+		reads++
+		if err != nil {
+			return fmt.Errorf("Failed while reading: %s", err)
+		}
+		entry.ID = key.Name
+		if err := f(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// Restore writes the entry exactly as given, including its ID and
+// timestamps, so restoring the same entry twice is idempotent.
+func (e *Entries) Restore(ctx context.Context, entry *Entry) error {
+	defer e.cache.clear()
+	if entry.ID == "" {
+		return fmt.Errorf("Entry must have an ID.")
+	}
+	key := e.DS.NewKey(ENTRY)
+	key.Name = entry.ID
+	_, err := e.DS.Client.Put(ctx, key, entry)
+	return err
+}
@@ -53,7 +53,7 @@ func TestDB(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, entries, 0)
 
-	id, err := e.Insert(ctx, "This is content.", "This is title")
+	id, err := e.Insert(ctx, &Entry{Content: "This is content.", Title: "This is title"})
 	assert.NoError(t, err)
 	assert.NotEqual(t, id, "")
 
@@ -64,7 +64,7 @@ func TestDB(t *testing.T) {
 	assert.Equal(t, entries[0].Title, "This is title")
 	assert.Equal(t, entries[0].Content, "This is content.")
 
-	id2, err := e.Insert(ctx, "This is content.", "This is another post")
+	id2, err := e.Insert(ctx, &Entry{Content: "This is content.", Title: "This is another post"})
 	assert.NoError(t, err)
 	assert.NotEqual(t, id2, "")
 	assert.NotEqual(t, id2, id)
@@ -100,3 +100,335 @@ func TestDB(t *testing.T) {
 	assert.Equal(t, entries[0].Title, "This is another post")
 	assert.Equal(t, entries[0].Content, "This is content.")
 }
+
+func TestRestoreDeleted(t *testing.T) {
+	e := InitForTesting(t)
+	ctx := context.Background()
+
+	id, err := e.Insert(ctx, &Entry{Content: "Oops.", Title: "Title"})
+	assert.NoError(t, err)
+	assert.NoError(t, e.Delete(ctx, id))
+	_, err = e.Get(ctx, id)
+	assert.Error(t, err)
+
+	assert.NoError(t, e.RestoreDeleted(ctx, id))
+	entry, err := e.Get(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, "Oops.", entry.Content)
+	assert.Error(t, e.RestoreDeleted(ctx, id))
+
+	assert.NoError(t, e.Delete(ctx, id))
+	n, err := e.PurgeDeleted(ctx, time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Error(t, e.RestoreDeleted(ctx, id))
+}
+
+func TestListPublic(t *testing.T) {
+	e := InitForTesting(t)
+	ctx := context.Background()
+
+	public, err := e.Insert(ctx, &Entry{Content: "Public.", Title: "Public"})
+	assert.NoError(t, err)
+	_, err = e.Insert(ctx, &Entry{Content: "Unlisted.", Title: "Unlisted", Visibility: UNLISTED})
+	assert.NoError(t, err)
+	private, err := e.Insert(ctx, &Entry{Content: "Private.", Title: "Private", Visibility: PRIVATE})
+	assert.NoError(t, err)
+
+	entries, err := e.List(ctx, 10, 0)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+
+	entries, err = e.ListPublic(ctx, 10, 0)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, public, entries[0].ID)
+
+	entries, err = e.ListPublic(ctx, 10, 1)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 0)
+
+	entry, err := e.Get(ctx, private)
+	assert.NoError(t, err)
+	assert.Equal(t, PRIVATE, entry.Visibility)
+	assert.False(t, entry.IsPublic())
+}
+
+func TestToVisibility(t *testing.T) {
+	assert.Equal(t, PUBLIC, ToVisibility(""))
+	assert.Equal(t, PUBLIC, ToVisibility("bogus"))
+	assert.Equal(t, UNLISTED, ToVisibility("unlisted"))
+	assert.Equal(t, PRIVATE, ToVisibility("private"))
+}
+
+func TestUnion(t *testing.T) {
+	got, changed := union([]string{"a", "b"}, []string{"b", "c"})
+	assert.True(t, changed)
+	assert.Equal(t, []string{"a", "b", "c"}, got)
+
+	got, changed = union([]string{"a"}, []string{"a"})
+	assert.False(t, changed)
+	assert.Equal(t, []string{"a"}, got)
+
+	got, changed = union(nil, nil)
+	assert.False(t, changed)
+	assert.Empty(t, got)
+}
+
+func TestHasPhotos(t *testing.T) {
+	assert.True(t, hasPhotos("A photo ![alt text](https://example.org/a.jpg)."))
+	assert.True(t, hasPhotos("![](/images/a.png)"))
+	assert.True(t, hasPhotos(`<img src="https://example.org/a.jpg">`))
+	assert.False(t, hasPhotos("A [link](https://example.org/) and an <imgur> tag."))
+	assert.False(t, hasPhotos(""))
+}
+
+func TestBackfillPhotos(t *testing.T) {
+	e := InitForTesting(t)
+	ctx := context.Background()
+
+	// Restore writes the entry as given, like an entry stored before
+	// HasPhotos was added.
+	now := time.Now()
+	old := &Entry{
+		ID:         "old-photo",
+		Content:    "![](https://example.org/a.jpg)",
+		Visibility: PUBLIC,
+		Created:    now,
+		Updated:    now,
+	}
+	assert.NoError(t, e.Restore(ctx, old))
+	photos, err := e.ListPhotos(ctx, 10, 0)
+	assert.NoError(t, err)
+	assert.Len(t, photos, 0)
+
+	n, err := e.BackfillPhotos(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	photos, err = e.ListPhotos(ctx, 10, 0)
+	assert.NoError(t, err)
+	assert.Len(t, photos, 1)
+	assert.Equal(t, "old-photo", photos[0].ID)
+
+	n, err = e.BackfillPhotos(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestFuzzLocation(t *testing.T) {
+	e := &Entry{
+		Latitude:  35.7796123,
+		Longitude: -78.6381789,
+	}
+	e.FuzzLocation(2)
+	assert.Equal(t, 35.78, e.Latitude)
+	assert.Equal(t, -78.64, e.Longitude)
+}
+
+func TestSetLocked(t *testing.T) {
+	e := &Entry{}
+	e.SetLocked(false)
+	assert.False(t, e.Locked)
+	assert.False(t, e.KeepOpen)
+	e.SetLocked(true)
+	assert.True(t, e.Locked)
+	e.SetLocked(false)
+	assert.False(t, e.Locked)
+	assert.True(t, e.KeepOpen)
+	e.SetLocked(true)
+	assert.True(t, e.Locked)
+	assert.False(t, e.KeepOpen)
+}
+
+func TestToKind(t *testing.T) {
+	assert.Equal(t, NOTE, ToKind(""))
+	assert.Equal(t, NOTE, ToKind("bogus"))
+	assert.Equal(t, CHECKIN, ToKind("checkin"))
+	assert.Equal(t, BOOKMARK, ToKind("bookmark"))
+	assert.Equal(t, AUDIO, ToKind("audio"))
+	assert.Equal(t, VIDEO, ToKind("video"))
+}
+
+func TestKind_Label(t *testing.T) {
+	assert.Equal(t, "Bookmark", BOOKMARK.Label())
+	assert.Equal(t, "Checkins", CHECKIN.PluralLabel())
+	assert.Equal(t, "Note", Kind("").Label())
+	for _, k := range []Kind{NOTE, CHECKIN, BOOKMARK, AUDIO, VIDEO} {
+		assert.NotEmpty(t, k.Label())
+		assert.NotEmpty(t, k.PluralLabel())
+	}
+}
+
+func TestUpdateConflict(t *testing.T) {
+	e := InitForTesting(t)
+	ctx := context.Background()
+
+	id, err := e.Insert(ctx, &Entry{Content: "Content.", Title: "Title"})
+	assert.NoError(t, err)
+
+	first, err := e.Get(ctx, id)
+	assert.NoError(t, err)
+	second, err := e.Get(ctx, id)
+	assert.NoError(t, err)
+
+	first.Title = "First edit"
+	assert.NoError(t, e.Update(ctx, first))
+	assert.Equal(t, int64(1), first.Version)
+
+	second.Title = "Second edit"
+	assert.Equal(t, ErrConflict, e.Update(ctx, second))
+
+	stored, err := e.Get(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, "First edit", stored.Title)
+}
+
+func TestGetMultiAndCount(t *testing.T) {
+	e := InitForTesting(t)
+	ctx := context.Background()
+
+	n, err := e.Count(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	id1, err := e.Insert(ctx, &Entry{Content: "One.", Title: "One"})
+	assert.NoError(t, err)
+	id2, err := e.Insert(ctx, &Entry{Content: "Two.", Title: "Two", Visibility: PRIVATE})
+	assert.NoError(t, err)
+
+	n, err = e.Count(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	got, err := e.GetMulti(ctx, []string{id2, "missing", id1})
+	assert.NoError(t, err)
+	assert.Len(t, got, 3)
+	assert.Equal(t, id2, got[0].ID)
+	assert.Equal(t, "Two", got[0].Title)
+	assert.Nil(t, got[1])
+	assert.Equal(t, id1, got[2].ID)
+	assert.Equal(t, "One", got[2].Title)
+}
+
+func TestTags(t *testing.T) {
+	assert.Equal(t, []string{"golang", "indieweb"}, tags("#GoLang is fun. #indieweb\n\nMore #golang."))
+	assert.Equal(t, []string{}, tags("# A heading\n\nA [link](https://example.org/#fragment)."))
+	assert.Equal(t, []string{"café"}, tags("Coffee at the #café"))
+}
+
+func TestSetTags(t *testing.T) {
+	got, err := SetTags("#GoLang is fun. #indieweb\n\nMore #golang.", []string{"golang", "#Web"})
+	assert.NoError(t, err)
+	assert.Equal(t, "#GoLang is fun. indieweb\n\nMore #golang.\n\n#Web", got)
+	assert.Equal(t, []string{"golang", "web"}, tags(got))
+
+	got, err = SetTags("No tags.\n", []string{})
+	assert.NoError(t, err)
+	assert.Equal(t, "No tags.\n", got)
+
+	got, err = SetTags("", []string{"a", "A"})
+	assert.NoError(t, err)
+	assert.Equal(t, "#a", got)
+
+	_, err = SetTags("", []string{"two words"})
+	assert.Error(t, err)
+}
+
+func TestNormalizeURL(t *testing.T) {
+	assert.Equal(t, "example.org/a/b", NormalizeURL("https://www.Example.org/a/b/#top"))
+	assert.Equal(t, "example.org/a/b", NormalizeURL("http://example.org/a/b?utm_source=rss&utm_medium=feed"))
+	assert.Equal(t, "example.org?id=2", NormalizeURL("https://example.org/?id=2&fbclid=abc"))
+	assert.Equal(t, "", NormalizeURL("mailto:joe@example.org"))
+	assert.Equal(t, "", NormalizeURL("/entry/abc"))
+}
+
+func TestURLs(t *testing.T) {
+	assert.Equal(t, []string{"example.com/page", "example.org/article"}, urls(&Entry{
+		Content: "See [this](https://example.org/article). Also https://example.com/page, and <a href=\"https://example.org/article/\">again</a>.",
+	}))
+	assert.Equal(t, []string{"example.org/article"}, urls(&Entry{
+		Kind: BOOKMARK,
+		Link: "https://example.org/article",
+	}))
+	assert.Equal(t, []string{}, urls(&Entry{Content: "No links."}))
+}
+
+func TestAliases(t *testing.T) {
+	e := InitForTesting(t)
+	ctx := context.Background()
+
+	id, err := e.Insert(ctx, &Entry{Content: "Content.", Title: "Title"})
+	assert.NoError(t, err)
+
+	_, err = e.FindByAlias(ctx, "old-slug")
+	assert.Error(t, err)
+
+	assert.NoError(t, e.AddAlias(ctx, id, "old-slug"))
+	assert.Error(t, e.AddAlias(ctx, id, id))
+
+	found, err := e.FindByAlias(ctx, "old-slug")
+	assert.NoError(t, err)
+	assert.Equal(t, id, found)
+
+	// Aliases survive edits.
+	entry, err := e.Get(ctx, id)
+	assert.NoError(t, err)
+	entry.Aliases = nil
+	assert.NoError(t, e.Update(ctx, entry))
+	entry, err = e.Get(ctx, id)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"old-slug"}, entry.Aliases)
+}
+
+func TestThread(t *testing.T) {
+	e := InitForTesting(t)
+	ctx := context.Background()
+
+	root, err := e.Insert(ctx, &Entry{Content: "1/"})
+	assert.NoError(t, err)
+	second, err := e.Insert(ctx, &Entry{Content: "2/", ParentID: root})
+	assert.NoError(t, err)
+	aside, err := e.Insert(ctx, &Entry{Content: "2a/", ParentID: second})
+	assert.NoError(t, err)
+	third, err := e.Insert(ctx, &Entry{Content: "3/", ParentID: root})
+	assert.NoError(t, err)
+
+	thread, err := e.Thread(ctx, root)
+	assert.NoError(t, err)
+	ids := []string{}
+	for _, entry := range thread {
+		ids = append(ids, entry.ID)
+	}
+	assert.Equal(t, []string{second, aside, third}, ids)
+
+	thread, err = e.Thread(ctx, third)
+	assert.NoError(t, err)
+	assert.Empty(t, thread)
+}
+
+func TestImport(t *testing.T) {
+	e := InitForTesting(t)
+	ctx := context.Background()
+
+	created := time.Date(2015, 6, 7, 8, 9, 10, 0, time.UTC)
+	id, isNew, err := e.Import(ctx, &Entry{Content: "Imported #old", Created: created}, "twitter:123")
+	assert.NoError(t, err)
+	assert.True(t, isNew)
+
+	entry, err := e.Get(ctx, id)
+	assert.NoError(t, err)
+	assert.True(t, created.Equal(entry.Created))
+	assert.True(t, created.Equal(entry.Updated))
+	assert.Equal(t, []string{"old"}, entry.Tags)
+	assert.Equal(t, PUBLIC, entry.Visibility)
+
+	found, err := e.FindByAlias(ctx, "twitter:123")
+	assert.NoError(t, err)
+	assert.Equal(t, id, found)
+
+	// Importing again doesn't create a duplicate.
+	again, isNew, err := e.Import(ctx, &Entry{Content: "Imported #old", Created: created}, "twitter:123")
+	assert.NoError(t, err)
+	assert.False(t, isNew)
+	assert.Equal(t, id, again)
+}
@@ -0,0 +1,176 @@
+package entries
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+
+	"github.com/jcgregorio/go-lib/ds"
+)
+
+// READ_COST is the estimated cost in USD of reading one entity, at $0.06 per
+// 100,000 reads.
+const READ_COST = 0.06 / 100000
+
+// BACKGROUND is the handler that operations without one are counted under,
+// e.g. those done by periodic jobs.
+const BACKGROUND = "background"
+
+type handlerKey struct{}
+
+// WithHandler returns a context that attributes the Datastore operations done
+// with it to the named handler.
+func WithHandler(ctx context.Context, handler string) context.Context {
+	return context.WithValue(ctx, handlerKey{}, handler)
+}
+
+func handlerFromContext(ctx context.Context) string {
+	if h, ok := ctx.Value(handlerKey{}).(string); ok && h != "" {
+		return h
+	}
+	return BACKGROUND
+}
+
+// OpStats are the totals for one Entries method called from one handler.
+type OpStats struct {
+	Handler string
+	Op      string
+	Calls   int64
+
+	// Reads is the estimated number of entity reads, which is what
+	// Datastore bills for.
+	Reads int64
+
+	Total time.Duration
+	Max   time.Duration
+}
+
+// Mean returns the mean latency.
+func (o OpStats) Mean() time.Duration {
+	if o.Calls == 0 {
+		return 0
+	}
+	return o.Total / time.Duration(o.Calls)
+}
+
+// Cost returns the estimated cost of the reads in USD.
+func (o OpStats) Cost() float64 {
+	return float64(o.Reads) * READ_COST
+}
+
+// stats counts the Datastore operations done by Entries.
+type stats struct {
+	mutex sync.Mutex
+	since time.Time
+	ops   map[[2]string]*OpStats
+}
+
+func newStats() *stats {
+	return &stats{
+		since: time.Now(),
+		ops:   map[[2]string]*OpStats{},
+	}
+}
+
+// record adds an operation that started at start and read the given number
+// of entities. Every query is billed at least one read, even if it returns
+// nothing.
+func (s *stats) record(ctx context.Context, op string, start time.Time, reads int) {
+	d := time.Since(start)
+	if reads < 1 {
+		reads = 1
+	}
+	handler := handlerFromContext(ctx)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	k := [2]string{handler, op}
+	o, ok := s.ops[k]
+	if !ok {
+		o = &OpStats{Handler: handler, Op: op}
+		s.ops[k] = o
+	}
+	o.Calls++
+	o.Reads += int64(reads)
+	o.Total += d
+	if d > o.Max {
+		o.Max = d
+	}
+}
+
+// snapshot returns a copy of the stats, most reads first, and when counting
+// started.
+func (s *stats) snapshot() ([]OpStats, time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ret := []OpStats{}
+	for _, o := range s.ops {
+		ret = append(ret, *o)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Reads != ret[j].Reads {
+			return ret[i].Reads > ret[j].Reads
+		}
+		if ret[i].Handler != ret[j].Handler {
+			return ret[i].Handler < ret[j].Handler
+		}
+		return ret[i].Op < ret[j].Op
+	})
+	return ret, s.since
+}
+
+func (s *stats) reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.since = time.Now()
+	s.ops = map[[2]string]*OpStats{}
+}
+
+// Stats returns the Datastore operations done since the process started, or
+// since ResetStats, most reads first, and when counting started.
+func (e *Entries) Stats() ([]OpStats, time.Time) {
+	return e.stats.snapshot()
+}
+
+// ResetStats starts counting operations again.
+func (e *Entries) ResetStats() {
+	e.stats.reset()
+}
+
+// KindCount is the size of one kind, from the statistics Datastore keeps,
+// which are updated about once a day.
+type KindCount struct {
+	Kind      string    `datastore:"kind_name"`
+	Count     int64     `datastore:"count"`
+	Bytes     int64     `datastore:"bytes"`
+	Timestamp time.Time `datastore:"timestamp"`
+}
+
+// KindCounts returns the number of entities of each kind in the namespace,
+// largest first.
+func (e *Entries) KindCounts(ctx context.Context) ([]*KindCount, error) {
+	kind := "__Stat_Ns_Kind__"
+	if e.DS.Namespace == "" {
+		kind = "__Stat_Kind__"
+	}
+	ret := []*KindCount{}
+	it := e.DS.Client.Run(ctx, e.DS.NewQuery(ds.Kind(kind)))
+	for {
+		k := &KindCount{}
+		_, err := it.Next(k)
+		if err == iterator.Done {
+			break
+		}
+		// The statistics have more properties than are loaded here.
+		if _, ok := err.(*datastore.ErrFieldMismatch); err != nil && !ok {
+			return nil, fmt.Errorf("Failed while reading kind statistics: %s", err)
+		}
+		ret = append(ret, k)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Count > ret[j].Count })
+	return ret, nil
+}
@@ -0,0 +1,37 @@
+package entries
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	s := newStats()
+	ctx := WithHandler(context.Background(), "GET /")
+	start := time.Now().Add(-10 * time.Millisecond)
+	s.record(ctx, "List", start, 20)
+	s.record(ctx, "List", start, 10)
+	s.record(context.Background(), "Count", start, 0)
+
+	ops, since := s.snapshot()
+	assert.False(t, since.IsZero())
+	assert.Len(t, ops, 2)
+	assert.Equal(t, "GET /", ops[0].Handler)
+	assert.Equal(t, "List", ops[0].Op)
+	assert.Equal(t, int64(2), ops[0].Calls)
+	assert.Equal(t, int64(30), ops[0].Reads)
+	assert.True(t, ops[0].Mean() >= 10*time.Millisecond)
+	assert.InDelta(t, 30*READ_COST, ops[0].Cost(), 1e-12)
+
+	// Queries are billed at least one read.
+	assert.Equal(t, BACKGROUND, ops[1].Handler)
+	assert.Equal(t, int64(1), ops[1].Reads)
+
+	s.reset()
+	ops, _ = s.snapshot()
+	assert.Empty(t, ops)
+	assert.Equal(t, time.Duration(0), OpStats{}.Mean())
+}
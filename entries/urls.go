@@ -0,0 +1,60 @@
+package entries
+
+import (
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// urlRegex matches the http and https URLs in Markdown or HTML content.
+var urlRegex = regexp.MustCompile(`https?://[^\s<>"'()\[\]]+`)
+
+// trackingParams are query parameters that don't change which page a URL is
+// for, so they are dropped by NormalizeURL.
+var trackingParams = map[string]bool{
+	"fbclid": true,
+	"gclid":  true,
+	"ref":    true,
+	"si":     true,
+}
+
+// NormalizeURL returns the form of u that is stored in the URL index, so that
+// URLs for the same page compare equal. The scheme, "www.", fragment,
+// trailing slash, and tracking parameters such as utm_source are dropped. It
+// returns "" if u isn't an absolute http or https URL.
+func NormalizeURL(u string) string {
+	parsed, err := url.Parse(strings.TrimSpace(u))
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return ""
+	}
+	host := strings.TrimPrefix(strings.ToLower(parsed.Host), "www.")
+	query := parsed.Query()
+	for key := range query {
+		if strings.HasPrefix(key, "utm_") || trackingParams[key] {
+			query.Del(key)
+		}
+	}
+	ret := host + strings.TrimSuffix(parsed.EscapedPath(), "/")
+	if len(query) > 0 {
+		ret += "?" + query.Encode()
+	}
+	return ret
+}
+
+// urls returns the distinct normalized URLs the entry links to, including
+// its Link.
+func urls(entry *Entry) []string {
+	ret := []string{}
+	seen := map[string]bool{}
+	for _, u := range append(urlRegex.FindAllString(entry.Content, -1), entry.Link) {
+		// Punctuation that ends a sentence isn't part of the URL.
+		n := NormalizeURL(strings.TrimRight(u, ".,;:!?"))
+		if n != "" && !seen[n] {
+			seen[n] = true
+			ret = append(ret, n)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,130 @@
+// Package export writes entries as individual Markdown files with YAML front
+// matter, zipped, for archiving and for note tools like Obsidian and Day One.
+package export
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jcgregorio/stream-run/entries"
+)
+
+// MAX_SLUG_LENGTH is the most characters of the title used in a slug.
+const MAX_SLUG_LENGTH = 50
+
+var nonSlugRegex = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Slug returns a file name friendly version of the entry's title, or its id
+// if it doesn't have one.
+func Slug(e *entries.Entry) string {
+	s := strings.Trim(nonSlugRegex.ReplaceAllString(strings.ToLower(e.Title), "-"), "-")
+	if len(s) > MAX_SLUG_LENGTH {
+		s = strings.Trim(s[:MAX_SLUG_LENGTH], "-")
+	}
+	if s == "" {
+		return e.ID
+	}
+	return s
+}
+
+// quote returns s as a YAML double quoted scalar, which JSON strings are.
+func quote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+func list(values []string) string {
+	quoted := []string{}
+	for _, v := range values {
+		quoted = append(quoted, quote(v))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// FrontMatter returns the YAML front matter for the entry, including the
+// leading and trailing --- lines.
+func FrontMatter(e *entries.Entry) string {
+	var b strings.Builder
+	b.WriteString("---\n")
+	if e.Title != "" {
+		fmt.Fprintf(&b, "title: %s\n", quote(e.Title))
+	}
+	fmt.Fprintf(&b, "date: %s\n", e.Created.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "updated: %s\n", e.Updated.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "id: %s\n", quote(e.ID))
+	fmt.Fprintf(&b, "slug: %s\n", quote(Slug(e)))
+	if e.Visibility != "" {
+		fmt.Fprintf(&b, "visibility: %s\n", quote(string(e.Visibility)))
+	}
+	if e.Summary != "" {
+		fmt.Fprintf(&b, "summary: %s\n", quote(e.Summary))
+	}
+	if len(e.Tags) > 0 {
+		fmt.Fprintf(&b, "tags: %s\n", list(e.Tags))
+	}
+	if len(e.Syndication) > 0 {
+		fmt.Fprintf(&b, "syndication: %s\n", list(e.Syndication))
+	}
+	if len(e.Aliases) > 0 {
+		fmt.Fprintf(&b, "aliases: %s\n", list(e.Aliases))
+	}
+	if e.Kind == entries.BOOKMARK && e.Link != "" {
+		fmt.Fprintf(&b, "bookmark-of: %s\n", quote(e.Link))
+	}
+	if e.Kind == entries.CHECKIN {
+		fmt.Fprintf(&b, "location: [%g, %g]\n", e.Latitude, e.Longitude)
+		if e.Venue != "" {
+			fmt.Fprintf(&b, "venue: %s\n", quote(e.Venue))
+		}
+	}
+	b.WriteString("---\n")
+	return b.String()
+}
+
+// Markdown writes entries into a zip file, one Markdown file per entry
+// named by date and slug.
+type Markdown struct {
+	zw *zip.Writer
+
+	// names are the file names already used, to avoid collisions.
+	names map[string]bool
+}
+
+func NewMarkdown(w io.Writer) *Markdown {
+	return &Markdown{
+		zw:    zip.NewWriter(w),
+		names: map[string]bool{},
+	}
+}
+
+// Add writes the entry to the zip file.
+func (m *Markdown) Add(e *entries.Entry) error {
+	name := fmt.Sprintf("%s-%s.md", e.Created.UTC().Format("2006-01-02"), Slug(e))
+	if m.names[name] {
+		name = fmt.Sprintf("%s-%s-%s.md", e.Created.UTC().Format("2006-01-02"), Slug(e), e.ID)
+	}
+	m.names[name] = true
+	f, err := m.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: e.Updated,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to add %q: %s", name, err)
+	}
+	content := strings.ReplaceAll(e.Content, "\r\n", "\n")
+	if _, err := io.WriteString(f, FrontMatter(e)+"\n"+content+"\n"); err != nil {
+		return fmt.Errorf("Failed to write %q: %s", name, err)
+	}
+	return nil
+}
+
+// Close finishes writing the zip file.
+func (m *Markdown) Close() error {
+	return m.zw.Close()
+}
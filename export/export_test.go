@@ -0,0 +1,79 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jcgregorio/stream-run/entries"
+)
+
+var created = time.Date(2020, 3, 4, 5, 6, 7, 0, time.UTC)
+
+func TestSlug(t *testing.T) {
+	assert.Equal(t, "hello-world", Slug(&entries.Entry{ID: "abc", Title: "Hello, World!"}))
+	assert.Equal(t, "abc", Slug(&entries.Entry{ID: "abc"}))
+	assert.Equal(t, "abc", Slug(&entries.Entry{ID: "abc", Title: "日本"}))
+}
+
+func TestFrontMatter(t *testing.T) {
+	e := &entries.Entry{
+		ID:          "abc",
+		Title:       `Say "hi"`,
+		Created:     created,
+		Updated:     created,
+		Tags:        []string{"go", "web"},
+		Syndication: []string{"https://example.org/1"},
+	}
+	assert.Equal(t, `---
+title: "Say \"hi\""
+date: 2020-03-04T05:06:07Z
+updated: 2020-03-04T05:06:07Z
+id: "abc"
+slug: "say-hi"
+tags: ["go", "web"]
+syndication: ["https://example.org/1"]
+---
+`, FrontMatter(e))
+
+	e = &entries.Entry{
+		ID:      "def",
+		Title:   "Worth reading",
+		Created: created,
+		Updated: created,
+		Kind:    entries.BOOKMARK,
+		Link:    "https://example.org/article",
+	}
+	assert.Equal(t, `---
+title: "Worth reading"
+date: 2020-03-04T05:06:07Z
+updated: 2020-03-04T05:06:07Z
+id: "def"
+slug: "worth-reading"
+bookmark-of: "https://example.org/article"
+---
+`, FrontMatter(e))
+}
+
+func TestMarkdown(t *testing.T) {
+	var b bytes.Buffer
+	m := NewMarkdown(&b)
+	assert.NoError(t, m.Add(&entries.Entry{ID: "a", Title: "Same", Content: "One\r\n", Created: created, Updated: created}))
+	assert.NoError(t, m.Add(&entries.Entry{ID: "b", Title: "Same", Content: "Two", Created: created, Updated: created}))
+	assert.NoError(t, m.Close())
+
+	r, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	assert.NoError(t, err)
+	assert.Len(t, r.File, 2)
+	assert.Equal(t, "2020-03-04-same.md", r.File[0].Name)
+	assert.Equal(t, "2020-03-04-same-b.md", r.File[1].Name)
+	f, err := r.File[0].Open()
+	assert.NoError(t, err)
+	body, err := ioutil.ReadAll(f)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), "---\n\nOne\n\n")
+}
@@ -0,0 +1,177 @@
+// Package features turns subsystems on and off per deployment. Each feature
+// is on unless turned off in the config, and the config can be overridden
+// from the admin page, with the overrides stored in Datastore.
+package features
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+
+	"github.com/jcgregorio/go-lib/ds"
+	"github.com/jcgregorio/slog"
+)
+
+const (
+	FEATURE ds.Kind = "Feature"
+
+	// CACHE_FOR is how long overrides are used from memory before being read
+	// again, which is also how long an override takes to reach other
+	// instances.
+	CACHE_FOR = time.Minute
+)
+
+// Feature names.
+const (
+	// WEBMENTIONS is sending webmentions to the pages entries link to.
+	WEBMENTIONS = "webmentions"
+
+	// SYNDICATION is sending webmentions to the BRIDGES, which publishes
+	// entries elsewhere.
+	SYNDICATION = "syndication"
+
+	// WEBSUB is pinging the WebSub hub when an entry is published.
+	WEBSUB = "websub"
+
+	// BRIDGE_LINKS is displaying the links to the BRIDGES on entries.
+	BRIDGE_LINKS = "bridge_links"
+
+	// PUSH is sending Web Push notifications of new entries.
+	PUSH = "push"
+)
+
+// Feature describes a feature.
+type Feature struct {
+	Name        string
+	Description string
+}
+
+// All is every feature, in the order they are displayed.
+var All = []Feature{
+	{Name: WEBMENTIONS, Description: "Send webmentions to linked pages."},
+	{Name: SYNDICATION, Description: "Send webmentions to the bridges, which syndicates entries."},
+	{Name: WEBSUB, Description: "Ping the WebSub hub on publish."},
+	{Name: BRIDGE_LINKS, Description: "Show links to the bridges on entries."},
+	{Name: PUSH, Description: "Send push notifications of new entries."},
+}
+
+// Known returns true if name is one of All.
+func Known(name string) bool {
+	for _, f := range All {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Override is a feature turned on or off from the admin page.
+type Override struct {
+	Enabled bool      `datastore:"enabled,noindex"`
+	Updated time.Time `datastore:"updated,noindex"`
+}
+
+type Features struct {
+	DS  *ds.DS
+	log slog.Logger
+
+	// configured returns if a feature is on in the config.
+	configured func(name string) bool
+
+	// now is replaceable for testing.
+	now func() time.Time
+
+	mutex     sync.Mutex
+	overrides map[string]bool
+	read      time.Time
+}
+
+// New returns a Features whose defaults come from configured.
+func New(ctx context.Context, project, ns string, configured func(name string) bool, log slog.Logger) (*Features, error) {
+	d, err := ds.New(ctx, project, ns)
+	if err != nil {
+		return nil, err
+	}
+	return &Features{
+		DS:         d,
+		log:        log,
+		configured: configured,
+		now:        time.Now,
+	}, nil
+}
+
+func (f *Features) key(name string) *datastore.Key {
+	key := f.DS.NewKey(FEATURE)
+	key.Name = name
+	return key
+}
+
+// Overrides returns the features turned on or off from the admin page.
+func (f *Features) Overrides(ctx context.Context) (map[string]bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.overrides != nil && f.now().Sub(f.read) < CACHE_FOR {
+		return f.overrides, nil
+	}
+	overrides := map[string]bool{}
+	it := f.DS.Client.Run(ctx, f.DS.NewQuery(FEATURE))
+	for {
+		o := &Override{}
+		key, err := it.Next(o)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed while reading features: %s", err)
+		}
+		overrides[key.Name] = o.Enabled
+	}
+	f.overrides = overrides
+	f.read = f.now()
+	return overrides, nil
+}
+
+// Configured returns if the feature is on in the config, ignoring overrides.
+func (f *Features) Configured(name string) bool {
+	return f.configured(name)
+}
+
+// Enabled returns if the feature is on.
+func (f *Features) Enabled(ctx context.Context, name string) bool {
+	overrides, err := f.Overrides(ctx)
+	if err != nil {
+		f.log.Warningf("Failed to read feature overrides: %s", err)
+	} else if enabled, ok := overrides[name]; ok {
+		return enabled
+	}
+	return f.configured(name)
+}
+
+// Set overrides the config for the feature.
+func (f *Features) Set(ctx context.Context, name string, enabled bool) error {
+	if !Known(name) {
+		return fmt.Errorf("Unknown feature %q.", name)
+	}
+	if _, err := f.DS.Client.Put(ctx, f.key(name), &Override{Enabled: enabled, Updated: f.now()}); err != nil {
+		return fmt.Errorf("Failed to store feature: %s", err)
+	}
+	f.mutex.Lock()
+	f.overrides = nil
+	f.mutex.Unlock()
+	return nil
+}
+
+// Clear removes the override for the feature, so the config applies again.
+func (f *Features) Clear(ctx context.Context, name string) error {
+	if err := f.DS.Client.Delete(ctx, f.key(name)); err != nil {
+		return fmt.Errorf("Failed to clear feature: %s", err)
+	}
+	f.mutex.Lock()
+	f.overrides = nil
+	f.mutex.Unlock()
+	return nil
+}
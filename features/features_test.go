@@ -0,0 +1,51 @@
+package features
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jcgregorio/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnabled_CachedOverrides(t *testing.T) {
+	f := &Features{
+		log:        logger.New(),
+		configured: func(name string) bool { return name != WEBSUB },
+		now:        time.Now,
+		overrides:  map[string]bool{PUSH: false, WEBSUB: true},
+		read:       time.Now(),
+	}
+	ctx := context.Background()
+	assert.True(t, f.Enabled(ctx, WEBMENTIONS))
+	assert.False(t, f.Enabled(ctx, PUSH))
+	assert.True(t, f.Enabled(ctx, WEBSUB))
+	assert.False(t, f.Configured(WEBSUB))
+}
+
+func TestKnown(t *testing.T) {
+	assert.True(t, Known(SYNDICATION))
+	assert.False(t, Known("time_travel"))
+}
+
+func TestFeatures(t *testing.T) {
+	if os.Getenv("DATASTORE_EMULATOR_HOST") == "" {
+		t.Skip("Requires a running Cloud Datastore emulator, see entries/entries_test.go.")
+	}
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	f, err := New(context.Background(), "test-project", fmt.Sprintf("test-namespace-%d", r.Uint64()), func(string) bool { return true }, logger.New())
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	assert.True(t, f.Enabled(ctx, WEBSUB))
+	assert.NoError(t, f.Set(ctx, WEBSUB, false))
+	assert.False(t, f.Enabled(ctx, WEBSUB))
+	assert.Error(t, f.Set(ctx, "time_travel", false))
+
+	assert.NoError(t, f.Clear(ctx, WEBSUB))
+	assert.True(t, f.Enabled(ctx, WEBSUB))
+}
@@ -0,0 +1,371 @@
+// Package graphql is a small, read-only GraphQL server. It parses queries,
+// including variables, aliases, fragments, and the @include and @skip
+// directives, and executes them against Objects whose fields are resolved by
+// Go functions. Mutations, subscriptions, and introspection beyond
+// __typename aren't supported.
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MAX_DEPTH is how deeply selections may be nested, so a query can't make
+// the server do unbounded work.
+const MAX_DEPTH = 10
+
+// Resolver returns the value of a field of source, which is the value that
+// was resolved for the object the field belongs to, or nil for the Query.
+// Values of scalar fields are marshalled as JSON. Values of object fields are
+// passed to the resolvers of the object's fields, and for a List field must
+// be a []interface{} of them. A nil value is null.
+type Resolver func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error)
+
+// Field is a field of an Object.
+type Field struct {
+	// Type is the Object the field's value is, or nil for scalars.
+	Type *Object
+
+	// List is true if the field's value is a list of Type.
+	List bool
+
+	Resolve Resolver
+}
+
+// Object is a GraphQL object type.
+type Object struct {
+	Name   string
+	Fields map[string]*Field
+}
+
+// Schema is the types a query is executed against.
+type Schema struct {
+	Query *Object
+}
+
+// Error is an error in a Response, where Path is the response keys and list
+// indices of the field that failed, if any.
+type Error struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// Response is the result of executing a query.
+type Response struct {
+	Data   interface{} `json:"data"`
+	Errors []*Error    `json:"errors,omitempty"`
+}
+
+// Request is a query as POST'd as JSON or sent as GET parameters.
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// object is a JSON object whose keys are in the order of the selections, as
+// GraphQL requires.
+type object struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o *object) set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *object) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString("{")
+	for i, key := range o.keys {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(k)
+		b.WriteString(":")
+		b.Write(v)
+	}
+	b.WriteString("}")
+	return b.Bytes(), nil
+}
+
+// Execute runs the operation of the request, whose OperationName can be ""
+// if the query only has one.
+func (s *Schema) Execute(ctx context.Context, req *Request) *Response {
+	failed := func(err error) *Response {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+	doc, err := parse(req.Query)
+	if err != nil {
+		return failed(err)
+	}
+	var op *operation
+	for _, o := range doc.operations {
+		if o.name == req.OperationName || (req.OperationName == "" && len(doc.operations) == 1) {
+			op = o
+		}
+	}
+	if op == nil {
+		if req.OperationName == "" {
+			return failed(fmt.Errorf("The operation to run must be named."))
+		}
+		return failed(fmt.Errorf("Unknown operation %q.", req.OperationName))
+	}
+	if op.kind != "query" {
+		return failed(fmt.Errorf("Only queries are supported, not %s.", op.kind))
+	}
+	vars := map[string]interface{}{}
+	for _, def := range op.variables {
+		v, ok := req.Variables[def.name]
+		if !ok && def.hasDefault {
+			v, ok = def.def, true
+		}
+		if v == nil && def.nonNull {
+			return failed(fmt.Errorf("Variable $%s is required.", def.name))
+		}
+		if ok {
+			vars[def.name] = v
+		}
+	}
+	e := &executor{
+		ctx:       ctx,
+		doc:       doc,
+		variables: vars,
+	}
+	data := e.selectionSet(s.Query, nil, op.selections, nil, 1)
+	if e.invalid {
+		return &Response{Errors: e.errors}
+	}
+	return &Response{Data: data, Errors: e.errors}
+}
+
+// executor is the state of one execution.
+type executor struct {
+	ctx       context.Context
+	doc       *document
+	variables map[string]interface{}
+	errors    []*Error
+
+	// invalid is true if the query itself is wrong, rather than a resolver
+	// failing, in which case there is no data.
+	invalid bool
+}
+
+func (e *executor) fail(path []interface{}, invalid bool, format string, args ...interface{}) {
+	e.errors = append(e.errors, &Error{
+		Message: fmt.Sprintf(format, args...),
+		Path:    append([]interface{}{}, path...),
+	})
+	e.invalid = e.invalid || invalid
+}
+
+// value replaces the variables in an argument value with their values.
+func (e *executor) value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case variable:
+		return e.variables[string(v)]
+	case enum:
+		return string(v)
+	case []interface{}:
+		ret := make([]interface{}, len(v))
+		for i, item := range v {
+			ret[i] = e.value(item)
+		}
+		return ret
+	case map[string]interface{}:
+		ret := map[string]interface{}{}
+		for k, item := range v {
+			ret[k] = e.value(item)
+		}
+		return ret
+	}
+	return v
+}
+
+// included returns false if the directives skip a selection.
+func (e *executor) included(directives []*directive) bool {
+	for _, d := range directives {
+		cond, _ := e.value(d.args["if"]).(bool)
+		if (d.name == "skip" && cond) || (d.name == "include" && !cond) {
+			return false
+		}
+	}
+	return true
+}
+
+// collect appends the fields in sels that apply to obj to fields, by response
+// key, and their keys to keys in the order they are first selected.
+func (e *executor) collect(obj *Object, sels []selection, keys []string, fields map[string][]*field, visited map[string]bool) []string {
+	for _, sel := range sels {
+		switch sel := sel.(type) {
+		case *field:
+			if !e.included(sel.directives) {
+				continue
+			}
+			if _, ok := fields[sel.key()]; !ok {
+				keys = append(keys, sel.key())
+			}
+			fields[sel.key()] = append(fields[sel.key()], sel)
+		case *fragmentSpread:
+			if visited[sel.name] || !e.included(sel.directives) {
+				continue
+			}
+			visited[sel.name] = true
+			frag, ok := e.doc.fragments[sel.name]
+			if !ok {
+				e.fail(nil, true, "Unknown fragment %q.", sel.name)
+				continue
+			}
+			if frag.typeCondition == obj.Name {
+				keys = e.collect(obj, frag.selections, keys, fields, visited)
+			}
+		case *inlineFragment:
+			if !e.included(sel.directives) || (sel.typeCondition != "" && sel.typeCondition != obj.Name) {
+				continue
+			}
+			keys = e.collect(obj, sel.selections, keys, fields, visited)
+		}
+	}
+	return keys
+}
+
+// selectionSet returns the selected fields of source, which is a value of
+// obj.
+func (e *executor) selectionSet(obj *Object, source interface{}, sels []selection, path []interface{}, depth int) *object {
+	if depth > MAX_DEPTH {
+		e.fail(path, true, "The query is nested more than %d levels deep.", MAX_DEPTH)
+		return nil
+	}
+	fields := map[string][]*field{}
+	keys := e.collect(obj, sels, nil, fields, map[string]bool{})
+	ret := &object{values: map[string]interface{}{}}
+	for _, key := range keys {
+		f := fields[key][0]
+		fieldPath := append(append([]interface{}{}, path...), key)
+		if f.name == "__typename" {
+			ret.set(key, obj.Name)
+			continue
+		}
+		def, ok := obj.Fields[f.name]
+		if !ok {
+			e.fail(fieldPath, true, "Cannot query field %q on type %q.", f.name, obj.Name)
+			continue
+		}
+		args := map[string]interface{}{}
+		for name, v := range f.args {
+			args[name] = e.value(v)
+		}
+		// Fields selected more than once with the same key are merged.
+		var sub []selection
+		for _, same := range fields[key] {
+			sub = append(sub, same.selections...)
+		}
+		if def.Type == nil && len(sub) > 0 {
+			e.fail(fieldPath, true, "Field %q of %q is a scalar and can't have selections.", f.name, obj.Name)
+			continue
+		}
+		if def.Type != nil && len(sub) == 0 {
+			e.fail(fieldPath, true, "Field %q of %q must have selections.", f.name, obj.Name)
+			continue
+		}
+		value, err := def.Resolve(e.ctx, source, args)
+		if err != nil {
+			e.fail(fieldPath, false, "%s", err)
+			ret.set(key, nil)
+			continue
+		}
+		ret.set(key, e.complete(def, value, sub, fieldPath, depth))
+	}
+	return ret
+}
+
+// complete returns the value of a field in the response.
+func (e *executor) complete(def *Field, value interface{}, sub []selection, path []interface{}, depth int) interface{} {
+	if value == nil || def.Type == nil {
+		return value
+	}
+	if !def.List {
+		return e.selectionSet(def.Type, value, sub, path, depth+1)
+	}
+	items, ok := value.([]interface{})
+	if !ok {
+		e.fail(path, false, "Resolved a %T for a list.", value)
+		return nil
+	}
+	ret := make([]interface{}, len(items))
+	for i, item := range items {
+		if item != nil {
+			ret[i] = e.selectionSet(def.Type, item, sub, append(append([]interface{}{}, path...), i), depth+1)
+		}
+	}
+	return ret
+}
+
+// Int returns the argument name as an int, or def if it wasn't given.
+func Int(args map[string]interface{}, name string, def int) (int, error) {
+	switch v := args[name].(type) {
+	case nil:
+		return def, nil
+	case int:
+		return v, nil
+	case float64:
+		// Variables are decoded from JSON as float64.
+		if v != math.Trunc(v) || math.Abs(v) > math.MaxInt32 {
+			return 0, fmt.Errorf("Argument %q must be an integer.", name)
+		}
+		return int(v), nil
+	}
+	return 0, fmt.Errorf("Argument %q must be an integer.", name)
+}
+
+// String returns the argument name as a string, or def if it wasn't given.
+func String(args map[string]interface{}, name string, def string) (string, error) {
+	switch v := args[name].(type) {
+	case nil:
+		return def, nil
+	case string:
+		return v, nil
+	}
+	return "", fmt.Errorf("Argument %q must be a string.", name)
+}
+
+// cursorPrefix is prepended to offsets before they are encoded as cursors,
+// so that cursors are opaque.
+const cursorPrefix = "offset:"
+
+// Cursor returns the opaque cursor of the item at offset in a list.
+func Cursor(offset int) string {
+	return base64.StdEncoding.EncodeToString([]byte(cursorPrefix + strconv.Itoa(offset)))
+}
+
+// Offset returns the offset of the item after the one at cursor, or 0 if
+// cursor is "", for paginating with an "after" argument.
+func Offset(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(cursor)
+	if err == nil && strings.HasPrefix(string(b), cursorPrefix) {
+		if n, err := strconv.Atoi(strings.TrimPrefix(string(b), cursorPrefix)); err == nil && n >= 0 {
+			return n + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid cursor %q.", cursor)
+}
@@ -0,0 +1,169 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type post struct {
+	ID    string
+	Title string
+	Tags  []string
+}
+
+var posts = []*post{
+	{ID: "a", Title: "First", Tags: []string{"go"}},
+	{ID: "b", Title: "Second"},
+	{ID: "c", Title: "Third", Tags: []string{"go", "web"}},
+}
+
+func testSchema() *Schema {
+	postType := &Object{Name: "Post"}
+	postType.Fields = map[string]*Field{
+		"id": {Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(*post).ID, nil
+		}},
+		"title": {Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(*post).Title, nil
+		}},
+		"tags": {Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source.(*post).Tags, nil
+		}},
+		"related": {Type: postType, Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return source, nil
+		}},
+		"broken": {Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return nil, fmt.Errorf("Broken.")
+		}},
+	}
+	query := &Object{Name: "Query", Fields: map[string]*Field{
+		"posts": {Type: postType, List: true, Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			first, err := Int(args, "first", len(posts))
+			if err != nil {
+				return nil, err
+			}
+			offset, err := Offset(args["after"].(string))
+			if err != nil {
+				return nil, err
+			}
+			ret := []interface{}{}
+			for i := offset; i < len(posts) && len(ret) < first; i++ {
+				ret = append(ret, posts[i])
+			}
+			return ret, nil
+		}},
+		"post": {Type: postType, Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			id, err := String(args, "id", "")
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range posts {
+				if p.ID == id {
+					return p, nil
+				}
+			}
+			return nil, nil
+		}},
+	}}
+	return &Schema{Query: query}
+}
+
+func execute(t *testing.T, req *Request) string {
+	b, err := json.Marshal(testSchema().Execute(context.Background(), req))
+	assert.NoError(t, err)
+	return string(b)
+}
+
+func TestExecute(t *testing.T) {
+	assert.Equal(t, `{"data":{"post":{"title":"First","id":"a","__typename":"Post"},"missing":null}}`,
+		execute(t, &Request{Query: `{ post(id: "a") { title, id __typename } missing: post(id: "z") { id } }`}))
+
+	// Variables, defaults, aliases, and cursors.
+	assert.Equal(t, `{"data":{"page":[{"id":"b"}]}}`,
+		execute(t, &Request{
+			Query:     `query Page($first: Int = 1, $after: String!) { page: posts(first: $first, after: $after) { id } }`,
+			Variables: map[string]interface{}{"after": Cursor(0)},
+		}))
+
+	// Fragments and directives.
+	assert.Equal(t, `{"data":{"post":{"id":"c","tags":["go","web"],"title":"Third"}}}`,
+		execute(t, &Request{
+			Query: `
+# Comments are ignored.
+query Q($withTitle: Boolean!) {
+  post(id: "c") {
+    ...Basic
+    ... on Post @include(if: $withTitle) { title }
+    ... @skip(if: true) { broken }
+  }
+}
+fragment Basic on Post { id tags }`,
+			OperationName: "Q",
+			Variables:     map[string]interface{}{"withTitle": true},
+		}))
+
+	// Resolver errors null the field and have a path.
+	assert.Equal(t, `{"data":{"posts":[{"id":"a","broken":null}]},"errors":[{"message":"Broken.","path":["posts",0,"broken"]}]}`,
+		execute(t, &Request{Query: `{ posts(first: 1, after: "") { id broken } }`}))
+}
+
+func TestExecute_Invalid(t *testing.T) {
+	for query, message := range map[string]string{
+		`{ post(id: "a") { nope } }`:                        `Cannot query field "nope" on type "Post".`,
+		`{ post(id: "a") }`:                                 `Field "post" of "Query" must have selections.`,
+		`{ post(id: "a") { id { x } } }`:                    `Field "id" of "Post" is a scalar and can't have selections.`,
+		`mutation { post(id: "a") { id } }`:                 `Only queries are supported, not mutation.`,
+		`{ post(id: "a" { id } }`:                           `Unexpected "{" at 15.`,
+		`query A { posts { id } } query B { posts { id } }`: `The operation to run must be named.`,
+		`query ($id: String!) { post(id: $id) { id } }`:     `Variable $id is required.`,
+		`{ post(id: "a") { ...Missing } }`:                  `Unknown fragment "Missing".`,
+	} {
+		resp := testSchema().Execute(context.Background(), &Request{Query: query})
+		assert.Nil(t, resp.Data, query)
+		if assert.Len(t, resp.Errors, 1, query) {
+			assert.Equal(t, message, resp.Errors[0].Message, query)
+		}
+	}
+}
+
+func TestExecute_Depth(t *testing.T) {
+	query := "id"
+	for i := 2; i < MAX_DEPTH; i++ {
+		query = "related { " + query + " }"
+	}
+	assert.Contains(t, execute(t, &Request{Query: `{ post(id: "a") { ` + query + ` } }`}), `"data":{"post":{"related":`)
+	assert.Equal(t, `{"data":null,"errors":[{"message":"The query is nested more than 10 levels deep.","path":["post","related","related","related","related","related","related","related","related","related"]}]}`,
+		execute(t, &Request{Query: `{ post(id: "a") { related { ` + query + ` } } }`}))
+
+	// Fragments that spread themselves don't recurse forever.
+	assert.Equal(t, `{"data":{"post":{"id":"a"}}}`,
+		execute(t, &Request{Query: `{ post(id: "a") { ...F } } fragment F on Post { ...F id }`}))
+}
+
+func TestCursor(t *testing.T) {
+	n, err := Offset(Cursor(41))
+	assert.NoError(t, err)
+	assert.Equal(t, 42, n)
+	n, err = Offset("")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	_, err = Offset("bm90IGEgY3Vyc29y")
+	assert.Error(t, err)
+}
+
+func TestInt(t *testing.T) {
+	n, err := Int(map[string]interface{}{"n": float64(3)}, "n", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	n, err = Int(map[string]interface{}{}, "n", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	_, err = Int(map[string]interface{}{"n": 1.5}, "n", 1)
+	assert.Error(t, err)
+	_, err = Int(map[string]interface{}{"n": "1"}, "n", 1)
+	assert.Error(t, err)
+}
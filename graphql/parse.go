@@ -0,0 +1,602 @@
+package graphql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+// lexer splits a query into tokens, skipping whitespace, commas, and
+// comments, which GraphQL ignores.
+type lexer struct {
+	src string
+	pos int
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		if c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',' {
+			l.pos++
+			continue
+		}
+		if c == '#' {
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		break
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+	c := l.src[l.pos]
+	switch {
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.pos += 3
+		return token{kind: tokPunct, value: "...", pos: start}, nil
+	case strings.IndexByte("!$():=@[]{}|", c) >= 0:
+		l.pos++
+		return token{kind: tokPunct, value: string(c), pos: start}, nil
+	case isNameStart(c):
+		for l.pos < len(l.src) && (isNameStart(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokName, value: l.src[start:l.pos], pos: start}, nil
+	case c == '-' || isDigit(c):
+		return l.number()
+	case c == '"':
+		return l.string()
+	}
+	return token{}, fmt.Errorf("Unexpected character %q at %d.", c, start)
+}
+
+func (l *lexer) digits() int {
+	start := l.pos
+	for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+		l.pos++
+	}
+	return l.pos - start
+}
+
+func (l *lexer) number() (token, error) {
+	start := l.pos
+	kind := tokInt
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	if l.digits() == 0 {
+		return token{}, fmt.Errorf("Invalid number at %d.", start)
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		kind = tokFloat
+		l.pos++
+		if l.digits() == 0 {
+			return token{}, fmt.Errorf("Invalid number at %d.", start)
+		}
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		kind = tokFloat
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		if l.digits() == 0 {
+			return token{}, fmt.Errorf("Invalid number at %d.", start)
+		}
+	}
+	return token{kind: kind, value: l.src[start:l.pos], pos: start}, nil
+}
+
+func (l *lexer) string() (token, error) {
+	start := l.pos
+	if strings.HasPrefix(l.src[l.pos:], `"""`) {
+		end := strings.Index(l.src[l.pos+3:], `"""`)
+		if end < 0 {
+			return token{}, fmt.Errorf("Unterminated string at %d.", start)
+		}
+		value := l.src[l.pos+3 : l.pos+3+end]
+		l.pos += end + 6
+		return token{kind: tokString, value: strings.TrimSpace(value), pos: start}, nil
+	}
+	l.pos++
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case '\\':
+			l.pos += 2
+			continue
+		case '\n', '\r':
+			return token{}, fmt.Errorf("Unterminated string at %d.", start)
+		case '"':
+			l.pos++
+			// GraphQL strings have the same escapes as JSON strings.
+			var value string
+			if err := json.Unmarshal([]byte(l.src[start:l.pos]), &value); err != nil {
+				return token{}, fmt.Errorf("Invalid string at %d: %s", start, err)
+			}
+			return token{kind: tokString, value: value, pos: start}, nil
+		}
+		l.pos++
+	}
+	return token{}, fmt.Errorf("Unterminated string at %d.", start)
+}
+
+// document is a parsed query.
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	kind       string
+	name       string
+	variables  []*variableDef
+	selections []selection
+}
+
+type variableDef struct {
+	name       string
+	nonNull    bool
+	def        interface{}
+	hasDefault bool
+}
+
+// selection is a *field, *fragmentSpread, or *inlineFragment.
+type selection interface{}
+
+type field struct {
+	alias      string
+	name       string
+	args       map[string]interface{}
+	directives []*directive
+	selections []selection
+}
+
+// key returns the name of the field in the response.
+func (f *field) key() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+type fragmentSpread struct {
+	name       string
+	directives []*directive
+}
+
+type inlineFragment struct {
+	typeCondition string
+	directives    []*directive
+	selections    []selection
+}
+
+type fragment struct {
+	typeCondition string
+	selections    []selection
+}
+
+type directive struct {
+	name string
+	args map[string]interface{}
+}
+
+// variable is a reference to a variable in an argument value.
+type variable string
+
+// enum is an enum value in an argument, which resolvers see as a string.
+type enum string
+
+// parser is a recursive descent parser of GraphQL queries.
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+func parse(query string) (*document, error) {
+	p := &parser{lex: &lexer{src: query}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	doc := &document{fragments: map[string]*fragment{}}
+	for p.tok.kind != tokEOF {
+		if p.tok.kind == tokName && p.tok.value == "fragment" {
+			name, frag, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.fragments[name]; ok {
+				return nil, fmt.Errorf("Fragment %q is defined more than once.", name)
+			}
+			doc.fragments[name] = frag
+			continue
+		}
+		op, err := p.operation()
+		if err != nil {
+			return nil, err
+		}
+		doc.operations = append(doc.operations, op)
+	}
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("The query has no operations.")
+	}
+	return doc, nil
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+// is returns true if the current token is the punctuator s.
+func (p *parser) is(s string) bool {
+	return p.tok.kind == tokPunct && p.tok.value == s
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokEOF {
+		return fmt.Errorf("Unexpected end of query.")
+	}
+	return fmt.Errorf("Unexpected %q at %d.", p.tok.value, p.tok.pos)
+}
+
+func (p *parser) expect(s string) error {
+	if !p.is(s) {
+		return p.unexpected()
+	}
+	return p.advance()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokName {
+		return "", p.unexpected()
+	}
+	name := p.tok.value
+	return name, p.advance()
+}
+
+func (p *parser) operation() (*operation, error) {
+	op := &operation{kind: "query"}
+	if p.tok.kind == tokName {
+		op.kind = p.tok.value
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind == tokName {
+			op.name = p.tok.value
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		}
+		if p.is("(") {
+			vars, err := p.variableDefs()
+			if err != nil {
+				return nil, err
+			}
+			op.variables = vars
+		}
+		if _, err := p.directives(); err != nil {
+			return nil, err
+		}
+	}
+	sels, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.selections = sels
+	return op, nil
+}
+
+func (p *parser) variableDefs() ([]*variableDef, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	ret := []*variableDef{}
+	for !p.is(")") {
+		if err := p.expect("$"); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		nonNull, err := p.typeRef()
+		if err != nil {
+			return nil, err
+		}
+		def := &variableDef{name: name, nonNull: nonNull}
+		if p.is("=") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if def.def, err = p.value(true); err != nil {
+				return nil, err
+			}
+			def.hasDefault = true
+		}
+		ret = append(ret, def)
+	}
+	return ret, p.advance()
+}
+
+// typeRef parses a type like "Int", "[String!]", or "ID!", and returns
+// whether it is non-null.
+func (p *parser) typeRef() (bool, error) {
+	if p.is("[") {
+		if err := p.advance(); err != nil {
+			return false, err
+		}
+		if _, err := p.typeRef(); err != nil {
+			return false, err
+		}
+		if err := p.expect("]"); err != nil {
+			return false, err
+		}
+	} else if _, err := p.name(); err != nil {
+		return false, err
+	}
+	if p.is("!") {
+		return true, p.advance()
+	}
+	return false, nil
+}
+
+func (p *parser) directives() ([]*directive, error) {
+	ret := []*directive{}
+	for p.is("@") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		args, err := p.arguments()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, &directive{name: name, args: args})
+	}
+	return ret, nil
+}
+
+func (p *parser) selectionSet() ([]selection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	ret := []selection{}
+	for !p.is("}") {
+		sel, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, sel)
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("Empty selection at %d.", p.tok.pos)
+	}
+	return ret, p.advance()
+}
+
+func (p *parser) selection() (selection, error) {
+	if p.is("...") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if p.tok.kind == tokName && p.tok.value != "on" {
+			spread := &fragmentSpread{name: p.tok.value}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			var err error
+			spread.directives, err = p.directives()
+			return spread, err
+		}
+		inline := &inlineFragment{}
+		if p.tok.kind == tokName {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			var err error
+			if inline.typeCondition, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		var err error
+		if inline.directives, err = p.directives(); err != nil {
+			return nil, err
+		}
+		inline.selections, err = p.selectionSet()
+		return inline, err
+	}
+	f := &field{}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	f.name = name
+	if p.is(":") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		f.alias = name
+		if f.name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if f.args, err = p.arguments(); err != nil {
+		return nil, err
+	}
+	if f.directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if p.is("{") {
+		if f.selections, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (p *parser) arguments() (map[string]interface{}, error) {
+	ret := map[string]interface{}{}
+	if !p.is("(") {
+		return ret, nil
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	for !p.is(")") {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if ret[name], err = p.value(false); err != nil {
+			return nil, err
+		}
+	}
+	return ret, p.advance()
+}
+
+// value parses an argument value, which can't refer to variables if it is
+// constant, as in the default value of a variable.
+func (p *parser) value(constant bool) (interface{}, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokInt:
+		n, err := strconv.Atoi(tok.value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid integer %q at %d.", tok.value, tok.pos)
+		}
+		return n, p.advance()
+	case tokFloat:
+		f, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid float %q at %d.", tok.value, tok.pos)
+		}
+		return f, p.advance()
+	case tokString:
+		return tok.value, p.advance()
+	case tokName:
+		var v interface{}
+		switch tok.value {
+		case "true":
+			v = true
+		case "false":
+			v = false
+		case "null":
+			v = nil
+		default:
+			v = enum(tok.value)
+		}
+		return v, p.advance()
+	}
+	switch {
+	case p.is("$") && !constant:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		return variable(name), err
+	case p.is("["):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		ret := []interface{}{}
+		for !p.is("]") {
+			v, err := p.value(constant)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, v)
+		}
+		return ret, p.advance()
+	case p.is("{"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		ret := map[string]interface{}{}
+		for !p.is("}") {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if ret[name], err = p.value(constant); err != nil {
+				return nil, err
+			}
+		}
+		return ret, p.advance()
+	}
+	return nil, p.unexpected()
+}
+
+func (p *parser) fragment() (string, *fragment, error) {
+	if err := p.advance(); err != nil {
+		return "", nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return "", nil, err
+	}
+	if name == "on" {
+		return "", nil, fmt.Errorf("A fragment can't be named \"on\".")
+	}
+	if p.tok.kind != tokName || p.tok.value != "on" {
+		return "", nil, p.unexpected()
+	}
+	if err := p.advance(); err != nil {
+		return "", nil, err
+	}
+	frag := &fragment{}
+	if frag.typeCondition, err = p.name(); err != nil {
+		return "", nil, err
+	}
+	if _, err := p.directives(); err != nil {
+		return "", nil, err
+	}
+	if frag.selections, err = p.selectionSet(); err != nil {
+		return "", nil, err
+	}
+	return name, frag, nil
+}
@@ -0,0 +1,320 @@
+// Package importer reads posts from Twitter and Mastodon archive exports so
+// they can be imported as entries.
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Visibility of an imported post, matching entries.Visibility.
+const (
+	PUBLIC   = "public"
+	UNLISTED = "unlisted"
+	PRIVATE  = "private"
+)
+
+// Media is a file attached to a post.
+type Media struct {
+	// Name is the file name, unique within the archive.
+	Name string
+	Alt  string
+
+	// Open returns the contents of the file, or nil if the archive doesn't
+	// include the file.
+	Open func() (io.ReadCloser, error)
+}
+
+// Sources of posts.
+const (
+	TWITTER  = "twitter"
+	MASTODON = "mastodon"
+)
+
+// Post is a single post from an archive.
+type Post struct {
+	Source string
+
+	// ID is the post's id on the original site.
+	ID      string
+	URL     string
+	Created time.Time
+
+	// Content is Markdown, which may include HTML.
+	Content    string
+	Summary    string
+	Visibility string
+
+	// InReplyToID is the id of the post this one replies to, if it's in the
+	// same archive, and InReplyToURL is the URL of the post replied to.
+	InReplyToID  string
+	InReplyToURL string
+
+	Media []*Media
+}
+
+// Alias returns the identifier of the original post, which is recorded on
+// the imported entry so it isn't imported twice.
+func (p *Post) Alias() string {
+	return p.Source + ":" + p.ID
+}
+
+// sortPosts sorts posts oldest first, so replies come after the posts they
+// reply to.
+func sortPosts(posts []*Post) {
+	sort.SliceStable(posts, func(i, j int) bool { return posts[i].Created.Before(posts[j].Created) })
+}
+
+// zipFiles indexes the files in a zip archive by name.
+func zipFiles(zr *zip.Reader) map[string]*zip.File {
+	ret := map[string]*zip.File{}
+	for _, f := range zr.File {
+		ret[f.Name] = f
+	}
+	return ret
+}
+
+type tweetURL struct {
+	URL         string `json:"url"`
+	ExpandedURL string `json:"expanded_url"`
+}
+
+type tweetMedia struct {
+	URL           string `json:"url"`
+	MediaURLHTTPS string `json:"media_url_https"`
+	Type          string `json:"type"`
+}
+
+type tweet struct {
+	IDStr                string `json:"id_str"`
+	FullText             string `json:"full_text"`
+	CreatedAt            string `json:"created_at"`
+	InReplyToStatusIDStr string `json:"in_reply_to_status_id_str"`
+	InReplyToScreenName  string `json:"in_reply_to_screen_name"`
+	Entities             struct {
+		URLs []tweetURL `json:"urls"`
+	} `json:"entities"`
+	ExtendedEntities struct {
+		Media []tweetMedia `json:"media"`
+	} `json:"extended_entities"`
+}
+
+// TWITTER_TIME is the format of created_at in a Twitter archive.
+const TWITTER_TIME = "Mon Jan 02 15:04:05 -0700 2006"
+
+// ParseTwitter reads the tweets in a Twitter archive zip file. Retweets are
+// skipped.
+func ParseTwitter(zr *zip.Reader) ([]*Post, error) {
+	files := zipFiles(zr)
+	f, ok := files["data/tweets.js"]
+	if !ok {
+		f, ok = files["data/tweet.js"]
+	}
+	if !ok {
+		return nil, fmt.Errorf("Not a Twitter archive, data/tweets.js is missing.")
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	// The file is JavaScript, "window.YTD.tweets.part0 = [...]".
+	if i := bytes.IndexByte(b, '['); i != -1 {
+		b = b[i:]
+	}
+	var items []struct {
+		Tweet tweet `json:"tweet"`
+	}
+	if err := json.Unmarshal(b, &items); err != nil {
+		return nil, fmt.Errorf("Failed to parse tweets: %s", err)
+	}
+	ret := []*Post{}
+	for _, item := range items {
+		t := item.Tweet
+		if strings.HasPrefix(t.FullText, "RT @") {
+			continue
+		}
+		created, err := time.Parse(TWITTER_TIME, t.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse time of tweet %s: %s", t.IDStr, err)
+		}
+		text := html.UnescapeString(t.FullText)
+		for _, u := range t.Entities.URLs {
+			text = strings.ReplaceAll(text, u.URL, u.ExpandedURL)
+		}
+		p := &Post{
+			Source:     TWITTER,
+			ID:         t.IDStr,
+			URL:        "https://twitter.com/i/web/status/" + t.IDStr,
+			Created:    created,
+			Visibility: PUBLIC,
+		}
+		for _, m := range t.ExtendedEntities.Media {
+			text = strings.ReplaceAll(text, m.URL, "")
+			name := t.IDStr + "-" + path.Base(m.MediaURLHTTPS)
+			p.Media = append(p.Media, &Media{
+				Name: name,
+				Open: opener(files["data/tweets_media/"+name]),
+			})
+		}
+		p.Content = strings.TrimSpace(text)
+		if t.InReplyToStatusIDStr != "" {
+			p.InReplyToID = t.InReplyToStatusIDStr
+			p.InReplyToURL = fmt.Sprintf("https://twitter.com/%s/status/%s", t.InReplyToScreenName, t.InReplyToStatusIDStr)
+		}
+		ret = append(ret, p)
+	}
+	sortPosts(ret)
+	return ret, nil
+}
+
+// opener returns a Media.Open for f, which may be nil.
+func opener(f *zip.File) func() (io.ReadCloser, error) {
+	if f == nil {
+		return nil
+	}
+	return f.Open
+}
+
+const activityStreamsPublic = "https://www.w3.org/ns/activitystreams#Public"
+
+// recipients is an ActivityStreams to or cc, which may be a string or list.
+type recipients []string
+
+func (r *recipients) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*r = []string{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*r = list
+	return nil
+}
+
+func (r recipients) public() bool {
+	for _, s := range r {
+		if s == activityStreamsPublic || s == "as:Public" || s == "Public" {
+			return true
+		}
+	}
+	return false
+}
+
+type note struct {
+	ID         string     `json:"id"`
+	URL        string     `json:"url"`
+	Published  time.Time  `json:"published"`
+	Content    string     `json:"content"`
+	Summary    string     `json:"summary"`
+	InReplyTo  string     `json:"inReplyTo"`
+	To         recipients `json:"to"`
+	CC         recipients `json:"cc"`
+	Attachment []struct {
+		URL  string `json:"url"`
+		Name string `json:"name"`
+	} `json:"attachment"`
+}
+
+type activity struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// ParseMastodon reads the posts in a Mastodon outbox.json. If zr is not
+// nil it is the archive the outbox came from, which media is read from.
+// Boosts are skipped.
+func ParseMastodon(outbox io.Reader, zr *zip.Reader) ([]*Post, error) {
+	var o struct {
+		OrderedItems []activity `json:"orderedItems"`
+	}
+	if err := json.NewDecoder(outbox).Decode(&o); err != nil {
+		return nil, fmt.Errorf("Failed to parse outbox: %s", err)
+	}
+	files := map[string]*zip.File{}
+	if zr != nil {
+		files = zipFiles(zr)
+	}
+	ret := []*Post{}
+	ids := map[string]bool{}
+	for _, a := range o.OrderedItems {
+		if a.Type != "Create" {
+			continue
+		}
+		var n note
+		if err := json.Unmarshal(a.Object, &n); err != nil {
+			continue
+		}
+		p := &Post{
+			Source:       MASTODON,
+			ID:           n.ID,
+			URL:          n.URL,
+			Created:      n.Published,
+			Content:      n.Content,
+			Summary:      n.Summary,
+			InReplyToURL: n.InReplyTo,
+		}
+		switch {
+		case n.To.public():
+			p.Visibility = PUBLIC
+		case n.CC.public():
+			p.Visibility = UNLISTED
+		default:
+			p.Visibility = PRIVATE
+		}
+		for _, att := range n.Attachment {
+			name := strings.TrimPrefix(att.URL, "/")
+			p.Media = append(p.Media, &Media{
+				Name: name,
+				Alt:  att.Name,
+				Open: opener(files[name]),
+			})
+		}
+		ids[n.ID] = true
+		ret = append(ret, p)
+	}
+	// Replies to posts in the same archive are threaded.
+	for _, p := range ret {
+		if ids[p.InReplyToURL] {
+			p.InReplyToID = p.InReplyToURL
+		}
+	}
+	sortPosts(ret)
+	return ret, nil
+}
+
+// Read parses an archive of the given size, which is either a Twitter
+// archive zip, a Mastodon archive zip, or a bare Mastodon outbox.json.
+func Read(r io.ReaderAt, size int64) ([]*Post, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return ParseMastodon(io.NewSectionReader(r, 0, size), nil)
+	}
+	for _, f := range zr.File {
+		if f.Name == "outbox.json" {
+			outbox, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer outbox.Close()
+			return ParseMastodon(outbox, zr)
+		}
+	}
+	return ParseTwitter(zr)
+}
@@ -0,0 +1,138 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// read calls Read on b.
+func read(b []byte) ([]*Post, error) {
+	return Read(bytes.NewReader(b), int64(len(b)))
+}
+
+// makeZip returns a zip file containing the given files.
+func makeZip(t *testing.T, files map[string]string) []byte {
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for name, body := range files {
+		f, err := zw.Create(name)
+		assert.NoError(t, err)
+		_, err = f.Write([]byte(body))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, zw.Close())
+	return b.Bytes()
+}
+
+const tweets = `window.YTD.tweets.part0 = [
+  {"tweet": {
+    "id_str": "2",
+    "full_text": "Reply &amp; more https://t.co/abc https://t.co/pic",
+    "created_at": "Wed Oct 10 20:19:24 +0000 2018",
+    "in_reply_to_status_id_str": "1",
+    "in_reply_to_screen_name": "joe",
+    "entities": {"urls": [{"url": "https://t.co/abc", "expanded_url": "https://example.org/"}]},
+    "extended_entities": {"media": [{"url": "https://t.co/pic", "media_url_https": "https://pbs.twimg.com/media/x.jpg", "type": "photo"}]}
+  }},
+  {"tweet": {"id_str": "1", "full_text": "First", "created_at": "Tue Oct 09 20:19:24 +0000 2018"}},
+  {"tweet": {"id_str": "3", "full_text": "RT @someone: Boosted", "created_at": "Thu Oct 11 20:19:24 +0000 2018"}}
+]`
+
+func TestRead_Twitter(t *testing.T) {
+	posts, err := read(makeZip(t, map[string]string{
+		"data/tweets.js":            tweets,
+		"data/tweets_media/2-x.jpg": "JPEG",
+	}))
+	assert.NoError(t, err)
+	assert.Len(t, posts, 2)
+
+	assert.Equal(t, "twitter:1", posts[0].Alias())
+	assert.Equal(t, "First", posts[0].Content)
+
+	p := posts[1]
+	assert.Equal(t, "2", p.ID)
+	assert.Equal(t, "Reply & more https://example.org/", p.Content)
+	assert.Equal(t, time.Date(2018, 10, 10, 20, 19, 24, 0, time.UTC).Unix(), p.Created.Unix())
+	assert.Equal(t, "1", p.InReplyToID)
+	assert.Equal(t, "https://twitter.com/joe/status/1", p.InReplyToURL)
+	assert.Len(t, p.Media, 1)
+	assert.Equal(t, "2-x.jpg", p.Media[0].Name)
+	r, err := p.Media[0].Open()
+	assert.NoError(t, err)
+	body, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "JPEG", string(body))
+}
+
+const outbox = `{
+  "orderedItems": [
+    {"type": "Create", "object": {
+      "id": "https://m.example/users/joe/statuses/2",
+      "url": "https://m.example/@joe/2",
+      "published": "2022-01-02T00:00:00Z",
+      "content": "<p>Reply</p>",
+      "inReplyTo": "https://m.example/users/joe/statuses/1",
+      "to": ["https://m.example/users/joe/followers"],
+      "cc": ["https://www.w3.org/ns/activitystreams#Public"],
+      "attachment": [{"url": "/media_attachments/files/a.png", "name": "A cat"}]
+    }},
+    {"type": "Create", "object": {
+      "id": "https://m.example/users/joe/statuses/1",
+      "published": "2022-01-01T00:00:00Z",
+      "content": "<p>First</p>",
+      "summary": "CW",
+      "to": "https://www.w3.org/ns/activitystreams#Public"
+    }},
+    {"type": "Announce", "object": "https://other.example/statuses/9"},
+    {"type": "Create", "object": {
+      "id": "https://m.example/users/joe/statuses/3",
+      "published": "2022-01-03T00:00:00Z",
+      "content": "<p>Followers</p>",
+      "inReplyTo": "https://other.example/statuses/8",
+      "to": ["https://m.example/users/joe/followers"]
+    }}
+  ]
+}`
+
+func TestRead_Mastodon(t *testing.T) {
+	posts, err := read([]byte(outbox))
+	assert.NoError(t, err)
+	assert.Len(t, posts, 3)
+
+	assert.Equal(t, "<p>First</p>", posts[0].Content)
+	assert.Equal(t, "CW", posts[0].Summary)
+	assert.Equal(t, PUBLIC, posts[0].Visibility)
+
+	assert.Equal(t, UNLISTED, posts[1].Visibility)
+	assert.Equal(t, "https://m.example/users/joe/statuses/1", posts[1].InReplyToID)
+	assert.Len(t, posts[1].Media, 1)
+	assert.Equal(t, "A cat", posts[1].Media[0].Alt)
+	// A bare outbox has no media files.
+	assert.Nil(t, posts[1].Media[0].Open)
+
+	assert.Equal(t, PRIVATE, posts[2].Visibility)
+	assert.Equal(t, "", posts[2].InReplyToID)
+	assert.Equal(t, "https://other.example/statuses/8", posts[2].InReplyToURL)
+}
+
+func TestRead_MastodonZip(t *testing.T) {
+	posts, err := read(makeZip(t, map[string]string{
+		"outbox.json":                   outbox,
+		"media_attachments/files/a.png": "PNG",
+	}))
+	assert.NoError(t, err)
+	assert.Len(t, posts, 3)
+	assert.NotNil(t, posts[1].Media[0].Open)
+}
+
+func TestRead_Invalid(t *testing.T) {
+	_, err := read([]byte("not an archive"))
+	assert.Error(t, err)
+	_, err = read(makeZip(t, map[string]string{"README": "hi"}))
+	assert.Error(t, err)
+}
@@ -0,0 +1,95 @@
+// Package interact sends visitors to their own fediverse instance to reply
+// to, boost, or favourite an entry, using the OStatus subscribe template their
+// instance publishes via WebFinger.
+package interact
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SUBSCRIBE_REL is the WebFinger link rel whose template is the instance's
+// interact URL, e.g. "https://mastodon.social/authorize_interaction?uri={uri}".
+const SUBSCRIBE_REL = "http://ostatus.org/schema/1.0/subscribe"
+
+// ParseHandle splits a handle like "@joe@example.org" or "joe@example.org"
+// into the user and the host.
+func ParseHandle(handle string) (string, string, error) {
+	handle = strings.TrimPrefix(strings.TrimSpace(handle), "@")
+	parts := strings.Split(handle, "@")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("Not a fediverse handle: %q", handle)
+	}
+	user, host := parts[0], strings.ToLower(parts[1])
+	if strings.ContainsAny(user, "/?#: ") || strings.ContainsAny(host, "/?#@ ") {
+		return "", "", fmt.Errorf("Not a fediverse handle: %q", handle)
+	}
+	return user, host, nil
+}
+
+type jrd struct {
+	Links []struct {
+		Rel      string `json:"rel"`
+		Template string `json:"template"`
+	} `json:"links"`
+}
+
+// Resolver finds interact URLs.
+type Resolver struct {
+	client *http.Client
+
+	// scheme is replaceable for testing.
+	scheme string
+}
+
+// New returns a Resolver that makes WebFinger requests with client, which
+// should be a safefetch client since the hosts come from visitors.
+func New(client *http.Client) *Resolver {
+	return &Resolver{
+		client: client,
+		scheme: "https",
+	}
+}
+
+// URL returns the URL on the instance of handle for interacting with the
+// post at uri.
+func (r *Resolver) URL(ctx context.Context, handle, uri string) (string, error) {
+	user, host, err := ParseHandle(handle)
+	if err != nil {
+		return "", err
+	}
+	u := fmt.Sprintf("%s://%s/.well-known/webfinger?resource=%s", r.scheme, host, url.QueryEscape("acct:"+user+"@"+host))
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return "", fmt.Errorf("Failed to build WebFinger request: %s", err)
+	}
+	req.Header.Set("Accept", "application/jrd+json, application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Failed to look up %q: %s", handle, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("Failed to look up %q: %s", handle, resp.Status)
+	}
+	var j jrd
+	if err := json.NewDecoder(resp.Body).Decode(&j); err != nil {
+		return "", fmt.Errorf("Failed to decode WebFinger response: %s", err)
+	}
+	for _, l := range j.Links {
+		if l.Rel != SUBSCRIBE_REL || !strings.Contains(l.Template, "{uri}") {
+			continue
+		}
+		ret := strings.Replace(l.Template, "{uri}", url.QueryEscape(uri), 1)
+		parsed, err := url.Parse(ret)
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+			return "", fmt.Errorf("Invalid interact template: %q", l.Template)
+		}
+		return ret, nil
+	}
+	return "", fmt.Errorf("The instance of %q doesn't support interacting from other sites.", handle)
+}
@@ -0,0 +1,63 @@
+package interact
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHandle(t *testing.T) {
+	user, host, err := ParseHandle(" @joe@Example.org ")
+	assert.NoError(t, err)
+	assert.Equal(t, "joe", user)
+	assert.Equal(t, "example.org", host)
+
+	user, host, err = ParseHandle("joe@example.org")
+	assert.NoError(t, err)
+	assert.Equal(t, "joe", user)
+	assert.Equal(t, "example.org", host)
+
+	for _, bad := range []string{"", "joe", "@joe", "joe@", "a@b@c", "joe@example.org/evil"} {
+		_, _, err = ParseHandle(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func resolverFor(t *testing.T, body string) (*Resolver, string) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/.well-known/webfinger", r.URL.Path)
+		assert.True(t, strings.HasPrefix(r.FormValue("resource"), "acct:joe@"))
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(s.Close)
+	r := New(s.Client())
+	r.scheme = "http"
+	return r, "@joe@" + strings.TrimPrefix(s.URL, "http://")
+}
+
+func TestURL(t *testing.T) {
+	r, handle := resolverFor(t, `{"links": [
+		{"rel": "self", "href": "https://example.org/users/joe"},
+		{"rel": "http://ostatus.org/schema/1.0/subscribe", "template": "https://example.org/authorize_interaction?uri={uri}"}
+	]}`)
+	u, err := r.URL(context.Background(), handle, "https://example.com/entry/abc?x=1")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.org/authorize_interaction?uri=https%3A%2F%2Fexample.com%2Fentry%2Fabc%3Fx%3D1", u)
+}
+
+func TestURL_NoTemplate(t *testing.T) {
+	r, handle := resolverFor(t, `{"links": [{"rel": "self", "href": "https://example.org/users/joe"}]}`)
+	_, err := r.URL(context.Background(), handle, "https://example.com/entry/abc")
+	assert.Error(t, err)
+}
+
+func TestURL_BadScheme(t *testing.T) {
+	r, handle := resolverFor(t, `{"links": [{"rel": "http://ostatus.org/schema/1.0/subscribe", "template": "javascript:alert({uri})"}]}`)
+	_, err := r.URL(context.Background(), handle, "https://example.com/entry/abc")
+	assert.Error(t, err)
+}
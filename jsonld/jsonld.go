@@ -0,0 +1,134 @@
+// Package jsonld builds the schema.org structured data embedded in pages as
+// JSON-LD, which search engines use for richer results.
+package jsonld
+
+import (
+	"encoding/json"
+	"html/template"
+	"time"
+)
+
+// CONTEXT is the vocabulary all the types come from.
+const CONTEXT = "https://schema.org"
+
+// Types of postings.
+const (
+	// BLOG_POSTING is for entries with a title.
+	BLOG_POSTING = "BlogPosting"
+
+	// SOCIAL_MEDIA_POSTING is for notes.
+	SOCIAL_MEDIA_POSTING = "SocialMediaPosting"
+)
+
+// Person is the author of a posting.
+type Person struct {
+	Type  string `json:"@type"`
+	Name  string `json:"name"`
+	URL   string `json:"url,omitempty"`
+	Image string `json:"image,omitempty"`
+}
+
+// NewPerson returns a Person, where url and image may be "".
+func NewPerson(name, url, image string) *Person {
+	return &Person{
+		Type:  "Person",
+		Name:  name,
+		URL:   url,
+		Image: image,
+	}
+}
+
+// Posting is a BlogPosting or SocialMediaPosting.
+type Posting struct {
+	Context       string   `json:"@context,omitempty"`
+	Type          string   `json:"@type"`
+	ID            string   `json:"@id"`
+	URL           string   `json:"url"`
+	Headline      string   `json:"headline"`
+	Description   string   `json:"description,omitempty"`
+	DatePublished string   `json:"datePublished"`
+	DateModified  string   `json:"dateModified"`
+	Author        *Person  `json:"author"`
+	Image         []string `json:"image,omitempty"`
+	WordCount     int      `json:"wordCount,omitempty"`
+
+	// SharedContent is the page a note replies to or bookmarks.
+	SharedContent *WebPage `json:"sharedContent,omitempty"`
+}
+
+// WebPage is a page shared by a posting.
+type WebPage struct {
+	Type string `json:"@type"`
+	URL  string `json:"url"`
+}
+
+// NewPosting returns a posting, which is a SOCIAL_MEDIA_POSTING if isNote is
+// true, else a BLOG_POSTING. Fields that aren't passed in can be set on the
+// returned posting.
+func NewPosting(isNote bool, url, headline string, published, modified time.Time, author *Person) *Posting {
+	ret := &Posting{
+		Context:       CONTEXT,
+		Type:          BLOG_POSTING,
+		ID:            url,
+		URL:           url,
+		Headline:      headline,
+		DatePublished: published.Format(time.RFC3339),
+		DateModified:  modified.Format(time.RFC3339),
+		Author:        author,
+	}
+	if isNote {
+		ret.Type = SOCIAL_MEDIA_POSTING
+	}
+	if modified.Before(published) {
+		ret.DateModified = ret.DatePublished
+	}
+	return ret
+}
+
+// Share records that the posting is about the page at url, if not "".
+func (p *Posting) Share(url string) {
+	if url == "" {
+		return
+	}
+	p.SharedContent = &WebPage{
+		Type: "WebPage",
+		URL:  url,
+	}
+}
+
+// Blog is a page of postings, such as the home page.
+type Blog struct {
+	Context  string     `json:"@context"`
+	Type     string     `json:"@type"`
+	URL      string     `json:"url"`
+	Name     string     `json:"name"`
+	Author   *Person    `json:"author"`
+	BlogPost []*Posting `json:"blogPost"`
+}
+
+// NewBlog returns a Blog of the postings, whose own @context is dropped
+// since the Blog has one.
+func NewBlog(url, name string, author *Person, postings []*Posting) *Blog {
+	for _, p := range postings {
+		p.Context = ""
+	}
+	return &Blog{
+		Context:  CONTEXT,
+		Type:     "Blog",
+		URL:      url,
+		Name:     name,
+		Author:   author,
+		BlogPost: postings,
+	}
+}
+
+// Script returns v as JSON to be the content of a
+// <script type="application/ld+json"> element. Since the JSON escapes '<',
+// '>', and '&', it can't close the element early.
+func Script(v interface{}) (template.JS, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return template.JS(b), nil
+}
@@ -0,0 +1,55 @@
+package jsonld
+
+import (
+	"encoding/json"
+	"html/template"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPosting(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	author := NewPerson("Joe", "https://example.org/about", "")
+	p := NewPosting(false, "https://example.org/entry/1", "A Title", created, created.Add(time.Hour), author)
+	p.Image = []string{"https://example.org/images/a.jpg"}
+	p.Share("")
+	b, err := json.Marshal(p)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"@context": "https://schema.org",
+		"@type": "BlogPosting",
+		"@id": "https://example.org/entry/1",
+		"url": "https://example.org/entry/1",
+		"headline": "A Title",
+		"datePublished": "2024-05-01T12:00:00Z",
+		"dateModified": "2024-05-01T13:00:00Z",
+		"author": {"@type": "Person", "name": "Joe", "url": "https://example.org/about"},
+		"image": ["https://example.org/images/a.jpg"]
+	}`, string(b))
+
+	// Entries imported without an Updated time have the zero time.
+	note := NewPosting(true, "https://example.org/entry/2", "A note", created, time.Time{}, author)
+	note.Share("https://example.com/")
+	assert.Equal(t, SOCIAL_MEDIA_POSTING, note.Type)
+	assert.Equal(t, note.DatePublished, note.DateModified)
+	assert.Equal(t, &WebPage{Type: "WebPage", URL: "https://example.com/"}, note.SharedContent)
+}
+
+func TestNewBlog(t *testing.T) {
+	author := NewPerson("Joe", "", "")
+	p := NewPosting(true, "https://example.org/entry/1", "A note", time.Now(), time.Now(), author)
+	b := NewBlog("https://example.org/", "Joe - Stream", author, []*Posting{p})
+	assert.Equal(t, CONTEXT, b.Context)
+	assert.Equal(t, "", b.BlogPost[0].Context)
+}
+
+func TestScript(t *testing.T) {
+	author := NewPerson("</script><script>alert(1)</script>", "", "")
+	js, err := Script(author)
+	assert.NoError(t, err)
+	assert.False(t, strings.Contains(string(js), "</script>"))
+	assert.Equal(t, template.JS(`{"@type":"Person","name":"\u003c/script\u003e\u003cscript\u003ealert(1)\u003c/script\u003e"}`), js)
+}
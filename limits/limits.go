@@ -0,0 +1,138 @@
+// Package limits enforces per-route limits on the size of request bodies and
+// the time handlers take, so a single request can't exhaust memory or tie up
+// the server. The limits are picked by the longest matching path prefix.
+package limits
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DEFAULT_MAX_BYTES is the largest request body allowed for paths
+	// without a rule.
+	DEFAULT_MAX_BYTES = 1 << 20
+
+	// DEFAULT_TIMEOUT_SECONDS is how long handlers for paths without a rule
+	// may take.
+	DEFAULT_TIMEOUT_SECONDS = 30
+
+	// NONE is the value of a limit to disable it.
+	NONE = -1
+)
+
+// Rule is the limits for the requests whose path starts with Prefix.
+type Rule struct {
+	Prefix string `mapstructure:"prefix"`
+
+	// MaxBytes is the largest request body allowed, 0 to use the default, or
+	// NONE for no limit.
+	MaxBytes int64 `mapstructure:"max_bytes"`
+
+	// TimeoutSeconds is how long the handler may take before the request
+	// fails with a 503, 0 to use the default, or NONE for no limit, which
+	// also lifts the server's read and write timeouts. Handlers with a
+	// timeout can't stream their responses, since they are buffered.
+	TimeoutSeconds int `mapstructure:"timeout_seconds"`
+}
+
+// Limits picks the Rule for each request.
+type Limits struct {
+	// rules are sorted from the longest prefix to the shortest.
+	rules []Rule
+
+	// defaults is used for paths that don't match a rule, and for the
+	// limits a rule leaves at 0.
+	defaults Rule
+}
+
+// New returns Limits with the given rules. If more than one rule has the same
+// prefix the last one wins, so configured rules can be appended to built-in
+// ones. Zero values in defaults are DEFAULT_MAX_BYTES and
+// DEFAULT_TIMEOUT_SECONDS.
+func New(defaults Rule, rules []Rule) (*Limits, error) {
+	if defaults.MaxBytes == 0 {
+		defaults.MaxBytes = DEFAULT_MAX_BYTES
+	}
+	if defaults.TimeoutSeconds == 0 {
+		defaults.TimeoutSeconds = DEFAULT_TIMEOUT_SECONDS
+	}
+	byPrefix := map[string]Rule{}
+	for _, rule := range rules {
+		if !strings.HasPrefix(rule.Prefix, "/") {
+			return nil, fmt.Errorf("Prefix %q must start with /.", rule.Prefix)
+		}
+		if rule.MaxBytes < NONE || rule.TimeoutSeconds < NONE {
+			return nil, fmt.Errorf("Limits for %q must be positive, 0, or %d.", rule.Prefix, NONE)
+		}
+		if rule.MaxBytes == 0 {
+			rule.MaxBytes = defaults.MaxBytes
+		}
+		if rule.TimeoutSeconds == 0 {
+			rule.TimeoutSeconds = defaults.TimeoutSeconds
+		}
+		byPrefix[rule.Prefix] = rule
+	}
+	ret := &Limits{
+		rules:    make([]Rule, 0, len(byPrefix)),
+		defaults: defaults,
+	}
+	for _, rule := range byPrefix {
+		ret.rules = append(ret.rules, rule)
+	}
+	sort.Slice(ret.rules, func(i, j int) bool {
+		return len(ret.rules[i].Prefix) > len(ret.rules[j].Prefix)
+	})
+	return ret, nil
+}
+
+// For returns the Rule that applies to path.
+func (l *Limits) For(path string) Rule {
+	for _, rule := range l.rules {
+		if strings.HasPrefix(path, rule.Prefix) {
+			return rule
+		}
+	}
+	return l.defaults
+}
+
+// Middleware enforces the limits on requests to h. Bodies that say they are
+// too large are rejected with a 413 before h is called, and reading past the
+// limit of any other body fails, which handlers can check with TooLarge.
+// Handlers that take too long have their context cancelled and the request
+// fails with a 503. Requests without a timeout have the connection's read
+// and write deadlines cleared, so the http.Server's ReadTimeout and
+// WriteTimeout don't cut them off either.
+func (l *Limits) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule := l.For(r.URL.Path)
+		if rule.MaxBytes != NONE {
+			if r.ContentLength > rule.MaxBytes {
+				http.Error(w, "Request body too large.", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, rule.MaxBytes)
+		}
+		if rule.TimeoutSeconds != NONE {
+			http.TimeoutHandler(h, time.Duration(rule.TimeoutSeconds)*time.Second, "Request timed out.").ServeHTTP(w, r)
+			return
+		}
+		// Not every ResponseWriter supports deadlines, e.g. in tests, in
+		// which case there are none to clear.
+		rc := http.NewResponseController(w)
+		_ = rc.SetReadDeadline(time.Time{})
+		_ = rc.SetWriteDeadline(time.Time{})
+		h.ServeHTTP(w, r)
+	})
+}
+
+// TooLarge returns true if err came from reading past the limit of a request
+// body.
+func TooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
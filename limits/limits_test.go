@@ -0,0 +1,118 @@
+package limits
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	l, err := New(Rule{}, []Rule{
+		{Prefix: "/admin/", MaxBytes: 100},
+		{Prefix: "/admin/import", MaxBytes: 1000, TimeoutSeconds: NONE},
+		{Prefix: "/admin/", MaxBytes: 200},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, Rule{MaxBytes: DEFAULT_MAX_BYTES, TimeoutSeconds: DEFAULT_TIMEOUT_SECONDS}, l.For("/"))
+	assert.Equal(t, Rule{Prefix: "/admin/", MaxBytes: 200, TimeoutSeconds: DEFAULT_TIMEOUT_SECONDS}, l.For("/admin/edit/1"))
+	assert.Equal(t, Rule{Prefix: "/admin/import", MaxBytes: 1000, TimeoutSeconds: NONE}, l.For("/admin/import"))
+
+	_, err = New(Rule{}, []Rule{{Prefix: "admin"}})
+	assert.Error(t, err)
+	_, err = New(Rule{}, []Rule{{Prefix: "/admin", MaxBytes: -2}})
+	assert.Error(t, err)
+}
+
+func TestMiddleware_MaxBytes(t *testing.T) {
+	l, err := New(Rule{MaxBytes: 10}, []Rule{{Prefix: "/upload", MaxBytes: NONE}})
+	assert.NoError(t, err)
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			assert.True(t, TooLarge(err))
+			http.Error(w, "Too large.", http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	send := func(path, body string, chunked bool) int {
+		r := httptest.NewRequest("POST", path, strings.NewReader(body))
+		if chunked {
+			r.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusNoContent, send("/", "small", false))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, send("/", "far too large", false))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, send("/", "far too large", true))
+	assert.Equal(t, http.StatusNoContent, send("/upload", "far too large", false))
+}
+
+func TestMiddleware_Timeout(t *testing.T) {
+	l, err := New(Rule{TimeoutSeconds: 1}, []Rule{{Prefix: "/slow", TimeoutSeconds: NONE}})
+	assert.NoError(t, err)
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(1500 * time.Millisecond):
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestMiddleware_NoneLiftsServerTimeouts(t *testing.T) {
+	l, err := New(Rule{}, []Rule{{Prefix: "/slow", TimeoutSeconds: NONE}})
+	assert.NoError(t, err)
+	ts := httptest.NewUnstartedServer(l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		fmt.Fprint(w, "done")
+	})))
+	ts.Config.ReadTimeout = 100 * time.Millisecond
+	ts.Config.WriteTimeout = 100 * time.Millisecond
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/slow")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "done", string(b))
+
+	// Other requests are still cut off by the server.
+	_, err = http.Get(ts.URL + "/")
+	assert.Error(t, err)
+}
+
+func TestTooLarge_Multipart(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("archive", "archive.zip")
+	assert.NoError(t, err)
+	_, err = fw.Write(bytes.Repeat([]byte("x"), 1000))
+	assert.NoError(t, err)
+	assert.NoError(t, mw.Close())
+
+	r := httptest.NewRequest("POST", "/admin/import", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.Body = http.MaxBytesReader(httptest.NewRecorder(), r.Body, 100)
+	_, _, err = r.FormFile("archive")
+	assert.True(t, TooLarge(err))
+	assert.False(t, TooLarge(nil))
+}
@@ -0,0 +1,208 @@
+// Package linkrel adds rel and target attributes to the external links in
+// rendered entries, e.g. so that links to some sites are nofollow, while the
+// sites on an allow-list keep plain links that pass on their ranking.
+package linkrel
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// ANY is the key of Options.Domains that applies to every external link.
+const ANY = "*"
+
+// followRels are the rel values that the Follow list removes.
+var followRels = map[string]bool{
+	"nofollow":  true,
+	"ugc":       true,
+	"sponsored": true,
+}
+
+// Options are the rules for external links, from the LINK_REL config.
+type Options struct {
+	// Rel are added to every external link, e.g. ["noopener"].
+	Rel []string `mapstructure:"rel"`
+
+	// Domains are more rel values for links to a domain or its subdomains,
+	// e.g. {"example.com": ["nofollow", "ugc"]}. The ANY domain applies to
+	// every link.
+	Domains map[string][]string `mapstructure:"domains"`
+
+	// Follow are the domains, and their subdomains, whose links never get
+	// nofollow, ugc, or sponsored.
+	Follow []string `mapstructure:"follow"`
+
+	// Target, if not "", is the target of every external link, e.g.
+	// "_blank", in which case noopener is also added.
+	Target string `mapstructure:"target"`
+}
+
+// Rewriter applies Options to HTML.
+type Rewriter struct {
+	opts Options
+
+	// host is the site's own host, whose links aren't external.
+	host string
+
+	// domains are the keys of opts.Domains, sorted so the rel values are
+	// always added in the same order.
+	domains []string
+}
+
+// New returns a Rewriter for the site at host, e.g. "https://example.org".
+func New(host string, opts Options) (*Rewriter, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse host %q: %s", host, err)
+	}
+	ret := &Rewriter{
+		host: strings.ToLower(u.Hostname()),
+	}
+	domains := map[string][]string{}
+	for domain, rels := range opts.Domains {
+		domain = strings.ToLower(domain)
+		domains[domain] = rels
+		ret.domains = append(ret.domains, domain)
+	}
+	sort.Strings(ret.domains)
+	opts.Domains = domains
+	ret.opts = opts
+	return ret, nil
+}
+
+// inDomain returns true if host is domain or one of its subdomains.
+func inDomain(host, domain string) bool {
+	domain = strings.ToLower(domain)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// rels returns the rel values to add to a link to host.
+func (r *Rewriter) rels(host string) []string {
+	ret := append([]string{}, r.opts.Rel...)
+	ret = append(ret, r.opts.Domains[ANY]...)
+	for _, domain := range r.domains {
+		if domain != ANY && inDomain(host, domain) {
+			ret = append(ret, r.opts.Domains[domain]...)
+		}
+	}
+	if r.opts.Target == "_blank" {
+		ret = append(ret, "noopener")
+	}
+	for _, domain := range r.opts.Follow {
+		if inDomain(host, domain) {
+			kept := []string{}
+			for _, rel := range ret {
+				if !followRels[strings.ToLower(rel)] {
+					kept = append(kept, rel)
+				}
+			}
+			return kept
+		}
+	}
+	return ret
+}
+
+// Rewrite returns the HTML fragment h with the attributes added to its
+// external links, which are the absolute http and https links to hosts
+// other than the site's own. Rel values already on a link are kept, and so
+// is an existing target.
+func (r *Rewriter) Rewrite(h string) string {
+	if !strings.Contains(h, "<a") {
+		return h
+	}
+	var out bytes.Buffer
+	z := xhtml.NewTokenizer(strings.NewReader(h))
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			if z.Err() != io.EOF {
+				return h
+			}
+			return out.String()
+		}
+		if tt != xhtml.StartTagToken {
+			out.Write(z.Raw())
+			continue
+		}
+		raw := string(z.Raw())
+		token := z.Token()
+		if token.Data != "a" {
+			out.WriteString(raw)
+			continue
+		}
+		out.WriteString(r.link(token, raw))
+	}
+}
+
+// link returns the rewritten tag of the link token, or raw if it isn't
+// external.
+func (r *Rewriter) link(token xhtml.Token, raw string) string {
+	href := ""
+	for _, a := range token.Attr {
+		if a.Key == "href" {
+			href = a.Val
+		}
+	}
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return raw
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == r.host {
+		return raw
+	}
+	add := r.rels(host)
+	if len(add) == 0 && r.opts.Target == "" {
+		return raw
+	}
+
+	hasRel, hasTarget := false, false
+	for i, a := range token.Attr {
+		switch a.Key {
+		case "rel":
+			hasRel = true
+			token.Attr[i].Val = mergeRels(a.Val, add)
+		case "target":
+			hasTarget = true
+		}
+	}
+	if !hasRel && len(add) > 0 {
+		token.Attr = append(token.Attr, xhtml.Attribute{Key: "rel", Val: mergeRels("", add)})
+	}
+	if !hasTarget && r.opts.Target != "" {
+		token.Attr = append(token.Attr, xhtml.Attribute{Key: "target", Val: r.opts.Target})
+	}
+
+	var b strings.Builder
+	b.WriteString("<a")
+	for _, a := range token.Attr {
+		fmt.Fprintf(&b, ` %s="%s"`, a.Key, html.EscapeString(a.Val))
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
+// mergeRels returns the space separated rel values in existing with add
+// appended, without duplicates.
+func mergeRels(existing string, add []string) string {
+	ret := strings.Fields(existing)
+	seen := map[string]bool{}
+	for _, rel := range ret {
+		seen[strings.ToLower(rel)] = true
+	}
+	for _, rel := range add {
+		rel = strings.ToLower(strings.TrimSpace(rel))
+		if rel != "" && !seen[rel] {
+			seen[rel] = true
+			ret = append(ret, rel)
+		}
+	}
+	return strings.Join(ret, " ")
+}
@@ -0,0 +1,54 @@
+package linkrel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewrite(t *testing.T) {
+	r, err := New("https://stream.example.org", Options{
+		Rel: []string{"noopener"},
+		Domains: map[string][]string{
+			ANY:           {"nofollow"},
+			"Example.com": {"ugc"},
+		},
+		Follow: []string{"friend.org"},
+	})
+	assert.NoError(t, err)
+
+	// Internal links are left alone.
+	assert.Equal(t, `<p><a href="/entry/abc">a</a> <a href="https://stream.example.org/tag/go">b</a></p>`,
+		r.Rewrite(`<p><a href="/entry/abc">a</a> <a href="https://stream.example.org/tag/go">b</a></p>`))
+
+	assert.Equal(t, `<p>See <a href="https://other.net/a?b=1&amp;c=2" rel="noopener nofollow">this</a>.</p>`,
+		r.Rewrite(`<p>See <a href="https://other.net/a?b=1&c=2">this</a>.</p>`))
+
+	// Subdomains match, and existing rel values are kept.
+	assert.Equal(t, `<a href="https://www.example.com/" rel="me noopener nofollow ugc">x</a>`,
+		r.Rewrite(`<a href="https://www.example.com/" rel="me">x</a>`))
+
+	// The allow-list keeps plain follow links.
+	assert.Equal(t, `<a href="https://blog.friend.org/" rel="noopener">x</a>`,
+		r.Rewrite(`<a href="https://blog.friend.org/">x</a>`))
+
+	// Anything that isn't a link passes through untouched.
+	assert.Equal(t, `<img src="https://other.net/a.png" alt="<a>"><a name=top>`,
+		r.Rewrite(`<img src="https://other.net/a.png" alt="<a>"><a name=top>`))
+}
+
+func TestRewrite_Target(t *testing.T) {
+	r, err := New("https://stream.example.org", Options{Target: "_blank"})
+	assert.NoError(t, err)
+	assert.Equal(t, `<a href="https://other.net/" rel="noopener" target="_blank">x</a>`,
+		r.Rewrite(`<a href="https://other.net/">x</a>`))
+	assert.Equal(t, `<a href="https://other.net/" target="_self" rel="noopener">x</a>`,
+		r.Rewrite(`<a href="https://other.net/" target="_self">x</a>`))
+}
+
+func TestRewrite_None(t *testing.T) {
+	r, err := New("", Options{})
+	assert.NoError(t, err)
+	h := `<a href='https://other.net/'>x</a>`
+	assert.Equal(t, h, r.Rewrite(h))
+}
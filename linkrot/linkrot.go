@@ -0,0 +1,413 @@
+// Package linkrot periodically re-checks the external links in entries,
+// recording the ones that have died along with an archive.org snapshot that
+// can be linked to instead.
+package linkrot
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/PuerkitoBio/goquery"
+	"google.golang.org/api/iterator"
+
+	"github.com/jcgregorio/go-lib/ds"
+	"github.com/jcgregorio/slog"
+)
+
+const (
+	LINK ds.Kind = "Link"
+)
+
+// FAILURES_BEFORE_DEAD is the number of checks in a row that must fail
+// before a link is considered dead, so a site that is briefly down isn't
+// reported.
+const FAILURES_BEFORE_DEAD = 2
+
+// CHECK_TIMEOUT is how long to wait for a response from a link.
+const CHECK_TIMEOUT = 20 * time.Second
+
+// WaybackAvailableURL is the archive.org availability API.
+var WaybackAvailableURL = "https://archive.org/wayback/available"
+
+// WaybackSaveURL is the Wayback Machine save API, the URL to save is
+// appended.
+var WaybackSaveURL = "https://web.archive.org/save/"
+
+// SAVE_TIMEOUT is how long to wait for the Wayback Machine to save a page,
+// which can take a while.
+const SAVE_TIMEOUT = 2 * time.Minute
+
+// Link is the result of checking a single external link. The key name is
+// the md5 of the URL, since URLs can be longer than a key name allows.
+type Link struct {
+	URL      string   `datastore:"url,noindex"`
+	EntryIDs []string `datastore:"entry_ids,noindex"`
+
+	// Status is the HTTP status code of the last check, or 0 if the request
+	// failed.
+	Status int    `datastore:"status,noindex"`
+	Error  string `datastore:"error,noindex"`
+
+	// Failures is the number of checks in a row that have failed.
+	Failures    int       `datastore:"failures,noindex"`
+	Dead        bool      `datastore:"dead"`
+	LastChecked time.Time `datastore:"last_checked,noindex"`
+
+	// Archive is the URL of an archive.org snapshot of the link, either
+	// saved when the entry was published or found once the link died.
+	Archive string `datastore:"archive,noindex"`
+}
+
+// Links stores the results of checking links.
+type Links struct {
+	DS  *ds.DS
+	log slog.Logger
+
+	mutex sync.Mutex
+
+	// archives maps dead URLs to their archive.org snapshot.
+	archives map[string]string
+}
+
+func New(ctx context.Context, project, ns string, log slog.Logger) (*Links, error) {
+	d, err := ds.New(ctx, project, ns)
+	if err != nil {
+		return nil, err
+	}
+	l := &Links{
+		DS:       d,
+		log:      log,
+		archives: map[string]string{},
+	}
+	if _, err := l.Dead(ctx); err != nil {
+		log.Warningf("Failed to load dead links: %s", err)
+	}
+	return l, nil
+}
+
+// External returns the absolute http(s) links in html that don't point at
+// host, without duplicates.
+func External(html, host string) []string {
+	ret := []string{}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return ret
+	}
+	self := ""
+	if u, err := url.Parse(host); err == nil {
+		self = u.Host
+	}
+	seen := map[string]bool{}
+	doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
+		href := s.AttrOr("href", "")
+		u, err := url.Parse(href)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Host == self {
+			return
+		}
+		if !seen[href] {
+			seen[href] = true
+			ret = append(ret, href)
+		}
+	})
+	return ret
+}
+
+// IsDead returns true if the result of a check means the link is gone: the
+// request failed, for example because of a timeout or the domain no longer
+// resolving, or the server says the page doesn't exist.
+func IsDead(status int, err error) bool {
+	return err != nil || status == http.StatusNotFound || status == http.StatusGone
+}
+
+// Check requests u and returns the HTTP status code. HEAD is tried first,
+// falling back to GET for servers that don't support it.
+func Check(ctx context.Context, client *http.Client, u string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, CHECK_TIMEOUT)
+	defer cancel()
+	status, err := request(ctx, client, "HEAD", u)
+	if err == nil && status < 400 {
+		return status, nil
+	}
+	return request(ctx, client, "GET", u)
+}
+
+func request(ctx context.Context, client *http.Client, method, u string) (int, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+type availability struct {
+	ArchivedSnapshots struct {
+		Closest struct {
+			Available bool   `json:"available"`
+			URL       string `json:"url"`
+		} `json:"closest"`
+	} `json:"archived_snapshots"`
+}
+
+// Snapshot returns the URL of the closest archive.org snapshot of u, or ""
+// if there isn't one.
+func Snapshot(ctx context.Context, client *http.Client, u string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, CHECK_TIMEOUT)
+	defer cancel()
+	req, err := http.NewRequest("GET", WaybackAvailableURL+"?url="+url.QueryEscape(u), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("Failed to query archive.org: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Failed to query archive.org: %s", resp.Status)
+	}
+	var a availability
+	if err := json.NewDecoder(resp.Body).Decode(&a); err != nil {
+		return "", fmt.Errorf("Failed to decode archive.org response: %s", err)
+	}
+	if !a.ArchivedSnapshots.Closest.Available {
+		return "", nil
+	}
+	return a.ArchivedSnapshots.Closest.URL, nil
+}
+
+// Save asks the Wayback Machine to save u and returns the URL of the
+// snapshot.
+func Save(ctx context.Context, client *http.Client, u string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, SAVE_TIMEOUT)
+	defer cancel()
+	req, err := http.NewRequest("GET", WaybackSaveURL+u, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("Failed to save to archive.org: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Failed to save to archive.org: %s", resp.Status)
+	}
+	// The snapshot is either in Content-Location or is where the request was
+	// redirected to.
+	loc := resp.Header.Get("Content-Location")
+	if loc == "" && strings.Contains(resp.Request.URL.Path, "/web/") {
+		loc = resp.Request.URL.String()
+	}
+	if loc == "" {
+		return "", fmt.Errorf("Failed to find snapshot location for %q", u)
+	}
+	base, err := url.Parse(WaybackSaveURL)
+	if err != nil {
+		return "", err
+	}
+	snapshot, err := base.Parse(loc)
+	if err != nil {
+		return "", fmt.Errorf("Invalid snapshot location %q: %s", loc, err)
+	}
+	return snapshot.String(), nil
+}
+
+func (l *Links) key(u string) *datastore.Key {
+	key := l.DS.NewKey(LINK)
+	key.Name = fmt.Sprintf("%x", md5.Sum([]byte(u)))
+	return key
+}
+
+// Get returns the last result of checking u, or nil if it hasn't been
+// checked.
+func (l *Links) Get(ctx context.Context, u string) (*Link, error) {
+	link := &Link{}
+	if err := l.DS.Client.Get(ctx, l.key(u), link); err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to get link: %s", err)
+	}
+	return link, nil
+}
+
+func (l *Links) put(ctx context.Context, link *Link) error {
+	if _, err := l.DS.Client.Put(ctx, l.key(link.URL), link); err != nil {
+		return fmt.Errorf("Failed to write link: %s", err)
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if link.Dead && link.Archive != "" {
+		l.archives[link.URL] = link.Archive
+	} else {
+		delete(l.archives, link.URL)
+	}
+	return nil
+}
+
+// Dead returns all the dead links, sorted by URL.
+func (l *Links) Dead(ctx context.Context) ([]*Link, error) {
+	ret := []*Link{}
+	archives := map[string]string{}
+	it := l.DS.Client.Run(ctx, l.DS.NewQuery(LINK).Filter("dead =", true))
+	for {
+		link := &Link{}
+		_, err := it.Next(link)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed while reading: %s", err)
+		}
+		ret = append(ret, link)
+		if link.Archive != "" {
+			archives[link.URL] = link.Archive
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].URL < ret[j].URL })
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.archives = archives
+	return ret, nil
+}
+
+// CheckAll checks every link in urls, which maps each URL to the ids of the
+// entries that link to it, and records the results. If archive is true then
+// an archive.org snapshot is looked up for each newly dead link. Returns the
+// number of dead links.
+func (l *Links) CheckAll(ctx context.Context, client *http.Client, urls map[string][]string, archive bool) (int, error) {
+	dead := 0
+	for u, ids := range urls {
+		link, err := l.Get(ctx, u)
+		if err != nil {
+			return dead, err
+		}
+		if link == nil {
+			link = &Link{URL: u}
+		}
+		link.EntryIDs = ids
+		link.LastChecked = time.Now()
+		link.Error = ""
+		status, err := Check(ctx, client, u)
+		link.Status = status
+		if err != nil {
+			link.Error = err.Error()
+		}
+		if IsDead(status, err) {
+			link.Failures++
+		} else {
+			link.Failures = 0
+		}
+		link.Dead = link.Failures >= FAILURES_BEFORE_DEAD
+		if link.Dead && archive && link.Archive == "" {
+			link.Archive, err = Snapshot(ctx, client, u)
+			if err != nil {
+				l.log.Warningf("Failed to find snapshot of %q: %s", u, err)
+			}
+		}
+		if link.Dead {
+			dead++
+		}
+		if err := l.put(ctx, link); err != nil {
+			return dead, err
+		}
+	}
+	return dead, nil
+}
+
+// SaveAll asks the Wayback Machine to save each of urls, which are linked
+// to from the entry with the given id, and records the snapshots. Links that
+// already have a snapshot are skipped.
+func (l *Links) SaveAll(ctx context.Context, client *http.Client, id string, urls []string) error {
+	for _, u := range urls {
+		link, err := l.Get(ctx, u)
+		if err != nil {
+			return err
+		}
+		if link == nil {
+			link = &Link{URL: u}
+		}
+		if !contains(link.EntryIDs, id) {
+			link.EntryIDs = append(link.EntryIDs, id)
+		}
+		if link.Archive == "" {
+			link.Archive, err = Save(ctx, client, u)
+			if err != nil {
+				l.log.Warningf("Failed to save %q: %s", u, err)
+			}
+		}
+		if err := l.put(ctx, link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func contains(a []string, s string) bool {
+	for _, x := range a {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Archive looks up an archive.org snapshot for the dead link u.
+func (l *Links) Archive(ctx context.Context, client *http.Client, u string) error {
+	link, err := l.Get(ctx, u)
+	if err != nil {
+		return err
+	}
+	if link == nil || !link.Dead {
+		return fmt.Errorf("Not a dead link: %q", u)
+	}
+	link.Archive, err = Snapshot(ctx, client, u)
+	if err != nil {
+		return err
+	}
+	if link.Archive == "" {
+		return fmt.Errorf("No archive.org snapshot of %q", u)
+	}
+	return l.put(ctx, link)
+}
+
+// anchorRegex matches links in rendered HTML.
+var anchorRegex = regexp.MustCompile(`<a href="(https?://[^"]+)"[^>]*>.*?</a>`)
+
+// annotate adds a link to the archived copy after each link in h that has
+// one in archives.
+func annotate(h string, archives map[string]string) string {
+	if len(archives) == 0 {
+		return h
+	}
+	return anchorRegex.ReplaceAllStringFunc(h, func(s string) string {
+		archive, ok := archives[html.UnescapeString(anchorRegex.FindStringSubmatch(s)[1])]
+		if !ok {
+			return s
+		}
+		return fmt.Sprintf(`%s <a href="%s" class=archived>(archived)</a>`, s, html.EscapeString(archive))
+	})
+}
+
+// Annotate adds a link to the archive.org snapshot after each dead link in
+// the rendered HTML h.
+func (l *Links) Annotate(h string) string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return annotate(h, l.archives)
+}
@@ -0,0 +1,121 @@
+package linkrot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExternal(t *testing.T) {
+	html := `<p><a href="https://example.org/a">A</a> <a href="/entry/1">Local</a>
+<a href="https://stream.example.com/entry/2">Self</a> <a href="mailto:joe@example.org">Mail</a>
+<a href="https://example.org/a">A again</a> <a href="http://example.net/">B</a></p>`
+	assert.Equal(t, []string{"https://example.org/a", "http://example.net/"}, External(html, "https://stream.example.com"))
+}
+
+func TestIsDead(t *testing.T) {
+	assert.False(t, IsDead(200, nil))
+	assert.False(t, IsDead(500, nil))
+	assert.True(t, IsDead(404, nil))
+	assert.True(t, IsDead(410, nil))
+	assert.True(t, IsDead(0, errors.New("no such host")))
+}
+
+func TestCheck(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.WriteHeader(http.StatusOK)
+		case "/nohead":
+			if r.Method == "HEAD" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	status, err := Check(context.Background(), ts.Client(), ts.URL+"/ok")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+
+	status, err = Check(context.Background(), ts.Client(), ts.URL+"/nohead")
+	assert.NoError(t, err)
+	assert.Equal(t, 200, status)
+
+	status, err = Check(context.Background(), ts.Client(), ts.URL+"/gone")
+	assert.NoError(t, err)
+	assert.Equal(t, 404, status)
+}
+
+func TestSnapshot(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("url") == "https://example.org/a" {
+			fmt.Fprint(w, `{"archived_snapshots":{"closest":{"available":true,"url":"http://web.archive.org/web/2020/https://example.org/a","status":"200"}}}`)
+			return
+		}
+		fmt.Fprint(w, `{"archived_snapshots":{}}`)
+	}))
+	defer ts.Close()
+	old := WaybackAvailableURL
+	WaybackAvailableURL = ts.URL
+	defer func() { WaybackAvailableURL = old }()
+
+	s, err := Snapshot(context.Background(), ts.Client(), "https://example.org/a")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://web.archive.org/web/2020/https://example.org/a", s)
+
+	s, err = Snapshot(context.Background(), ts.Client(), "https://example.org/b")
+	assert.NoError(t, err)
+	assert.Equal(t, "", s)
+}
+
+func TestAnnotate(t *testing.T) {
+	archives := map[string]string{
+		"https://example.org/a?x=1&y=2": "http://web.archive.org/web/2020/https://example.org/a",
+	}
+	assert.Equal(t, `<p><a href="https://example.org/a?x=1&amp;y=2">A</a> <a href="http://web.archive.org/web/2020/https://example.org/a" class=archived>(archived)</a> <a href="https://example.org/b">B</a></p>`,
+		annotate(`<p><a href="https://example.org/a?x=1&amp;y=2">A</a> <a href="https://example.org/b">B</a></p>`, archives))
+	assert.Equal(t, "<p>Plain</p>", annotate("<p>Plain</p>", nil))
+}
+
+func TestSave(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/save/https://example.org/a":
+			w.Header().Set("Content-Location", "/web/20200101000000/https://example.org/a")
+		case "/save/https://example.org/b":
+			http.Redirect(w, r, "http://"+r.Host+"/web/20200101000000/https://example.org/b", http.StatusFound)
+		case "/web/20200101000000/https://example.org/b":
+		case "/save/https://example.org/c":
+		default:
+			http.Error(w, "Failed", http.StatusBadGateway)
+		}
+	}))
+	defer ts.Close()
+	old := WaybackSaveURL
+	WaybackSaveURL = ts.URL + "/save/"
+	defer func() { WaybackSaveURL = old }()
+
+	s, err := Save(context.Background(), ts.Client(), "https://example.org/a")
+	assert.NoError(t, err)
+	assert.Equal(t, ts.URL+"/web/20200101000000/https://example.org/a", s)
+
+	s, err = Save(context.Background(), ts.Client(), "https://example.org/b")
+	assert.NoError(t, err)
+	assert.Equal(t, ts.URL+"/web/20200101000000/https://example.org/b", s)
+
+	_, err = Save(context.Background(), ts.Client(), "https://example.org/c")
+	assert.Error(t, err)
+
+	_, err = Save(context.Background(), ts.Client(), "https://example.org/d")
+	assert.Error(t, err)
+}
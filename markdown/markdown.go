@@ -0,0 +1,85 @@
+// Package markdown renders entry content from Markdown to HTML with a
+// configurable set of extensions.
+package markdown
+
+import (
+	"regexp"
+
+	blackfriday "gopkg.in/russross/blackfriday.v2"
+)
+
+// Options controls which Markdown extensions are enabled. The field names
+// match the keys of the MARKDOWN config block.
+type Options struct {
+	Footnotes     bool `mapstructure:"footnotes"`
+	Tables        bool `mapstructure:"tables"`
+	TaskLists     bool `mapstructure:"tasklists"`
+	Strikethrough bool `mapstructure:"strikethrough"`
+	Autolink      bool `mapstructure:"autolink"`
+
+	// Typographer converts straight quotes to curly quotes, "--" to dashes,
+	// and "1/2" to fractions.
+	Typographer bool `mapstructure:"typographer"`
+
+	// Mermaid emits ```mermaid fenced code blocks as containers that the
+	// mermaid script renders to diagrams in the browser.
+	Mermaid bool `mapstructure:"mermaid"`
+}
+
+// Default returns the Options that match blackfriday's default behavior.
+func Default() Options {
+	return Options{
+		Tables:        true,
+		Strikethrough: true,
+		Autolink:      true,
+		Typographer:   true,
+		Mermaid:       true,
+	}
+}
+
+// taskRegex matches the "[ ]" or "[x]" marker at the start of a list item,
+// which may be wrapped in a paragraph for loose lists.
+var taskRegex = regexp.MustCompile(`<li>(<p>)?\[([ xX])\]\s`)
+
+// mermaidRegex matches the HTML blackfriday emits for a ```mermaid fenced
+// code block.
+var mermaidRegex = regexp.MustCompile(`(?s)<pre><code class="language-mermaid">(.*?)</code></pre>`)
+
+// Render converts the Markdown in src to HTML.
+func Render(src []byte, opts Options) []byte {
+	extensions := blackfriday.NoIntraEmphasis | blackfriday.FencedCode | blackfriday.SpaceHeadings | blackfriday.HeadingIDs | blackfriday.BackslashLineBreak | blackfriday.DefinitionLists
+	if opts.Footnotes {
+		extensions |= blackfriday.Footnotes
+	}
+	if opts.Tables {
+		extensions |= blackfriday.Tables
+	}
+	if opts.Strikethrough {
+		extensions |= blackfriday.Strikethrough
+	}
+	if opts.Autolink {
+		extensions |= blackfriday.Autolink
+	}
+	flags := blackfriday.UseXHTML
+	if opts.Typographer {
+		flags |= blackfriday.Smartypants | blackfriday.SmartypantsFractions | blackfriday.SmartypantsDashes | blackfriday.SmartypantsLatexDashes
+	}
+	renderer := blackfriday.NewHTMLRenderer(blackfriday.HTMLRendererParameters{
+		Flags: flags,
+	})
+	out := blackfriday.Run(src, blackfriday.WithExtensions(extensions), blackfriday.WithRenderer(renderer))
+	if opts.TaskLists {
+		out = taskRegex.ReplaceAllFunc(out, func(b []byte) []byte {
+			m := taskRegex.FindSubmatch(b)
+			checkbox := `<input type="checkbox" disabled="" /> `
+			if string(m[2]) != " " {
+				checkbox = `<input type="checkbox" checked="" disabled="" /> `
+			}
+			return []byte(`<li class="task-list-item">` + string(m[1]) + checkbox)
+		})
+	}
+	if opts.Mermaid {
+		out = mermaidRegex.ReplaceAll(out, []byte(`<pre class="mermaid">$1</pre>`))
+	}
+	return out
+}
@@ -0,0 +1,78 @@
+package markdown
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func render(src string, opts Options) string {
+	return string(Render([]byte(src), opts))
+}
+
+func TestFootnotes(t *testing.T) {
+	src := "A claim.[^1]\n\n[^1]: The source.\n"
+	assert.Contains(t, render(src, Options{Footnotes: true}), `class="footnotes"`)
+	assert.Contains(t, render(src, Options{}), "[^1]")
+}
+
+func TestTables(t *testing.T) {
+	src := "| a | b |\n|---|---|\n| 1 | 2 |\n"
+	assert.Contains(t, render(src, Options{Tables: true}), "<table>")
+	assert.NotContains(t, render(src, Options{}), "<table>")
+}
+
+func TestTaskLists(t *testing.T) {
+	src := "- [ ] todo\n- [x] done\n"
+	got := render(src, Options{TaskLists: true})
+	assert.Contains(t, got, `<li class="task-list-item"><input type="checkbox" disabled="" /> todo`)
+	assert.Contains(t, got, `<li class="task-list-item"><input type="checkbox" checked="" disabled="" /> done`)
+	assert.NotContains(t, render(src, Options{}), "checkbox")
+}
+
+func TestTaskLists_Loose(t *testing.T) {
+	src := "- [ ] todo\n\n- [X] done\n"
+	got := render(src, Options{TaskLists: true})
+	assert.Contains(t, got, `<li class="task-list-item"><p><input type="checkbox" disabled="" /> todo`)
+	assert.Contains(t, got, `<li class="task-list-item"><p><input type="checkbox" checked="" disabled="" /> done`)
+}
+
+func TestStrikethrough(t *testing.T) {
+	assert.Contains(t, render("~~gone~~", Options{Strikethrough: true}), "<del>gone</del>")
+	assert.NotContains(t, render("~~gone~~", Options{}), "<del>")
+}
+
+func TestAutolink(t *testing.T) {
+	src := "See https://example.org for more."
+	assert.Contains(t, render(src, Options{Autolink: true}), `<a href="https://example.org">https://example.org</a>`)
+	assert.NotContains(t, render(src, Options{}), "<a ")
+}
+
+func TestTypographer(t *testing.T) {
+	src := `"Quoted" -- text`
+	got := render(src, Options{Typographer: true})
+	assert.Contains(t, got, "&ldquo;Quoted&rdquo;")
+	assert.Contains(t, got, "&ndash;")
+	assert.NotContains(t, render(src, Options{}), "&ldquo;")
+}
+
+func TestMermaid(t *testing.T) {
+	src := "```mermaid\ngraph TD\n  A --> B\n```\n"
+	got := render(src, Options{Mermaid: true})
+	assert.Contains(t, got, "<pre class=\"mermaid\">graph TD\n  A --&gt; B\n</pre>")
+
+	got = render(src, Options{})
+	assert.Contains(t, got, `<code class="language-mermaid">`)
+	assert.NotContains(t, got, `<pre class="mermaid">`)
+}
+
+func TestDefault(t *testing.T) {
+	opts := Default()
+	assert.True(t, opts.Tables)
+	assert.True(t, opts.Strikethrough)
+	assert.True(t, opts.Autolink)
+	assert.True(t, opts.Typographer)
+	assert.True(t, opts.Mermaid)
+	assert.False(t, opts.Footnotes)
+	assert.False(t, opts.TaskLists)
+}
@@ -0,0 +1,137 @@
+// Package mastoapi has the types of a read-only subset of the Mastodon
+// client API, enough for Mastodon apps to browse the stream.
+//
+// See https://docs.joinmastodon.org/entities/.
+package mastoapi
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ACCOUNT_ID is the id of the only account, the author.
+const ACCOUNT_ID = "1"
+
+// DEFAULT_LIMIT and MAX_LIMIT are the number of statuses returned by
+// timeline requests when no limit is given, and the most allowed.
+const (
+	DEFAULT_LIMIT = 20
+	MAX_LIMIT     = 40
+)
+
+// Emoji is a custom emoji, which are never used but apps expect the lists.
+type Emoji struct{}
+
+// Field is a profile metadata field.
+type Field struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type Account struct {
+	ID             string    `json:"id"`
+	Username       string    `json:"username"`
+	Acct           string    `json:"acct"`
+	DisplayName    string    `json:"display_name"`
+	Locked         bool      `json:"locked"`
+	Bot            bool      `json:"bot"`
+	CreatedAt      time.Time `json:"created_at"`
+	Note           string    `json:"note"`
+	URL            string    `json:"url"`
+	Avatar         string    `json:"avatar"`
+	AvatarStatic   string    `json:"avatar_static"`
+	Header         string    `json:"header"`
+	HeaderStatic   string    `json:"header_static"`
+	FollowersCount int       `json:"followers_count"`
+	FollowingCount int       `json:"following_count"`
+	StatusesCount  int       `json:"statuses_count"`
+	Emojis         []Emoji   `json:"emojis"`
+	Fields         []Field   `json:"fields"`
+}
+
+type MediaAttachment struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	URL         string `json:"url"`
+	PreviewURL  string `json:"preview_url"`
+	Description string `json:"description"`
+}
+
+type Status struct {
+	ID                 string            `json:"id"`
+	URI                string            `json:"uri"`
+	URL                string            `json:"url"`
+	CreatedAt          time.Time         `json:"created_at"`
+	EditedAt           *time.Time        `json:"edited_at"`
+	Account            *Account          `json:"account"`
+	Content            string            `json:"content"`
+	Visibility         string            `json:"visibility"`
+	Sensitive          bool              `json:"sensitive"`
+	SpoilerText        string            `json:"spoiler_text"`
+	InReplyToID        *string           `json:"in_reply_to_id"`
+	InReplyToAccountID *string           `json:"in_reply_to_account_id"`
+	Reblog             *Status           `json:"reblog"`
+	Language           *string           `json:"language"`
+	RepliesCount       int               `json:"replies_count"`
+	ReblogsCount       int               `json:"reblogs_count"`
+	FavouritesCount    int               `json:"favourites_count"`
+	MediaAttachments   []MediaAttachment `json:"media_attachments"`
+	Mentions           []struct{}        `json:"mentions"`
+	Tags               []struct{}        `json:"tags"`
+	Emojis             []Emoji           `json:"emojis"`
+}
+
+// NewStatus returns a Status with the lists apps expect to be present
+// filled in as empty.
+func NewStatus(id, url string, created time.Time, account *Account) *Status {
+	return &Status{
+		ID:               id,
+		URI:              url,
+		URL:              url,
+		CreatedAt:        created,
+		Account:          account,
+		Visibility:       "public",
+		MediaAttachments: []MediaAttachment{},
+		Mentions:         []struct{}{},
+		Tags:             []struct{}{},
+		Emojis:           []Emoji{},
+	}
+}
+
+// Limit parses the limit query parameter of a timeline request.
+func Limit(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return DEFAULT_LIMIT
+	}
+	if n > MAX_LIMIT {
+		return MAX_LIMIT
+	}
+	return n
+}
+
+// AcctMatches returns true if acct, as given to /api/v1/accounts/lookup,
+// is the account with the given username on domain. A leading @ and a
+// missing domain are allowed.
+func AcctMatches(acct, username, domain string) bool {
+	acct = strings.ToLower(strings.TrimPrefix(acct, "@"))
+	username = strings.ToLower(username)
+	return acct == username || acct == username+"@"+strings.ToLower(domain)
+}
+
+// MediaType returns the attachment type for the media at url.
+func MediaType(url string) string {
+	lower := strings.ToLower(url)
+	for _, ext := range []string{".mp4", ".webm", ".mov"} {
+		if strings.HasSuffix(lower, ext) {
+			return "video"
+		}
+	}
+	for _, ext := range []string{".mp3", ".ogg", ".m4a", ".wav"} {
+		if strings.HasSuffix(lower, ext) {
+			return "audio"
+		}
+	}
+	return "image"
+}
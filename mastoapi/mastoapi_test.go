@@ -0,0 +1,40 @@
+package mastoapi
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimit(t *testing.T) {
+	assert.Equal(t, DEFAULT_LIMIT, Limit(""))
+	assert.Equal(t, DEFAULT_LIMIT, Limit("-3"))
+	assert.Equal(t, DEFAULT_LIMIT, Limit("abc"))
+	assert.Equal(t, 5, Limit("5"))
+	assert.Equal(t, MAX_LIMIT, Limit("1000"))
+}
+
+func TestAcctMatches(t *testing.T) {
+	assert.True(t, AcctMatches("stream.example.com", "stream.example.com", "stream.example.com"))
+	assert.True(t, AcctMatches("@Stream.example.com@stream.example.com", "stream.example.com", "stream.example.com"))
+	assert.False(t, AcctMatches("joe@example.com", "stream.example.com", "stream.example.com"))
+}
+
+func TestMediaType(t *testing.T) {
+	assert.Equal(t, "image", MediaType("/images/a.jpg"))
+	assert.Equal(t, "video", MediaType("/images/a.MP4"))
+	assert.Equal(t, "audio", MediaType("/images/a.mp3"))
+}
+
+func TestNewStatus_JSON(t *testing.T) {
+	s := NewStatus("abc", "https://example.com/entry/abc", time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), &Account{ID: ACCOUNT_ID})
+	b, err := json.Marshal(s)
+	assert.NoError(t, err)
+	// Apps fail on missing lists and expect nulls for unset references.
+	assert.Contains(t, string(b), `"media_attachments":[]`)
+	assert.Contains(t, string(b), `"in_reply_to_id":null`)
+	assert.Contains(t, string(b), `"created_at":"2020-01-02T03:04:05Z"`)
+	assert.Contains(t, string(b), `"visibility":"public"`)
+}
@@ -0,0 +1,277 @@
+// Package media keeps track of the images served from /images/, along with
+// their alt text and which entries use them.
+package media
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+
+	"github.com/jcgregorio/go-lib/ds"
+	"github.com/jcgregorio/slog"
+)
+
+const (
+	MEDIA ds.Kind = "Media"
+)
+
+// Media is a single file in the images directory. The path, relative to the
+// images directory, is the key name.
+type Media struct {
+	Path    string    `datastore:"-"`
+	Alt     string    `datastore:"alt,noindex"`
+	Created time.Time `datastore:"created"`
+}
+
+// Library stores Media and the files they describe.
+type Library struct {
+	DS  *ds.DS
+	log slog.Logger
+
+	// dir is the images directory.
+	dir string
+
+	mutex sync.Mutex
+
+	// alts caches the alt text of every Media, keyed by path.
+	alts map[string]string
+}
+
+func New(ctx context.Context, project, ns, dir string, log slog.Logger) (*Library, error) {
+	d, err := ds.New(ctx, project, ns)
+	if err != nil {
+		return nil, err
+	}
+	l := &Library{
+		DS:   d,
+		log:  log,
+		dir:  dir,
+		alts: map[string]string{},
+	}
+	if _, err := l.List(ctx); err != nil {
+		log.Warningf("Failed to load media: %s", err)
+	}
+	return l, nil
+}
+
+// referenceRegex matches references to files under /images/ in Markdown or
+// HTML.
+var referenceRegex = regexp.MustCompile(`/images/([^\s)"'?#<>]+)`)
+
+// References returns the paths, relative to /images/, of the images used by
+// content, without duplicates.
+func References(content string) []string {
+	ret := []string{}
+	seen := map[string]bool{}
+	for _, m := range referenceRegex.FindAllStringSubmatch(content, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			ret = append(ret, m[1])
+		}
+	}
+	return ret
+}
+
+// cleanPath validates p as a path relative to the images directory.
+func cleanPath(p string) (string, error) {
+	clean := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if clean == "" || clean != p {
+		return "", fmt.Errorf("Invalid media path %q.", p)
+	}
+	return clean, nil
+}
+
+func (l *Library) key(p string) *datastore.Key {
+	key := l.DS.NewKey(MEDIA)
+	key.Name = p
+	return key
+}
+
+// Alt returns the stored alt text for the image at path p, or "".
+func (l *Library) Alt(p string) string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.alts[p]
+}
+
+// Sync adds a Media for every file in the images directory that doesn't
+// have one yet, such as newly deployed images.
+func (l *Library) Sync(ctx context.Context) error {
+	existing := map[string]bool{}
+	stored, err := l.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range stored {
+		existing[m.Path] = true
+	}
+	return filepath.Walk(l.dir, func(filename string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(l.dir, filename)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if existing[rel] {
+			return nil
+		}
+		if _, err := l.DS.Client.Put(ctx, l.key(rel), &Media{Created: info.ModTime()}); err != nil {
+			return fmt.Errorf("Failed to add media %q: %s", rel, err)
+		}
+		return nil
+	})
+}
+
+// List returns all the Media, sorted by path.
+func (l *Library) List(ctx context.Context) ([]*Media, error) {
+	ret := []*Media{}
+	it := l.DS.Client.Run(ctx, l.DS.NewQuery(MEDIA))
+	for {
+		m := &Media{}
+		key, err := it.Next(m)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed while reading media: %s", err)
+		}
+		m.Path = key.Name
+		ret = append(ret, m)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Path < ret[j].Path
+	})
+	alts := map[string]string{}
+	for _, m := range ret {
+		if m.Alt != "" {
+			alts[m.Path] = m.Alt
+		}
+	}
+	l.mutex.Lock()
+	l.alts = alts
+	l.mutex.Unlock()
+	return ret, nil
+}
+
+// SetAlt changes the alt text for the image at path p.
+func (l *Library) SetAlt(ctx context.Context, p, alt string) error {
+	p, err := cleanPath(p)
+	if err != nil {
+		return err
+	}
+	_, err = l.DS.Client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var m Media
+		if err := tx.Get(l.key(p), &m); err != nil {
+			return err
+		}
+		m.Alt = alt
+		_, err := tx.Put(l.key(p), &m)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to set alt text for %q: %s", p, err)
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.alts[p] = alt
+	return nil
+}
+
+// unsafeNameRegex matches the runs of characters that are replaced in the
+// names of uploaded files.
+var unsafeNameRegex = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// safeName returns the file name of an upload, without any directories or
+// unsafe characters.
+func safeName(name string) string {
+	name = strings.Trim(unsafeNameRegex.ReplaceAllString(path.Base(strings.ReplaceAll(name, "\\", "/")), "-"), ".-")
+	if name == "" {
+		return "upload"
+	}
+	return name
+}
+
+// Upload saves the file read from r, whose name on the uploader's machine is
+// name, under a directory for the current year, and adds its Media. An
+// existing file is never replaced, a number is added to the name instead. It
+// returns the path of the new file, relative to the images directory.
+func (l *Library) Upload(ctx context.Context, name string, r io.Reader) (string, error) {
+	name = safeName(name)
+	dir := time.Now().Format("2006")
+	if err := os.MkdirAll(filepath.Join(l.dir, dir), 0755); err != nil {
+		return "", fmt.Errorf("Failed to create %q: %s", dir, err)
+	}
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	var f *os.File
+	var p string
+	for i := 1; f == nil; i++ {
+		p = path.Join(dir, name)
+		if i > 1 {
+			p = path.Join(dir, fmt.Sprintf("%s-%d%s", base, i, ext))
+		}
+		var err error
+		f, err = os.OpenFile(filepath.Join(l.dir, filepath.FromSlash(p)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("Failed to create %q: %s", p, err)
+		}
+	}
+	_, err := io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("Failed to write %q: %s", p, err)
+	}
+	if _, err := l.DS.Client.Put(ctx, l.key(p), &Media{Created: time.Now()}); err != nil {
+		return "", fmt.Errorf("Failed to add media %q: %s", p, err)
+	}
+	return p, nil
+}
+
+// File returns the filename of the media at path p.
+func (l *Library) File(p string) (string, error) {
+	p, err := cleanPath(p)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.dir, filepath.FromSlash(p)), nil
+}
+
+// Delete removes the image at path p and its Media.
+func (l *Library) Delete(ctx context.Context, p string) error {
+	p, err := cleanPath(p)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(l.dir, filepath.FromSlash(p))); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Failed to delete %q: %s", p, err)
+	}
+	if err := l.DS.Client.Delete(ctx, l.key(p)); err != nil {
+		return fmt.Errorf("Failed to delete media %q: %s", p, err)
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.alts, p)
+	return nil
+}
@@ -0,0 +1,102 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReferences(t *testing.T) {
+	content := `A photo ![Sunset](/images/2020/sunset.jpg "title") and
+<img src="https://stream.example.org/images/cat.png?w=10" alt=""> and
+![again](/images/2020/sunset.jpg) but not /img/320/other.jpg.`
+	assert.Equal(t, []string{"2020/sunset.jpg", "cat.png"}, References(content))
+	assert.Equal(t, []string{}, References("No images."))
+}
+
+func TestCleanPath(t *testing.T) {
+	p, err := cleanPath("2020/sunset.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, "2020/sunset.jpg", p)
+
+	for _, bad := range []string{"", "../config.json", "/abs.jpg", "a/../b.jpg"} {
+		_, err := cleanPath(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestSafeName(t *testing.T) {
+	assert.Equal(t, "My-Episode-1.mp3", safeName("My Episode #1.mp3"))
+	assert.Equal(t, "passwd", safeName("../../etc/passwd"))
+	assert.Equal(t, "clip.mp4", safeName(`C:\Users\joe\clip.mp4`))
+	assert.Equal(t, "upload", safeName(".."))
+}
+
+// box returns an MP4 box of the given type around contents.
+func box(boxType string, contents ...[]byte) []byte {
+	body := bytes.Join(contents, nil)
+	ret := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(ret, uint32(8+len(body)))
+	copy(ret[4:], boxType)
+	return append(ret, body...)
+}
+
+func TestProbe(t *testing.T) {
+	// A version 0 mvhd with a timescale of 1000 and a duration of 95.5s.
+	mvhd := make([]byte, 20)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], 95500)
+	movie := bytes.Join([][]byte{
+		box("ftyp", []byte("isom")),
+		box("mdat", make([]byte, 100)),
+		box("moov", box("trak"), box("mvhd", mvhd)),
+	}, nil)
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "clip.mp4")
+	assert.NoError(t, os.WriteFile(filename, movie, 0644))
+	info, err := Probe(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, &Info{Type: "video/mp4", Length: int64(len(movie)), Duration: 95500 * time.Millisecond}, info)
+
+	// The duration of other files isn't known.
+	filename = filepath.Join(dir, "episode.mp3")
+	assert.NoError(t, os.WriteFile(filename, []byte("ID3"), 0644))
+	info, err = Probe(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, &Info{Type: "audio/mpeg", Length: 3}, info)
+
+	_, err = Probe(filepath.Join(dir, "missing.mp3"))
+	assert.Error(t, err)
+}
+
+func TestIsAudio(t *testing.T) {
+	assert.True(t, (&Media{Path: "2024/episode.MP3"}).IsAudio())
+	assert.False(t, (&Media{Path: "2024/episode.mp3"}).IsVideo())
+	assert.True(t, (&Media{Path: "clip.webm"}).IsVideo())
+	assert.False(t, (&Media{Path: "cat.jpg"}).IsAudio())
+}
+
+func TestParseDuration(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"":        0,
+		"95":      95 * time.Second,
+		"1:35":    95 * time.Second,
+		"1:02:03": time.Hour + 2*time.Minute + 3*time.Second,
+	} {
+		got, err := ParseDuration(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, want, got, s)
+	}
+	for _, bad := range []string{"abc", "1:5", "1:60", "1:2:3:4", "-5"} {
+		_, err := ParseDuration(bad)
+		assert.Error(t, err, bad)
+	}
+	assert.Equal(t, "1:35", FormatDuration(95*time.Second))
+	assert.Equal(t, "1:02:03", FormatDuration(time.Hour+2*time.Minute+3*time.Second))
+}
@@ -0,0 +1,198 @@
+package media
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// types are the MIME types of the audio and video files that browsers and
+// podcast apps play, which aren't all known to the mime package.
+var types = map[string]string{
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".opus": "audio/ogg",
+	".wav":  "audio/wav",
+	".flac": "audio/flac",
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".mov":  "video/quicktime",
+	".webm": "video/webm",
+	".ogv":  "video/ogg",
+}
+
+// TypeByExtension returns the MIME type of the file at p from its extension,
+// or "" if it isn't known.
+func TypeByExtension(p string) string {
+	ext := strings.ToLower(path.Ext(p))
+	if t, ok := types[ext]; ok {
+		return t
+	}
+	t := mime.TypeByExtension(ext)
+	if i := strings.Index(t, ";"); i >= 0 {
+		t = t[:i]
+	}
+	return t
+}
+
+// IsAudio returns true if the Media is an audio file.
+func (m *Media) IsAudio() bool {
+	return strings.HasPrefix(TypeByExtension(m.Path), "audio/")
+}
+
+// IsVideo returns true if the Media is a video file.
+func (m *Media) IsVideo() bool {
+	return strings.HasPrefix(TypeByExtension(m.Path), "video/")
+}
+
+// Info is what Probe finds out about an audio or video file.
+type Info struct {
+	// Type is the MIME type.
+	Type string
+
+	// Length is the size in bytes.
+	Length int64
+
+	// Duration is 0 if it couldn't be found, which is only possible for MP4
+	// and QuickTime files.
+	Duration time.Duration
+}
+
+// Probe returns the Info of the file at filename.
+func Probe(filename string) (*Info, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %q: %s", filename, err)
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to stat %q: %s", filename, err)
+	}
+	ret := &Info{
+		Type:   TypeByExtension(filename),
+		Length: st.Size(),
+	}
+	if ret.Type == "" {
+		head := make([]byte, 512)
+		n, _ := io.ReadFull(f, head)
+		ret.Type = strings.Split(http.DetectContentType(head[:n]), ";")[0]
+	}
+	if d, err := mp4Duration(f, st.Size()); err == nil {
+		ret.Duration = d
+	}
+	return ret, nil
+}
+
+// mp4Duration returns the duration in the movie header of an MP4 or
+// QuickTime file, which is in the moov box, in the mvhd box.
+func mp4Duration(r io.ReaderAt, size int64) (time.Duration, error) {
+	moov, moovSize, err := findBox(r, 0, size, "moov")
+	if err != nil {
+		return 0, err
+	}
+	mvhd, _, err := findBox(r, moov, moov+moovSize, "mvhd")
+	if err != nil {
+		return 0, err
+	}
+	var version [1]byte
+	if _, err := r.ReadAt(version[:], mvhd); err != nil {
+		return 0, err
+	}
+	// After the version and flags come the creation and modification
+	// times, then the timescale and duration, which are all 32 bits in
+	// version 0 and the times and duration are 64 bits in version 1.
+	var timescale, duration uint64
+	if version[0] == 1 {
+		var b [28]byte
+		if _, err := r.ReadAt(b[:], mvhd+4); err != nil {
+			return 0, err
+		}
+		timescale = uint64(binary.BigEndian.Uint32(b[16:20]))
+		duration = binary.BigEndian.Uint64(b[20:28])
+	} else {
+		var b [16]byte
+		if _, err := r.ReadAt(b[:], mvhd+4); err != nil {
+			return 0, err
+		}
+		timescale = uint64(binary.BigEndian.Uint32(b[8:12]))
+		duration = uint64(binary.BigEndian.Uint32(b[12:16]))
+	}
+	if timescale == 0 {
+		return 0, fmt.Errorf("Invalid timescale.")
+	}
+	return time.Duration(duration) * time.Second / time.Duration(timescale), nil
+}
+
+// findBox returns the offset and size of the contents of the first box of
+// the given type between start and end.
+func findBox(r io.ReaderAt, start, end int64, boxType string) (int64, int64, error) {
+	for offset := start; offset+8 <= end; {
+		var header [16]byte
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return 0, 0, err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			// The box runs to the end.
+			size = end - offset
+		case 1:
+			// A 64 bit size follows the type.
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return 0, 0, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize || offset+size > end {
+			return 0, 0, fmt.Errorf("Invalid %q box at %d.", header[4:8], offset)
+		}
+		if string(header[4:8]) == boxType {
+			return offset + headerSize, size - headerSize, nil
+		}
+		offset += size
+	}
+	return 0, 0, fmt.Errorf("No %q box.", boxType)
+}
+
+// ParseDuration parses a duration written as seconds, "m:ss", or "h:mm:ss".
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("Invalid duration %q.", s)
+	}
+	var seconds int64
+	for i, part := range parts {
+		var n int64
+		if _, err := fmt.Sscanf(part, "%d", &n); err != nil || n < 0 || (i > 0 && (len(part) != 2 || n > 59)) {
+			return 0, fmt.Errorf("Invalid duration %q.", s)
+		}
+		seconds = seconds*60 + n
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// FormatDuration formats d as "m:ss" or "h:mm:ss", the inverse of
+// ParseDuration, as used by podcast feeds.
+func FormatDuration(d time.Duration) string {
+	seconds := int64(d / time.Second)
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
@@ -0,0 +1,334 @@
+// Package mentions stores interactions with entries, such as replies, likes,
+// and reposts, that arrive from other sites.
+package mentions
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+
+	"github.com/jcgregorio/go-lib/ds"
+	"github.com/jcgregorio/slog"
+)
+
+const (
+	MENTION ds.Kind = "Mention"
+)
+
+// Type is the kind of interaction a Mention represents.
+type Type string
+
+const (
+	REPLY  Type = "reply"
+	LIKE   Type = "like"
+	REPOST Type = "repost"
+
+	// PLAIN is a mention that is none of the above, e.g. a link from a post.
+	PLAIN Type = "mention"
+
+	// EMAIL is a reply sent to an entry's reply address.
+	EMAIL Type = "email"
+)
+
+// Mention is a single interaction with an entry.
+type Mention struct {
+	ID          string    `datastore:"-"`
+	EntryID     string    `datastore:"entry_id"`
+	Source      string    `datastore:"source,noindex"`
+	Type        Type      `datastore:"type,noindex"`
+	AuthorName  string    `datastore:"author_name,noindex"`
+	AuthorURL   string    `datastore:"author_url,noindex"`
+	AuthorPhoto string    `datastore:"author_photo,noindex"`
+	Content     string    `datastore:"content,noindex"`
+	Published   time.Time `datastore:"published,noindex"`
+	Created     time.Time `datastore:"created"`
+
+	// Pending is true for mentions that aren't displayed until approved.
+	Pending bool `datastore:"pending"`
+
+	// Target is the URL a received webmention was sent for. It is only set
+	// for webmentions, which are the mentions that can be verified again.
+	Target string `datastore:"target,noindex"`
+
+	// Verified is when the source was last found to link to Target.
+	Verified time.Time `datastore:"verified,noindex"`
+
+	// Retracted is true if the source was deleted, or no longer links to
+	// Target, and the mention is no longer displayed.
+	Retracted bool `datastore:"retracted,noindex"`
+}
+
+type Mentions struct {
+	DS  *ds.DS
+	log slog.Logger
+}
+
+func New(ctx context.Context, project, ns string, log slog.Logger) (*Mentions, error) {
+	d, err := ds.New(ctx, project, ns)
+	if err != nil {
+		return nil, err
+	}
+	return &Mentions{
+		DS:  d,
+		log: log,
+	}, nil
+}
+
+// id is derived from the source, type, and target so that storing the same
+// interaction twice is idempotent.
+func id(m *Mention) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(m.EntryID+" "+string(m.Type)+" "+m.Source)))
+}
+
+func (m *Mentions) key(id string) *datastore.Key {
+	key := m.DS.NewKey(MENTION)
+	key.Name = id
+	return key
+}
+
+// Put stores the mention and returns true if it wasn't already stored. A
+// mention that was already approved stays approved when it is updated, and a
+// retracted mention that is stored again is no longer retracted.
+func (m *Mentions) Put(ctx context.Context, mention *Mention) (bool, error) {
+	mention.ID = id(mention)
+	key := m.key(mention.ID)
+	isNew := false
+	_, err := m.DS.Client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var existing Mention
+		err := tx.Get(key, &existing)
+		if err == nil {
+			mention.Created = existing.Created
+			mention.Pending = mention.Pending && existing.Pending
+		} else if err == datastore.ErrNoSuchEntity {
+			isNew = true
+			mention.Created = time.Now()
+		} else {
+			return err
+		}
+		_, err = tx.Put(key, mention)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("Failed to store mention of %q from %q: %s", mention.EntryID, mention.Source, err)
+	}
+	return isNew, nil
+}
+
+// Retract marks the mention with the given ID as retracted.
+func (m *Mentions) Retract(ctx context.Context, id string) error {
+	_, err := m.DS.Client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var mention Mention
+		if err := tx.Get(m.key(id), &mention); err != nil {
+			return err
+		}
+		mention.Retracted = true
+		_, err := tx.Put(m.key(id), &mention)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to retract mention %q: %s", id, err)
+	}
+	return nil
+}
+
+// FromSource returns the mentions of the given entry from source, of any
+// type.
+func (m *Mentions) FromSource(ctx context.Context, entryID, source string) ([]*Mention, error) {
+	all, err := m.ForEntry(ctx, entryID)
+	if err != nil {
+		return nil, err
+	}
+	ret := []*Mention{}
+	for _, mention := range all {
+		if mention.Source == source {
+			ret = append(ret, mention)
+		}
+	}
+	return ret, nil
+}
+
+// Unverified returns up to n received webmentions that haven't been
+// retracted, least recently verified first.
+//
+// Sorting is done here to avoid needing a composite index.
+func (m *Mentions) Unverified(ctx context.Context, n int) ([]*Mention, error) {
+	ret := []*Mention{}
+	err := m.All(ctx, func(mention *Mention) error {
+		if mention.Target != "" && !mention.Retracted {
+			ret = append(ret, mention)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Verified.Before(ret[j].Verified)
+	})
+	if len(ret) > n {
+		ret = ret[:n]
+	}
+	return ret, nil
+}
+
+// Delete removes the mention with the given ID.
+func (m *Mentions) Delete(ctx context.Context, id string) error {
+	return m.DS.Client.Delete(ctx, m.key(id))
+}
+
+// ForEntry returns all the mentions of the given entry, oldest first.
+//
+// Sorting is done here to avoid needing a composite index.
+func (m *Mentions) ForEntry(ctx context.Context, entryID string) ([]*Mention, error) {
+	ret := []*Mention{}
+	q := m.DS.NewQuery(MENTION).Filter("entry_id =", entryID)
+	it := m.DS.Client.Run(ctx, q)
+	for {
+		mention := &Mention{}
+		key, err := it.Next(mention)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed while reading mentions: %s", err)
+		}
+		mention.ID = key.Name
+		ret = append(ret, mention)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Created.Before(ret[j].Created)
+	})
+	return ret, nil
+}
+
+// Since returns up to n mentions created after t, oldest first.
+func (m *Mentions) Since(ctx context.Context, t time.Time, n int) ([]*Mention, error) {
+	ret := []*Mention{}
+	q := m.DS.NewQuery(MENTION).Filter("created >", t).Order("created").Limit(n)
+	it := m.DS.Client.Run(ctx, q)
+	for {
+		mention := &Mention{}
+		key, err := it.Next(mention)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed while reading mentions: %s", err)
+		}
+		mention.ID = key.Name
+		ret = append(ret, mention)
+	}
+	return ret, nil
+}
+
+// Pending returns the mentions awaiting moderation, oldest first, skipping
+// retracted ones.
+func (m *Mentions) Pending(ctx context.Context) ([]*Mention, error) {
+	ret := []*Mention{}
+	it := m.DS.Client.Run(ctx, m.DS.NewQuery(MENTION).Filter("pending =", true))
+	for {
+		mention := &Mention{}
+		key, err := it.Next(mention)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed while reading mentions: %s", err)
+		}
+		if mention.Retracted {
+			continue
+		}
+		mention.ID = key.Name
+		ret = append(ret, mention)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Created.Before(ret[j].Created)
+	})
+	return ret, nil
+}
+
+// Get returns the mention with the given ID.
+func (m *Mentions) Get(ctx context.Context, id string) (*Mention, error) {
+	mention := &Mention{}
+	if err := m.DS.Client.Get(ctx, m.key(id), mention); err != nil {
+		return nil, fmt.Errorf("Failed to get mention %q: %s", id, err)
+	}
+	mention.ID = id
+	return mention, nil
+}
+
+// Counts are the numbers of likes, reposts, and replies among the mentions
+// of an entry.
+type Counts struct {
+	Likes   int
+	Reposts int
+	Replies int
+}
+
+// Count returns the Counts of the mentions, where replies by email are
+// replies. Plain mentions aren't counted.
+func Count(list []*Mention) Counts {
+	ret := Counts{}
+	for _, m := range list {
+		switch m.Type {
+		case LIKE:
+			ret.Likes++
+		case REPOST:
+			ret.Reposts++
+		case REPLY, EMAIL:
+			ret.Replies++
+		}
+	}
+	return ret
+}
+
+// Approve makes a pending mention visible.
+func (m *Mentions) Approve(ctx context.Context, id string) error {
+	_, err := m.DS.Client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var mention Mention
+		if err := tx.Get(m.key(id), &mention); err != nil {
+			return err
+		}
+		mention.Pending = false
+		_, err := tx.Put(m.key(id), &mention)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to approve mention %q: %s", id, err)
+	}
+	return nil
+}
+
+// All calls f for every mention, in no particular order, stopping at the
+// first error.
+func (m *Mentions) All(ctx context.Context, f func(*Mention) error) error {
+	it := m.DS.Client.Run(ctx, m.DS.NewQuery(MENTION))
+	for {
+		mention := &Mention{}
+		key, err := it.Next(mention)
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Failed while reading mentions: %s", err)
+		}
+		mention.ID = key.Name
+		if err := f(mention); err != nil {
+			return err
+		}
+	}
+}
+
+// Restore writes the mention exactly as given, including its ID.
+func (m *Mentions) Restore(ctx context.Context, mention *Mention) error {
+	if mention.ID == "" {
+		return fmt.Errorf("Mention must have an ID.")
+	}
+	_, err := m.DS.Client.Put(ctx, m.key(mention.ID), mention)
+	return err
+}
@@ -0,0 +1,19 @@
+package mentions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCount(t *testing.T) {
+	assert.Equal(t, Counts{Likes: 2, Reposts: 1, Replies: 2}, Count([]*Mention{
+		{Type: LIKE},
+		{Type: REPLY},
+		{Type: LIKE},
+		{Type: PLAIN},
+		{Type: REPOST},
+		{Type: EMAIL},
+	}))
+	assert.Equal(t, Counts{}, Count(nil))
+}
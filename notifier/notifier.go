@@ -0,0 +1,123 @@
+// Package notifier sends short notifications to the site admin, such as when
+// a webmention fails to send.
+package notifier
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jcgregorio/slog"
+)
+
+// Notifier sends a notification to the site admin.
+type Notifier interface {
+	Send(subject, body string) error
+}
+
+// Nop is a Notifier that just logs the notification, used when no mail
+// server is configured.
+type Nop struct {
+	log slog.Logger
+}
+
+// NewNop returns a new Nop.
+func NewNop(log slog.Logger) *Nop {
+	return &Nop{
+		log: log,
+	}
+}
+
+// Send implements Notifier.
+func (n *Nop) Send(subject, body string) error {
+	n.log.Infof("Notification: %s", subject)
+	return nil
+}
+
+// SMTP is a Notifier that sends email through an SMTP server.
+type SMTP struct {
+	host     string
+	port     int
+	user     string
+	password string
+	from     string
+	to       []string
+}
+
+// NewSMTP returns a new SMTP Notifier. If user is empty then no
+// authentication is done.
+func NewSMTP(host string, port int, user, password, from string, to []string) (*SMTP, error) {
+	if host == "" {
+		return nil, fmt.Errorf("SMTP host must be supplied.")
+	}
+	if len(to) == 0 {
+		return nil, fmt.Errorf("At least one recipient must be supplied.")
+	}
+	if from == "" {
+		from = to[0]
+	}
+	return &SMTP{
+		host:     host,
+		port:     port,
+		user:     user,
+		password: password,
+		from:     from,
+		to:       to,
+	}, nil
+}
+
+// Send implements Notifier.
+func (s *SMTP) Send(subject, body string) error {
+	return s.Mail(s.to, subject, TEXT, body)
+}
+
+// Mail sends an email with the given content type to arbitrary recipients,
+// for example newsletter subscribers.
+func (s *SMTP) Mail(to []string, subject, contentType, body string) error {
+	var auth smtp.Auth
+	if s.user != "" {
+		auth = smtp.PlainAuth("", s.user, s.password, s.host)
+	}
+	addr := net.JoinHostPort(s.host, strconv.Itoa(s.port))
+	if err := smtp.SendMail(addr, auth, s.from, to, Message(s.from, to, subject, contentType, body)); err != nil {
+		return fmt.Errorf("Failed to send email %q: %s", subject, err)
+	}
+	return nil
+}
+
+// Content types for Message.
+const (
+	TEXT = "text/plain"
+	HTML = "text/html"
+)
+
+// headerValue returns s with every control character, including "\r" and
+// "\n", replaced by a space, so that text from strangers, such as the name of
+// a webmention's author, can't add headers to a message.
+func headerValue(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+// Message formats an email message.
+func Message(from string, to []string, subject, contentType, body string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headerValue(subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: %s; charset=UTF-8\r\n", contentType)
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return b.Bytes()
+}
@@ -0,0 +1,35 @@
+package notifier
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessage(t *testing.T) {
+	b := string(Message("stream@example.org", []string{"a@example.org", "b@example.org"}, "Webmention\nfailed", TEXT, "Line one.\nLine two."))
+	assert.Contains(t, b, "From: stream@example.org\r\n")
+	assert.Contains(t, b, "To: a@example.org, b@example.org\r\n")
+	assert.Contains(t, b, "Subject: Webmention failed\r\n")
+	assert.Contains(t, b, "Content-Type: text/plain; charset=UTF-8\r\n")
+	assert.True(t, strings.HasSuffix(b, "\r\n\r\nLine one.\r\nLine two."))
+}
+
+func TestMessage_SubjectInjection(t *testing.T) {
+	b := string(Message("stream@example.org", []string{"a@example.org"}, "Reply from Eve\rBcc: victim@example.org\x00", TEXT, "Body."))
+	assert.Contains(t, b, "Subject: Reply from Eve Bcc: victim@example.org \r\n")
+	assert.NotContains(t, b, "\rBcc")
+}
+
+func TestNewSMTP(t *testing.T) {
+	_, err := NewSMTP("", 25, "", "", "", []string{"a@example.org"})
+	assert.Error(t, err)
+
+	_, err = NewSMTP("smtp.example.org", 25, "", "", "", nil)
+	assert.Error(t, err)
+
+	s, err := NewSMTP("smtp.example.org", 25, "", "", "", []string{"a@example.org"})
+	assert.NoError(t, err)
+	assert.Equal(t, "a@example.org", s.from)
+}
@@ -0,0 +1,206 @@
+// Package outbox records every attempt to send a webmention, so failed sends
+// can be reviewed and retried instead of being lost in the logs. WebSub pings
+// are also recorded, and push notifications when they are only logged in a
+// dry run.
+package outbox
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+
+	"github.com/jcgregorio/go-lib/ds"
+	"github.com/jcgregorio/slog"
+)
+
+const (
+	WEBMENTION_OUT ds.Kind = "WebmentionOut"
+)
+
+// Kinds of attempts other than webmentions, whose Kind is "".
+const (
+	WEBSUB = "websub"
+	PUSH   = "push"
+)
+
+// Attempt is a single attempt to send a webmention.
+type Attempt struct {
+	ID      string `datastore:"-"`
+	EntryID string `datastore:"entry_id"`
+	Source  string `datastore:"source,noindex"`
+	Target  string `datastore:"target,noindex"`
+
+	// Endpoint is the target's webmention endpoint, or "" if discovery
+	// failed.
+	Endpoint string `datastore:"endpoint,noindex"`
+
+	// Status is the HTTP status code returned by the endpoint, or 0 if there
+	// was no response.
+	Status int    `datastore:"status,noindex"`
+	Error  string `datastore:"error,noindex"`
+
+	// Kind is "" for webmentions, or WEBSUB or PUSH. WebSub pings are
+	// recorded for the entry that caused them.
+	Kind string `datastore:"kind,noindex"`
+
+	// DryRun is true if the attempt was only logged and not sent.
+	DryRun bool `datastore:"dry_run,noindex"`
+
+	Created time.Time `datastore:"created"`
+}
+
+// IsWebmention returns true if the attempt is a webmention, as opposed to
+// another kind of outbound request.
+func (a *Attempt) IsWebmention() bool {
+	return a.Kind == ""
+}
+
+// OK returns true if the endpoint accepted the webmention.
+func (a *Attempt) OK() bool {
+	return a.Error == "" && a.Status >= 200 && a.Status < 300
+}
+
+// Outbox stores webmention send attempts.
+type Outbox struct {
+	DS  *ds.DS
+	log slog.Logger
+}
+
+func New(ctx context.Context, project, ns string, log slog.Logger) (*Outbox, error) {
+	d, err := ds.New(ctx, project, ns)
+	if err != nil {
+		return nil, err
+	}
+	return &Outbox{
+		DS:  d,
+		log: log,
+	}, nil
+}
+
+// Record stores the attempt, setting its Created time if it isn't set.
+func (o *Outbox) Record(ctx context.Context, a *Attempt) error {
+	if a.Created.IsZero() {
+		a.Created = time.Now()
+	}
+	a.ID = fmt.Sprintf("%x", md5.Sum([]byte(a.EntryID+" "+a.Target+" "+a.Created.Format(time.RFC3339Nano))))
+	key := o.DS.NewKey(WEBMENTION_OUT)
+	key.Name = a.ID
+	if _, err := o.DS.Client.Put(ctx, key, a); err != nil {
+		return fmt.Errorf("Failed to record webmention to %q: %s", a.Target, err)
+	}
+	return nil
+}
+
+func (o *Outbox) list(ctx context.Context, q *datastore.Query) ([]*Attempt, error) {
+	ret := []*Attempt{}
+	it := o.DS.Client.Run(ctx, q)
+	for {
+		a := &Attempt{}
+		key, err := it.Next(a)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed while reading webmention attempts: %s", err)
+		}
+		a.ID = key.Name
+		ret = append(ret, a)
+	}
+	return ret, nil
+}
+
+// ForEntry returns every attempt to send a webmention for the entry, newest
+// first.
+//
+// Sorting is done here to avoid needing a composite index.
+func (o *Outbox) ForEntry(ctx context.Context, entryID string) ([]*Attempt, error) {
+	ret, err := o.list(ctx, o.DS.NewQuery(WEBMENTION_OUT).Filter("entry_id =", entryID))
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Created.After(ret[j].Created)
+	})
+	return ret, nil
+}
+
+// Since returns the attempts made after t, newest first.
+func (o *Outbox) Since(ctx context.Context, t time.Time) ([]*Attempt, error) {
+	return o.list(ctx, o.DS.NewQuery(WEBMENTION_OUT).Filter("created >", t).Order("-created"))
+}
+
+// Recent returns the n most recent attempts, newest first.
+func (o *Outbox) Recent(ctx context.Context, n int) ([]*Attempt, error) {
+	return o.list(ctx, o.DS.NewQuery(WEBMENTION_OUT).Order("-created").Limit(n))
+}
+
+// Latest returns the newest attempt for each entry and target in attempts,
+// newest first.
+func Latest(attempts []*Attempt) []*Attempt {
+	sorted := append([]*Attempt{}, attempts...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Created.After(sorted[j].Created)
+	})
+	ret := []*Attempt{}
+	seen := map[string]bool{}
+	for _, a := range sorted {
+		k := a.EntryID + " " + a.Target
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		ret = append(ret, a)
+	}
+	return ret
+}
+
+// Status is where one entry has been syndicated, from its attempts.
+type Status struct {
+	// Bridges are the newest attempt to each bridge, by target.
+	Bridges map[string]*Attempt
+
+	// WebSub is the newest WebSub ping, or nil if there wasn't one.
+	WebSub *Attempt
+
+	// Webmentions are the newest attempts to every other target, and Failed
+	// is how many of them weren't accepted, not counting dry runs.
+	Webmentions []*Attempt
+	Failed      int
+}
+
+// Summarize returns the Status of each entry in attempts, by EntryID, where
+// bridges are the targets that syndicate entries.
+func Summarize(attempts []*Attempt, bridges []string) map[string]*Status {
+	isBridge := map[string]bool{}
+	for _, b := range bridges {
+		isBridge[b] = true
+	}
+	ret := map[string]*Status{}
+	for _, a := range Latest(attempts) {
+		status, ok := ret[a.EntryID]
+		if !ok {
+			status = &Status{Bridges: map[string]*Attempt{}}
+			ret[a.EntryID] = status
+		}
+		switch {
+		case a.Kind == WEBSUB:
+			if status.WebSub == nil {
+				status.WebSub = a
+			}
+		case !a.IsWebmention():
+		case isBridge[a.Target]:
+			status.Bridges[a.Target] = a
+		default:
+			status.Webmentions = append(status.Webmentions, a)
+			if !a.OK() && !a.DryRun {
+				status.Failed++
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,50 @@
+package outbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttempt_OK(t *testing.T) {
+	assert.True(t, (&Attempt{Status: 202}).OK())
+	assert.False(t, (&Attempt{Status: 400}).OK())
+	assert.False(t, (&Attempt{Error: "no endpoint found"}).OK())
+	assert.False(t, (&Attempt{}).OK())
+	assert.False(t, (&Attempt{DryRun: true}).OK())
+}
+
+func TestLatest(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	old := &Attempt{EntryID: "a", Target: "https://example.org/", Status: 500, Created: t0}
+	retry := &Attempt{EntryID: "a", Target: "https://example.org/", Status: 202, Created: t0.Add(time.Hour)}
+	other := &Attempt{EntryID: "a", Target: "https://example.com/", Error: "no endpoint", Created: t0.Add(time.Minute)}
+	otherEntry := &Attempt{EntryID: "b", Target: "https://example.org/", Status: 201, Created: t0}
+	assert.Equal(t, []*Attempt{retry, other, otherEntry}, Latest([]*Attempt{old, other, otherEntry, retry}))
+	assert.Empty(t, Latest(nil))
+}
+
+func TestSummarize(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	bridge := "https://brid.gy/publish/mastodon"
+	failedBridge := &Attempt{EntryID: "a", Target: bridge, Status: 500, Created: t0}
+	retriedBridge := &Attempt{EntryID: "a", Target: bridge, Status: 201, Created: t0.Add(time.Hour)}
+	ping := &Attempt{EntryID: "a", Target: "https://hub.example/", Kind: WEBSUB, Status: 204, Created: t0}
+	push := &Attempt{EntryID: "a", Target: "https://push.example/", Kind: PUSH, DryRun: true, Created: t0}
+	sent := &Attempt{EntryID: "a", Target: "https://example.org/", Status: 202, Created: t0}
+	failed := &Attempt{EntryID: "a", Target: "https://example.com/", Error: "no endpoint", Created: t0}
+	dryRun := &Attempt{EntryID: "b", Target: "https://example.org/", DryRun: true, Created: t0}
+
+	got := Summarize([]*Attempt{failedBridge, retriedBridge, ping, push, sent, failed, dryRun}, []string{bridge})
+	assert.Len(t, got, 2)
+	assert.Equal(t, map[string]*Attempt{bridge: retriedBridge}, got["a"].Bridges)
+	assert.Equal(t, ping, got["a"].WebSub)
+	assert.ElementsMatch(t, []*Attempt{sent, failed}, got["a"].Webmentions)
+	assert.Equal(t, 1, got["a"].Failed)
+
+	assert.Empty(t, got["b"].Bridges)
+	assert.Nil(t, got["b"].WebSub)
+	assert.Equal(t, []*Attempt{dryRun}, got["b"].Webmentions)
+	assert.Equal(t, 0, got["b"].Failed)
+}
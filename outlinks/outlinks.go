@@ -0,0 +1,206 @@
+// Package outlinks is an in-memory index of the links entries make to other
+// sites, which turns years of link posts into a searchable collection of
+// bookmarks. Like the search index it is small enough to be rebuilt whenever
+// entries change.
+package outlinks
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Link is a link from an entry to another site.
+type Link struct {
+	URL string
+
+	// Domain is the host of the URL without any "www.".
+	Domain string
+
+	// Title is the text of the link, or the URL if it has none.
+	Title string
+
+	EntryID    string
+	EntryTitle string
+	Created    time.Time
+
+	// words are the distinct words of the Title, EntryTitle, and URL.
+	words map[string]bool
+}
+
+// Domain is a domain and how many links there are to it.
+type Domain struct {
+	Name  string
+	Count int
+}
+
+// domain returns the host of u without any "www.", or "" if u isn't an
+// absolute http(s) URL.
+func domain(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+}
+
+// Extract returns the links in html, the rendered content of an entry, that
+// point somewhere other than host. Archived copies of dead links aren't
+// included, and each URL is only returned once.
+func Extract(html, host string) []*Link {
+	ret := []*Link{}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return ret
+	}
+	self := domain(host)
+	seen := map[string]bool{}
+	doc.Find("a[href]").Not(".archived").Each(func(i int, s *goquery.Selection) {
+		href := s.AttrOr("href", "")
+		d := domain(href)
+		if d == "" || d == self || seen[href] {
+			return
+		}
+		seen[href] = true
+		title := strings.Join(strings.Fields(s.Text()), " ")
+		if title == "" {
+			title = href
+		}
+		ret = append(ret, &Link{
+			URL:    href,
+			Domain: d,
+			Title:  title,
+		})
+	})
+	return ret
+}
+
+// NewLink returns a Link to u titled title, for links that aren't in the
+// content of an entry, such as what a bookmark is of. Returns nil if u isn't
+// an absolute http(s) URL.
+func NewLink(u, title string) *Link {
+	d := domain(u)
+	if d == "" {
+		return nil
+	}
+	if title == "" {
+		title = u
+	}
+	return &Link{
+		URL:    u,
+		Domain: d,
+		Title:  title,
+	}
+}
+
+// words splits s into lowercase words.
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Index finds links by domain and by the words in them.
+type Index struct {
+	// links are newest first.
+	links   []*Link
+	domains []Domain
+}
+
+// New indexes the links.
+func New(links []*Link) *Index {
+	counts := map[string]int{}
+	for _, l := range links {
+		l.words = map[string]bool{}
+		for _, w := range words(l.Title + " " + l.EntryTitle + " " + l.URL) {
+			l.words[w] = true
+		}
+		counts[l.Domain]++
+	}
+	sort.SliceStable(links, func(i, j int) bool {
+		return links[i].Created.After(links[j].Created)
+	})
+	domains := []Domain{}
+	for name, n := range counts {
+		domains = append(domains, Domain{Name: name, Count: n})
+	}
+	sort.Slice(domains, func(i, j int) bool {
+		if domains[i].Count != domains[j].Count {
+			return domains[i].Count > domains[j].Count
+		}
+		return domains[i].Name < domains[j].Name
+	})
+	return &Index{
+		links:   links,
+		domains: domains,
+	}
+}
+
+// Len returns the number of links in the index.
+func (i *Index) Len() int {
+	return len(i.links)
+}
+
+// Domains returns the n domains linked to most.
+func (i *Index) Domains(n int) []Domain {
+	if n > len(i.domains) {
+		n = len(i.domains)
+	}
+	return i.domains[:n]
+}
+
+// matches returns true if the link has every term as a word, or for the
+// last term, as the start of a word.
+func (l *Link) matches(terms []string) bool {
+	for j, t := range terms {
+		if l.words[t] {
+			continue
+		}
+		if j < len(terms)-1 {
+			return false
+		}
+		found := false
+		for w := range l.words {
+			if strings.HasPrefix(w, t) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Find returns up to n links, newest first, skipping the first offset, that
+// contain every word in q and are to the domain d, or to any domain if d is
+// "". A domain also matches its subdomains. The returned bool is true if
+// there are more links after these.
+func (i *Index) Find(q, d string, n, offset int) ([]*Link, bool) {
+	terms := words(q)
+	d = strings.TrimPrefix(strings.ToLower(d), "www.")
+	ret := []*Link{}
+	skipped := 0
+	for _, l := range i.links {
+		if d != "" && l.Domain != d && !strings.HasSuffix(l.Domain, "."+d) {
+			continue
+		}
+		if !l.matches(terms) {
+			continue
+		}
+		if skipped < offset {
+			skipped++
+			continue
+		}
+		if len(ret) == n {
+			return ret, true
+		}
+		ret = append(ret, l)
+	}
+	return ret, false
+}
@@ -0,0 +1,81 @@
+package outlinks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func urls(links []*Link) []string {
+	ret := []string{}
+	for _, l := range links {
+		ret = append(ret, l.URL)
+	}
+	return ret
+}
+
+func TestExtract(t *testing.T) {
+	links := Extract(`<p>See <a href="https://www.Example.com/a">the
+  first   post</a>, <a href="https://example.com/a">again</a>,
+<a href="https://bitworking.org/news/1">my own</a>, <a href="/tag/go">#go</a>,
+<a href="mailto:joe@example.com">mail</a>, <a href="https://web.archive.org/x" class=archived>(archived)</a>
+and <a href="http://blog.example.org/b"><img src="x.png"></a>.</p>`, "https://bitworking.org")
+	assert.Equal(t, []string{"https://www.Example.com/a", "https://example.com/a", "http://blog.example.org/b"}, urls(links))
+	assert.Equal(t, "example.com", links[0].Domain)
+	assert.Equal(t, "the first post", links[0].Title)
+	assert.Equal(t, "blog.example.org", links[2].Domain)
+	assert.Equal(t, "http://blog.example.org/b", links[2].Title)
+}
+
+func TestNewLink(t *testing.T) {
+	assert.Nil(t, NewLink("ftp://example.com/", ""))
+	l := NewLink("https://www.example.com/", "")
+	assert.Equal(t, "example.com", l.Domain)
+	assert.Equal(t, "https://www.example.com/", l.Title)
+}
+
+func testIndex() *Index {
+	day := func(d int) time.Time {
+		return time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+	return New([]*Link{
+		{URL: "https://golang.org/doc", Domain: "golang.org", Title: "Go documentation", EntryID: "1", Created: day(1)},
+		{URL: "https://blog.golang.org/generics", Domain: "blog.golang.org", Title: "Generics", EntryTitle: "Go news", EntryID: "2", Created: day(3)},
+		{URL: "https://example.com/recipes", Domain: "example.com", Title: "Soup", EntryID: "3", Created: day(2)},
+		{URL: "https://example.com/go", Domain: "example.com", Title: "Board games", EntryID: "4", Created: day(4)},
+	})
+}
+
+func TestFind(t *testing.T) {
+	i := testIndex()
+	assert.Equal(t, 4, i.Len())
+
+	links, more := i.Find("", "", 10, 0)
+	assert.Equal(t, []string{"https://example.com/go", "https://blog.golang.org/generics", "https://example.com/recipes", "https://golang.org/doc"}, urls(links))
+	assert.False(t, more)
+
+	links, more = i.Find("", "", 2, 1)
+	assert.Equal(t, []string{"https://blog.golang.org/generics", "https://example.com/recipes"}, urls(links))
+	assert.True(t, more)
+
+	// Words match the link text, the entry title, and the URL, and the last
+	// word may be a prefix.
+	links, _ = i.Find("go", "", 10, 0)
+	assert.Equal(t, []string{"https://example.com/go", "https://blog.golang.org/generics", "https://golang.org/doc"}, urls(links))
+	links, _ = i.Find("go doc", "", 10, 0)
+	assert.Equal(t, []string{"https://golang.org/doc"}, urls(links))
+	links, _ = i.Find("documentation soup", "", 10, 0)
+	assert.Empty(t, links)
+
+	// Domains match their subdomains.
+	links, _ = i.Find("", "www.golang.org", 10, 0)
+	assert.Equal(t, []string{"https://blog.golang.org/generics", "https://golang.org/doc"}, urls(links))
+	links, _ = i.Find("go", "example.com", 10, 0)
+	assert.Equal(t, []string{"https://example.com/go"}, urls(links))
+}
+
+func TestDomains(t *testing.T) {
+	assert.Equal(t, []Domain{{"example.com", 2}, {"blog.golang.org", 1}}, testIndex().Domains(2))
+	assert.Len(t, testIndex().Domains(10), 3)
+}
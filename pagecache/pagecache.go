@@ -0,0 +1,180 @@
+// Package pagecache caches whole rendered responses in memory.
+package pagecache
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/jcgregorio/stream-run/cachecontrol"
+)
+
+// page is a cached response.
+type page struct {
+	header http.Header
+	body   []byte
+}
+
+// Cache holds rendered pages, keyed by request URL.
+type Cache struct {
+	// max is the number of pages cached before the cache is emptied.
+	max int
+
+	// policy is the Cache-Control header added to cacheable responses.
+	policy cachecontrol.Policy
+
+	// skip returns true for requests that shouldn't be served from, or
+	// stored in, the cache, such as those from admins.
+	skip func(r *http.Request) bool
+
+	// variant returns what, besides the URL, the response depends on, such
+	// as the reader's language. May be nil.
+	variant func(r *http.Request) string
+
+	mutex sync.Mutex
+	pages map[string]*page
+
+	// last holds the most recent response for each key, which, unlike
+	// pages, survives Clear so it can be served by Last when pages can't be
+	// rendered. Responses marked private or no-store are never kept.
+	last map[string]*page
+}
+
+// New returns a Cache that holds up to max pages. Responses are sent with a
+// Cache-Control header that lets shared caches, such as a CDN, keep them for
+// sMaxAge seconds and then serve them stale for up to staleWhileRevalidate
+// seconds while fetching a fresh copy.
+func New(max, sMaxAge, staleWhileRevalidate int, skip func(r *http.Request) bool) *Cache {
+	return &Cache{
+		max:    max,
+		policy: cachecontrol.Shared(sMaxAge, staleWhileRevalidate),
+		skip:   skip,
+		pages:  map[string]*page{},
+		last:   map[string]*page{},
+	}
+}
+
+// SetVariant makes responses depend on variant as well as the URL, e.g. the
+// language negotiated from the request. It must be called before the cache
+// is used.
+func (c *Cache) SetVariant(variant func(r *http.Request) string) {
+	c.variant = variant
+}
+
+// Clear empties the cache, which should be done whenever entries change.
+func (c *Cache) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pages = map[string]*page{}
+}
+
+// Purge empties the cache and forgets the last responses too, which should
+// be done whenever entries are deleted or hidden, so that they aren't served
+// again, not even by Last.
+func (c *Cache) Purge() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.pages = map[string]*page{}
+	c.last = map[string]*page{}
+}
+
+// key returns the key of the response to r.
+func (c *Cache) key(r *http.Request) string {
+	key := r.URL.String()
+	if c.variant != nil {
+		key += " " + c.variant(r)
+	}
+	return key
+}
+
+// Last returns the headers and body of the most recent successful response
+// to a request like r, even if the cache was cleared since, for serving
+// something when a fresh page can't be rendered.
+func (c *Cache) Last(r *http.Request) (http.Header, []byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	p, ok := c.last[c.key(r)]
+	if !ok {
+		return nil, nil, false
+	}
+	return p.header.Clone(), p.body, true
+}
+
+func (c *Cache) get(key string) (*page, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	p, ok := c.pages[key]
+	return p, ok
+}
+
+func (c *Cache) put(key string, p *page) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.pages) >= c.max {
+		c.pages = map[string]*page{}
+	}
+	c.pages[key] = p
+	if _, ok := c.last[key]; !ok && len(c.last) >= c.max {
+		c.last = map[string]*page{}
+	}
+	c.last[key] = p
+}
+
+// recorder captures a response while also writing it to the client.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// cacheable returns false if the response headers forbid sharing it, i.e.
+// the handler replaced the shared policy with a private or no-store one.
+func cacheable(header http.Header) bool {
+	cc := header.Get("Cache-Control")
+	return !strings.Contains(cc, "private") && !strings.Contains(cc, "no-store")
+}
+
+// Middleware wraps h so that successful GET responses are cached. HEAD
+// requests are served from the cache but never fill it, and neither do
+// responses the handler marks private or no-store.
+func (c *Cache) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != "GET" && r.Method != "HEAD") || c.skip(r) {
+			cachecontrol.Set(w, cachecontrol.PRIVATE)
+			h.ServeHTTP(w, r)
+			return
+		}
+		key := c.key(r)
+		if p, ok := c.get(key); ok {
+			for k, v := range p.header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("X-Page-Cache", "hit")
+			if r.Method == "GET" {
+				w.Write(p.body)
+			}
+			return
+		}
+		cachecontrol.Set(w, c.policy)
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(rec, r)
+		if r.Method != "GET" || rec.status != http.StatusOK || !cacheable(w.Header()) {
+			return
+		}
+		c.put(key, &page{
+			header: w.Header().Clone(),
+			body:   rec.body.Bytes(),
+		})
+	})
+}
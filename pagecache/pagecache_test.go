@@ -0,0 +1,136 @@
+package pagecache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	calls := 0
+	status := http.StatusOK
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "call %d", calls)
+	})
+	c := New(10, 60, 3600, func(r *http.Request) bool {
+		return r.Header.Get("Cookie") != ""
+	})
+	m := c.Middleware(h)
+
+	get := func(path string, cookie bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		if cookie {
+			r.Header.Set("Cookie", "admin=1")
+		}
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, r)
+		return w
+	}
+
+	w := get("/", false)
+	assert.Equal(t, "call 1", w.Body.String())
+	assert.Equal(t, "public, max-age=0, s-maxage=60, stale-while-revalidate=3600", w.Header().Get("Cache-Control"))
+
+	w = get("/", false)
+	assert.Equal(t, "call 1", w.Body.String())
+	assert.Equal(t, "hit", w.Header().Get("X-Page-Cache"))
+	assert.Equal(t, "text/html", w.Header().Get("Content-Type"))
+
+	// Query parameters are part of the key.
+	w = get("/?offset=10", false)
+	assert.Equal(t, "call 2", w.Body.String())
+
+	// Skipped requests bypass the cache.
+	w = get("/", true)
+	assert.Equal(t, "call 3", w.Body.String())
+	assert.Equal(t, "private, no-cache", w.Header().Get("Cache-Control"))
+
+	c.Clear()
+	w = get("/", false)
+	assert.Equal(t, "call 4", w.Body.String())
+
+	// Errors aren't cached.
+	status = http.StatusNotFound
+	get("/missing", false)
+	w = get("/missing", false)
+	assert.Equal(t, "call 6", w.Body.String())
+}
+
+func TestLast(t *testing.T) {
+	calls := 0
+	status := http.StatusOK
+	c := New(10, 60, 3600, func(r *http.Request) bool { return false })
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, "call %d", calls)
+	}))
+	r := httptest.NewRequest("GET", "/", nil)
+	_, _, ok := c.Last(r)
+	assert.False(t, ok)
+
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	c.Clear()
+	header, body, ok := c.Last(r)
+	assert.True(t, ok)
+	assert.Equal(t, "call 1", string(body))
+	assert.Equal(t, "text/html", header.Get("Content-Type"))
+
+	// Failures don't replace the last good response.
+	status = http.StatusInternalServerError
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	_, body, _ = c.Last(r)
+	assert.Equal(t, "call 1", string(body))
+
+	c.Purge()
+	_, _, ok = c.Last(r)
+	assert.False(t, ok)
+}
+
+func TestMiddleware_Max(t *testing.T) {
+	calls := 0
+	m := New(1, 60, 3600, func(r *http.Request) bool { return false }).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	for _, path := range []string{"/a", "/b", "/a"} {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	assert.Equal(t, 3, calls)
+}
+
+func TestMiddleware_Variant(t *testing.T) {
+	calls := 0
+	c := New(10, 60, 3600, func(r *http.Request) bool { return false })
+	c.SetVariant(func(r *http.Request) string { return r.Header.Get("Accept-Language") })
+	m := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	}))
+	for _, lang := range []string{"en", "de", "en"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Language", lang)
+		m.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestMiddleware_Private(t *testing.T) {
+	calls := 0
+	c := New(10, 60, 3600, func(r *http.Request) bool { return false })
+	m := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Cache-Control", "private, no-store")
+	}))
+	r := httptest.NewRequest("GET", "/", nil)
+	m.ServeHTTP(httptest.NewRecorder(), r)
+	m.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, 2, calls)
+	_, _, ok := c.Last(r)
+	assert.False(t, ok)
+}
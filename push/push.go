@@ -0,0 +1,152 @@
+// Package push stores Web Push subscriptions and sends notifications to them.
+package push
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	webpush "github.com/SherClockHolmes/webpush-go"
+	"google.golang.org/api/iterator"
+
+	"github.com/jcgregorio/go-lib/ds"
+	"github.com/jcgregorio/slog"
+)
+
+const (
+	SUBSCRIPTION ds.Kind = "PushSubscription"
+)
+
+// Subscription is a Push API subscription as sent from the browser.
+type Subscription struct {
+	Endpoint string    `datastore:"endpoint,noindex" json:"endpoint"`
+	P256dh   string    `datastore:"p256dh,noindex" json:"-"`
+	Auth     string    `datastore:"auth,noindex" json:"-"`
+	Created  time.Time `datastore:"created" json:"-"`
+}
+
+// Message is the JSON payload delivered to the service worker.
+type Message struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+type Push struct {
+	DS         *ds.DS
+	log        slog.Logger
+	publicKey  string
+	privateKey string
+	subscriber string
+	client     *http.Client
+}
+
+// New returns a new Push. The VAPID keys are base64 url encoded and
+// subscriber is a mailto: or https: URL identifying the sender. Pushes are
+// sent with client.
+func New(ctx context.Context, project, ns, publicKey, privateKey, subscriber string, client *http.Client, log slog.Logger) (*Push, error) {
+	d, err := ds.New(ctx, project, ns)
+	if err != nil {
+		return nil, err
+	}
+	return &Push{
+		DS:         d,
+		log:        log,
+		publicKey:  publicKey,
+		privateKey: privateKey,
+		subscriber: subscriber,
+		client:     client,
+	}, nil
+}
+
+// ParseSubscription parses the JSON serialization of a PushSubscription.
+func ParseSubscription(b []byte) (*Subscription, error) {
+	var in struct {
+		Endpoint string `json:"endpoint"`
+		Keys     struct {
+			P256dh string `json:"p256dh"`
+			Auth   string `json:"auth"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &in); err != nil {
+		return nil, fmt.Errorf("Failed to decode subscription: %s", err)
+	}
+	if in.Endpoint == "" || in.Keys.P256dh == "" || in.Keys.Auth == "" {
+		return nil, fmt.Errorf("Subscription is missing required fields.")
+	}
+	return &Subscription{
+		Endpoint: in.Endpoint,
+		P256dh:   in.Keys.P256dh,
+		Auth:     in.Keys.Auth,
+	}, nil
+}
+
+func (p *Push) key(endpoint string) *datastore.Key {
+	key := p.DS.NewKey(SUBSCRIPTION)
+	key.Name = fmt.Sprintf("%x", md5.Sum([]byte(endpoint)))
+	return key
+}
+
+// Subscribe stores the subscription, replacing any previous subscription
+// with the same endpoint.
+func (p *Push) Subscribe(ctx context.Context, sub *Subscription) error {
+	sub.Created = time.Now()
+	_, err := p.DS.Client.Put(ctx, p.key(sub.Endpoint), sub)
+	return err
+}
+
+// Unsubscribe removes the subscription with the given endpoint.
+func (p *Push) Unsubscribe(ctx context.Context, endpoint string) error {
+	return p.DS.Client.Delete(ctx, p.key(endpoint))
+}
+
+// SendAll sends the message to every subscription. Subscriptions the push
+// service reports as gone are removed.
+func (p *Push) SendAll(ctx context.Context, msg *Message) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	q := p.DS.NewQuery(SUBSCRIPTION)
+	it := p.DS.Client.Run(ctx, q)
+	for {
+		sub := &Subscription{}
+		_, err := it.Next(sub)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Failed while reading subscriptions: %s", err)
+		}
+		resp, err := webpush.SendNotification(b, &webpush.Subscription{
+			Endpoint: sub.Endpoint,
+			Keys: webpush.Keys{
+				P256dh: sub.P256dh,
+				Auth:   sub.Auth,
+			},
+		}, &webpush.Options{
+			Subscriber:      p.subscriber,
+			VAPIDPublicKey:  p.publicKey,
+			VAPIDPrivateKey: p.privateKey,
+			TTL:             int((24 * time.Hour).Seconds()),
+			HTTPClient:      p.client,
+		})
+		if err != nil {
+			p.log.Warningf("Failed to send push to %q: %s", sub.Endpoint, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+			p.log.Infof("Removing expired push subscription: %q", sub.Endpoint)
+			if err := p.Unsubscribe(ctx, sub.Endpoint); err != nil {
+				p.log.Warningf("Failed to remove push subscription: %s", err)
+			}
+		} else if resp.StatusCode >= 400 {
+			p.log.Warningf("Failed to send push to %q: %s", sub.Endpoint, resp.Status)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package push
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSubscription(t *testing.T) {
+	sub, err := ParseSubscription([]byte(`{"endpoint": "https://push.example.org/abc", "expirationTime": null, "keys": {"p256dh": "BNc", "auth": "tBH"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "https://push.example.org/abc", sub.Endpoint)
+	assert.Equal(t, "BNc", sub.P256dh)
+	assert.Equal(t, "tBH", sub.Auth)
+
+	_, err = ParseSubscription([]byte(`{"endpoint": "https://push.example.org/abc"}`))
+	assert.Error(t, err)
+
+	_, err = ParseSubscription([]byte(`not json`))
+	assert.Error(t, err)
+}
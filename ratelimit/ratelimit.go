@@ -0,0 +1,154 @@
+// Package ratelimit provides per-client token bucket rate limiting as HTTP
+// middleware, with temporary bans for clients that keep exceeding the limit.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MAX_CLIENTS is the number of tracked clients above which idle ones are
+// forgotten.
+const MAX_CLIENTS = 10000
+
+type client struct {
+	tokens      float64
+	last        time.Time
+	violations  int
+	bannedUntil time.Time
+}
+
+// Limiter is a set of token buckets keyed by client.
+type Limiter struct {
+	// perSecond is the rate at which tokens are added to a bucket.
+	perSecond float64
+
+	// burst is the size of a bucket.
+	burst int
+
+	// banAfter is the number of rejected requests after which a client is
+	// banned, 0 to never ban.
+	banAfter int
+	banFor   time.Duration
+
+	// trustedProxies is the number of proxies in front of the server that
+	// each append the address they received a request from to
+	// X-Forwarded-For.
+	trustedProxies int
+
+	// now is replaceable for testing.
+	now func() time.Time
+
+	mutex   sync.Mutex
+	clients map[string]*client
+}
+
+// New returns a Limiter that allows perMinute requests per minute from each
+// client, with bursts of up to burst requests. A client that has banAfter
+// requests rejected is banned for banFor.
+func New(perMinute float64, burst, banAfter int, banFor time.Duration) *Limiter {
+	return &Limiter{
+		perSecond: perMinute / 60,
+		burst:     burst,
+		banAfter:  banAfter,
+		banFor:    banFor,
+		now:       time.Now,
+		clients:   map[string]*client{},
+	}
+}
+
+// SetTrustedProxies sets the number of proxies in front of the server whose
+// X-Forwarded-For entries are believed, 0 to identify clients only by the
+// address they connect from. It must be called before the Limiter is used.
+func (l *Limiter) SetTrustedProxies(n int) {
+	l.trustedProxies = n
+}
+
+// Allow reports whether a request from the client identified by key is
+// allowed. If not, it also returns how long the client should wait before
+// retrying.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	c, ok := l.clients[key]
+	if !ok {
+		if len(l.clients) >= MAX_CLIENTS {
+			l.prune(now)
+		}
+		c = &client{
+			tokens: float64(l.burst),
+			last:   now,
+		}
+		l.clients[key] = c
+	}
+	if now.Before(c.bannedUntil) {
+		return false, c.bannedUntil.Sub(now)
+	}
+	c.tokens = math.Min(float64(l.burst), c.tokens+now.Sub(c.last).Seconds()*l.perSecond)
+	c.last = now
+	if c.tokens >= 1 {
+		c.tokens -= 1
+		c.violations = 0
+		return true, 0
+	}
+	c.violations += 1
+	if l.banAfter > 0 && c.violations >= l.banAfter {
+		c.violations = 0
+		c.bannedUntil = now.Add(l.banFor)
+		return false, l.banFor
+	}
+	return false, time.Duration((1 - c.tokens) / l.perSecond * float64(time.Second))
+}
+
+// prune forgets clients that aren't banned and whose buckets have refilled.
+func (l *Limiter) prune(now time.Time) {
+	for key, c := range l.clients {
+		if now.After(c.bannedUntil) && c.tokens+now.Sub(c.last).Seconds()*l.perSecond >= float64(l.burst) {
+			delete(l.clients, key)
+		}
+	}
+}
+
+// ClientIP returns the IP address of the client that made the request. Each
+// of the trustedProxies in front of the server appends the address it was
+// reached from to X-Forwarded-For, so the client is the trustedProxies-th
+// address from the right; anything to the left of that was sent by the
+// client and can't be believed. Falls back to the address of the connection
+// if there are no trusted proxies or the header is too short.
+func ClientIP(r *http.Request, trustedProxies int) string {
+	if trustedProxies > 0 {
+		var hops []string
+		for _, v := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(v, ",")...)
+		}
+		if len(hops) >= trustedProxies {
+			if ip := strings.TrimSpace(hops[len(hops)-trustedProxies]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Middleware wraps h so that requests from clients over the limit are
+// rejected with a 429.
+func (l *Limiter) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, retry := l.Allow(ClientIP(r, l.trustedProxies)); !ok {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(retry.Seconds()))))
+			http.Error(w, "Too many requests.", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
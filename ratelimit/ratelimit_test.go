@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (f *fakeClock) now() time.Time {
+	return f.t
+}
+
+func newForTesting(perMinute float64, burst, banAfter int, banFor time.Duration) (*Limiter, *fakeClock) {
+	l := New(perMinute, burst, banAfter, banFor)
+	clock := &fakeClock{t: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l.now = clock.now
+	return l, clock
+}
+
+func TestAllow_Burst(t *testing.T) {
+	l, clock := newForTesting(60, 2, 0, 0)
+	ok, _ := l.Allow("a")
+	assert.True(t, ok)
+	ok, _ = l.Allow("a")
+	assert.True(t, ok)
+	ok, retry := l.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, retry)
+
+	// Other clients have their own buckets.
+	ok, _ = l.Allow("b")
+	assert.True(t, ok)
+
+	// One token is added per second.
+	clock.t = clock.t.Add(time.Second)
+	ok, _ = l.Allow("a")
+	assert.True(t, ok)
+	ok, _ = l.Allow("a")
+	assert.False(t, ok)
+}
+
+func TestAllow_Ban(t *testing.T) {
+	l, clock := newForTesting(60, 1, 2, time.Hour)
+	ok, _ := l.Allow("a")
+	assert.True(t, ok)
+	ok, _ = l.Allow("a")
+	assert.False(t, ok)
+	ok, retry := l.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, time.Hour, retry)
+
+	// Still banned even though the bucket has refilled.
+	clock.t = clock.t.Add(time.Minute)
+	ok, retry = l.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, 59*time.Minute, retry)
+
+	clock.t = clock.t.Add(time.Hour)
+	ok, _ = l.Allow("a")
+	assert.True(t, ok)
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	assert.Equal(t, "192.0.2.1", ClientIP(r, 1))
+	r.Header.Set("X-Forwarded-For", "198.51.100.1, 10.0.0.1")
+	assert.Equal(t, "10.0.0.1", ClientIP(r, 1))
+	assert.Equal(t, "198.51.100.1", ClientIP(r, 2))
+	assert.Equal(t, "192.0.2.1", ClientIP(r, 3))
+	assert.Equal(t, "192.0.2.1", ClientIP(r, 0))
+}
+
+func TestClientIP_Spoofed(t *testing.T) {
+	// The client sends its own X-Forwarded-For, and the proxy appends the
+	// address it really came from.
+	r := httptest.NewRequest("POST", "/", nil)
+	r.RemoteAddr = "10.0.0.2:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, 198.51.100.1")
+	assert.Equal(t, "198.51.100.1", ClientIP(r, 1))
+
+	// Spoofing a new address on every request doesn't escape the limit.
+	l, _ := newForTesting(60, 1, 0, 0)
+	l.SetTrustedProxies(1)
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("X-Forwarded-For", fmt.Sprintf("203.0.113.%d, 198.51.100.1", i))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(t, want, w.Code)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	l, _ := newForTesting(60, 1, 0, 0)
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/", nil))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+}
@@ -0,0 +1,236 @@
+// Package receiver verifies webmentions sent to entries, see
+// https://www.w3.org/TR/webmention/#receiving-webmentions, and the vouches
+// that may come with them, see https://indieweb.org/Vouch.
+package receiver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/jcgregorio/stream-run/mentions"
+)
+
+// MAX_CONTENT is the maximum length in runes of a mention's content.
+const MAX_CONTENT = 1000
+
+// ErrNoLink is returned if the source doesn't link to the target.
+var ErrNoLink = errors.New("Source does not link to target.")
+
+// ErrGone is returned if the source has been deleted, i.e. it returns a 410
+// or 404.
+var ErrGone = errors.New("Source is gone.")
+
+// ValidURL parses u and returns an error if it isn't an absolute http or
+// https URL.
+func ValidURL(u string) (*url.URL, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %q: %s", u, err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("Not an http(s) URL: %q", u)
+	}
+	return parsed, nil
+}
+
+// Domain returns the lowercase hostname of u without any "www." prefix, or
+// "" if u can't be parsed.
+func Domain(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.")
+}
+
+// sameURL compares URLs ignoring fragments.
+func sameURL(a, b string) bool {
+	strip := func(s string) string {
+		if i := strings.Index(s, "#"); i >= 0 {
+			return s[:i]
+		}
+		return s
+	}
+	return strip(a) == strip(b)
+}
+
+// links returns every link in doc.
+func links(doc *goquery.Document) []*goquery.Selection {
+	ret := []*goquery.Selection{}
+	doc.Find("a[href], link[href]").Each(func(_ int, s *goquery.Selection) {
+		ret = append(ret, s)
+	})
+	return ret
+}
+
+func resolve(base *url.URL, href string) string {
+	u, err := base.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return ""
+	}
+	return u.String()
+}
+
+func truncate(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	r := []rune(s)
+	if len(r) > MAX_CONTENT {
+		return string(r[:MAX_CONTENT]) + "…"
+	}
+	return s
+}
+
+// Parse returns the mention of target in the HTML of the page at source, or
+// ErrNoLink if the page doesn't link to target.
+//
+// The type of the mention comes from the class of the link, e.g.
+// u-in-reply-to, and the author and content from the page's h-entry.
+func Parse(r io.Reader, source *url.URL, target string) (*mentions.Mention, error) {
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return nil, err
+	}
+	ret := &mentions.Mention{
+		Source: source.String(),
+		Type:   mentions.PLAIN,
+	}
+	found := false
+	for _, s := range links(doc) {
+		if !sameURL(resolve(source, s.AttrOr("href", "")), target) {
+			continue
+		}
+		found = true
+		switch {
+		case s.HasClass("u-in-reply-to"):
+			ret.Type = mentions.REPLY
+		case s.HasClass("u-like-of"):
+			ret.Type = mentions.LIKE
+		case s.HasClass("u-repost-of"):
+			ret.Type = mentions.REPOST
+		}
+	}
+	if !found {
+		return nil, ErrNoLink
+	}
+
+	entry := doc.Find(".h-entry").First()
+	if entry.Length() == 0 {
+		entry = doc.Selection
+	}
+	if author := entry.Find(".p-author, .u-author").First(); author.Length() > 0 {
+		if author.HasClass("h-card") {
+			ret.AuthorName = strings.TrimSpace(author.Find(".p-name").First().Text())
+			if ret.AuthorName == "" {
+				ret.AuthorName = strings.TrimSpace(author.Text())
+			}
+			ret.AuthorURL = author.Find(".u-url").First().AttrOr("href", author.AttrOr("href", ""))
+			if photo := author.Find(".u-photo").First(); photo.Length() > 0 {
+				ret.AuthorPhoto = resolve(source, photo.AttrOr("src", ""))
+			}
+		} else {
+			ret.AuthorName = strings.TrimSpace(author.Text())
+			ret.AuthorURL = author.AttrOr("href", "")
+		}
+		if ret.AuthorURL != "" {
+			ret.AuthorURL = resolve(source, ret.AuthorURL)
+		}
+	}
+	if ret.AuthorName == "" {
+		ret.AuthorName = Domain(source.String())
+	}
+	ret.Content = truncate(entry.Find(".e-content, .p-content").First().Text())
+	ret.Published = time.Now()
+	if dt, ok := entry.Find(".dt-published").First().Attr("datetime"); ok {
+		if t, err := time.Parse(time.RFC3339, dt); err == nil {
+			ret.Published = t
+		}
+	}
+	return ret, nil
+}
+
+// LinksToDomain returns true if the HTML of the page at base links to any
+// page on the given domain.
+func LinksToDomain(r io.Reader, base *url.URL, domain string) bool {
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return false
+	}
+	for _, s := range links(doc) {
+		if Domain(resolve(base, s.AttrOr("href", ""))) == domain {
+			return true
+		}
+	}
+	return false
+}
+
+// Receiver fetches sources and vouches to verify them.
+type Receiver struct {
+	client *http.Client
+}
+
+func New(client *http.Client) *Receiver {
+	return &Receiver{
+		client: client,
+	}
+}
+
+// get fetches u and calls f with the body if the response is a 200. It
+// returns ErrGone for a 404 or 410.
+func (r *Receiver) get(ctx context.Context, u *url.URL, f func(io.Reader) error) error {
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/html")
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("Failed to fetch %q: %s", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone || resp.StatusCode == http.StatusNotFound {
+		return ErrGone
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to fetch %q: %s", u, resp.Status)
+	}
+	return f(io.LimitReader(resp.Body, 2*1024*1024))
+}
+
+// Verify fetches source and returns the mention of target it contains. It
+// returns ErrNoLink or ErrGone if the source no longer mentions target.
+func (r *Receiver) Verify(ctx context.Context, source, target string) (*mentions.Mention, error) {
+	u, err := ValidURL(source)
+	if err != nil {
+		return nil, err
+	}
+	var ret *mentions.Mention
+	err = r.get(ctx, u, func(body io.Reader) error {
+		ret, err = Parse(body, u, target)
+		return err
+	})
+	return ret, err
+}
+
+// Vouch fetches the vouch URL and returns an error if it doesn't link to the
+// domain of source, i.e. if the vouching site doesn't know the sender.
+func (r *Receiver) Vouch(ctx context.Context, vouch, source string) error {
+	u, err := ValidURL(vouch)
+	if err != nil {
+		return err
+	}
+	domain := Domain(source)
+	return r.get(ctx, u, func(body io.Reader) error {
+		if !LinksToDomain(body, u, domain) {
+			return fmt.Errorf("Vouch %q does not link to %q", vouch, domain)
+		}
+		return nil
+	})
+}
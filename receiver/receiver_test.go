@@ -0,0 +1,111 @@
+package receiver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jcgregorio/stream-run/mentions"
+	"github.com/stretchr/testify/assert"
+)
+
+const reply = `<html><body>
+<div class="h-entry">
+  <a class="p-author h-card" href="/"><img class="u-photo" src="/me.png"> <span class="p-name">Fred</span></a>
+  <a class="u-in-reply-to" href="https://bitworking.org/entry/abc">In reply to</a>
+  <time class="dt-published" datetime="2020-01-02T03:04:05Z"></time>
+  <div class="e-content">Nice   post.</div>
+</div>
+</body></html>`
+
+func TestValidURL(t *testing.T) {
+	_, err := ValidURL("https://example.org/post")
+	assert.NoError(t, err)
+	_, err = ValidURL("ftp://example.org/post")
+	assert.Error(t, err)
+	_, err = ValidURL("/post")
+	assert.Error(t, err)
+}
+
+func TestDomain(t *testing.T) {
+	assert.Equal(t, "example.org", Domain("https://WWW.Example.org/post"))
+	assert.Equal(t, "example.org", Domain("http://example.org:8080/"))
+	assert.Equal(t, "", Domain("%%"))
+}
+
+func TestParse_Reply(t *testing.T) {
+	source, _ := url.Parse("https://example.org/posts/1")
+	m, err := Parse(strings.NewReader(reply), source, "https://bitworking.org/entry/abc")
+	assert.NoError(t, err)
+	assert.Equal(t, mentions.REPLY, m.Type)
+	assert.Equal(t, "https://example.org/posts/1", m.Source)
+	assert.Equal(t, "Fred", m.AuthorName)
+	assert.Equal(t, "https://example.org/", m.AuthorURL)
+	assert.Equal(t, "https://example.org/me.png", m.AuthorPhoto)
+	assert.Equal(t, "Nice post.", m.Content)
+	assert.Equal(t, 2020, m.Published.Year())
+}
+
+func TestParse_PlainMention(t *testing.T) {
+	source, _ := url.Parse("https://example.org/posts/2")
+	m, err := Parse(strings.NewReader(`<p>See <a href="https://bitworking.org/entry/abc#comments">this</a>.</p>`), source, "https://bitworking.org/entry/abc")
+	assert.NoError(t, err)
+	assert.Equal(t, mentions.PLAIN, m.Type)
+	assert.Equal(t, "example.org", m.AuthorName)
+}
+
+func TestParse_NoLink(t *testing.T) {
+	source, _ := url.Parse("https://example.org/posts/3")
+	_, err := Parse(strings.NewReader(`<p>Nothing to see.</p>`), source, "https://bitworking.org/entry/abc")
+	assert.Equal(t, ErrNoLink, err)
+}
+
+func TestLinksToDomain(t *testing.T) {
+	base, _ := url.Parse("https://friend.example/blogroll")
+	assert.True(t, LinksToDomain(strings.NewReader(`<a href="https://www.example.org/">Example</a>`), base, "example.org"))
+	assert.False(t, LinksToDomain(strings.NewReader(`<a href="/about">About</a>`), base, "example.org"))
+}
+
+func TestVouch(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/blogroll" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `<a href="https://example.org/">Example</a>`)
+	}))
+	defer ts.Close()
+
+	r := New(ts.Client())
+	assert.NoError(t, r.Vouch(context.Background(), ts.URL+"/blogroll", "https://example.org/posts/1"))
+	assert.Error(t, r.Vouch(context.Background(), ts.URL+"/blogroll", "https://spam.example/posts/1"))
+	assert.Error(t, r.Vouch(context.Background(), ts.URL+"/missing", "https://example.org/posts/1"))
+}
+
+func TestVerify_Gone(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/deleted":
+			w.WriteHeader(http.StatusGone)
+		case "/edited":
+			fmt.Fprint(w, `<div class="h-entry"><p class="e-content">Nothing to see.</p></div>`)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	r := New(ts.Client())
+	target := "https://bitworking.org/entry/abc"
+	_, err := r.Verify(context.Background(), ts.URL+"/deleted", target)
+	assert.Equal(t, ErrGone, err)
+	_, err = r.Verify(context.Background(), ts.URL+"/missing", target)
+	assert.Error(t, err)
+	assert.NotEqual(t, ErrGone, err)
+	_, err = r.Verify(context.Background(), ts.URL+"/edited", target)
+	assert.Equal(t, ErrNoLink, err)
+}
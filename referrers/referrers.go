@@ -0,0 +1,227 @@
+// Package referrers counts the sites that link to the stream, from the
+// Referer header of page views.
+//
+// Only the referring page, with any query and fragment removed, is kept. No
+// IP addresses or cookies are stored, so views can't be tied to visitors.
+package referrers
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+
+	"github.com/jcgregorio/go-lib/ds"
+	"github.com/jcgregorio/slog"
+)
+
+const (
+	REFERRER ds.Kind = "Referrer"
+
+	// MAX_PAGES is the number of distinct referring pages kept per domain
+	// and day.
+	MAX_PAGES = 10
+
+	// DAY_FORMAT is the format of Referrer.Day.
+	DAY_FORMAT = "2006-01-02"
+)
+
+// Referrer is the number of views referred by a domain on a day, in UTC.
+type Referrer struct {
+	Domain string `datastore:"domain,noindex"`
+	Day    string `datastore:"day"`
+	Views  int64  `datastore:"views,noindex"`
+
+	// Pages are up to MAX_PAGES of the referring pages on the domain.
+	Pages []string `datastore:"pages,noindex"`
+}
+
+// Clean returns the referring page with the query and fragment removed, and
+// its domain without any "www." prefix. It returns "" for empty, non-http,
+// and unparseable referers, and for links from the domain self.
+func Clean(referer, self string) (string, string) {
+	u, err := url.Parse(referer)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", ""
+	}
+	domain := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if domain == strings.TrimPrefix(strings.ToLower(self), "www.") {
+		return "", ""
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	u.User = nil
+	u.Host = strings.ToLower(u.Host)
+	return u.String(), domain
+}
+
+// add merges views and pages into r, keeping at most MAX_PAGES pages.
+func (r *Referrer) add(views int64, pages []string) {
+	r.Views += views
+	for _, p := range pages {
+		if len(r.Pages) >= MAX_PAGES {
+			break
+		}
+		found := false
+		for _, existing := range r.Pages {
+			if existing == p {
+				found = true
+				break
+			}
+		}
+		if !found {
+			r.Pages = append(r.Pages, p)
+		}
+	}
+}
+
+// Summarize merges the referrers by domain, sorted by views, most first.
+func Summarize(refs []*Referrer) []*Referrer {
+	byDomain := map[string]*Referrer{}
+	for _, r := range refs {
+		sum, ok := byDomain[r.Domain]
+		if !ok {
+			sum = &Referrer{Domain: r.Domain}
+			byDomain[r.Domain] = sum
+		}
+		sum.add(r.Views, r.Pages)
+		if r.Day > sum.Day {
+			sum.Day = r.Day
+		}
+	}
+	ret := []*Referrer{}
+	for _, r := range byDomain {
+		ret = append(ret, r)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Views != ret[j].Views {
+			return ret[i].Views > ret[j].Views
+		}
+		return ret[i].Domain < ret[j].Domain
+	})
+	return ret
+}
+
+// Referrers counts referrals in memory and periodically adds them to the
+// counts in Datastore, so page views don't each cause a write.
+type Referrers struct {
+	DS  *ds.DS
+	log slog.Logger
+
+	// self is the stream's own hostname, whose referrals aren't counted.
+	self string
+
+	// now is replaceable for testing.
+	now func() time.Time
+
+	mutex   sync.Mutex
+	pending map[string]*Referrer
+}
+
+func New(ctx context.Context, project, ns, self string, log slog.Logger) (*Referrers, error) {
+	d, err := ds.New(ctx, project, ns)
+	if err != nil {
+		return nil, err
+	}
+	return &Referrers{
+		DS:      d,
+		log:     log,
+		self:    self,
+		now:     time.Now,
+		pending: map[string]*Referrer{},
+	}, nil
+}
+
+// Add counts a view referred by the given Referer header value.
+func (r *Referrers) Add(referer string) {
+	page, domain := Clean(referer, r.self)
+	if domain == "" {
+		return
+	}
+	day := r.now().UTC().Format(DAY_FORMAT)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	k := day + " " + domain
+	ref, ok := r.pending[k]
+	if !ok {
+		ref = &Referrer{Domain: domain, Day: day}
+		r.pending[k] = ref
+	}
+	ref.add(1, []string{page})
+}
+
+// Middleware counts the referrers of GET requests to h.
+func (r *Referrers) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == "GET" {
+			r.Add(req.Referer())
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+func (r *Referrers) key(domain, day string) *datastore.Key {
+	key := r.DS.NewKey(REFERRER)
+	key.Name = fmt.Sprintf("%x", md5.Sum([]byte(day+" "+domain)))
+	return key
+}
+
+// Flush adds the counts made since the last Flush to Datastore. Counts that
+// fail to be written are kept for the next Flush.
+func (r *Referrers) Flush(ctx context.Context) error {
+	r.mutex.Lock()
+	pending := r.pending
+	r.pending = map[string]*Referrer{}
+	r.mutex.Unlock()
+
+	var lastErr error
+	for k, ref := range pending {
+		_, err := r.DS.Client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+			key := r.key(ref.Domain, ref.Day)
+			stored := &Referrer{Domain: ref.Domain, Day: ref.Day}
+			if err := tx.Get(key, stored); err != nil && err != datastore.ErrNoSuchEntity {
+				return err
+			}
+			stored.add(ref.Views, ref.Pages)
+			_, err := tx.Put(key, stored)
+			return err
+		})
+		if err != nil {
+			lastErr = fmt.Errorf("Failed to store referrer %q: %s", ref.Domain, err)
+			r.mutex.Lock()
+			if newer, ok := r.pending[k]; ok {
+				ref.add(newer.Views, newer.Pages)
+			}
+			r.pending[k] = ref
+			r.mutex.Unlock()
+		}
+	}
+	return lastErr
+}
+
+// Since returns the referrers of the days from since onward, summarized by
+// domain.
+func (r *Referrers) Since(ctx context.Context, since time.Time) ([]*Referrer, error) {
+	refs := []*Referrer{}
+	it := r.DS.Client.Run(ctx, r.DS.NewQuery(REFERRER).Filter("day >=", since.UTC().Format(DAY_FORMAT)))
+	for {
+		ref := &Referrer{}
+		_, err := it.Next(ref)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed while reading referrers: %s", err)
+		}
+		refs = append(refs, ref)
+	}
+	return Summarize(refs), nil
+}
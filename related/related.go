@@ -0,0 +1,146 @@
+// Package related finds entries related to a given entry by scoring shared
+// tags and overlap between title words.
+package related
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// TAG_WEIGHT is how much more a shared tag counts than a shared title word.
+const TAG_WEIGHT = 2.0
+
+// stopWords are ignored when comparing titles.
+var stopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "with": true, "that": true,
+	"this": true, "from": true, "are": true, "was": true, "you": true,
+	"your": true, "not": true, "but": true, "have": true, "has": true,
+	"its": true, "into": true, "about": true, "how": true, "what": true,
+	"why": true, "when": true, "who": true, "will": true, "just": true,
+}
+
+// Candidate is an entry that may be related.
+type Candidate struct {
+	ID    string
+	Title string
+	Tags  []string
+}
+
+// Tokens returns the distinct, lowercased, significant words in a title.
+func Tokens(title string) []string {
+	seen := map[string]bool{}
+	ret := []string{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		if len([]rune(w)) < 3 || stopWords[w] || seen[w] {
+			continue
+		}
+		seen[w] = true
+		ret = append(ret, w)
+	}
+	return ret
+}
+
+func set(values []string) map[string]bool {
+	ret := map[string]bool{}
+	for _, v := range values {
+		ret[strings.ToLower(v)] = true
+	}
+	return ret
+}
+
+// jaccard returns the Jaccard index of two sets.
+func jaccard(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for k := range a {
+		if b[k] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// Score returns how related two candidates are, 0 meaning unrelated.
+func Score(a, b Candidate) float64 {
+	sharedTags := 0
+	bTags := set(b.Tags)
+	for t := range set(a.Tags) {
+		if bTags[t] {
+			sharedTags++
+		}
+	}
+	return TAG_WEIGHT*float64(sharedTags) + jaccard(set(Tokens(a.Title)), set(Tokens(b.Title)))
+}
+
+// Top returns the IDs of up to n candidates most related to target, best
+// first, ignoring unrelated candidates and the target itself.
+func Top(target Candidate, candidates []Candidate, n int) []string {
+	type scored struct {
+		id    string
+		score float64
+		index int
+	}
+	all := []scored{}
+	for i, c := range candidates {
+		if c.ID == target.ID {
+			continue
+		}
+		if s := Score(target, c); s > 0 {
+			all = append(all, scored{id: c.ID, score: s, index: i})
+		}
+	}
+	// Ties go to the earlier candidate, i.e. the more recent entry.
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].score != all[j].score {
+			return all[i].score > all[j].score
+		}
+		return all[i].index < all[j].index
+	})
+	ret := []string{}
+	for i := 0; i < len(all) && i < n; i++ {
+		ret = append(ret, all[i].id)
+	}
+	return ret
+}
+
+// Cache holds the computed related entry IDs per entry ID.
+type Cache struct {
+	mutex  sync.Mutex
+	values map[string][]string
+}
+
+// NewCache returns a new empty Cache.
+func NewCache() *Cache {
+	return &Cache{
+		values: map[string][]string{},
+	}
+}
+
+// Get returns the cached related IDs for id.
+func (c *Cache) Get(id string) ([]string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	v, ok := c.values[id]
+	return v, ok
+}
+
+// Set caches the related IDs for id.
+func (c *Cache) Set(id string, related []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.values[id] = related
+}
+
+// Clear empties the cache, which should be done whenever any entry changes
+// since that may change what is related to every other entry.
+func (c *Cache) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.values = map[string][]string{}
+}
@@ -0,0 +1,46 @@
+package related
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokens(t *testing.T) {
+	assert.Equal(t, []string{"running", "cloud", "run"}, Tokens("Running the Cloud-Run, the CLOUD run!"))
+	assert.Equal(t, []string{}, Tokens(""))
+	assert.Equal(t, []string{}, Tokens("a to of the"))
+}
+
+func TestScore(t *testing.T) {
+	a := Candidate{ID: "a", Title: "Go generics", Tags: []string{"golang"}}
+	assert.Equal(t, 0.0, Score(a, Candidate{ID: "b", Title: "Sourdough bread"}))
+	assert.Equal(t, TAG_WEIGHT, Score(a, Candidate{ID: "b", Title: "Sourdough", Tags: []string{"GoLang"}}))
+	assert.InDelta(t, 0.5, Score(a, Candidate{ID: "b", Title: "Go generics talk"}), 0.001)
+}
+
+func TestTop(t *testing.T) {
+	target := Candidate{ID: "t", Title: "Webmention sending", Tags: []string{"indieweb"}}
+	candidates := []Candidate{
+		{ID: "t", Title: "Webmention sending", Tags: []string{"indieweb"}},
+		{ID: "unrelated", Title: "Bread"},
+		{ID: "title", Title: "Webmention receiving"},
+		{ID: "tag", Title: "Microformats", Tags: []string{"indieweb"}},
+		{ID: "both", Title: "More webmention", Tags: []string{"indieweb"}},
+	}
+	assert.Equal(t, []string{"both", "tag", "title"}, Top(target, candidates, 5))
+	assert.Equal(t, []string{"both"}, Top(target, candidates, 1))
+}
+
+func TestCache(t *testing.T) {
+	c := NewCache()
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+	c.Set("a", []string{"b"})
+	v, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"b"}, v)
+	c.Clear()
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+}
@@ -0,0 +1,207 @@
+// Package replycontext fetches and caches information about pages that
+// entries reply to, so a quoted context can be displayed with the reply.
+package replycontext
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/PuerkitoBio/goquery"
+	"willnorris.com/go/microformats"
+
+	"github.com/jcgregorio/go-lib/ds"
+	"github.com/jcgregorio/slog"
+)
+
+const (
+	REPLY_CONTEXT ds.Kind = "ReplyContext"
+
+	// MAX_EXCERPT is the maximum length in runes of an excerpt.
+	MAX_EXCERPT = 280
+)
+
+// Context is the information displayed about the page being replied to.
+type Context struct {
+	URL         string    `datastore:"url,noindex"`
+	Name        string    `datastore:"name,noindex"`
+	AuthorName  string    `datastore:"author_name,noindex"`
+	AuthorURL   string    `datastore:"author_url,noindex"`
+	AuthorPhoto string    `datastore:"author_photo,noindex"`
+	Excerpt     string    `datastore:"excerpt,noindex"`
+	Photo       string    `datastore:"photo,noindex"`
+	Fetched     time.Time `datastore:"fetched,noindex"`
+}
+
+// InReplyTo returns the URL of the first u-in-reply-to link in the rendered
+// HTML of an entry, or "" if there isn't one.
+func InReplyTo(html string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return ""
+	}
+	return doc.Find(".u-in-reply-to").AttrOr("href", "")
+}
+
+// first returns the first property value as a string.
+func first(mf *microformats.Microformat, name string) string {
+	for _, v := range mf.Properties[name] {
+		switch v := v.(type) {
+		case string:
+			return v
+		case map[string]interface{}:
+			if s, ok := v["value"].(string); ok {
+				return s
+			}
+		case map[string]string:
+			return v["value"]
+		case *microformats.Microformat:
+			return v.Value
+		}
+	}
+	return ""
+}
+
+// author returns the first author as a nested h-card, if there is one.
+func author(mf *microformats.Microformat) *microformats.Microformat {
+	for _, v := range mf.Properties["author"] {
+		if a, ok := v.(*microformats.Microformat); ok {
+			return a
+		}
+	}
+	return nil
+}
+
+func findEntry(items []*microformats.Microformat) *microformats.Microformat {
+	for _, item := range items {
+		for _, t := range item.Type {
+			if t == "h-entry" {
+				return item
+			}
+		}
+		if found := findEntry(item.Children); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func truncate(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	r := []rune(s)
+	if len(r) > MAX_EXCERPT {
+		return string(r[:MAX_EXCERPT]) + "…"
+	}
+	return s
+}
+
+// Parse extracts a Context from the HTML of the page at u, preferring an
+// h-entry and falling back to the page title and description.
+func Parse(r io.Reader, u *url.URL) (*Context, error) {
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return nil, err
+	}
+	html, err := doc.Html()
+	if err != nil {
+		return nil, err
+	}
+	ret := &Context{
+		URL:     u.String(),
+		Fetched: time.Now(),
+	}
+	if entry := findEntry(microformats.Parse(strings.NewReader(html), u).Items); entry != nil {
+		ret.Name = first(entry, "name")
+		ret.Excerpt = first(entry, "summary")
+		if ret.Excerpt == "" {
+			ret.Excerpt = first(entry, "content")
+		}
+		// A note's name is just its content, so don't repeat it.
+		if strings.HasPrefix(strings.Join(strings.Fields(ret.Excerpt), " "), strings.Join(strings.Fields(ret.Name), " ")) {
+			ret.Name = ""
+		}
+		ret.Photo = first(entry, "photo")
+		if a := author(entry); a != nil {
+			ret.AuthorName = first(a, "name")
+			ret.AuthorURL = first(a, "url")
+			ret.AuthorPhoto = first(a, "photo")
+		} else {
+			ret.AuthorName = first(entry, "author")
+		}
+	} else {
+		ret.Name = strings.TrimSpace(doc.Find("title").First().Text())
+		ret.Excerpt = doc.Find("meta[property='og:description'], meta[name=description]").AttrOr("content", "")
+		ret.Photo = doc.Find("meta[property='og:image']").AttrOr("content", "")
+	}
+	ret.Name = truncate(ret.Name)
+	ret.Excerpt = truncate(ret.Excerpt)
+	return ret, nil
+}
+
+// ReplyContexts fetches reply contexts and caches them in Datastore.
+type ReplyContexts struct {
+	DS     *ds.DS
+	client *http.Client
+	log    slog.Logger
+}
+
+func New(ctx context.Context, project, ns string, client *http.Client, log slog.Logger) (*ReplyContexts, error) {
+	d, err := ds.New(ctx, project, ns)
+	if err != nil {
+		return nil, err
+	}
+	return &ReplyContexts{
+		DS:     d,
+		client: client,
+		log:    log,
+	}, nil
+}
+
+func (r *ReplyContexts) key(u string) *datastore.Key {
+	key := r.DS.NewKey(REPLY_CONTEXT)
+	key.Name = fmt.Sprintf("%x", md5.Sum([]byte(u)))
+	return key
+}
+
+// Get returns the cached Context for the URL, without fetching it.
+func (r *ReplyContexts) Get(ctx context.Context, u string) (*Context, error) {
+	var ret Context
+	if err := r.DS.Client.Get(ctx, r.key(u), &ret); err != nil {
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// Refresh fetches the page at u and caches its Context.
+func (r *ReplyContexts) Refresh(ctx context.Context, u string) (*Context, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to fetch %q: %s", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to fetch %q: %s", u, resp.Status)
+	}
+	c, err := Parse(io.LimitReader(resp.Body, 2*1024*1024), parsed)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %q: %s", u, err)
+	}
+	if _, err := r.DS.Client.Put(ctx, r.key(u), c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,54 @@
+package replycontext
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInReplyTo(t *testing.T) {
+	assert.Equal(t, "https://example.org/post", InReplyTo(`<p><a class='u-in-reply-to' href='https://example.org/post'>A post</a></p>`))
+	assert.Equal(t, "", InReplyTo(`<p><a href='https://example.org/post'>A post</a></p>`))
+}
+
+func TestParse_HEntry(t *testing.T) {
+	u, _ := url.Parse("https://example.org/post")
+	c, err := Parse(strings.NewReader(`<html><head><title>Ignored</title></head><body>
+<article class="h-entry">
+  <h1 class="p-name">A title</h1>
+  <a class="p-author h-card" href="/about"><img class="u-photo" src="/me.jpg" alt="">Fred</a>
+  <div class="e-content">Some   content
+  here.</div>
+</article>
+</body></html>`), u)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.org/post", c.URL)
+	assert.Equal(t, "A title", c.Name)
+	assert.Equal(t, "Some content here.", c.Excerpt)
+	assert.Equal(t, "Fred", c.AuthorName)
+	assert.Equal(t, "https://example.org/about", c.AuthorURL)
+	assert.Equal(t, "https://example.org/me.jpg", c.AuthorPhoto)
+}
+
+func TestParse_Note(t *testing.T) {
+	u, _ := url.Parse("https://example.org/note")
+	c, err := Parse(strings.NewReader(`<div class="h-entry"><p class="e-content p-name">Just a note.</p></div>`), u)
+	assert.NoError(t, err)
+	assert.Equal(t, "", c.Name)
+	assert.Equal(t, "Just a note.", c.Excerpt)
+}
+
+func TestParse_Fallback(t *testing.T) {
+	u, _ := url.Parse("https://example.org/page")
+	c, err := Parse(strings.NewReader(`<html><head><title> A page </title><meta name="description" content="About the page."></head></html>`), u)
+	assert.NoError(t, err)
+	assert.Equal(t, "A page", c.Name)
+	assert.Equal(t, "About the page.", c.Excerpt)
+}
+
+func TestTruncate(t *testing.T) {
+	long := strings.Repeat("é", MAX_EXCERPT+10)
+	assert.Equal(t, strings.Repeat("é", MAX_EXCERPT)+"…", truncate(long))
+}
@@ -0,0 +1,112 @@
+package resize
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// Markup rewrites the <img> tags in the HTML fragment h so they don't shift
+// the layout as they load, and so browsers can pick a smaller rendition:
+//
+//   - Every image gets loading="lazy".
+//   - Images under imagesPrefix, e.g. "/images/", get their width and height,
+//     and a srcset of the narrower renditions served from resizedPrefix,
+//     e.g. "/img/", followed by the width and path.
+//
+// Attributes already on a tag are kept. The rest of h is passed through
+// untouched.
+func (r *Resizer) Markup(h, imagesPrefix, resizedPrefix string) string {
+	if !strings.Contains(h, "<img") {
+		return h
+	}
+	widths := make([]int, 0, len(r.widths))
+	for width := range r.widths {
+		widths = append(widths, width)
+	}
+	sort.Ints(widths)
+
+	var out bytes.Buffer
+	z := xhtml.NewTokenizer(strings.NewReader(h))
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			if z.Err() != io.EOF {
+				return h
+			}
+			return out.String()
+		}
+		if tt != xhtml.StartTagToken && tt != xhtml.SelfClosingTagToken {
+			out.Write(z.Raw())
+			continue
+		}
+		raw := string(z.Raw())
+		token := z.Token()
+		if token.Data != "img" {
+			out.WriteString(raw)
+			continue
+		}
+		out.WriteString(r.img(token, tt == xhtml.SelfClosingTagToken, widths, imagesPrefix, resizedPrefix))
+	}
+}
+
+// img returns the rewritten tag of the image token.
+func (r *Resizer) img(token xhtml.Token, selfClosing bool, widths []int, imagesPrefix, resizedPrefix string) string {
+	attrs := map[string]string{}
+	for _, a := range token.Attr {
+		attrs[a.Key] = a.Val
+	}
+	add := func(key, value string) {
+		if _, ok := attrs[key]; ok {
+			return
+		}
+		attrs[key] = value
+		token.Attr = append(token.Attr, xhtml.Attribute{Key: key, Val: value})
+	}
+	add("loading", "lazy")
+
+	// Images the resizer can't read are left alone, since it can't resize
+	// them either.
+	if p := strings.TrimPrefix(attrs["src"], imagesPrefix); p != attrs["src"] && !strings.Contains(p, "?") {
+		if width, height, err := r.Size(p); err == nil {
+			// A tag with only one of them was sized by hand, and adding the
+			// other would change its aspect ratio.
+			_, hasWidth := attrs["width"]
+			_, hasHeight := attrs["height"]
+			if !hasWidth && !hasHeight {
+				add("width", fmt.Sprintf("%d", width))
+				add("height", fmt.Sprintf("%d", height))
+			}
+			candidates := []string{}
+			for _, w := range widths {
+				// Renditions aren't enlarged, so wider ones are all the
+				// original.
+				if w >= width {
+					break
+				}
+				candidates = append(candidates, fmt.Sprintf("%s%d/%s %dw", resizedPrefix, w, p, w))
+			}
+			if len(candidates) > 0 {
+				candidates = append(candidates, fmt.Sprintf("%s %dw", attrs["src"], width))
+				add("srcset", strings.Join(candidates, ", "))
+				add("sizes", fmt.Sprintf("(max-width: %dpx) 100vw, %dpx", width, width))
+			}
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("<img")
+	for _, a := range token.Attr {
+		fmt.Fprintf(&b, ` %s="%s"`, a.Key, html.EscapeString(a.Val))
+	}
+	if selfClosing {
+		b.WriteString(" /")
+	}
+	b.WriteString(">")
+	return b.String()
+}
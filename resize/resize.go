@@ -0,0 +1,176 @@
+// Package resize produces resized renditions of images, caching them by the
+// hash of the original image so that a changed original is never served
+// stale.
+package resize
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"sync"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+)
+
+// Format is an output image format.
+type Format string
+
+const (
+	JPEG Format = "image/jpeg"
+	PNG  Format = "image/png"
+	WEBP Format = "image/webp"
+)
+
+// Source returns the bytes of the original image at path.
+type Source func(path string) ([]byte, error)
+
+// Image is a resized rendition.
+type Image struct {
+	Body        []byte
+	ContentType string
+
+	// ETag is derived from the original content, the width, and the format.
+	ETag string
+}
+
+// Resizer resizes images from a Source into one of a fixed set of widths.
+type Resizer struct {
+	src    Source
+	widths map[int]bool
+
+	mutex   sync.Mutex
+	max     int
+	lru     *list.List
+	entries map[string]*list.Element
+
+	// sizes caches the dimensions of original images by path, see Size.
+	sizes map[string]image.Point
+}
+
+type cacheEntry struct {
+	key   string
+	image *Image
+}
+
+// New returns a new Resizer that only allows the given widths and keeps at
+// most maxCached renditions in memory.
+func New(src Source, widths []int, maxCached int) *Resizer {
+	w := map[int]bool{}
+	for _, width := range widths {
+		w[width] = true
+	}
+	return &Resizer{
+		src:     src,
+		widths:  w,
+		max:     maxCached,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+		sizes:   map[string]image.Point{},
+	}
+}
+
+// ValidWidth returns true if width is one of the allowed widths.
+func (r *Resizer) ValidWidth(width int) bool {
+	return r.widths[width]
+}
+
+func (r *Resizer) get(key string) (*Image, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if e, ok := r.entries[key]; ok {
+		r.lru.MoveToFront(e)
+		return e.Value.(*cacheEntry).image, true
+	}
+	return nil, false
+}
+
+func (r *Resizer) put(key string, img *Image) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.entries[key]; ok {
+		return
+	}
+	r.entries[key] = r.lru.PushFront(&cacheEntry{key: key, image: img})
+	for r.lru.Len() > r.max {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Size returns the width and height of the original image at path, which are
+// cached, so a changed original keeps its old size until restart.
+func (r *Resizer) Size(path string) (int, int, error) {
+	r.mutex.Lock()
+	size, ok := r.sizes[path]
+	r.mutex.Unlock()
+	if ok {
+		return size.X, size.Y, nil
+	}
+	b, err := r.src(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return 0, 0, fmt.Errorf("Failed to decode %q: %s", path, err)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.sizes[path] = image.Pt(config.Width, config.Height)
+	return config.Width, config.Height, nil
+}
+
+// Get returns the image at path resized to width, encoded as format. Images
+// narrower than width are not enlarged.
+func (r *Resizer) Get(path string, width int, format Format) (*Image, error) {
+	if !r.ValidWidth(width) {
+		return nil, fmt.Errorf("Width %d is not allowed.", width)
+	}
+	b, err := r.src(path)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%x-%d-%s", sha256.Sum256(b), width, format)
+	if img, ok := r.get(key); ok {
+		return img, nil
+	}
+	src, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode %q: %s", path, err)
+	}
+	var dst image.Image = src
+	bounds := src.Bounds()
+	if bounds.Dx() > width {
+		height := bounds.Dy() * width / bounds.Dx()
+		scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(scaled, scaled.Bounds(), src, bounds, draw.Over, nil)
+		dst = scaled
+	}
+	var out bytes.Buffer
+	switch format {
+	case WEBP:
+		err = nativewebp.Encode(&out, dst, nil)
+	case PNG:
+		err = png.Encode(&out, dst)
+	default:
+		format = JPEG
+		err = jpeg.Encode(&out, dst, &jpeg.Options{Quality: 85})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to encode %q: %s", path, err)
+	}
+	img := &Image{
+		Body:        out.Bytes(),
+		ContentType: string(format),
+		ETag:        fmt.Sprintf("%q", key[:16]+key[64:]),
+	}
+	r.put(key, img)
+	return img, nil
+}
@@ -0,0 +1,98 @@
+package resize
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testSource(width, height int) Source {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 0x80, A: 0xff})
+		}
+	}
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		panic(err)
+	}
+	return func(path string) ([]byte, error) {
+		if path != "photo.png" {
+			return nil, fmt.Errorf("Not found: %q", path)
+		}
+		return b.Bytes(), nil
+	}
+}
+
+func TestGet(t *testing.T) {
+	r := New(testSource(400, 200), []int{100, 800}, 10)
+
+	img, err := r.Get("photo.png", 100, PNG)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", img.ContentType)
+	decoded, _, err := image.Decode(bytes.NewReader(img.Body))
+	assert.NoError(t, err)
+	assert.Equal(t, 100, decoded.Bounds().Dx())
+	assert.Equal(t, 50, decoded.Bounds().Dy())
+
+	// Not enlarged.
+	img, err = r.Get("photo.png", 800, JPEG)
+	assert.NoError(t, err)
+	decoded, _, err = image.Decode(bytes.NewReader(img.Body))
+	assert.NoError(t, err)
+	assert.Equal(t, 400, decoded.Bounds().Dx())
+
+	// Cached.
+	again, err := r.Get("photo.png", 800, JPEG)
+	assert.NoError(t, err)
+	assert.True(t, img == again)
+	assert.NotEqual(t, img.ETag, "")
+
+	_, err = r.Get("photo.png", 123, JPEG)
+	assert.Error(t, err)
+
+	_, err = r.Get("missing.png", 100, JPEG)
+	assert.Error(t, err)
+}
+
+func TestCacheEviction(t *testing.T) {
+	r := New(testSource(10, 10), []int{1, 2, 3}, 2)
+	for _, w := range []int{1, 2, 3} {
+		_, err := r.Get("photo.png", w, PNG)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, r.lru.Len())
+	assert.Len(t, r.entries, 2)
+}
+
+func TestSize(t *testing.T) {
+	r := New(testSource(400, 200), []int{100}, 10)
+	width, height, err := r.Size("photo.png")
+	assert.NoError(t, err)
+	assert.Equal(t, 400, width)
+	assert.Equal(t, 200, height)
+	_, _, err = r.Size("missing.png")
+	assert.Error(t, err)
+}
+
+func TestMarkup(t *testing.T) {
+	r := New(testSource(400, 200), []int{640, 100, 320}, 10)
+	assert.Equal(t, "<p>No images.</p>", r.Markup("<p>No images.</p>", "/images/", "/img/"))
+	assert.Equal(t,
+		`<p>A <img src="/images/photo.png" alt="A &amp; B" loading="lazy" width="400" height="200" srcset="/img/100/photo.png 100w, /img/320/photo.png 320w, /images/photo.png 400w" sizes="(max-width: 400px) 100vw, 400px"> photo.</p>`,
+		r.Markup(`<p>A <img src="/images/photo.png" alt="A &amp; B"> photo.</p>`, "/images/", "/img/"))
+	// Existing attributes are kept, and only local images get a srcset.
+	assert.Equal(t,
+		`<img src="https://example.org/a.jpg" loading="eager" /><img src="/images/photo.png" width="40" loading="lazy" srcset="/img/100/photo.png 100w, /img/320/photo.png 320w, /images/photo.png 400w" sizes="(max-width: 400px) 100vw, 400px">`,
+		r.Markup(`<img src="https://example.org/a.jpg" loading="eager" /><img src="/images/photo.png" width="40">`, "/images/", "/img/"))
+	// Images that can't be read aren't resized.
+	assert.Equal(t,
+		`<img src="/images/missing.png" loading="lazy">`,
+		r.Markup(`<img src="/images/missing.png">`, "/images/", "/img/"))
+}
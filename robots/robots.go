@@ -0,0 +1,161 @@
+// Package robots generates robots.txt, and decides which responses get an
+// X-Robots-Tag header, so that the admin pages, and entries that aren't
+// public, stay out of search engines, and AI crawlers can be turned away.
+package robots
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// AI_CRAWLERS are the user agents of the crawlers that collect pages to
+// train or ground AI models, which Options.BlockAI disallows.
+var AI_CRAWLERS = []string{
+	"Amazonbot",
+	"anthropic-ai",
+	"Applebot-Extended",
+	"Bytespider",
+	"CCBot",
+	"ChatGPT-User",
+	"Claude-Web",
+	"ClaudeBot",
+	"cohere-ai",
+	"Diffbot",
+	"FacebookBot",
+	"Google-Extended",
+	"GPTBot",
+	"meta-externalagent",
+	"OAI-SearchBot",
+	"Omgilibot",
+	"PerplexityBot",
+	"Timpibot",
+}
+
+// Values of the X-Robots-Tag header.
+const (
+	// NOINDEX keeps a page that is only shared by link out of search
+	// results.
+	NOINDEX = "noindex"
+
+	// PRIVATE keeps a page out of search results and archives, and stops its
+	// links being followed.
+	PRIVATE = "noindex, nofollow, noarchive"
+)
+
+// Options are the ROBOTS config.
+type Options struct {
+	// Disallow are more path prefixes that no crawler may fetch, on top of
+	// the ones the site always disallows.
+	Disallow []string `mapstructure:"disallow"`
+
+	// Agents are the path prefixes that particular crawlers may not fetch,
+	// by user agent, e.g. {"BadBot": ["/"]}.
+	Agents map[string][]string `mapstructure:"agents"`
+
+	// BlockAI disallows the whole site to the AI_CRAWLERS.
+	BlockAI bool `mapstructure:"block_ai"`
+
+	// NoHeaders turns off the X-Robots-Tag headers.
+	NoHeaders bool `mapstructure:"no_headers"`
+}
+
+// validPath returns true if p can be a Disallow line.
+func validPath(p string) bool {
+	return strings.HasPrefix(p, "/") && !strings.ContainsAny(p, "\r\n")
+}
+
+// Robots serves robots.txt and adds X-Robots-Tag headers.
+type Robots struct {
+	opts Options
+
+	// text is robots.txt.
+	text string
+
+	// private are the path prefixes whose responses are PRIVATE.
+	private []string
+}
+
+// New returns Robots that always disallow the private path prefixes, such
+// as /admin, where sitemap, if not "", is the URL of a sitemap to list.
+func New(opts Options, private []string, sitemap string) (*Robots, error) {
+	all := append(append([]string{}, private...), opts.Disallow...)
+	for _, p := range all {
+		if !validPath(p) {
+			return nil, fmt.Errorf("Disallowed path %q must start with /.", p)
+		}
+	}
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	for _, p := range all {
+		fmt.Fprintf(&b, "Disallow: %s\n", p)
+	}
+	agents := []string{}
+	for agent := range opts.Agents {
+		if agent == "" || strings.ContainsAny(agent, "\r\n") {
+			return nil, fmt.Errorf("Invalid user agent %q.", agent)
+		}
+		agents = append(agents, agent)
+	}
+	sort.Strings(agents)
+	for _, agent := range agents {
+		fmt.Fprintf(&b, "\nUser-agent: %s\n", agent)
+		// Crawlers only follow the most specific group that matches them,
+		// so the rules for everyone are repeated.
+		for _, p := range append(append([]string{}, all...), opts.Agents[agent]...) {
+			if !validPath(p) {
+				return nil, fmt.Errorf("Disallowed path %q for %q must start with /.", p, agent)
+			}
+			fmt.Fprintf(&b, "Disallow: %s\n", p)
+		}
+	}
+	if opts.BlockAI {
+		b.WriteString("\n")
+		for _, agent := range AI_CRAWLERS {
+			fmt.Fprintf(&b, "User-agent: %s\n", agent)
+		}
+		b.WriteString("Disallow: /\n")
+	}
+	if sitemap != "" {
+		fmt.Fprintf(&b, "\nSitemap: %s\n", sitemap)
+	}
+	return &Robots{
+		opts:    opts,
+		text:    b.String(),
+		private: private,
+	}, nil
+}
+
+// Text returns robots.txt.
+func (r *Robots) Text() string {
+	return r.text
+}
+
+// ServeHTTP serves robots.txt.
+func (r *Robots) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, r.text)
+}
+
+// SetHeader adds an X-Robots-Tag header with the given value, unless headers
+// are turned off.
+func (r *Robots) SetHeader(w http.ResponseWriter, value string) {
+	if !r.opts.NoHeaders {
+		w.Header().Set("X-Robots-Tag", value)
+	}
+}
+
+// Middleware adds the PRIVATE X-Robots-Tag header to the responses from h
+// under the private path prefixes.
+func (r *Robots) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for _, p := range r.private {
+			if strings.HasPrefix(req.URL.Path, p) {
+				r.SetHeader(w, PRIVATE)
+				break
+			}
+		}
+		h.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,64 @@
+package robots
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestText(t *testing.T) {
+	r, err := New(Options{
+		Disallow: []string{"/drafts/"},
+		Agents:   map[string][]string{"BadBot": {"/"}},
+	}, []string{"/admin"}, "https://example.org/sitemap.xml")
+	assert.NoError(t, err)
+	assert.Equal(t, `User-agent: *
+Disallow: /admin
+Disallow: /drafts/
+
+User-agent: BadBot
+Disallow: /admin
+Disallow: /drafts/
+Disallow: /
+
+Sitemap: https://example.org/sitemap.xml
+`, r.Text())
+}
+
+func TestText_BlockAI(t *testing.T) {
+	r, err := New(Options{BlockAI: true}, []string{"/admin"}, "")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(r.Text(), "User-agent: *\nDisallow: /admin\n\nUser-agent: Amazonbot\n"))
+	assert.True(t, strings.HasSuffix(r.Text(), "User-agent: Timpibot\nDisallow: /\n"))
+	assert.Contains(t, r.Text(), "User-agent: GPTBot\n")
+}
+
+func TestNew_Invalid(t *testing.T) {
+	_, err := New(Options{Disallow: []string{"admin"}}, nil, "")
+	assert.Error(t, err)
+	_, err = New(Options{Disallow: []string{"/a\nAllow: /"}}, nil, "")
+	assert.Error(t, err)
+	_, err = New(Options{Agents: map[string][]string{"Bot\nUser-agent: *": {"/"}}}, nil, "")
+	assert.Error(t, err)
+}
+
+func TestMiddleware(t *testing.T) {
+	r, err := New(Options{}, []string{"/admin"}, "")
+	assert.NoError(t, err)
+	h := r.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/admin/edit/1", nil))
+	assert.Equal(t, PRIVATE, w.Header().Get("X-Robots-Tag"))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/entry/1", nil))
+	assert.Equal(t, "", w.Header().Get("X-Robots-Tag"))
+
+	r, err = New(Options{NoHeaders: true}, []string{"/admin"}, "")
+	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	r.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})).ServeHTTP(w, httptest.NewRequest("GET", "/admin", nil))
+	assert.Equal(t, "", w.Header().Get("X-Robots-Tag"))
+}
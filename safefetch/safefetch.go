@@ -0,0 +1,125 @@
+// Package safefetch provides HTTP clients for fetching URLs that come from
+// untrusted input, such as webmention sources and targets, shared pages, and
+// links in entries.
+//
+// The clients only connect to public addresses over http or https, so they
+// can't be used to reach services on the private network, and they have a
+// timeout, a limit on the size of response bodies, and a User-Agent.
+package safefetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+const (
+	// USER_AGENT is sent with every request.
+	USER_AGENT = "stream-run (+https://github.com/jcgregorio/stream-run)"
+
+	// MAX_BODY is the most bytes read from a response body, anything after
+	// that is dropped.
+	MAX_BODY = 2 * 1024 * 1024
+
+	// MAX_REDIRECTS is the most redirects followed.
+	MAX_REDIRECTS = 10
+)
+
+// ErrBlocked is returned when a request is for a disallowed address or
+// scheme.
+var ErrBlocked = errors.New("Destination is not allowed.")
+
+// Public returns true if ip is a globally routable unicast address, i.e. not
+// loopback, private, link-local, multicast, or unspecified.
+func Public(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	// Carrier-grade NAT, 100.64.0.0/10.
+	if ip4 := ip.To4(); ip4 != nil && ip4[0] == 100 && ip4[1]&0xc0 == 64 {
+		return false
+	}
+	return true
+}
+
+// limitedBody reads at most MAX_BODY bytes of a response body.
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// transport sets the User-Agent and limits response bodies.
+type transport struct {
+	base http.RoundTripper
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.URL.Scheme != "http" && r.URL.Scheme != "https" {
+		return nil, ErrBlocked
+	}
+	if r.Header.Get("User-Agent") == "" {
+		r = r.Clone(r.Context())
+		r.Header.Set("User-Agent", USER_AGENT)
+	}
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = limitedBody{Reader: io.LimitReader(resp.Body, MAX_BODY), Closer: resp.Body}
+	return resp, nil
+}
+
+// newClient returns a client that only dials addresses for which allow
+// returns true.
+func newClient(timeout time.Duration, allow func(net.IP) bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		// Checking the address being connected to, rather than the host in
+		// the URL, also catches hostnames that resolve to private addresses.
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if !allow(net.ParseIP(host)) {
+				return fmt.Errorf("%s: %w", address, ErrBlocked)
+			}
+			return nil
+		},
+	}
+	base := &http.Transport{
+		Proxy: nil,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &transport{base: base},
+		CheckRedirect: func(r *http.Request, via []*http.Request) error {
+			if len(via) >= MAX_REDIRECTS {
+				return fmt.Errorf("Stopped after %d redirects.", MAX_REDIRECTS)
+			}
+			return nil
+		},
+	}
+}
+
+// New returns a client for untrusted URLs with the given overall timeout, or
+// none if it is 0, e.g. when timeouts are set per request with a context.
+func New(timeout time.Duration) *http.Client {
+	return newClient(timeout, Public)
+}
@@ -0,0 +1,56 @@
+package safefetch
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublic(t *testing.T) {
+	for _, s := range []string{"8.8.8.8", "2001:4860:4860::8888", "93.184.216.34"} {
+		assert.True(t, Public(net.ParseIP(s)), s)
+	}
+	for _, s := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "100.64.0.1", "0.0.0.0", "::1", "fe80::1", "fd00::1", "224.0.0.1"} {
+		assert.False(t, Public(net.ParseIP(s)), s)
+	}
+	assert.False(t, Public(nil))
+}
+
+func TestNew_BlocksLoopback(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	_, err := New(5 * time.Second).Get(ts.URL)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrBlocked))
+}
+
+func TestClient_UserAgentAndBodyLimit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-User-Agent", r.UserAgent())
+		w.Write([]byte(strings.Repeat("x", MAX_BODY+100)))
+	}))
+	defer ts.Close()
+
+	c := newClient(5*time.Second, func(net.IP) bool { return true })
+	resp, err := c.Get(ts.URL)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, USER_AGENT, resp.Header.Get("X-User-Agent"))
+	b, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Len(t, b, MAX_BODY)
+}
+
+func TestClient_BlocksSchemes(t *testing.T) {
+	c := newClient(5*time.Second, func(net.IP) bool { return true })
+	_, err := c.Get("file:///etc/passwd")
+	assert.Error(t, err)
+}
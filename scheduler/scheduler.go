@@ -0,0 +1,207 @@
+// Package scheduler runs the periodic maintenance jobs, such as backups and
+// link checks, on cron schedules, and keeps the status of each job's last run
+// for the admin page.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jcgregorio/slog"
+)
+
+var (
+	// ErrUnknownJob is returned by Run for a name that wasn't added.
+	ErrUnknownJob = errors.New("Unknown job.")
+
+	// ErrRunning is returned by Run if the job is already running.
+	ErrRunning = errors.New("Job is already running.")
+)
+
+// Func is the work a job does.
+type Func func(ctx context.Context) error
+
+// Status is the state of a job, as displayed on the admin page.
+type Status struct {
+	Name string
+
+	// Spec is the cron expression the job runs on.
+	Spec string
+
+	// Next is when the job will next run, or the zero time if the scheduler
+	// hasn't been started.
+	Next time.Time
+
+	Running bool
+
+	// LastRun is when the last run started, or the zero time if the job
+	// hasn't run since the process started.
+	LastRun      time.Time
+	LastDuration time.Duration
+
+	// LastError is the error of the last run, or "" if it succeeded.
+	LastError string
+
+	Runs     int
+	Failures int
+}
+
+// job is a Func and its schedule.
+type job struct {
+	schedule Schedule
+	f        Func
+
+	// status is protected by Scheduler.mutex.
+	status Status
+}
+
+// Scheduler runs jobs on their schedules.
+//
+// Schedules are per process, so with more than one instance each instance
+// runs every job. The jobs are written to be safe to run that way.
+type Scheduler struct {
+	loc *time.Location
+	log slog.Logger
+
+	// now is time.Now, replaced in tests.
+	now func() time.Time
+
+	mutex   sync.Mutex
+	jobs    map[string]*job
+	started bool
+}
+
+// New returns a Scheduler that interprets cron expressions in loc.
+func New(loc *time.Location, log slog.Logger) *Scheduler {
+	return &Scheduler{
+		loc:  loc,
+		log:  log,
+		now:  time.Now,
+		jobs: map[string]*job{},
+	}
+}
+
+// Add adds the job name that runs f on the schedule spec, see Parse. It must
+// be called before Start.
+func (s *Scheduler) Add(name, spec string, f Func) error {
+	schedule, err := Parse(spec)
+	if err != nil {
+		return fmt.Errorf("Job %q: %s", name, err)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.started {
+		return fmt.Errorf("Job %q added after the scheduler started.", name)
+	}
+	if _, ok := s.jobs[name]; ok {
+		return fmt.Errorf("Job %q was already added.", name)
+	}
+	s.jobs[name] = &job{
+		schedule: schedule,
+		f:        f,
+		status: Status{
+			Name: name,
+			Spec: spec,
+		},
+	}
+	return nil
+}
+
+// Has returns true if the job name was added.
+func (s *Scheduler) Has(name string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, ok := s.jobs[name]
+	return ok
+}
+
+// Start runs each job on its schedule until ctx is cancelled.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.started = true
+	for name, j := range s.jobs {
+		go s.loop(ctx, name, j)
+	}
+}
+
+// loop waits for each scheduled time of j and runs it.
+func (s *Scheduler) loop(ctx context.Context, name string, j *job) {
+	for {
+		next := j.schedule.Next(s.now().In(s.loc))
+		s.mutex.Lock()
+		j.status.Next = next
+		s.mutex.Unlock()
+		if next.IsZero() {
+			s.log.Warningf("Job %q will never run.", name)
+			return
+		}
+		timer := time.NewTimer(next.Sub(s.now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := s.run(ctx, j); err == ErrRunning {
+			s.log.Warningf("Skipped job %q, the previous run hasn't finished.", name)
+		}
+	}
+}
+
+// Run runs the job name now, waiting for it to finish, and returns the error
+// of the run.
+func (s *Scheduler) Run(ctx context.Context, name string) error {
+	s.mutex.Lock()
+	j, ok := s.jobs[name]
+	s.mutex.Unlock()
+	if !ok {
+		return ErrUnknownJob
+	}
+	return s.run(ctx, j)
+}
+
+func (s *Scheduler) run(ctx context.Context, j *job) error {
+	s.mutex.Lock()
+	if j.status.Running {
+		s.mutex.Unlock()
+		return ErrRunning
+	}
+	j.status.Running = true
+	start := s.now()
+	s.mutex.Unlock()
+
+	err := j.f(ctx)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	j.status.Running = false
+	j.status.LastRun = start
+	j.status.LastDuration = s.now().Sub(start)
+	j.status.Runs++
+	j.status.LastError = ""
+	if err != nil {
+		j.status.Failures++
+		j.status.LastError = err.Error()
+		s.log.Warningf("Job %q failed: %s", j.status.Name, err)
+	}
+	return err
+}
+
+// Status returns the status of every job, sorted by name.
+func (s *Scheduler) Status() []Status {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ret := make([]Status, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		ret = append(ret, j.status)
+	}
+	sort.Slice(ret, func(i, k int) bool {
+		return ret[i].Name < ret[k].Name
+	})
+	return ret
+}
@@ -0,0 +1,131 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jcgregorio/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse_Next(t *testing.T) {
+	// A Monday.
+	now := time.Date(2020, 3, 16, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2020, 3, 16, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2020, 3, 16, 10, 15, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2020, 3, 17, 3, 0, 0, 0, time.UTC)},
+		{"30 9-17/4 * * *", time.Date(2020, 3, 16, 13, 30, 0, 0, time.UTC)},
+		{"0 0 * * sun", time.Date(2020, 3, 22, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2020, 3, 22, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// With both day fields restricted either one matches.
+		{"0 12 1 * fri", time.Date(2020, 3, 20, 12, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2020, 3, 17, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2020, 3, 16, 11, 0, 0, 0, time.UTC)},
+		{"@every 6h", now.Add(6 * time.Hour)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tc := range tests {
+		s, err := Parse(tc.spec)
+		assert.NoError(t, err, tc.spec)
+		assert.Equal(t, tc.want, s.Next(now), tc.spec)
+	}
+}
+
+func TestParse_Location(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err)
+	s, err := Parse("0 3 * * *")
+	assert.NoError(t, err)
+	next := s.Next(time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC).In(loc))
+	assert.Equal(t, time.Date(2020, 6, 16, 7, 0, 0, 0, time.UTC), next.UTC())
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"* * * smarch *",
+		"@fortnightly",
+		"@every soon",
+		"@every 1s",
+	} {
+		_, err := Parse(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestScheduler_Run(t *testing.T) {
+	s := New(time.UTC, logger.New())
+	now := time.Date(2020, 3, 16, 10, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	fail := true
+	assert.NoError(t, s.Add("backup", "@daily", func(ctx context.Context) error {
+		now = now.Add(time.Second)
+		if fail {
+			return fmt.Errorf("Bucket is missing.")
+		}
+		return nil
+	}))
+	assert.Error(t, s.Add("backup", "@hourly", nil))
+	assert.Error(t, s.Add("linkcheck", "@sometimes", nil))
+	assert.True(t, s.Has("backup"))
+	assert.False(t, s.Has("linkcheck"))
+
+	ctx := context.Background()
+	assert.Equal(t, ErrUnknownJob, s.Run(ctx, "linkcheck"))
+	assert.Error(t, s.Run(ctx, "backup"))
+	status := s.Status()
+	assert.Len(t, status, 1)
+	assert.Equal(t, "backup", status[0].Name)
+	assert.Equal(t, "@daily", status[0].Spec)
+	assert.Equal(t, time.Date(2020, 3, 16, 10, 0, 0, 0, time.UTC), status[0].LastRun)
+	assert.Equal(t, time.Second, status[0].LastDuration)
+	assert.Equal(t, "Bucket is missing.", status[0].LastError)
+	assert.Equal(t, 1, status[0].Failures)
+
+	fail = false
+	assert.NoError(t, s.Run(ctx, "backup"))
+	status = s.Status()
+	assert.Equal(t, "", status[0].LastError)
+	assert.Equal(t, 2, status[0].Runs)
+	assert.Equal(t, 1, status[0].Failures)
+}
+
+func TestScheduler_Start(t *testing.T) {
+	s := New(time.UTC, logger.New())
+	ran := make(chan bool, 1)
+	assert.NoError(t, s.Add("flush", "* * * * *", func(ctx context.Context) error {
+		select {
+		case ran <- true:
+		default:
+		}
+		return nil
+	}))
+	// Pretend it's a moment before the next minute.
+	s.now = func() time.Time { return time.Now().Truncate(time.Minute).Add(time.Minute - 10*time.Millisecond) }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Start(ctx)
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Job didn't run.")
+	}
+	assert.Error(t, s.Add("late", "@daily", nil))
+}
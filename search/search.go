@@ -0,0 +1,119 @@
+// Package search is an in-memory full text index of entries, which is small
+// enough for a personal stream to be rebuilt whenever entries change.
+package search
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Doc is a searchable entry.
+type Doc struct {
+	ID      string
+	Title   string
+	Text    string
+	Created time.Time
+
+	// title and text are the distinct words in Title and Text.
+	title map[string]bool
+	text  map[string]bool
+}
+
+// words splits s into lowercase words.
+func words(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+func wordSet(s string) map[string]bool {
+	ret := map[string]bool{}
+	for _, w := range words(s) {
+		ret[w] = true
+	}
+	return ret
+}
+
+// Index finds docs by the words they contain.
+type Index struct {
+	docs []*Doc
+}
+
+// New indexes the docs.
+func New(docs []*Doc) *Index {
+	for _, d := range docs {
+		d.title = wordSet(d.Title)
+		d.text = wordSet(d.Text)
+	}
+	return &Index{
+		docs: docs,
+	}
+}
+
+// has returns true if any word in set has the given prefix, or equals it if
+// prefix is false.
+func has(set map[string]bool, w string, prefix bool) bool {
+	if set[w] {
+		return true
+	}
+	if !prefix {
+		return false
+	}
+	for s := range set {
+		if strings.HasPrefix(s, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// Search returns up to n docs that contain every word in q, those that match
+// more words in their title first, then newest first.
+//
+// The last word only needs to be a prefix, since q is typed as the search
+// runs.
+func (i *Index) Search(q string, n int) []*Doc {
+	terms := words(q)
+	if len(terms) == 0 {
+		return []*Doc{}
+	}
+	type match struct {
+		doc   *Doc
+		title int
+	}
+	// A trailing space means the last word is finished.
+	typing := !unicode.IsSpace(rune(q[len(q)-1]))
+	matches := []match{}
+	for _, d := range i.docs {
+		m := match{doc: d}
+		found := true
+		for j, w := range terms {
+			prefix := typing && j == len(terms)-1
+			if has(d.title, w, prefix) {
+				m.title++
+			} else if !has(d.text, w, prefix) {
+				found = false
+				break
+			}
+		}
+		if found {
+			matches = append(matches, m)
+		}
+	}
+	sort.SliceStable(matches, func(a, b int) bool {
+		if matches[a].title != matches[b].title {
+			return matches[a].title > matches[b].title
+		}
+		return matches[a].doc.Created.After(matches[b].doc.Created)
+	})
+	ret := []*Doc{}
+	for _, m := range matches {
+		if len(ret) >= n {
+			break
+		}
+		ret = append(ret, m.doc)
+	}
+	return ret
+}
@@ -0,0 +1,37 @@
+package search
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ids(docs []*Doc) []string {
+	ret := []string{}
+	for _, d := range docs {
+		ret = append(ret, d.ID)
+	}
+	return ret
+}
+
+func TestSearch(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	i := New([]*Doc{
+		{ID: "old", Title: "", Text: "Notes on Go generics.", Created: t0},
+		{ID: "new", Title: "", Text: "More about generics in Go.", Created: t0.Add(time.Hour)},
+		{ID: "titled", Title: "Generics", Text: "A long post about Go.", Created: t0.Add(-time.Hour)},
+		{ID: "other", Title: "Gardening", Text: "Tomatoes.", Created: t0},
+	})
+
+	assert.Equal(t, []string{"titled", "new", "old"}, ids(i.Search("go generics", 10)))
+	assert.Equal(t, []string{"titled", "new"}, ids(i.Search("GENERICS go", 2)))
+
+	// The last word is a prefix while it's being typed.
+	assert.Equal(t, []string{"other", "titled", "new", "old"}, ids(i.Search("g", 10)))
+	assert.Equal(t, []string{"other"}, ids(i.Search("tom", 10)))
+	assert.Empty(t, i.Search("tom ", 10))
+
+	assert.Empty(t, i.Search("  ", 10))
+	assert.Empty(t, i.Search("go python", 10))
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+
+	"github.com/jcgregorio/slog"
+	"github.com/jcgregorio/stream-run/assets"
+	"github.com/jcgregorio/stream-run/auth"
+	"github.com/jcgregorio/stream-run/blocklist"
+	"github.com/jcgregorio/stream-run/bridges"
+	"github.com/jcgregorio/stream-run/cachecontrol"
+	"github.com/jcgregorio/stream-run/emailreply"
+	"github.com/jcgregorio/stream-run/entries"
+	"github.com/jcgregorio/stream-run/graphql"
+	"github.com/jcgregorio/stream-run/linkrel"
+	"github.com/jcgregorio/stream-run/linkrot"
+	"github.com/jcgregorio/stream-run/markdown"
+	"github.com/jcgregorio/stream-run/media"
+	"github.com/jcgregorio/stream-run/mentions"
+	"github.com/jcgregorio/stream-run/notifier"
+	"github.com/jcgregorio/stream-run/outbox"
+	"github.com/jcgregorio/stream-run/outlinks"
+	"github.com/jcgregorio/stream-run/pagecache"
+	"github.com/jcgregorio/stream-run/push"
+	"github.com/jcgregorio/stream-run/referrers"
+	"github.com/jcgregorio/stream-run/related"
+	"github.com/jcgregorio/stream-run/replycontext"
+	"github.com/jcgregorio/stream-run/resize"
+	"github.com/jcgregorio/stream-run/robots"
+	"github.com/jcgregorio/stream-run/scheduler"
+	"github.com/jcgregorio/stream-run/search"
+	"github.com/jcgregorio/stream-run/sessions"
+	"github.com/jcgregorio/stream-run/sharetarget"
+	"github.com/jcgregorio/stream-run/shorturl"
+	"github.com/jcgregorio/stream-run/snippets"
+	"github.com/jcgregorio/stream-run/subscribers"
+	"github.com/jcgregorio/stream-run/tasks"
+	"github.com/jcgregorio/stream-run/tokens"
+	"github.com/jcgregorio/stream-run/trusted"
+	"github.com/jcgregorio/stream-run/websub"
+)
+
+// The interfaces below are the parts of each store that the handlers use, so
+// that tests can run a Server against fakes instead of the Datastore.
+
+// entryStore is implemented by entries.Entries.
+type entryStore interface {
+	Get(ctx context.Context, id string) (*entries.Entry, error)
+	GetMulti(ctx context.Context, ids []string) ([]*entries.Entry, error)
+	Insert(ctx context.Context, entry *entries.Entry) (string, error)
+	Import(ctx context.Context, entry *entries.Entry, source string) (string, bool, error)
+	Ping(ctx context.Context) error
+	Update(ctx context.Context, entry *entries.Entry) error
+	AddSyndication(ctx context.Context, id, u string) error
+	SetInteractions(ctx context.Context, id string, likes, reposts, replies int) error
+	AddTargets(ctx context.Context, id string, targets []string) error
+	AddAlias(ctx context.Context, id, alias string) error
+	FindByAlias(ctx context.Context, alias string) (string, error)
+	FindByURL(ctx context.Context, u string) ([]*entries.Entry, error)
+	Thread(ctx context.Context, id string) ([]*entries.Entry, error)
+	Delete(ctx context.Context, id string) error
+	RestoreDeleted(ctx context.Context, id string) error
+	LockBefore(ctx context.Context, before time.Time) (int, error)
+	BackfillPhotos(ctx context.Context) (int, error)
+	PurgeDeleted(ctx context.Context, before time.Time) (int, error)
+	List(ctx context.Context, n int, offset int) ([]*entries.Entry, error)
+	ListPublic(ctx context.Context, n int, offset int) ([]*entries.Entry, error)
+	CountPublic(ctx context.Context) (int, error)
+	ListPhotos(ctx context.Context, n int, offset int) ([]*entries.Entry, error)
+	ListByTag(ctx context.Context, tag string, n int, offset int) ([]*entries.Entry, error)
+	ListByKind(ctx context.Context, kind entries.Kind, n int, offset int) ([]*entries.Entry, error)
+	ListByMonthDay(ctx context.Context, t time.Time) ([]*entries.Entry, error)
+	ListSince(ctx context.Context, since time.Time, n int) ([]*entries.Entry, error)
+	All(ctx context.Context, f func(*entries.Entry) error) error
+	Restore(ctx context.Context, entry *entries.Entry) error
+	Stats() ([]entries.OpStats, time.Time)
+	ResetStats()
+	KindCounts(ctx context.Context) ([]*entries.KindCount, error)
+}
+
+// mentionStore is implemented by mentions.Mentions.
+type mentionStore interface {
+	Put(ctx context.Context, mention *mentions.Mention) (bool, error)
+	Retract(ctx context.Context, id string) error
+	FromSource(ctx context.Context, entryID, source string) ([]*mentions.Mention, error)
+	Unverified(ctx context.Context, n int) ([]*mentions.Mention, error)
+	Delete(ctx context.Context, id string) error
+	ForEntry(ctx context.Context, entryID string) ([]*mentions.Mention, error)
+	Since(ctx context.Context, t time.Time, n int) ([]*mentions.Mention, error)
+	Pending(ctx context.Context) ([]*mentions.Mention, error)
+	Get(ctx context.Context, id string) (*mentions.Mention, error)
+	Approve(ctx context.Context, id string) error
+	All(ctx context.Context, f func(*mentions.Mention) error) error
+	Restore(ctx context.Context, mention *mentions.Mention) error
+}
+
+// tokenStore is implemented by tokens.Tokens.
+type tokenStore interface {
+	Create(ctx context.Context, label string, scope tokens.Scope, ttl time.Duration) (string, error)
+	Validate(ctx context.Context, value string, scope tokens.Scope) (*tokens.Token, error)
+	List(ctx context.Context) ([]*tokens.Token, error)
+	Revoke(ctx context.Context, id string) error
+}
+
+// sessionStore is implemented by sessions.Sessions.
+type sessionStore interface {
+	Lifetime() time.Duration
+	ID(value string) string
+	Create(ctx context.Context, userAgent string) (string, *sessions.Session, error)
+	Get(ctx context.Context, value string) (*sessions.Session, error)
+	List(ctx context.Context) ([]*sessions.Session, error)
+	Revoke(ctx context.Context, id string) error
+}
+
+// featureStore is implemented by features.Features.
+type featureStore interface {
+	Overrides(ctx context.Context) (map[string]bool, error)
+	Configured(name string) bool
+	Enabled(ctx context.Context, name string) bool
+	Set(ctx context.Context, name string, enabled bool) error
+	Clear(ctx context.Context, name string) error
+}
+
+// websubStore is implemented by websub.Subscriber.
+type websubStore interface {
+	List(ctx context.Context) ([]*websub.Subscription, error)
+	Subscribe(ctx context.Context, topic string) (*websub.Subscription, error)
+	Unsubscribe(ctx context.Context, id string) error
+	Verify(ctx context.Context, id string, query url.Values) (string, error)
+	Receive(ctx context.Context, id string, r *http.Request) (*websub.Subscription, []byte, error)
+}
+
+// endpointStore is implemented by endpoints.Endpoints.
+type endpointStore interface {
+	Discover(ctx context.Context, target string) (string, error)
+	Forget(ctx context.Context, target string) error
+}
+
+// outboxStore is implemented by outbox.Outbox.
+type outboxStore interface {
+	Record(ctx context.Context, a *outbox.Attempt) error
+	ForEntry(ctx context.Context, entryID string) ([]*outbox.Attempt, error)
+	Since(ctx context.Context, t time.Time) ([]*outbox.Attempt, error)
+	Recent(ctx context.Context, n int) ([]*outbox.Attempt, error)
+}
+
+// snippetStore is implemented by snippets.Snippets.
+type snippetStore interface {
+	Put(ctx context.Context, snippet *snippets.Snippet) error
+	Get(ctx context.Context, name string) (*snippets.Snippet, error)
+	Delete(ctx context.Context, name string) error
+	List(ctx context.Context) ([]*snippets.Snippet, error)
+}
+
+// referrerStore is implemented by referrers.Referrers.
+type referrerStore interface {
+	Middleware(h http.Handler) http.Handler
+	Flush(ctx context.Context) error
+	Since(ctx context.Context, since time.Time) ([]*referrers.Referrer, error)
+}
+
+// shortURLStore is implemented by shorturl.ShortURLs.
+type shortURLStore interface {
+	Lookup(ctx context.Context, entryID string) (string, error)
+	For(ctx context.Context, entryID string) (string, error)
+	Resolve(ctx context.Context, code string) (string, error)
+	Top(ctx context.Context, n int) ([]*shorturl.ShortURL, error)
+}
+
+// mediaStore is implemented by media.Library.
+type mediaStore interface {
+	Alt(p string) string
+	Sync(ctx context.Context) error
+	List(ctx context.Context) ([]*media.Media, error)
+	SetAlt(ctx context.Context, p, alt string) error
+	Upload(ctx context.Context, name string, r io.Reader) (string, error)
+	File(p string) (string, error)
+	Delete(ctx context.Context, p string) error
+}
+
+// linkStore is implemented by linkrot.Links.
+type linkStore interface {
+	Dead(ctx context.Context) ([]*linkrot.Link, error)
+	CheckAll(ctx context.Context, client *http.Client, urls map[string][]string, archive bool) (int, error)
+	SaveAll(ctx context.Context, client *http.Client, id string, urls []string) error
+	Archive(ctx context.Context, client *http.Client, u string) error
+	Annotate(h string) string
+}
+
+// blockStore is implemented by blocklist.Blocklist.
+type blockStore interface {
+	Blocked(urls ...string) bool
+	SetConfig(ctx context.Context, config []string) error
+	Add(ctx context.Context, pattern, reason string) error
+	Remove(ctx context.Context, pattern string) error
+	List(ctx context.Context) ([]*blocklist.Block, error)
+	Config() []string
+}
+
+// trustStore is implemented by trusted.Trusted.
+type trustStore interface {
+	Trusted(domain string) bool
+	SetConfig(ctx context.Context, config []string) error
+	Add(ctx context.Context, domain string) error
+	Remove(ctx context.Context, domain string) error
+	List(ctx context.Context) ([]*trusted.Domain, error)
+	Config() []string
+}
+
+// replyStore is implemented by replycontext.ReplyContexts.
+type replyStore interface {
+	Get(ctx context.Context, u string) (*replycontext.Context, error)
+	Refresh(ctx context.Context, u string) (*replycontext.Context, error)
+}
+
+// pushStore is implemented by push.Push.
+type pushStore interface {
+	Subscribe(ctx context.Context, sub *push.Subscription) error
+	SendAll(ctx context.Context, msg *push.Message) error
+}
+
+// subscriberStore is implemented by subscribers.Subscribers.
+type subscriberStore interface {
+	Add(ctx context.Context, email string) (*subscribers.Subscriber, error)
+	Confirm(ctx context.Context, token string) (*subscribers.Subscriber, error)
+	Remove(ctx context.Context, token string) error
+	MarkSent(ctx context.Context, sub *subscribers.Subscriber, sent time.Time) error
+	Confirmed(ctx context.Context) ([]*subscribers.Subscriber, error)
+}
+
+// mailSender is implemented by notifier.SMTP.
+type mailSender interface {
+	Mail(to []string, subject, contentType, body string) error
+}
+
+// stores are the Datastore backed stores of a Server.
+type stores struct {
+	entryDB    entryStore
+	tokenDB    tokenStore
+	sessionDB  sessionStore
+	featureDB  featureStore
+	websubDB   websubStore
+	endpointDB endpointStore
+	mentionDB  mentionStore
+	outboxDB   outboxStore
+	snippetDB  snippetStore
+	referrerDB referrerStore
+	shortDB    shortURLStore
+	mediaDB    mediaStore
+	linkDB     linkStore
+	blockDB    blockStore
+	trustDB    trustStore
+	replyDB    replyStore
+
+	// pushDB is nil if Web Push isn't configured.
+	pushDB pushStore
+
+	// mailer and subscriberDB are nil if SMTP isn't configured.
+	mailer       mailSender
+	subscriberDB subscriberStore
+}
+
+// Server is a single stream, with its config, stores, templates, and caches.
+// Every handler is a method on Server.
+type Server struct {
+	stores
+
+	config *viper.Viper
+	log    slog.Logger
+
+	ad     auth.Authenticator
+	notify notifier.Notifier
+
+	// handler serves every route, see addHandlers.
+	handler http.Handler
+
+	// jobs runs the periodic maintenance jobs, see addJob.
+	jobs *scheduler.Scheduler
+
+	// taskQueue runs the outbound side effects of publishing, see
+	// addTasks.
+	taskQueue *tasks.Tasks
+
+	resizer *resize.Resizer
+
+	relatedCache *related.Cache
+
+	pageCache *pagecache.Cache
+
+	// imageVersions versions the files under /images/, see the bust
+	// template func.
+	imageVersions *cachecontrol.Versions
+
+	assetDB *assets.Assets
+
+	templates *template.Template
+
+	// localizedTemplates are the templates for each supported locale, see
+	// templatesFor.
+	localizedTemplates map[string]*template.Template
+
+	// shareExtractor turns shares into new entries.
+	shareExtractor *sharetarget.Extractor
+
+	// markdownOptions are the Markdown extensions enabled by the MARKDOWN
+	// config block.
+	markdownOptions markdown.Options
+
+	// linkRewriter adds the LINK_REL attributes to external links.
+	linkRewriter *linkrel.Rewriter
+
+	// robotsPolicy serves robots.txt and adds X-Robots-Tag headers.
+	robotsPolicy *robots.Robots
+
+	// bridgeRules are where links to the BRIDGES are added, from
+	// BRIDGE_CONTEXTS and BRIDGE_LINK_TEXT.
+	bridgeRules *bridges.Rules
+
+	// graphqlSchema is the schema served on /graphql.
+	graphqlSchema *graphql.Schema
+
+	// mailgun verifies the webhooks that deliver email replies.
+	mailgun *emailreply.Mailgun
+
+	// backupBucket is BACKUP_BUCKET, or nil if periodic backups are
+	// disabled.
+	backupBucket *storage.BucketHandle
+
+	// searchMutex protects searchIndex, which is nil until a search needs it
+	// and again after entries change.
+	searchMutex sync.Mutex
+	searchIndex *search.Index
+
+	// linksMutex protects linksIndex, which is nil until the links page
+	// needs it and again after entries change.
+	linksMutex sync.Mutex
+	linksIndex *outlinks.Index
+
+	// tagCountsMutex protects tagCounts, which is nil until they are needed
+	// and again after entries change.
+	tagCountsMutex sync.Mutex
+	tagCounts      []*tagCount
+
+	// degradedMutex protects degradedSince, when the Datastore was found to
+	// be unreachable, or the zero time if it's reachable.
+	degradedMutex sync.Mutex
+	degradedSince time.Time
+
+	redirectsMutex sync.RWMutex
+
+	// redirects maps old paths to new URLs, loaded from REDIRECTS.
+	redirects map[string]string
+}
+
+// newServer returns a Server for the stream configured by config that reads
+// and writes through st, checks admins with ad, and sends notifications to
+// notify.
+func newServer(config *viper.Viper, st stores, ad auth.Authenticator, notify notifier.Notifier, log slog.Logger) (*Server, error) {
+	s := &Server{
+		stores: st,
+		config: config,
+		log:    log,
+		ad:     ad,
+		notify: notify,
+	}
+	if err := s.setup(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// setup applies the config defaults, loads the templates, builds the caches,
+// and adds the routes, once the stores are in place.
+func (s *Server) setup() error {
+	s.config.SetDefault(IMAGE_WIDTHS, []int{320, 640, 1280})
+	s.config.SetDefault(LOCATION_FUZZ_PLACES, 2)
+	s.config.SetDefault(BRIDGE_PATHS, []string{"/.well-known/webfinger"})
+	s.config.SetDefault(SW_PRECACHE_ENTRIES, 10)
+	s.config.SetDefault(PAGE_CACHE_S_MAXAGE, 60)
+	s.config.SetDefault(PAGE_CACHE_SWR, 24*60*60)
+	s.config.SetDefault(TEMPLATES, "templates")
+	s.config.SetDefault(IMAGES, "images")
+
+	s.redirects = map[string]string{}
+	s.markdownOptions = markdown.Default()
+	s.linkRewriter, _ = linkrel.New("", linkrel.Options{})
+	s.robotsPolicy, _ = robots.New(robots.Options{}, robotsPrivate, "")
+	s.bridgeRules, _ = bridges.New(nil, "")
+	s.loadRedirects()
+	s.loadMarkdownOptions()
+	s.loadBridgeRules()
+	s.loadLinkRel()
+	s.loadRobots()
+
+	s.shareExtractor = sharetarget.New(fetchDocument)
+	s.mailgun = emailreply.NewMailgun()
+	s.relatedCache = related.NewCache()
+	s.imageVersions = cachecontrol.NewVersions(s.imagesDir(), !*local)
+	// Locally the assets are read from disk, so edits show up without a
+	// rebuild.
+	s.assetDB = assets.New(assets.Embedded(), true)
+	if *local {
+		dir := filepath.Join(*resourcesDir, "assets", "static")
+		if _, err := os.Stat(dir); err == nil {
+			s.assetDB = assets.New(os.DirFS(dir), false)
+		}
+	}
+	s.loadTemplates()
+	s.pageCache = pagecache.New(PAGE_CACHE_SIZE, s.config.GetInt(PAGE_CACHE_S_MAXAGE), s.config.GetInt(PAGE_CACHE_SWR), func(r *http.Request) bool {
+		// Capability URLs show private entries, which must never be shared.
+		return s.isAdmin(r) || r.URL.Query().Has("cap")
+	})
+	s.pageCache.SetVariant(s.localeFor)
+	s.resizer = resize.New(s.imagesSource, s.config.GetIntSlice(IMAGE_WIDTHS), 200)
+	s.graphqlSchema = s.newGraphQLSchema()
+
+	s.jobs = scheduler.New(s.displayLocation(), s.log)
+	s.taskQueue = tasks.New(s.log)
+	s.addTasks()
+
+	r := mux.NewRouter()
+	s.addHandlers(r)
+	lim, err := s.requestLimits()
+	if err != nil {
+		return err
+	}
+	var h http.Handler = r
+	if s.config.GetBool(MIRROR) {
+		h = readOnly(r)
+	}
+	s.handler = lim.Middleware(s.robotsHeaders(h))
+	return nil
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// sites routes each request to the Server of the stream whose HOST matches
+// its Host header, see SITES.
+type sites struct {
+	byHost map[string]*Server
+
+	// fallback serves requests for unknown hosts, such as health checks
+	// made by IP address.
+	fallback *Server
+}
+
+// newSites returns sites for the given Servers, where the first is the
+// fallback.
+func newSites(servers []*Server) (*sites, error) {
+	ret := &sites{
+		byHost:   map[string]*Server{},
+		fallback: servers[0],
+	}
+	for _, s := range servers {
+		u, err := url.Parse(s.config.GetString(HOST))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse %s: %s", HOST, err)
+		}
+		host := strings.ToLower(u.Hostname())
+		if _, ok := ret.byHost[host]; ok {
+			return nil, fmt.Errorf("More than one site has the host %q.", host)
+		}
+		ret.byHost[host] = s
+	}
+	return ret, nil
+}
+
+// hosts returns the hostnames of the sites, sorted.
+func (ss *sites) hosts() []string {
+	ret := []string{}
+	for host := range ss.byHost {
+		ret = append(ret, host)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// ServeHTTP implements http.Handler.
+func (ss *sites) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	s, ok := ss.byHost[strings.ToLower(host)]
+	if !ok {
+		s = ss.fallback
+	}
+	s.ServeHTTP(w, r)
+}
@@ -0,0 +1,1111 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jcgregorio/logger"
+	"github.com/jcgregorio/slog"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jcgregorio/stream-run/blocklist"
+	"github.com/jcgregorio/stream-run/endpoints"
+	"github.com/jcgregorio/stream-run/entries"
+	"github.com/jcgregorio/stream-run/linkrot"
+	"github.com/jcgregorio/stream-run/media"
+	"github.com/jcgregorio/stream-run/mentions"
+	"github.com/jcgregorio/stream-run/notifier"
+	"github.com/jcgregorio/stream-run/outbox"
+	"github.com/jcgregorio/stream-run/push"
+	"github.com/jcgregorio/stream-run/referrers"
+	"github.com/jcgregorio/stream-run/replycontext"
+	"github.com/jcgregorio/stream-run/sessions"
+	"github.com/jcgregorio/stream-run/shorturl"
+	"github.com/jcgregorio/stream-run/snippets"
+	"github.com/jcgregorio/stream-run/subscribers"
+	"github.com/jcgregorio/stream-run/tokens"
+	"github.com/jcgregorio/stream-run/trusted"
+	"github.com/jcgregorio/stream-run/websub"
+)
+
+// The fakes below are in-memory versions of the stores, just faithful enough
+// for the handlers to be exercised without a Datastore emulator.
+
+const (
+	// testSession is the session cookie value of the admin in tests.
+	testSession = "admin-session"
+
+	// testToken is the ADMIN scoped API token in tests.
+	testToken = "admin-token"
+)
+
+// fakeEntries is an in-memory entryStore.
+type fakeEntries struct {
+	mutex   sync.Mutex
+	entries map[string]*entries.Entry
+	deleted map[string]*entries.Entry
+	next    int
+
+	// listErr, if set, is returned by List, as when the Datastore fails.
+	listErr error
+}
+
+func newFakeEntries() *fakeEntries {
+	return &fakeEntries{
+		entries: map[string]*entries.Entry{},
+		deleted: map[string]*entries.Entry{},
+	}
+}
+
+// add stores entry as is, for setting up tests, and returns its id.
+func (f *fakeEntries) add(entry *entries.Entry) string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if entry.ID == "" {
+		f.next++
+		entry.ID = fmt.Sprintf("entry%d", f.next)
+	}
+	if entry.Created.IsZero() {
+		entry.Created = time.Now()
+		entry.Updated = entry.Created
+	}
+	copied := *entry
+	f.entries[entry.ID] = &copied
+	return entry.ID
+}
+
+// list returns copies of the entries that match, newest first.
+func (f *fakeEntries) list(match func(*entries.Entry) bool) []*entries.Entry {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	ret := []*entries.Entry{}
+	for _, entry := range f.entries {
+		if match(entry) {
+			copied := *entry
+			ret = append(ret, &copied)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Created.After(ret[j].Created)
+	})
+	return ret
+}
+
+// page returns up to n of list, starting at offset.
+func page(list []*entries.Entry, n int, offset int) []*entries.Entry {
+	if offset >= len(list) {
+		return []*entries.Entry{}
+	}
+	list = list[offset:]
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+func (f *fakeEntries) Get(ctx context.Context, id string) (*entries.Entry, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	entry, ok := f.entries[id]
+	if !ok {
+		return nil, entries.ErrNotFound
+	}
+	copied := *entry
+	return &copied, nil
+}
+
+func (f *fakeEntries) GetMulti(ctx context.Context, ids []string) ([]*entries.Entry, error) {
+	ret := make([]*entries.Entry, len(ids))
+	for i, id := range ids {
+		ret[i], _ = f.Get(ctx, id)
+	}
+	return ret, nil
+}
+
+func (f *fakeEntries) Insert(ctx context.Context, entry *entries.Entry) (string, error) {
+	entry.ID = ""
+	entry.Created = time.Now()
+	entry.Updated = entry.Created
+	if entry.Visibility == "" {
+		entry.Visibility = entries.PUBLIC
+	}
+	if entry.Kind == "" {
+		entry.Kind = entries.NOTE
+	}
+	return f.add(entry), nil
+}
+
+func (f *fakeEntries) Import(ctx context.Context, entry *entries.Entry, source string) (string, bool, error) {
+	entry.ID = fmt.Sprintf("%x", md5.Sum([]byte(source)))
+	if _, err := f.Get(ctx, entry.ID); err == nil {
+		return entry.ID, false, nil
+	}
+	entry.Aliases = append(entry.Aliases, source)
+	return f.add(entry), true, nil
+}
+
+func (f *fakeEntries) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (f *fakeEntries) Update(ctx context.Context, entry *entries.Entry) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	stored, ok := f.entries[entry.ID]
+	if !ok {
+		return entries.ErrNotFound
+	}
+	if stored.Version != entry.Version {
+		return entries.ErrConflict
+	}
+	entry.Version++
+	entry.Updated = time.Now()
+	copied := *entry
+	f.entries[entry.ID] = &copied
+	return nil
+}
+
+// modify applies change to the stored entry with the given id.
+func (f *fakeEntries) modify(id string, change func(*entries.Entry)) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	entry, ok := f.entries[id]
+	if !ok {
+		return entries.ErrNotFound
+	}
+	change(entry)
+	return nil
+}
+
+func (f *fakeEntries) AddSyndication(ctx context.Context, id, u string) error {
+	return f.modify(id, func(entry *entries.Entry) {
+		entry.Syndication = append(entry.Syndication, u)
+	})
+}
+
+func (f *fakeEntries) SetInteractions(ctx context.Context, id string, likes, reposts, replies int) error {
+	return f.modify(id, func(entry *entries.Entry) {
+		entry.Likes, entry.Reposts, entry.Replies = likes, reposts, replies
+	})
+}
+
+func (f *fakeEntries) AddTargets(ctx context.Context, id string, targets []string) error {
+	return f.modify(id, func(entry *entries.Entry) {
+		entry.Targets = append(entry.Targets, targets...)
+	})
+}
+
+func (f *fakeEntries) AddAlias(ctx context.Context, id, alias string) error {
+	if alias == "" || alias == id {
+		return fmt.Errorf("Invalid alias %q.", alias)
+	}
+	return f.modify(id, func(entry *entries.Entry) {
+		entry.Aliases = append(entry.Aliases, alias)
+	})
+}
+
+func (f *fakeEntries) FindByAlias(ctx context.Context, alias string) (string, error) {
+	for _, entry := range f.list(func(*entries.Entry) bool { return true }) {
+		for _, a := range entry.Aliases {
+			if a == alias {
+				return entry.ID, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("No entry has alias %q.", alias)
+}
+
+func (f *fakeEntries) FindByURL(ctx context.Context, u string) ([]*entries.Entry, error) {
+	n := entries.NormalizeURL(u)
+	return f.list(func(entry *entries.Entry) bool {
+		for _, link := range entry.URLs {
+			if n != "" && link == n {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+func (f *fakeEntries) Thread(ctx context.Context, id string) ([]*entries.Entry, error) {
+	children := f.list(func(entry *entries.Entry) bool { return entry.ParentID == id })
+	ret := []*entries.Entry{}
+	for i := len(children) - 1; i >= 0; i-- {
+		ret = append(ret, children[i])
+		descendants, _ := f.Thread(ctx, children[i].ID)
+		ret = append(ret, descendants...)
+	}
+	return ret, nil
+}
+
+func (f *fakeEntries) Delete(ctx context.Context, id string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	entry, ok := f.entries[id]
+	if !ok {
+		return fmt.Errorf("Failed to delete %q: %s", id, entries.ErrNotFound)
+	}
+	f.deleted[id] = entry
+	delete(f.entries, id)
+	return nil
+}
+
+func (f *fakeEntries) RestoreDeleted(ctx context.Context, id string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	entry, ok := f.deleted[id]
+	if !ok {
+		return fmt.Errorf("Failed to restore deleted entry %q: %s", id, entries.ErrNotFound)
+	}
+	f.entries[id] = entry
+	delete(f.deleted, id)
+	return nil
+}
+
+func (f *fakeEntries) LockBefore(ctx context.Context, before time.Time) (int, error) {
+	n := 0
+	for _, entry := range f.list(func(entry *entries.Entry) bool {
+		return entry.Created.Before(before) && !entry.Locked && !entry.KeepOpen
+	}) {
+		n++
+		_ = f.modify(entry.ID, func(entry *entries.Entry) { entry.Locked = true })
+	}
+	return n, nil
+}
+
+func (f *fakeEntries) BackfillPhotos(ctx context.Context) (int, error) {
+	return 0, nil
+}
+
+func (f *fakeEntries) PurgeDeleted(ctx context.Context, before time.Time) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	n := len(f.deleted)
+	f.deleted = map[string]*entries.Entry{}
+	return n, nil
+}
+
+func (f *fakeEntries) List(ctx context.Context, n int, offset int) ([]*entries.Entry, error) {
+	if f.listErr != nil {
+		return nil, f.listErr
+	}
+	return page(f.list(func(*entries.Entry) bool { return true }), n, offset), nil
+}
+
+func (f *fakeEntries) ListPublic(ctx context.Context, n int, offset int) ([]*entries.Entry, error) {
+	return page(f.list((*entries.Entry).IsPublic), n, offset), nil
+}
+
+func (f *fakeEntries) CountPublic(ctx context.Context) (int, error) {
+	return len(f.list((*entries.Entry).IsPublic)), nil
+}
+
+func (f *fakeEntries) ListPhotos(ctx context.Context, n int, offset int) ([]*entries.Entry, error) {
+	return page(f.list(func(entry *entries.Entry) bool {
+		return entry.IsPublic() && entry.HasPhotos
+	}), n, offset), nil
+}
+
+func (f *fakeEntries) ListByTag(ctx context.Context, tag string, n int, offset int) ([]*entries.Entry, error) {
+	return page(f.list(func(entry *entries.Entry) bool {
+		for _, t := range entry.Tags {
+			if t == strings.ToLower(tag) {
+				return entry.IsPublic()
+			}
+		}
+		return false
+	}), n, offset), nil
+}
+
+func (f *fakeEntries) ListByKind(ctx context.Context, kind entries.Kind, n int, offset int) ([]*entries.Entry, error) {
+	return page(f.list(func(entry *entries.Entry) bool {
+		return entry.IsPublic() && entry.Kind == kind
+	}), n, offset), nil
+}
+
+func (f *fakeEntries) ListByMonthDay(ctx context.Context, t time.Time) ([]*entries.Entry, error) {
+	return f.list(func(entry *entries.Entry) bool {
+		created := entry.Created.In(t.Location())
+		return entry.IsPublic() && created.Year() < t.Year() && created.Month() == t.Month() && created.Day() == t.Day()
+	}), nil
+}
+
+func (f *fakeEntries) ListSince(ctx context.Context, since time.Time, n int) ([]*entries.Entry, error) {
+	return page(f.list(func(entry *entries.Entry) bool {
+		return entry.IsPublic() && entry.Created.After(since)
+	}), n, 0), nil
+}
+
+func (f *fakeEntries) All(ctx context.Context, fn func(*entries.Entry) error) error {
+	for _, entry := range f.list(func(*entries.Entry) bool { return true }) {
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeEntries) Restore(ctx context.Context, entry *entries.Entry) error {
+	if entry.ID == "" {
+		return fmt.Errorf("Entry must have an ID.")
+	}
+	f.add(entry)
+	return nil
+}
+
+func (f *fakeEntries) Stats() ([]entries.OpStats, time.Time) {
+	return nil, time.Time{}
+}
+
+func (f *fakeEntries) ResetStats() {}
+
+func (f *fakeEntries) KindCounts(ctx context.Context) ([]*entries.KindCount, error) {
+	return []*entries.KindCount{}, nil
+}
+
+// fakeMentions is an in-memory mentionStore.
+type fakeMentions struct {
+	mutex    sync.Mutex
+	mentions map[string]*mentions.Mention
+}
+
+func newFakeMentions() *fakeMentions {
+	return &fakeMentions{
+		mentions: map[string]*mentions.Mention{},
+	}
+}
+
+// list returns copies of the mentions that match, oldest first.
+func (f *fakeMentions) list(match func(*mentions.Mention) bool) []*mentions.Mention {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	ret := []*mentions.Mention{}
+	for _, m := range f.mentions {
+		if match(m) {
+			copied := *m
+			ret = append(ret, &copied)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Created.Before(ret[j].Created)
+	})
+	return ret
+}
+
+func (f *fakeMentions) Put(ctx context.Context, mention *mentions.Mention) (bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	mention.ID = fmt.Sprintf("%x", md5.Sum([]byte(mention.EntryID+" "+string(mention.Type)+" "+mention.Source)))
+	existing, ok := f.mentions[mention.ID]
+	if ok {
+		mention.Created = existing.Created
+		mention.Pending = mention.Pending && existing.Pending
+	} else {
+		mention.Created = time.Now()
+	}
+	copied := *mention
+	f.mentions[mention.ID] = &copied
+	return !ok, nil
+}
+
+// modify applies change to the stored mention with the given id.
+func (f *fakeMentions) modify(id string, change func(*mentions.Mention)) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	m, ok := f.mentions[id]
+	if !ok {
+		return fmt.Errorf("Failed to get mention %q.", id)
+	}
+	change(m)
+	return nil
+}
+
+func (f *fakeMentions) Retract(ctx context.Context, id string) error {
+	return f.modify(id, func(m *mentions.Mention) { m.Retracted = true })
+}
+
+func (f *fakeMentions) FromSource(ctx context.Context, entryID, source string) ([]*mentions.Mention, error) {
+	return f.list(func(m *mentions.Mention) bool {
+		return m.EntryID == entryID && m.Source == source
+	}), nil
+}
+
+func (f *fakeMentions) Unverified(ctx context.Context, n int) ([]*mentions.Mention, error) {
+	ret := f.list(func(m *mentions.Mention) bool {
+		return m.Target != "" && !m.Retracted
+	})
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Verified.Before(ret[j].Verified)
+	})
+	if len(ret) > n {
+		ret = ret[:n]
+	}
+	return ret, nil
+}
+
+func (f *fakeMentions) Delete(ctx context.Context, id string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.mentions, id)
+	return nil
+}
+
+func (f *fakeMentions) ForEntry(ctx context.Context, entryID string) ([]*mentions.Mention, error) {
+	return f.list(func(m *mentions.Mention) bool { return m.EntryID == entryID }), nil
+}
+
+func (f *fakeMentions) Since(ctx context.Context, t time.Time, n int) ([]*mentions.Mention, error) {
+	ret := f.list(func(m *mentions.Mention) bool { return m.Created.After(t) })
+	if len(ret) > n {
+		ret = ret[:n]
+	}
+	return ret, nil
+}
+
+func (f *fakeMentions) Pending(ctx context.Context) ([]*mentions.Mention, error) {
+	return f.list(func(m *mentions.Mention) bool { return m.Pending && !m.Retracted }), nil
+}
+
+func (f *fakeMentions) Get(ctx context.Context, id string) (*mentions.Mention, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	m, ok := f.mentions[id]
+	if !ok {
+		return nil, fmt.Errorf("Failed to get mention %q.", id)
+	}
+	copied := *m
+	return &copied, nil
+}
+
+func (f *fakeMentions) Approve(ctx context.Context, id string) error {
+	return f.modify(id, func(m *mentions.Mention) { m.Pending = false })
+}
+
+func (f *fakeMentions) All(ctx context.Context, fn func(*mentions.Mention) error) error {
+	for _, m := range f.list(func(*mentions.Mention) bool { return true }) {
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeMentions) Restore(ctx context.Context, mention *mentions.Mention) error {
+	if mention.ID == "" {
+		return fmt.Errorf("Mention must have an ID.")
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	copied := *mention
+	f.mentions[mention.ID] = &copied
+	return nil
+}
+
+// fakeTokens accepts only testToken.
+type fakeTokens struct{}
+
+func (fakeTokens) Create(ctx context.Context, label string, scope tokens.Scope, ttl time.Duration) (string, error) {
+	return testToken, nil
+}
+
+func (fakeTokens) Validate(ctx context.Context, value string, scope tokens.Scope) (*tokens.Token, error) {
+	if value != testToken {
+		return nil, fmt.Errorf("Invalid token.")
+	}
+	return &tokens.Token{ID: "1", Label: "test", Scope: tokens.ADMIN}, nil
+}
+
+func (fakeTokens) List(ctx context.Context) ([]*tokens.Token, error) {
+	return []*tokens.Token{{ID: "1", Label: "test", Scope: tokens.ADMIN}}, nil
+}
+
+func (fakeTokens) Revoke(ctx context.Context, id string) error {
+	return nil
+}
+
+// fakeSessions has a single session, testSession, which is never revoked.
+type fakeSessions struct{}
+
+func (fakeSessions) Lifetime() time.Duration {
+	return time.Hour
+}
+
+func (fakeSessions) ID(value string) string {
+	return value
+}
+
+func (f fakeSessions) Create(ctx context.Context, userAgent string) (string, *sessions.Session, error) {
+	session, err := f.Get(ctx, testSession)
+	return testSession, session, err
+}
+
+func (fakeSessions) Get(ctx context.Context, value string) (*sessions.Session, error) {
+	if value != testSession {
+		return nil, fmt.Errorf("No such session.")
+	}
+	return &sessions.Session{ID: testSession, Created: time.Now(), Expires: time.Now().Add(time.Hour), LastSeen: time.Now()}, nil
+}
+
+func (f fakeSessions) List(ctx context.Context) ([]*sessions.Session, error) {
+	session, err := f.Get(ctx, testSession)
+	return []*sessions.Session{session}, err
+}
+
+func (fakeSessions) Revoke(ctx context.Context, id string) error {
+	return nil
+}
+
+// fakeFeatures has every feature off unless it is Set.
+type fakeFeatures struct {
+	mutex     sync.Mutex
+	overrides map[string]bool
+}
+
+func (f *fakeFeatures) Overrides(ctx context.Context) (map[string]bool, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	ret := map[string]bool{}
+	for name, enabled := range f.overrides {
+		ret[name] = enabled
+	}
+	return ret, nil
+}
+
+func (f *fakeFeatures) Configured(name string) bool {
+	return false
+}
+
+func (f *fakeFeatures) Enabled(ctx context.Context, name string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.overrides[name]
+}
+
+func (f *fakeFeatures) Set(ctx context.Context, name string, enabled bool) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.overrides == nil {
+		f.overrides = map[string]bool{}
+	}
+	f.overrides[name] = enabled
+	return nil
+}
+
+func (f *fakeFeatures) Clear(ctx context.Context, name string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.overrides, name)
+	return nil
+}
+
+// fakeWebSub has no subscriptions.
+type fakeWebSub struct{}
+
+func (fakeWebSub) List(ctx context.Context) ([]*websub.Subscription, error) {
+	return []*websub.Subscription{}, nil
+}
+
+func (fakeWebSub) Subscribe(ctx context.Context, topic string) (*websub.Subscription, error) {
+	return &websub.Subscription{ID: "1", Topic: topic}, nil
+}
+
+func (fakeWebSub) Unsubscribe(ctx context.Context, id string) error {
+	return nil
+}
+
+func (fakeWebSub) Verify(ctx context.Context, id string, query url.Values) (string, error) {
+	return "", fmt.Errorf("Unknown subscription %q.", id)
+}
+
+func (fakeWebSub) Receive(ctx context.Context, id string, r *http.Request) (*websub.Subscription, []byte, error) {
+	return nil, nil, fmt.Errorf("Unknown subscription %q.", id)
+}
+
+// fakeEndpoints never finds an endpoint, so no webmentions are sent.
+type fakeEndpoints struct{}
+
+func (fakeEndpoints) Discover(ctx context.Context, target string) (string, error) {
+	return "", endpoints.ErrNoEndpoint
+}
+
+func (fakeEndpoints) Forget(ctx context.Context, target string) error {
+	return nil
+}
+
+// fakeOutbox records attempts.
+type fakeOutbox struct {
+	mutex    sync.Mutex
+	attempts []*outbox.Attempt
+}
+
+func (f *fakeOutbox) Record(ctx context.Context, a *outbox.Attempt) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.attempts = append(f.attempts, a)
+	return nil
+}
+
+func (f *fakeOutbox) ForEntry(ctx context.Context, entryID string) ([]*outbox.Attempt, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	ret := []*outbox.Attempt{}
+	for _, a := range f.attempts {
+		if a.EntryID == entryID {
+			ret = append(ret, a)
+		}
+	}
+	return ret, nil
+}
+
+func (f *fakeOutbox) Since(ctx context.Context, t time.Time) ([]*outbox.Attempt, error) {
+	return f.Recent(ctx, len(f.attempts))
+}
+
+func (f *fakeOutbox) Recent(ctx context.Context, n int) ([]*outbox.Attempt, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	ret := append([]*outbox.Attempt{}, f.attempts...)
+	if len(ret) > n {
+		ret = ret[:n]
+	}
+	return ret, nil
+}
+
+// fakeSnippets is an in-memory snippetStore.
+type fakeSnippets struct {
+	mutex    sync.Mutex
+	snippets map[string]*snippets.Snippet
+}
+
+func (f *fakeSnippets) Put(ctx context.Context, snippet *snippets.Snippet) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.snippets == nil {
+		f.snippets = map[string]*snippets.Snippet{}
+	}
+	f.snippets[snippet.Name] = snippet
+	return nil
+}
+
+func (f *fakeSnippets) Get(ctx context.Context, name string) (*snippets.Snippet, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	snippet, ok := f.snippets[name]
+	if !ok {
+		return nil, fmt.Errorf("No snippet %q.", name)
+	}
+	return snippet, nil
+}
+
+func (f *fakeSnippets) Delete(ctx context.Context, name string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.snippets, name)
+	return nil
+}
+
+func (f *fakeSnippets) List(ctx context.Context) ([]*snippets.Snippet, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	ret := []*snippets.Snippet{}
+	for _, snippet := range f.snippets {
+		ret = append(ret, snippet)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret, nil
+}
+
+// fakeReferrers doesn't count anything.
+type fakeReferrers struct{}
+
+func (fakeReferrers) Middleware(h http.Handler) http.Handler {
+	return h
+}
+
+func (fakeReferrers) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (fakeReferrers) Since(ctx context.Context, since time.Time) ([]*referrers.Referrer, error) {
+	return []*referrers.Referrer{}, nil
+}
+
+// fakeShortURLs uses the entry id as the code.
+type fakeShortURLs struct {
+	entryDB *fakeEntries
+}
+
+func (f fakeShortURLs) Lookup(ctx context.Context, entryID string) (string, error) {
+	return entryID, nil
+}
+
+func (f fakeShortURLs) For(ctx context.Context, entryID string) (string, error) {
+	return entryID, nil
+}
+
+func (f fakeShortURLs) Resolve(ctx context.Context, code string) (string, error) {
+	if _, err := f.entryDB.Get(ctx, code); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+func (f fakeShortURLs) Top(ctx context.Context, n int) ([]*shorturl.ShortURL, error) {
+	return []*shorturl.ShortURL{}, nil
+}
+
+// fakeMedia is an empty library.
+type fakeMedia struct{}
+
+func (fakeMedia) Alt(p string) string {
+	return ""
+}
+
+func (fakeMedia) Sync(ctx context.Context) error {
+	return nil
+}
+
+func (fakeMedia) List(ctx context.Context) ([]*media.Media, error) {
+	return []*media.Media{}, nil
+}
+
+func (fakeMedia) SetAlt(ctx context.Context, p, alt string) error {
+	return nil
+}
+
+func (fakeMedia) Upload(ctx context.Context, name string, r io.Reader) (string, error) {
+	return name, nil
+}
+
+func (fakeMedia) File(p string) (string, error) {
+	return "", fmt.Errorf("No file %q.", p)
+}
+
+func (fakeMedia) Delete(ctx context.Context, p string) error {
+	return nil
+}
+
+// fakeLinks has no dead links.
+type fakeLinks struct{}
+
+func (fakeLinks) Dead(ctx context.Context) ([]*linkrot.Link, error) {
+	return []*linkrot.Link{}, nil
+}
+
+func (fakeLinks) CheckAll(ctx context.Context, client *http.Client, urls map[string][]string, archive bool) (int, error) {
+	return 0, nil
+}
+
+func (fakeLinks) SaveAll(ctx context.Context, client *http.Client, id string, urls []string) error {
+	return nil
+}
+
+func (fakeLinks) Archive(ctx context.Context, client *http.Client, u string) error {
+	return nil
+}
+
+func (fakeLinks) Annotate(h string) string {
+	return h
+}
+
+// fakeTrusted trusts the domains it was given or had added.
+type fakeTrusted struct {
+	mutex   sync.Mutex
+	config  []string
+	domains map[string]bool
+}
+
+func newFakeTrusted(config []string) *fakeTrusted {
+	return &fakeTrusted{config: config, domains: map[string]bool{}}
+}
+
+func (f *fakeTrusted) Trusted(domain string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, d := range f.config {
+		if d == domain {
+			return true
+		}
+	}
+	return f.domains[domain]
+}
+
+func (f *fakeTrusted) SetConfig(ctx context.Context, config []string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.config = config
+	return nil
+}
+
+func (f *fakeTrusted) Add(ctx context.Context, domain string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.domains[domain] = true
+	return nil
+}
+
+func (f *fakeTrusted) Remove(ctx context.Context, domain string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.domains, domain)
+	return nil
+}
+
+func (f *fakeTrusted) List(ctx context.Context) ([]*trusted.Domain, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	ret := []*trusted.Domain{}
+	for d := range f.domains {
+		ret = append(ret, &trusted.Domain{Name: d})
+	}
+	return ret, nil
+}
+
+func (f *fakeTrusted) Config() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.config
+}
+
+// fakeBlocks blocks URLs that start with one of its patterns.
+type fakeBlocks struct {
+	mutex    sync.Mutex
+	patterns []string
+}
+
+func (f *fakeBlocks) Blocked(urls ...string) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, u := range urls {
+		for _, p := range f.patterns {
+			if u != "" && strings.HasPrefix(u, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *fakeBlocks) SetConfig(ctx context.Context, config []string) error {
+	return nil
+}
+
+func (f *fakeBlocks) Add(ctx context.Context, pattern, reason string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.patterns = append(f.patterns, pattern)
+	return nil
+}
+
+func (f *fakeBlocks) Remove(ctx context.Context, pattern string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for i, p := range f.patterns {
+		if p == pattern {
+			f.patterns = append(f.patterns[:i], f.patterns[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeBlocks) List(ctx context.Context) ([]*blocklist.Block, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	ret := []*blocklist.Block{}
+	for _, p := range f.patterns {
+		ret = append(ret, &blocklist.Block{Pattern: p})
+	}
+	return ret, nil
+}
+
+func (f *fakeBlocks) Config() []string {
+	return []string{}
+}
+
+// fakeReplies never has a reply context.
+type fakeReplies struct{}
+
+func (fakeReplies) Get(ctx context.Context, u string) (*replycontext.Context, error) {
+	return nil, fmt.Errorf("No reply context for %q.", u)
+}
+
+func (fakeReplies) Refresh(ctx context.Context, u string) (*replycontext.Context, error) {
+	return nil, fmt.Errorf("Failed to fetch %q.", u)
+}
+
+// fakePush records subscriptions and messages.
+type fakePush struct {
+	mutex         sync.Mutex
+	subscriptions []*push.Subscription
+	messages      []*push.Message
+}
+
+func (f *fakePush) Subscribe(ctx context.Context, sub *push.Subscription) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.subscriptions = append(f.subscriptions, sub)
+	return nil
+}
+
+func (f *fakePush) SendAll(ctx context.Context, msg *push.Message) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.messages = append(f.messages, msg)
+	return nil
+}
+
+// fakeSubscribers is an in-memory subscriberStore, where the token of each
+// subscriber is their email address.
+type fakeSubscribers struct {
+	mutex       sync.Mutex
+	subscribers map[string]*subscribers.Subscriber
+}
+
+func (f *fakeSubscribers) Add(ctx context.Context, email string) (*subscribers.Subscriber, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if !strings.Contains(email, "@") {
+		return nil, fmt.Errorf("Invalid email address %q.", email)
+	}
+	if f.subscribers == nil {
+		f.subscribers = map[string]*subscribers.Subscriber{}
+	}
+	if sub, ok := f.subscribers[email]; ok {
+		return sub, nil
+	}
+	sub := &subscribers.Subscriber{Email: email, Token: email, Created: time.Now()}
+	f.subscribers[email] = sub
+	return sub, nil
+}
+
+func (f *fakeSubscribers) Confirm(ctx context.Context, token string) (*subscribers.Subscriber, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	sub, ok := f.subscribers[token]
+	if !ok {
+		return nil, fmt.Errorf("Unknown token.")
+	}
+	sub.Confirmed = true
+	return sub, nil
+}
+
+func (f *fakeSubscribers) Remove(ctx context.Context, token string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if _, ok := f.subscribers[token]; !ok {
+		return fmt.Errorf("Unknown token.")
+	}
+	delete(f.subscribers, token)
+	return nil
+}
+
+func (f *fakeSubscribers) MarkSent(ctx context.Context, sub *subscribers.Subscriber, sent time.Time) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	sub.LastSent = sent
+	return nil
+}
+
+func (f *fakeSubscribers) Confirmed(ctx context.Context) ([]*subscribers.Subscriber, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	ret := []*subscribers.Subscriber{}
+	for _, sub := range f.subscribers {
+		if sub.Confirmed {
+			ret = append(ret, sub)
+		}
+	}
+	return ret, nil
+}
+
+// fakeMailer records the subjects of the mail it is asked to send.
+type fakeMailer struct {
+	mutex    sync.Mutex
+	subjects []string
+}
+
+func (f *fakeMailer) Mail(to []string, subject, contentType, body string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.subjects = append(f.subjects, subject)
+	return nil
+}
+
+// fakeAuth treats every sign in as an admin's.
+type fakeAuth struct{}
+
+func (fakeAuth) IsAdmin(r *http.Request, log slog.Logger) bool {
+	return true
+}
+
+// testStores are the fakes behind a test Server.
+type testStores struct {
+	entries     *fakeEntries
+	mentions    *fakeMentions
+	subscribers *fakeSubscribers
+	mailer      *fakeMailer
+	push        *fakePush
+	trusted     *fakeTrusted
+}
+
+// testConfig returns the config of a test Server for host.
+func testConfig(host string) *viper.Viper {
+	config := viper.New()
+	config.Set(HOST, host)
+	config.Set(AUTHOR, "Test Author")
+	config.Set(PROJECT, "test-project")
+	config.Set(DATASTORE_NAMESPACE, "test")
+
+	// Every test request comes from the same address.
+	config.Set(RATE_LIMIT_BURST, 1000)
+	config.Set(RATE_LIMIT_BAN_AFTER, 1000)
+	return config
+}
+
+// newTestServer returns a Server for config backed by fakes.
+func newTestServer(t *testing.T, config *viper.Viper) (*Server, *testStores) {
+	ts := &testStores{
+		entries:     newFakeEntries(),
+		mentions:    newFakeMentions(),
+		subscribers: &fakeSubscribers{},
+		mailer:      &fakeMailer{},
+		push:        &fakePush{},
+		trusted:     newFakeTrusted(config.GetStringSlice(VOUCH_DOMAINS)),
+	}
+	st := stores{
+		entryDB:      ts.entries,
+		tokenDB:      fakeTokens{},
+		sessionDB:    fakeSessions{},
+		featureDB:    &fakeFeatures{},
+		websubDB:     fakeWebSub{},
+		endpointDB:   fakeEndpoints{},
+		mentionDB:    ts.mentions,
+		outboxDB:     &fakeOutbox{},
+		snippetDB:    &fakeSnippets{},
+		referrerDB:   fakeReferrers{},
+		shortDB:      fakeShortURLs{entryDB: ts.entries},
+		mediaDB:      fakeMedia{},
+		linkDB:       fakeLinks{},
+		blockDB:      &fakeBlocks{},
+		trustDB:      ts.trusted,
+		replyDB:      fakeReplies{},
+		pushDB:       ts.push,
+		mailer:       ts.mailer,
+		subscriberDB: ts.subscribers,
+	}
+	log := logger.New()
+	s, err := newServer(config, st, fakeAuth{}, notifier.NewNop(log), log)
+	assert.NoError(t, err)
+	return s, ts
+}
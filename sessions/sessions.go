@@ -0,0 +1,244 @@
+// Package sessions manages admin sessions, which are started once the
+// identity of an admin has been checked, so it doesn't have to be checked
+// again on every request, and which can be revoked.
+//
+// The session cookie holds a random token, signed if there is a secret, and
+// only a hash of the token is stored.
+package sessions
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+
+	"github.com/jcgregorio/go-lib/ds"
+	"github.com/jcgregorio/slog"
+)
+
+const (
+	SESSION ds.Kind = "Session"
+
+	// COOKIE is the name of the session cookie.
+	COOKIE = "session"
+
+	// CACHE_FOR is how long a session is used from memory before being read
+	// again, which is also how long a revoked session may still work on
+	// other instances.
+	CACHE_FOR = time.Minute
+
+	// SEEN_EVERY is how often LastSeen is updated.
+	SEEN_EVERY = time.Hour
+)
+
+// Session is a signed in admin.
+type Session struct {
+	// ID is the hash of the token in the cookie.
+	ID        string    `datastore:"-"`
+	UserAgent string    `datastore:"user_agent,noindex"`
+	Created   time.Time `datastore:"created"`
+	Expires   time.Time `datastore:"expires,noindex"`
+	LastSeen  time.Time `datastore:"last_seen,noindex"`
+}
+
+// cached is a session and when it was read.
+type cached struct {
+	session *Session
+	read    time.Time
+}
+
+type Sessions struct {
+	DS  *ds.DS
+	log slog.Logger
+
+	// secret signs cookies, if not empty.
+	secret []byte
+
+	// lifetime is how long a session lasts.
+	lifetime time.Duration
+
+	// now is replaceable for testing.
+	now func() time.Time
+
+	mutex sync.Mutex
+	cache map[string]cached
+}
+
+// New returns a Sessions whose sessions last for lifetime, with cookies
+// signed with secret if it isn't empty.
+func New(ctx context.Context, project, ns, secret string, lifetime time.Duration, log slog.Logger) (*Sessions, error) {
+	d, err := ds.New(ctx, project, ns)
+	if err != nil {
+		return nil, err
+	}
+	return &Sessions{
+		DS:       d,
+		log:      log,
+		secret:   []byte(secret),
+		lifetime: lifetime,
+		now:      time.Now,
+		cache:    map[string]cached{},
+	}, nil
+}
+
+// Lifetime is how long a session lasts.
+func (s *Sessions) Lifetime() time.Duration {
+	return s.lifetime
+}
+
+func hash(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+func (s *Sessions) sign(token string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// cookie returns the cookie value for token.
+func (s *Sessions) cookie(token string) string {
+	if len(s.secret) == 0 {
+		return token
+	}
+	return token + "." + s.sign(token)
+}
+
+// ID returns the ID of the session in a cookie value, or "" if the value
+// isn't validly signed.
+func (s *Sessions) ID(value string) string {
+	token := value
+	if len(s.secret) != 0 {
+		parts := strings.Split(value, ".")
+		if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(s.sign(parts[0]))) {
+			return ""
+		}
+		token = parts[0]
+	}
+	if token == "" {
+		return ""
+	}
+	return hash(token)
+}
+
+func (s *Sessions) key(id string) *datastore.Key {
+	key := s.DS.NewKey(SESSION)
+	key.Name = id
+	return key
+}
+
+// Create starts a new session and returns the value for its cookie.
+func (s *Sessions) Create(ctx context.Context, userAgent string) (string, *Session, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	token := hex.EncodeToString(b)
+	now := s.now()
+	session := &Session{
+		ID:        hash(token),
+		UserAgent: userAgent,
+		Created:   now,
+		Expires:   now.Add(s.lifetime),
+		LastSeen:  now,
+	}
+	if _, err := s.DS.Client.Put(ctx, s.key(session.ID), session); err != nil {
+		return "", nil, fmt.Errorf("Failed to store session: %s", err)
+	}
+	return s.cookie(token), session, nil
+}
+
+// Get returns the session for a cookie value, or an error if there isn't
+// one, or it has expired or been revoked.
+func (s *Sessions) Get(ctx context.Context, value string) (*Session, error) {
+	id := s.ID(value)
+	if id == "" {
+		return nil, fmt.Errorf("Invalid session cookie.")
+	}
+	now := s.now()
+	s.mutex.Lock()
+	c, ok := s.cache[id]
+	s.mutex.Unlock()
+	if !ok || now.Sub(c.read) > CACHE_FOR {
+		session := &Session{}
+		if err := s.DS.Client.Get(ctx, s.key(id), session); err != nil {
+			s.mutex.Lock()
+			delete(s.cache, id)
+			s.mutex.Unlock()
+			return nil, fmt.Errorf("Unknown session.")
+		}
+		session.ID = id
+		c = cached{session: session, read: now}
+		s.mutex.Lock()
+		s.cache[id] = c
+		s.mutex.Unlock()
+	}
+	session := c.session
+	if now.After(session.Expires) {
+		return nil, fmt.Errorf("Session expired.")
+	}
+	if now.Sub(session.LastSeen) > SEEN_EVERY {
+		seen := *session
+		seen.LastSeen = now
+		if _, err := s.DS.Client.Put(ctx, s.key(id), &seen); err != nil {
+			s.log.Warningf("Failed to record session use: %s", err)
+		} else {
+			s.mutex.Lock()
+			s.cache[id] = cached{session: &seen, read: c.read}
+			s.mutex.Unlock()
+		}
+	}
+	return session, nil
+}
+
+// List returns the sessions that haven't expired, newest first. Expired
+// sessions found along the way are deleted.
+func (s *Sessions) List(ctx context.Context) ([]*Session, error) {
+	ret := []*Session{}
+	expired := []*datastore.Key{}
+	now := s.now()
+	it := s.DS.Client.Run(ctx, s.DS.NewQuery(SESSION))
+	for {
+		session := &Session{}
+		key, err := it.Next(session)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed while reading sessions: %s", err)
+		}
+		if now.After(session.Expires) {
+			expired = append(expired, key)
+			continue
+		}
+		session.ID = key.Name
+		ret = append(ret, session)
+	}
+	if len(expired) > 0 {
+		if err := s.DS.Client.DeleteMulti(ctx, expired); err != nil {
+			s.log.Warningf("Failed to delete expired sessions: %s", err)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Created.After(ret[j].Created) })
+	return ret, nil
+}
+
+// Revoke ends the session with the given ID.
+func (s *Sessions) Revoke(ctx context.Context, id string) error {
+	s.mutex.Lock()
+	delete(s.cache, id)
+	s.mutex.Unlock()
+	if err := s.DS.Client.Delete(ctx, s.key(id)); err != nil {
+		return fmt.Errorf("Failed to revoke session: %s", err)
+	}
+	return nil
+}
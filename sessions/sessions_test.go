@@ -0,0 +1,68 @@
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jcgregorio/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestID(t *testing.T) {
+	s := &Sessions{secret: []byte("secret")}
+	value := s.cookie("abc")
+	assert.Equal(t, hash("abc"), s.ID(value))
+	assert.Equal(t, "", s.ID("abc"))
+	assert.Equal(t, "", s.ID(value+"0"))
+	assert.Equal(t, "", s.ID(""))
+
+	// Without a secret the cookie is just the token.
+	s = &Sessions{}
+	assert.Equal(t, "abc", s.cookie("abc"))
+	assert.Equal(t, hash("abc"), s.ID("abc"))
+	assert.Equal(t, "", s.ID(""))
+}
+
+func initForTesting(t *testing.T) *Sessions {
+	if os.Getenv("DATASTORE_EMULATOR_HOST") == "" {
+		t.Skip("Requires a running Cloud Datastore emulator, see entries/entries_test.go.")
+	}
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	s, err := New(context.Background(), "test-project", fmt.Sprintf("test-namespace-%d", r.Uint64()), "secret", time.Hour, logger.New())
+	assert.NoError(t, err)
+	return s
+}
+
+func TestSessions(t *testing.T) {
+	s := initForTesting(t)
+	ctx := context.Background()
+
+	value, session, err := s.Create(ctx, "Firefox")
+	assert.NoError(t, err)
+	got, err := s.Get(ctx, value)
+	assert.NoError(t, err)
+	assert.Equal(t, session.ID, got.ID)
+
+	list, err := s.List(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+	assert.Equal(t, "Firefox", list[0].UserAgent)
+
+	assert.NoError(t, s.Revoke(ctx, session.ID))
+	_, err = s.Get(ctx, value)
+	assert.Error(t, err)
+
+	// Expired sessions don't work and are removed when listed.
+	value, _, err = s.Create(ctx, "Chrome")
+	assert.NoError(t, err)
+	s.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	_, err = s.Get(ctx, value)
+	assert.Error(t, err)
+	list, err = s.List(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, list)
+}
@@ -0,0 +1,236 @@
+// Package sharetarget turns what the Web Share Target API sends into a title
+// and content for a new entry. Shares are messy: depending on the app the
+// URL may be in the url or text field, the text may be a selection, and the
+// title may be the app's own decoration of the page title. Sites that are
+// shared often have their own rules, and every other page falls back to its
+// metadata and first paragraph.
+package sharetarget
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// DESCRIPTION_LENGTH is the longest description, in runes, quoted from a
+// page.
+const DESCRIPTION_LENGTH = 280
+
+// Share is what the share target was sent.
+type Share struct {
+	Title string
+	Text  string
+	URL   string
+}
+
+// Result pre-populates the new entry form.
+type Result struct {
+	Title   string
+	Content string
+
+	// URL is the canonical URL of the shared page, or "" if no page was
+	// shared.
+	URL string
+}
+
+// Fetch fetches and parses the HTML page at u.
+type Fetch func(u string) (*goquery.Document, error)
+
+// page is what is known about a shared page.
+type page struct {
+	url *url.URL
+
+	// title is the name of the page, and author who wrote it, if known.
+	title  string
+	author string
+
+	// note is true for posts on social sites, whose replies are notes
+	// without a title of their own.
+	note bool
+
+	// quote is text from the page to quote in the entry, either the shared
+	// selection or the page's description.
+	quote string
+}
+
+// rule extracts what it can about pages on one site, returning false if it
+// doesn't handle p.url.
+type rule func(p *page, s Share, fetch Fetch) bool
+
+// Extractor extracts entries from shares.
+type Extractor struct {
+	fetch Fetch
+	rules []rule
+}
+
+// New returns an Extractor that fetches shared pages with fetch.
+func New(fetch Fetch) *Extractor {
+	return &Extractor{
+		fetch: fetch,
+		rules: []rule{twitter, youtube, github, mastodon, generic},
+	}
+}
+
+// urlRegex finds a URL in shared text.
+var urlRegex = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// parseURL returns u parsed if it's an absolute http or https URL.
+func parseURL(u string) (*url.URL, bool) {
+	parsed, err := url.Parse(strings.TrimSpace(u))
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, false
+	}
+	return parsed, true
+}
+
+// sharedURL finds the URL of the shared page, which is in the url field or,
+// for many Android apps, in the text field, and returns it along with the
+// text that remains once it's removed.
+func sharedURL(s Share) (*url.URL, string) {
+	text := strings.TrimSpace(s.Text)
+	if u, ok := parseURL(s.URL); ok {
+		return u, strings.TrimSpace(strings.Replace(text, s.URL, "", 1))
+	}
+	if m := urlRegex.FindString(text); m != "" {
+		// Punctuation that ends a sentence isn't part of the URL.
+		m = strings.TrimRight(m, ".,;:!?)")
+		if u, ok := parseURL(m); ok {
+			return u, strings.TrimSpace(strings.Replace(text, m, "", 1))
+		}
+	}
+	return nil, text
+}
+
+// Extract returns the entry to start from the share.
+func (e *Extractor) Extract(s Share) *Result {
+	u, text := sharedURL(s)
+	if u == nil {
+		// Just text, such as a selection shared from an app that doesn't
+		// have URLs.
+		return &Result{
+			Title:   strings.TrimSpace(s.Title),
+			Content: text,
+		}
+	}
+	p := &page{
+		url:   u,
+		quote: text,
+	}
+	for _, r := range e.rules {
+		if r(p, s, e.fetch) {
+			break
+		}
+	}
+	name := p.title
+	if name == "" {
+		name = p.url.String()
+	}
+	content := fmt.Sprintf("<a class='u-in-reply-to' href='%s'>%s</a>", html.EscapeString(p.url.String()), html.EscapeString(name))
+	if p.author != "" {
+		content += " by " + html.EscapeString(p.author)
+	}
+	if quote := strings.TrimSpace(p.quote); quote != "" {
+		content += "\n\n> " + strings.Join(strings.Split(quote, "\n"), "\n> ")
+	}
+	ret := &Result{
+		Title:   p.title,
+		Content: content,
+		URL:     p.url.String(),
+	}
+	if p.note {
+		ret.Title = ""
+	}
+	return ret
+}
+
+// shorten truncates s to at most n runes, at a word boundary, adding an
+// ellipsis if anything was removed.
+func shorten(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	cut := string(runes[:n])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return cut + "…"
+}
+
+// meta returns the content of the first of the named <meta> elements found
+// in doc, looking at both property and name attributes.
+func meta(doc *goquery.Document, names ...string) string {
+	for _, name := range names {
+		sel := fmt.Sprintf(`meta[property=%q], meta[name=%q]`, name, name)
+		if v := strings.TrimSpace(doc.Find(sel).First().AttrOr("content", "")); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// readPage fills in what p is missing from the page's metadata: its
+// canonical URL, title, and description, falling back to the <title>, first
+// heading, and first paragraph of text.
+func readPage(p *page, doc *goquery.Document) {
+	if href, ok := doc.Find("link[rel=canonical]").First().Attr("href"); ok {
+		if canonical, err := p.url.Parse(strings.TrimSpace(href)); err == nil && (canonical.Scheme == "http" || canonical.Scheme == "https") {
+			p.url = canonical
+		}
+	}
+	if p.title == "" {
+		p.title = meta(doc, "og:title", "twitter:title")
+	}
+	if p.title == "" {
+		p.title = strings.TrimSpace(doc.Find("title").First().Text())
+	}
+	if p.title == "" {
+		p.title = strings.TrimSpace(doc.Find("h1").First().Text())
+	}
+	p.title = strings.Join(strings.Fields(p.title), " ")
+	if p.author == "" {
+		p.author = meta(doc, "author", "article:author")
+		if _, ok := parseURL(p.author); ok {
+			// Some sites use a link to the author's page.
+			p.author = ""
+		}
+	}
+	if p.quote != "" {
+		return
+	}
+	description := meta(doc, "og:description", "twitter:description", "description")
+	if description == "" {
+		doc.Find("article p, main p, p").EachWithBreak(func(i int, s *goquery.Selection) bool {
+			text := strings.TrimSpace(s.Text())
+			// Skip bylines, captions, and other short bits before the text.
+			if len(strings.Fields(text)) < 8 {
+				return true
+			}
+			description = text
+			return false
+		})
+	}
+	p.quote = shorten(description, DESCRIPTION_LENGTH)
+}
+
+// generic handles every page by reading its metadata.
+func generic(p *page, s Share, fetch Fetch) bool {
+	p.title = strings.TrimSpace(s.Title)
+	doc, err := fetch(p.url.String())
+	if err != nil {
+		return true
+	}
+	// Apps often decorate the page title, e.g. "Page - Chrome", so the
+	// page's own title is preferred.
+	p.title = ""
+	readPage(p, doc)
+	if p.title == "" {
+		p.title = strings.TrimSpace(s.Title)
+	}
+	return true
+}
@@ -0,0 +1,169 @@
+package sharetarget
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/assert"
+)
+
+// pages returns a Fetch that serves the HTML in pages, and records the URLs
+// fetched in fetched.
+func pages(fetched *[]string, pages map[string]string) Fetch {
+	return func(u string) (*goquery.Document, error) {
+		*fetched = append(*fetched, u)
+		h, ok := pages[u]
+		if !ok {
+			return nil, fmt.Errorf("Not found: %q", u)
+		}
+		return goquery.NewDocumentFromReader(strings.NewReader(h))
+	}
+}
+
+func TestExtract_Text(t *testing.T) {
+	fetched := []string{}
+	e := New(pages(&fetched, nil))
+	got := e.Extract(Share{Title: "A thought", Text: "Some selected text."})
+	assert.Equal(t, &Result{Title: "A thought", Content: "Some selected text."}, got)
+	assert.Empty(t, fetched)
+}
+
+func TestExtract_Generic(t *testing.T) {
+	fetched := []string{}
+	e := New(pages(&fetched, map[string]string{
+		"https://example.org/post?utm_source=x": `<html><head>
+<title>Post - Example</title>
+<meta property="og:title" content="Post">
+<meta name="description" content="What the post is about.">
+<meta name="author" content="Jane &amp; Joe">
+<link rel="canonical" href="/post">
+</head></html>`,
+	}))
+	got := e.Extract(Share{Title: "Post - Example - Chrome", URL: "https://example.org/post?utm_source=x"})
+	assert.Equal(t, "Post", got.Title)
+	assert.Equal(t, "https://example.org/post", got.URL)
+	assert.Equal(t, "<a class='u-in-reply-to' href='https://example.org/post'>Post</a> by Jane &amp; Joe\n\n> What the post is about.", got.Content)
+}
+
+func TestExtract_URLInText(t *testing.T) {
+	fetched := []string{}
+	e := New(pages(&fetched, map[string]string{
+		"https://example.org/a": `<html><head><title>A</title></head><body>
+<p>By Jane</p>
+<p>This is the first real paragraph of the page, long enough to quote.</p>
+</body></html>`,
+	}))
+	got := e.Extract(Share{Title: "Chrome", Text: "Check this out https://example.org/a."})
+	assert.Equal(t, []string{"https://example.org/a"}, fetched)
+	assert.Equal(t, "A", got.Title)
+	// The rest of the text is what was selected, so it's quoted instead of the
+	// first paragraph.
+	assert.Equal(t, "<a class='u-in-reply-to' href='https://example.org/a'>A</a>\n\n> Check this out .", got.Content)
+
+	got = e.Extract(Share{Text: "https://example.org/a"})
+	assert.Equal(t, "<a class='u-in-reply-to' href='https://example.org/a'>A</a>\n\n> This is the first real paragraph of the page, long enough to quote.", got.Content)
+}
+
+func TestExtract_FetchFails(t *testing.T) {
+	fetched := []string{}
+	e := New(pages(&fetched, nil))
+	got := e.Extract(Share{Title: "Gone", URL: "https://example.org/gone"})
+	assert.Equal(t, &Result{
+		Title:   "Gone",
+		Content: "<a class='u-in-reply-to' href='https://example.org/gone'>Gone</a>",
+		URL:     "https://example.org/gone",
+	}, got)
+}
+
+func TestExtract_Twitter(t *testing.T) {
+	fetched := []string{}
+	e := New(pages(&fetched, nil))
+	got := e.Extract(Share{
+		Title: `Joe Gregorio on X: "Hello, world" / X`,
+		Text:  "https://twitter.com/jcgregorio/status/12345?s=20&t=abc",
+	})
+	assert.Empty(t, fetched)
+	assert.Equal(t, &Result{
+		Content: "<a class='u-in-reply-to' href='https://x.com/jcgregorio/status/12345'>Joe Gregorio</a>\n\n> Hello, world",
+		URL:     "https://x.com/jcgregorio/status/12345",
+	}, got)
+
+	got = e.Extract(Share{URL: "https://x.com/jcgregorio/status/12345"})
+	assert.Equal(t, "<a class='u-in-reply-to' href='https://x.com/jcgregorio/status/12345'>@jcgregorio</a>", got.Content)
+
+	// Profiles aren't tweets.
+	e.Extract(Share{URL: "https://x.com/jcgregorio"})
+	assert.Equal(t, []string{"https://x.com/jcgregorio"}, fetched)
+}
+
+func TestExtract_YouTube(t *testing.T) {
+	const video = `<html><head>
+<title>A Video - YouTube</title>
+<meta property="og:title" content="A Video">
+<meta property="og:description" content="All about it.">
+</head><body>
+<span itemprop="author"><link itemprop="name" content="The Channel"></span>
+</body></html>`
+	fetched := []string{}
+	e := New(pages(&fetched, map[string]string{
+		"https://www.youtube.com/watch?v=abc123": video,
+	}))
+	for _, u := range []string{
+		"https://youtu.be/abc123?si=tracking",
+		"https://m.youtube.com/watch?v=abc123&t=10",
+		"https://www.youtube.com/shorts/abc123",
+	} {
+		got := e.Extract(Share{URL: u})
+		assert.Equal(t, &Result{
+			Title:   "A Video",
+			Content: "<a class='u-in-reply-to' href='https://www.youtube.com/watch?v=abc123'>A Video</a> by The Channel\n\n> All about it.",
+			URL:     "https://www.youtube.com/watch?v=abc123",
+		}, got, u)
+	}
+
+	// Without the page the share title is used.
+	got := e.Extract(Share{Title: "Other - YouTube", URL: "https://youtu.be/other"})
+	assert.Equal(t, "Other", got.Title)
+}
+
+func TestExtract_GitHub(t *testing.T) {
+	fetched := []string{}
+	e := New(pages(&fetched, map[string]string{
+		"https://github.com/jcgregorio/stream-run": `<html><head>
+<title>GitHub - jcgregorio/stream-run: A blog.</title>
+<meta property="og:description" content="A blog.">
+</head></html>`,
+		"https://github.com/jcgregorio/stream-run/issues/1": `<html><head>
+<title>Broken feed · Issue #1 · jcgregorio/stream-run · GitHub</title>
+</head></html>`,
+	}))
+	got := e.Extract(Share{URL: "https://github.com/jcgregorio/stream-run"})
+	assert.Equal(t, "jcgregorio/stream-run", got.Title)
+	assert.Equal(t, "<a class='u-in-reply-to' href='https://github.com/jcgregorio/stream-run'>jcgregorio/stream-run</a>\n\n> A blog.", got.Content)
+
+	got = e.Extract(Share{URL: "https://github.com/jcgregorio/stream-run/issues/1"})
+	assert.Equal(t, "Broken feed · Issue #1 · jcgregorio/stream-run", got.Title)
+}
+
+func TestExtract_Mastodon(t *testing.T) {
+	fetched := []string{}
+	e := New(pages(&fetched, map[string]string{
+		"https://mastodon.social/@joe/109876": `<html><head>
+<meta property="og:title" content="Joe (@joe@mastodon.social)">
+<meta property="og:description" content="A toot.">
+</head></html>`,
+	}))
+	got := e.Extract(Share{URL: "https://mastodon.social/@joe/109876#reply"})
+	assert.Equal(t, &Result{
+		Content: "<a class='u-in-reply-to' href='https://mastodon.social/@joe/109876'>Joe (@joe@mastodon.social)</a>\n\n> A toot.",
+		URL:     "https://mastodon.social/@joe/109876",
+	}, got)
+}
+
+func TestShorten(t *testing.T) {
+	assert.Equal(t, "short", shorten("short", 10))
+	assert.Equal(t, "a b c", shorten(" a\n b  c ", 10))
+	assert.Equal(t, "one two…", shorten("one two three", 10))
+}
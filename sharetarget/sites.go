@@ -0,0 +1,135 @@
+package sharetarget
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// hostIn returns true if the host of u, ignoring "www." and "m.", is one of
+// hosts.
+func hostIn(u *url.URL, hosts ...string) bool {
+	host := strings.ToLower(u.Hostname())
+	host = strings.TrimPrefix(strings.TrimPrefix(host, "www."), "m.")
+	for _, h := range hosts {
+		if host == h {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// tweetPathRegex matches the path of a tweet, capturing the user and id.
+	tweetPathRegex = regexp.MustCompile(`^/([A-Za-z0-9_]+)/status(?:es)?/([0-9]+)`)
+
+	// tweetTitleRegex matches the title Twitter and X give a tweet, e.g.
+	// `Joe on X: "Hello" / X`, capturing the name and text.
+	tweetTitleRegex = regexp.MustCompile(`(?s)^(.+?) on (?:Twitter|X): ["“](.*)["”](?: / (?:Twitter|X))?$`)
+)
+
+// twitter handles tweets. Their pages need JavaScript, so everything comes
+// from the share and nothing is fetched.
+func twitter(p *page, s Share, fetch Fetch) bool {
+	if !hostIn(p.url, "twitter.com", "x.com", "mobile.twitter.com") {
+		return false
+	}
+	m := tweetPathRegex.FindStringSubmatch(p.url.Path)
+	if m == nil {
+		return false
+	}
+	// Drop the tracking query parameters added by the share sheet.
+	p.url = &url.URL{Scheme: "https", Host: "x.com", Path: "/" + m[1] + "/status/" + m[2]}
+	p.note = true
+	p.title = "@" + m[1]
+	if t := tweetTitleRegex.FindStringSubmatch(strings.TrimSpace(s.Title)); t != nil {
+		p.title = strings.TrimSpace(t[1])
+		if p.quote == "" {
+			p.quote = strings.TrimSpace(t[2])
+		}
+	}
+	return true
+}
+
+// youtubeID returns the id of the video at u, or "" if u isn't a video.
+func youtubeID(u *url.URL) string {
+	switch {
+	case hostIn(u, "youtu.be"):
+		return strings.Trim(u.Path, "/")
+	case hostIn(u, "youtube.com", "music.youtube.com"):
+		if id := u.Query().Get("v"); id != "" {
+			return id
+		}
+		for _, prefix := range []string{"/shorts/", "/live/", "/embed/"} {
+			if strings.HasPrefix(u.Path, prefix) {
+				return strings.Trim(strings.TrimPrefix(u.Path, prefix), "/")
+			}
+		}
+	}
+	return ""
+}
+
+// youtube handles videos, whose share links come in many forms, so they are
+// all turned into the watch URL.
+func youtube(p *page, s Share, fetch Fetch) bool {
+	id := youtubeID(p.url)
+	if id == "" {
+		return false
+	}
+	p.url = &url.URL{Scheme: "https", Host: "www.youtube.com", Path: "/watch", RawQuery: url.Values{"v": {id}}.Encode()}
+	if doc, err := fetch(p.url.String()); err == nil {
+		readPage(p, doc)
+		// The channel is only in the microdata.
+		if channel := strings.TrimSpace(doc.Find(`[itemprop=author] [itemprop=name]`).First().AttrOr("content", "")); channel != "" {
+			p.author = channel
+		}
+	}
+	if p.title == "" {
+		p.title = strings.TrimSpace(s.Title)
+	}
+	p.title = strings.TrimSuffix(p.title, " - YouTube")
+	return true
+}
+
+// github handles repos, issues, and pull requests, whose titles are
+// decorated with "GitHub - " and " · GitHub".
+func github(p *page, s Share, fetch Fetch) bool {
+	if !hostIn(p.url, "github.com") {
+		return false
+	}
+	if doc, err := fetch(p.url.String()); err == nil {
+		readPage(p, doc)
+	}
+	if p.title == "" {
+		p.title = strings.TrimSpace(s.Title)
+	}
+	p.title = strings.TrimSuffix(strings.TrimPrefix(p.title, "GitHub - "), " · GitHub")
+	// A repo's title repeats its description, which is already quoted.
+	parts := strings.Split(strings.Trim(p.url.Path, "/"), "/")
+	if len(parts) == 2 && strings.HasPrefix(p.title, parts[0]+"/"+parts[1]+": ") {
+		p.title = parts[0] + "/" + parts[1]
+	}
+	return true
+}
+
+// mastodonPathRegex matches the path of a post on Mastodon and compatible
+// servers, which can be on any host.
+var mastodonPathRegex = regexp.MustCompile(`^/(?:@[A-Za-z0-9_.-]+(?:@[^/]+)?|users/[A-Za-z0-9_.-]+/statuses)/[0-9]+/?$`)
+
+// mastodon handles posts on the fediverse, whose og:title is the author and
+// og:description is the text of the post.
+func mastodon(p *page, s Share, fetch Fetch) bool {
+	if !mastodonPathRegex.MatchString(p.url.Path) {
+		return false
+	}
+	p.note = true
+	p.url.RawQuery = ""
+	p.url.Fragment = ""
+	if doc, err := fetch(p.url.String()); err == nil {
+		readPage(p, doc)
+	}
+	if p.title == "" {
+		p.title = strings.TrimSpace(s.Title)
+	}
+	return true
+}
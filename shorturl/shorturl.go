@@ -0,0 +1,175 @@
+// Package shorturl stores short codes for entries, used in /s/{code} links
+// when syndicating to networks that limit post length, and counts clicks on
+// them.
+package shorturl
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"math/big"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+
+	"github.com/jcgregorio/go-lib/ds"
+	"github.com/jcgregorio/slog"
+)
+
+const (
+	SHORT_URL ds.Kind = "ShortURL"
+)
+
+// CODE_LENGTH is the length of a code, which allows for 62^5, about 900
+// million, codes.
+const CODE_LENGTH = 5
+
+const alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// ShortURL maps a code to an entry. The code is the key name.
+type ShortURL struct {
+	Code    string    `datastore:"-"`
+	EntryID string    `datastore:"entry_id"`
+	Clicks  int64     `datastore:"clicks"`
+	Created time.Time `datastore:"created,noindex"`
+}
+
+type ShortURLs struct {
+	DS  *ds.DS
+	log slog.Logger
+}
+
+func New(ctx context.Context, project, ns string, log slog.Logger) (*ShortURLs, error) {
+	d, err := ds.New(ctx, project, ns)
+	if err != nil {
+		return nil, err
+	}
+	return &ShortURLs{
+		DS:  d,
+		log: log,
+	}, nil
+}
+
+// Encode returns the base62 encoding of b.
+func Encode(b []byte) string {
+	n := new(big.Int).SetBytes(b)
+	if n.Sign() == 0 {
+		return string(alphabet[0])
+	}
+	base := big.NewInt(int64(len(alphabet)))
+	mod := new(big.Int)
+	ret := []byte{}
+	for n.Sign() > 0 {
+		n.DivMod(n, base, mod)
+		ret = append(ret, alphabet[mod.Int64()])
+	}
+	// Reverse so the most significant digit is first.
+	for i, j := 0, len(ret)-1; i < j; i, j = i+1, j-1 {
+		ret[i], ret[j] = ret[j], ret[i]
+	}
+	return string(ret)
+}
+
+// candidate returns the code to try for entryID on the given attempt, which
+// is different for each attempt in case of collisions.
+func candidate(entryID string, attempt int) string {
+	sum := md5.Sum([]byte(fmt.Sprintf("%s:%d", entryID, attempt)))
+	code := Encode(sum[:])
+	return code[:CODE_LENGTH]
+}
+
+func (s *ShortURLs) key(code string) *datastore.Key {
+	key := s.DS.NewKey(SHORT_URL)
+	key.Name = code
+	return key
+}
+
+// Lookup returns the code for the given entry, or "" if it doesn't have one.
+func (s *ShortURLs) Lookup(ctx context.Context, entryID string) (string, error) {
+	keys, err := s.DS.Client.GetAll(ctx, s.DS.NewQuery(SHORT_URL).Filter("entry_id =", entryID).KeysOnly().Limit(1), nil)
+	if err != nil {
+		return "", fmt.Errorf("Failed to look up short URL: %s", err)
+	}
+	if len(keys) == 0 {
+		return "", nil
+	}
+	return keys[0].Name, nil
+}
+
+// For returns the code for the given entry, creating one if needed.
+func (s *ShortURLs) For(ctx context.Context, entryID string) (string, error) {
+	code, err := s.Lookup(ctx, entryID)
+	if err != nil || code != "" {
+		return code, err
+	}
+	for attempt := 0; attempt < 10; attempt++ {
+		code := candidate(entryID, attempt)
+		_, err := s.DS.Client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+			var existing ShortURL
+			err := tx.Get(s.key(code), &existing)
+			if err == nil {
+				if existing.EntryID == entryID {
+					return nil
+				}
+				return errCollision
+			}
+			if err != datastore.ErrNoSuchEntity {
+				return err
+			}
+			_, err = tx.Put(s.key(code), &ShortURL{
+				EntryID: entryID,
+				Created: time.Now(),
+			})
+			return err
+		})
+		if err == errCollision {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("Failed to create short URL: %s", err)
+		}
+		return code, nil
+	}
+	return "", fmt.Errorf("Failed to find an unused short URL for %q.", entryID)
+}
+
+var errCollision = fmt.Errorf("Code is already in use.")
+
+// Resolve returns the entry ID for the code and counts a click.
+func (s *ShortURLs) Resolve(ctx context.Context, code string) (string, error) {
+	var entryID string
+	_, err := s.DS.Client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var short ShortURL
+		if err := tx.Get(s.key(code), &short); err != nil {
+			return err
+		}
+		entryID = short.EntryID
+		short.Clicks += 1
+		_, err := tx.Put(s.key(code), &short)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("Failed to resolve %q: %s", code, err)
+	}
+	return entryID, nil
+}
+
+// Top returns the n most clicked short URLs.
+func (s *ShortURLs) Top(ctx context.Context, n int) ([]*ShortURL, error) {
+	ret := []*ShortURL{}
+	it := s.DS.Client.Run(ctx, s.DS.NewQuery(SHORT_URL).Order("-clicks").Limit(n))
+	for {
+		short := &ShortURL{}
+		key, err := it.Next(short)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed while reading short URLs: %s", err)
+		}
+		short.Code = key.Name
+		ret = append(ret, short)
+	}
+	return ret, nil
+}
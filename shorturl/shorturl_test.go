@@ -0,0 +1,22 @@
+package shorturl
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncode(t *testing.T) {
+	assert.Equal(t, "0", Encode([]byte{}))
+	assert.Equal(t, "z", Encode([]byte{35}))
+	assert.Equal(t, "10", Encode([]byte{62}))
+	assert.Equal(t, "48", Encode([]byte{1, 0}))
+}
+
+func TestCandidate(t *testing.T) {
+	a := candidate("abc", 0)
+	assert.Len(t, a, CODE_LENGTH)
+	assert.Equal(t, a, candidate("abc", 0))
+	assert.NotEqual(t, a, candidate("abc", 1))
+	assert.NotEqual(t, a, candidate("abd", 0))
+}
@@ -0,0 +1,130 @@
+// Package snippets stores reusable content, such as a weekly notes template,
+// that can be inserted into the new entry form.
+package snippets
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+
+	"github.com/jcgregorio/go-lib/ds"
+	"github.com/jcgregorio/slog"
+)
+
+const (
+	SNIPPET ds.Kind = "Snippet"
+)
+
+// Snippet is a named piece of content. The Title and Content may contain
+// placeholders, see Expand.
+type Snippet struct {
+	Name    string    `datastore:"-"`
+	Title   string    `datastore:"title,noindex"`
+	Content string    `datastore:"content,noindex"`
+	Updated time.Time `datastore:"updated,noindex"`
+}
+
+// placeholderRegex matches placeholders like {date}.
+var placeholderRegex = regexp.MustCompile(`\{([a-z]+)\}`)
+
+// Values returns the placeholder values for a snippet inserted at time t:
+// {date} is t as 2006-01-02, {week} is the ISO week number, and {url} and
+// {title} are the given url and title, e.g. of a shared page.
+func Values(t time.Time, url, title string) map[string]string {
+	_, week := t.ISOWeek()
+	return map[string]string{
+		"date":  t.Format("2006-01-02"),
+		"week":  fmt.Sprintf("%d", week),
+		"url":   url,
+		"title": title,
+	}
+}
+
+// Expand replaces the placeholders in s with their values. Unknown
+// placeholders are left as they are.
+func Expand(s string, values map[string]string) string {
+	return placeholderRegex.ReplaceAllStringFunc(s, func(m string) string {
+		if v, ok := values[m[1:len(m)-1]]; ok {
+			return v
+		}
+		return m
+	})
+}
+
+type Snippets struct {
+	DS  *ds.DS
+	log slog.Logger
+}
+
+func New(ctx context.Context, project, ns string, log slog.Logger) (*Snippets, error) {
+	d, err := ds.New(ctx, project, ns)
+	if err != nil {
+		return nil, err
+	}
+	return &Snippets{
+		DS:  d,
+		log: log,
+	}, nil
+}
+
+func (s *Snippets) key(name string) *datastore.Key {
+	key := s.DS.NewKey(SNIPPET)
+	key.Name = name
+	return key
+}
+
+// Put stores the snippet, replacing any with the same name.
+func (s *Snippets) Put(ctx context.Context, snippet *Snippet) error {
+	snippet.Name = strings.TrimSpace(snippet.Name)
+	if snippet.Name == "" {
+		return fmt.Errorf("Snippet must have a name.")
+	}
+	snippet.Updated = time.Now()
+	if _, err := s.DS.Client.Put(ctx, s.key(snippet.Name), snippet); err != nil {
+		return fmt.Errorf("Failed to store snippet %q: %s", snippet.Name, err)
+	}
+	return nil
+}
+
+// Get returns the snippet with the given name.
+func (s *Snippets) Get(ctx context.Context, name string) (*Snippet, error) {
+	var ret Snippet
+	if err := s.DS.Client.Get(ctx, s.key(name), &ret); err != nil {
+		return nil, fmt.Errorf("Failed to get snippet %q: %s", name, err)
+	}
+	ret.Name = name
+	return &ret, nil
+}
+
+// Delete removes the snippet with the given name.
+func (s *Snippets) Delete(ctx context.Context, name string) error {
+	return s.DS.Client.Delete(ctx, s.key(name))
+}
+
+// List returns all the snippets sorted by name.
+func (s *Snippets) List(ctx context.Context) ([]*Snippet, error) {
+	ret := []*Snippet{}
+	it := s.DS.Client.Run(ctx, s.DS.NewQuery(SNIPPET))
+	for {
+		snippet := &Snippet{}
+		key, err := it.Next(snippet)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed while reading snippets: %s", err)
+		}
+		snippet.Name = key.Name
+		ret = append(ret, snippet)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret, nil
+}
@@ -0,0 +1,23 @@
+package snippets
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValues(t *testing.T) {
+	v := Values(time.Date(2021, 1, 4, 12, 0, 0, 0, time.UTC), "https://example.org/", "Example")
+	assert.Equal(t, "2021-01-04", v["date"])
+	assert.Equal(t, "1", v["week"])
+	assert.Equal(t, "https://example.org/", v["url"])
+	assert.Equal(t, "Example", v["title"])
+}
+
+func TestExpand(t *testing.T) {
+	values := map[string]string{"week": "12", "url": "https://example.org/"}
+	assert.Equal(t, "Week 12 notes", Expand("Week {week} notes", values))
+	assert.Equal(t, "[link](https://example.org/) {unknown} {}", Expand("[link]({url}) {unknown} {}", values))
+	assert.Equal(t, "", Expand("", values))
+}
@@ -3,27 +3,101 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"embed"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
 	"flag"
 	"fmt"
+	"html"
 	"html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"cloud.google.com/go/storage"
 	"github.com/PuerkitoBio/goquery"
-	units "github.com/docker/go-units"
+	"github.com/fsnotify/fsnotify"
 	"github.com/gorilla/mux"
 	"github.com/spf13/viper"
-	blackfriday "gopkg.in/russross/blackfriday.v2"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"golang.org/x/net/trace"
 
 	"github.com/jcgregorio/go-lib/admin"
 	"github.com/jcgregorio/logger"
+	"github.com/jcgregorio/slog"
+	"github.com/jcgregorio/stream-run/a11y"
+	"github.com/jcgregorio/stream-run/assets"
+	"github.com/jcgregorio/stream-run/auth"
+	"github.com/jcgregorio/stream-run/backfeed"
+	"github.com/jcgregorio/stream-run/backup"
+	"github.com/jcgregorio/stream-run/blocklist"
+	"github.com/jcgregorio/stream-run/bridges"
+	"github.com/jcgregorio/stream-run/cachecontrol"
+	"github.com/jcgregorio/stream-run/configcheck"
+	"github.com/jcgregorio/stream-run/discovery"
+	"github.com/jcgregorio/stream-run/emailreply"
+	"github.com/jcgregorio/stream-run/endpoints"
 	"github.com/jcgregorio/stream-run/entries"
+	"github.com/jcgregorio/stream-run/export"
+	"github.com/jcgregorio/stream-run/features"
+	"github.com/jcgregorio/stream-run/graphql"
+	"github.com/jcgregorio/stream-run/importer"
+	"github.com/jcgregorio/stream-run/interact"
+	"github.com/jcgregorio/stream-run/jsonld"
+	"github.com/jcgregorio/stream-run/limits"
+	"github.com/jcgregorio/stream-run/linkrel"
+	"github.com/jcgregorio/stream-run/linkrot"
+	"github.com/jcgregorio/stream-run/markdown"
+	"github.com/jcgregorio/stream-run/mastoapi"
+	"github.com/jcgregorio/stream-run/media"
+	"github.com/jcgregorio/stream-run/mentions"
+	"github.com/jcgregorio/stream-run/notifier"
+	"github.com/jcgregorio/stream-run/outbox"
+	"github.com/jcgregorio/stream-run/outlinks"
+	"github.com/jcgregorio/stream-run/push"
+	"github.com/jcgregorio/stream-run/ratelimit"
+	"github.com/jcgregorio/stream-run/receiver"
+	"github.com/jcgregorio/stream-run/referrers"
+	"github.com/jcgregorio/stream-run/related"
+	"github.com/jcgregorio/stream-run/replycontext"
+	"github.com/jcgregorio/stream-run/resize"
+	"github.com/jcgregorio/stream-run/robots"
+	"github.com/jcgregorio/stream-run/safefetch"
+	"github.com/jcgregorio/stream-run/scheduler"
+	"github.com/jcgregorio/stream-run/search"
+	"github.com/jcgregorio/stream-run/sessions"
+	"github.com/jcgregorio/stream-run/sharetarget"
+	"github.com/jcgregorio/stream-run/shorturl"
+	"github.com/jcgregorio/stream-run/snippets"
+	"github.com/jcgregorio/stream-run/subscribers"
+	"github.com/jcgregorio/stream-run/tasks"
+	"github.com/jcgregorio/stream-run/templatefuncs"
+	"github.com/jcgregorio/stream-run/tokens"
+	"github.com/jcgregorio/stream-run/trusted"
+	"github.com/jcgregorio/stream-run/twtxt"
+	"github.com/jcgregorio/stream-run/websub"
 	"willnorris.com/go/webmention"
 )
 
@@ -36,475 +110,6417 @@ const (
 	ADMINS              = "ADMINS"
 	HOST                = "HOST"
 	AUTHOR              = "AUTHOR"
+	AUTHOR_DESC         = "AUTHOR_DESC"
+	AUTHOR_IMAGE_URL    = "AUTHOR_IMAGE_URL"
+	AUTHOR_URL          = "AUTHOR_URL"
+	EMAIL               = "EMAIL"
 	WEBSUB              = "WEBSUB"
 	BRIDGES             = "BRIDGES"
 	FEDSOC_BRIDGE       = "FEDSOC_BRIDGE"
+	BRIDGE_PATHS        = "BRIDGE_PATHS"
+	REDIRECTS           = "REDIRECTS"
+	MARKDOWN            = "MARKDOWN"
+	BLOCKLIST           = "BLOCKLIST"
+	TIMEZONE            = "TIMEZONE"
+	DATE_FORMAT         = "DATE_FORMAT"
+	LOCALE              = "LOCALE"
+	ONTHISDAY_REMINDER  = "ONTHISDAY_REMINDER"
+	SMTP_HOST           = "SMTP_HOST"
+	SMTP_PORT           = "SMTP_PORT"
+	SMTP_USER           = "SMTP_USER"
+	NOTIFY_FROM         = "NOTIFY_FROM"
+	NOTIFY_TO           = "NOTIFY_TO"
+	DIGEST_DAYS         = "DIGEST_DAYS"
+	BACKFEED_MINUTES    = "BACKFEED_MINUTES"
+	BACKFEED_ENTRIES    = "BACKFEED_ENTRIES"
+	IMAGE_WIDTHS        = "IMAGE_WIDTHS"
+	VAPID_PUBLIC_KEY    = "VAPID_PUBLIC_KEY"
+	VAPID_SUBSCRIBER    = "VAPID_SUBSCRIBER"
+
+	// BACKUP_BUCKET is the GCS bucket periodic backups are written to. If
+	// empty then periodic backups are disabled.
+	BACKUP_BUCKET         = "BACKUP_BUCKET"
+	BACKUP_HOURS          = "BACKUP_HOURS"
+	BACKUP_RETENTION_DAYS = "BACKUP_RETENTION_DAYS"
+
+	// BACKUP_PREFIX is the prefix of backup object names in BACKUP_BUCKET,
+	// defaulting to "stream-backup/". Old backups are pruned by prefix, so
+	// streams sharing a bucket must have prefixes that don't start with
+	// each other's.
+	BACKUP_PREFIX = "BACKUP_PREFIX"
+
+	// LOCATION_FUZZ_PLACES is the number of decimal places checkin
+	// coordinates are rounded to when fuzzing is requested.
+	LOCATION_FUZZ_PLACES = "LOCATION_FUZZ_PLACES"
+
+	// Rate limiting of public write endpoints, per client IP.
+	RATE_LIMIT_PER_MINUTE  = "RATE_LIMIT_PER_MINUTE"
+	RATE_LIMIT_BURST       = "RATE_LIMIT_BURST"
+	RATE_LIMIT_BAN_AFTER   = "RATE_LIMIT_BAN_AFTER"
+	RATE_LIMIT_BAN_MINUTES = "RATE_LIMIT_BAN_MINUTES"
+
+	// TRUSTED_PROXIES is the number of proxies in front of the server, such
+	// as Cloud Run's, that append the client's address to X-Forwarded-For.
+	TRUSTED_PROXIES = "TRUSTED_PROXIES"
+
+	// Whole page caching of the index, feed, and permalinks. The page cache
+	// is cleared when entries change, but a fronting CDN can't be cleared,
+	// so it is told to only keep pages for PAGE_CACHE_S_MAXAGE seconds.
+	PAGE_CACHE_S_MAXAGE = "PAGE_CACHE_S_MAXAGE"
+	PAGE_CACHE_SWR      = "PAGE_CACHE_SWR"
+
+	// AUTOCERT serves HTTPS directly with certificates from Let's Encrypt,
+	// for running on a bare VM instead of behind Cloud Run. Certificates are
+	// stored in AUTOCERT_CACHE_DIR, which defaults to resources_dir/autocert.
+	AUTOCERT           = "AUTOCERT"
+	AUTOCERT_CACHE_DIR = "AUTOCERT_CACHE_DIR"
+	AUTOCERT_EMAIL     = "AUTOCERT_EMAIL"
+
+	// LISTENERS is a list of {"network", "addr", "no_admin", "cert_file",
+	// "key_file"} to listen on, which defaults to tcp on $PORT.
+	LISTENERS = "LISTENERS"
+
+	// H2C enables cleartext HTTP/2.
+	H2C = "H2C"
+
+	READ_TIMEOUT_SECONDS  = "READ_TIMEOUT_SECONDS"
+	WRITE_TIMEOUT_SECONDS = "WRITE_TIMEOUT_SECONDS"
+	IDLE_TIMEOUT_SECONDS  = "IDLE_TIMEOUT_SECONDS"
+
+	// SW_PRECACHE_ENTRIES is the number of recent entries the service worker
+	// caches for offline reading.
+	SW_PRECACHE_ENTRIES = "SW_PRECACHE_ENTRIES"
+
+	// LINKROT_HOURS is how often the external links in public entries are
+	// checked, 0 disables checking. If LINKROT_ARCHIVE is true then dead
+	// links are annotated with a link to an archive.org snapshot.
+	LINKROT_HOURS   = "LINKROT_HOURS"
+	LINKROT_ARCHIVE = "LINKROT_ARCHIVE"
+
+	// WAYBACK_SAVE submits the external links in new public entries to the
+	// Wayback Machine so a snapshot exists if they later die.
+	WAYBACK_SAVE = "WAYBACK_SAVE"
+
+	// REPLY_DOMAIN is the domain of per-entry reply addresses, which should
+	// have a Mailgun inbound route that forwards to /email/inbound. If empty
+	// then replying by email is disabled.
+	REPLY_DOMAIN = "REPLY_DOMAIN"
+
+	// BRIDGE_CONTEXTS are where links to the BRIDGES are added to entries,
+	// any of "index", "permalink", and "feed", or "none". Bridges check the
+	// permalink for the link, so leaving it out stops syndication.
+	BRIDGE_CONTEXTS = "BRIDGE_CONTEXTS"
+
+	// BRIDGE_LINK_TEXT is an html/template for the text of bridge links, run
+	// with .URL and .Host, which defaults to empty anchors.
+	BRIDGE_LINK_TEXT = "BRIDGE_LINK_TEXT"
+
+	// RECEIVE_WEBMENTIONS has entries advertise and accept webmentions at
+	// /webmention instead of at webmention.bitworking.org.
+	RECEIVE_WEBMENTIONS = "RECEIVE_WEBMENTIONS"
+
+	// VOUCH_DOMAINS are domains whose webmentions skip moderation, along with
+	// the domains trusted from /admin/moderation. Webmentions from other
+	// domains also skip moderation if they come with a vouch from one of
+	// these domains, see https://indieweb.org/Vouch.
+	VOUCH_DOMAINS = "VOUCH_DOMAINS"
+
+	// UNDO_DELETE_MINUTES is how long a deleted entry can be restored before
+	// it is purged.
+	UNDO_DELETE_MINUTES = "UNDO_DELETE_MINUTES"
+
+	// AUTH is how admins sign in, one of:
+	//
+	//   "google" - Google sign-in with CLIENT_ID, the default.
+	//   "github" - GitHub OAuth with the app AUTH_GITHUB_CLIENT_ID, whose
+	//              secret is in $GITHUB_CLIENT_SECRET.
+	//   "basic"  - HTTP basic auth for the users in AUTH_BASIC_USERS, behind
+	//              a proxy that provides TLS.
+	//   "mtls"   - TLS client certificates signed by the CA in AUTH_CLIENT_CA.
+	//
+	// Except for "basic", ADMINS are the email addresses of the admins.
+	AUTH = "AUTH"
+
+	// AUTH_GITHUB_CLIENT_ID is the client id of the GitHub OAuth app, whose
+	// callback URL is HOST/auth/callback.
+	AUTH_GITHUB_CLIENT_ID = "AUTH_GITHUB_CLIENT_ID"
+
+	// AUTH_BASIC_USERS are htpasswd lines with bcrypt passwords, as written
+	// by "htpasswd -B".
+	AUTH_BASIC_USERS = "AUTH_BASIC_USERS"
+
+	// AUTH_CLIENT_CA is the PEM file of the CA that signs admin client
+	// certificates. Client certificates are only asked for over TLS, with
+	// AUTOCERT or on LISTENERS with a cert_file.
+	AUTH_CLIENT_CA = "AUTH_CLIENT_CA"
+
+	// SESSION_HOURS is how long an admin stays signed in.
+	SESSION_HOURS = "SESSION_HOURS"
+
+	// FEATURES maps feature names, see the features package, to false to
+	// turn them off. Features can also be turned on and off at
+	// /admin/features, which overrides this.
+	FEATURES = "FEATURES"
+
+	// REVERIFY_HOURS is how often received webmentions are checked again, in
+	// batches of REVERIFY_BATCH, and retracted if their source was deleted or
+	// no longer links to the entry. 0 turns checking off.
+	REVERIFY_HOURS = "REVERIFY_HOURS"
+
+	// BACKFEED_FEEDS are feeds of interactions, such as Bridgy or granary
+	// feeds, that are subscribed to on their WebSub hubs. Items pushed from
+	// them that link to entries are received as webmentions.
+	BACKFEED_FEEDS = "BACKFEED_FEEDS"
+
+	// SCHEDULE maps job names to the cron expressions they run on, in
+	// TIMEZONE, e.g. {"backup": "0 3 * * *"}. Jobs not listed run on their
+	// defaults, which come from settings such as BACKUP_HOURS. The jobs and
+	// their last runs are listed on /admin/jobs.
+	SCHEDULE = "SCHEDULE"
+
+	// TASKS_QUEUE is the name of a Cloud Tasks queue in PROJECT and REGION
+	// that sending webmentions, syndication, and push notifications are
+	// queued on, so they are retried instead of lost if the instance is
+	// shut down. If empty they are done in-process. Tasks are delivered to
+	// HOST/tasks/ with the secret in the TASKS_SECRET_ENV environment
+	// variable.
+	TASKS_QUEUE = "TASKS_QUEUE"
+
+	// LIMITS is a list of {"prefix", "max_bytes", "timeout_seconds"} that
+	// override the request body size and handler time limits for paths
+	// starting with prefix, where -1 means no limit. They are added to
+	// builtinLimits, and the longest matching prefix wins.
+	LIMITS = "LIMITS"
+
+	// MIRROR runs the instance as a read-only mirror, e.g. in another region
+	// for failover or to spread load. It reads from the same Datastore,
+	// which it only needs read access to, but serves just the public pages:
+	// signing in, the admin pages, and anything that changes state are
+	// refused, and the periodic jobs don't run. Its caches are cleared every
+	// MIRROR_REFRESH_MINUTES so that changes made on the primary show up.
+	MIRROR                 = "MIRROR"
+	MIRROR_REFRESH_MINUTES = "MIRROR_REFRESH_MINUTES"
+
+	// FEED_CONTENT is how much of each entry feeds include, by the route of
+	// the feed, e.g. {"/feed": "excerpt", "/tag/{tag}/feed": "summary"},
+	// where "default" applies to feeds that aren't listed. The values are
+	// FEED_FULL, the default, FEED_SUMMARY, and FEED_EXCERPT. Notes are
+	// always included in full.
+	FEED_CONTENT = "FEED_CONTENT"
+
+	// LINK_REL is {"rel", "domains", "follow", "target"}, the rel and target
+	// attributes added to external links in entries, see linkrel.Options.
+	// For example {"domains": {"*": ["nofollow"]}, "follow":
+	// ["bitworking.org"]} makes every external link nofollow except those to
+	// bitworking.org.
+	LINK_REL = "LINK_REL"
+
+	// ROBOTS is {"disallow", "agents", "block_ai", "no_headers"}, what
+	// robots.txt disallows on top of the robotsPrivate paths, and whether
+	// X-Robots-Tag headers are added, see robots.Options. For example
+	// {"block_ai": true} turns away the crawlers of AI companies.
+	ROBOTS = "ROBOTS"
+
+	// LOCK_AFTER_DAYS is how many days after they are created entries stop
+	// accepting webmentions and replies, or 0 to only lock entries by hand.
+	LOCK_AFTER_DAYS = "LOCK_AFTER_DAYS"
+
+	// SITES are more streams served by the same process, by name, e.g.
+	// {"photos": {"HOST": "https://photos.example.com",
+	// "DATASTORE_NAMESPACE": "photos", "ADMINS": [...], "TEMPLATES":
+	// "photos/templates"}}. Each is configured by the top level config with
+	// its block on top, and requests are routed to a stream by their Host
+	// header, see sites. Unless their block says otherwise, each stream has
+	// its own IMAGES, "sites/<name>/images", and BACKUP_PREFIX,
+	// "stream-backup-<name>/".
+	SITES = "SITES"
+
+	// TEMPLATES is the directory of templates that override the built in
+	// ones, either absolute or relative to resources_dir, and defaults to
+	// "templates".
+	TEMPLATES = "TEMPLATES"
+
+	// IMAGES is the directory of uploaded images and media, served at
+	// /images/, either absolute or relative to resources_dir, and defaults
+	// to "images".
+	IMAGES = "IMAGES"
+)
+
+// Feed content policies, see FEED_CONTENT.
+const (
+	// FEED_FULL includes the whole content.
+	FEED_FULL = "full"
+
+	// FEED_SUMMARY includes just the summary, or the excerpt if there isn't
+	// one, and readers have to follow the link for the rest.
+	FEED_SUMMARY = "summary"
+
+	// FEED_EXCERPT includes the excerpt as the content, followed by a "Read
+	// more" link to the permalink.
+	FEED_EXCERPT = "excerpt"
+)
+
+// PAGE_CACHE_SIZE is the number of rendered pages kept in memory.
+const PAGE_CACHE_SIZE = 200
+
+// Environment variables.
+const (
+	// SMTP_PASSWORD_ENV is the name of the environment variable that holds the
+	// SMTP password, kept out of config.json since that is checked in.
+	SMTP_PASSWORD_ENV = "SMTP_PASSWORD"
+
+	// VAPID_PRIVATE_KEY_ENV is the name of the environment variable that holds
+	// the VAPID private key used to sign Web Push requests.
+	VAPID_PRIVATE_KEY_ENV = "VAPID_PRIVATE_KEY"
+
+	// CAPABILITY_SECRET_ENV is the name of the environment variable that holds
+	// the key used to sign capability URLs for private entries.
+	CAPABILITY_SECRET_ENV = "CAPABILITY_SECRET"
+
+	// MASTODON_TOKEN_ENV is the name of the environment variable that holds an
+	// optional Mastodon access token used for backfeed.
+	MASTODON_TOKEN_ENV = "MASTODON_TOKEN"
+
+	// REPLY_SECRET_ENV is the name of the environment variable that holds the
+	// key used to sign reply addresses.
+	REPLY_SECRET_ENV = "REPLY_SECRET"
+
+	// MAILGUN_SIGNING_KEY_ENV is the name of the environment variable that
+	// holds the key Mailgun signs inbound mail webhooks with.
+	MAILGUN_SIGNING_KEY_ENV = "MAILGUN_SIGNING_KEY"
+
+	// GITHUB_CLIENT_SECRET_ENV is the name of the environment variable that
+	// holds the secret of the GitHub OAuth app used when AUTH is "github".
+	GITHUB_CLIENT_SECRET_ENV = "GITHUB_CLIENT_SECRET"
+
+	// SESSION_SECRET_ENV is the name of the environment variable that holds
+	// the key used to sign admin session cookies. It is required for GitHub
+	// sign in, and optional otherwise since sessions are also checked
+	// against Datastore.
+	SESSION_SECRET_ENV = "SESSION_SECRET"
+
+	// TASKS_SECRET_ENV is the name of the environment variable that holds
+	// the secret Cloud Tasks sends with each task, see TASKS_QUEUE.
+	TASKS_SECRET_ENV = "TASKS_SECRET"
 )
 
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
 // flags
 var (
 	local        = flag.Bool("local", false, "Running locally if true. As opposed to in production.")
-	resourcesDir = flag.String("resources_dir", "", "The directory to find templates, JS, and CSS files. If blank the current directory will be used.")
+	resourcesDir = flag.String("resources_dir", "", "The directory to find config.json, images, and templates that override the built in ones. If blank the source directory, or if that doesn't exist the current directory, will be used.")
+	restore      = flag.String("restore", "", "If set, restore the backup in the named newline delimited JSON file and exit.")
+
+	dryRunOutbound = flag.Bool("dry-run-outbound", false, "If true, webmentions, syndication, WebSub pings, push notifications, and archive.org saves are logged, and recorded in the outbox, instead of sent. For staging deployments that use production data.")
 )
 
-var (
-	entryDB *entries.Entries
+func (s *Server) permalinkFromId(id string) string {
+	return fmt.Sprintf("%s/entry/%s", s.config.GetString(HOST), id)
+}
 
-	templates *template.Template
+// listener is one address the server listens on, from the LISTENERS config.
+type listener struct {
+	// Network is "tcp", the default, or "unix".
+	Network string `mapstructure:"network"`
 
-	log = logger.New()
+	// Addr is a host:port for tcp, or a socket path for unix.
+	Addr string `mapstructure:"addr"`
 
-	ad *admin.Admin
-)
+	// NoAdmin hides the /admin and /debug pages, e.g. on a public port when
+	// another listener on localhost is used for administration.
+	NoAdmin bool `mapstructure:"no_admin"`
+
+	// CertFile and KeyFile, if set, serve HTTPS with the given PEM files.
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+}
+
+// httpServer returns an http.Server for h with timeouts, so slow clients
+// can't hold connections open indefinitely. Routes whose limits have no
+// timeout lift the read and write timeouts, see limits.Middleware.
+func (s *Server) httpServer(h http.Handler) *http.Server {
+	s.config.SetDefault(READ_TIMEOUT_SECONDS, 30)
+	s.config.SetDefault(WRITE_TIMEOUT_SECONDS, 60)
+	s.config.SetDefault(IDLE_TIMEOUT_SECONDS, 120)
+	seconds := func(key string) time.Duration {
+		return time.Duration(s.config.GetInt(key)) * time.Second
+	}
+	return &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       seconds(READ_TIMEOUT_SECONDS),
+		WriteTimeout:      seconds(WRITE_TIMEOUT_SECONDS),
+		IdleTimeout:       seconds(IDLE_TIMEOUT_SECONDS),
+	}
+}
+
+// noAdmin wraps h so that admin and debug pages aren't found.
+func noAdmin(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/admin") || strings.HasPrefix(r.URL.Path, "/debug/") || strings.HasPrefix(r.URL.Path, "/auth/") {
+			http.NotFound(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
 
-func permalinkFromId(id string) string {
-	return fmt.Sprintf("%s/entry/%s", viper.GetString(HOST), id)
+// mirrorRefused are the path prefixes a MIRROR refuses even for GET and
+// HEAD, since they sign in, change state, or are only for the admin.
+var mirrorRefused = []string{
+	"/admin",
+	"/auth/",
+	"/debug/",
+	"/logout",
+	"/feed/private",
+	"/api/entries",
+	"/api/mentions",
+	"/subscribe/confirm",
+	"/unsubscribe",
+	"/websub/callback/",
+	"/email/",
+	tasks.PREFIX,
 }
 
-func loadTemplates() {
-	pattern := filepath.Join(*resourcesDir, "templates", "*.*")
+// readOnly wraps h so that it only serves what a MIRROR serves. Everything
+// else fails with a 503, so clients and load balancers try the primary.
+func readOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// GraphQL queries are POST'd, but are still reads.
+		refused := r.Method != "GET" && r.Method != "HEAD" && !(r.URL.Path == "/graphql" && (r.Method == "POST" || r.Method == "OPTIONS"))
+		for _, prefix := range mirrorRefused {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				refused = true
+			}
+		}
+		if refused {
+			http.Error(w, "This is a read-only mirror.", http.StatusServiceUnavailable)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
 
-	templates = template.New("")
-	templates.Funcs(template.FuncMap{
-		"trunc": func(s string) string {
-			if len(s) > 80 {
-				return s[:80] + "..."
+// serve serves h on every listener in LISTENERS, or on $PORT if there are
+// none, and returns when any of them fails.
+func (s *Server) serve(h http.Handler) error {
+	var listeners []listener
+	if err := s.config.UnmarshalKey(LISTENERS, &listeners); err != nil {
+		return fmt.Errorf("Failed to parse %s: %s", LISTENERS, err)
+	}
+	if len(listeners) == 0 {
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = "1313"
+		}
+		listeners = []listener{{Addr: ":" + port}}
+	}
+	if s.config.GetBool(H2C) {
+		// Cleartext HTTP/2, for proxies such as Cloud Run that speak it to
+		// the backend. HTTP/2 over TLS is always available.
+		h = h2c.NewHandler(h, &http2.Server{})
+	}
+	errCh := make(chan error, len(listeners))
+	for _, l := range listeners {
+		if l.Network == "" {
+			l.Network = "tcp"
+		}
+		if l.Network == "unix" {
+			// Remove a socket left behind by a previous run.
+			if err := os.Remove(l.Addr); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("Failed to remove old socket %q: %s", l.Addr, err)
+			}
+		}
+		ln, err := net.Listen(l.Network, l.Addr)
+		if err != nil {
+			return fmt.Errorf("Failed to listen on %s %q: %s", l.Network, l.Addr, err)
+		}
+		handler := h
+		if l.NoAdmin {
+			handler = noAdmin(h)
+		}
+		s.log.Infof("Listening on %s %s", l.Network, l.Addr)
+		if l.CertFile != "" {
+			server := s.httpServer(handler)
+			server.TLSConfig, err = s.clientCertTLS(&tls.Config{})
+			if err != nil {
+				return err
 			}
-			return s
+			go func(ln net.Listener, l listener) {
+				errCh <- server.ServeTLS(ln, l.CertFile, l.KeyFile)
+			}(ln, l)
+			continue
+		}
+		go func(ln net.Listener, handler http.Handler) {
+			errCh <- s.httpServer(handler).Serve(ln)
+		}(ln, handler)
+	}
+	return <-errCh
+}
+
+// serveAutocert serves h over HTTPS on port 443 using certificates obtained
+// automatically from Let's Encrypt for hosts, the domains in HOST and SITES.
+// Port 80 answers ACME challenges and redirects everything else to HTTPS.
+func (s *Server) serveAutocert(h http.Handler, hosts []string) error {
+	s.config.SetDefault(AUTOCERT_CACHE_DIR, filepath.Join(*resourcesDir, "autocert"))
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(hosts...),
+		Cache:      autocert.DirCache(s.config.GetString(AUTOCERT_CACHE_DIR)),
+		Email:      s.config.GetString(AUTOCERT_EMAIL),
+	}
+	go func() {
+		s.log.Fatal(http.ListenAndServe(":80", m.HTTPHandler(nil)))
+	}()
+	server := s.httpServer(h)
+	server.Addr = ":443"
+	var err error
+	server.TLSConfig, err = s.clientCertTLS(m.TLSConfig())
+	if err != nil {
+		return err
+	}
+	s.log.Infof("Serving HTTPS for %s", strings.Join(hosts, ", "))
+	return server.ListenAndServeTLS("", "")
+}
+
+// clientCertTLS adds verification of client certificates, which are
+// optional, against AUTH_CLIENT_CA to cfg when AUTH is "mtls".
+func (s *Server) clientCertTLS(cfg *tls.Config) (*tls.Config, error) {
+	if s.config.GetString(AUTH) != "mtls" {
+		return cfg, nil
+	}
+	b, err := ioutil.ReadFile(s.config.GetString(AUTH_CLIENT_CA))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s: %s", AUTH_CLIENT_CA, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("No certificates found in %s.", AUTH_CLIENT_CA)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	return cfg, nil
+}
+
+// newAuthenticator returns the Authenticator for AUTH.
+func (s *Server) newAuthenticator() (auth.Authenticator, error) {
+	switch s.config.GetString(AUTH) {
+	case "github":
+		return auth.NewGitHub(s.config.GetString(AUTH_GITHUB_CLIENT_ID), os.Getenv(GITHUB_CLIENT_SECRET_ENV), s.config.GetString(HOST), s.config.GetStringSlice(ADMINS), os.Getenv(SESSION_SECRET_ENV), &http.Client{Timeout: 30 * time.Second})
+	case "basic":
+		return auth.NewBasic(s.config.GetStringSlice(AUTH_BASIC_USERS))
+	case "mtls":
+		return auth.NewClientCert(s.config.GetStringSlice(ADMINS)), nil
+	default:
+		return admin.New(s.config.GetString(CLIENT_ID), s.config.GetStringSlice(ADMINS)), nil
+	}
+}
+
+// validateConfig checks the config for problems that would otherwise only
+// show up at request time, returning an error that lists all of them.
+func (s *Server) validateConfig() error {
+	c := &configcheck.Checker{}
+	c.Required(PROJECT, s.config.GetString(PROJECT))
+	c.Required(DATASTORE_NAMESPACE, s.config.GetString(DATASTORE_NAMESPACE))
+	c.Required(AUTHOR, s.config.GetString(AUTHOR))
+	c.URL(HOST, s.config.GetString(HOST))
+	c.URL(WEBSUB, s.config.GetString(WEBSUB))
+	switch s.config.GetString(AUTH) {
+	case "google":
+		c.Required(CLIENT_ID, s.config.GetString(CLIENT_ID))
+		c.NonEmpty(ADMINS, s.config.GetStringSlice(ADMINS))
+	case "github":
+		c.Required(AUTH_GITHUB_CLIENT_ID, s.config.GetString(AUTH_GITHUB_CLIENT_ID))
+		c.NonEmpty(ADMINS, s.config.GetStringSlice(ADMINS))
+	case "basic":
+		_, err := auth.NewBasic(s.config.GetStringSlice(AUTH_BASIC_USERS))
+		c.Valid(AUTH_BASIC_USERS, err)
+	case "mtls":
+		c.Required(AUTH_CLIENT_CA, s.config.GetString(AUTH_CLIENT_CA))
+		c.NonEmpty(ADMINS, s.config.GetStringSlice(ADMINS))
+	default:
+		c.Valid(AUTH, fmt.Errorf("must be one of google, github, basic, or mtls"))
+	}
+	c.URLs(BRIDGES, s.config.GetStringSlice(BRIDGES))
+	c.OptionalURL(FEDSOC_BRIDGE, s.config.GetString(FEDSOC_BRIDGE))
+	c.Paths(BRIDGE_PATHS, s.config.GetStringSlice(BRIDGE_PATHS))
+	c.URLs(BACKFEED_FEEDS, s.config.GetStringSlice(BACKFEED_FEEDS))
+	c.Location(TIMEZONE, s.config.GetString(TIMEZONE))
+	if s.config.GetString(TASKS_QUEUE) != "" {
+		c.Required(REGION, s.config.GetString(REGION))
+		c.Required(TASKS_SECRET_ENV, os.Getenv(TASKS_SECRET_ENV))
+	}
+	_, err := bridges.New(s.config.GetStringSlice(BRIDGE_CONTEXTS), s.config.GetString(BRIDGE_LINK_TEXT))
+	c.Valid(BRIDGE_CONTEXTS, err)
+	schedule := s.config.GetStringMapString(SCHEDULE)
+	names := make([]string, 0, len(schedule))
+	for name := range schedule {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		_, err := scheduler.Parse(schedule[name])
+		c.Valid(SCHEDULE+"."+name, err)
+	}
+	_, err = s.requestLimits()
+	c.Valid(LIMITS, err)
+	_, err = s.newLinkRewriter()
+	c.Valid(LINK_REL, err)
+	_, err = s.newRobots()
+	c.Valid(ROBOTS, err)
+	for feed, policy := range s.config.GetStringMapString(FEED_CONTENT) {
+		if policy != FEED_FULL && policy != FEED_SUMMARY && policy != FEED_EXCERPT {
+			c.Valid(FEED_CONTENT+"."+feed, fmt.Errorf("must be one of %s, %s, or %s", FEED_FULL, FEED_SUMMARY, FEED_EXCERPT))
+		}
+	}
+	return c.Err()
+}
+
+// builtinLimits are the request limits of the routes that need more than the
+// defaults.
+var builtinLimits = []limits.Rule{
+	// Uploaded backups and archives can be large and slow to process.
+	{Prefix: "/admin/restore", MaxBytes: 256 << 20, TimeoutSeconds: limits.NONE},
+	{Prefix: "/admin/import", MaxBytes: 256 << 20, TimeoutSeconds: limits.NONE},
+
+	// Exports are streamed.
+	{Prefix: "/admin/backup.json", TimeoutSeconds: limits.NONE},
+	{Prefix: "/admin/export/", TimeoutSeconds: limits.NONE},
+
+	// Audio and video uploads.
+	{Prefix: "/admin/media", MaxBytes: 1 << 30, TimeoutSeconds: limits.NONE},
+
+	// Cloud Tasks enforces its own deadline.
+	{Prefix: tasks.PREFIX, TimeoutSeconds: limits.NONE},
+
+	// CPU profiles and traces run for as long as the seconds parameter asks.
+	{Prefix: "/debug/pprof/", TimeoutSeconds: limits.NONE},
+}
+
+// requestLimits returns the builtinLimits overridden by LIMITS.
+func (s *Server) requestLimits() (*limits.Limits, error) {
+	var rules []limits.Rule
+	if err := s.config.UnmarshalKey(LIMITS, &rules); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %s", LIMITS, err)
+	}
+	return limits.New(limits.Rule{}, append(append([]limits.Rule{}, builtinLimits...), rules...))
+}
+
+// displayLocation returns the TIMEZONE that times are displayed in,
+// defaulting to UTC.
+func (s *Server) displayLocation() *time.Location {
+	name := s.config.GetString(TIMEZONE)
+	if name == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		s.log.Warningf("Unknown %s %q: %s", TIMEZONE, name, err)
+		return time.UTC
+	}
+	return loc
+}
+
+// embeddedTemplates are built into the binary, so it can run without a
+// resources_dir.
+//
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// resourceFile reads the file at name, relative to resources_dir, falling
+// back to the embedded templates.
+func resourceFile(name string) ([]byte, error) {
+	b, err := ioutil.ReadFile(filepath.Join(*resourcesDir, filepath.FromSlash(name)))
+	if err == nil {
+		return b, nil
+	}
+	return embeddedTemplates.ReadFile(name)
+}
+
+// loadTemplates parses the embedded templates, and then any in the TEMPLATES
+// directory under resources_dir, which replace the embedded ones with the
+// same name. That's how templates are edited locally, and how a deployment
+// can be themed without a rebuild.
+func (s *Server) loadTemplates() {
+	s.templates = template.New("")
+	s.templates.Funcs(templatefuncs.New(templatefuncs.Options{
+		Location:   s.displayLocation(),
+		DateFormat: s.config.GetString(DATE_FORMAT),
+		Locale:     s.config.GetString(LOCALE),
+	}))
+	s.templates.Funcs(template.FuncMap{
+		"excerpt": func(html template.HTML) string {
+			return excerpt(string(html), EXCERPT_LENGTH)
 		},
-		"humanTime": func(t time.Time) string {
-			if t.IsZero() {
+		// srcset returns a srcset attribute value of resized renditions of an
+		// image src, or "" if the image isn't served from /images/.
+		"srcset": func(src string) string {
+			if !strings.HasPrefix(src, "/images/") {
 				return ""
 			}
-			return units.HumanDuration(time.Now().Sub(t)) + " ago"
+			ret := []string{}
+			for _, width := range s.config.GetIntSlice(IMAGE_WIDTHS) {
+				ret = append(ret, fmt.Sprintf("/img/%d/%s %dw", width, strings.TrimPrefix(src, "/images/"), width))
+			}
+			return strings.Join(ret, ", ")
+		},
+		// jsonld returns the structured data of an entry, for a
+		// <script type="application/ld+json"> element.
+		"jsonld": func(c *entryContent) (template.JS, error) {
+			return jsonld.Script(s.structuredPosting(c))
+		},
+		// jsonldBlog is jsonld for a page of entries.
+		"jsonldBlog": func(cooked []*entryContent) (template.JS, error) {
+			return jsonld.Script(s.structuredBlog(cooked))
+		},
+		// duration formats a length in seconds as "m:ss" or "h:mm:ss".
+		"duration": func(seconds int64) string {
+			return media.FormatDuration(time.Duration(seconds) * time.Second)
 		},
-		"atomTime": func(t time.Time) string {
-			return t.Format(time.RFC3339)
+		// assetURL returns the hashed path of a file in the assets package,
+		// e.g. {{assetURL "stream.css"}}.
+		"assetURL": func(name string) string {
+			return s.assetDB.URL(name)
+		},
+		// bust appends the version of a file under /images/ to its path, so
+		// it can be cached forever.
+		"bust": func(path string) string {
+			if !strings.HasPrefix(path, "/images/") {
+				return path
+			}
+			return s.imageVersions.URL("/images/", path)
 		},
 	})
-	template.Must(templates.ParseGlob(pattern))
+	template.Must(s.templates.ParseFS(embeddedTemplates, "templates/*.*"))
+	dir := s.config.GetString(TEMPLATES)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(*resourcesDir, dir)
+	}
+	overrides, err := filepath.Glob(filepath.Join(dir, "*.*"))
+	if err != nil {
+		s.log.Fatal(err)
+	}
+	if len(overrides) > 0 {
+		template.Must(s.templates.ParseFiles(overrides...))
+	}
+	localized := map[string]*template.Template{}
+	for _, locale := range templatefuncs.Locales() {
+		t := template.Must(s.templates.Clone())
+		t.Funcs(templatefuncs.New(templatefuncs.Options{
+			Location:   s.displayLocation(),
+			DateFormat: s.config.GetString(DATE_FORMAT),
+			Locale:     locale,
+		}))
+		localized[locale] = t
+	}
+	s.localizedTemplates = localized
+}
+
+// localeFor returns the language of reader-facing pages for r, which is
+// LOCALE if it's set, otherwise negotiated from Accept-Language.
+func (s *Server) localeFor(r *http.Request) string {
+	if locale := s.config.GetString(LOCALE); locale != "" {
+		return locale
+	}
+	return templatefuncs.Negotiate(r.Header.Get("Accept-Language"))
+}
+
+// templatesFor returns the templates in the language of localeFor(r).
+func (s *Server) templatesFor(w http.ResponseWriter, r *http.Request) *template.Template {
+	if s.config.GetString(LOCALE) == "" {
+		w.Header().Add("Vary", "Accept-Language")
+	}
+	if t, ok := s.localizedTemplates[s.localeFor(r)]; ok {
+		return t
+	}
+	return s.templates
 }
 
-func initialize() {
+// loadConfig parses the flags and reads config.json from the resources
+// directory.
+func loadConfig(log slog.Logger) *viper.Viper {
 	flag.Parse()
-	viper.SetConfigType("json")
 	if *resourcesDir == "" {
+		// The source directory, when running with go run, otherwise the
+		// current directory.
 		_, filename, _, _ := runtime.Caller(0)
 		*resourcesDir = filepath.Join(filepath.Dir(filename))
+		if _, err := os.Stat(*resourcesDir); err != nil {
+			*resourcesDir = "."
+		}
 	}
 
+	config := viper.New()
+	config.SetConfigType("json")
 	f, err := os.Open(filepath.Join(*resourcesDir, "config.json"))
 	if err != nil {
-		log.Fatal(err)
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if err := config.ReadConfig(f); err != nil {
+		log.Fatal(err)
+	}
+
+	config.AddConfigPath(*resourcesDir)
+	if err := config.ReadInConfig(); err != nil {
+		log.Fatal(err)
+	}
+	config.SetDefault(AUTH, "google")
+	config.SetDefault(IMAGES, "images")
+	return config
+}
+
+// openServer returns the Server for config, connected to the Datastore and
+// the other services it configures. The config is watched for changes.
+func openServer(config *viper.Viper, log slog.Logger) (*Server, error) {
+	s := &Server{
+		config: config,
+		log:    log,
+	}
+	if err := s.validateConfig(); err != nil {
+		return nil, err
+	}
+	var err error
+	s.ad, err = s.newAuthenticator()
+	if err != nil {
+		return nil, err
+	}
+
+	s.notify = notifier.NewNop(s.log)
+	if s.config.GetString(SMTP_HOST) != "" {
+		s.config.SetDefault(SMTP_PORT, 587)
+		to := s.config.GetStringSlice(NOTIFY_TO)
+		if len(to) == 0 {
+			to = s.config.GetStringSlice(ADMINS)
+		}
+		mailer, err := notifier.NewSMTP(s.config.GetString(SMTP_HOST), s.config.GetInt(SMTP_PORT), s.config.GetString(SMTP_USER), os.Getenv(SMTP_PASSWORD_ENV), s.config.GetString(NOTIFY_FROM), to)
+		if err != nil {
+			return nil, err
+		}
+		s.mailer = mailer
+		s.notify = mailer
+
+		s.subscriberDB, err = subscribers.New(context.Background(), s.config.GetString(PROJECT), s.config.GetString(DATASTORE_NAMESPACE), s.log)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if s.config.GetString(VAPID_PUBLIC_KEY) != "" {
+		s.pushDB, err = push.New(context.Background(), s.config.GetString(PROJECT), s.config.GetString(DATASTORE_NAMESPACE), s.config.GetString(VAPID_PUBLIC_KEY), os.Getenv(VAPID_PRIVATE_KEY_ENV), s.config.GetString(VAPID_SUBSCRIBER), safefetch.New(30*time.Second), s.log)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.tokenDB, err = tokens.New(context.Background(), s.config.GetString(PROJECT), s.config.GetString(DATASTORE_NAMESPACE), s.log)
+	if err != nil {
+		return nil, err
+	}
+
+	s.featureDB, err = features.New(context.Background(), s.config.GetString(PROJECT), s.config.GetString(DATASTORE_NAMESPACE), s.featureConfigured, s.log)
+	if err != nil {
+		return nil, err
+	}
+
+	s.config.SetDefault(SESSION_HOURS, 30*24)
+	s.sessionDB, err = sessions.New(context.Background(), s.config.GetString(PROJECT), s.config.GetString(DATASTORE_NAMESPACE), os.Getenv(SESSION_SECRET_ENV), time.Duration(s.config.GetInt(SESSION_HOURS))*time.Hour, s.log)
+	if err != nil {
+		return nil, err
+	}
+
+	s.websubDB, err = websub.New(context.Background(), s.config.GetString(PROJECT), s.config.GetString(DATASTORE_NAMESPACE), s.config.GetString(HOST)+"/websub/callback/", safefetch.New(30*time.Second), s.log)
+	if err != nil {
+		return nil, err
+	}
+
+	s.endpointDB, err = endpoints.New(context.Background(), s.config.GetString(PROJECT), s.config.GetString(DATASTORE_NAMESPACE), discoverEndpoint, s.log)
+	if err != nil {
+		return nil, err
+	}
+
+	s.replyDB, err = replycontext.New(context.Background(), s.config.GetString(PROJECT), s.config.GetString(DATASTORE_NAMESPACE), safefetch.New(30*time.Second), s.log)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mentionDB, err = mentions.New(context.Background(), s.config.GetString(PROJECT), s.config.GetString(DATASTORE_NAMESPACE), s.log)
+	if err != nil {
+		return nil, err
+	}
+
+	s.outboxDB, err = outbox.New(context.Background(), s.config.GetString(PROJECT), s.config.GetString(DATASTORE_NAMESPACE), s.log)
+	if err != nil {
+		return nil, err
+	}
+	s.snippetDB, err = snippets.New(context.Background(), s.config.GetString(PROJECT), s.config.GetString(DATASTORE_NAMESPACE), s.log)
+	if err != nil {
+		return nil, err
+	}
+	hostURL, err := url.Parse(s.config.GetString(HOST))
+	if err != nil {
+		return nil, err
+	}
+	s.referrerDB, err = referrers.New(context.Background(), s.config.GetString(PROJECT), s.config.GetString(DATASTORE_NAMESPACE), hostURL.Hostname(), s.log)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mediaDB, err = media.New(context.Background(), s.config.GetString(PROJECT), s.config.GetString(DATASTORE_NAMESPACE), s.imagesDir(), s.log)
+	if err != nil {
+		return nil, err
+	}
+
+	s.shortDB, err = shorturl.New(context.Background(), s.config.GetString(PROJECT), s.config.GetString(DATASTORE_NAMESPACE), s.log)
+	if err != nil {
+		return nil, err
+	}
+
+	s.linkDB, err = linkrot.New(context.Background(), s.config.GetString(PROJECT), s.config.GetString(DATASTORE_NAMESPACE), s.log)
+	if err != nil {
+		return nil, err
+	}
+
+	s.blockDB, err = blocklist.New(context.Background(), s.config.GetString(PROJECT), s.config.GetString(DATASTORE_NAMESPACE), s.config.GetStringSlice(BLOCKLIST), s.log)
+	if err != nil {
+		return nil, err
+	}
+
+	s.trustDB, err = trusted.New(context.Background(), s.config.GetString(PROJECT), s.config.GetString(DATASTORE_NAMESPACE), s.config.GetStringSlice(VOUCH_DOMAINS), s.log)
+	if err != nil {
+		return nil, err
+	}
+
+	s.entryDB, err = entries.New(context.Background(), s.config.GetString(PROJECT), s.config.GetString(DATASTORE_NAMESPACE), s.log)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.setup(); err != nil {
+		return nil, err
+	}
+	if name := s.config.GetString(TASKS_QUEUE); name != "" {
+		queue, err := tasks.NewCloudTasks(context.Background(), tasks.QueueName(s.config.GetString(PROJECT), s.config.GetString(REGION), name), s.config.GetString(HOST), os.Getenv(TASKS_SECRET_ENV))
+		if err != nil {
+			return nil, err
+		}
+		s.taskQueue.SetQueue(queue, os.Getenv(TASKS_SECRET_ENV))
+	}
+	// SITES are configured from the top level config.json, so only the top
+	// level stream watches it, and changes to SITES need a restart.
+	if s.config.ConfigFileUsed() != "" {
+		s.config.OnConfigChange(func(e fsnotify.Event) {
+			s.log.Infof("Config changed: %s", e.Name)
+			s.loadRedirects()
+			s.loadMarkdownOptions()
+			s.loadBridgeRules()
+			s.loadLinkRel()
+			s.loadRobots()
+			if err := s.blockDB.SetConfig(context.Background(), s.config.GetStringSlice(BLOCKLIST)); err != nil {
+				s.log.Warningf("Failed to reload blocklist: %s", err)
+			}
+			if err := s.trustDB.SetConfig(context.Background(), s.config.GetStringSlice(VOUCH_DOMAINS)); err != nil {
+				s.log.Warningf("Failed to reload trusted domains: %s", err)
+			}
+		})
+		s.config.WatchConfig()
+	}
+	s.log.Info("Initialized.")
+	return s, nil
+}
+
+// siteConfig returns the config of the stream name in SITES, which is config
+// with the stream's block on top.
+func siteConfig(config *viper.Viper, name string) (*viper.Viper, error) {
+	settings := config.AllSettings()
+	delete(settings, strings.ToLower(SITES))
+	site := viper.New()
+	if err := site.MergeConfigMap(settings); err != nil {
+		return nil, fmt.Errorf("Failed to copy config for site %q: %s", name, err)
+	}
+	block := config.GetStringMap(SITES + "." + name)
+	if err := site.MergeConfigMap(block); err != nil {
+		return nil, fmt.Errorf("Failed to read %s.%s: %s", SITES, name, err)
+	}
+	// Sites don't share media or backups, since deleting media or pruning
+	// backups in one would remove another's.
+	if _, ok := block[strings.ToLower(IMAGES)]; !ok {
+		site.Set(IMAGES, filepath.Join("sites", name, "images"))
+	}
+	if _, ok := block[strings.ToLower(BACKUP_PREFIX)]; !ok {
+		site.Set(BACKUP_PREFIX, "stream-backup-"+name+"/")
+	}
+	return site, nil
+}
+
+// openSites returns the Servers of the SITES in config, in order of their
+// names.
+func openSites(config *viper.Viper, log slog.Logger) ([]*Server, error) {
+	names := []string{}
+	for name := range config.GetStringMap(SITES) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ret := []*Server{}
+	for _, name := range names {
+		site, err := siteConfig(config, name)
+		if err != nil {
+			return nil, err
+		}
+		s, err := openServer(site, log)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open site %q: %s", name, err)
+		}
+		ret = append(ret, s)
+	}
+	return ret, nil
+}
+
+type adminContext struct {
+	IsAdmin bool
+	Entries []*entryContent
+	Offset  int
+	Config  map[string]interface{}
+	Form    map[string]string
+
+	// Warnings are accessibility problems that stopped an entry from being
+	// published.
+	Warnings []string
+
+	// Snippets can be inserted into the new entry form.
+	Snippets []*snippets.Snippet
+
+	// Duplicates are the entries that already link to the page being
+	// shared, if any, which might be better edited than posted about again.
+	Duplicates []*entryContent
+
+	Flash *flash
+}
+
+// FLASH_COOKIE holds the flash for the next admin page.
+const FLASH_COOKIE = "flash"
+
+// flash is a message displayed once on the admin page after a redirect.
+type flash struct {
+	Message string `json:"message"`
+
+	// UndoID is the id of a deleted entry that can be restored.
+	UndoID string `json:"undo_id,omitempty"`
+}
+
+// setFlash stores the flash to be displayed by the next admin page.
+func (s *Server) setFlash(w http.ResponseWriter, f *flash) {
+	b, err := json.Marshal(f)
+	if err != nil {
+		s.log.Warningf("Failed to encode flash: %s", err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     FLASH_COOKIE,
+		Value:    base64.RawURLEncoding.EncodeToString(b),
+		Path:     "/admin",
+		MaxAge:   300,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// takeFlash returns the flash set by a previous request, if any, and clears
+// it.
+func takeFlash(w http.ResponseWriter, r *http.Request) *flash {
+	c, err := r.Cookie(FLASH_COOKIE)
+	if err != nil {
+		return nil
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:   FLASH_COOKIE,
+		Path:   "/admin",
+		MaxAge: -1,
+	})
+	b, err := base64.RawURLEncoding.DecodeString(c.Value)
+	if err != nil {
+		return nil
+	}
+	var ret flash
+	if err := json.Unmarshal(b, &ret); err != nil {
+		return nil
+	}
+	return &ret
+}
+
+type entryContent struct {
+	Title       string
+	Content     template.HTML
+	SafeContent string
+	ID          string
+	Created     time.Time
+	Updated     time.Time
+	Visibility  entries.Visibility
+	Summary     string
+
+	// InReplyTo is the URL this entry replies to, if any.
+	InReplyTo string
+
+	// Photos are the src URLs of the images in the content.
+	Photos []string
+
+	Kind      entries.Kind
+	Latitude  float64
+	Longitude float64
+	Venue     string
+
+	// MapURL is an OpenStreetMap embed URL for checkins.
+	MapURL string
+
+	// Link is the page a bookmark is about, which its title links to, or ""
+	// for other kinds of entries.
+	Link string
+
+	// MediaURL is the file played by audio and video entries, or "" for
+	// other kinds of entries. MediaType, MediaLength, Duration, and Poster
+	// are as in entries.Entry.
+	MediaURL    string
+	MediaType   string
+	MediaLength int64
+	Duration    int64
+	Poster      string
+
+	// ReplyContext is only filled in by addReplyContext.
+	ReplyContext *replycontext.Context
+
+	WordCount int
+
+	// ReadingTime is the estimated reading time in minutes.
+	ReadingTime int
+
+	// Excerpt is the plain text of the first paragraph, shortened to at most
+	// EXCERPT_LENGTH characters.
+	Excerpt string
+
+	// IsNote is true for entries without a title, which are displayed as
+	// notes rather than articles.
+	IsNote bool
+
+	// DisplayTitle is the Title, or for notes a title generated from the
+	// excerpt, for places that always need one like feeds and <title>.
+	DisplayTitle string
+
+	// NoBridges is true if links to the BRIDGES aren't added.
+	NoBridges bool
+
+	// Locked is true if the entry no longer accepts webmentions or replies.
+	Locked bool
+
+	// ParentID is the ID of the entry this one continues in a thread.
+	ParentID string
+
+	// Likes, Reposts, and Replies are the interaction counts of the entry.
+	Likes   int
+	Reposts int
+	Replies int
+}
+
+func parseWithDefault(s string, defaultValue int) int {
+	// "" will parse as an error.
+	ret, err := strconv.ParseInt(s, 10, 32)
+	if err != nil {
+		return defaultValue
+	}
+	return int(ret)
+}
+
+// shareTargetToMap pre-populates the new entry form from a share. The
+// returned map has values for 'title' and 'content', and 'url' if a page was
+// shared. See the sharetarget package for how sites such as Twitter and
+// YouTube are handled.
+func (s *Server) shareTargetToMap(form url.Values) map[string]string {
+	result := s.shareExtractor.Extract(sharetarget.Share{
+		Title: form.Get("title"),
+		Text:  form.Get("text"),
+		URL:   form.Get("url"),
+	})
+	ret := map[string]string{
+		"title":   result.Title,
+		"content": result.Content,
+		"parent":  form.Get("parent"),
+	}
+	if result.URL != "" {
+		ret["url"] = result.URL
+	}
+	return ret
+}
+
+// fetchDocument fetches and parses the HTML page at u.
+func fetchDocument(u string) (*goquery.Document, error) {
+	resp, err := safefetch.New(30 * time.Second).Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Failed to fetch %q: %s", u, resp.Status)
+	}
+	return goquery.NewDocumentFromReader(resp.Body)
+}
+
+// adminHandler displays the admin page for Stream.
+//
+// They query parameters 'title', 'text', and 'url' may be supplied by a Web
+// Share Target call and should pre-populate the form for creating a new
+// entry.
+func (s *Server) adminHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form values.", 400)
+		return
+	}
+	s.renderAdmin(w, r, s.shareTargetToMap(r.Form))
+}
+
+// renderAdmin displays the admin page with the new entry form pre-populated
+// from form.
+func (s *Server) renderAdmin(w http.ResponseWriter, r *http.Request, form map[string]string) {
+	w.Header().Set("Content-Type", "text/html")
+	signedIn := s.isAdmin(r)
+	context := &adminContext{
+		IsAdmin: signedIn,
+		Config:  s.config.AllSettings(),
+		Form:    form,
+	}
+	s.log.Infof("Form: %#v", context.Form)
+	if signedIn {
+		context.Flash = takeFlash(w, r)
+		if name := r.FormValue("snippet"); name != "" {
+			if snippet, err := s.snippetDB.Get(r.Context(), name); err != nil {
+				s.log.Warningf("Failed to get snippet: %s", err)
+			} else {
+				values := snippets.Values(time.Now().In(s.displayLocation()), r.FormValue("url"), context.Form["title"])
+				context.Form["title"] = snippets.Expand(snippet.Title, values)
+				context.Form["content"] = snippets.Expand(snippet.Content, values)
+			}
+		}
+		var err error
+		context.Snippets, err = s.snippetDB.List(r.Context())
+		if err != nil {
+			s.log.Warningf("Failed to get snippets: %s", err)
+		}
+		if u := form["url"]; u != "" {
+			found, err := s.entryDB.FindByURL(r.Context(), u)
+			if err != nil {
+				s.log.Warningf("Failed to look for entries about %q: %s", u, err)
+			}
+			context.Duplicates = s.toDisplaySlice(found)
+		}
+		limit := parseWithDefault(r.FormValue("limit"), 20)
+		offset := parseWithDefault(r.FormValue("offset"), 0)
+		entries, err := s.entryDB.List(r.Context(), int(limit), int(offset))
+		if err != nil {
+			s.log.Warningf("Failed to get entries: %s", err)
+			return
+		}
+		context.Entries = s.toDisplaySlice(entries)
+		context.Offset = int(offset + limit)
+		if len(entries) < limit {
+			context.Offset = -1
+		}
+	}
+	s.render(w, r, s.templates, "admin.html", context)
+}
+
+// bookmarkletContent returns the Markdown that starts an entry about the
+// page at u, which is a reply to it unless as is "bookmark", followed by the
+// selected text quoted. Bookmarks link to the page from their Link instead of
+// their content.
+func bookmarkletContent(u, name, selection, as string) string {
+	if name == "" {
+		name = u
+	}
+	ret := ""
+	if as != "bookmark" {
+		ret = fmt.Sprintf("<a class='u-in-reply-to' href='%s'>%s</a>\n", html.EscapeString(u), html.EscapeString(name))
+	}
+	if selection = strings.TrimSpace(selection); selection != "" {
+		if ret != "" {
+			ret += "\n"
+		}
+		ret += "> " + strings.Join(strings.Split(selection, "\n"), "\n> ") + "\n"
+	}
+	return ret
+}
+
+// adminPostAboutHandler is where the bookmarklet sends the 'url', 'title',
+// and selected text, as 'selection', of the page being viewed. The page is
+// unfurled through the reply context cache and the admin page is displayed
+// with the new entry form pre-populated as a reply to, or with 'as=bookmark'
+// a bookmark of, the page.
+func (s *Server) adminPostAboutHandler(w http.ResponseWriter, r *http.Request) {
+	form := map[string]string{}
+	u := r.FormValue("url")
+	if s.isAdmin(r) && u != "" {
+		if _, err := receiver.ValidURL(u); err != nil {
+			http.Error(w, "Invalid url.", 400)
+			return
+		}
+		name := r.FormValue("title")
+		rc, err := s.replyDB.Refresh(r.Context(), u)
+		if err != nil {
+			s.log.Infof("Failed to unfurl %q: %s", u, err)
+		} else if rc.Name != "" {
+			name = rc.Name
+		}
+		form["title"] = name
+		form["url"] = u
+		form["content"] = bookmarkletContent(u, name, r.FormValue("selection"), r.FormValue("as"))
+		if r.FormValue("as") == "bookmark" {
+			form["kind"] = string(entries.BOOKMARK)
+			form["link"] = u
+		}
+	}
+	s.renderAdmin(w, r, form)
+}
+
+type bookmarkletContext struct {
+	Config map[string]interface{}
+
+	// Reply and Bookmark are the bookmarklets for replying to and
+	// bookmarking the page being viewed.
+	Reply    template.URL
+	Bookmark template.URL
+}
+
+// bookmarklet returns the javascript: URL that sends the page being viewed
+// to adminPostAboutHandler.
+func (s *Server) bookmarklet(as string) template.URL {
+	target := s.config.GetString(HOST) + "/admin/post?as=" + as
+	return template.URL(fmt.Sprintf("javascript:(function(){window.open(%q+'&url='+encodeURIComponent(location.href)+'&title='+encodeURIComponent(document.title)+'&selection='+encodeURIComponent(String(window.getSelection())))})()", target))
+}
+
+// adminBookmarkletHandler displays the bookmarklets, a desktop counterpart to
+// the Web Share Target.
+func (s *Server) adminBookmarkletHandler(w http.ResponseWriter, r *http.Request) {
+	if *local {
+		s.loadTemplates()
+	}
+	if !s.isAdmin(r) {
+		http.Error(w, "Unauthorized", 401)
+		return
+	}
+	c := &bookmarkletContext{
+		Config:   s.config.AllSettings(),
+		Reply:    s.bookmarklet("reply"),
+		Bookmark: s.bookmarklet("bookmark"),
+	}
+	w.Header().Set("Content-Type", "text/html")
+	s.render(w, r, s.templates, "adminBookmarklet.html", c)
+}
+
+type indexContext struct {
+	Config  map[string]interface{}
+	Entries []*entryContent
+	Offset  int
+}
+
+// indexHandler displays the admin page for Stream.
+func (s *Server) indexHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	limit := parseWithDefault(r.FormValue("limit"), 20)
+	offset := parseWithDefault(r.FormValue("offset"), 0)
+	entries, err := s.entryDB.ListPublic(r.Context(), int(limit), int(offset))
+	if err != nil {
+		s.serveDegraded(w, r, err)
+		return
+	}
+	s.log.Infof("%#v\n", s.config.AllSettings())
+	cooked := s.toDisplaySlice(entries)
+	s.addBridges(cooked, bridges.INDEX)
+	context := &indexContext{
+		Config:  s.config.AllSettings(),
+		Entries: cooked,
+		Offset:  int(offset + limit),
+	}
+	if len(entries) < limit {
+		context.Offset = -1
+	}
+	s.render(w, r, s.templatesFor(w, r), "index.html", context)
+}
+
+// photosHandler displays a grid of the entries that contain photos.
+func (s *Server) photosHandler(w http.ResponseWriter, r *http.Request) {
+	if *local {
+		s.loadTemplates()
+	}
+	w.Header().Set("Content-Type", "text/html")
+	limit := parseWithDefault(r.FormValue("limit"), 30)
+	offset := parseWithDefault(r.FormValue("offset"), 0)
+	entries, err := s.entryDB.ListPhotos(r.Context(), limit, offset)
+	if err != nil {
+		s.log.Warningf("Failed to get entries: %s", err)
+		return
+	}
+	context := &indexContext{
+		Config:  s.config.AllSettings(),
+		Entries: s.toDisplaySlice(entries),
+		Offset:  offset + limit,
+	}
+	if len(entries) < limit {
+		context.Offset = -1
+	}
+	s.render(w, r, s.templatesFor(w, r), "photos.html", context)
+}
+
+// photosFeedHandler displays the Atom feed of entries with photos.
+func (s *Server) photosFeedHandler(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.entryDB.ListPhotos(r.Context(), FEED_ENTRIES, 0)
+	if err != nil {
+		s.log.Warningf("Failed to get entries: %s", err)
+		return
+	}
+	s.renderFeed(w, r, entries, "/photos", "Photos")
+}
+
+// FEED_ENTRIES is the number of entries in feeds.
+const FEED_ENTRIES = 10
+
+type sliceContext struct {
+	Config  map[string]interface{}
+	Entries []*entryContent
+	Offset  int
+
+	// Title describes the slice, e.g. "#golang".
+	Title string
+
+	// Feed is the path of the Atom feed of the slice.
+	Feed string
+}
+
+// renderSlice displays a page of the entries with a tag or of a kind.
+func (s *Server) renderSlice(w http.ResponseWriter, r *http.Request, list func(n, offset int) ([]*entries.Entry, error), title string) {
+	if *local {
+		s.loadTemplates()
+	}
+	w.Header().Set("Content-Type", "text/html")
+	limit := parseWithDefault(r.FormValue("limit"), 20)
+	offset := parseWithDefault(r.FormValue("offset"), 0)
+	entries, err := list(limit, offset)
+	if err != nil {
+		s.log.Warningf("Failed to get entries: %s", err)
+		return
+	}
+	cooked := s.toDisplaySlice(entries)
+	s.addBridges(cooked, bridges.INDEX)
+	context := &sliceContext{
+		Config:  s.config.AllSettings(),
+		Entries: cooked,
+		Offset:  offset + limit,
+		Title:   title,
+		Feed:    strings.TrimSuffix(r.URL.Path, "/") + "/feed",
+	}
+	if len(entries) < limit {
+		context.Offset = -1
+	}
+	s.render(w, r, s.templatesFor(w, r), "slice.html", context)
+}
+
+// tagHandler displays the entries with a tag.
+func (s *Server) tagHandler(w http.ResponseWriter, r *http.Request) {
+	tag := strings.ToLower(mux.Vars(r)["tag"])
+	s.renderSlice(w, r, func(n, offset int) ([]*entries.Entry, error) {
+		return s.entryDB.ListByTag(r.Context(), tag, n, offset)
+	}, "#"+tag)
+}
+
+// tagFeedHandler displays the Atom feed of the entries with a tag.
+func (s *Server) tagFeedHandler(w http.ResponseWriter, r *http.Request) {
+	tag := strings.ToLower(mux.Vars(r)["tag"])
+	entries, err := s.entryDB.ListByTag(r.Context(), tag, FEED_ENTRIES, 0)
+	if err != nil {
+		s.log.Warningf("Failed to get entries: %s", err)
+		return
+	}
+	s.renderFeed(w, r, entries, "/tag/"+tag, "#"+tag)
+}
+
+// kindFromVars returns the kind in the URL, or false if it isn't a known
+// kind.
+func kindFromVars(r *http.Request) (entries.Kind, bool) {
+	s := mux.Vars(r)["kind"]
+	kind := entries.ToKind(s)
+	return kind, string(kind) == s
+}
+
+// kindHandler displays the entries of a kind.
+func (s *Server) kindHandler(w http.ResponseWriter, r *http.Request) {
+	kind, ok := kindFromVars(r)
+	if !ok {
+		s.renderError(w, r, http.StatusNotFound)
+		return
+	}
+	s.renderSlice(w, r, func(n, offset int) ([]*entries.Entry, error) {
+		return s.entryDB.ListByKind(r.Context(), kind, n, offset)
+	}, kind.PluralLabel())
+}
+
+// kindFeedHandler displays the Atom feed of the entries of a kind.
+func (s *Server) kindFeedHandler(w http.ResponseWriter, r *http.Request) {
+	kind, ok := kindFromVars(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	entries, err := s.entryDB.ListByKind(r.Context(), kind, FEED_ENTRIES, 0)
+	if err != nil {
+		s.log.Warningf("Failed to get entries: %s", err)
+		return
+	}
+	s.renderFeed(w, r, entries, "/kind/"+string(kind), kind.PluralLabel())
+}
+
+// kindPodcastHandler displays the RSS feed of audio or video entries, with
+// the enclosures and iTunes tags that podcast apps need.
+func (s *Server) kindPodcastHandler(w http.ResponseWriter, r *http.Request) {
+	kind, ok := kindFromVars(r)
+	if !ok || (kind != entries.AUDIO && kind != entries.VIDEO) {
+		http.NotFound(w, r)
+		return
+	}
+	list, err := s.entryDB.ListByKind(r.Context(), kind, FEED_ENTRIES, 0)
+	if err != nil {
+		s.log.Warningf("Failed to get entries: %s", err)
+		return
+	}
+	withMedia := []*entries.Entry{}
+	for _, entry := range list {
+		if entry.HasMedia() {
+			withMedia = append(withMedia, entry)
+		}
+	}
+	w.Header().Set("Content-Type", "application/rss+xml")
+	s.render(w, r, s.templates, "rss.xml", s.newFeedContext(r, withMedia, "/kind/"+string(kind), kind.Label()))
+}
+
+type onThisDayContext struct {
+	Config  map[string]interface{}
+	Entries []*entryContent
+	Date    time.Time
+}
+
+// LINKS_PAGE is the number of links on each page of /links.
+const LINKS_PAGE = 50
+
+// LINKS_DOMAINS is the number of domains that /links can be filtered by
+// with a click.
+const LINKS_DOMAINS = 30
+
+type linksContext struct {
+	Config  map[string]interface{}
+	Links   []*outlinks.Link
+	Domains []outlinks.Domain
+	Total   int
+
+	// Query and Domain are what the links were searched for.
+	Query  string
+	Domain string
+
+	// Offset is the offset of the next page, or -1 if there isn't one.
+	Offset int
+}
+
+// linksHandler lists the links public entries make to other sites, newest
+// first, searchable by words and filterable by domain.
+func (s *Server) linksHandler(w http.ResponseWriter, r *http.Request) {
+	if *local {
+		s.loadTemplates()
+	}
+	index, err := s.currentLinksIndex(r.Context())
+	if err != nil {
+		s.log.Errorf("Failed to index links: %s", err)
+		http.Error(w, "Failed to load links.", http.StatusInternalServerError)
+		return
+	}
+	offset := parseWithDefault(r.FormValue("offset"), 0)
+	if offset < 0 {
+		offset = 0
+	}
+	c := &linksContext{
+		Config:  s.config.AllSettings(),
+		Domains: index.Domains(LINKS_DOMAINS),
+		Total:   index.Len(),
+		Query:   strings.TrimSpace(r.FormValue("q")),
+		Domain:  strings.TrimSpace(r.FormValue("domain")),
+		Offset:  -1,
+	}
+	links, more := index.Find(c.Query, c.Domain, LINKS_PAGE, offset)
+	c.Links = links
+	if more {
+		c.Offset = offset + LINKS_PAGE
+	}
+	w.Header().Set("Content-Type", "text/html")
+	s.render(w, r, s.templatesFor(w, r), "links.html", c)
+}
+
+// onThisDayHandler displays entries published on today's date in previous
+// years.
+func (s *Server) onThisDayHandler(w http.ResponseWriter, r *http.Request) {
+	if *local {
+		s.loadTemplates()
+	}
+	w.Header().Set("Content-Type", "text/html")
+	now := time.Now().In(s.displayLocation())
+	entries, err := s.entryDB.ListByMonthDay(r.Context(), now)
+	if err != nil {
+		s.log.Warningf("Failed to get entries: %s", err)
+		return
+	}
+	context := &onThisDayContext{
+		Config:  s.config.AllSettings(),
+		Entries: s.toDisplaySlice(entries),
+		Date:    now,
+	}
+	s.render(w, r, s.templatesFor(w, r), "onthisday.html", context)
+}
+
+// sendOnThisDayReminder emails the admin links to entries published on
+// today's date in previous years, if there are any.
+func (s *Server) sendOnThisDayReminder(ctx context.Context) error {
+	entries, err := s.entryDB.ListByMonthDay(ctx, time.Now().In(s.displayLocation()))
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	lines := []string{}
+	for _, e := range entries {
+		lines = append(lines, fmt.Sprintf("%s - %s\n  %s", e.Created.In(s.displayLocation()).Format("2006"), e.Title, s.permalinkFromId(e.ID)))
+	}
+	return s.notify.Send("On this day", strings.Join(lines, "\n\n")+"\n")
+}
+
+// startOnThisDayReminders sends a daily reminder if ONTHISDAY_REMINDER is
+// true.
+func (s *Server) startOnThisDayReminders() {
+	if !s.config.GetBool(ONTHISDAY_REMINDER) {
+		return
+	}
+	s.addJob("onthisday", "@every 24h", s.sendOnThisDayReminder)
+}
+
+type feedContext struct {
+	Updated time.Time
+	Entries []*entryContent
+	Config  map[string]interface{}
+	Author  string
+	Host    string
+
+	// Self is the path of the feed, and Alternate the path of the HTML page
+	// with the same entries.
+	Self      string
+	Alternate string
+
+	// Title describes the slice of the stream in the feed, if it isn't the
+	// whole stream.
+	Title string
+}
+
+// feedHandler displays the Atom feed of public entries.
+func (s *Server) feedHandler(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.entryDB.ListPublic(r.Context(), FEED_ENTRIES, 0)
+	if err != nil {
+		s.serveDegraded(w, r, err)
+		return
+	}
+	s.renderFeed(w, r, entries, "/", "")
+}
+
+// TWTXT_ENTRIES is the number of entries in /twtxt.txt.
+const TWTXT_ENTRIES = 50
+
+// twtxtHandler displays the public entries in twtxt format, each as its
+// title or excerpt followed by the permalink.
+func (s *Server) twtxtHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := s.entryDB.ListPublic(r.Context(), TWTXT_ENTRIES, 0)
+	if err != nil {
+		s.log.Warningf("Failed to get entries: %s", err)
+		http.Error(w, "Failed to get entries.", http.StatusInternalServerError)
+		return
+	}
+	twts := []twtxt.Twt{}
+	for _, c := range s.toDisplaySlice(list) {
+		text := c.DisplayTitle
+		if c.IsNote && c.Excerpt != "" {
+			text = c.Excerpt
+		}
+		twts = append(twts, twtxt.Twt{
+			Created: c.Created,
+			Text:    text + " " + s.permalinkFromId(c.ID),
+		})
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	meta := twtxt.Metadata{
+		Nick:        s.mastoUsername(),
+		URL:         s.config.GetString(HOST) + "/twtxt.txt",
+		Avatar:      s.config.GetString(AUTHOR_IMAGE_URL),
+		Description: s.config.GetString(AUTHOR_DESC),
+	}
+	if err := twtxt.Write(w, meta, twts); err != nil {
+		s.log.Errorf("Failed to write twtxt: %s", err)
+	}
+}
+
+// privateFeedHandler displays the Atom feed of all entries, including private
+// ones, to anyone with a valid feed token.
+func (s *Server) privateFeedHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.tokenDB.Validate(r.Context(), r.FormValue("token"), tokens.FEED); err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	cachecontrol.Set(w, cachecontrol.PRIVATE)
+	entries, err := s.entryDB.List(r.Context(), FEED_ENTRIES, 0)
+	if err != nil {
+		s.log.Errorf("Failed to get entries: %s", err)
+		http.Error(w, "Failed to get entries.", http.StatusInternalServerError)
+		return
+	}
+	s.renderFeed(w, r, entries, "/", "Private")
+}
+
+// renderFeed displays the entries as an Atom feed, where alternate is the path
+// of the HTML page with the same entries and title describes them.
+func (s *Server) renderFeed(w http.ResponseWriter, r *http.Request, entries []*entries.Entry, alternate, title string) {
+	w.Header().Set("Content-Type", "application/atom+xml")
+	s.render(w, r, s.templates, "atom.xml", s.newFeedContext(r, entries, alternate, title))
+}
+
+// newFeedContext returns the feedContext of the entries, with their content
+// as the feed's FEED_CONTENT policy allows.
+func (s *Server) newFeedContext(r *http.Request, entries []*entries.Entry, alternate, title string) *feedContext {
+	updated := time.Time{}
+	for _, entry := range entries {
+		if entry.Updated.After(updated) {
+			updated = entry.Updated
+		}
+	}
+	cooked := s.toDisplaySlice(entries)
+	s.addBridges(cooked, bridges.FEED)
+	s.addReplyContext(r.Context(), cooked)
+	// Feed content is escaped HTML, so the reply context is rendered into it.
+	for _, c := range cooked {
+		if c.ReplyContext == nil {
+			continue
+		}
+		var b bytes.Buffer
+		if err := s.templates.ExecuteTemplate(&b, "replyContext.html", c.ReplyContext); err != nil {
+			s.log.Errorf("Failed to render reply context template: %s", err)
+			continue
+		}
+		c.SafeContent = b.String() + c.SafeContent
+	}
+	policy := s.feedPolicy(r)
+	readMore := templatefuncs.Translate(s.localeFor(r), "Read more")
+	for _, c := range cooked {
+		if c.IsNote {
+			continue
+		}
+		switch policy {
+		case FEED_SUMMARY:
+			c.SafeContent = ""
+		case FEED_EXCERPT:
+			c.SafeContent = fmt.Sprintf("<p>%s</p>\n<p><a href=\"%s\">%s</a></p>", html.EscapeString(c.Excerpt), html.EscapeString(s.permalinkFromId(c.ID)), html.EscapeString(readMore))
+		}
+	}
+	return &feedContext{
+		Config:    s.config.AllSettings(),
+		Updated:   updated,
+		Entries:   cooked,
+		Self:      r.URL.Path,
+		Alternate: alternate,
+		Title:     title,
+	}
+}
+
+// feedPolicy returns the FEED_CONTENT policy of the feed being requested.
+func (s *Server) feedPolicy(r *http.Request) string {
+	policies := s.config.GetStringMapString(FEED_CONTENT)
+	if route := mux.CurrentRoute(r); route != nil {
+		if tmpl, err := route.GetPathTemplate(); err == nil {
+			// Viper lowercases keys.
+			if policy, ok := policies[strings.ToLower(tmpl)]; ok {
+				return policy
+			}
+		}
+	}
+	if policy, ok := policies["default"]; ok {
+		return policy
+	}
+	return FEED_FULL
+}
+
+// loadMarkdownOptions reads the MARKDOWN config block, keeping the defaults
+// for any extensions it doesn't mention.
+func (s *Server) loadMarkdownOptions() {
+	opts := markdown.Default()
+	if err := s.config.UnmarshalKey(MARKDOWN, &opts); err != nil {
+		s.log.Errorf("Failed to parse %s config: %s", MARKDOWN, err)
+		return
+	}
+	s.markdownOptions = opts
+}
+
+// emptyAltRegex matches images from /images/ that have no alt text in the
+// Markdown.
+var emptyAltRegex = regexp.MustCompile(`<img src="/images/([^"]+)" alt=""`)
+
+// fillAltText adds the alt text stored in the media library to images that
+// don't have any.
+func (s *Server) fillAltText(html string) string {
+	return emptyAltRegex.ReplaceAllStringFunc(html, func(img string) string {
+		p := emptyAltRegex.FindStringSubmatch(img)[1]
+		alt := s.mediaDB.Alt(p)
+		if alt == "" {
+			return img
+		}
+		return fmt.Sprintf(`<img src="/images/%s" alt="%s"`, p, template.HTMLEscapeString(alt))
+	})
+}
+
+// renderContent converts the Markdown content of an entry into HTML.
+func (s *Server) renderContent(md string) string {
+	content := strings.ReplaceAll(md, "\r\n", "\n")
+	html := s.fillAltText(string(markdown.Render([]byte(content), s.markdownOptions)))
+	html = s.resizer.Markup(html, "/images/", "/img/")
+	if s.config.GetBool(LINKROT_ARCHIVE) {
+		html = s.linkDB.Annotate(html)
+	}
+	return s.linkRewriter.Rewrite(html)
+}
+
+// newLinkRewriter returns a linkrel.Rewriter from the LINK_REL config.
+func (s *Server) newLinkRewriter() (*linkrel.Rewriter, error) {
+	var opts linkrel.Options
+	if err := s.config.UnmarshalKey(LINK_REL, &opts); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %s", LINK_REL, err)
+	}
+	return linkrel.New(s.config.GetString(HOST), opts)
+}
+
+// loadLinkRel reads the LINK_REL config, keeping the previous rules if it
+// isn't valid.
+func (s *Server) loadLinkRel() {
+	rewriter, err := s.newLinkRewriter()
+	if err != nil {
+		s.log.Errorf("Failed to load link rel config: %s", err)
+		return
+	}
+	s.linkRewriter = rewriter
+}
+
+// robotsPrivate are the path prefixes that robots.txt always disallows, and
+// whose responses are marked robots.PRIVATE, since they are only for the
+// admin.
+var robotsPrivate = []string{
+	"/admin",
+	"/auth/",
+	"/debug/",
+	"/api/entries",
+	"/api/mentions",
+	"/feed/private",
+	tasks.PREFIX,
+}
+
+// newRobots returns a robots.Robots from the ROBOTS config.
+func (s *Server) newRobots() (*robots.Robots, error) {
+	var opts robots.Options
+	if err := s.config.UnmarshalKey(ROBOTS, &opts); err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %s", ROBOTS, err)
+	}
+	return robots.New(opts, robotsPrivate, "")
+}
+
+// loadRobots reads the ROBOTS config, keeping the previous policy if it
+// isn't valid.
+func (s *Server) loadRobots() {
+	policy, err := s.newRobots()
+	if err != nil {
+		s.log.Errorf("Failed to load robots config: %s", err)
+		return
+	}
+	s.robotsPolicy = policy
+}
+
+// robotsHandler serves robots.txt.
+func (s *Server) robotsHandler(w http.ResponseWriter, r *http.Request) {
+	s.robotsPolicy.ServeHTTP(w, r)
+}
+
+// robotsHeaders adds the X-Robots-Tag header to the responses from h for the
+// robotsPrivate paths.
+func (s *Server) robotsHeaders(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.robotsPolicy.Middleware(h).ServeHTTP(w, r)
+	})
+}
+
+// HOSTED_WEBMENTION_ENDPOINT receives webmentions for entries when
+// RECEIVE_WEBMENTIONS is off.
+const HOSTED_WEBMENTION_ENDPOINT = "https://webmention.bitworking.org/IncomingWebMention"
+
+// discoveryLinks returns the endpoints advertised by entries and the index:
+// where to send webmentions, and the WebSub hub of the feed.
+func (s *Server) discoveryLinks(r *http.Request) []discovery.Link {
+	endpoint := HOSTED_WEBMENTION_ENDPOINT
+	if s.config.GetBool(RECEIVE_WEBMENTIONS) {
+		endpoint = s.config.GetString(HOST) + "/webmention"
+	}
+	ret := []discovery.Link{{Rel: "webmention", Href: endpoint}}
+	if hub := s.config.GetString(WEBSUB); hub != "" {
+		ret = append(ret, discovery.Link{Rel: "hub", Href: hub})
+	}
+	return ret
+}
+
+// advertised wraps h so its responses advertise the discoveryLinks.
+func (s *Server) advertised(h http.Handler) http.Handler {
+	return discovery.Middleware(s.discoveryLinks, h)
+}
+
+// loadBridgeRules reads BRIDGE_CONTEXTS and BRIDGE_LINK_TEXT, keeping the
+// previous rules if they aren't valid.
+func (s *Server) loadBridgeRules() {
+	rules, err := bridges.New(s.config.GetStringSlice(BRIDGE_CONTEXTS), s.config.GetString(BRIDGE_LINK_TEXT))
+	if err != nil {
+		s.log.Errorf("Failed to parse bridge config: %s", err)
+		return
+	}
+	s.bridgeRules = rules
+}
+
+// bridgeLinks returns the links to the BRIDGES.
+func (s *Server) bridgeLinks() string {
+	links, err := s.bridgeRules.Links(s.config.GetStringSlice(BRIDGES))
+	if err != nil {
+		s.log.Warningf("%s", err)
+	}
+	return links
+}
+
+// addBridges appends the links to the BRIDGES to public entries that haven't
+// opted out, if they are added where the entries are being displayed.
+func (s *Server) addBridges(cooked []*entryContent, where bridges.Context) {
+	if !s.bridgeRules.In(where) || !s.featureDB.Enabled(context.Background(), features.BRIDGE_LINKS) {
+		return
+	}
+	links := s.bridgeLinks()
+	for _, c := range cooked {
+		if c.NoBridges || entries.ToVisibility(string(c.Visibility)) != entries.PUBLIC {
+			continue
+		}
+		c.Content += template.HTML(links)
+		c.SafeContent += links
+	}
+}
+
+// webMentionContent is the HTML that webmentions for the entry are sent
+// from, which includes the page a bookmark is about, and the bridges if the
+// entry is public and didn't opt out, so they syndicate it. Unlisted entries
+// still send webmentions to the pages they link to but are never syndicated.
+func (s *Server) webMentionContent(cooked *entryContent) string {
+	content := cooked.SafeContent
+	if cooked.Link != "" {
+		content = fmt.Sprintf(`<a class="u-bookmark-of" href="%s"></a>`, html.EscapeString(cooked.Link)) + content
+	}
+	if cooked.NoBridges || entries.ToVisibility(string(cooked.Visibility)) != entries.PUBLIC {
+		return content
+	}
+	return content + s.bridgeLinks()
+}
+
+// toDisplay converts an entries.Entry into an entryContent.
+func (s *Server) toDisplay(in *entries.Entry) *entryContent {
+	content := s.renderContent(in.Content)
+	ex := excerpt(content, EXCERPT_LENGTH)
+	displayTitle := in.Title
+	link := bookmarkLink(in)
+	if displayTitle == "" {
+		displayTitle = link
+	}
+	if displayTitle == "" {
+		displayTitle = shorten(ex, NOTE_TITLE_LENGTH)
+	}
+	if displayTitle == "" {
+		displayTitle = in.Created.Format("January 2, 2006")
+	}
+	inReplyTo := replycontext.InReplyTo(content)
+	if inReplyTo == "" && in.ParentID != "" {
+		inReplyTo = s.permalinkFromId(in.ParentID)
+	}
+	ret := &entryContent{
+		Title:        in.Title,
+		Content:      template.HTML(content),
+		SafeContent:  content,
+		ID:           in.ID,
+		Created:      in.Created,
+		Updated:      in.Updated,
+		Visibility:   in.Visibility,
+		Summary:      in.Summary,
+		InReplyTo:    inReplyTo,
+		Photos:       photos(content),
+		Kind:         in.Kind,
+		Latitude:     in.Latitude,
+		Longitude:    in.Longitude,
+		Venue:        in.Venue,
+		MapURL:       mapURL(in),
+		Link:         link,
+		WordCount:    wordCount(content),
+		ReadingTime:  readingTime(wordCount(content)),
+		Excerpt:      ex,
+		IsNote:       in.Title == "" && link == "",
+		DisplayTitle: displayTitle,
+		NoBridges:    in.NoBridges,
+		Locked:       in.Locked,
+		ParentID:     in.ParentID,
+		Likes:        in.Likes,
+		Reposts:      in.Reposts,
+		Replies:      in.Replies,
+	}
+	if in.HasMedia() {
+		ret.MediaURL = in.MediaURL
+		ret.MediaType = in.MediaType
+		ret.MediaLength = in.MediaLength
+		ret.Duration = in.Duration
+		ret.Poster = in.Poster
+	}
+	return ret
+}
+
+// bookmarkLink returns the Link of a bookmark, or "" if the entry isn't a
+// bookmark or the Link isn't an http or https URL.
+func bookmarkLink(in *entries.Entry) string {
+	if in.Kind != entries.BOOKMARK {
+		return ""
+	}
+	if _, err := receiver.ValidURL(in.Link); err != nil {
+		return ""
+	}
+	return in.Link
+}
+
+// structuredAuthor returns the author of every entry, for structured data.
+func (s *Server) structuredAuthor() *jsonld.Person {
+	return jsonld.NewPerson(s.config.GetString(AUTHOR), s.config.GetString(AUTHOR_URL), s.config.GetString(AUTHOR_IMAGE_URL))
+}
+
+// structuredPosting returns the schema.org structured data for an entry.
+func (s *Server) structuredPosting(c *entryContent) *jsonld.Posting {
+	ret := jsonld.NewPosting(c.IsNote, s.permalinkFromId(c.ID), c.DisplayTitle, c.Created, c.Updated, s.structuredAuthor())
+	ret.Description = c.Summary
+	if ret.Description == "" {
+		ret.Description = c.Excerpt
+	}
+	ret.WordCount = c.WordCount
+	for _, src := range c.Photos {
+		if strings.HasPrefix(src, "/") {
+			src = s.config.GetString(HOST) + src
+		}
+		ret.Image = append(ret.Image, src)
+	}
+	if c.Link != "" {
+		ret.Share(c.Link)
+	} else if c.IsNote {
+		ret.Share(c.InReplyTo)
+	}
+	return ret
+}
+
+// structuredBlog returns the schema.org structured data for a page of
+// entries.
+func (s *Server) structuredBlog(cooked []*entryContent) *jsonld.Blog {
+	postings := make([]*jsonld.Posting, 0, len(cooked))
+	for _, c := range cooked {
+		postings = append(postings, s.structuredPosting(c))
+	}
+	return jsonld.NewBlog(s.config.GetString(HOST)+"/", s.config.GetString(AUTHOR)+" - Stream", s.structuredAuthor(), postings)
+}
+
+// mapURL returns an OpenStreetMap embed URL centered on a checkin, or "" if
+// the entry isn't a checkin.
+func mapURL(in *entries.Entry) string {
+	if in.Kind != entries.CHECKIN {
+		return ""
+	}
+	const d = 0.005
+	return fmt.Sprintf("https://www.openstreetmap.org/export/embed.html?bbox=%f,%f,%f,%f&layer=mapnik&marker=%f,%f",
+		in.Longitude-d, in.Latitude-d, in.Longitude+d, in.Latitude+d, in.Latitude, in.Longitude)
+}
+
+// photos returns the src of every img in the HTML.
+const (
+	// WORDS_PER_MINUTE is the reading speed used to estimate reading time.
+	WORDS_PER_MINUTE = 200
+
+	// EXCERPT_LENGTH is the maximum length in runes of an entry excerpt.
+	EXCERPT_LENGTH = 280
+
+	// NOTE_TITLE_LENGTH is the maximum length in runes of the title generated
+	// for a note.
+	NOTE_TITLE_LENGTH = 60
+)
+
+// wordCount returns the number of words in the text of the given HTML.
+func wordCount(html string) int {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return 0
+	}
+	return len(strings.Fields(doc.Text()))
+}
+
+// readingTime returns the estimated reading time in minutes for the given
+// number of words, never less than one minute.
+func readingTime(words int) int {
+	minutes := (words + WORDS_PER_MINUTE - 1) / WORDS_PER_MINUTE
+	if minutes < 1 {
+		return 1
+	}
+	return minutes
+}
+
+// excerpt returns the plain text of the first non-empty paragraph of the
+// given HTML, with entities decoded, shortened on a word boundary to at most
+// n runes. If there are no paragraphs then the text of the whole document is
+// used.
+func excerpt(html string, n int) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return ""
+	}
+	text := ""
+	doc.Find("p").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		text = strings.TrimSpace(s.Text())
+		return text == ""
+	})
+	if text == "" {
+		text = doc.Text()
+	}
+	return shorten(strings.Join(strings.Fields(text), " "), n)
+}
+
+// shorten shortens text on a word boundary to at most n runes, adding an
+// ellipsis if anything was removed.
+func shorten(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	cut := string(runes[:n])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " ,.;:") + "…"
+}
+
+func photos(html string) []string {
+	ret := []string{}
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		return ret
+	}
+	doc.Find("img[src]").Each(func(i int, s *goquery.Selection) {
+		ret = append(ret, s.AttrOr("src", ""))
+	})
+	return ret
+}
+
+// isSelfReply returns true if the entry only replies to its parent in a
+// thread, which doesn't need a reply context.
+func (s *Server) isSelfReply(c *entryContent) bool {
+	return c.ParentID != "" && c.InReplyTo == s.permalinkFromId(c.ParentID)
+}
+
+// addReplyContext fills in the cached ReplyContext of entries that are
+// replies.
+func (s *Server) addReplyContext(ctx context.Context, cooked []*entryContent) {
+	for _, c := range cooked {
+		if c.InReplyTo == "" || s.isSelfReply(c) {
+			continue
+		}
+		rc, err := s.replyDB.Get(ctx, c.InReplyTo)
+		if err != nil {
+			continue
+		}
+		c.ReplyContext = rc
+	}
+}
+
+// refreshReplyContext fetches and caches the reply context of an entry if it
+// is a reply.
+func (s *Server) refreshReplyContext(ctx context.Context, cooked *entryContent) {
+	if cooked.InReplyTo == "" || s.isSelfReply(cooked) {
+		return
+	}
+	if _, err := s.replyDB.Refresh(ctx, cooked.InReplyTo); err != nil {
+		s.log.Warningf("Failed to fetch reply context: %s", err)
+	}
+}
+
+func (s *Server) toDisplaySlice(in []*entries.Entry) []*entryContent {
+	ret := []*entryContent{}
+	for _, en := range in {
+		ret = append(ret, s.toDisplay(en))
+	}
+	return ret
+}
+
+// fromForm copies the values from the new or edit entry form into the
+// entry.
+func (s *Server) fromForm(r *http.Request, entry *entries.Entry) {
+	entry.Title = r.FormValue("title")
+	entry.Content = r.FormValue("content")
+	entry.Summary = r.FormValue("summary")
+	entry.Visibility = entries.ToVisibility(r.FormValue("visibility"))
+	entry.Kind = entries.ToKind(r.FormValue("kind"))
+	entry.NoBridges = r.FormValue("no_bridges") != ""
+	entry.SetLocked(r.FormValue("locked") != "")
+	// The parent may be given as an id or a permalink.
+	entry.ParentID = strings.TrimSpace(r.FormValue("parent"))
+	if id := s.entryIDFromTarget(entry.ParentID); id != "" {
+		entry.ParentID = id
+	}
+	if entry.ParentID == entry.ID {
+		entry.ParentID = ""
+	}
+	entry.Link = ""
+	if entry.Kind == entries.BOOKMARK {
+		entry.Link = strings.TrimSpace(r.FormValue("link"))
+	}
+	entry.MediaURL, entry.MediaType, entry.MediaLength, entry.Duration, entry.Poster = "", "", 0, 0, ""
+	if entry.Kind == entries.AUDIO || entry.Kind == entries.VIDEO {
+		entry.MediaURL = strings.TrimSpace(r.FormValue("media_url"))
+		if entry.Kind == entries.VIDEO {
+			entry.Poster = strings.TrimSpace(r.FormValue("poster"))
+		}
+		if d, err := media.ParseDuration(r.FormValue("duration")); err == nil {
+			entry.Duration = int64(d / time.Second)
+		}
+		s.probeMedia(entry)
+	}
+	if entry.Kind == entries.CHECKIN {
+		entry.Latitude, _ = strconv.ParseFloat(r.FormValue("latitude"), 64)
+		entry.Longitude, _ = strconv.ParseFloat(r.FormValue("longitude"), 64)
+		entry.Venue = r.FormValue("venue")
+		if r.FormValue("fuzz") != "" {
+			entry.FuzzLocation(s.config.GetInt(LOCATION_FUZZ_PLACES))
+		}
+	}
+}
+
+// probeMedia fills in the MediaType, MediaLength, and, if it isn't already
+// known, the Duration of the entry's MediaURL. Files in the media library are
+// probed, for anything else the type is guessed from the extension.
+func (s *Server) probeMedia(entry *entries.Entry) {
+	entry.MediaType = media.TypeByExtension(entry.MediaURL)
+	if !strings.HasPrefix(entry.MediaURL, "/images/") {
+		return
+	}
+	filename, err := s.mediaDB.File(strings.TrimPrefix(entry.MediaURL, "/images/"))
+	if err != nil {
+		s.log.Warningf("Failed to find media %q: %s", entry.MediaURL, err)
+		return
+	}
+	info, err := media.Probe(filename)
+	if err != nil {
+		s.log.Warningf("Failed to probe media %q: %s", entry.MediaURL, err)
+		return
+	}
+	entry.MediaType = info.Type
+	entry.MediaLength = info.Length
+	if entry.Duration == 0 {
+		entry.Duration = int64(info.Duration / time.Second)
+	}
+}
+
+// adminNewHandler accepts POST'd form values to create a new entry.
+func (s *Server) adminNewHandler(w http.ResponseWriter, r *http.Request) {
+	if *local {
+		s.loadTemplates()
+	}
+	if !s.isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	entry := &entries.Entry{}
+	s.fromForm(r, entry)
+	if warnings := a11y.Check(s.renderContent(entry.Content)); len(warnings) > 0 && r.FormValue("ignore_warnings") == "" {
+		c := &adminContext{
+			IsAdmin:  true,
+			Offset:   -1,
+			Config:   s.config.AllSettings(),
+			Form:     map[string]string{},
+			Warnings: warnings,
+		}
+		for _, key := range []string{"title", "summary", "content", "visibility", "kind", "link", "media_url", "poster", "duration", "venue", "latitude", "longitude", "no_bridges", "locked", "parent"} {
+			c.Form[key] = r.FormValue(key)
+		}
+		w.Header().Set("Content-Type", "text/html")
+		s.render(w, r, s.templates, "admin.html", c)
+		return
+	}
+	id, err := s.entryDB.Insert(r.Context(), entry)
+	if err != nil {
+		s.log.Errorf("Failed to insert: %s", err)
+		http.Error(w, "Failed to insert", http.StatusInternalServerError)
+		return
+	}
+	s.published(r.Context(), id, entry)
+	http.Redirect(w, r, "/admin", 302)
+}
+
+// entriesChanged clears caches that depend on entries or their mentions.
+func (s *Server) entriesChanged() {
+	s.relatedCache.Clear()
+	s.pageCache.Clear()
+	s.searchMutex.Lock()
+	s.searchIndex = nil
+	s.searchMutex.Unlock()
+	s.tagCountsMutex.Lock()
+	s.tagCounts = nil
+	s.tagCountsMutex.Unlock()
+	s.linksMutex.Lock()
+	s.linksIndex = nil
+	s.linksMutex.Unlock()
+}
+
+// entriesHidden is entriesChanged for when entries are deleted or made
+// non-public, which also forgets the saved pages served when degraded, since
+// those may show the entries.
+func (s *Server) entriesHidden() {
+	s.entriesChanged()
+	s.pageCache.Purge()
+}
+
+// currentSearchIndex returns the search index of all entries, building it if
+// needed.
+func (s *Server) currentSearchIndex(ctx context.Context) (*search.Index, error) {
+	s.searchMutex.Lock()
+	defer s.searchMutex.Unlock()
+	if s.searchIndex != nil {
+		return s.searchIndex, nil
+	}
+	docs := []*search.Doc{}
+	err := s.entryDB.All(ctx, func(entry *entries.Entry) error {
+		docs = append(docs, &search.Doc{
+			ID:      entry.ID,
+			Title:   entry.Title,
+			Text:    entry.Content,
+			Created: entry.Created,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.searchIndex = search.New(docs)
+	return s.searchIndex, nil
+}
+
+// tagCount is a tag and the number of public entries that have it.
+type tagCount struct {
+	Name  string
+	Count int
+}
+
+// currentLinksIndex returns the index of the outbound links of public
+// entries, building it if needed.
+func (s *Server) currentLinksIndex(ctx context.Context) (*outlinks.Index, error) {
+	s.linksMutex.Lock()
+	defer s.linksMutex.Unlock()
+	if s.linksIndex != nil {
+		return s.linksIndex, nil
+	}
+	links := []*outlinks.Link{}
+	err := s.entryDB.All(ctx, func(entry *entries.Entry) error {
+		if !entry.IsPublic() {
+			return nil
+		}
+		cooked := s.toDisplay(entry)
+		found := outlinks.Extract(cooked.SafeContent, s.config.GetString(HOST))
+		if l := outlinks.NewLink(cooked.Link, entry.Title); l != nil {
+			found = append([]*outlinks.Link{l}, found...)
+		}
+		for _, l := range found {
+			l.EntryID = entry.ID
+			l.EntryTitle = cooked.DisplayTitle
+			l.Created = entry.Created
+			links = append(links, l)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.linksIndex = outlinks.New(links)
+	return s.linksIndex, nil
+}
+
+// currentTagCounts returns the tags of public entries, most used first,
+// counting them if needed.
+func (s *Server) currentTagCounts(ctx context.Context) ([]*tagCount, error) {
+	s.tagCountsMutex.Lock()
+	defer s.tagCountsMutex.Unlock()
+	if s.tagCounts != nil {
+		return s.tagCounts, nil
+	}
+	counts := map[string]int{}
+	err := s.entryDB.All(ctx, func(entry *entries.Entry) error {
+		if entry.IsPublic() {
+			for _, t := range entry.Tags {
+				counts[t]++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ret := []*tagCount{}
+	for name, n := range counts {
+		ret = append(ret, &tagCount{Name: name, Count: n})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Count != ret[j].Count {
+			return ret[i].Count > ret[j].Count
+		}
+		return ret[i].Name < ret[j].Name
+	})
+	s.tagCounts = ret
+	return s.tagCounts, nil
+}
+
+// SEARCH_RESULTS is the most results /admin/api/search returns.
+const SEARCH_RESULTS = 20
+
+type searchResult struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	Excerpt string `json:"excerpt"`
+}
+
+// adminSearchHandler returns the entries matching the query q as JSON, for
+// the filter box on the admin page.
+func (s *Server) adminSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	index, err := s.currentSearchIndex(r.Context())
+	if err != nil {
+		s.log.Errorf("Failed to build search index: %s", err)
+		http.Error(w, "Failed to search.", http.StatusInternalServerError)
+		return
+	}
+	ret := []*searchResult{}
+	for _, doc := range index.Search(r.FormValue("q"), SEARCH_RESULTS) {
+		ret = append(ret, &searchResult{
+			ID:      doc.ID,
+			Title:   doc.Title,
+			Excerpt: excerpt(s.renderContent(doc.Text), EXCERPT_LENGTH),
+		})
+	}
+	s.writeJSON(w, ret)
+}
+
+// inlineEntry is the part of an entry the inline editor on the admin page
+// can change.
+type inlineEntry struct {
+	ID         string             `json:"id"`
+	Title      string             `json:"title"`
+	Tags       []string           `json:"tags"`
+	Visibility entries.Visibility `json:"visibility"`
+	Version    int64              `json:"version"`
+}
+
+func toInlineEntry(entry *entries.Entry) *inlineEntry {
+	ret := &inlineEntry{
+		ID:         entry.ID,
+		Title:      entry.Title,
+		Tags:       entry.Tags,
+		Visibility: entries.ToVisibility(string(entry.Visibility)),
+		Version:    entry.Version,
+	}
+	if ret.Tags == nil {
+		ret.Tags = []string{}
+	}
+	return ret
+}
+
+// entryPatch is a partial update from the inline editor. Fields that are nil
+// are left unchanged. Version is the version of the entry the edit was made
+// to, as with the edit form.
+type entryPatch struct {
+	Version    int64     `json:"version"`
+	Title      *string   `json:"title"`
+	Tags       *[]string `json:"tags"`
+	Visibility *string   `json:"visibility"`
+}
+
+// apply changes the entry, returning an error if a value isn't valid.
+func (p *entryPatch) apply(entry *entries.Entry) error {
+	if p.Title != nil {
+		entry.Title = strings.TrimSpace(*p.Title)
+	}
+	if p.Tags != nil {
+		content, err := entries.SetTags(entry.Content, *p.Tags)
+		if err != nil {
+			return err
+		}
+		entry.Content = content
+	}
+	if p.Visibility != nil {
+		v := entries.Visibility(*p.Visibility)
+		if v != entries.PUBLIC && v != entries.UNLISTED && v != entries.PRIVATE {
+			return fmt.Errorf("Unknown visibility %q.", *p.Visibility)
+		}
+		entry.Visibility = v
+	}
+	entry.Version = p.Version
+	return nil
+}
+
+// adminAPIEntryHandler returns an entry as an inlineEntry, or applies an
+// entryPatch to it and returns the result. If the entry was changed since
+// the version the patch was made to, the response is a 409 with the current
+// entry, so the editor can show it.
+func (s *Server) adminAPIEntryHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	raw, err := s.entryDB.Get(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method == "PATCH" {
+		// Requiring JSON means a cross-site form can't make the request.
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			http.Error(w, "Content-Type must be application/json.", http.StatusUnsupportedMediaType)
+			return
+		}
+		var patch entryPatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			http.Error(w, "Failed to parse JSON.", http.StatusBadRequest)
+			return
+		}
+		if err := patch.apply(raw); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.entryDB.Update(r.Context(), raw); err == entries.ErrConflict {
+			current, err := s.entryDB.Get(r.Context(), raw.ID)
+			if err != nil {
+				http.Error(w, "Failed to read.", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			s.writeJSON(w, toInlineEntry(current))
+			return
+		} else if err != nil {
+			s.log.Errorf("Failed to update entry: %s", err)
+			http.Error(w, "Failed to write.", http.StatusInternalServerError)
+			return
+		}
+		s.edited(r.Context(), raw)
+	}
+	s.writeJSON(w, toInlineEntry(raw))
+}
+
+// edited does the work that follows updating an entry.
+func (s *Server) edited(ctx context.Context, entry *entries.Entry) {
+	if entry.IsPublic() {
+		s.entriesChanged()
+	} else {
+		s.entriesHidden()
+	}
+	s.refreshReplyContext(ctx, s.toDisplay(entry))
+	if entry.Visibility != entries.PRIVATE {
+		if err := s.taskQueue.Enqueue(ctx, WEBMENTIONS_TASK, entryTask{ID: entry.ID}); err != nil {
+			s.log.Warningf("Failed to send webmentions: %s", err)
+		}
+	}
+}
+
+// published does the work that follows inserting a new entry, such as
+// sending webmentions and push notifications.
+func (s *Server) published(ctx context.Context, id string, entry *entries.Entry) {
+	s.entriesChanged()
+	s.notifyPublished(id, entry)
+	cooked := s.toDisplay(entry)
+	s.refreshReplyContext(ctx, cooked)
+	if entry.IsPublic() {
+		// Create the short URL before sending webmentions so that bridges
+		// syndicating to length limited networks can use it.
+		if _, err := s.shortDB.For(ctx, id); err != nil {
+			s.log.Warningf("Failed to create short URL: %s", err)
+		}
+		if s.config.GetBool(WAYBACK_SAVE) {
+			s.taskQueue.Go(SAVE_LINKS_TASK, entryTask{ID: id})
+		}
+	}
+	if entry.Visibility != entries.PRIVATE {
+		if err := s.taskQueue.Enqueue(ctx, WEBMENTIONS_TASK, entryTask{ID: id}); err != nil {
+			s.log.Warningf("Failed to send webmentions: %s", err)
+		}
+	}
+	if s.pushDB != nil && entry.IsPublic() && s.featureDB.Enabled(ctx, features.PUSH) {
+		if err := s.taskQueue.Enqueue(ctx, PUSH_TASK, entryTask{ID: id}); err != nil {
+			s.log.Warningf("Failed to send push notifications: %s", err)
+		}
+	}
+}
+
+// Task names, see addTasks.
+const (
+	WEBMENTIONS_TASK = "webmentions"
+	PUSH_TASK        = "push"
+	SAVE_LINKS_TASK  = "savelinks"
+	RECEIVE_TASK     = "receive"
+)
+
+// entryTask is the payload of the tasks done for an entry.
+type entryTask struct {
+	ID string `json:"id"`
+}
+
+// receiveTask is the payload of RECEIVE_TASK.
+type receiveTask struct {
+	ID     string `json:"id"`
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Vouch  string `json:"vouch"`
+}
+
+// entryTaskHandler returns a task handler that calls f with the entry named
+// in an entryTask payload. Only failing to read the entry is returned as an
+// error, since retrying f would repeat the parts that succeeded, such as
+// webmentions already sent.
+func (s *Server) entryTaskHandler(f func(ctx context.Context, entry *entries.Entry)) tasks.Handler {
+	return func(ctx context.Context, payload []byte) error {
+		var t entryTask
+		if err := json.Unmarshal(payload, &t); err != nil {
+			s.log.Warningf("Dropped invalid task: %s", err)
+			return nil
+		}
+		entry, err := s.entryDB.Get(ctx, t.ID)
+		if err != nil {
+			return err
+		}
+		f(ctx, entry)
+		return nil
+	}
+}
+
+// addTasks adds the handlers of the tasks in taskQueue.
+func (s *Server) addTasks() {
+	s.taskQueue.Add(WEBMENTIONS_TASK, s.entryTaskHandler(func(ctx context.Context, entry *entries.Entry) {
+		if entry.Visibility == entries.PRIVATE {
+			return
+		}
+		if err := s.sendWebMentions(entry.ID, s.webMentionContent(s.toDisplay(entry))); err != nil {
+			s.log.Warningf("Failed to send webmentions: %s", err)
+		}
+	}))
+	s.taskQueue.Add(PUSH_TASK, s.entryTaskHandler(s.sendPush))
+	s.taskQueue.Add(SAVE_LINKS_TASK, s.entryTaskHandler(func(ctx context.Context, entry *entries.Entry) {
+		s.saveLinks(entry.ID, entry)
+	}))
+	s.taskQueue.Add(RECEIVE_TASK, func(ctx context.Context, payload []byte) error {
+		var t receiveTask
+		if err := json.Unmarshal(payload, &t); err != nil {
+			s.log.Warningf("Dropped invalid task: %s", err)
+			return nil
+		}
+		entry, err := s.entryDB.Get(ctx, t.ID)
+		if err != nil {
+			return err
+		}
+		s.receiveWebMention(entry, t.Source, t.Target, t.Vouch)
+		return nil
+	})
+}
+
+// sendPush sends a push notification of the entry to every subscriber.
+func (s *Server) sendPush(ctx context.Context, entry *entries.Entry) {
+	title := entry.Title
+	if title == "" {
+		title = s.config.GetString(AUTHOR) + " - Stream"
+	}
+	if *dryRunOutbound {
+		s.recordDryRun(&outbox.Attempt{
+			EntryID: entry.ID,
+			Source:  s.permalinkFromId(entry.ID),
+			Target:  title,
+			Kind:    outbox.PUSH,
+		})
+	} else if err := s.pushDB.SendAll(ctx, &push.Message{Title: title, URL: s.permalinkFromId(entry.ID)}); err != nil {
+		s.log.Warningf("Failed to send push notifications: %s", err)
+	}
+}
+
+// API_LIST_LIMIT is the most entries or mentions returned by a single
+// request to the JSON API.
+const API_LIST_LIMIT = 100
+
+// apiOnly wraps h so that it requires an "Authorization: Bearer <token>"
+// header with a token that has the ADMIN scope.
+func (s *Server) apiOnly(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := s.tokenDB.Validate(r.Context(), strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), tokens.ADMIN); err != nil {
+			s.log.Warningf("API token failed to validate: %s", err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	})
+}
+
+// apiEntry is an entry as returned by the JSON API.
+type apiEntry struct {
+	ID         string             `json:"id"`
+	URL        string             `json:"url"`
+	Title      string             `json:"title"`
+	Content    string             `json:"content"`
+	Visibility entries.Visibility `json:"visibility"`
+	Created    time.Time          `json:"created"`
+	Updated    time.Time          `json:"updated"`
+}
+
+// apiEntriesHandler lists entries, including private ones, newest first.
+func (s *Server) apiEntriesHandler(w http.ResponseWriter, r *http.Request) {
+	n := parseWithDefault(r.FormValue("n"), 20)
+	if n > API_LIST_LIMIT {
+		n = API_LIST_LIMIT
+	}
+	list, err := s.entryDB.List(r.Context(), n, parseWithDefault(r.FormValue("offset"), 0))
+	if err != nil {
+		s.log.Errorf("Failed to get entries: %s", err)
+		http.Error(w, "Failed to get entries.", http.StatusInternalServerError)
+		return
+	}
+	ret := []*apiEntry{}
+	for _, e := range list {
+		ret = append(ret, &apiEntry{
+			ID:         e.ID,
+			URL:        s.permalinkFromId(e.ID),
+			Title:      e.Title,
+			Content:    e.Content,
+			Visibility: e.Visibility,
+			Created:    e.Created,
+			Updated:    e.Updated,
+		})
+	}
+	s.writeJSON(w, ret)
+}
+
+// apiDeleteEntryHandler deletes an entry.
+func (s *Server) apiDeleteEntryHandler(w http.ResponseWriter, r *http.Request) {
+	if err := s.entryDB.Delete(r.Context(), mux.Vars(r)["id"]); err != nil {
+		s.log.Errorf("Failed to delete entry: %s", err)
+		http.Error(w, "Failed to delete.", http.StatusInternalServerError)
+		return
+	}
+	s.entriesHidden()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiWebMentionsHandler resends the webmentions for an entry.
+func (s *Server) apiWebMentionsHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	entry, err := s.entryDB.Get(r.Context(), id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if entry.Visibility == entries.PRIVATE {
+		http.Error(w, "Webmentions aren't sent for private entries.", http.StatusBadRequest)
+		return
+	}
+	if err := s.sendWebMentions(id, s.webMentionContent(s.toDisplay(entry))); err != nil {
+		s.log.Errorf("Failed to send webmentions: %s", err)
+		http.Error(w, "Failed to send webmentions.", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiBackupHandler runs a backup to BACKUP_BUCKET now.
+func (s *Server) apiBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if s.backupBucket == nil {
+		http.Error(w, "BACKUP_BUCKET isn't configured.", http.StatusBadRequest)
+		return
+	}
+	if err := s.runBackup(r.Context(), s.backupBucket); err != nil {
+		s.log.Errorf("Backup failed: %s", err)
+		http.Error(w, fmt.Sprintf("Backup failed: %s", err), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiMentionsHandler lists mentions received after the time in the since
+// parameter, oldest first, which streamctl polls to tail new mentions.
+func (s *Server) apiMentionsHandler(w http.ResponseWriter, r *http.Request) {
+	since := time.Now().Add(-24 * time.Hour)
+	if v := r.FormValue("since"); v != "" {
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			http.Error(w, "Invalid since.", http.StatusBadRequest)
+			return
+		}
+		since = t
+	}
+	list, err := s.mentionDB.Since(r.Context(), since, API_LIST_LIMIT)
+	if err != nil {
+		s.log.Errorf("Failed to get mentions: %s", err)
+		http.Error(w, "Failed to get mentions.", http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, list)
+}
+
+// GRAPHQL_MAX_FIRST is the most items a page of a GraphQL connection has.
+const GRAPHQL_MAX_FIRST = 100
+
+// graphqlPage is a page of a GraphQL connection, where offset is the offset
+// of the first of the items in the whole list.
+type graphqlPage struct {
+	items   []interface{}
+	offset  int
+	hasNext bool
+}
+
+// graphqlPaginate returns the page of list selected by the "first" and
+// "after" arguments. list is called with one more than the number of items
+// needed, to find out if there is a next page.
+func graphqlPaginate(args map[string]interface{}, list func(n, offset int) ([]interface{}, error)) (*graphqlPage, error) {
+	first, err := graphql.Int(args, "first", 20)
+	if err != nil {
+		return nil, err
+	}
+	if first < 0 || first > GRAPHQL_MAX_FIRST {
+		return nil, fmt.Errorf("Argument \"first\" must be between 0 and %d.", GRAPHQL_MAX_FIRST)
+	}
+	after, err := graphql.String(args, "after", "")
+	if err != nil {
+		return nil, err
+	}
+	offset, err := graphql.Offset(after)
+	if err != nil {
+		return nil, err
+	}
+	items, err := list(first+1, offset)
+	if err != nil {
+		return nil, err
+	}
+	ret := &graphqlPage{
+		items:   items,
+		offset:  offset,
+		hasNext: len(items) > first,
+	}
+	if ret.hasNext {
+		ret.items = items[:first]
+	}
+	return ret, nil
+}
+
+// graphqlField returns a graphql.Field of scalars whose value is f of the
+// source.
+func graphqlField(f func(source interface{}) interface{}) *graphql.Field {
+	return &graphql.Field{
+		Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+			return f(source), nil
+		},
+	}
+}
+
+// graphqlTime formats times in GraphQL responses.
+func graphqlTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Format(time.RFC3339)
+}
+
+// graphqlPageInfo is the PageInfo of every connection.
+var graphqlPageInfo = &graphql.Object{
+	Name: "PageInfo",
+	Fields: map[string]*graphql.Field{
+		"hasNextPage": graphqlField(func(source interface{}) interface{} { return source.(*graphqlPage).hasNext }),
+		"endCursor": graphqlField(func(source interface{}) interface{} {
+			p := source.(*graphqlPage)
+			if len(p.items) == 0 {
+				return nil
+			}
+			return graphql.Cursor(p.offset + len(p.items) - 1)
+		}),
+	},
+}
+
+// graphqlEdge is an item of a page of a connection at offset in the list.
+type graphqlEdge struct {
+	node   interface{}
+	offset int
+}
+
+// graphqlConnection returns the type of the pages of a list of node, with
+// edges that have cursors, the nodes alone, and the PageInfo, as in Relay.
+func graphqlConnection(node *graphql.Object) *graphql.Object {
+	edge := &graphql.Object{
+		Name: node.Name + "Edge",
+		Fields: map[string]*graphql.Field{
+			"cursor": graphqlField(func(source interface{}) interface{} { return graphql.Cursor(source.(*graphqlEdge).offset) }),
+			"node": {
+				Type: node,
+				Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+					return source.(*graphqlEdge).node, nil
+				},
+			},
+		},
+	}
+	return &graphql.Object{
+		Name: node.Name + "Connection",
+		Fields: map[string]*graphql.Field{
+			"edges": {
+				Type: edge,
+				List: true,
+				Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+					p := source.(*graphqlPage)
+					ret := []interface{}{}
+					for i, item := range p.items {
+						ret = append(ret, &graphqlEdge{node: item, offset: p.offset + i})
+					}
+					return ret, nil
+				},
+			},
+			"nodes": {
+				Type: node,
+				List: true,
+				Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+					return source.(*graphqlPage).items, nil
+				},
+			},
+			"pageInfo": {
+				Type: graphqlPageInfo,
+				Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+					return source, nil
+				},
+			},
+		},
+	}
+}
+
+// graphqlEntries converts entries into the items of a graphqlPage.
+func graphqlEntries(list []*entries.Entry) []interface{} {
+	ret := []interface{}{}
+	for _, entry := range list {
+		ret = append(ret, entry)
+	}
+	return ret
+}
+
+// newGraphQLSchema returns the schema of the read-only GraphQL API, which
+// only has entries that aren't private, their visible mentions, and the
+// tags of public entries:
+//
+//	type Query {
+//	  entries(first: Int, after: String, tag: String, kind: String): EntryConnection
+//	  entry(id: String!): Entry
+//	  tags(first: Int): [Tag]
+//	}
+func (s *Server) newGraphQLSchema() *graphql.Schema {
+	entryField := func(f func(e *entries.Entry) interface{}) *graphql.Field {
+		return graphqlField(func(source interface{}) interface{} { return f(source.(*entries.Entry)) })
+	}
+	mentionField := func(f func(m *mentions.Mention) interface{}) *graphql.Field {
+		return graphqlField(func(source interface{}) interface{} { return f(source.(*mentions.Mention)) })
+	}
+	tagField := func(f func(t *tagCount) interface{}) *graphql.Field {
+		return graphqlField(func(source interface{}) interface{} { return f(source.(*tagCount)) })
+	}
+
+	mention := &graphql.Object{
+		Name: "Mention",
+		Fields: map[string]*graphql.Field{
+			"id":          mentionField(func(m *mentions.Mention) interface{} { return m.ID }),
+			"source":      mentionField(func(m *mentions.Mention) interface{} { return m.Source }),
+			"type":        mentionField(func(m *mentions.Mention) interface{} { return string(m.Type) }),
+			"authorName":  mentionField(func(m *mentions.Mention) interface{} { return m.AuthorName }),
+			"authorURL":   mentionField(func(m *mentions.Mention) interface{} { return m.AuthorURL }),
+			"authorPhoto": mentionField(func(m *mentions.Mention) interface{} { return m.AuthorPhoto }),
+			"content":     mentionField(func(m *mentions.Mention) interface{} { return m.Content }),
+			"published":   mentionField(func(m *mentions.Mention) interface{} { return graphqlTime(m.Published) }),
+			"created":     mentionField(func(m *mentions.Mention) interface{} { return graphqlTime(m.Created) }),
+		},
+	}
+	mentionConnection := graphqlConnection(mention)
+
+	entry := &graphql.Object{Name: "Entry"}
+	entryConnection := graphqlConnection(entry)
+	entry.Fields = map[string]*graphql.Field{
+		"id":           entryField(func(e *entries.Entry) interface{} { return e.ID }),
+		"url":          entryField(func(e *entries.Entry) interface{} { return s.permalinkFromId(e.ID) }),
+		"title":        entryField(func(e *entries.Entry) interface{} { return e.Title }),
+		"displayTitle": entryField(func(e *entries.Entry) interface{} { return s.toDisplay(e).DisplayTitle }),
+		"summary":      entryField(func(e *entries.Entry) interface{} { return e.Summary }),
+		"markdown":     entryField(func(e *entries.Entry) interface{} { return e.Content }),
+		"html":         entryField(func(e *entries.Entry) interface{} { return s.renderContent(e.Content) }),
+		"excerpt":      entryField(func(e *entries.Entry) interface{} { return s.toDisplay(e).Excerpt }),
+		"kind":         entryField(func(e *entries.Entry) interface{} { return string(entries.ToKind(string(e.Kind))) }),
+		"visibility":   entryField(func(e *entries.Entry) interface{} { return string(entries.ToVisibility(string(e.Visibility))) }),
+		"tags":         entryField(func(e *entries.Entry) interface{} { return append([]string{}, e.Tags...) }),
+		"link":         entryField(func(e *entries.Entry) interface{} { return bookmarkLink(e) }),
+		"syndication":  entryField(func(e *entries.Entry) interface{} { return append([]string{}, e.Syndication...) }),
+		"created":      entryField(func(e *entries.Entry) interface{} { return graphqlTime(e.Created) }),
+		"updated":      entryField(func(e *entries.Entry) interface{} { return graphqlTime(e.Updated) }),
+		"mentions": {
+			Type: mentionConnection,
+			Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				return graphqlPaginate(args, func(n, offset int) ([]interface{}, error) {
+					list, err := s.mentionDB.ForEntry(ctx, source.(*entries.Entry).ID)
+					if err != nil {
+						return nil, err
+					}
+					ret := []interface{}{}
+					for i, m := range s.visibleMentions(list) {
+						if i >= offset && len(ret) < n {
+							ret = append(ret, m)
+						}
+					}
+					return ret, nil
+				})
+			},
+		},
+	}
+
+	tag := &graphql.Object{
+		Name: "Tag",
+		Fields: map[string]*graphql.Field{
+			"name":  tagField(func(t *tagCount) interface{} { return t.Name }),
+			"count": tagField(func(t *tagCount) interface{} { return t.Count }),
+			"url":   tagField(func(t *tagCount) interface{} { return s.config.GetString(HOST) + "/tag/" + url.PathEscape(t.Name) }),
+		},
+	}
+
+	return &graphql.Schema{
+		Query: &graphql.Object{
+			Name: "Query",
+			Fields: map[string]*graphql.Field{
+				"entries": {
+					Type: entryConnection,
+					Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+						tag, err := graphql.String(args, "tag", "")
+						if err != nil {
+							return nil, err
+						}
+						kind, err := graphql.String(args, "kind", "")
+						if err != nil {
+							return nil, err
+						}
+						if tag != "" && kind != "" {
+							return nil, fmt.Errorf("Only one of \"tag\" and \"kind\" can be given.")
+						}
+						if kind != "" && string(entries.ToKind(kind)) != kind {
+							return nil, fmt.Errorf("Unknown kind %q.", kind)
+						}
+						return graphqlPaginate(args, func(n, offset int) ([]interface{}, error) {
+							var list []*entries.Entry
+							var err error
+							switch {
+							case tag != "":
+								list, err = s.entryDB.ListByTag(ctx, strings.TrimPrefix(tag, "#"), n, offset)
+							case kind != "":
+								list, err = s.entryDB.ListByKind(ctx, entries.Kind(kind), n, offset)
+							default:
+								list, err = s.entryDB.ListPublic(ctx, n, offset)
+							}
+							return graphqlEntries(list), err
+						})
+					},
+				},
+				"entry": {
+					Type: entry,
+					Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+						id, err := graphql.String(args, "id", "")
+						if err != nil {
+							return nil, err
+						}
+						e, err := s.entryDB.Get(ctx, id)
+						if err != nil || e.Visibility == entries.PRIVATE {
+							return nil, nil
+						}
+						return e, nil
+					},
+				},
+				"tags": {
+					Type: tag,
+					List: true,
+					Resolve: func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+						first, err := graphql.Int(args, "first", GRAPHQL_MAX_FIRST)
+						if err != nil {
+							return nil, err
+						}
+						counts, err := s.currentTagCounts(ctx)
+						if err != nil {
+							return nil, err
+						}
+						ret := []interface{}{}
+						for _, t := range counts {
+							if len(ret) >= first {
+								break
+							}
+							ret = append(ret, t)
+						}
+						return ret, nil
+					},
+				},
+			},
+		},
+	}
+}
+
+// graphqlHandler serves the read-only GraphQL API, with the query as GET
+// parameters or a POST'd JSON body. Responses can be read from any origin.
+func (s *Server) graphqlHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	req := &graphql.Request{}
+	if r.Method == "POST" {
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+			http.Error(w, "Content-Type must be application/json.", http.StatusUnsupportedMediaType)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			if limits.TooLarge(err) {
+				http.Error(w, "Query too large.", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Invalid JSON.", http.StatusBadRequest)
+			return
+		}
+	} else {
+		req.Query = r.FormValue("query")
+		req.OperationName = r.FormValue("operationName")
+		if v := r.FormValue("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				http.Error(w, "Invalid variables.", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	resp := s.graphqlSchema.Execute(r.Context(), req)
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Data == nil {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	s.writeJSON(w, resp)
+}
+
+// MAX_QUICK_POST_SIZE is the largest body accepted by quickPostHandler.
+const MAX_QUICK_POST_SIZE = 64 * 1024
+
+// quickPost is the JSON body accepted by quickPostHandler.
+type quickPost struct {
+	Title      string `json:"title"`
+	Content    string `json:"content"`
+	Summary    string `json:"summary"`
+	Visibility string `json:"visibility"`
+
+	// Link makes the entry a bookmark of the page at Link.
+	Link string `json:"link"`
+}
+
+// quickPostHandler creates a note from a plain text or JSON body, or a
+// bookmark if the JSON has a link, for use by automations like iOS Shortcuts.
+// Requests must have an "Authorization: Bearer <token>" header with a token
+// that has the POST scope. The permalink of the new entry is returned in the
+// body and Location header.
+func (s *Server) quickPostHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.tokenDB.Validate(r.Context(), strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), tokens.POST); err != nil {
+		s.log.Warningf("Quick post token failed to validate: %s", err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, MAX_QUICK_POST_SIZE+1))
+	if err != nil {
+		http.Error(w, "Failed to read body.", http.StatusBadRequest)
+		return
+	}
+	if len(b) > MAX_QUICK_POST_SIZE {
+		http.Error(w, "Body too large.", http.StatusRequestEntityTooLarge)
+		return
+	}
+	post := quickPost{}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.Unmarshal(b, &post); err != nil {
+			http.Error(w, "Invalid JSON.", http.StatusBadRequest)
+			return
+		}
+	} else {
+		post.Content = string(b)
+	}
+	if strings.TrimSpace(post.Content) == "" && post.Link == "" {
+		http.Error(w, "Content must not be empty.", http.StatusBadRequest)
+		return
+	}
+	entry := &entries.Entry{
+		Title:      post.Title,
+		Content:    post.Content,
+		Summary:    post.Summary,
+		Visibility: entries.PUBLIC,
+		Kind:       entries.NOTE,
+	}
+	if post.Link != "" {
+		if _, err := receiver.ValidURL(post.Link); err != nil {
+			http.Error(w, "Link must be an http or https URL.", http.StatusBadRequest)
+			return
+		}
+		entry.Kind = entries.BOOKMARK
+		entry.Link = post.Link
+	}
+	if post.Visibility != "" {
+		entry.Visibility = entries.ToVisibility(post.Visibility)
+	}
+	id, err := s.entryDB.Insert(r.Context(), entry)
+	if err != nil {
+		s.log.Errorf("Failed to insert: %s", err)
+		http.Error(w, "Failed to insert", http.StatusInternalServerError)
+		return
+	}
+	s.published(r.Context(), id, entry)
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Location", s.permalinkFromId(id))
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, s.permalinkFromId(id))
+}
+
+// pushSubscribeHandler accepts a POST'd JSON PushSubscription and stores it.
+func (s *Server) pushSubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	if s.pushDB == nil {
+		http.NotFound(w, r)
+		return
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, 4*1024))
+	if err != nil {
+		http.Error(w, "Failed to read body.", http.StatusBadRequest)
+		return
+	}
+	sub, err := push.ParseSubscription(b)
+	if err != nil {
+		http.Error(w, "Invalid subscription.", http.StatusBadRequest)
+		return
+	}
+	if err := s.pushDB.Subscribe(r.Context(), sub); err != nil {
+		s.log.Errorf("Failed to store push subscription: %s", err)
+		http.Error(w, "Failed to subscribe.", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) sendWebMentions(id, content string) error {
+	client := safefetch.New(30 * time.Second)
+	source := s.permalinkFromId(id)
+	m := webmention.New(client)
+	buf := bytes.NewBufferString(content)
+	links, err := webmention.DiscoverLinksFromReader(buf, source, "")
+	if err != nil {
+		return fmt.Errorf("Failed to discover links in %q: %s", content, err)
+	}
+	sendTo := s.featureDB.Enabled(context.Background(), features.WEBMENTIONS)
+	syndicate := s.featureDB.Enabled(context.Background(), features.SYNDICATION)
+	for _, link := range links {
+		if bridge := s.isBridge(link); (bridge && !syndicate) || (!bridge && !sendTo) {
+			s.log.Infof("Webmention not sent, feature is off: %q -> %q", source, link)
+			continue
+		}
+		resp, err := s.sendWebMention(m, id, link)
+		if err != nil {
+			continue
+		}
+		s.recordSyndication(id, link, resp)
+	}
+	if err := s.entryDB.AddTargets(context.Background(), id, links); err != nil {
+		s.log.Warningf("Failed to record webmention targets: %s", err)
+	}
+	if !s.featureDB.Enabled(context.Background(), features.WEBSUB) {
+		return nil
+	}
+	s.pingWebSub(id)
+	return nil
+}
+
+// pingWebSub tells the WEBSUB hub that the feed changed because of the entry
+// with the given id, recording the attempt in the outbox.
+func (s *Server) pingWebSub(id string) {
+	attempt := &outbox.Attempt{
+		EntryID: id,
+		Source:  fmt.Sprintf("%s/feed", s.config.GetString(HOST)),
+		Target:  s.config.GetString(WEBSUB),
+		Kind:    outbox.WEBSUB,
+	}
+	if *dryRunOutbound {
+		s.recordDryRun(attempt)
+		return
+	}
+	resp, err := safefetch.New(30*time.Second).PostForm(attempt.Target, url.Values{
+		"hub.mode": {"publish"},
+		"hub.url":  {attempt.Source},
+	})
+	if err != nil {
+		s.log.Errorf("Failed to update websub hub: %q: %s", attempt.Target, err)
+		attempt.Error = err.Error()
+	} else {
+		resp.Body.Close()
+		s.log.Infof("WebSub response: %d - %q", resp.StatusCode, resp.Status)
+		attempt.Status = resp.StatusCode
+		if resp.StatusCode >= 400 {
+			attempt.Error = resp.Status
+		}
+	}
+	if err := s.outboxDB.Record(context.Background(), attempt); err != nil {
+		s.log.Warningf("%s", err)
+	}
+}
+
+// recordSyndication records the URL of the syndicated copy of the entry that
+// bridges, like Bridgy Publish, return in response to a webmention.
+func (s *Server) recordSyndication(id, link string, resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	if loc := resp.Header.Get("Location"); loc != "" && s.isBridge(link) {
+		if err := s.entryDB.AddSyndication(context.Background(), id, loc); err != nil {
+			s.log.Warningf("Failed to record syndication %q: %s", loc, err)
+		}
+	}
+}
+
+// sendWebMention sends a single webmention from the entry with the given id
+// to link, recording the attempt in the outbox. The admin is only notified if
+// link has an endpoint and it rejects the webmention for good, not when the
+// endpoint can't be found or reached, fails, or asks to be retried later, and
+// only the first time it rejects webmentions from the entry.
+func (s *Server) sendWebMention(m *webmention.Client, id, link string) (*http.Response, error) {
+	source := s.permalinkFromId(id)
+	attempt := &outbox.Attempt{
+		EntryID: id,
+		Source:  source,
+		Target:  link,
+	}
+	defer func() {
+		if err := s.outboxDB.Record(context.Background(), attempt); err != nil {
+			s.log.Warningf("%s", err)
+		}
+	}()
+	s.log.Infof("Webmention trying to send: %q -> %q", source, link)
+	endpoint, err := s.endpointDB.Discover(context.Background(), link)
+	if err != nil {
+		attempt.Error = err.Error()
+		return nil, err
+	}
+	attempt.Endpoint = endpoint
+	if *dryRunOutbound {
+		attempt.DryRun = true
+		s.log.Infof("Dry run, webmention not sent: %q -> %q via %q", source, link, endpoint)
+		return nil, nil
+	}
+	resp, err := m.SendWebmention(endpoint, source, link)
+	failed := err == nil && (resp.StatusCode < 200 || resp.StatusCode >= 300)
+	if err != nil || failed {
+		// The endpoint may have moved, so find it again next time.
+		if err := s.endpointDB.Forget(context.Background(), link); err != nil {
+			s.log.Warningf("%s", err)
+		}
+	}
+	if err != nil {
+		s.log.Infof("Failed to send webmention %q -> %q: %s", source, link, err)
+		attempt.Error = err.Error()
+		return nil, err
+	}
+	// Only the headers are used by callers.
+	defer resp.Body.Close()
+	attempt.Status = resp.StatusCode
+	if failed {
+		s.log.Infof("Failed to send webmention %q -> %q: %s", source, link, resp.Status)
+		attempt.Error = resp.Status
+		if rejected(resp.StatusCode) && !s.rejectedBefore(id, link) {
+			s.notifyWebMentionFailed(source, link, resp.Status)
+		}
+		return nil, fmt.Errorf("Webmention endpoint returned %s", resp.Status)
+	}
+	s.log.Infof("Webmention sent: %q -> %q", source, link)
+	return resp, nil
+}
+
+// rejected returns true if an endpoint's response status means it won't
+// accept the webmention if it's sent again as it is, i.e. a 4xx other than
+// 408 Request Timeout or 429 Too Many Requests.
+func rejected(status int) bool {
+	return status >= 400 && status < 500 && status != http.StatusRequestTimeout && status != http.StatusTooManyRequests
+}
+
+// rejectedBefore returns true if an earlier webmention from the entry with the
+// given id was rejected by link, in which case the admin was already
+// notified, e.g. when salmentions are resent for each new reply.
+func (s *Server) rejectedBefore(id, link string) bool {
+	attempts, err := s.outboxDB.ForEntry(context.Background(), id)
+	if err != nil {
+		s.log.Warningf("Failed to read outbox: %s", err)
+		return false
+	}
+	for _, a := range attempts {
+		if a.IsWebmention() && a.Target == link && rejected(a.Status) {
+			return true
+		}
+	}
+	return false
+}
+
+// discoverEndpoint finds the webmention endpoint of target for endpointDB.
+func discoverEndpoint(target string) (string, error) {
+	endpoint, err := webmention.New(safefetch.New(30 * time.Second)).DiscoverEndpoint(target)
+	// The webmention package reports a page without an endpoint as an error,
+	// which is cached differently from a page that couldn't be fetched.
+	if err != nil && strings.Contains(err.Error(), "no webmention rel found") {
+		return "", nil
+	}
+	return endpoint, err
+}
+
+// recordDryRun logs and records an outbound request that wasn't sent because
+// of -dry-run-outbound.
+func (s *Server) recordDryRun(attempt *outbox.Attempt) {
+	attempt.DryRun = true
+	s.log.Infof("Dry run, %s not sent: %q -> %q", attempt.Kind, attempt.Source, attempt.Target)
+	if err := s.outboxDB.Record(context.Background(), attempt); err != nil {
+		s.log.Warningf("%s", err)
+	}
+}
+
+// sendSalmentions re-sends webmentions to every page the entry has ever
+// linked to, so that upstream conversations learn about new responses to the
+// entry. See https://indieweb.org/Salmention.
+//
+// Bridges are skipped since sending to them would publish the entry again.
+func (s *Server) sendSalmentions(entry *entries.Entry) {
+	if !s.featureDB.Enabled(context.Background(), features.WEBMENTIONS) {
+		return
+	}
+	m := webmention.New(safefetch.New(30 * time.Second))
+	for _, link := range entry.Targets {
+		if s.isBridge(link) {
+			continue
+		}
+		_, _ = s.sendWebMention(m, entry.ID, link)
+	}
+}
+
+// resendWebMention sends a webmention from the entry with the given id to a
+// single target again.
+func (s *Server) resendWebMention(id, link string) error {
+	m := webmention.New(safefetch.New(30 * time.Second))
+	resp, err := s.sendWebMention(m, id, link)
+	if err != nil {
+		return err
+	}
+	s.recordSyndication(id, link, resp)
+	return nil
+}
+
+// isBridge returns true if the link is one of the configured BRIDGES.
+func (s *Server) isBridge(link string) bool {
+	for _, b := range s.config.GetStringSlice(BRIDGES) {
+		if link == b {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyWebMentionFailed lets the admin know that a webmention could not be
+// sent.
+func (s *Server) notifyWebMentionFailed(source, target, reason string) {
+	subject := fmt.Sprintf("Webmention failed: %s", target)
+	body := fmt.Sprintf("Failed to send webmention.\n\nSource: %s\nTarget: %s\nReason: %s\n", source, target, reason)
+	if err := s.notify.Send(subject, body); err != nil {
+		s.log.Warningf("Failed to send notification: %s", err)
+	}
+}
+
+type editContext struct {
+	Raw    *entries.Entry
+	Cooked *entryContent
+	Config map[string]interface{}
+
+	// CapabilityURL is a link that can be shared to view a private entry, or
+	// "" if capability URLs aren't configured.
+	CapabilityURL string
+
+	// Current is only set when an update conflicted with another edit, and
+	// holds the stored entry, while Raw holds the rejected edit.
+	Current *entries.Entry
+
+	// Warnings are accessibility problems in the saved content.
+	Warnings []string
+}
+
+// renderConflict displays the edit page with both the rejected edit and the
+// currently stored entry so they can be merged by hand. The form's version
+// is the stored one, so resubmitting overwrites it.
+func (s *Server) renderConflict(w http.ResponseWriter, r *http.Request, edited *entries.Entry) {
+	current, err := s.entryDB.Get(r.Context(), edited.ID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	edited.Version = current.Version
+	c := editContext{
+		Raw:           edited,
+		Cooked:        s.toDisplay(current),
+		Config:        s.config.AllSettings(),
+		CapabilityURL: s.capabilityURL(edited.ID),
+		Current:       current,
+	}
+	s.renderStatus(w, r, s.templates, http.StatusConflict, "adminEdit.html", c)
+}
+
+// adminEditHandler displays the admin page for Stream.
+func (s *Server) adminEditHandler(w http.ResponseWriter, r *http.Request) {
+	if *local {
+		s.loadTemplates()
+	}
+	if !s.isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	vars := mux.Vars(r)
+	id := vars["id"]
+	raw, err := s.entryDB.Get(r.Context(), id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method == "POST" {
+		switch r.FormValue("action") {
+		case "update":
+			s.fromForm(r, raw)
+			raw.Version, _ = strconv.ParseInt(r.FormValue("version"), 10, 64)
+			if err := s.entryDB.Update(r.Context(), raw); err == entries.ErrConflict {
+				s.renderConflict(w, r, raw)
+				return
+			} else if err != nil {
+				http.Error(w, "Failed to write.", http.StatusInternalServerError)
+				return
+			}
+			s.edited(r.Context(), raw)
+		case "alias":
+			if err := s.entryDB.AddAlias(r.Context(), id, strings.TrimSpace(r.FormValue("alias"))); err != nil {
+				s.log.Warningf("Failed to add alias: %s", err)
+				http.Error(w, "Failed to add alias.", http.StatusBadRequest)
+				return
+			}
+			s.entriesChanged()
+			http.Redirect(w, r, "/admin/edit/"+id, 302)
+			return
+		case "delete":
+			if err := s.entryDB.Delete(r.Context(), id); err != nil {
+				s.log.Errorf("Failed to delete: %s", err)
+				http.Error(w, "Failed to delete.", http.StatusInternalServerError)
+				return
+			}
+			s.entriesHidden()
+			s.setFlash(w, &flash{
+				Message: fmt.Sprintf("Deleted %q, it can be restored for %d minutes.", s.toDisplay(raw).DisplayTitle, s.config.GetInt(UNDO_DELETE_MINUTES)),
+				UndoID:  id,
+			})
+			http.Redirect(w, r, "/admin", 302)
+			return
+		default:
+			http.Error(w, "POST request failed to include action.", http.StatusBadRequest)
+			return
+		}
+	}
+	c := editContext{
+		Raw:           raw,
+		Cooked:        s.toDisplay(raw),
+		Config:        s.config.AllSettings(),
+		CapabilityURL: s.capabilityURL(id),
+		Warnings:      a11y.Check(s.renderContent(raw.Content)),
+	}
+	s.render(w, r, s.templates, "adminEdit.html", c)
+}
+
+// capability returns the signature that allows viewing the private entry
+// with the given id, or "" if no CAPABILITY_SECRET is set.
+func capability(id string) string {
+	secret := os.Getenv(CAPABILITY_SECRET_ENV)
+	if secret == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// capabilityURL returns a permalink that grants access to the private entry
+// with the given id, or "" if capabilities aren't configured.
+func (s *Server) capabilityURL(id string) string {
+	c := capability(id)
+	if c == "" {
+		return ""
+	}
+	return s.permalinkFromId(id) + "?cap=" + c
+}
+
+// validCapability returns true if cap grants access to the entry.
+func validCapability(id, cap string) bool {
+	expected := capability(id)
+	return expected != "" && hmac.Equal([]byte(expected), []byte(cap))
+}
+
+type entryContext struct {
+	Cooked   *entryContent
+	Config   map[string]interface{}
+	Mentions []*mentions.Mention
+	Related  []*entryContent
+
+	// Thread are the entries that continue this one, in reading order.
+	Thread []*entryContent
+
+	// ShortURL is the entry's /s/{code} link, if it has one.
+	ShortURL string
+
+	// ReplyAddress is the address replies to the entry can be emailed to.
+	ReplyAddress string
+
+	// Federated is true if the entry has a copy on the fediverse that
+	// visitors can interact with from their own instance.
+	Federated bool
+}
+
+// RELATED_CANDIDATES is how many recent entries are considered when looking
+// for related entries.
+const RELATED_CANDIDATES = 200
+
+// relatedEntries returns up to n public entries related to the given entry,
+// computing and caching them if needed.
+func (s *Server) relatedEntries(ctx context.Context, entry *entries.Entry, n int) []*entryContent {
+	ids, ok := s.relatedCache.Get(entry.ID)
+	if !ok {
+		recent, err := s.entryDB.ListPublic(ctx, RELATED_CANDIDATES, 0)
+		if err != nil {
+			s.log.Warningf("Failed to get related candidates: %s", err)
+			return nil
+		}
+		candidates := make([]related.Candidate, len(recent))
+		for i, e := range recent {
+			candidates[i] = related.Candidate{ID: e.ID, Title: e.Title, Tags: e.Tags}
+		}
+		ids = related.Top(related.Candidate{ID: entry.ID, Title: entry.Title, Tags: entry.Tags}, candidates, n)
+		s.relatedCache.Set(entry.ID, ids)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	found, err := s.entryDB.GetMulti(ctx, ids)
+	if err != nil {
+		s.log.Warningf("Failed to get related entries: %s", err)
+		return nil
+	}
+	ret := []*entryContent{}
+	for _, e := range found {
+		if e != nil && e.IsPublic() {
+			ret = append(ret, s.toDisplay(e))
+		}
+	}
+	return ret
+}
+
+// entryHandler handles the permalink for an individual entry.
+func (s *Server) entryHandler(w http.ResponseWriter, r *http.Request) {
+	if *local {
+		s.loadTemplates()
+	}
+	vars := mux.Vars(r)
+	id := vars["id"]
+	raw, err := s.entryDB.Get(r.Context(), id)
+	if err != nil && err != entries.ErrNotFound {
+		s.serveDegraded(w, r, err)
+		return
+	}
+	if err != nil {
+		// Redirect from a previous identifier to the canonical permalink.
+		if canonical, err := s.entryDB.FindByAlias(r.Context(), id); err == nil {
+			target := "/entry/" + canonical
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, http.StatusMovedPermanently)
+			return
+		}
+		s.renderError(w, r, http.StatusNotFound)
+		return
+	}
+	if raw.Visibility == entries.PRIVATE && !validCapability(id, r.FormValue("cap")) && !s.isAdmin(r) {
+		s.renderError(w, r, http.StatusNotFound)
+		return
+	}
+	switch raw.Visibility {
+	case entries.PRIVATE:
+		s.robotsPolicy.SetHeader(w, robots.PRIVATE)
+		cachecontrol.Set(w, cachecontrol.NO_STORE)
+	case entries.UNLISTED:
+		s.robotsPolicy.SetHeader(w, robots.NOINDEX)
+	}
+
+	mentionList, err := s.mentionDB.ForEntry(r.Context(), id)
+	if err != nil {
+		s.log.Warningf("Failed to get mentions: %s", err)
+	}
+	mentionList = s.visibleMentions(mentionList)
+
+	cooked := s.toDisplay(raw)
+	s.addBridges([]*entryContent{cooked}, bridges.PERMALINK)
+	s.addReplyContext(r.Context(), []*entryContent{cooked})
+
+	c := &entryContext{
+		Cooked:   cooked,
+		Config:   s.config.AllSettings(),
+		Mentions: mentionList,
+		Related:  s.relatedEntries(r.Context(), raw, 5),
+	}
+	if raw.ParentID == "" {
+		thread, err := s.entryDB.Thread(r.Context(), id)
+		if err != nil {
+			s.log.Warningf("Failed to get thread: %s", err)
+		}
+		for _, e := range thread {
+			if e.Visibility != entries.PRIVATE {
+				c.Thread = append(c.Thread, s.toDisplay(e))
+			}
+		}
+	}
+	if raw.IsPublic() {
+		if code, err := s.shortDB.Lookup(r.Context(), id); err != nil {
+			s.log.Warningf("Failed to look up short URL: %s", err)
+		} else if code != "" {
+			c.ShortURL = s.shortURL(code)
+		}
+	}
+	if raw.Visibility != entries.PRIVATE && !raw.Locked {
+		c.ReplyAddress = s.replyAddress(id)
+	}
+	c.Federated = raw.IsPublic() && !raw.Locked && s.federatedURL(raw) != ""
+
+	s.render(w, r, s.templatesFor(w, r), "entry.html", c)
+}
+
+// federatedURL returns the URL of the entry's copy on the fediverse, which is
+// the status it was syndicated to on Mastodon if there is one, otherwise the
+// permalink if the FEDSOC_BRIDGE federates it. Returns "" if neither.
+func (s *Server) federatedURL(entry *entries.Entry) string {
+	for _, u := range entry.Syndication {
+		if _, _, err := backfeed.ParseStatusURL(u); err == nil {
+			return u
+		}
+	}
+	if s.config.GetString(FEDSOC_BRIDGE) != "" {
+		return s.permalinkFromId(entry.ID)
+	}
+	return ""
+}
+
+var interactResolver = interact.New(safefetch.New(10 * time.Second))
+
+// interactHandler sends a visitor to their own fediverse instance, given
+// their handle, to reply to, boost, or favourite the entry's federated copy.
+func (s *Server) interactHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	entry, err := s.entryDB.Get(r.Context(), id)
+	if err != nil || !entry.IsPublic() {
+		http.NotFound(w, r)
+		return
+	}
+	uri := s.federatedURL(entry)
+	if uri == "" {
+		http.NotFound(w, r)
+		return
+	}
+	u, err := interactResolver.URL(r.Context(), r.FormValue("handle"), uri)
+	if err != nil {
+		s.log.Infof("Failed to find interact URL: %s", err)
+		http.Error(w, "Couldn't find your instance, check your handle looks like @you@example.social.", 400)
+		return
+	}
+	http.Redirect(w, r, u, http.StatusSeeOther)
+}
+
+// precacheEntry is a URL for the service worker to cache ahead of time, along
+// with a revision that changes when the content at the URL does.
+type precacheEntry struct {
+	URL      string `json:"url"`
+	Revision string `json:"revision"`
+}
+
+// precacheAssets maps static URLs to the files, relative to resources_dir or
+// embedded, they are built from.
+var precacheAssets = []struct {
+	URL   string
+	Files []string
+}{
+	{"/offline", []string{"templates/offline.html", "templates/header.html"}},
+	{"/manifest.json", []string{"templates/manifest.json"}},
+}
+
+// precacheManifest returns the static assets, everything in assetDB, and the
+// permalinks of the most recent SW_PRECACHE_ENTRIES public entries.
+func (s *Server) precacheManifest(ctx context.Context) ([]precacheEntry, error) {
+	ret := []precacheEntry{}
+	for _, asset := range precacheAssets {
+		h := md5.New()
+		for _, filename := range asset.Files {
+			b, err := resourceFile(filename)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read precache asset: %s", err)
+			}
+			h.Write(b)
+		}
+		ret = append(ret, precacheEntry{URL: asset.URL, Revision: fmt.Sprintf("%x", h.Sum(nil))[:12]})
+	}
+	names, err := s.assetDB.Names()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		ret = append(ret, precacheEntry{URL: s.assetDB.URL(name), Revision: s.assetDB.Hash(name)})
+	}
+	recent, err := s.entryDB.ListPublic(ctx, s.config.GetInt(SW_PRECACHE_ENTRIES), 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range recent {
+		rev := fmt.Sprintf("%x", md5.Sum([]byte(entry.ID+entry.Updated.Format(time.RFC3339Nano))))[:12]
+		ret = append(ret, precacheEntry{URL: "/entry/" + entry.ID, Revision: rev})
+	}
+	return ret, nil
+}
+
+type serviceWorkerContext struct {
+	// Precache is the JSON encoded list of precacheEntry's.
+	Precache template.HTML
+
+	// Version changes whenever any revision in Precache does, so that
+	// browsers install the new service worker.
+	Version string
+}
+
+// serviceWorkerHandler serves the service worker script, with the precache
+// manifest filled in.
+func (s *Server) serviceWorkerHandler(w http.ResponseWriter, r *http.Request) {
+	if *local {
+		s.loadTemplates()
+	}
+	manifest, err := s.precacheManifest(r.Context())
+	if err != nil {
+		s.log.Warningf("Failed to build precache manifest: %s", err)
+		manifest = []precacheEntry{}
+	}
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		s.log.Errorf("Failed to encode precache manifest: %s", err)
+		http.Error(w, "Failed to render.", http.StatusInternalServerError)
+		return
+	}
+	context := &serviceWorkerContext{
+		Precache: template.HTML(b),
+		Version:  fmt.Sprintf("%x", md5.Sum(b))[:12],
+	}
+	w.Header().Set("Content-Type", "text/javascript")
+	cachecontrol.Set(w, cachecontrol.NO_CACHE)
+	s.render(w, r, s.templates, "service-worker.js", context)
+}
+
+// manifestHandler handles the permalink for an individual entry.
+func (s *Server) manifestHandler(w http.ResponseWriter, r *http.Request) {
+	if *local {
+		s.loadTemplates()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	s.render(w, r, s.templates, "manifest.json", nil)
+}
+
+// offlineHandler handles the permalink for an individual entry.
+func (s *Server) offlineHandler(w http.ResponseWriter, r *http.Request) {
+	if *local {
+		s.loadTemplates()
+	}
+	w.Header().Set("Content-Type", "text/html")
+	s.render(w, r, s.templates, "offline.html", nil)
+}
+
+// visibleMentions filters out mentions awaiting moderation, retracted ones,
+// and those that were stored before their source or author was blocked.
+func (s *Server) visibleMentions(in []*mentions.Mention) []*mentions.Mention {
+	ret := []*mentions.Mention{}
+	for _, m := range in {
+		if !m.Pending && !m.Retracted && !s.blockDB.Blocked(m.Source, m.AuthorURL) {
+			ret = append(ret, m)
+		}
+	}
+	return ret
+}
+
+// replyAddress returns the address replies to the entry can be emailed to,
+// or "" if replying by email isn't configured.
+func (s *Server) replyAddress(id string) string {
+	secret := os.Getenv(REPLY_SECRET_ENV)
+	if secret == "" || s.config.GetString(REPLY_DOMAIN) == "" {
+		return ""
+	}
+	return emailreply.Address(id, secret, s.config.GetString(REPLY_DOMAIN))
+}
+
+// emailReplyHandler receives replies sent to reply addresses from a Mailgun
+// inbound route and stores them as mentions awaiting moderation.
+func (s *Server) emailReplyHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.mailgun.Verify(os.Getenv(MAILGUN_SIGNING_KEY_ENV), r.FormValue("timestamp"), r.FormValue("token"), r.FormValue("signature")) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, err := emailreply.Parse(r.FormValue("recipient"), os.Getenv(REPLY_SECRET_ENV))
+	if err != nil {
+		s.log.Warningf("Rejected email reply: %s", err)
+		// Mailgun retries on anything but 200 and 406.
+		http.Error(w, "Unknown recipient.", http.StatusNotAcceptable)
+		return
+	}
+	entry, err := s.entryDB.Get(r.Context(), id)
+	if err != nil || entry.Visibility == entries.PRIVATE {
+		http.Error(w, "Unknown recipient.", http.StatusNotAcceptable)
+		return
+	}
+	if entry.Locked {
+		s.log.Infof("Dropped email reply to locked %q", entry.ID)
+		http.Error(w, "Replies are closed.", http.StatusNotAcceptable)
+		return
+	}
+	// Checking the sender's domain as a URL lets host patterns block mail.
+	sender := strings.ToLower(r.FormValue("sender"))
+	domain := sender[strings.LastIndex(sender, "@")+1:]
+	if s.blockDB.Blocked("mailto:"+sender, "https://"+domain) {
+		s.log.Infof("Dropped email reply from blocked %q", sender)
+		return
+	}
+	content := r.FormValue("stripped-text")
+	if content == "" {
+		content = r.FormValue("body-plain")
+	}
+	messageID := r.FormValue("Message-Id")
+	if messageID == "" {
+		messageID = r.FormValue("token")
+	}
+	mention := &mentions.Mention{
+		EntryID:    id,
+		Source:     "mid:" + strings.Trim(messageID, "<>"),
+		Type:       mentions.EMAIL,
+		AuthorName: emailreply.Author(r.FormValue("from")),
+		Content:    strings.TrimSpace(content),
+		Published:  time.Now(),
+		Pending:    true,
+	}
+	isNew, err := s.mentionDB.Put(r.Context(), mention)
+	if err != nil {
+		s.log.Errorf("Failed to store email reply: %s", err)
+		http.Error(w, "Failed to store reply.", http.StatusInternalServerError)
+		return
+	}
+	if isNew {
+		s.notifyMentionReceived(mention)
+	}
+}
+
+// webmentionReceiver verifies the sources of received webmentions.
+var webmentionReceiver = receiver.New(safefetch.New(30 * time.Second))
+
+// entryIDFromTarget returns the id of the entry a webmention target refers
+// to, or "" if the target isn't a permalink.
+func (s *Server) entryIDFromTarget(target string) string {
+	prefix := s.permalinkFromId("")
+	if !strings.HasPrefix(target, prefix) {
+		return ""
+	}
+	id := strings.TrimPrefix(target, prefix)
+	if i := strings.IndexAny(id, "/?#"); i >= 0 {
+		id = id[:i]
+	}
+	return id
+}
+
+// webmentionHandler receives webmentions for entries. Sources are fetched
+// and verified in the background, so a 202 is returned for any request that
+// targets a public entry.
+func (s *Server) webmentionHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.config.GetBool(RECEIVE_WEBMENTIONS) {
+		http.NotFound(w, r)
+		return
+	}
+	source := r.FormValue("source")
+	target := r.FormValue("target")
+	vouch := r.FormValue("vouch")
+	if _, err := receiver.ValidURL(source); err != nil {
+		http.Error(w, "Invalid source.", http.StatusBadRequest)
+		return
+	}
+	if vouch != "" {
+		if _, err := receiver.ValidURL(vouch); err != nil {
+			http.Error(w, "Invalid vouch.", http.StatusBadRequest)
+			return
+		}
+	}
+	if source == target {
+		http.Error(w, "Source and target must differ.", http.StatusBadRequest)
+		return
+	}
+	id := s.entryIDFromTarget(target)
+	if id == "" {
+		http.Error(w, "Target is not an entry.", http.StatusBadRequest)
+		return
+	}
+	entry, err := s.entryDB.Get(r.Context(), id)
+	if err != nil || entry.Visibility == entries.PRIVATE {
+		http.Error(w, "Target is not an entry.", http.StatusBadRequest)
+		return
+	}
+	if entry.Locked {
+		http.Error(w, "Target no longer accepts webmentions.", http.StatusGone)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	s.taskQueue.Go(RECEIVE_TASK, receiveTask{ID: entry.ID, Source: source, Target: target, Vouch: vouch})
+}
+
+// receiveWebMention verifies and stores a webmention of the entry. Mentions
+// wait for moderation unless their source is on a trusted domain or is
+// vouched for by a page on a trusted domain.
+func (s *Server) receiveWebMention(entry *entries.Entry, source, target, vouch string) {
+	ctx := context.Background()
+	m, err := webmentionReceiver.Verify(ctx, source, target)
+	if err == receiver.ErrNoLink || err == receiver.ErrGone {
+		// A webmention for an updated or deleted source retracts what it
+		// said before.
+		s.retractMentions(ctx, entry.ID, source, "")
+		s.updateInteractions(ctx, entry.ID)
+		s.log.Infof("Webmention from %q retracted: %s", source, err)
+		return
+	}
+	if err != nil {
+		s.log.Warningf("Rejected webmention from %q: %s", source, err)
+		return
+	}
+	// The entry may have been locked since the webmention was queued, and
+	// pushed and backfed webmentions never went through webmentionHandler.
+	if entry.Locked {
+		s.log.Infof("Dropped webmention from %q to locked %q", source, entry.ID)
+		return
+	}
+	m.EntryID = entry.ID
+	m.Target = target
+	m.Verified = time.Now()
+	if s.blockDB.Blocked(m.Source, m.AuthorURL) {
+		s.log.Infof("Dropped webmention from blocked %q", source)
+		return
+	}
+	m.Pending = !s.trustDB.Trusted(receiver.Domain(source))
+	if m.Pending && vouch != "" && s.trustDB.Trusted(receiver.Domain(vouch)) {
+		if err := webmentionReceiver.Vouch(ctx, vouch, source); err != nil {
+			s.log.Warningf("Failed to verify vouch for %q: %s", source, err)
+		} else {
+			m.Pending = false
+		}
+	}
+	isNew, err := s.mentionDB.Put(ctx, m)
+	if err != nil {
+		s.log.Errorf("Failed to store webmention: %s", err)
+		return
+	}
+	// An updated source may have changed the type of the mention.
+	s.retractMentions(ctx, entry.ID, source, m.ID)
+	s.updateInteractions(ctx, entry.ID)
+	if !m.Pending {
+		s.pageCache.Clear()
+	}
+	if isNew {
+		s.notifyMentionReceived(m)
+		if !m.Pending {
+			s.sendSalmentions(entry)
+		}
+	}
+}
+
+// retractMentions retracts the mentions of the entry from source, except the
+// one with the ID keep.
+func (s *Server) retractMentions(ctx context.Context, entryID, source, keep string) {
+	found, err := s.mentionDB.FromSource(ctx, entryID, source)
+	if err != nil {
+		s.log.Warningf("Failed to find mentions from %q: %s", source, err)
+		return
+	}
+	for _, m := range found {
+		if m.ID == keep || m.Retracted {
+			continue
+		}
+		if err := s.mentionDB.Retract(ctx, m.ID); err != nil {
+			s.log.Warningf("%s", err)
+			continue
+		}
+		s.pageCache.Clear()
+	}
+}
+
+// REVERIFY_BATCH is the most webmentions checked by each run of
+// reverifyMentions.
+const REVERIFY_BATCH = 50
+
+// reverifyMentions fetches the sources of the least recently verified
+// webmentions again, updating them, or retracting them if the source was
+// deleted or no longer links to the entry.
+func (s *Server) reverifyMentions(ctx context.Context) error {
+	list, err := s.mentionDB.Unverified(ctx, REVERIFY_BATCH)
+	if err != nil {
+		return err
+	}
+	retracted := 0
+	changed := map[string]bool{}
+	for _, m := range list {
+		fresh, err := webmentionReceiver.Verify(ctx, m.Source, m.Target)
+		if err == receiver.ErrNoLink || err == receiver.ErrGone {
+			if err := s.mentionDB.Retract(ctx, m.ID); err != nil {
+				s.log.Warningf("%s", err)
+			}
+			changed[m.EntryID] = true
+			retracted++
+			continue
+		}
+		if err != nil {
+			// Probably temporary, so try again next time.
+			s.log.Infof("Failed to reverify %q: %s", m.Source, err)
+			continue
+		}
+		fresh.EntryID = m.EntryID
+		fresh.Target = m.Target
+		fresh.Pending = m.Pending
+		fresh.Verified = time.Now()
+		if _, err := s.mentionDB.Put(ctx, fresh); err != nil {
+			s.log.Warningf("%s", err)
+			continue
+		}
+		if fresh.ID != m.ID {
+			if err := s.mentionDB.Retract(ctx, m.ID); err != nil {
+				s.log.Warningf("%s", err)
+			}
+			changed[m.EntryID] = true
+		}
+	}
+	for id := range changed {
+		s.updateInteractions(ctx, id)
+	}
+	s.pageCache.Clear()
+	s.log.Infof("Reverified %d webmentions, %d retracted.", len(list), retracted)
+	return nil
+}
+
+func (s *Server) startReverifyMentions() {
+	s.config.SetDefault(REVERIFY_HOURS, 24)
+	if s.config.GetInt(REVERIFY_HOURS) == 0 {
+		return
+	}
+	s.addJob("reverify", every(time.Duration(s.config.GetInt(REVERIFY_HOURS))*time.Hour), s.reverifyMentions)
+}
+
+// updateInteractions recounts the likes, reposts, and replies of the entry
+// from its visible mentions.
+func (s *Server) updateInteractions(ctx context.Context, entryID string) {
+	list, err := s.mentionDB.ForEntry(ctx, entryID)
+	if err != nil {
+		s.log.Warningf("Failed to count interactions of %q: %s", entryID, err)
+		return
+	}
+	counts := mentions.Count(s.visibleMentions(list))
+	if err := s.entryDB.SetInteractions(ctx, entryID, counts.Likes, counts.Reposts, counts.Replies); err != nil {
+		s.log.Warningf("Failed to update interactions of %q: %s", entryID, err)
+	}
+}
+
+// recountInteractions recounts the interactions of every entry, catching
+// those that changed without a mention changing, such as when an author is
+// blocked.
+func (s *Server) recountInteractions(ctx context.Context) error {
+	byEntry := map[string][]*mentions.Mention{}
+	err := s.mentionDB.All(ctx, func(m *mentions.Mention) error {
+		byEntry[m.EntryID] = append(byEntry[m.EntryID], m)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	stale := map[string]mentions.Counts{}
+	err = s.entryDB.All(ctx, func(entry *entries.Entry) error {
+		counts := mentions.Count(s.visibleMentions(byEntry[entry.ID]))
+		if counts.Likes != entry.Likes || counts.Reposts != entry.Reposts || counts.Replies != entry.Replies {
+			stale[entry.ID] = counts
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	updated := 0
+	for id, counts := range stale {
+		if err := s.entryDB.SetInteractions(ctx, id, counts.Likes, counts.Reposts, counts.Replies); err != nil {
+			s.log.Warningf("%s", err)
+			continue
+		}
+		updated++
+	}
+	if updated > 0 {
+		s.pageCache.Clear()
+	}
+	s.log.Infof("Recounted interactions, %d entries updated.", updated)
+	return nil
+}
+
+// lockEntries locks the entries older than LOCK_AFTER_DAYS.
+func (s *Server) lockEntries(ctx context.Context) error {
+	days := s.config.GetInt(LOCK_AFTER_DAYS)
+	if days <= 0 {
+		return nil
+	}
+	n, err := s.entryDB.LockBefore(ctx, time.Now().AddDate(0, 0, -days))
+	if n > 0 {
+		s.pageCache.Clear()
+	}
+	if err != nil {
+		return err
+	}
+	s.log.Infof("Locked %d entries.", n)
+	return nil
+}
+
+func (s *Server) startLockEntries() {
+	if s.config.GetInt(LOCK_AFTER_DAYS) <= 0 {
+		return
+	}
+	s.addJob("lock", every(24*time.Hour), s.lockEntries)
+}
+
+func (s *Server) startInteractions() {
+	s.addJob("interactions", every(24*time.Hour), s.recountInteractions)
+}
+
+// backfillPhotos flags the entries with photos that were stored without
+// HasPhotos, so they show up on /photos.
+func (s *Server) backfillPhotos(ctx context.Context) error {
+	n, err := s.entryDB.BackfillPhotos(ctx)
+	if n > 0 {
+		s.entriesChanged()
+	}
+	if err != nil {
+		return err
+	}
+	s.log.Infof("Backfilled photos on %d entries.", n)
+	return nil
+}
+
+// startBackfillPhotos runs backfillPhotos weekly, it only writes to entries
+// that need it, and it can be run right away from /admin/jobs after
+// restoring an older backup.
+func (s *Server) startBackfillPhotos() {
+	s.addJob("photos", every(7*24*time.Hour), s.backfillPhotos)
+}
+
+// websubCallbackHandler receives verifications and content from the hubs of
+// the BACKFEED_FEEDS. Pushed items that link to entries are received as
+// webmentions, so they are verified like any other.
+func (s *Server) websubCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if r.Method == "GET" {
+		challenge, err := s.websubDB.Verify(r.Context(), id, r.URL.Query())
+		if err != nil {
+			s.log.Infof("Rejected WebSub verification: %s", err)
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, challenge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, safefetch.MAX_BODY)
+	sub, body, err := s.websubDB.Receive(r.Context(), id, r)
+	if err != nil {
+		// Hubs are told the content was received either way, so that they
+		// don't retry content that will never validate.
+		s.log.Warningf("Dropped WebSub content: %s", err)
+		return
+	}
+	received := 0
+	for _, l := range backfeed.FeedLinks(body, sub.Topic, s.permalinkFromId("")) {
+		entry, err := s.entryDB.Get(r.Context(), s.entryIDFromTarget(l.Target))
+		if err != nil || entry.Visibility == entries.PRIVATE {
+			continue
+		}
+		received++
+		s.taskQueue.Go(RECEIVE_TASK, receiveTask{ID: entry.ID, Source: l.Source, Target: l.Target})
+	}
+	s.log.Infof("Received %d interactions from %q", received, sub.Topic)
+}
+
+// renewWebSub subscribes to the BACKFEED_FEEDS that aren't subscribed to,
+// renews leases that are about to expire, and unsubscribes from feeds that
+// are no longer configured.
+func (s *Server) renewWebSub(ctx context.Context) error {
+	list, err := s.websubDB.List(ctx)
+	if err != nil {
+		return err
+	}
+	existing := map[string]*websub.Subscription{}
+	for _, sub := range list {
+		existing[sub.ID] = sub
+	}
+	now := time.Now()
+	for _, topic := range s.config.GetStringSlice(BACKFEED_FEEDS) {
+		id := websub.ID(topic)
+		sub, ok := existing[id]
+		delete(existing, id)
+		if ok && sub.State != websub.UNSUBSCRIBING && !sub.Due(now) {
+			continue
+		}
+		if _, err := s.websubDB.Subscribe(ctx, topic); err != nil {
+			s.log.Warningf("Failed to subscribe: %s", err)
+		}
+	}
+	for id, sub := range existing {
+		if sub.State == websub.UNSUBSCRIBING && now.Sub(sub.Requested) < websub.RETRY_AFTER {
+			continue
+		}
+		if err := s.websubDB.Unsubscribe(ctx, id); err != nil {
+			s.log.Warningf("Failed to unsubscribe: %s", err)
+		}
+	}
+	return nil
+}
+
+// startWebSubRenewals keeps the subscriptions to the BACKFEED_FEEDS current.
+func (s *Server) startWebSubRenewals() {
+	if *dryRunOutbound {
+		s.log.Infof("Dry run: not subscribing to %s.", BACKFEED_FEEDS)
+		return
+	}
+	s.addJob("websub", "@hourly", s.renewWebSub)
+	// Subscribe to newly added feeds now instead of at the top of the hour.
+	go s.jobs.Run(context.Background(), "websub")
+}
+
+type moderationContext struct {
+	Config   map[string]interface{}
+	Mentions []*mentions.Mention
+
+	// Trusted are the domains trusted from here, and ConfigTrusted the
+	// VOUCH_DOMAINS from config.json, which can't be edited here.
+	Trusted       []*trusted.Domain
+	ConfigTrusted []string
+}
+
+// adminModerationHandler lists mentions awaiting moderation and approves or
+// deletes them. Approving a mention can also trust its domain, so later
+// webmentions from there skip moderation.
+func (s *Server) adminModerationHandler(w http.ResponseWriter, r *http.Request) {
+	if *local {
+		s.loadTemplates()
+	}
+	if !s.isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method == "POST" && r.FormValue("action") == "untrust" {
+		if err := s.trustDB.Remove(r.Context(), r.FormValue("domain")); err != nil {
+			s.log.Errorf("%s", err)
+			http.Error(w, "Failed to remove trusted domain.", http.StatusInternalServerError)
+			return
+		}
+	} else if r.Method == "POST" {
+		mention, err := s.mentionDB.Get(r.Context(), r.FormValue("id"))
+		if err != nil {
+			s.log.Errorf("%s", err)
+			http.Error(w, "Mention not found.", http.StatusNotFound)
+			return
+		}
+		switch action := r.FormValue("action"); action {
+		case "approve", "trust":
+			if err := s.mentionDB.Approve(r.Context(), mention.ID); err != nil {
+				s.log.Errorf("%s", err)
+				http.Error(w, "Failed to approve.", http.StatusInternalServerError)
+				return
+			}
+			s.notifyMentionApproved(mention)
+			if action == "trust" {
+				if err := s.trustDB.Add(r.Context(), receiver.Domain(mention.Source)); err != nil {
+					s.log.Errorf("%s", err)
+					http.Error(w, "Failed to trust domain.", http.StatusInternalServerError)
+					return
+				}
+			}
+		case "delete":
+			if err := s.mentionDB.Delete(r.Context(), mention.ID); err != nil {
+				s.log.Errorf("Failed to delete mention: %s", err)
+				http.Error(w, "Failed to delete.", http.StatusInternalServerError)
+				return
+			}
+		default:
+			http.Error(w, "POST request failed to include action.", http.StatusBadRequest)
+			return
+		}
+		s.updateInteractions(r.Context(), mention.EntryID)
+		s.pageCache.Clear()
+	}
+	c := &moderationContext{
+		Config:        s.config.AllSettings(),
+		ConfigTrusted: s.trustDB.Config(),
+	}
+	var err error
+	c.Mentions, err = s.mentionDB.Pending(r.Context())
+	if err != nil {
+		s.log.Warningf("Failed to get pending mentions: %s", err)
+	}
+	c.Trusted, err = s.trustDB.List(r.Context())
+	if err != nil {
+		s.log.Warningf("Failed to get trusted domains: %s", err)
+	}
+	w.Header().Set("Content-Type", "text/html")
+	s.render(w, r, s.templates, "adminModeration.html", c)
+}
+
+type blocksContext struct {
+	Config map[string]interface{}
+	Blocks []*blocklist.Block
+
+	// ConfigBlocks are the patterns from config.json, which can't be edited
+	// here.
+	ConfigBlocks []string
+}
+
+func (s *Server) adminBlocksHandler(w http.ResponseWriter, r *http.Request) {
+	if *local {
+		s.loadTemplates()
+	}
+	if !s.isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method == "POST" {
+		switch r.FormValue("action") {
+		case "add":
+			if err := s.blockDB.Add(r.Context(), r.FormValue("pattern"), r.FormValue("reason")); err != nil {
+				s.log.Errorf("Failed to add block: %s", err)
+				http.Error(w, "Failed to add block.", http.StatusInternalServerError)
+				return
+			}
+		case "remove":
+			if err := s.blockDB.Remove(r.Context(), r.FormValue("pattern")); err != nil {
+				s.log.Errorf("Failed to remove block: %s", err)
+				http.Error(w, "Failed to remove block.", http.StatusInternalServerError)
+				return
+			}
+		default:
+			http.Error(w, "POST request failed to include action.", http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "/admin/blocks", 302)
+		return
+	}
+	c := &blocksContext{
+		Config:       s.config.AllSettings(),
+		ConfigBlocks: s.blockDB.Config(),
+	}
+	var err error
+	c.Blocks, err = s.blockDB.List(r.Context())
+	if err != nil {
+		s.log.Warningf("Failed to get blocks: %s", err)
+	}
+	w.Header().Set("Content-Type", "text/html")
+	s.render(w, r, s.templates, "adminBlocks.html", c)
+}
+
+// shortURL returns the absolute short URL for a code.
+func (s *Server) shortURL(code string) string {
+	return fmt.Sprintf("%s/s/%s", s.config.GetString(HOST), code)
+}
+
+// shortURLHandler redirects a short URL to its entry's permalink.
+func (s *Server) shortURLHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := s.shortDB.Resolve(r.Context(), mux.Vars(r)["code"])
+	if err != nil {
+		s.renderError(w, r, http.StatusNotFound)
+		return
+	}
+	http.Redirect(w, r, s.permalinkFromId(id), http.StatusMovedPermanently)
+}
+
+// mediaItem is a Media along with the entries that use it.
+type mediaItem struct {
+	*media.Media
+	EntryIDs []string
+
+	// Static is true for images used by the site itself, such as icons.
+	Static bool
+}
+
+// isStaticImage returns true if the image at path p, relative to /images/,
+// is used by the site itself.
+func isStaticImage(p string) bool {
+	for _, asset := range precacheAssets {
+		if asset.URL == "/images/"+p {
+			return true
+		}
+	}
+	return false
+}
+
+type mediaContext struct {
+	Config map[string]interface{}
+	Media  []*mediaItem
+}
+
+// adminMediaHandler lists the images, audio, and video in the media library
+// along with the entries that use them, and allows uploading files, editing
+// alt text, and deleting files.
+func (s *Server) adminMediaHandler(w http.ResponseWriter, r *http.Request) {
+	if *local {
+		s.loadTemplates()
+	}
+	if !s.isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	usage := map[string][]string{}
+	err := s.entryDB.All(r.Context(), func(entry *entries.Entry) error {
+		refs := media.References(entry.Content)
+		for _, u := range []string{entry.MediaURL, entry.Poster} {
+			if strings.HasPrefix(u, "/images/") {
+				refs = append(refs, strings.TrimPrefix(u, "/images/"))
+			}
+		}
+		for _, p := range refs {
+			usage[p] = append(usage[p], entry.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		s.log.Errorf("Failed to find media usage: %s", err)
+		http.Error(w, "Failed to find media usage.", http.StatusInternalServerError)
+		return
+	}
+	if r.Method == "POST" {
+		p := r.FormValue("path")
+		switch r.FormValue("action") {
+		case "alt":
+			if err := s.mediaDB.SetAlt(r.Context(), p, r.FormValue("alt")); err != nil {
+				s.log.Errorf("Failed to set alt text: %s", err)
+				http.Error(w, "Failed to set alt text.", http.StatusInternalServerError)
+				return
+			}
+			s.entriesChanged()
+		case "upload":
+			f, header, err := r.FormFile("file")
+			if limits.TooLarge(err) {
+				http.Error(w, "Upload too large.", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				http.Error(w, "POST request failed to include a file.", http.StatusBadRequest)
+				return
+			}
+			defer f.Close()
+			if _, err := s.mediaDB.Upload(r.Context(), header.Filename, f); err != nil {
+				s.log.Errorf("Failed to upload media: %s", err)
+				http.Error(w, "Failed to upload media.", http.StatusInternalServerError)
+				return
+			}
+		case "delete":
+			if len(usage[p]) > 0 || isStaticImage(p) {
+				http.Error(w, "Media is still used by entries.", http.StatusConflict)
+				return
+			}
+			if err := s.mediaDB.Delete(r.Context(), p); err != nil {
+				s.log.Errorf("Failed to delete media: %s", err)
+				http.Error(w, "Failed to delete media.", http.StatusInternalServerError)
+				return
+			}
+		default:
+			http.Error(w, "POST request failed to include action.", http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "/admin/media", 302)
+		return
+	}
+	if err := s.mediaDB.Sync(r.Context()); err != nil {
+		s.log.Warningf("Failed to sync media: %s", err)
+	}
+	all, err := s.mediaDB.List(r.Context())
+	if err != nil {
+		s.log.Warningf("Failed to list media: %s", err)
+	}
+	c := &mediaContext{
+		Config: s.config.AllSettings(),
+		Media:  []*mediaItem{},
+	}
+	for _, m := range all {
+		c.Media = append(c.Media, &mediaItem{Media: m, EntryIDs: usage[m.Path], Static: isStaticImage(m.Path)})
+	}
+	w.Header().Set("Content-Type", "text/html")
+	s.render(w, r, s.templates, "adminMedia.html", c)
+}
+
+type webmentionsContext struct {
+	Config map[string]interface{}
+
+	// EntryID is the entry whose history is displayed, or "" for the most
+	// recent attempts for all entries.
+	EntryID  string
+	Attempts []*outbox.Attempt
+	Message  string
+}
+
+// WEBMENTIONS_RECENT is the number of attempts displayed on
+// /admin/webmentions when not looking at a single entry.
+const WEBMENTIONS_RECENT = 100
+
+// adminWebmentionsHandler displays the history of webmentions sent, either
+// recently or for one entry, and resends them.
+func (s *Server) adminWebmentionsHandler(w http.ResponseWriter, r *http.Request) {
+	if *local {
+		s.loadTemplates()
+	}
+	if !s.isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	c := &webmentionsContext{
+		Config:  s.config.AllSettings(),
+		EntryID: r.FormValue("entry"),
+	}
+	if r.Method == "POST" {
+		switch r.FormValue("action") {
+		case "resend":
+			entry, err := s.entryDB.Get(r.Context(), c.EntryID)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			if entry.Visibility == entries.PRIVATE {
+				http.Error(w, "Webmentions aren't sent for private entries.", http.StatusBadRequest)
+				return
+			}
+			c.Message = "Webmention sent."
+			if *dryRunOutbound {
+				c.Message = "Dry run, webmention recorded but not sent."
+			}
+			if err := s.resendWebMention(entry.ID, r.FormValue("target")); err != nil {
+				c.Message = fmt.Sprintf("Failed to send webmention: %s", err)
+			}
+		default:
+			http.Error(w, "POST request failed to include action.", http.StatusBadRequest)
+			return
+		}
+	}
+	var err error
+	if c.EntryID != "" {
+		c.Attempts, err = s.outboxDB.ForEntry(r.Context(), c.EntryID)
+	} else {
+		c.Attempts, err = s.outboxDB.Recent(r.Context(), WEBMENTIONS_RECENT)
+	}
+	if err != nil {
+		s.log.Warningf("Failed to get webmention attempts: %s", err)
+	}
+	w.Header().Set("Content-Type", "text/html")
+	s.render(w, r, s.templates, "adminWebmentions.html", c)
+}
+
+// SYNDICATION_RECENT is the number of entries displayed on
+// /admin/syndication.
+const SYNDICATION_RECENT = 50
+
+// bridgeStatus is the newest attempt to syndicate an entry to one of the
+// BRIDGES, or nil if it wasn't sent there.
+type bridgeStatus struct {
+	Target  string
+	Attempt *outbox.Attempt
+}
+
+// syndicationRow is one entry on /admin/syndication.
+type syndicationRow struct {
+	Entry   *entries.Entry
+	Bridges []bridgeStatus
+	Status  *outbox.Status
+}
+
+type syndicationContext struct {
+	Config  map[string]interface{}
+	Rows    []*syndicationRow
+	Message string
+}
+
+// adminSyndicationHandler displays, for each recent entry, where it was
+// syndicated, the bridges and WebSub hub it was sent to, and how its
+// webmentions went, and sends any of them again.
+func (s *Server) adminSyndicationHandler(w http.ResponseWriter, r *http.Request) {
+	if *local {
+		s.loadTemplates()
+	}
+	if !s.isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	c := &syndicationContext{
+		Config: s.config.AllSettings(),
+		Rows:   []*syndicationRow{},
+	}
+	if r.Method == "POST" {
+		entry, err := s.entryDB.Get(r.Context(), r.FormValue("entry"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if entry.Visibility == entries.PRIVATE {
+			http.Error(w, "Private entries aren't syndicated.", http.StatusBadRequest)
+			return
+		}
+		switch r.FormValue("action") {
+		case "resend":
+			c.Message = fmt.Sprintf("Sent %s to %s.", entry.ID, r.FormValue("target"))
+			if err := s.resendWebMention(entry.ID, r.FormValue("target")); err != nil {
+				c.Message = fmt.Sprintf("Failed to send %s to %s: %s", entry.ID, r.FormValue("target"), err)
+			}
+		case "websub":
+			s.pingWebSub(entry.ID)
+			c.Message = fmt.Sprintf("Pinged the WebSub hub for %s.", entry.ID)
+		case "retry":
+			attempts, err := s.outboxDB.ForEntry(r.Context(), entry.ID)
+			if err != nil {
+				s.log.Errorf("Failed to get webmention attempts: %s", err)
+				http.Error(w, "Failed to get webmention attempts.", http.StatusInternalServerError)
+				return
+			}
+			failed := 0
+			for _, a := range outbox.Summarize(attempts, s.config.GetStringSlice(BRIDGES))[entry.ID].Webmentions {
+				if a.OK() || a.DryRun {
+					continue
+				}
+				failed++
+				if err := s.resendWebMention(entry.ID, a.Target); err != nil {
+					s.log.Infof("Retry failed: %s", err)
+				}
+			}
+			c.Message = fmt.Sprintf("Retried %d webmentions for %s.", failed, entry.ID)
+		default:
+			http.Error(w, "POST request failed to include action.", http.StatusBadRequest)
+			return
+		}
+		if *dryRunOutbound {
+			c.Message += " Dry run, recorded but not sent."
+		}
+	}
+	list, err := s.entryDB.List(r.Context(), SYNDICATION_RECENT, 0)
+	if err != nil {
+		s.log.Warningf("Failed to get entries: %s", err)
+	}
+	var statuses map[string]*outbox.Status
+	if len(list) > 0 {
+		// Every attempt for an entry is made after it was created.
+		attempts, err := s.outboxDB.Since(r.Context(), list[len(list)-1].Created)
+		if err != nil {
+			s.log.Warningf("Failed to get webmention attempts: %s", err)
+		}
+		statuses = outbox.Summarize(attempts, s.config.GetStringSlice(BRIDGES))
+	}
+	for _, entry := range list {
+		status, ok := statuses[entry.ID]
+		if !ok {
+			status = &outbox.Status{}
+		}
+		row := &syndicationRow{
+			Entry:  entry,
+			Status: status,
+		}
+		for _, b := range s.config.GetStringSlice(BRIDGES) {
+			row.Bridges = append(row.Bridges, bridgeStatus{Target: b, Attempt: status.Bridges[b]})
+		}
+		c.Rows = append(c.Rows, row)
+	}
+	w.Header().Set("Content-Type", "text/html")
+	s.render(w, r, s.templates, "adminSyndication.html", c)
+}
+
+// featureConfigured returns if the named feature is on in the config, which
+// it is unless FEATURES turns it off.
+func (s *Server) featureConfigured(name string) bool {
+	key := FEATURES + "." + name
+	if !s.config.IsSet(key) {
+		return true
+	}
+	return s.config.GetBool(key)
+}
+
+// featureStatus is a feature as displayed on the admin features page.
+type featureStatus struct {
+	features.Feature
+	Configured bool
+	Overridden bool
+	Enabled    bool
+}
+
+type featuresContext struct {
+	Config   map[string]interface{}
+	Features []featureStatus
+}
+
+// adminFeaturesHandler displays the features and turns them on and off.
+func (s *Server) adminFeaturesHandler(w http.ResponseWriter, r *http.Request) {
+	if *local {
+		s.loadTemplates()
+	}
+	if !s.isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method == "POST" {
+		var err error
+		switch r.FormValue("action") {
+		case "on":
+			err = s.featureDB.Set(r.Context(), r.FormValue("name"), true)
+		case "off":
+			err = s.featureDB.Set(r.Context(), r.FormValue("name"), false)
+		case "default":
+			err = s.featureDB.Clear(r.Context(), r.FormValue("name"))
+		default:
+			http.Error(w, "POST request failed to include action.", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			s.log.Errorf("Failed to change feature: %s", err)
+			http.Error(w, "Failed to change feature.", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/admin/features", http.StatusFound)
+		return
+	}
+	c := &featuresContext{
+		Config: s.config.AllSettings(),
+	}
+	overrides, err := s.featureDB.Overrides(r.Context())
+	if err != nil {
+		s.log.Warningf("Failed to get feature overrides: %s", err)
+	}
+	for _, f := range features.All {
+		override, ok := overrides[f.Name]
+		status := featureStatus{
+			Feature:    f,
+			Configured: s.featureDB.Configured(f.Name),
+			Overridden: ok,
+		}
+		status.Enabled = status.Configured
+		if ok {
+			status.Enabled = override
+		}
+		c.Features = append(c.Features, status)
+	}
+	w.Header().Set("Content-Type", "text/html")
+	s.render(w, r, s.templates, "adminFeatures.html", c)
+}
+
+// every returns the schedule of a job that runs every d.
+func every(d time.Duration) string {
+	return "@every " + d.String()
+}
+
+// addJob adds the job name that runs f, on its schedule from SCHEDULE if set
+// there, otherwise on spec.
+func (s *Server) addJob(name, spec string, f scheduler.Func) {
+	if v := s.config.GetString(SCHEDULE + "." + name); v != "" {
+		spec = v
+	}
+	if err := s.jobs.Add(name, spec, f); err != nil {
+		s.log.Fatal(err)
+	}
+}
+
+// startMirrorRefresh periodically clears the caches of a MIRROR, which
+// doesn't see the changes made on the primary.
+func (s *Server) startMirrorRefresh() {
+	s.config.SetDefault(MIRROR_REFRESH_MINUTES, 5)
+	s.addJob("mirror", every(time.Duration(s.config.GetInt(MIRROR_REFRESH_MINUTES))*time.Minute), func(ctx context.Context) error {
+		s.entriesChanged()
+		return nil
+	})
+}
+
+// startJobs runs the jobs added by the start functions.
+func (s *Server) startJobs() {
+	for name := range s.config.GetStringMapString(SCHEDULE) {
+		if !s.jobs.Has(name) {
+			s.log.Warningf("%s.%s isn't a job, or the job is turned off.", SCHEDULE, name)
+		}
+	}
+	s.jobs.Start(context.Background())
+}
+
+type jobsContext struct {
+	Config  map[string]interface{}
+	Jobs    []scheduler.Status
+	Message string
+}
+
+// adminJobsHandler displays the periodic jobs and their last runs, and starts
+// them on demand.
+func (s *Server) adminJobsHandler(w http.ResponseWriter, r *http.Request) {
+	if *local {
+		s.loadTemplates()
+	}
+	if !s.isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	c := &jobsContext{
+		Config: s.config.AllSettings(),
+	}
+	if r.Method == "POST" {
+		if r.FormValue("action") != "run" {
+			http.Error(w, "POST request failed to include action.", http.StatusBadRequest)
+			return
+		}
+		name := r.FormValue("name")
+		if !s.jobs.Has(name) {
+			http.NotFound(w, r)
+			return
+		}
+		// Jobs such as backups can outlast the request, so they are run in
+		// the background and their result shows up here once done.
+		go s.jobs.Run(context.Background(), name)
+		c.Message = fmt.Sprintf("Started %s.", name)
+	}
+	c.Jobs = s.jobs.Status()
+	w.Header().Set("Content-Type", "text/html")
+	s.render(w, r, s.templates, "adminJobs.html", c)
+}
+
+// labelHandler attributes the Datastore reads done while handling a request
+// to its route, for /admin/debug/datastore.
+func labelHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path
+		if route := mux.CurrentRoute(r); route != nil {
+			if t, err := route.GetPathTemplate(); err == nil {
+				name = t
+			}
+		}
+		h.ServeHTTP(w, r.WithContext(entries.WithHandler(r.Context(), r.Method+" "+name)))
+	})
+}
+
+type datastoreContext struct {
+	Config map[string]interface{}
+	Kinds  []*entries.KindCount
+	Ops    []entries.OpStats
+	Since  time.Time
+
+	// Reads and Cost are the totals of Ops.
+	Reads int64
+	Cost  float64
+}
+
+// adminDatastoreHandler reports the number of entities of each kind, and the
+// latency and estimated cost of the reads done by each handler, to help
+// decide where caching or composite indexes are worth adding.
+func (s *Server) adminDatastoreHandler(w http.ResponseWriter, r *http.Request) {
+	if *local {
+		s.loadTemplates()
+	}
+	if !s.isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method == "POST" {
+		switch r.FormValue("action") {
+		case "reset":
+			s.entryDB.ResetStats()
+		default:
+			http.Error(w, "POST request failed to include action.", http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "/admin/debug/datastore", http.StatusFound)
+		return
+	}
+	c := &datastoreContext{
+		Config: s.config.AllSettings(),
+	}
+	var err error
+	c.Kinds, err = s.entryDB.KindCounts(r.Context())
+	if err != nil {
+		s.log.Warningf("Failed to get kind counts: %s", err)
+	}
+	c.Ops, c.Since = s.entryDB.Stats()
+	for _, o := range c.Ops {
+		c.Reads += o.Reads
+		c.Cost += o.Cost()
+	}
+	w.Header().Set("Content-Type", "text/html")
+	s.render(w, r, s.templates, "adminDatastore.html", c)
+}
+
+type statsContext struct {
+	Config    map[string]interface{}
+	ShortURLs []*shorturl.ShortURL
+
+	// Referrers are the sites that linked to the stream in the last
+	// REFERRER_DAYS days.
+	Referrers []*referrers.Referrer
+}
+
+// REFERRER_DAYS is the number of days of referrers displayed on the stats
+// page.
+const REFERRER_DAYS = 30
+
+// REFERRER_FLUSH is how often referrer counts are written to Datastore.
+const REFERRER_FLUSH = 5 * time.Minute
+
+// startReferrerFlush periodically writes the counted referrers.
+func (s *Server) startReferrerFlush() {
+	s.addJob("referrers", every(REFERRER_FLUSH), s.referrerDB.Flush)
+}
+
+// adminStatsHandler displays the most clicked short URLs and the sites that
+// link to the stream.
+func (s *Server) adminStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if *local {
+		s.loadTemplates()
+	}
+	if !s.isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	c := &statsContext{
+		Config: s.config.AllSettings(),
+	}
+	var err error
+	c.ShortURLs, err = s.shortDB.Top(r.Context(), 50)
+	if err != nil {
+		s.log.Warningf("Failed to get short URLs: %s", err)
+	}
+	c.Referrers, err = s.referrerDB.Since(r.Context(), time.Now().AddDate(0, 0, -REFERRER_DAYS))
+	if err != nil {
+		s.log.Warningf("Failed to get referrers: %s", err)
+	}
+	w.Header().Set("Content-Type", "text/html")
+	s.render(w, r, s.templates, "adminStats.html", c)
+}
+
+type linkrotContext struct {
+	Config  map[string]interface{}
+	Links   []*linkrot.Link
+	Message string
+}
+
+// adminLinkrotHandler reports dead links, and allows looking up archive.org
+// snapshots of them or starting a check of all links.
+func (s *Server) adminLinkrotHandler(w http.ResponseWriter, r *http.Request) {
+	if *local {
+		s.loadTemplates()
+	}
+	if !s.isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	c := &linkrotContext{
+		Config: s.config.AllSettings(),
+	}
+	if r.Method == "POST" {
+		switch r.FormValue("action") {
+		case "check":
+			go func() {
+				if err := s.runLinkChecks(context.Background()); err != nil {
+					s.log.Warningf("Link check failed: %s", err)
+				}
+			}()
+			c.Message = "Checking links, reload this page in a few minutes."
+		case "archive":
+			if err := s.linkDB.Archive(r.Context(), linkrotClient, r.FormValue("url")); err != nil {
+				c.Message = err.Error()
+			} else {
+				s.entriesChanged()
+			}
+		default:
+			http.Error(w, "POST request failed to include action.", http.StatusBadRequest)
+			return
+		}
+	}
+	var err error
+	c.Links, err = s.linkDB.Dead(r.Context())
+	if err != nil {
+		s.log.Warningf("Failed to get dead links: %s", err)
+	}
+	w.Header().Set("Content-Type", "text/html")
+	s.render(w, r, s.templates, "adminLinkrot.html", c)
+}
+
+// adminOnly wraps h so that it is only available to admins.
+func (s *Server) adminOnly(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.isAdmin(r) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// traceRequests records each request in the traces shown at
+// /debug/requests.
+func traceRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tr := trace.New("http", r.URL.Path)
+		defer tr.Finish()
+		tr.LazyPrintf("%s %s", r.Method, r.URL)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// requestsHandler displays recent and long running requests.
+func requestsHandler(w http.ResponseWriter, r *http.Request) {
+	trace.Render(w, r, true)
+}
+
+// isAdmin returns true if r is part of an admin session.
+func (s *Server) isAdmin(r *http.Request) bool {
+	c, err := r.Cookie(sessions.COOKIE)
+	if err != nil {
+		return false
+	}
+	_, err = s.sessionDB.Get(r.Context(), c.Value)
+	return err == nil
+}
+
+// setSessionCookie sets the session cookie to value, or clears it if value
+// is empty.
+func (s *Server) setSessionCookie(w http.ResponseWriter, value string) {
+	c := &http.Cookie{
+		Name:     sessions.COOKIE,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(s.sessionDB.Lifetime().Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(s.config.GetString(HOST), "https:"),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if value == "" {
+		c.MaxAge = -1
+	}
+	http.SetCookie(w, c)
+}
+
+// sessionHandler starts an admin session once the authenticator has checked
+// who is signing in, sending them off to sign in first if needed.
+func (s *Server) sessionHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.ad.IsAdmin(r, s.log) {
+		if _, ok := s.ad.(auth.Login); ok && r.Method == "GET" {
+			http.Redirect(w, r, "/auth/login", http.StatusFound)
+			return
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	value, _, err := s.sessionDB.Create(r.Context(), r.UserAgent())
+	if err != nil {
+		s.log.Errorf("Failed to start session: %s", err)
+		http.Error(w, "Failed to start session.", http.StatusInternalServerError)
+		return
+	}
+	s.setSessionCookie(w, value)
+	if r.Method == "POST" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Redirect(w, r, "/admin", http.StatusFound)
+}
+
+// logoutHandler ends the current admin session.
+func (s *Server) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessions.COOKIE); err == nil {
+		if id := s.sessionDB.ID(c.Value); id != "" {
+			if err := s.sessionDB.Revoke(r.Context(), id); err != nil {
+				s.log.Warningf("Failed to end session: %s", err)
+			}
+		}
+	}
+	s.setSessionCookie(w, "")
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+type sessionsContext struct {
+	Config   map[string]interface{}
+	Sessions []*sessions.Session
+
+	// Current is the ID of the session viewing the page.
+	Current string
+}
+
+// adminSessionsHandler lists and revokes admin sessions.
+func (s *Server) adminSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if *local {
+		s.loadTemplates()
+	}
+	if !s.isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	c := &sessionsContext{
+		Config: s.config.AllSettings(),
+	}
+	if cookie, err := r.Cookie(sessions.COOKIE); err == nil {
+		c.Current = s.sessionDB.ID(cookie.Value)
+	}
+	if r.Method == "POST" {
+		switch r.FormValue("action") {
+		case "revoke":
+			if err := s.sessionDB.Revoke(r.Context(), r.FormValue("id")); err != nil {
+				s.log.Errorf("Failed to revoke session: %s", err)
+				http.Error(w, "Failed to revoke session.", http.StatusInternalServerError)
+				return
+			}
+		case "revoke_others":
+			all, err := s.sessionDB.List(r.Context())
+			if err != nil {
+				http.Error(w, "Failed to list sessions.", http.StatusInternalServerError)
+				return
+			}
+			for _, session := range all {
+				if session.ID == c.Current {
+					continue
+				}
+				if err := s.sessionDB.Revoke(r.Context(), session.ID); err != nil {
+					s.log.Errorf("Failed to revoke session: %s", err)
+					http.Error(w, "Failed to revoke session.", http.StatusInternalServerError)
+					return
+				}
+			}
+		default:
+			http.Error(w, "POST request failed to include action.", http.StatusBadRequest)
+			return
+		}
+	}
+	var err error
+	c.Sessions, err = s.sessionDB.List(r.Context())
+	if err != nil {
+		s.log.Warningf("Failed to get sessions: %s", err)
+	}
+	w.Header().Set("Content-Type", "text/html")
+	s.render(w, r, s.templates, "adminSessions.html", c)
+}
+
+type tokensContext struct {
+	Config map[string]interface{}
+	Tokens []*tokens.Token
+
+	// NewToken is the value of a just created token, which is only displayed
+	// once.
+	NewToken string
+	NewScope tokens.Scope
+}
+
+// adminUndeleteHandler restores a deleted entry that hasn't been purged yet.
+func (s *Server) adminUndeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id := r.FormValue("id")
+	if err := s.entryDB.RestoreDeleted(r.Context(), id); err != nil {
+		s.log.Warningf("Failed to undo delete: %s", err)
+		s.setFlash(w, &flash{Message: "The entry was already purged and can't be restored."})
+		http.Redirect(w, r, "/admin", 302)
+		return
+	}
+	s.entriesChanged()
+	http.Redirect(w, r, "/admin/edit/"+id, 302)
+}
+
+// startPurgeDeleted periodically purges entries that were deleted more than
+// UNDO_DELETE_MINUTES ago.
+func (s *Server) startPurgeDeleted() {
+	s.config.SetDefault(UNDO_DELETE_MINUTES, 60)
+	s.addJob("purge", "* * * * *", func(ctx context.Context) error {
+		n, err := s.entryDB.PurgeDeleted(ctx, time.Now().Add(-time.Duration(s.config.GetInt(UNDO_DELETE_MINUTES))*time.Minute))
+		if err != nil {
+			return fmt.Errorf("Failed to purge deleted entries: %s", err)
+		}
+		if n > 0 {
+			s.log.Infof("Purged %d deleted entries", n)
+		}
+		return nil
+	})
+}
+
+type snippetsContext struct {
+	Config   map[string]interface{}
+	Snippets []*snippets.Snippet
+
+	// Current is the snippet being edited, if any.
+	Current *snippets.Snippet
+}
+
+// adminSnippetsHandler lists, saves, and deletes snippets.
+func (s *Server) adminSnippetsHandler(w http.ResponseWriter, r *http.Request) {
+	if *local {
+		s.loadTemplates()
+	}
+	if !s.isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	c := &snippetsContext{
+		Config:  s.config.AllSettings(),
+		Current: &snippets.Snippet{},
+	}
+	if r.Method == "POST" {
+		switch r.FormValue("action") {
+		case "save":
+			snippet := &snippets.Snippet{
+				Name:    r.FormValue("name"),
+				Title:   r.FormValue("title"),
+				Content: r.FormValue("content"),
+			}
+			if err := s.snippetDB.Put(r.Context(), snippet); err != nil {
+				s.log.Warningf("Failed to save snippet: %s", err)
+				http.Error(w, "Failed to save snippet.", http.StatusBadRequest)
+				return
+			}
+		case "delete":
+			if err := s.snippetDB.Delete(r.Context(), r.FormValue("name")); err != nil {
+				http.Error(w, "Failed to delete snippet.", http.StatusInternalServerError)
+				return
+			}
+		default:
+			http.Error(w, "POST request failed to include action.", http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "/admin/snippets", 302)
+		return
+	}
+	if name := r.FormValue("name"); name != "" {
+		snippet, err := s.snippetDB.Get(r.Context(), name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		c.Current = snippet
+	}
+	var err error
+	c.Snippets, err = s.snippetDB.List(r.Context())
+	if err != nil {
+		s.log.Warningf("Failed to get snippets: %s", err)
+	}
+	w.Header().Set("Content-Type", "text/html")
+	s.render(w, r, s.templates, "adminSnippets.html", c)
+}
+
+// adminTokensHandler lists, creates, and revokes private feed tokens.
+func (s *Server) adminTokensHandler(w http.ResponseWriter, r *http.Request) {
+	if *local {
+		s.loadTemplates()
+	}
+	if !s.isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	c := &tokensContext{
+		Config: s.config.AllSettings(),
+	}
+	if r.Method == "POST" {
+		switch r.FormValue("action") {
+		case "create":
+			scope := tokens.ToScope(r.FormValue("scope"))
+			days, _ := strconv.Atoi(r.FormValue("days"))
+			value, err := s.tokenDB.Create(r.Context(), r.FormValue("label"), scope, time.Duration(days)*24*time.Hour)
+			if err != nil {
+				s.log.Errorf("Failed to create token: %s", err)
+				http.Error(w, "Failed to create token.", http.StatusInternalServerError)
+				return
+			}
+			c.NewToken = value
+			c.NewScope = scope
+		case "revoke":
+			if err := s.tokenDB.Revoke(r.Context(), r.FormValue("id")); err != nil {
+				http.Error(w, "Failed to revoke token.", http.StatusInternalServerError)
+				return
+			}
+		default:
+			http.Error(w, "POST request failed to include action.", http.StatusBadRequest)
+			return
+		}
+	}
+	var err error
+	c.Tokens, err = s.tokenDB.List(r.Context())
+	if err != nil {
+		s.log.Warningf("Failed to get tokens: %s", err)
+	}
+	w.Header().Set("Content-Type", "text/html")
+	s.render(w, r, s.templates, "adminTokens.html", c)
+}
+
+// runBackfeed fetches interactions with the syndicated copies of recent entries
+// and stores them as mentions, notifying the admin of new ones.
+func (s *Server) runBackfeed(ctx context.Context, m *backfeed.Mastodon) error {
+	recent, err := s.entryDB.List(ctx, s.config.GetInt(BACKFEED_ENTRIES), 0)
+	if err != nil {
+		return err
+	}
+	for _, entry := range recent {
+		if entry.Locked {
+			continue
+		}
+		for _, u := range entry.Syndication {
+			if _, _, err := backfeed.ParseStatusURL(u); err != nil {
+				continue
+			}
+			found, err := m.Fetch(ctx, entry.ID, u)
+			if err != nil {
+				s.log.Warningf("Failed to backfeed %q: %s", u, err)
+				continue
+			}
+			received := false
+			for _, mention := range found {
+				if s.blockDB.Blocked(mention.Source, mention.AuthorURL) {
+					continue
+				}
+				isNew, err := s.mentionDB.Put(ctx, mention)
+				if err != nil {
+					s.log.Warningf("%s", err)
+					continue
+				}
+				if isNew {
+					received = true
+					s.pageCache.Clear()
+					s.notifyMentionReceived(mention)
+				}
+			}
+			if received {
+				s.updateInteractions(ctx, entry.ID)
+				s.sendSalmentions(entry)
+			}
+		}
+	}
+	return nil
+}
+
+// startBackfeed periodically polls for interactions with syndicated entries.
+func (s *Server) startBackfeed() {
+	s.config.SetDefault(BACKFEED_MINUTES, 15)
+	s.config.SetDefault(BACKFEED_ENTRIES, 20)
+	m := backfeed.NewMastodon(safefetch.New(30*time.Second), os.Getenv(MASTODON_TOKEN_ENV))
+	s.addJob("backfeed", every(time.Duration(s.config.GetInt(BACKFEED_MINUTES))*time.Minute), func(ctx context.Context) error {
+		return s.runBackfeed(ctx, m)
+	})
+}
+
+// linkrotClient is used to check and archive links. Timeouts are set per
+// request by the linkrot package.
+var linkrotClient = safefetch.New(0)
+
+// runLinkChecks checks the external links in every public entry, notifying
+// the admin if the number of dead links has grown.
+func (s *Server) runLinkChecks(ctx context.Context) error {
+	before, err := s.linkDB.Dead(ctx)
+	if err != nil {
+		return err
+	}
+	urls := map[string][]string{}
+	err = s.entryDB.All(ctx, func(e *entries.Entry) error {
+		if !e.IsPublic() {
+			return nil
+		}
+		// Render without annotations so links to snapshots aren't checked.
+		html := string(markdown.Render([]byte(e.Content), s.markdownOptions))
+		for _, u := range linkrot.External(html, s.config.GetString(HOST)) {
+			urls[u] = append(urls[u], e.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	dead, err := s.linkDB.CheckAll(ctx, linkrotClient, urls, s.config.GetBool(LINKROT_ARCHIVE))
+	if err != nil {
+		return err
+	}
+	s.log.Infof("Checked %d links, %d dead.", len(urls), dead)
+	if dead > len(before) {
+		body := fmt.Sprintf("%d links are dead, see %s/admin/linkrot\n", dead, s.config.GetString(HOST))
+		if err := s.notify.Send("New dead links", body); err != nil {
+			s.log.Warningf("Failed to send notification: %s", err)
+		}
+	}
+	s.entriesChanged()
+	return nil
+}
+
+// saveLinks submits the external links in the entry to the Wayback Machine,
+// which can take minutes, so it is run in the background.
+func (s *Server) saveLinks(id string, entry *entries.Entry) {
+	html := string(markdown.Render([]byte(entry.Content), s.markdownOptions))
+	urls := linkrot.External(html, s.config.GetString(HOST))
+	if *dryRunOutbound {
+		s.log.Infof("Dry run, links not saved to archive.org: %q", urls)
+		return
+	}
+	if err := s.linkDB.SaveAll(context.Background(), linkrotClient, id, urls); err != nil {
+		s.log.Warningf("Failed to save links to archive.org: %s", err)
+	}
+}
+
+func (s *Server) startLinkChecks() {
+	s.config.SetDefault(LINKROT_HOURS, 24*7)
+	if s.config.GetInt(LINKROT_HOURS) == 0 {
+		return
+	}
+	s.addJob("linkcheck", every(time.Duration(s.config.GetInt(LINKROT_HOURS))*time.Hour), s.runLinkChecks)
+}
+
+// notifyMentionReceived lets the admin know about a new mention.
+func (s *Server) notifyMentionReceived(m *mentions.Mention) {
+	subject := fmt.Sprintf("New %s from %s", m.Type, m.AuthorName)
+	body := fmt.Sprintf("%s\n\nSource: %s\nEntry: %s\n", m.Content, m.Source, s.permalinkFromId(m.EntryID))
+	if m.Pending {
+		body += fmt.Sprintf("\nAwaiting moderation: %s/admin/moderation\n", s.config.GetString(HOST))
+	}
+	if err := s.notify.Send(subject, body); err != nil {
+		s.log.Warningf("Failed to send notification: %s", err)
+	}
+}
+
+// notifyMentionApproved lets the admin know that a moderated mention is now
+// displayed.
+func (s *Server) notifyMentionApproved(m *mentions.Mention) {
+	subject := fmt.Sprintf("Approved %s from %s", m.Type, m.AuthorName)
+	body := fmt.Sprintf("%s\n\nSource: %s\nEntry: %s\n", m.Content, m.Source, s.permalinkFromId(m.EntryID))
+	if err := s.notify.Send(subject, body); err != nil {
+		s.log.Warningf("Failed to send notification: %s", err)
+	}
+}
+
+// notifyPublished lets the admin know that an entry was published, which
+// confirms posts that arrive without the admin page, such as from the
+// quick-post API or a scheduled job.
+func (s *Server) notifyPublished(id string, entry *entries.Entry) {
+	title := entry.Title
+	if title == "" {
+		title = shorten(excerpt(s.renderContent(entry.Content), EXCERPT_LENGTH), NOTE_TITLE_LENGTH)
+	}
+	subject := fmt.Sprintf("Published: %s", title)
+	body := fmt.Sprintf("%s\n\nVisibility: %s\n", s.permalinkFromId(id), entry.Visibility)
+	if err := s.notify.Send(subject, body); err != nil {
+		s.log.Warningf("Failed to send notification: %s", err)
+	}
+}
+
+type subscribeContext struct {
+	Config  map[string]interface{}
+	Message string
+}
+
+// renderSubscribe displays a message about the state of a newsletter
+// subscription.
+func (s *Server) renderSubscribe(w http.ResponseWriter, r *http.Request, message string) {
+	w.Header().Set("Content-Type", "text/html")
+	c := &subscribeContext{
+		Config:  s.config.AllSettings(),
+		Message: message,
+	}
+	s.render(w, r, s.templates, "subscribe.html", c)
+}
+
+// subscribeHandler accepts a POST'd email address and sends a confirmation
+// email for the newsletter digest. Addresses that are already confirmed are
+// sent a reminder instead, and the response is the same either way, so it
+// doesn't reveal who is subscribed.
+func (s *Server) subscribeHandler(w http.ResponseWriter, r *http.Request) {
+	if s.subscriberDB == nil {
+		http.NotFound(w, r)
+		return
+	}
+	sub, err := s.subscriberDB.Add(r.Context(), r.FormValue("email"))
+	if err != nil {
+		http.Error(w, "Invalid email address.", http.StatusBadRequest)
+		return
+	}
+	subject := "Confirm your subscription"
+	body := fmt.Sprintf("Please confirm your subscription to %s - Stream by visiting:\n\n  %s/subscribe/confirm?token=%s\n\nIf you didn't ask to subscribe you can ignore this email.\n", s.config.GetString(AUTHOR), s.config.GetString(HOST), sub.Token)
+	if sub.Confirmed {
+		subject = "You are already subscribed"
+		body = fmt.Sprintf("You are already subscribed to %s - Stream. To stop getting it visit:\n\n  %s/unsubscribe?token=%s\n\nIf you didn't ask to subscribe you can ignore this email.\n", s.config.GetString(AUTHOR), s.config.GetString(HOST), sub.Token)
+	}
+	if err := s.mailer.Mail([]string{sub.Email}, subject, notifier.TEXT, body); err != nil {
+		s.log.Errorf("Failed to send confirmation: %s", err)
+		http.Error(w, "Failed to send confirmation email.", http.StatusInternalServerError)
+		return
+	}
+	s.renderSubscribe(w, r, "Check your email to confirm your subscription.")
+}
+
+// subscribeConfirmHandler confirms a newsletter subscription.
+func (s *Server) subscribeConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	if s.subscriberDB == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if _, err := s.subscriberDB.Confirm(r.Context(), r.FormValue("token")); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.renderSubscribe(w, r, "Your subscription is confirmed.")
+}
+
+// unsubscribeHandler removes a newsletter subscription.
+func (s *Server) unsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	if s.subscriberDB == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if err := s.subscriberDB.Remove(r.Context(), r.FormValue("token")); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.renderSubscribe(w, r, "You have been unsubscribed.")
+}
+
+type digestContext struct {
+	Config  map[string]interface{}
+	Entries []*entryContent
+	Token   string
+}
+
+// sendDigests emails every confirmed subscriber whose last digest is older
+// than DIGEST_DAYS the entries published since then.
+func (s *Server) sendDigests(ctx context.Context) error {
+	subs, err := s.subscriberDB.Confirmed(ctx)
+	if err != nil {
+		return err
+	}
+	period := time.Duration(s.config.GetInt(DIGEST_DAYS)) * 24 * time.Hour
+	now := time.Now()
+	for _, sub := range subs {
+		if now.Sub(sub.LastSent) < period {
+			continue
+		}
+		since := sub.LastSent
+		if since.Before(now.Add(-period)) {
+			since = now.Add(-period)
+		}
+		entries, err := s.entryDB.ListSince(ctx, since, 50)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			var b bytes.Buffer
+			c := &digestContext{
+				Config:  s.config.AllSettings(),
+				Entries: s.toDisplaySlice(entries),
+				Token:   sub.Token,
+			}
+			if err := s.templates.ExecuteTemplate(&b, "digest.html", c); err != nil {
+				return fmt.Errorf("Failed to render digest template: %s", err)
+			}
+			subject := fmt.Sprintf("%s - Stream digest", s.config.GetString(AUTHOR))
+			if err := s.mailer.Mail([]string{sub.Email}, subject, notifier.HTML, b.String()); err != nil {
+				s.log.Warningf("Failed to send digest: %s", err)
+				continue
+			}
+		}
+		if err := s.subscriberDB.MarkSent(ctx, sub, now); err != nil {
+			s.log.Warningf("Failed to record digest sent: %s", err)
+		}
+	}
+	return nil
+}
+
+// startDigests periodically sends the newsletter digest.
+func (s *Server) startDigests() {
+	if s.subscriberDB == nil {
+		return
+	}
+	s.config.SetDefault(DIGEST_DAYS, 7)
+	s.addJob("digests", "@hourly", s.sendDigests)
+}
+
+// imagesDir returns the IMAGES directory.
+func (s *Server) imagesDir() string {
+	dir := s.config.GetString(IMAGES)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(*resourcesDir, dir)
+	}
+	return dir
+}
+
+// imagesSource reads original images from the images directory.
+func (s *Server) imagesSource(p string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(s.imagesDir(), filepath.FromSlash(path.Clean("/"+p))))
+}
+
+// imgHandler serves resized renditions of images, as WebP if the client
+// accepts it.
+func (s *Server) imgHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	width := parseWithDefault(vars["width"], 0)
+	if !s.resizer.ValidWidth(width) {
+		http.NotFound(w, r)
+		return
+	}
+	format := resize.JPEG
+	if strings.HasSuffix(vars["path"], ".png") {
+		format = resize.PNG
+	}
+	if strings.Contains(r.Header.Get("Accept"), "image/webp") {
+		format = resize.WEBP
+	}
+	img, err := s.resizer.Get(vars["path"], width, format)
+	if err != nil {
+		s.log.Infof("Failed to resize: %s", err)
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("ETag", img.ETag)
+	cachecontrol.Set(w, cachecontrol.MEDIA)
+	if r.Header.Get("If-None-Match") == img.ETag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", img.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(img.Body)))
+	if _, err := w.Write(img.Body); err != nil {
+		s.log.Warningf("Failed to write image: %s", err)
+	}
+}
+
+func (s *Server) backupStore() *backup.Store {
+	return &backup.Store{
+		Entries:  s.entryDB,
+		Mentions: s.mentionDB,
+	}
+}
+
+// adminBackupHandler streams a backup of all the stored data.
+func (s *Server) adminBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=stream-%s.json", time.Now().Format("2006-01-02")))
+	if err := s.backupStore().Write(r.Context(), w); err != nil {
+		// Headers are already sent, so the best we can do is log.
+		s.log.Errorf("Failed to write backup: %s", err)
+	}
+}
+
+// adminExportMarkdownHandler downloads every entry as a zip of Markdown
+// files.
+func (s *Server) adminExportMarkdownHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=stream-%s.zip", time.Now().Format("2006-01-02")))
+	m := export.NewMarkdown(w)
+	if err := s.entryDB.All(r.Context(), m.Add); err != nil {
+		// Headers are already sent, so the best we can do is log.
+		s.log.Errorf("Failed to export entries: %s", err)
+	}
+	if err := m.Close(); err != nil {
+		s.log.Errorf("Failed to finish export: %s", err)
+	}
+}
+
+// adminRestoreHandler restores an uploaded backup.
+func (s *Server) adminRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	f, _, err := r.FormFile("backup")
+	if limits.TooLarge(err) {
+		http.Error(w, "Backup file is too large.", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Backup file must be supplied.", http.StatusBadRequest)
+		return
+	}
+	defer f.Close()
+	n, err := s.backupStore().Restore(r.Context(), f)
+	if err != nil {
+		s.log.Errorf("Failed to restore after %d records: %s", n, err)
+		http.Error(w, fmt.Sprintf("Failed to restore after %d records: %s", n, err), http.StatusBadRequest)
+		return
+	}
+	s.entriesChanged()
+	s.log.Infof("Restored %d records.", n)
+	http.Redirect(w, r, "/admin", 302)
+}
+
+// importPosts creates backdated entries from posts, oldest first, skipping
+// ones already imported. Replies to posts in the same archive link to the
+// imported entry, and media is copied into the images directory under
+// import/<source>/. Returns the number of entries created.
+func (s *Server) importPosts(ctx context.Context, posts []*importer.Post) (int, error) {
+	n := 0
+	ids := map[string]string{}
+	alts := map[string]string{}
+	for _, p := range posts {
+		content := p.Content
+		for _, m := range p.Media {
+			if m.Open == nil {
+				continue
+			}
+			rel := path.Join("import", p.Source, path.Base(m.Name))
+			if err := importMedia(s.imagesDir(), rel, m); err != nil {
+				return n, err
+			}
+			if m.Alt != "" {
+				alts[rel] = m.Alt
+			}
+			content += fmt.Sprintf("\n\n![%s](/images/%s)", m.Alt, rel)
+		}
+		inReplyTo := p.InReplyToURL
+		if id, ok := ids[p.InReplyToID]; ok {
+			inReplyTo = s.permalinkFromId(id)
+		}
+		if inReplyTo != "" {
+			content = fmt.Sprintf(`<a class="u-in-reply-to" href="%s">In reply to</a>`, template.HTMLEscapeString(inReplyTo)) + "\n\n" + content
+		}
+		entry := &entries.Entry{
+			Content:    content,
+			Summary:    p.Summary,
+			Visibility: entries.ToVisibility(p.Visibility),
+			Created:    p.Created,
+		}
+		if p.URL != "" {
+			entry.Syndication = []string{p.URL}
+		}
+		id, isNew, err := s.entryDB.Import(ctx, entry, p.Alias())
+		if err != nil {
+			return n, err
+		}
+		ids[p.ID] = id
+		if isNew {
+			n++
+		}
+	}
+	if err := s.mediaDB.Sync(ctx); err != nil {
+		return n, err
+	}
+	for rel, alt := range alts {
+		if s.mediaDB.Alt(rel) != "" {
+			continue
+		}
+		if err := s.mediaDB.SetAlt(ctx, rel, alt); err != nil {
+			s.log.Warningf("%s", err)
+		}
+	}
+	return n, nil
+}
+
+// importMedia copies m into the images directory dir at rel, unless it's
+// already there from an earlier import.
+func importMedia(dir, rel string, m *importer.Media) error {
+	filename := filepath.Join(dir, filepath.FromSlash(rel))
+	if _, err := os.Stat(filename); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return fmt.Errorf("Failed to create directory for %q: %s", rel, err)
+	}
+	r, err := m.Open()
+	if err != nil {
+		return fmt.Errorf("Failed to read %q from archive: %s", m.Name, err)
+	}
+	defer r.Close()
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("Failed to create %q: %s", rel, err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return fmt.Errorf("Failed to write %q: %s", rel, err)
+	}
+	return f.Close()
+}
+
+// adminImportHandler imports an uploaded Twitter or Mastodon archive.
+func (s *Server) adminImportHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.isAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	f, header, err := r.FormFile("archive")
+	if limits.TooLarge(err) {
+		http.Error(w, "Archive file is too large.", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Archive file must be supplied.", http.StatusBadRequest)
+		return
+	}
+	defer f.Close()
+	posts, err := importer.Read(f, header.Size)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read archive: %s", err), http.StatusBadRequest)
+		return
+	}
+	n, err := s.importPosts(r.Context(), posts)
+	s.entriesChanged()
+	if err != nil {
+		s.log.Errorf("Failed to import after %d entries: %s", n, err)
+		http.Error(w, fmt.Sprintf("Failed to import after %d entries: %s", n, err), http.StatusInternalServerError)
+		return
+	}
+	s.log.Infof("Imported %d of %d posts.", n, len(posts))
+	http.Redirect(w, r, "/admin", 302)
+}
+
+// restoreFromFile restores the backup in the named file.
+func (s *Server) restoreFromFile(filename string) {
+	f, err := os.Open(filename)
+	if err != nil {
+		s.log.Fatal(err)
 	}
 	defer f.Close()
-	if err := viper.ReadConfig(f); err != nil {
-		log.Fatal(err)
+	n, err := s.backupStore().Restore(context.Background(), f)
+	if err != nil {
+		s.log.Fatal(fmt.Errorf("Failed to restore after %d records: %s", n, err))
 	}
+	s.log.Infof("Restored %d records.", n)
+}
 
-	viper.AddConfigPath(*resourcesDir)
-	if err := viper.ReadInConfig(); err != nil {
-		log.Fatal(err)
+// runBackup writes a backup to BACKUP_BUCKET and deletes backups older than
+// BACKUP_RETENTION_DAYS.
+func (s *Server) runBackup(ctx context.Context, bucket *storage.BucketHandle) error {
+	name, err := s.backupStore().ToGCS(ctx, bucket, s.config.GetString(BACKUP_PREFIX))
+	if err != nil {
+		return err
 	}
+	s.log.Infof("Wrote backup: %q", name)
+	before := time.Now().Add(-time.Duration(s.config.GetInt(BACKUP_RETENTION_DAYS)) * 24 * time.Hour)
+	n, err := backup.Prune(ctx, bucket, s.config.GetString(BACKUP_PREFIX), before)
+	if err != nil {
+		return err
+	}
+	s.log.Infof("Pruned %d backups.", n)
+	return nil
+}
 
-	ad = admin.New(viper.GetString(CLIENT_ID), viper.GetStringSlice(ADMINS))
-	loadTemplates()
-
-	entryDB, err = entries.New(context.Background(), viper.GetString(PROJECT), viper.GetString(DATASTORE_NAMESPACE), log)
+// startBackups periodically backs up to BACKUP_BUCKET, if set, and notifies
+// the admin of failures.
+func (s *Server) startBackups() {
+	if s.config.GetString(BACKUP_BUCKET) == "" {
+		return
+	}
+	s.config.SetDefault(BACKUP_HOURS, 24)
+	s.config.SetDefault(BACKUP_RETENTION_DAYS, 30)
+	s.config.SetDefault(BACKUP_PREFIX, "stream-backup/")
+	client, err := storage.NewClient(context.Background())
 	if err != nil {
-		log.Fatal(err)
-	} else {
-		log.Info("Initialized.")
+		s.log.Fatal(err)
 	}
+	s.backupBucket = client.Bucket(s.config.GetString(BACKUP_BUCKET))
+	s.addJob("backup", every(time.Duration(s.config.GetInt(BACKUP_HOURS))*time.Hour), func(ctx context.Context) error {
+		err := s.runBackup(ctx, s.backupBucket)
+		if err != nil {
+			if err := s.notify.Send("Backup failed", err.Error()); err != nil {
+				s.log.Warningf("Failed to send notification: %s", err)
+			}
+		}
+		return err
+	})
 }
 
-type adminContext struct {
-	IsAdmin bool
-	Entries []*entryContent
-	Offset  int
-	Config  map[string]interface{}
-	Form    map[string]string
+// makeImagesHandler serves the files under /images/, which are immutable
+// when requested with their current version, see the bust template func.
+func (s *Server) makeImagesHandler() http.Handler {
+	fileServer := http.FileServer(http.Dir(s.imagesDir()))
+	return s.imageVersions.Middleware(cachecontrol.MEDIA, fileServer)
 }
 
-type entryContent struct {
-	Title       string
-	Content     template.HTML
-	SafeContent string
-	ID          string
-	Created     time.Time
-	Updated     time.Time
+// shared wraps h, a page or feed that isn't in the pageCache, so that shared
+// caches keep it as long as they keep cached pages. Responses to admins are
+// private.
+func (s *Server) shared(h http.HandlerFunc) http.Handler {
+	policy := cachecontrol.Shared(s.config.GetInt(PAGE_CACHE_S_MAXAGE), s.config.GetInt(PAGE_CACHE_SWR))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.isAdmin(r) {
+			cachecontrol.Set(w, cachecontrol.PRIVATE)
+		} else {
+			cachecontrol.Set(w, policy)
+		}
+		h(w, r)
+	})
 }
 
-func parseWithDefault(s string, defaultValue int) int {
-	// "" will parse as an error.
-	ret, err := strconv.ParseInt(s, 10, 32)
-	if err != nil {
-		return defaultValue
+// DEGRADED_RETRY_AFTER is how many seconds clients are asked to wait before
+// trying again while the Datastore is unreachable.
+const DEGRADED_RETRY_AFTER = 60
+
+// setDegraded records whether the Datastore is unreachable.
+func (s *Server) setDegraded(down bool) {
+	s.degradedMutex.Lock()
+	defer s.degradedMutex.Unlock()
+	if down && s.degradedSince.IsZero() {
+		s.log.Errorf("The Datastore is unreachable, serving pages from the page cache.")
+		s.degradedSince = time.Now()
+	} else if !down && !s.degradedSince.IsZero() {
+		s.log.Infof("The Datastore is reachable again after %s.", time.Since(s.degradedSince))
+		s.degradedSince = time.Time{}
 	}
-	return int(ret)
 }
 
-// The returned map has values for 'title' and 'content'.
-//
-// For example Chrome on Android shares the title: and from Twitter web that looks like:
-//   <user name> on Twitter: "full tweet text <t.co link>" / Twitter
-// and text: is the url of the tweet.
-func shareTargetToMap(form url.Values) map[string]string {
-	ret := map[string]string{}
-	ret["title"] = form.Get("title")
-	ret["content"] = form.Get("text")
-
-	// Presume that all links are coming from Chrome, so text: is the url most of
-	// the time, but not always, you can select text to share, and that shows up
-	// w/o a URL.
-	u := form.Get("text")
-	if _, err := url.Parse(u); err != nil {
-		u = ""
-	}
-	if u == "" {
-		u = form.Get("url")
-	}
-	if u != "" {
-		doc, err := goquery.NewDocument(u)
-		if err != nil {
-			log.Infof("goquery failed to parse %q: %s", u, err)
-			return ret
-		}
-		u = doc.Find("link[rel=canonical]").AttrOr("href", u)
-		ret["title"] = doc.Find("title").Contents().Text()
-		ret["content"] = fmt.Sprintf("<a class='u-in-reply-to' href='%s'>%s</a>", u, ret["title"])
+// isDegraded returns true if the Datastore was found to be unreachable and
+// hasn't been reached since.
+func (s *Server) isDegraded() bool {
+	s.degradedMutex.Lock()
+	defer s.degradedMutex.Unlock()
+	return !s.degradedSince.IsZero()
+}
+
+// bodyTag finds the opening <body> tag of a page.
+var bodyTag = regexp.MustCompile(`(?i)<body[^>]*>`)
+
+// addBanner returns the HTML page with the message shown at the top.
+func addBanner(page []byte, message string) []byte {
+	loc := bodyTag.FindIndex(page)
+	if loc == nil {
+		return page
 	}
-	return ret
+	banner := fmt.Sprintf("\n<p class=degraded role=status>%s</p>", html.EscapeString(message))
+	ret := append([]byte{}, page[:loc[1]]...)
+	ret = append(ret, banner...)
+	return append(ret, page[loc[1]:]...)
 }
 
-// adminHandler displays the admin page for Stream.
-//
-// They query parameters 'title', 'text', and 'url' may be supplied by a Web
-// Share Target call and should pre-populate the form for creating a new
-// entry.
-func adminHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html")
-	context := &adminContext{}
-	isAdmin := ad.IsAdmin(r, log)
-	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Invalid form values.", 400)
+// serveDegraded responds to r when its page can't be rendered because the
+// Datastore failed with err. The last copy of the page in the pageCache is
+// served, with a banner if it's HTML, or an error if there isn't one. Either
+// way the status is 503 with a Retry-After, so crawlers and feed readers come
+// back later rather than taking the page as gone or empty.
+func (s *Server) serveDegraded(w http.ResponseWriter, r *http.Request, err error) {
+	s.log.Warningf("Failed to render %q: %s", r.URL.Path, err)
+	s.setDegraded(true)
+	header, body, ok := s.pageCache.Last(r)
+	w.Header().Set("Retry-After", strconv.Itoa(DEGRADED_RETRY_AFTER))
+	cachecontrol.Set(w, cachecontrol.NO_CACHE)
+	if !ok {
+		http.Error(w, "Temporarily unavailable, please try again later.", http.StatusServiceUnavailable)
 		return
 	}
-	context = &adminContext{
-		IsAdmin: isAdmin,
-		Config:  viper.AllSettings(),
-		Form:    shareTargetToMap(r.Form),
+	for k, v := range header {
+		if k != "Cache-Control" && k != "Content-Length" {
+			w.Header()[k] = v
+		}
 	}
-	log.Infof("Form: %#v", context.Form)
-	if isAdmin {
-		limit := parseWithDefault(r.FormValue("limit"), 20)
-		offset := parseWithDefault(r.FormValue("offset"), 0)
-		entries, err := entryDB.List(r.Context(), int(limit), int(offset))
-		if err != nil {
-			log.Warningf("Failed to get entries: %s", err)
+	w.Header().Set("X-Page-Cache", "stale")
+	if strings.HasPrefix(header.Get("Content-Type"), "text/html") {
+		body = addBanner(body, templatefuncs.Translate(s.localeFor(r), "This is a saved copy of the page, which may be out of date."))
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	w.Write(body)
+}
+
+// readyzHandler reports whether the Datastore is reachable, so pages are
+// being rendered rather than served from the page cache. Once it was found
+// unreachable it's checked on each request until it can be reached again.
+func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	cachecontrol.Set(w, cachecontrol.NO_CACHE)
+	if s.isDegraded() {
+		if err := s.entryDB.Ping(r.Context()); err != nil {
+			s.log.Warningf("%s", err)
+			w.Header().Set("Retry-After", strconv.Itoa(DEGRADED_RETRY_AFTER))
+			http.Error(w, "Datastore unreachable.", http.StatusServiceUnavailable)
 			return
 		}
-		context.Entries = toDisplaySlice(entries)
-		context.Offset = int(offset + limit)
-		if len(entries) < limit {
-			context.Offset = -1
-		}
-	}
-	if err := templates.ExecuteTemplate(w, "admin.html", context); err != nil {
-		log.Errorf("Failed to render admin template: %s", err)
+		s.setDegraded(false)
 	}
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, "ok")
 }
 
-type indexContext struct {
-	Config  map[string]interface{}
-	Entries []*entryContent
-	Offset  int
+type errorContext struct {
+	Config map[string]interface{}
+	Status int
 }
 
-// indexHandler displays the admin page for Stream.
-func indexHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/html")
-	limit := parseWithDefault(r.FormValue("limit"), 20)
-	offset := parseWithDefault(r.FormValue("offset"), 0)
-	entries, err := entryDB.List(r.Context(), int(limit), int(offset))
-	if err != nil {
-		log.Warningf("Failed to get entries: %s", err)
-		return
+// renderError writes the error page for the status, 404.html for
+// http.StatusNotFound and 500.html for anything else.
+func (s *Server) renderError(w http.ResponseWriter, r *http.Request, status int) {
+	name := "500.html"
+	if status == http.StatusNotFound {
+		name = "404.html"
 	}
-	log.Infof("%#v\n", viper.AllSettings())
-	context := &indexContext{
-		Config:  viper.AllSettings(),
-		Entries: toDisplaySlice(entries),
-		Offset:  int(offset + limit),
+	var b bytes.Buffer
+	c := &errorContext{
+		Config: s.config.AllSettings(),
+		Status: status,
 	}
-	if len(entries) < limit {
-		context.Offset = -1
+	if err := s.templatesFor(w, r).ExecuteTemplate(&b, name, c); err != nil {
+		s.log.Errorf("Failed to render %s: %s", name, err)
+		http.Error(w, http.StatusText(status), status)
+		return
 	}
-	if err := templates.ExecuteTemplate(w, "index.html", context); err != nil {
-		log.Errorf("Failed to render index template: %s", err)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(b.Bytes())
+}
+
+// renderStatus executes the named template with data and writes it with the
+// status. The template is rendered in full first, so if it fails the client
+// gets the 500.html error page rather than half a page.
+func (s *Server) renderStatus(w http.ResponseWriter, r *http.Request, t *template.Template, status int, name string, data interface{}) {
+	var b bytes.Buffer
+	if err := t.ExecuteTemplate(&b, name, data); err != nil {
+		s.log.Errorf("Failed to render %s: %s", name, err)
+		s.renderError(w, r, http.StatusInternalServerError)
+		return
 	}
+	w.WriteHeader(status)
+	w.Write(b.Bytes())
 }
 
-type feedContext struct {
-	Updated time.Time
-	Entries []*entryContent
-	Config  map[string]interface{}
-	Author  string
-	Host    string
+// render executes the named template with data and writes it, see
+// renderStatus.
+func (s *Server) render(w http.ResponseWriter, r *http.Request, t *template.Template, name string, data interface{}) {
+	s.renderStatus(w, r, t, http.StatusOK, name, data)
 }
 
-// feedHandler displays the admin page for Stream.
-func feedHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/atom+xml")
-	entries, err := entryDB.List(r.Context(), 10, 0)
-	if err != nil {
-		log.Warningf("Failed to get entries: %s", err)
+// redirect maps an old path, e.g. from a previous blog, to its new URL.
+type redirect struct {
+	From string `mapstructure:"from"`
+	To   string `mapstructure:"to"`
+}
+
+// loadRedirects loads REDIRECTS from the config, which may be called again
+// when the config changes.
+func (s *Server) loadRedirects() {
+	var rs []redirect
+	if err := s.config.UnmarshalKey(REDIRECTS, &rs); err != nil {
+		s.log.Errorf("Failed to load redirects: %s", err)
 		return
 	}
-	updated := time.Time{}
-	for _, entry := range entries {
-		if entry.Updated.After(updated) {
-			updated = entry.Updated
-		}
-	}
-	context := &feedContext{
-		Config:  viper.AllSettings(),
-		Updated: updated,
-		Entries: toDisplaySlice(entries),
-	}
-	if err := templates.ExecuteTemplate(w, "atom.xml", context); err != nil {
-		log.Errorf("Failed to render index template: %s", err)
+	m := map[string]string{}
+	for _, r := range rs {
+		m[r.From] = r.To
 	}
+	s.redirectsMutex.Lock()
+	defer s.redirectsMutex.Unlock()
+	s.redirects = m
+	s.log.Infof("Loaded %d redirects.", len(m))
 }
 
-func toDisplayContent(s string) string {
-	content := strings.ReplaceAll(s, "\r\n", "\n")
-	bridges := []string{}
-	for _, href := range viper.GetStringSlice(BRIDGES) {
-		bridges = append(bridges, fmt.Sprintf("<a href='%s'></a>", href))
+// notFoundHandler issues a permanent redirect for paths in REDIRECTS, and a
+// 404 for everything else.
+func (s *Server) notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	s.redirectsMutex.RLock()
+	to, ok := s.redirects[r.URL.Path]
+	s.redirectsMutex.RUnlock()
+	if !ok {
+		s.renderError(w, r, http.StatusNotFound)
+		return
 	}
-
-	return string(blackfriday.Run([]byte(content))) + strings.Join(bridges, " ")
+	http.Redirect(w, r, to, http.StatusMovedPermanently)
 }
 
-// toDisplay converts an entries.Entry into an entryContent.
-func toDisplay(in *entries.Entry) *entryContent {
-	content := toDisplayContent(in.Content)
-	return &entryContent{
-		Title:       in.Title,
-		Content:     template.HTML(content),
-		SafeContent: content,
-		ID:          in.ID,
-		Created:     in.Created,
-		Updated:     in.Updated,
+func (s *Server) makeRedirectHandler(path string) func(http.ResponseWriter, *http.Request) {
+	domain := s.config.GetString(FEDSOC_BRIDGE)
+	return func(w http.ResponseWriter, r *http.Request) {
+		u := domain + path
+		if r.URL.RawQuery != "" {
+			u += "?" + r.URL.RawQuery
+		}
+		s.log.Infof("Redirecting to: %q", u)
+		http.Redirect(w, r, u, 302)
 	}
 }
 
-func toDisplaySlice(in []*entries.Entry) []*entryContent {
-	ret := []*entryContent{}
-	for _, en := range in {
-		ret = append(ret, toDisplay(en))
-	}
-	return ret
+// nodeInfoWellKnownHandler points to the NodeInfo document.
+// See https://github.com/jhass/nodeinfo/blob/main/PROTOCOL.md.
+func (s *Server) nodeInfoWellKnownHandler(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, map[string]interface{}{
+		"links": []map[string]string{
+			{
+				"rel":  "http://nodeinfo.diaspora.software/ns/schema/2.1",
+				"href": s.config.GetString(HOST) + "/nodeinfo/2.1",
+			},
+		},
+	})
 }
 
-// adminNewHandler accepts POST'd form values to create a new entry.
-func adminNewHandler(w http.ResponseWriter, r *http.Request) {
-	if *local {
-		loadTemplates()
-	}
-	if !ad.IsAdmin(r, log) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-	content := r.FormValue("content")
-	id, err := entryDB.Insert(r.Context(), content, r.FormValue("title"))
+// nodeInfoHandler serves the NodeInfo 2.1 document.
+func (s *Server) nodeInfoHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := s.entryDB.CountPublic(r.Context())
 	if err != nil {
-		log.Errorf("Failed to insert: %s", err)
-		http.Error(w, "Failed to insert", http.StatusInternalServerError)
+		s.log.Warningf("Failed to count entries: %s", err)
 	}
-	if err := sendWebMentions(id, toDisplayContent(content)); err != nil {
-		log.Warningf("Failed to send webmentions: %s", err)
-	}
-	http.Redirect(w, r, "/admin", 302)
+	w.Header().Set("Content-Type", `application/json; profile="http://nodeinfo.diaspora.software/ns/schema/2.1#"`)
+	s.writeJSON(w, map[string]interface{}{
+		"version": "2.1",
+		"software": map[string]string{
+			"name":       "stream-run",
+			"version":    version,
+			"repository": "https://github.com/jcgregorio/stream-run",
+		},
+		"protocols": []string{"activitypub"},
+		"services": map[string][]string{
+			"inbound":  {},
+			"outbound": {"atom1.0"},
+		},
+		"openRegistrations": false,
+		"usage": map[string]interface{}{
+			"users": map[string]int{
+				"total": 1,
+			},
+			"localPosts": n,
+		},
+		"metadata": map[string]string{
+			"nodeName": s.config.GetString(AUTHOR) + " - Stream",
+		},
+	})
 }
 
-func sendWebMentions(id, content string) error {
-	client := &http.Client{
-		Timeout: time.Second * 30,
-	}
-	source := permalinkFromId(id)
-	m := webmention.New(client)
-	buf := bytes.NewBufferString(content)
-	links, err := webmention.DiscoverLinksFromReader(buf, source, "")
-	if err != nil {
-		return fmt.Errorf("Failed to discover links in %q: %s", content, err)
-	}
-	for _, link := range links {
-		log.Infof("Webmention trying to send: %q -> %q", source, link)
-		endpoint, err := m.DiscoverEndpoint(link)
-		if err != nil {
-			return err
-		}
-		resp, err := m.SendWebmention(endpoint, source, link)
-		if err != nil {
-			log.Infof("Failed to send webmention %q -> %q: %s", source, link, err)
-		} else if resp.StatusCode >= 400 {
-			log.Infof("Failed to send webmention %q -> %q: Status code %d:%s: %s", source, link, resp.StatusCode, resp.Status, err)
-		} else {
-			log.Infof("Webmention sent: %q -> %q", source, link)
-		}
-	}
-	websubUrl := viper.GetString(WEBSUB)
-	resp, err := client.PostForm(websubUrl, url.Values{
-		"hub.mode": {"publish"},
-		"hub.url":  {fmt.Sprintf("%s/feed", viper.GetString(HOST))},
-	})
+// mastoUsername is the username of the author's account in the Mastodon
+// API, which is the hostname, matching the @host@host handle bridges use.
+func (s *Server) mastoUsername() string {
+	u, err := url.Parse(s.config.GetString(HOST))
 	if err != nil {
-		log.Errorf("Failed to update websub hub: %q: %s", websubUrl, err)
+		return ""
 	}
-	log.Infof("WebSub response: %d - %q", resp.StatusCode, resp.Status)
-
-	return nil
+	return u.Hostname()
 }
 
-type editContext struct {
-	Raw    *entries.Entry
-	Cooked *entryContent
-	Config map[string]interface{}
+// mastoAccount returns the author's account in the Mastodon API.
+func (s *Server) mastoAccount(ctx context.Context) *mastoapi.Account {
+	n, err := s.entryDB.CountPublic(ctx)
+	if err != nil {
+		s.log.Warningf("Failed to count entries: %s", err)
+	}
+	return &mastoapi.Account{
+		ID:            mastoapi.ACCOUNT_ID,
+		Username:      s.mastoUsername(),
+		Acct:          s.mastoUsername(),
+		DisplayName:   s.config.GetString(AUTHOR),
+		Note:          template.HTMLEscapeString(s.config.GetString(AUTHOR_DESC)),
+		URL:           s.config.GetString(HOST),
+		Avatar:        s.config.GetString(AUTHOR_IMAGE_URL),
+		AvatarStatic:  s.config.GetString(AUTHOR_IMAGE_URL),
+		StatusesCount: n,
+		Emojis:        []mastoapi.Emoji{},
+		Fields:        []mastoapi.Field{},
+	}
 }
 
-// adminEditHandler displays the admin page for Stream.
-func adminEditHandler(w http.ResponseWriter, r *http.Request) {
-	if *local {
-		loadTemplates()
-	}
-	if !ad.IsAdmin(r, log) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
+// toStatus converts an entry, via the same display pipeline as the HTML
+// pages, into a Mastodon API status.
+func (s *Server) toStatus(entry *entries.Entry, account *mastoapi.Account) *mastoapi.Status {
+	cooked := s.toDisplay(entry)
+	st := mastoapi.NewStatus(entry.ID, s.permalinkFromId(entry.ID), entry.Created, account)
+	content := string(cooked.Content)
+	if entry.Title != "" {
+		content = fmt.Sprintf("<p><strong>%s</strong></p>%s", template.HTMLEscapeString(entry.Title), content)
 	}
-	vars := mux.Vars(r)
-	id := vars["id"]
-	raw, err := entryDB.Get(r.Context(), id)
-	if err != nil {
-		http.NotFound(w, r)
-		return
+	st.Content = content
+	st.SpoilerText = entry.Summary
+	st.Sensitive = entry.Summary != ""
+	if entry.ParentID != "" {
+		parent, accountID := entry.ParentID, account.ID
+		st.InReplyToID = &parent
+		st.InReplyToAccountID = &accountID
 	}
-	if r.Method == "POST" {
-		switch r.FormValue("action") {
-		case "update":
-			raw.Title = r.FormValue("title")
-			raw.Content = r.FormValue("content")
-			if err := entryDB.Update(r.Context(), raw); err != nil {
-				http.Error(w, "Failed to write.", http.StatusInternalServerError)
-				return
-			}
-			cooked := toDisplay(raw)
-			if err := sendWebMentions(id, cooked.SafeContent); err != nil {
-				log.Warningf("Failed to send webmentions: %s", err)
-			}
-		case "delete":
-			if err := entryDB.Delete(r.Context(), id); err != nil {
-				http.Error(w, "Failed to delete.", http.StatusInternalServerError)
-				return
-			}
-			http.Redirect(w, r, "/admin", 302)
-			return
-		default:
-			http.Error(w, "POST request failed to include action.", http.StatusBadRequest)
-			return
-		}
+	if entry.Visibility == entries.UNLISTED {
+		st.Visibility = "unlisted"
 	}
-	c := editContext{
-		Raw:    raw,
-		Cooked: toDisplay(raw),
-		Config: viper.AllSettings(),
+	if entry.Updated.Sub(entry.Created) > time.Minute {
+		updated := entry.Updated
+		st.EditedAt = &updated
 	}
-	if err := templates.ExecuteTemplate(w, "adminEdit.html", c); err != nil {
-		log.Errorf("Failed to render admin template: %s", err)
+	for i, src := range cooked.Photos {
+		if strings.HasPrefix(src, "/") {
+			src = s.config.GetString(HOST) + src
+		}
+		st.MediaAttachments = append(st.MediaAttachments, mastoapi.MediaAttachment{
+			ID:         fmt.Sprintf("%s-%d", entry.ID, i),
+			Type:       mastoapi.MediaType(src),
+			URL:        src,
+			PreviewURL: src,
+		})
 	}
+	return st
 }
 
-type entryContext struct {
-	Cooked *entryContent
-	Config map[string]interface{}
+// mastoJSON writes value as JSON, allowing web based apps on other origins
+// to read it.
+func (s *Server) mastoJSON(w http.ResponseWriter, value interface{}) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	s.writeJSON(w, value)
 }
 
-// entryHandler handles the permalink for an individual entry.
-func entryHandler(w http.ResponseWriter, r *http.Request) {
-	if *local {
-		loadTemplates()
-	}
-	vars := mux.Vars(r)
-	id := vars["id"]
-	raw, err := entryDB.Get(r.Context(), id)
+// mastoInstanceHandler describes this server, which apps request before
+// anything else.
+func (s *Server) mastoInstanceHandler(w http.ResponseWriter, r *http.Request) {
+	n, err := s.entryDB.CountPublic(r.Context())
 	if err != nil {
-		http.NotFound(w, r)
-		return
+		s.log.Warningf("Failed to count entries: %s", err)
 	}
+	s.mastoJSON(w, map[string]interface{}{
+		"uri":               s.mastoUsername(),
+		"title":             s.config.GetString(AUTHOR) + " - Stream",
+		"short_description": s.config.GetString(AUTHOR_DESC),
+		"description":       s.config.GetString(AUTHOR_DESC),
+		"email":             s.config.GetString(EMAIL),
+		"version":           "4.0.0 (compatible; stream-run " + version + ")",
+		"urls":              map[string]string{},
+		"stats": map[string]int{
+			"user_count":   1,
+			"status_count": n,
+			"domain_count": 0,
+		},
+		"languages":         []string{},
+		"registrations":     false,
+		"approval_required": false,
+		"invites_enabled":   false,
+	})
+}
 
-	c := &entryContext{
-		Cooked: toDisplay(raw),
-		Config: viper.AllSettings(),
+// mastoLookupHandler finds the author's account by acct.
+func (s *Server) mastoLookupHandler(w http.ResponseWriter, r *http.Request) {
+	if !mastoapi.AcctMatches(r.FormValue("acct"), s.mastoUsername(), s.mastoUsername()) {
+		http.Error(w, `{"error":"Record not found"}`, http.StatusNotFound)
+		return
 	}
+	s.mastoJSON(w, s.mastoAccount(r.Context()))
+}
 
-	if err := templates.ExecuteTemplate(w, "entry.html", c); err != nil {
-		log.Errorf("Failed to render entry template: %s", err)
+// mastoAccountHandler returns the author's account by id.
+func (s *Server) mastoAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if mux.Vars(r)["id"] != mastoapi.ACCOUNT_ID {
+		http.Error(w, `{"error":"Record not found"}`, http.StatusNotFound)
+		return
 	}
+	s.mastoJSON(w, s.mastoAccount(r.Context()))
 }
 
-// serviceWorkerHandler handles the permalink for an individual entry.
-func serviceWorkerHandler(w http.ResponseWriter, r *http.Request) {
-	if *local {
-		loadTemplates()
-	}
-	w.Header().Set("Content-Type", "text/javascript")
-	if err := templates.ExecuteTemplate(w, "service-worker.js", nil); err != nil {
-		log.Errorf("Failed to render service-worker.js: %s", err)
+// mastoStatusHandler returns a single public or unlisted entry as a status.
+func (s *Server) mastoStatusHandler(w http.ResponseWriter, r *http.Request) {
+	entry, err := s.entryDB.Get(r.Context(), mux.Vars(r)["id"])
+	if err != nil || entry.Visibility == entries.PRIVATE {
+		http.Error(w, `{"error":"Record not found"}`, http.StatusNotFound)
+		return
 	}
+	s.mastoJSON(w, s.toStatus(entry, s.mastoAccount(r.Context())))
 }
 
-// manifestHandler handles the permalink for an individual entry.
-func manifestHandler(w http.ResponseWriter, r *http.Request) {
-	if *local {
-		loadTemplates()
+// mastoTimelineHandler returns the public entries as statuses, newest first,
+// and is used for both the public timeline and the author's statuses.
+// Paging is by offset, which apps follow from the Link header.
+func (s *Server) mastoTimelineHandler(w http.ResponseWriter, r *http.Request) {
+	if id, ok := mux.Vars(r)["id"]; ok && id != mastoapi.ACCOUNT_ID {
+		http.Error(w, `{"error":"Record not found"}`, http.StatusNotFound)
+		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	if err := templates.ExecuteTemplate(w, "manifest.json", nil); err != nil {
-		log.Errorf("Failed to render manifest.json: %s", err)
+	limit := mastoapi.Limit(r.FormValue("limit"))
+	offset := parseWithDefault(r.FormValue("offset"), 0)
+	ret := []*mastoapi.Status{}
+	// Apps poll for newer statuses with min_id or since_id, but paging is by
+	// offset so those requests are answered with nothing new.
+	if r.FormValue("min_id") == "" && r.FormValue("since_id") == "" {
+		list, err := s.entryDB.ListPublic(r.Context(), limit, offset)
+		if err != nil {
+			s.log.Errorf("Failed to get entries: %s", err)
+			http.Error(w, `{"error":"Failed to get entries"}`, http.StatusInternalServerError)
+			return
+		}
+		account := s.mastoAccount(r.Context())
+		for _, e := range list {
+			ret = append(ret, s.toStatus(e, account))
+		}
+		if len(list) == limit {
+			next := *r.URL
+			q := next.Query()
+			q.Set("offset", strconv.Itoa(offset+limit))
+			q.Set("limit", strconv.Itoa(limit))
+			next.RawQuery = q.Encode()
+			w.Header().Set("Link", fmt.Sprintf(`<%s%s>; rel="next"`, s.config.GetString(HOST), next.RequestURI()))
+		}
 	}
+	s.mastoJSON(w, ret)
 }
 
-// offlineHandler handles the permalink for an individual entry.
-func offlineHandler(w http.ResponseWriter, r *http.Request) {
-	if *local {
-		loadTemplates()
-	}
-	w.Header().Set("Content-Type", "text/html")
-	if err := templates.ExecuteTemplate(w, "offline.html", nil); err != nil {
-		log.Errorf("Failed to render service-worker.js: %s", err)
-	}
+// hostMetaHandler serves host-meta as XRD, pointing WebFinger lookups at
+// this host. See RFC 6415.
+func (s *Server) hostMetaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/xrd+xml")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<XRD xmlns="http://docs.oasis-open.org/ns/xri/xrd-1.0">
+  <Link rel="lrdd" type="application/jrd+json" template="%s/.well-known/webfinger?resource={uri}"/>
+</XRD>
+`, s.config.GetString(HOST))
 }
 
-func makeImagesHandler() func(http.ResponseWriter, *http.Request) {
-	fileServer := http.FileServer(http.Dir(filepath.Join(*resourcesDir, "images")))
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("Cache-Control", "max-age=300")
-		fileServer.ServeHTTP(w, r)
-	}
+// hostMetaJSONHandler serves host-meta as JRD.
+func (s *Server) hostMetaJSONHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/jrd+json")
+	s.writeJSON(w, map[string]interface{}{
+		"links": []map[string]string{
+			{
+				"rel":      "lrdd",
+				"type":     "application/jrd+json",
+				"template": s.config.GetString(HOST) + "/.well-known/webfinger?resource={uri}",
+			},
+		},
+	})
 }
 
-func makeRedirectHandler(path string) func(http.ResponseWriter, *http.Request) {
-	domain := viper.GetString(FEDSOC_BRIDGE)
-	return func(w http.ResponseWriter, r *http.Request) {
-		u := domain + path + r.URL.RawQuery
-		log.Infof("Redirecting to: %q", u)
-		http.Redirect(w, r, u, 302)
+// writeJSON writes the value as JSON, setting the Content-Type if it hasn't
+// already been set.
+func (s *Server) writeJSON(w http.ResponseWriter, value interface{}) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		s.log.Errorf("Failed to write JSON: %s", err)
 	}
 }
 
-func main() {
-	initialize()
+// addHandlers adds the routes of the stream to r.
+func (s *Server) addHandlers(r *mux.Router) {
 	/*
 
 			/            - Root, displays the last 10 stream entries. Link to feed.
@@ -523,26 +6539,169 @@ func main() {
 
 	*/
 
-	r := mux.NewRouter()
-	r.PathPrefix("/images/").Handler(http.StripPrefix("/images/", http.HandlerFunc(makeImagesHandler()))).Methods("GET", "HEAD")
-	r.HandleFunc("/admin/new", adminNewHandler).Methods("POST")
-	r.HandleFunc("/admin/edit/{id}", adminEditHandler).Methods("GET", "POST")
-	r.HandleFunc("/admin", adminHandler).Methods("GET")
-	r.HandleFunc("/feed", feedHandler).Methods("GET", "HEAD")
-	r.HandleFunc("/", indexHandler).Methods("GET", "HEAD")
-	r.HandleFunc("/entry/{id}", entryHandler).Methods("GET", "HEAD")
-	r.HandleFunc("/service-worker.js", serviceWorkerHandler).Methods("GET")
-	r.HandleFunc("/offline", offlineHandler).Methods("GET")
-	r.HandleFunc("/manifest.json", manifestHandler).Methods("GET", "HEAD")
-	r.HandleFunc("/.well-known/host-meta", makeRedirectHandler("/.well-known/host-meta")).Methods("GET", "HEAD")
-	r.HandleFunc("/.well-known/host-meta.xrd", makeRedirectHandler("/.well-known/host-meta.xrd")).Methods("GET", "HEAD")
-	r.HandleFunc("/.well-known/host-meta.jrd", makeRedirectHandler("/.well-known/host-meta.jrd")).Methods("GET", "HEAD")
-	r.HandleFunc("/.well-known/webfinger", makeRedirectHandler("/.well-known/webfinger")).Methods("GET", "HEAD")
-
-	http.Handle("/", r)
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "1313"
-	}
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	r.Use(labelHandler)
+	r.NotFoundHandler = http.HandlerFunc(s.notFoundHandler)
+	r.HandleFunc("/img/{width:[0-9]+}/{path:.+}", s.imgHandler).Methods("GET", "HEAD")
+	r.PathPrefix(assets.PREFIX).Handler(s.assetDB).Methods("GET", "HEAD")
+	r.PathPrefix("/images/").Handler(http.StripPrefix("/images/", s.makeImagesHandler())).Methods("GET", "HEAD")
+	s.config.SetDefault(RATE_LIMIT_PER_MINUTE, 10)
+	s.config.SetDefault(RATE_LIMIT_BURST, 5)
+	s.config.SetDefault(RATE_LIMIT_BAN_AFTER, 20)
+	s.config.SetDefault(RATE_LIMIT_BAN_MINUTES, 60)
+	s.config.SetDefault(TRUSTED_PROXIES, 1)
+	limiter := ratelimit.New(s.config.GetFloat64(RATE_LIMIT_PER_MINUTE), s.config.GetInt(RATE_LIMIT_BURST), s.config.GetInt(RATE_LIMIT_BAN_AFTER), time.Duration(s.config.GetInt(RATE_LIMIT_BAN_MINUTES))*time.Minute)
+	limiter.SetTrustedProxies(s.config.GetInt(TRUSTED_PROXIES))
+	limited := func(f http.HandlerFunc) http.Handler {
+		return limiter.Middleware(f)
+	}
+	// Referrers aren't counted on a MIRROR, since it doesn't flush them.
+	counted := s.referrerDB.Middleware
+	if s.config.GetBool(MIRROR) {
+		counted = func(h http.Handler) http.Handler { return h }
+	}
+
+	r.Handle("/admin/new", limited(s.adminNewHandler)).Methods("POST")
+	r.HandleFunc("/admin/edit/{id}", s.adminEditHandler).Methods("GET", "POST")
+	r.HandleFunc("/admin/tokens", s.adminTokensHandler).Methods("GET", "POST")
+	r.HandleFunc("/admin/blocks", s.adminBlocksHandler).Methods("GET", "POST")
+	r.HandleFunc("/admin/stats", s.adminStatsHandler).Methods("GET")
+	r.HandleFunc("/admin/webmentions", s.adminWebmentionsHandler).Methods("GET", "POST")
+	r.HandleFunc("/admin/syndication", s.adminSyndicationHandler).Methods("GET", "POST")
+	r.HandleFunc("/admin/snippets", s.adminSnippetsHandler).Methods("GET", "POST")
+	r.HandleFunc("/admin/api/search", s.adminSearchHandler).Methods("GET")
+	r.HandleFunc("/admin/api/entry/{id}", s.adminAPIEntryHandler).Methods("GET", "PATCH")
+	r.HandleFunc("/admin/undelete", s.adminUndeleteHandler).Methods("POST")
+	r.HandleFunc("/admin/media", s.adminMediaHandler).Methods("GET", "POST")
+	r.HandleFunc("/admin/linkrot", s.adminLinkrotHandler).Methods("GET", "POST")
+	r.HandleFunc("/admin/moderation", s.adminModerationHandler).Methods("GET", "POST")
+	r.HandleFunc("/admin/backup.json", s.adminBackupHandler).Methods("GET")
+	r.HandleFunc("/admin/export/markdown", s.adminExportMarkdownHandler).Methods("GET")
+	r.HandleFunc("/admin/restore", s.adminRestoreHandler).Methods("POST")
+	r.HandleFunc("/admin/import", s.adminImportHandler).Methods("POST")
+	r.HandleFunc("/admin", s.adminHandler).Methods("GET")
+	r.HandleFunc("/admin/post", s.adminPostAboutHandler).Methods("GET")
+	r.HandleFunc("/admin/bookmarklet", s.adminBookmarkletHandler).Methods("GET")
+	r.HandleFunc("/admin/sessions", s.adminSessionsHandler).Methods("GET", "POST")
+	r.HandleFunc("/admin/features", s.adminFeaturesHandler).Methods("GET", "POST")
+	r.HandleFunc("/admin/jobs", s.adminJobsHandler).Methods("GET", "POST")
+	r.HandleFunc("/admin/debug/datastore", s.adminDatastoreHandler).Methods("GET", "POST")
+	r.Handle("/auth/session", limited(s.sessionHandler)).Methods("GET", "POST")
+	r.HandleFunc("/logout", s.logoutHandler).Methods("POST")
+	if login, ok := s.ad.(auth.Login); ok {
+		r.PathPrefix("/auth/").Handler(limiter.Middleware(login)).Methods("GET")
+	}
+	r.Handle("/debug/vars", s.adminOnly(expvar.Handler())).Methods("GET")
+	r.Handle("/debug/requests", s.adminOnly(http.HandlerFunc(requestsHandler))).Methods("GET")
+	r.Handle("/debug/pprof/cmdline", s.adminOnly(http.HandlerFunc(pprof.Cmdline))).Methods("GET")
+	r.Handle("/debug/pprof/profile", s.adminOnly(http.HandlerFunc(pprof.Profile))).Methods("GET")
+	r.Handle("/debug/pprof/symbol", s.adminOnly(http.HandlerFunc(pprof.Symbol))).Methods("GET", "POST")
+	r.Handle("/debug/pprof/trace", s.adminOnly(http.HandlerFunc(pprof.Trace))).Methods("GET")
+	r.PathPrefix("/debug/pprof/").Handler(s.adminOnly(http.HandlerFunc(pprof.Index))).Methods("GET")
+	r.Handle("/feed", s.pageCache.Middleware(http.HandlerFunc(s.feedHandler))).Methods("GET", "HEAD")
+	r.HandleFunc("/feed/private", s.privateFeedHandler).Methods("GET", "HEAD")
+	r.Handle("/twtxt.txt", s.shared(s.twtxtHandler)).Methods("GET", "HEAD")
+	r.Handle("/photos", counted(s.shared(s.photosHandler))).Methods("GET", "HEAD")
+	r.Handle("/photos/feed", s.shared(s.photosFeedHandler)).Methods("GET", "HEAD")
+	r.Handle("/tag/{tag}", counted(s.pageCache.Middleware(http.HandlerFunc(s.tagHandler)))).Methods("GET", "HEAD")
+	r.Handle("/tag/{tag}/feed", s.pageCache.Middleware(http.HandlerFunc(s.tagFeedHandler))).Methods("GET", "HEAD")
+	r.Handle("/kind/{kind}", counted(s.pageCache.Middleware(http.HandlerFunc(s.kindHandler)))).Methods("GET", "HEAD")
+	r.Handle("/kind/{kind}/feed", s.pageCache.Middleware(http.HandlerFunc(s.kindFeedHandler))).Methods("GET", "HEAD")
+	r.Handle("/kind/{kind}/podcast", s.pageCache.Middleware(http.HandlerFunc(s.kindPodcastHandler))).Methods("GET", "HEAD")
+	r.Handle("/onthisday", counted(s.shared(s.onThisDayHandler))).Methods("GET", "HEAD")
+	r.Handle("/links", counted(s.shared(s.linksHandler))).Methods("GET", "HEAD")
+	r.HandleFunc("/s/{code}", s.shortURLHandler).Methods("GET", "HEAD")
+	r.Handle("/", counted(s.advertised(s.pageCache.Middleware(http.HandlerFunc(s.indexHandler))))).Methods("GET", "HEAD")
+	r.Handle("/entry/{id}/interact", limited(s.interactHandler)).Methods("POST")
+	r.Handle("/entry/{id}", counted(s.advertised(s.pageCache.Middleware(http.HandlerFunc(s.entryHandler))))).Methods("GET", "HEAD")
+	r.HandleFunc("/service-worker.js", s.serviceWorkerHandler).Methods("GET")
+	r.HandleFunc("/offline", s.offlineHandler).Methods("GET")
+	r.Handle("/manifest.json", cachecontrol.Middleware(cachecontrol.STATIC, http.HandlerFunc(s.manifestHandler))).Methods("GET", "HEAD")
+	r.Handle("/api/quick", limited(s.quickPostHandler)).Methods("POST")
+	r.Handle("/api/entries", s.apiOnly(s.apiEntriesHandler)).Methods("GET")
+	r.Handle("/api/entries/{id}", s.apiOnly(s.apiDeleteEntryHandler)).Methods("DELETE")
+	r.Handle("/api/entries/{id}/webmentions", s.apiOnly(s.apiWebMentionsHandler)).Methods("POST")
+	r.Handle("/api/backup", s.apiOnly(s.apiBackupHandler)).Methods("POST")
+	r.Handle("/api/mentions", s.apiOnly(s.apiMentionsHandler)).Methods("GET")
+	r.HandleFunc("/robots.txt", s.robotsHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/readyz", s.readyzHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/graphql", s.graphqlHandler).Methods("GET", "POST", "OPTIONS")
+	r.Handle("/push/subscribe", limited(s.pushSubscribeHandler)).Methods("POST")
+	r.Handle("/subscribe", limited(s.subscribeHandler)).Methods("POST")
+	r.HandleFunc("/email/inbound", s.emailReplyHandler).Methods("POST")
+	r.Handle("/webmention", limited(s.webmentionHandler)).Methods("POST")
+	r.Handle(tasks.PREFIX+"{name}", s.taskQueue).Methods("POST")
+	r.Handle("/websub/callback/{id}", limited(s.websubCallbackHandler)).Methods("GET", "POST")
+	r.HandleFunc("/subscribe/confirm", s.subscribeConfirmHandler).Methods("GET")
+	r.HandleFunc("/unsubscribe", s.unsubscribeHandler).Methods("GET")
+	r.HandleFunc("/.well-known/nodeinfo", s.nodeInfoWellKnownHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/api/v1/instance", s.mastoInstanceHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/api/v1/accounts/lookup", s.mastoLookupHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/api/v1/accounts/{id}", s.mastoAccountHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/api/v1/accounts/{id}/statuses", s.mastoTimelineHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/api/v1/statuses/{id}", s.mastoStatusHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/api/v1/timelines/public", s.mastoTimelineHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/nodeinfo/2.1", s.nodeInfoHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/.well-known/host-meta", s.hostMetaHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/.well-known/host-meta.xrd", s.hostMetaHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/.well-known/host-meta.jrd", s.hostMetaJSONHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/.well-known/host-meta.json", s.hostMetaJSONHandler).Methods("GET", "HEAD")
+	for _, p := range s.config.GetStringSlice(BRIDGE_PATHS) {
+		r.HandleFunc(p, s.makeRedirectHandler(p)).Methods("GET", "HEAD")
+	}
+}
+
+// start adds the periodic jobs of the stream and starts running them.
+func (s *Server) start() {
+	if s.config.GetBool(MIRROR) {
+		// The jobs all write to Datastore, or send what the primary already
+		// sends.
+		s.startMirrorRefresh()
+	} else {
+		s.startDigests()
+		s.startBackfeed()
+		s.startBackups()
+		s.startOnThisDayReminders()
+		s.startLinkChecks()
+		s.startPurgeDeleted()
+		s.startReferrerFlush()
+		s.startReverifyMentions()
+		s.startInteractions()
+		s.startBackfillPhotos()
+		s.startLockEntries()
+		s.startWebSubRenewals()
+	}
+	s.startJobs()
+}
+
+func main() {
+	log := logger.New()
+	config := loadConfig(log)
+	s, err := openServer(config, log)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *restore != "" {
+		s.restoreFromFile(*restore)
+		return
+	}
+	others, err := openSites(config, log)
+	if err != nil {
+		log.Fatal(err)
+	}
+	all, err := newSites(append([]*Server{s}, others...))
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.start()
+	for _, site := range others {
+		site.start()
+	}
+
+	// Serve the sites directly rather than through http.DefaultServeMux so
+	// that packages like expvar can't register public handlers.
+	h := traceRequests(all)
+	if s.config.GetBool(AUTOCERT) {
+		log.Fatal(s.serveAutocert(h, all.hosts()))
+	}
+	log.Fatal(s.serve(h))
 }
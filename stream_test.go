@@ -0,0 +1,489 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jcgregorio/stream-run/entries"
+	"github.com/jcgregorio/stream-run/mentions"
+	"github.com/jcgregorio/stream-run/outbox"
+	"github.com/jcgregorio/stream-run/sessions"
+	"github.com/jcgregorio/stream-run/sharetarget"
+	"github.com/jcgregorio/stream-run/tasks"
+)
+
+// request is a request made to a test Server.
+type request struct {
+	method string
+	path   string
+
+	// form is sent url encoded, and json as application/json.
+	form url.Values
+	json string
+
+	// admin sends the admin's session cookie, and token the admin's API
+	// token.
+	admin bool
+	token bool
+}
+
+// serve makes req of s and returns the response.
+func serve(s *Server, req request) *httptest.ResponseRecorder {
+	var r *http.Request
+	switch {
+	case req.form != nil:
+		r = httptest.NewRequest(req.method, req.path, strings.NewReader(req.form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	case req.json != "":
+		r = httptest.NewRequest(req.method, req.path, strings.NewReader(req.json))
+		r.Header.Set("Content-Type", "application/json")
+	default:
+		r = httptest.NewRequest(req.method, req.path, nil)
+	}
+	if req.admin {
+		r.AddCookie(&http.Cookie{Name: sessions.COOKIE, Value: testSession})
+	}
+	if req.token {
+		r.Header.Set("Authorization", "Bearer "+testToken)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+// seedEntries adds a public, an unlisted, and a private entry, with the ids
+// "public", "unlisted", and "private".
+func seedEntries(ts *testStores) {
+	created := time.Now().Add(-time.Hour)
+	ts.entries.add(&entries.Entry{ID: "public", Title: "Hello", Content: "Hello #world", Tags: []string{"world"}, Visibility: entries.PUBLIC, Kind: entries.NOTE, Created: created, Updated: created})
+	ts.entries.add(&entries.Entry{ID: "unlisted", Content: "Unlisted", Visibility: entries.UNLISTED, Kind: entries.NOTE, Created: created.Add(time.Minute), Updated: created})
+	ts.entries.add(&entries.Entry{ID: "private", Content: "Private", Visibility: entries.PRIVATE, Kind: entries.NOTE, Created: created.Add(2 * time.Minute), Updated: created})
+}
+
+func TestRoutes(t *testing.T) {
+	config := testConfig("https://example.com")
+	config.Set(RECEIVE_WEBMENTIONS, true)
+	s, ts := newTestServer(t, config)
+	seedEntries(ts)
+
+	tests := []struct {
+		req  request
+		want int
+	}{
+		{request{method: "GET", path: "/img/320/missing.jpg"}, http.StatusNotFound},
+		{request{method: "GET", path: s.assetDB.URL("stream.css")}, http.StatusOK},
+		{request{method: "GET", path: "/images/missing.png"}, http.StatusNotFound},
+
+		{request{method: "POST", path: "/admin/new", form: url.Values{"content": {"New"}}}, http.StatusUnauthorized},
+		{request{method: "POST", path: "/admin/new", form: url.Values{"content": {"New"}}, admin: true}, http.StatusFound},
+		{request{method: "GET", path: "/admin/edit/public"}, http.StatusUnauthorized},
+		{request{method: "GET", path: "/admin/edit/public", admin: true}, http.StatusOK},
+		{request{method: "GET", path: "/admin/edit/missing", admin: true}, http.StatusNotFound},
+		{request{method: "POST", path: "/admin/edit/public", form: url.Values{}, admin: true}, http.StatusBadRequest},
+		{request{method: "GET", path: "/admin/tokens", admin: true}, http.StatusOK},
+		{request{method: "GET", path: "/admin/blocks", admin: true}, http.StatusOK},
+		{request{method: "GET", path: "/admin/stats", admin: true}, http.StatusOK},
+		{request{method: "GET", path: "/admin/webmentions", admin: true}, http.StatusOK},
+		{request{method: "GET", path: "/admin/syndication", admin: true}, http.StatusOK},
+		{request{method: "GET", path: "/admin/snippets", admin: true}, http.StatusOK},
+		{request{method: "GET", path: "/admin/api/search?q=hello", admin: true}, http.StatusOK},
+		{request{method: "GET", path: "/admin/api/entry/public"}, http.StatusUnauthorized},
+		{request{method: "GET", path: "/admin/api/entry/public", admin: true}, http.StatusOK},
+		{request{method: "POST", path: "/admin/undelete", form: url.Values{"id": {"missing"}}, admin: true}, http.StatusFound},
+		{request{method: "GET", path: "/admin/media", admin: true}, http.StatusOK},
+		{request{method: "GET", path: "/admin/linkrot", admin: true}, http.StatusOK},
+		{request{method: "GET", path: "/admin/moderation", admin: true}, http.StatusOK},
+		{request{method: "GET", path: "/admin/backup.json"}, http.StatusUnauthorized},
+		{request{method: "GET", path: "/admin/backup.json", admin: true}, http.StatusOK},
+		{request{method: "GET", path: "/admin/export/markdown", admin: true}, http.StatusOK},
+		{request{method: "POST", path: "/admin/restore", admin: true}, http.StatusBadRequest},
+		{request{method: "POST", path: "/admin/import", admin: true}, http.StatusBadRequest},
+		{request{method: "GET", path: "/admin"}, http.StatusOK},
+		{request{method: "GET", path: "/admin", admin: true}, http.StatusOK},
+		{request{method: "GET", path: "/admin/post?url=https://example.org/", admin: true}, http.StatusOK},
+		{request{method: "GET", path: "/admin/bookmarklet", admin: true}, http.StatusOK},
+		{request{method: "GET", path: "/admin/sessions", admin: true}, http.StatusOK},
+		{request{method: "GET", path: "/admin/features", admin: true}, http.StatusOK},
+		{request{method: "GET", path: "/admin/jobs", admin: true}, http.StatusOK},
+		{request{method: "GET", path: "/admin/debug/datastore", admin: true}, http.StatusOK},
+		{request{method: "POST", path: "/auth/session"}, http.StatusNoContent},
+		{request{method: "POST", path: "/logout", admin: true}, http.StatusFound},
+
+		{request{method: "GET", path: "/debug/vars"}, http.StatusUnauthorized},
+		{request{method: "GET", path: "/debug/vars", admin: true}, http.StatusOK},
+		{request{method: "GET", path: "/debug/requests", admin: true}, http.StatusOK},
+		{request{method: "GET", path: "/debug/pprof/cmdline", admin: true}, http.StatusOK},
+		{request{method: "GET", path: "/debug/pprof/profile"}, http.StatusUnauthorized},
+		{request{method: "GET", path: "/debug/pprof/symbol", admin: true}, http.StatusOK},
+		{request{method: "GET", path: "/debug/pprof/trace"}, http.StatusUnauthorized},
+		{request{method: "GET", path: "/debug/pprof/", admin: true}, http.StatusOK},
+
+		{request{method: "GET", path: "/feed"}, http.StatusOK},
+		{request{method: "GET", path: "/feed/private"}, http.StatusUnauthorized},
+		{request{method: "GET", path: "/twtxt.txt"}, http.StatusOK},
+		{request{method: "GET", path: "/photos"}, http.StatusOK},
+		{request{method: "GET", path: "/photos/feed"}, http.StatusOK},
+		{request{method: "GET", path: "/tag/world"}, http.StatusOK},
+		{request{method: "GET", path: "/tag/world/feed"}, http.StatusOK},
+		{request{method: "GET", path: "/kind/note"}, http.StatusOK},
+		{request{method: "GET", path: "/kind/note/feed"}, http.StatusOK},
+		{request{method: "GET", path: "/kind/audio/podcast"}, http.StatusOK},
+		{request{method: "GET", path: "/onthisday"}, http.StatusOK},
+		{request{method: "GET", path: "/links"}, http.StatusOK},
+		{request{method: "GET", path: "/s/public"}, http.StatusMovedPermanently},
+		{request{method: "GET", path: "/s/missing"}, http.StatusNotFound},
+		{request{method: "GET", path: "/"}, http.StatusOK},
+		{request{method: "POST", path: "/entry/public/interact", form: url.Values{"handle": {"@you@example.social"}}}, http.StatusNotFound},
+		{request{method: "GET", path: "/entry/public"}, http.StatusOK},
+		{request{method: "GET", path: "/entry/unlisted"}, http.StatusOK},
+		{request{method: "GET", path: "/entry/private"}, http.StatusNotFound},
+		{request{method: "GET", path: "/entry/private", admin: true}, http.StatusOK},
+		{request{method: "GET", path: "/entry/missing"}, http.StatusNotFound},
+		{request{method: "GET", path: "/service-worker.js"}, http.StatusOK},
+		{request{method: "GET", path: "/offline"}, http.StatusOK},
+		{request{method: "GET", path: "/manifest.json"}, http.StatusOK},
+
+		{request{method: "POST", path: "/api/quick", form: url.Values{"content": {"Quick"}}}, http.StatusUnauthorized},
+		{request{method: "GET", path: "/api/entries"}, http.StatusUnauthorized},
+		{request{method: "GET", path: "/api/entries", token: true}, http.StatusOK},
+		{request{method: "POST", path: "/api/entries/public/webmentions", token: true}, http.StatusNoContent},
+		{request{method: "POST", path: "/api/backup", token: true}, http.StatusBadRequest},
+		{request{method: "GET", path: "/api/mentions", token: true}, http.StatusOK},
+		{request{method: "GET", path: "/robots.txt"}, http.StatusOK},
+		{request{method: "GET", path: "/readyz"}, http.StatusOK},
+		{request{method: "POST", path: "/graphql", json: `{"query": "{ entries { nodes { id } } }"}`}, http.StatusOK},
+		{request{method: "POST", path: "/push/subscribe", json: `{"endpoint": "https://push.example.org/1", "keys": {"p256dh": "a", "auth": "b"}}`}, http.StatusCreated},
+		{request{method: "POST", path: "/subscribe", form: url.Values{"email": {"reader@example.org"}}}, http.StatusOK},
+		{request{method: "GET", path: "/subscribe/confirm?token=reader@example.org"}, http.StatusOK},
+		{request{method: "GET", path: "/unsubscribe?token=reader@example.org"}, http.StatusOK},
+		{request{method: "GET", path: "/unsubscribe?token=reader@example.org"}, http.StatusNotFound},
+		{request{method: "POST", path: "/email/inbound", form: url.Values{}}, http.StatusUnauthorized},
+		{request{method: "POST", path: "/webmention", form: url.Values{"source": {"https://example.org/"}, "target": {"https://elsewhere.example.org/"}}}, http.StatusBadRequest},
+		{request{method: "POST", path: tasks.PREFIX + "webmentions"}, http.StatusUnauthorized},
+		{request{method: "GET", path: "/websub/callback/1"}, http.StatusNotFound},
+
+		{request{method: "GET", path: "/.well-known/nodeinfo"}, http.StatusOK},
+		{request{method: "GET", path: "/api/v1/instance"}, http.StatusOK},
+		{request{method: "GET", path: "/api/v1/accounts/lookup?acct=nobody"}, http.StatusNotFound},
+		{request{method: "GET", path: "/api/v1/accounts/1"}, http.StatusOK},
+		{request{method: "GET", path: "/api/v1/accounts/1/statuses"}, http.StatusOK},
+		{request{method: "GET", path: "/api/v1/statuses/public"}, http.StatusOK},
+		{request{method: "GET", path: "/api/v1/statuses/private"}, http.StatusNotFound},
+		{request{method: "GET", path: "/api/v1/timelines/public"}, http.StatusOK},
+		{request{method: "GET", path: "/nodeinfo/2.1"}, http.StatusOK},
+		{request{method: "GET", path: "/.well-known/host-meta"}, http.StatusOK},
+		{request{method: "GET", path: "/.well-known/host-meta.xrd"}, http.StatusOK},
+		{request{method: "GET", path: "/.well-known/host-meta.jrd"}, http.StatusOK},
+		{request{method: "GET", path: "/.well-known/host-meta.json"}, http.StatusOK},
+		{request{method: "GET", path: "/.well-known/webfinger?resource=acct:test@example.com"}, http.StatusFound},
+		{request{method: "DELETE", path: "/api/entries/unlisted", token: true}, http.StatusNoContent},
+		{request{method: "GET", path: "/entry/unlisted"}, http.StatusNotFound},
+		{request{method: "GET", path: "/no/such/page"}, http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		w := serve(s, tc.req)
+		assert.Equal(t, tc.want, w.Code, "%s %s admin=%v token=%v", tc.req.method, tc.req.path, tc.req.admin, tc.req.token)
+	}
+}
+
+func TestAdmin_ShareTarget(t *testing.T) {
+	s, _ := newTestServer(t, testConfig("https://example.com"))
+	s.shareExtractor = sharetarget.New(func(u string) (*goquery.Document, error) {
+		return goquery.NewDocumentFromReader(strings.NewReader(`<html><head><title>Shared Page</title></head></html>`))
+	})
+
+	w := serve(s, request{method: "GET", path: "/admin?" + url.Values{"title": {"Shared"}, "text": {"Look at this"}, "url": {"https://example.org/page"}}.Encode(), admin: true})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "https://example.org/page")
+	assert.Contains(t, w.Body.String(), "Look at this")
+}
+
+func TestAdminEdit_UpdateDeleteAndConflict(t *testing.T) {
+	s, ts := newTestServer(t, testConfig("https://example.com"))
+	seedEntries(ts)
+
+	w := serve(s, request{method: "POST", path: "/admin/edit/public", form: url.Values{"action": {"update"}, "version": {"0"}, "title": {"Hello"}, "content": {"Edited"}}, admin: true})
+	assert.Equal(t, http.StatusOK, w.Code)
+	entry, err := ts.entries.Get(nil, "public")
+	assert.NoError(t, err)
+	assert.Equal(t, "Edited", entry.Content)
+	assert.Equal(t, int64(1), entry.Version)
+
+	// Saving again from the page loaded before the edit is a conflict, which
+	// shows the current content and leaves it alone.
+	w = serve(s, request{method: "POST", path: "/admin/edit/public", form: url.Values{"action": {"update"}, "version": {"0"}, "title": {"Hello"}, "content": {"Stale"}}, admin: true})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	entry, err = ts.entries.Get(nil, "public")
+	assert.NoError(t, err)
+	assert.Equal(t, "Edited", entry.Content)
+
+	w = serve(s, request{method: "POST", path: "/admin/edit/public", form: url.Values{"action": {"alias"}, "alias": {"old-slug"}}, admin: true})
+	assert.Equal(t, http.StatusFound, w.Code)
+	w = serve(s, request{method: "GET", path: "/entry/old-slug"})
+	assert.Equal(t, http.StatusMovedPermanently, w.Code)
+	assert.Equal(t, "/entry/public", w.Header().Get("Location"))
+
+	w = serve(s, request{method: "POST", path: "/admin/edit/public", form: url.Values{"action": {"delete"}}, admin: true})
+	assert.Equal(t, http.StatusFound, w.Code)
+	_, err = ts.entries.Get(nil, "public")
+	assert.Equal(t, entries.ErrNotFound, err)
+
+	w = serve(s, request{method: "POST", path: "/admin/undelete", form: url.Values{"id": {"public"}}, admin: true})
+	assert.Equal(t, http.StatusFound, w.Code)
+	_, err = ts.entries.Get(nil, "public")
+	assert.NoError(t, err)
+}
+
+func TestPageCache_HiddenEntriesForgotten(t *testing.T) {
+	s, ts := newTestServer(t, testConfig("https://example.com"))
+	seedEntries(ts)
+	saved := func(path string) bool {
+		serve(s, request{method: "GET", path: path})
+		_, _, ok := s.pageCache.Last(httptest.NewRequest("GET", path, nil))
+		return ok
+	}
+
+	// Made private.
+	assert.True(t, saved("/entry/public"))
+	w := serve(s, request{method: "POST", path: "/admin/edit/public", form: url.Values{"action": {"update"}, "version": {"0"}, "title": {"Hello"}, "content": {"Hello"}, "visibility": {"private"}}, admin: true})
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, _, ok := s.pageCache.Last(httptest.NewRequest("GET", "/entry/public", nil))
+	assert.False(t, ok)
+
+	// Deleted.
+	assert.True(t, saved("/entry/unlisted"))
+	w = serve(s, request{method: "POST", path: "/admin/edit/unlisted", form: url.Values{"action": {"delete"}}, admin: true})
+	assert.Equal(t, http.StatusFound, w.Code)
+	_, _, ok = s.pageCache.Last(httptest.NewRequest("GET", "/entry/unlisted", nil))
+	assert.False(t, ok)
+}
+
+func TestAdminAPIEntry_Patch(t *testing.T) {
+	s, ts := newTestServer(t, testConfig("https://example.com"))
+	seedEntries(ts)
+
+	w := serve(s, request{method: "PATCH", path: "/admin/api/entry/public", json: `{"title": "Patched", "version": 0}`, admin: true})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Patched")
+	entry, err := ts.entries.Get(nil, "public")
+	assert.NoError(t, err)
+	assert.Equal(t, "Patched", entry.Title)
+
+	// A stale version returns the current entry.
+	w = serve(s, request{method: "PATCH", path: "/admin/api/entry/public", json: `{"title": "Stale", "version": 0}`, admin: true})
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "Patched")
+
+	// Only JSON is accepted, so a cross-site form can't make the request.
+	w = serve(s, request{method: "PATCH", path: "/admin/api/entry/public", form: url.Values{"title": {"Form"}}, admin: true})
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+}
+
+func TestEntry_CapabilityNotCached(t *testing.T) {
+	t.Setenv(CAPABILITY_SECRET_ENV, "secret")
+	s, ts := newTestServer(t, testConfig("https://example.com"))
+	seedEntries(ts)
+
+	path := "/entry/private?cap=" + capability("private")
+	w := serve(s, request{method: "GET", path: path})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "private, no-store", w.Header().Get("Cache-Control"))
+
+	w = serve(s, request{method: "GET", path: path})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("X-Page-Cache"))
+	_, _, ok := s.pageCache.Last(httptest.NewRequest("GET", path, nil))
+	assert.False(t, ok)
+}
+
+func TestAdminModeration_Trust(t *testing.T) {
+	config := testConfig("https://example.com")
+	config.Set(VOUCH_DOMAINS, []string{"vouched.example"})
+	s, ts := newTestServer(t, config)
+	seedEntries(ts)
+	m := &mentions.Mention{EntryID: "public", Source: "https://www.friend.example/reply", Pending: true}
+	_, err := ts.mentions.Put(context.Background(), m)
+	assert.NoError(t, err)
+	assert.False(t, s.trustDB.Trusted("friend.example"))
+
+	w := serve(s, request{method: "POST", path: "/admin/moderation", form: url.Values{"id": {m.ID}, "action": {"trust"}}, admin: true})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, s.trustDB.Trusted("friend.example"))
+	assert.True(t, s.trustDB.Trusted("vouched.example"))
+	assert.Empty(t, ts.mentions.list(func(m *mentions.Mention) bool { return m.Pending }))
+	assert.Contains(t, w.Body.String(), "friend.example")
+
+	w = serve(s, request{method: "POST", path: "/admin/moderation", form: url.Values{"domain": {"friend.example"}, "action": {"untrust"}}, admin: true})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, s.trustDB.Trusted("friend.example"))
+}
+
+func TestPrivateFeed_ListError(t *testing.T) {
+	s, ts := newTestServer(t, testConfig("https://example.com"))
+	seedEntries(ts)
+
+	w := serve(s, request{method: "GET", path: "/feed/private?token=" + testToken})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<feed")
+
+	ts.entries.listErr = fmt.Errorf("Datastore unavailable")
+	w = serve(s, request{method: "GET", path: "/feed/private?token=" + testToken})
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestSubscribe_AlreadySubscribed(t *testing.T) {
+	s, ts := newTestServer(t, testConfig("https://example.com"))
+
+	w := serve(s, request{method: "POST", path: "/subscribe", form: url.Values{"email": {"reader@example.org"}}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, ts.mailer.subjects, 1)
+	serve(s, request{method: "GET", path: "/subscribe/confirm?token=reader@example.org"})
+
+	// The response doesn't say the address is subscribed, the email does.
+	first := w.Body.String()
+	w = serve(s, request{method: "POST", path: "/subscribe", form: url.Values{"email": {"reader@example.org"}}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, first, w.Body.String())
+	assert.Contains(t, w.Body.String(), "Check your email to confirm your subscription.")
+	assert.Equal(t, []string{"Confirm your subscription", "You are already subscribed"}, ts.mailer.subjects)
+}
+
+func TestWebMentionContent_BridgesOnlyForPublic(t *testing.T) {
+	config := testConfig("https://example.com")
+	config.Set(BRIDGES, []string{"https://brid.gy/publish/mastodon"})
+	s, _ := newTestServer(t, config)
+
+	public := s.webMentionContent(&entryContent{SafeContent: "<p>Hi</p>", Visibility: entries.PUBLIC})
+	assert.Contains(t, public, "https://brid.gy/publish/mastodon")
+
+	// Older entries have no visibility and are public.
+	old := s.webMentionContent(&entryContent{SafeContent: "<p>Hi</p>"})
+	assert.Contains(t, old, "https://brid.gy/publish/mastodon")
+
+	unlisted := s.webMentionContent(&entryContent{SafeContent: "<p>Hi</p>", Visibility: entries.UNLISTED})
+	assert.Equal(t, "<p>Hi</p>", unlisted)
+
+	optedOut := s.webMentionContent(&entryContent{SafeContent: "<p>Hi</p>", Visibility: entries.PUBLIC, NoBridges: true})
+	assert.Equal(t, "<p>Hi</p>", optedOut)
+}
+
+func TestRejected(t *testing.T) {
+	assert.True(t, rejected(http.StatusBadRequest))
+	assert.True(t, rejected(http.StatusNotFound))
+	assert.False(t, rejected(http.StatusRequestTimeout))
+	assert.False(t, rejected(http.StatusTooManyRequests))
+	assert.False(t, rejected(http.StatusInternalServerError))
+	assert.False(t, rejected(http.StatusServiceUnavailable))
+}
+
+func TestRejectedBefore(t *testing.T) {
+	s, _ := newTestServer(t, testConfig("https://example.com"))
+	assert.False(t, s.rejectedBefore("public", "https://other.example/post"))
+
+	// Failures that may succeed later don't count.
+	s.outboxDB.Record(context.Background(), &outbox.Attempt{EntryID: "public", Target: "https://other.example/post", Status: http.StatusServiceUnavailable})
+	assert.False(t, s.rejectedBefore("public", "https://other.example/post"))
+
+	s.outboxDB.Record(context.Background(), &outbox.Attempt{EntryID: "public", Target: "https://other.example/post", Status: http.StatusBadRequest})
+	assert.True(t, s.rejectedBefore("public", "https://other.example/post"))
+	assert.False(t, s.rejectedBefore("public", "https://another.example/post"))
+	assert.False(t, s.rejectedBefore("unlisted", "https://other.example/post"))
+}
+
+func TestSiteConfig(t *testing.T) {
+	config := testConfig("https://example.com")
+	config.Set(ADMINS, []string{"me@example.com"})
+	config.Set(BACKUP_BUCKET, "backups")
+	config.Set(IMAGES, "images")
+	config.Set(SITES, map[string]interface{}{
+		"photos": map[string]interface{}{
+			HOST:                "https://photos.example.com",
+			DATASTORE_NAMESPACE: "photos",
+			ADMINS:              []string{"photographer@example.com"},
+			TEMPLATES:           "photos/templates",
+		},
+		"notes": map[string]interface{}{
+			HOST:          "https://notes.example.com",
+			IMAGES:        "/var/notes/images",
+			BACKUP_PREFIX: "notes/",
+		},
+	})
+
+	site, err := siteConfig(config, "photos")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://photos.example.com", site.GetString(HOST))
+	assert.Equal(t, "photos", site.GetString(DATASTORE_NAMESPACE))
+	assert.Equal(t, []string{"photographer@example.com"}, site.GetStringSlice(ADMINS))
+	assert.Equal(t, "photos/templates", site.GetString(TEMPLATES))
+
+	// Everything else comes from the top level, except the other sites.
+	assert.Equal(t, "Test Author", site.GetString(AUTHOR))
+	assert.Equal(t, "backups", site.GetString(BACKUP_BUCKET))
+	assert.False(t, site.IsSet(SITES))
+
+	// Media and backups aren't shared unless the site's block says so.
+	assert.Equal(t, filepath.Join("sites", "photos", "images"), site.GetString(IMAGES))
+	assert.Equal(t, "stream-backup-photos/", site.GetString(BACKUP_PREFIX))
+	notes, err := siteConfig(config, "notes")
+	assert.NoError(t, err)
+	assert.Equal(t, "/var/notes/images", notes.GetString(IMAGES))
+	assert.Equal(t, "notes/", notes.GetString(BACKUP_PREFIX))
+
+	// The top level is unchanged.
+	assert.Equal(t, "https://example.com", config.GetString(HOST))
+	assert.Equal(t, []string{"me@example.com"}, config.GetStringSlice(ADMINS))
+}
+
+func TestSites_RoutesByHost(t *testing.T) {
+	primary, _ := newTestServer(t, testConfig("https://example.com"))
+
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "offline.html"), []byte("Photos are offline."), 0644))
+	photosConfig := testConfig("https://photos.example.com")
+	photosConfig.Set(TEMPLATES, dir)
+	photos, photosStores := newTestServer(t, photosConfig)
+	photosStores.entries.add(&entries.Entry{ID: "sunset", Content: "Sunset", Visibility: entries.PUBLIC, Kind: entries.NOTE})
+
+	all, err := newSites([]*Server{primary, photos})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example.com", "photos.example.com"}, all.hosts())
+
+	get := func(host, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Host = host
+		w := httptest.NewRecorder()
+		all.ServeHTTP(w, r)
+		return w
+	}
+
+	// Each site has its own entries.
+	assert.Equal(t, http.StatusOK, get("photos.example.com", "/entry/sunset").Code)
+	assert.Equal(t, http.StatusOK, get("Photos.Example.com:443", "/entry/sunset").Code)
+	assert.Equal(t, http.StatusNotFound, get("example.com", "/entry/sunset").Code)
+
+	// And its own HOST and templates.
+	assert.Contains(t, get("photos.example.com", "/.well-known/host-meta").Body.String(), "https://photos.example.com/.well-known/webfinger")
+	assert.Equal(t, "Photos are offline.", get("photos.example.com", "/offline").Body.String())
+	assert.NotContains(t, get("example.com", "/offline").Body.String(), "Photos are offline.")
+
+	// Unknown hosts go to the first site.
+	assert.Contains(t, get("127.0.0.1:8080", "/.well-known/host-meta").Body.String(), "https://example.com/.well-known/webfinger")
+}
+
+func TestNewSites_DuplicateHost(t *testing.T) {
+	a, _ := newTestServer(t, testConfig("https://example.com"))
+	b, _ := newTestServer(t, testConfig("https://EXAMPLE.com/"))
+	_, err := newSites([]*Server{a, b})
+	assert.Error(t, err)
+}
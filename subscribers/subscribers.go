@@ -0,0 +1,152 @@
+// Package subscribers stores email newsletter subscribers.
+package subscribers
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+
+	"github.com/jcgregorio/go-lib/ds"
+	"github.com/jcgregorio/slog"
+)
+
+const (
+	SUBSCRIBER ds.Kind = "Subscriber"
+)
+
+// Subscriber is a single email subscription. Subscriptions aren't active
+// until the address has been confirmed.
+type Subscriber struct {
+	Email     string    `datastore:"email,noindex"`
+	Token     string    `datastore:"token"`
+	Confirmed bool      `datastore:"confirmed"`
+	Created   time.Time `datastore:"created"`
+	LastSent  time.Time `datastore:"last_sent,noindex"`
+}
+
+type Subscribers struct {
+	DS  *ds.DS
+	log slog.Logger
+}
+
+func New(ctx context.Context, project, ns string, log slog.Logger) (*Subscribers, error) {
+	d, err := ds.New(ctx, project, ns)
+	if err != nil {
+		return nil, err
+	}
+	return &Subscribers{
+		DS:  d,
+		log: log,
+	}, nil
+}
+
+func (s *Subscribers) key(email string) *datastore.Key {
+	key := s.DS.NewKey(SUBSCRIBER)
+	key.Name = fmt.Sprintf("%x", md5.Sum([]byte(strings.ToLower(email))))
+	return key
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", b), nil
+}
+
+// Add creates an unconfirmed subscription for the given address and returns
+// the token used to confirm or cancel it. Adding an address that is already
+// subscribed returns the existing token.
+func (s *Subscribers) Add(ctx context.Context, email string) (*Subscriber, error) {
+	addr, err := mail.ParseAddress(email)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid email address %q: %s", email, err)
+	}
+	key := s.key(addr.Address)
+	var sub Subscriber
+	if err := s.DS.Client.Get(ctx, key, &sub); err == nil {
+		return &sub, nil
+	}
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	sub = Subscriber{
+		Email:   addr.Address,
+		Token:   token,
+		Created: time.Now(),
+	}
+	if _, err := s.DS.Client.Put(ctx, key, &sub); err != nil {
+		return nil, err
+	}
+	return &sub, nil
+}
+
+func (s *Subscribers) byToken(ctx context.Context, token string) (*datastore.Key, *Subscriber, error) {
+	if token == "" {
+		return nil, nil, fmt.Errorf("Token must be supplied.")
+	}
+	q := s.DS.NewQuery(SUBSCRIBER).Filter("token =", token).Limit(1)
+	var subs []*Subscriber
+	keys, err := s.DS.Client.GetAll(ctx, q, &subs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil, fmt.Errorf("Unknown token.")
+	}
+	return keys[0], subs[0], nil
+}
+
+// Confirm marks the subscription with the given token as confirmed.
+func (s *Subscribers) Confirm(ctx context.Context, token string) (*Subscriber, error) {
+	key, sub, err := s.byToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	sub.Confirmed = true
+	_, err = s.DS.Client.Put(ctx, key, sub)
+	return sub, err
+}
+
+// Remove deletes the subscription with the given token.
+func (s *Subscribers) Remove(ctx context.Context, token string) error {
+	key, _, err := s.byToken(ctx, token)
+	if err != nil {
+		return err
+	}
+	return s.DS.Client.Delete(ctx, key)
+}
+
+// MarkSent records when the last digest was sent to the subscriber.
+func (s *Subscribers) MarkSent(ctx context.Context, sub *Subscriber, sent time.Time) error {
+	sub.LastSent = sent
+	_, err := s.DS.Client.Put(ctx, s.key(sub.Email), sub)
+	return err
+}
+
+// Confirmed returns all the confirmed subscribers.
+func (s *Subscribers) Confirmed(ctx context.Context) ([]*Subscriber, error) {
+	ret := []*Subscriber{}
+	q := s.DS.NewQuery(SUBSCRIBER).Filter("confirmed =", true)
+	it := s.DS.Client.Run(ctx, q)
+	for {
+		sub := &Subscriber{}
+		_, err := it.Next(sub)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed while reading subscribers: %s", err)
+		}
+		ret = append(ret, sub)
+	}
+	return ret, nil
+}
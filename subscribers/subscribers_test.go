@@ -0,0 +1,59 @@
+package subscribers
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jcgregorio/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func initForTesting(t *testing.T) *Subscribers {
+	if os.Getenv("DATASTORE_EMULATOR_HOST") == "" {
+		t.Skip("Requires a running Cloud Datastore emulator, see entries/entries_test.go.")
+	}
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	s, err := New(context.Background(), "test-project", fmt.Sprintf("test-namespace-%d", r.Uint64()), logger.New())
+	assert.NoError(t, err)
+	return s
+}
+
+func TestSubscribers(t *testing.T) {
+	s := initForTesting(t)
+	ctx := context.Background()
+
+	_, err := s.Add(ctx, "not an address")
+	assert.Error(t, err)
+
+	sub, err := s.Add(ctx, "Reader <reader@example.org>")
+	assert.NoError(t, err)
+	assert.Equal(t, "reader@example.org", sub.Email)
+	assert.False(t, sub.Confirmed)
+
+	again, err := s.Add(ctx, "READER@example.org")
+	assert.NoError(t, err)
+	assert.Equal(t, sub.Token, again.Token)
+
+	subs, err := s.Confirmed(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, subs, 0)
+
+	_, err = s.Confirm(ctx, "unknown")
+	assert.Error(t, err)
+
+	_, err = s.Confirm(ctx, sub.Token)
+	assert.NoError(t, err)
+
+	subs, err = s.Confirmed(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, subs, 1)
+
+	assert.NoError(t, s.Remove(ctx, sub.Token))
+	subs, err = s.Confirmed(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, subs, 0)
+}
@@ -0,0 +1,74 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
+	"cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+)
+
+// CloudTasks queues tasks on a Cloud Tasks queue, which delivers them as
+// POSTs to PREFIX + name on the server.
+type CloudTasks struct {
+	client *cloudtasks.Client
+
+	// queue is the full name of the queue,
+	// "projects/{project}/locations/{location}/queues/{queue}".
+	queue string
+
+	// host is the URL of the server, e.g. "https://stream.bitworking.org".
+	host   string
+	secret string
+}
+
+// QueueName returns the full name of the queue in project and location.
+func QueueName(project, location, queue string) string {
+	return fmt.Sprintf("projects/%s/locations/%s/queues/%s", project, location, queue)
+}
+
+// NewCloudTasks returns a Queue that adds tasks to queue, see QueueName, to
+// be delivered to host with secret.
+func NewCloudTasks(ctx context.Context, queue, host, secret string) (*CloudTasks, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("A secret is required to use Cloud Tasks.")
+	}
+	client, err := cloudtasks.NewClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create Cloud Tasks client: %s", err)
+	}
+	return &CloudTasks{
+		client: client,
+		queue:  queue,
+		host:   strings.TrimSuffix(host, "/"),
+		secret: secret,
+	}, nil
+}
+
+// request returns the request that creates the task name.
+func (c *CloudTasks) request(name string, payload []byte) *cloudtaskspb.CreateTaskRequest {
+	return &cloudtaskspb.CreateTaskRequest{
+		Parent: c.queue,
+		Task: &cloudtaskspb.Task{
+			MessageType: &cloudtaskspb.Task_HttpRequest{
+				HttpRequest: &cloudtaskspb.HttpRequest{
+					HttpMethod: cloudtaskspb.HttpMethod_POST,
+					Url:        c.host + PREFIX + name,
+					Headers: map[string]string{
+						"Content-Type": "application/json",
+						SECRET_HEADER:  c.secret,
+					},
+					Body: payload,
+				},
+			},
+		},
+	}
+}
+
+func (c *CloudTasks) Enqueue(ctx context.Context, name string, payload []byte) error {
+	if _, err := c.client.CreateTask(ctx, c.request(name, payload)); err != nil {
+		return fmt.Errorf("Failed to queue task %q: %s", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+// Package tasks runs the outbound side effects of publishing, such as sending
+// webmentions and push notifications, outside of the request that caused them.
+//
+// By default tasks run in-process, which on App Engine or Cloud Run can be cut
+// short when the instance is shut down. With Cloud Tasks each task is instead
+// an HTTP request back to this server, which Cloud Tasks retries until it
+// succeeds.
+package tasks
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sync"
+
+	"github.com/jcgregorio/slog"
+)
+
+const (
+	// PREFIX is the path tasks are delivered to by Cloud Tasks, followed by
+	// the task name.
+	PREFIX = "/tasks/"
+
+	// SECRET_HEADER is the header that proves a delivered task came from
+	// Cloud Tasks.
+	SECRET_HEADER = "X-Stream-Task-Secret"
+
+	// MAX_PAYLOAD is the largest payload accepted from Cloud Tasks.
+	MAX_PAYLOAD = 64 * 1024
+)
+
+// Handler does the work of a task. Returning an error has Cloud Tasks retry
+// the task, so it should only be done for errors that might be temporary.
+type Handler func(ctx context.Context, payload []byte) error
+
+// Queue delivers tasks to Tasks.Run.
+type Queue interface {
+	// Enqueue arranges for the task name to be run with payload.
+	Enqueue(ctx context.Context, name string, payload []byte) error
+}
+
+// Tasks holds the task handlers and the queue that runs them.
+type Tasks struct {
+	queue  Queue
+	secret string
+	log    slog.Logger
+
+	mutex    sync.Mutex
+	handlers map[string]Handler
+}
+
+// New returns Tasks that run in-process, see SetQueue.
+func New(log slog.Logger) *Tasks {
+	t := &Tasks{
+		log:      log,
+		handlers: map[string]Handler{},
+	}
+	t.queue = &Local{tasks: t}
+	return t
+}
+
+// SetQueue has tasks run from queue, which sends secret in SECRET_HEADER with
+// each task it delivers to ServeHTTP.
+func (t *Tasks) SetQueue(queue Queue, secret string) {
+	t.queue = queue
+	t.secret = secret
+}
+
+// Add adds the handler of the task name.
+func (t *Tasks) Add(name string, h Handler) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.handlers[name] = h
+}
+
+// Run runs the task name with payload now.
+func (t *Tasks) Run(ctx context.Context, name string, payload []byte) error {
+	t.mutex.Lock()
+	h, ok := t.handlers[name]
+	t.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("Unknown task %q.", name)
+	}
+	return h(ctx, payload)
+}
+
+// Enqueue queues the task name with v, encoded as JSON, as its payload. When
+// tasks run in-process the task is run before Enqueue returns.
+func (t *Tasks) Enqueue(ctx context.Context, name string, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("Failed to encode payload of task %q: %s", name, err)
+	}
+	return t.queue.Enqueue(ctx, name, payload)
+}
+
+// Go is like Enqueue, but doesn't wait for tasks run in-process to finish,
+// for work that shouldn't hold up a response. Errors are logged.
+func (t *Tasks) Go(name string, v interface{}) {
+	enqueue := func() {
+		if err := t.Enqueue(context.Background(), name, v); err != nil {
+			t.log.Warningf("Task %q failed: %s", name, err)
+		}
+	}
+	if _, ok := t.queue.(*Local); ok {
+		go enqueue()
+		return
+	}
+	enqueue()
+}
+
+// ServeHTTP runs the tasks delivered by the queue to PREFIX + name. An error
+// response has the queue retry the task.
+func (t *Tasks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if t.secret == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get(SECRET_HEADER)), []byte(t.secret)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	name := path.Base(r.URL.Path)
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MAX_PAYLOAD))
+	if err != nil {
+		http.Error(w, "Failed to read payload.", http.StatusBadRequest)
+		return
+	}
+	if err := t.Run(r.Context(), name, payload); err != nil {
+		t.log.Warningf("Task %q failed, it will be retried: %s", name, err)
+		http.Error(w, "Task failed.", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Local runs tasks in-process, as soon as they are queued.
+type Local struct {
+	tasks *Tasks
+}
+
+func (l *Local) Enqueue(ctx context.Context, name string, payload []byte) error {
+	return l.tasks.Run(ctx, name, payload)
+}
@@ -0,0 +1,103 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	"github.com/jcgregorio/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+type ping struct {
+	ID string `json:"id"`
+}
+
+func TestEnqueue_Local(t *testing.T) {
+	tasks := New(logger.New())
+	var got ping
+	tasks.Add("ping", func(ctx context.Context, payload []byte) error {
+		return json.Unmarshal(payload, &got)
+	})
+	assert.NoError(t, tasks.Enqueue(context.Background(), "ping", ping{ID: "abc"}))
+	assert.Equal(t, "abc", got.ID)
+	assert.Error(t, tasks.Enqueue(context.Background(), "pong", ping{}))
+
+	done := make(chan string, 1)
+	tasks.Add("background", func(ctx context.Context, payload []byte) error {
+		done <- string(payload)
+		return nil
+	})
+	tasks.Go("background", ping{ID: "def"})
+	assert.Equal(t, `{"id":"def"}`, <-done)
+}
+
+// queue records the tasks queued on it.
+type queue struct {
+	names []string
+}
+
+func (q *queue) Enqueue(ctx context.Context, name string, payload []byte) error {
+	q.names = append(q.names, name)
+	return nil
+}
+
+func TestServeHTTP(t *testing.T) {
+	tasks := New(logger.New())
+	q := &queue{}
+	tasks.SetQueue(q, "sesame")
+	fail := true
+	tasks.Add("ping", func(ctx context.Context, payload []byte) error {
+		if fail {
+			return fmt.Errorf("Try again.")
+		}
+		return nil
+	})
+	tasks.Go("ping", ping{})
+	assert.Equal(t, []string{"ping"}, q.names)
+
+	deliver := func(name, secret string) int {
+		r := httptest.NewRequest("POST", PREFIX+name, strings.NewReader(`{"id":"abc"}`))
+		if secret != "" {
+			r.Header.Set(SECRET_HEADER, secret)
+		}
+		w := httptest.NewRecorder()
+		tasks.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusUnauthorized, deliver("ping", ""))
+	assert.Equal(t, http.StatusUnauthorized, deliver("ping", "open"))
+	assert.Equal(t, http.StatusInternalServerError, deliver("ping", "sesame"))
+	assert.Equal(t, http.StatusInternalServerError, deliver("pong", "sesame"))
+	fail = false
+	assert.Equal(t, http.StatusNoContent, deliver("ping", "sesame"))
+}
+
+func TestServeHTTP_NoSecret(t *testing.T) {
+	// In-process tasks aren't delivered over HTTP at all.
+	tasks := New(logger.New())
+	tasks.Add("ping", func(ctx context.Context, payload []byte) error { return nil })
+	w := httptest.NewRecorder()
+	tasks.ServeHTTP(w, httptest.NewRequest("POST", PREFIX+"ping", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestCloudTasks_Request(t *testing.T) {
+	c := &CloudTasks{
+		queue:  QueueName("my-project", "us-central1", "outbound"),
+		host:   "https://example.org",
+		secret: "sesame",
+	}
+	req := c.request("ping", []byte(`{}`))
+	assert.Equal(t, "projects/my-project/locations/us-central1/queues/outbound", req.Parent)
+	h := req.Task.GetHttpRequest()
+	assert.Equal(t, "https://example.org/tasks/ping", h.Url)
+	assert.Equal(t, cloudtaskspb.HttpMethod_POST, h.HttpMethod)
+	assert.Equal(t, "sesame", h.Headers[SECRET_HEADER])
+	assert.Equal(t, []byte(`{}`), h.Body)
+}
@@ -0,0 +1,88 @@
+package templatefuncs
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DEFAULT_LOCALE is used for unknown locales.
+const DEFAULT_LOCALE = "en"
+
+func phrasesFor(locale string) phrases {
+	if p, ok := locales[locale]; ok {
+		return p
+	}
+	return locales[DEFAULT_LOCALE]
+}
+
+// Locales returns the supported locales, sorted.
+func Locales() []string {
+	ret := []string{}
+	for l := range locales {
+		ret = append(ret, l)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Negotiate returns the supported locale that best matches an
+// Accept-Language header, or DEFAULT_LOCALE if none do. Regions are ignored,
+// so "de-AT" matches "de".
+func Negotiate(acceptLanguage string) string {
+	best := DEFAULT_LOCALE
+	bestQ := 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		lang, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if _, ok := locales[lang]; ok && q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
+
+// Translate returns the message, which is in English, in the language of
+// locale.
+func Translate(locale, message string) string {
+	if t, ok := phrasesFor(locale).messages[message]; ok {
+		return t
+	}
+	return message
+}
+
+// placeholders stand in for names in a layout while it's formatted, since
+// the translated names could contain layout elements, e.g. "Montag" starts
+// with "Mon".
+const (
+	monthPlaceholder        = "\x01"
+	shortMonthPlaceholder   = "\x02"
+	weekdayPlaceholder      = "\x03"
+	shortWeekdayPlaceholder = "\x04"
+)
+
+// FormatDate is time.Format with the month and weekday names in the
+// language of locale.
+func FormatDate(t time.Time, layout, locale string) string {
+	p := phrasesFor(locale)
+	layout = strings.NewReplacer(
+		"January", monthPlaceholder,
+		"Jan", shortMonthPlaceholder,
+		"Monday", weekdayPlaceholder,
+		"Mon", shortWeekdayPlaceholder,
+	).Replace(layout)
+	return strings.NewReplacer(
+		monthPlaceholder, p.months[t.Month()-1],
+		shortMonthPlaceholder, p.shortMonths[t.Month()-1],
+		weekdayPlaceholder, p.weekdays[t.Weekday()],
+		shortWeekdayPlaceholder, p.shortWeekdays[t.Weekday()],
+	).Replace(t.Format(layout))
+}
@@ -0,0 +1,275 @@
+// Package templatefuncs provides the functions available to templates, such
+// as formatting times in the configured timezone and language.
+package templatefuncs
+
+import (
+	"fmt"
+	"html/template"
+	"time"
+)
+
+// TRUNC_LENGTH is the number of characters trunc keeps.
+const TRUNC_LENGTH = 80
+
+// Options configures the template functions.
+type Options struct {
+	// Location is the timezone times are displayed in. Defaults to UTC.
+	Location *time.Location
+
+	// DateFormat is the time.Format layout used by the date func. Defaults to
+	// DEFAULT_DATE_FORMAT.
+	DateFormat string
+
+	// Locale is the language of humanTime, date, dateFormat, monthDay,
+	// readingTime, and t, e.g. "en" or "de". Unknown locales fall back to English.
+	Locale string
+}
+
+// DEFAULT_DATE_FORMAT is used if Options.DateFormat is empty.
+const DEFAULT_DATE_FORMAT = "January 2, 2006 3:04 PM MST"
+
+// Trunc shortens s to at most n characters, adding "..." if anything was
+// removed. Unlike slicing the string it never splits a multi-byte character.
+func Trunc(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "..."
+}
+
+// units are the names of a duration unit in one language, singular and
+// plural.
+type units struct {
+	second, seconds string
+	minute, minutes string
+	hour, hours     string
+	day, days       string
+	week, weeks     string
+	month, months   string
+	year, years     string
+}
+
+// phrases are the words for a single language.
+type phrases struct {
+	justNow string
+
+	// ago is a fmt format that takes a duration such as "3 days".
+	ago   string
+	units units
+
+	// months and weekdays are the full names, starting with January and
+	// Sunday, and shortMonths and shortWeekdays the abbreviations.
+	months        [12]string
+	shortMonths   [12]string
+	weekdays      [7]string
+	shortWeekdays [7]string
+
+	// monthDay is the time.Format layout of a day of the year, e.g. "January
+	// 2", in the order the language uses.
+	monthDay string
+
+	// messages translates the reader-facing strings in templates, keyed by
+	// the English. Missing messages are displayed in English.
+	messages map[string]string
+}
+
+var locales = map[string]phrases{
+	"en": {
+		justNow:       "just now",
+		ago:           "%s ago",
+		units:         units{"second", "seconds", "minute", "minutes", "hour", "hours", "day", "days", "week", "weeks", "month", "months", "year", "years"},
+		months:        [12]string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+		shortMonths:   [12]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"},
+		weekdays:      [7]string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"},
+		shortWeekdays: [7]string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"},
+		monthDay:      "January 2",
+	},
+	"de": {
+		justNow:       "gerade eben",
+		ago:           "vor %s",
+		units:         units{"Sekunde", "Sekunden", "Minute", "Minuten", "Stunde", "Stunden", "Tag", "Tagen", "Woche", "Wochen", "Monat", "Monaten", "Jahr", "Jahren"},
+		months:        [12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+		shortMonths:   [12]string{"Jan.", "Feb.", "März", "Apr.", "Mai", "Juni", "Juli", "Aug.", "Sept.", "Okt.", "Nov.", "Dez."},
+		weekdays:      [7]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+		shortWeekdays: [7]string{"So.", "Mo.", "Di.", "Mi.", "Do.", "Fr.", "Sa."},
+		monthDay:      "2. January",
+		messages: map[string]string{
+			"Next":                 "Weiter",
+			"Photos":               "Fotos",
+			"On This Day":          "An diesem Tag",
+			"%d min read":          "%d Min. Lesezeit",
+			"Related":              "Verwandte Beiträge",
+			"Interactions":         "Reaktionen",
+			"Reply by email":       "Per E-Mail antworten",
+			"Comments are closed.": "Kommentare sind geschlossen.",
+			"Links":                "Links",
+			"Search links":         "Links durchsuchen",
+			"Search":               "Suchen",
+			"All domains":          "Alle Domains",
+			"No links found.":      "Keine Links gefunden.",
+			"This is a saved copy of the page, which may be out of date.": "Dies ist eine gespeicherte Kopie der Seite, die veraltet sein kann.",
+			"Not found": "Nicht gefunden",
+			"There is no page here, it may have moved or been deleted.": "Hier gibt es keine Seite, sie wurde vielleicht verschoben oder gelöscht.",
+			"Something went wrong": "Etwas ist schiefgelaufen",
+			"This page couldn't be displayed, please try again later.": "Diese Seite konnte nicht angezeigt werden, bitte versuchen Sie es später erneut.",
+			"Read more": "Weiterlesen",
+			"Nothing was posted on this day in previous years.": "An diesem Tag wurde in früheren Jahren nichts veröffentlicht.",
+		},
+	},
+	"es": {
+		justNow:       "justo ahora",
+		ago:           "hace %s",
+		units:         units{"segundo", "segundos", "minuto", "minutos", "hora", "horas", "día", "días", "semana", "semanas", "mes", "meses", "año", "años"},
+		months:        [12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		shortMonths:   [12]string{"ene", "feb", "mar", "abr", "may", "jun", "jul", "ago", "sept", "oct", "nov", "dic"},
+		weekdays:      [7]string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+		shortWeekdays: [7]string{"dom", "lun", "mar", "mié", "jue", "vie", "sáb"},
+		monthDay:      "2 de January",
+		messages: map[string]string{
+			"Next":                 "Siguiente",
+			"Photos":               "Fotos",
+			"On This Day":          "Un día como hoy",
+			"%d min read":          "%d min de lectura",
+			"Related":              "Relacionado",
+			"Interactions":         "Interacciones",
+			"Reply by email":       "Responder por correo",
+			"Comments are closed.": "Los comentarios están cerrados.",
+			"Links":                "Enlaces",
+			"Search links":         "Buscar enlaces",
+			"Search":               "Buscar",
+			"All domains":          "Todos los dominios",
+			"No links found.":      "No se encontraron enlaces.",
+			"This is a saved copy of the page, which may be out of date.": "Esta es una copia guardada de la página, que puede estar desactualizada.",
+			"Not found": "No encontrado",
+			"There is no page here, it may have moved or been deleted.": "Aquí no hay ninguna página, puede que se haya movido o eliminado.",
+			"Something went wrong": "Algo salió mal",
+			"This page couldn't be displayed, please try again later.": "No se pudo mostrar esta página, inténtalo de nuevo más tarde.",
+			"Read more": "Seguir leyendo",
+			"Nothing was posted on this day in previous years.": "No se publicó nada en este día en años anteriores.",
+		},
+	},
+	"fr": {
+		justNow:       "à l'instant",
+		ago:           "il y a %s",
+		units:         units{"seconde", "secondes", "minute", "minutes", "heure", "heures", "jour", "jours", "semaine", "semaines", "mois", "mois", "an", "ans"},
+		months:        [12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		shortMonths:   [12]string{"janv.", "févr.", "mars", "avr.", "mai", "juin", "juil.", "août", "sept.", "oct.", "nov.", "déc."},
+		weekdays:      [7]string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+		shortWeekdays: [7]string{"dim.", "lun.", "mar.", "mer.", "jeu.", "ven.", "sam."},
+		monthDay:      "2 January",
+		messages: map[string]string{
+			"Next":                 "Suivant",
+			"Photos":               "Photos",
+			"On This Day":          "Ce jour-là",
+			"%d min read":          "%d min de lecture",
+			"Related":              "Articles liés",
+			"Interactions":         "Interactions",
+			"Reply by email":       "Répondre par e-mail",
+			"Comments are closed.": "Les commentaires sont fermés.",
+			"Links":                "Liens",
+			"Search links":         "Rechercher des liens",
+			"Search":               "Rechercher",
+			"All domains":          "Tous les domaines",
+			"No links found.":      "Aucun lien trouvé.",
+			"This is a saved copy of the page, which may be out of date.": "Ceci est une copie enregistrée de la page, qui peut être obsolète.",
+			"Not found": "Introuvable",
+			"There is no page here, it may have moved or been deleted.": "Il n'y a pas de page ici, elle a peut-être été déplacée ou supprimée.",
+			"Something went wrong": "Une erreur s'est produite",
+			"This page couldn't be displayed, please try again later.": "Cette page n'a pas pu être affichée, veuillez réessayer plus tard.",
+			"Read more": "Lire la suite",
+			"Nothing was posted on this day in previous years.": "Rien n'a été publié ce jour-là les années précédentes.",
+		},
+	},
+}
+
+func plural(n int, singular, plural string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", singular)
+	}
+	return fmt.Sprintf("%d %s", n, plural)
+}
+
+// HumanTime returns how long before now t was, e.g. "3 days ago", in the
+// language of locale. Zero times return "".
+func HumanTime(t, now time.Time, locale string) string {
+	if t.IsZero() {
+		return ""
+	}
+	p := phrasesFor(locale)
+	u := p.units
+	d := now.Sub(t)
+	var s string
+	switch {
+	case d < 5*time.Second:
+		return p.justNow
+	case d < time.Minute:
+		s = plural(int(d.Seconds()), u.second, u.seconds)
+	case d < time.Hour:
+		s = plural(int(d.Minutes()), u.minute, u.minutes)
+	case d < 24*time.Hour:
+		s = plural(int(d.Hours()), u.hour, u.hours)
+	case d < 7*24*time.Hour:
+		s = plural(int(d.Hours()/24), u.day, u.days)
+	case d < 30*24*time.Hour:
+		s = plural(int(d.Hours()/24/7), u.week, u.weeks)
+	case d < 365*24*time.Hour:
+		s = plural(int(d.Hours()/24/30), u.month, u.months)
+	default:
+		s = plural(int(d.Hours()/24/365), u.year, u.years)
+	}
+	return fmt.Sprintf(p.ago, s)
+}
+
+// New returns the template functions configured by opts.
+func New(opts Options) template.FuncMap {
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+	if opts.DateFormat == "" {
+		opts.DateFormat = DEFAULT_DATE_FORMAT
+	}
+	return template.FuncMap{
+		"trunc": func(s string) string {
+			return Trunc(s, TRUNC_LENGTH)
+		},
+		"humanTime": func(t time.Time) string {
+			return HumanTime(t, time.Now(), opts.Locale)
+		},
+		"readingTime": func(minutes int) string {
+			return fmt.Sprintf(Translate(opts.Locale, "%d min read"), minutes)
+		},
+		// t translates a reader-facing message, e.g. {{t "Next"}}.
+		"t": func(message string) string {
+			return Translate(opts.Locale, message)
+		},
+		"atomTime": func(t time.Time) string {
+			return t.Format(time.RFC3339)
+		},
+		"rssTime": func(t time.Time) string {
+			return t.Format(time.RFC1123Z)
+		},
+		// date formats t in the display timezone using DateFormat.
+		"date": func(t time.Time) string {
+			if t.IsZero() {
+				return ""
+			}
+			return FormatDate(t.In(opts.Location), opts.DateFormat, opts.Locale)
+		},
+		// monthDay formats the day of the year of t, e.g. "June 15" or
+		// "15. Juni".
+		"monthDay": func(t time.Time) string {
+			return FormatDate(t.In(opts.Location), phrasesFor(opts.Locale).monthDay, opts.Locale)
+		},
+		// dateFormat formats t in the display timezone with its own layout,
+		// e.g. {{ dateFormat .Date "January 2" }}.
+		"dateFormat": func(t time.Time, layout string) string {
+			return FormatDate(t.In(opts.Location), layout, opts.Locale)
+		},
+		// local converts t to the display timezone, for templates that use
+		// their own layout, e.g. {{ (local .Created).Format "2006" }}.
+		"local": func(t time.Time) time.Time {
+			return t.In(opts.Location)
+		},
+	}
+}
@@ -0,0 +1,79 @@
+package templatefuncs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrunc(t *testing.T) {
+	assert.Equal(t, "short", Trunc("short", 10))
+	assert.Equal(t, "abc...", Trunc("abcdef", 3))
+	assert.Equal(t, "héé...", Trunc("héééé", 3))
+	assert.Equal(t, "日本...", Trunc("日本語", 2))
+}
+
+func TestHumanTime(t *testing.T) {
+	now := time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, "", HumanTime(time.Time{}, now, "en"))
+	assert.Equal(t, "just now", HumanTime(now.Add(-time.Second), now, "en"))
+	assert.Equal(t, "30 seconds ago", HumanTime(now.Add(-30*time.Second), now, "en"))
+	assert.Equal(t, "1 minute ago", HumanTime(now.Add(-time.Minute), now, "en"))
+	assert.Equal(t, "3 hours ago", HumanTime(now.Add(-3*time.Hour), now, "en"))
+	assert.Equal(t, "2 days ago", HumanTime(now.Add(-48*time.Hour), now, "en"))
+	assert.Equal(t, "2 weeks ago", HumanTime(now.AddDate(0, 0, -14), now, "en"))
+	assert.Equal(t, "3 months ago", HumanTime(now.AddDate(0, -3, 0), now, "en"))
+	assert.Equal(t, "2 years ago", HumanTime(now.AddDate(-2, 0, 0), now, "en"))
+}
+
+func TestHumanTime_Locale(t *testing.T) {
+	now := time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, "vor 3 Stunden", HumanTime(now.Add(-3*time.Hour), now, "de"))
+	assert.Equal(t, "hace 1 día", HumanTime(now.Add(-24*time.Hour), now, "es"))
+	assert.Equal(t, "il y a 2 ans", HumanTime(now.AddDate(-2, 0, 0), now, "fr"))
+	assert.Equal(t, "3 hours ago", HumanTime(now.Add(-3*time.Hour), now, "xx"))
+}
+
+func TestNew_Date(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err)
+	funcs := New(Options{Location: loc, DateFormat: "2006-01-02 15:04"})
+	date := funcs["date"].(func(time.Time) string)
+	assert.Equal(t, "2020-06-15 08:00", date(time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)))
+	assert.Equal(t, "", date(time.Time{}))
+
+	funcs = New(Options{})
+	date = funcs["date"].(func(time.Time) string)
+	assert.Equal(t, "June 15, 2020 12:00 PM UTC", date(time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)))
+}
+
+func TestNegotiate(t *testing.T) {
+	assert.Equal(t, "en", Negotiate(""))
+	assert.Equal(t, "de", Negotiate("de-AT,de;q=0.9,en;q=0.8"))
+	assert.Equal(t, "fr", Negotiate("ja, fr;q=0.5, en;q=0.4"))
+	assert.Equal(t, "en", Negotiate("ja, zh;q=0.5"))
+	assert.Equal(t, "es", Negotiate("en;q=0.2, es"))
+}
+
+func TestFormatDate(t *testing.T) {
+	d := time.Date(2020, 3, 16, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, "Monday, March 16", FormatDate(d, "Monday, January 2", "en"))
+	assert.Equal(t, "Montag, 16. März", FormatDate(d, "Monday, 2. January", "de"))
+	assert.Equal(t, "lun. 16 mars 2020", FormatDate(d, "Mon 2 Jan 2006", "fr"))
+	assert.Equal(t, "March 16", FormatDate(d, "January 2", "xx"))
+
+	monthDay := New(Options{Locale: "es"})["monthDay"].(func(time.Time) string)
+	assert.Equal(t, "16 de marzo", monthDay(d))
+}
+
+func TestTranslate(t *testing.T) {
+	assert.Equal(t, "Weiter", Translate("de", "Next"))
+	assert.Equal(t, "Next", Translate("en", "Next"))
+	assert.Equal(t, "Untranslated", Translate("de", "Untranslated"))
+	assert.Equal(t, "Lire la suite", Translate("fr", "Read more"))
+
+	funcs := New(Options{Locale: "es"})
+	readingTime := funcs["readingTime"].(func(int) string)
+	assert.Equal(t, "3 min de lectura", readingTime(3))
+}
@@ -1,10 +1,19 @@
+// Version: {{.Version}}
 importScripts('https://storage.googleapis.com/workbox-cdn/releases/4.3.1/workbox-sw.js');
 
 workbox.precaching.cleanupOutdatedCaches();
 
-workbox.precaching.precacheAndRoute([
-  {
-    "url": "/offline",
-    "revision": "3"
-  },
-]);
+workbox.precaching.precacheAndRoute({{.Precache}});
+
+self.addEventListener('push', (event) => {
+  const msg = event.data ? event.data.json() : {};
+  event.waitUntil(self.registration.showNotification(msg.title || 'Stream', {
+    icon: '{{assetURL "icon-192x192.png"}}',
+    data: { url: msg.url || '/' },
+  }));
+});
+
+self.addEventListener('notificationclick', (event) => {
+  event.notification.close();
+  event.waitUntil(clients.openWindow(event.notification.data.url));
+});
@@ -0,0 +1,170 @@
+// Package tokens manages revocable bearer tokens, such as those used to read
+// the private feed.
+package tokens
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+
+	"github.com/jcgregorio/go-lib/ds"
+	"github.com/jcgregorio/slog"
+)
+
+const (
+	TOKEN ds.Kind = "Token"
+)
+
+// Scope is what a token grants access to.
+type Scope string
+
+const (
+	// FEED tokens can read the private feed.
+	FEED Scope = "feed"
+
+	// POST tokens can create entries through the quick-post API.
+	POST Scope = "post"
+
+	// ADMIN tokens can use the whole JSON API, which includes everything
+	// the other scopes allow.
+	ADMIN Scope = "admin"
+)
+
+// ToScope converts a string to a Scope, defaulting to FEED.
+func ToScope(s string) Scope {
+	switch sc := Scope(s); sc {
+	case POST, ADMIN:
+		return sc
+	default:
+		return FEED
+	}
+}
+
+// Allows returns true if a token with this scope can be used where scope
+// is required.
+func (s Scope) Allows(scope Scope) bool {
+	return s == scope || s == ADMIN
+}
+
+// Token is a stored token. The token value itself is never stored, only its
+// hash, which is used as the ID.
+type Token struct {
+	ID       string    `datastore:"-"`
+	Label    string    `datastore:"label,noindex"`
+	Scope    Scope     `datastore:"scope"`
+	Created  time.Time `datastore:"created"`
+	LastUsed time.Time `datastore:"last_used,noindex"`
+
+	// Expires is when the token stops working, the zero value means never.
+	Expires time.Time `datastore:"expires,noindex"`
+}
+
+// Expired returns true if the token has an expiry and it has passed.
+func (t *Token) Expired(now time.Time) bool {
+	return !t.Expires.IsZero() && !now.Before(t.Expires)
+}
+
+type Tokens struct {
+	DS  *ds.DS
+	log slog.Logger
+}
+
+func New(ctx context.Context, project, ns string, log slog.Logger) (*Tokens, error) {
+	d, err := ds.New(ctx, project, ns)
+	if err != nil {
+		return nil, err
+	}
+	return &Tokens{
+		DS:  d,
+		log: log,
+	}, nil
+}
+
+func hash(value string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(value)))
+}
+
+func (t *Tokens) key(id string) *datastore.Key {
+	key := t.DS.NewKey(TOKEN)
+	key.Name = id
+	return key
+}
+
+// Create makes a new token and returns its value, which can't be retrieved
+// again later. The token expires after ttl, or never if ttl is zero.
+func (t *Tokens) Create(ctx context.Context, label string, scope Scope, ttl time.Duration) (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	value := fmt.Sprintf("%x", b)
+	now := time.Now()
+	token := &Token{
+		Label:   label,
+		Scope:   scope,
+		Created: now,
+	}
+	if ttl > 0 {
+		token.Expires = now.Add(ttl)
+	}
+	if _, err := t.DS.Client.Put(ctx, t.key(hash(value)), token); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// Validate returns the Token for the given value if it exists, hasn't expired,
+// and its scope allows the given scope.
+func (t *Tokens) Validate(ctx context.Context, value string, scope Scope) (*Token, error) {
+	if value == "" {
+		return nil, fmt.Errorf("Token must be supplied.")
+	}
+	id := hash(value)
+	key := t.key(id)
+	var token Token
+	if err := t.DS.Client.Get(ctx, key, &token); err != nil {
+		return nil, fmt.Errorf("Unknown token.")
+	}
+	if token.Expired(time.Now()) {
+		return nil, fmt.Errorf("Token has expired.")
+	}
+	if !token.Scope.Allows(scope) {
+		return nil, fmt.Errorf("Token doesn't have scope %q.", scope)
+	}
+	token.ID = id
+	token.LastUsed = time.Now()
+	if _, err := t.DS.Client.Put(ctx, key, &token); err != nil {
+		t.log.Warningf("Failed to record token use: %s", err)
+	}
+	return &token, nil
+}
+
+// List returns all the tokens, newest first.
+func (t *Tokens) List(ctx context.Context) ([]*Token, error) {
+	ret := []*Token{}
+	q := t.DS.NewQuery(TOKEN).Order("-created")
+	it := t.DS.Client.Run(ctx, q)
+	for {
+		token := &Token{}
+		key, err := it.Next(token)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed while reading tokens: %s", err)
+		}
+		token.ID = key.Name
+		ret = append(ret, token)
+	}
+	return ret, nil
+}
+
+// Revoke deletes the token with the given ID.
+func (t *Tokens) Revoke(ctx context.Context, id string) error {
+	return t.DS.Client.Delete(ctx, t.key(id))
+}
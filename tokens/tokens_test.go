@@ -0,0 +1,87 @@
+package tokens
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jcgregorio/logger"
+	"github.com/stretchr/testify/assert"
+)
+
+func initForTesting(t *testing.T) *Tokens {
+	if os.Getenv("DATASTORE_EMULATOR_HOST") == "" {
+		t.Skip("Requires a running Cloud Datastore emulator, see entries/entries_test.go.")
+	}
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	tok, err := New(context.Background(), "test-project", fmt.Sprintf("test-namespace-%d", r.Uint64()), logger.New())
+	assert.NoError(t, err)
+	return tok
+}
+
+func TestToScope(t *testing.T) {
+	assert.Equal(t, POST, ToScope("post"))
+	assert.Equal(t, ADMIN, ToScope("admin"))
+	assert.Equal(t, FEED, ToScope("feed"))
+	assert.Equal(t, FEED, ToScope("unknown"))
+}
+
+func TestAllows(t *testing.T) {
+	assert.True(t, FEED.Allows(FEED))
+	assert.False(t, FEED.Allows(POST))
+	assert.False(t, POST.Allows(ADMIN))
+	assert.True(t, ADMIN.Allows(FEED))
+	assert.True(t, ADMIN.Allows(POST))
+}
+
+func TestExpired(t *testing.T) {
+	now := time.Now()
+	assert.False(t, (&Token{}).Expired(now))
+	assert.False(t, (&Token{Expires: now.Add(time.Hour)}).Expired(now))
+	assert.True(t, (&Token{Expires: now}).Expired(now))
+	assert.True(t, (&Token{Expires: now.Add(-time.Hour)}).Expired(now))
+}
+
+func TestTokens(t *testing.T) {
+	tok := initForTesting(t)
+	ctx := context.Background()
+
+	// Round trip.
+	value, err := tok.Create(ctx, "reader", FEED, 0)
+	assert.NoError(t, err)
+	token, err := tok.Validate(ctx, value, FEED)
+	assert.NoError(t, err)
+	assert.Equal(t, "reader", token.Label)
+	assert.Equal(t, FEED, token.Scope)
+	assert.True(t, token.Expires.IsZero())
+
+	// Wrong scope.
+	_, err = tok.Validate(ctx, value, POST)
+	assert.Error(t, err)
+
+	// Tampered.
+	last := value[len(value)-1]
+	flipped := byte('0')
+	if last == '0' {
+		flipped = '1'
+	}
+	_, err = tok.Validate(ctx, value[:len(value)-1]+string(flipped), FEED)
+	assert.Error(t, err)
+	_, err = tok.Validate(ctx, "", FEED)
+	assert.Error(t, err)
+
+	// Expired.
+	expiring, err := tok.Create(ctx, "short lived", FEED, time.Millisecond)
+	assert.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	_, err = tok.Validate(ctx, expiring, FEED)
+	assert.Error(t, err)
+
+	// Revoked.
+	assert.NoError(t, tok.Revoke(ctx, token.ID))
+	_, err = tok.Validate(ctx, value, FEED)
+	assert.Error(t, err)
+}
@@ -0,0 +1,160 @@
+// Package trusted is the set of domains whose webmentions, and vouches, skip
+// moderation.
+package trusted
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/api/iterator"
+
+	"github.com/jcgregorio/go-lib/ds"
+	"github.com/jcgregorio/slog"
+)
+
+const (
+	TRUSTED_DOMAIN ds.Kind = "TrustedDomain"
+)
+
+// Domain is a domain an admin trusted through the admin interface.
+type Domain struct {
+	Name    string    `datastore:"-"`
+	Created time.Time `datastore:"created"`
+}
+
+// Normalize returns the form domains are compared in: lower case and
+// without a leading "www.".
+func Normalize(domain string) string {
+	return strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "www.")
+}
+
+// Trusted combines domains from config with those stored in Datastore. The
+// set is held in memory and only rebuilt when it is changed, so checking a
+// domain doesn't touch Datastore.
+type Trusted struct {
+	DS  *ds.DS
+	log slog.Logger
+
+	mutex   sync.Mutex
+	config  []string
+	domains map[string]bool
+}
+
+func New(ctx context.Context, project, ns string, config []string, log slog.Logger) (*Trusted, error) {
+	d, err := ds.New(ctx, project, ns)
+	if err != nil {
+		return nil, err
+	}
+	t := &Trusted{
+		DS:      d,
+		log:     log,
+		config:  config,
+		domains: set(config, nil),
+	}
+	if err := t.Reload(ctx); err != nil {
+		log.Warningf("Failed to load trusted domains: %s", err)
+	}
+	return t, nil
+}
+
+// set returns the normalized domains from config and stored.
+func set(config []string, stored []*Domain) map[string]bool {
+	ret := map[string]bool{}
+	for _, d := range config {
+		ret[Normalize(d)] = true
+	}
+	for _, d := range stored {
+		ret[Normalize(d.Name)] = true
+	}
+	delete(ret, "")
+	return ret
+}
+
+func (t *Trusted) key(domain string) *datastore.Key {
+	key := t.DS.NewKey(TRUSTED_DOMAIN)
+	key.Name = domain
+	return key
+}
+
+// Trusted returns true if the domain is trusted.
+func (t *Trusted) Trusted(domain string) bool {
+	domain = Normalize(domain)
+	if domain == "" {
+		return false
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.domains[domain]
+}
+
+// SetConfig replaces the domains that come from config, such as when
+// config.json is changed.
+func (t *Trusted) SetConfig(ctx context.Context, config []string) error {
+	t.mutex.Lock()
+	t.config = config
+	t.mutex.Unlock()
+	return t.Reload(ctx)
+}
+
+// Reload rebuilds the set from config and Datastore.
+func (t *Trusted) Reload(ctx context.Context) error {
+	stored, err := t.List(ctx)
+	if err != nil {
+		return err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.domains = set(t.config, stored)
+	return nil
+}
+
+// Add stores a newly trusted domain.
+func (t *Trusted) Add(ctx context.Context, domain string) error {
+	domain = Normalize(domain)
+	if domain == "" {
+		return fmt.Errorf("Domain must not be empty.")
+	}
+	if _, err := t.DS.Client.Put(ctx, t.key(domain), &Domain{Created: time.Now()}); err != nil {
+		return fmt.Errorf("Failed to trust domain: %s", err)
+	}
+	return t.Reload(ctx)
+}
+
+// Remove deletes a stored domain. Domains from config can't be removed.
+func (t *Trusted) Remove(ctx context.Context, domain string) error {
+	if err := t.DS.Client.Delete(ctx, t.key(Normalize(domain))); err != nil {
+		return fmt.Errorf("Failed to remove trusted domain: %s", err)
+	}
+	return t.Reload(ctx)
+}
+
+// List returns the stored domains, newest first.
+func (t *Trusted) List(ctx context.Context) ([]*Domain, error) {
+	ret := []*Domain{}
+	q := t.DS.NewQuery(TRUSTED_DOMAIN).Order("-created")
+	it := t.DS.Client.Run(ctx, q)
+	for {
+		d := &Domain{}
+		key, err := it.Next(d)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed while reading trusted domains: %s", err)
+		}
+		d.Name = key.Name
+		ret = append(ret, d)
+	}
+	return ret, nil
+}
+
+// Config returns the domains that come from config.
+func (t *Trusted) Config() []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.config
+}
@@ -0,0 +1,20 @@
+package trusted
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSet(t *testing.T) {
+	s := set([]string{"WWW.Example.com", ""}, []*Domain{{Name: "friend.example"}})
+	assert.Equal(t, map[string]bool{"example.com": true, "friend.example": true}, s)
+}
+
+func TestTrusted(t *testing.T) {
+	tr := &Trusted{domains: set([]string{"example.com"}, nil)}
+	assert.True(t, tr.Trusted("www.example.com"))
+	assert.True(t, tr.Trusted("Example.com"))
+	assert.False(t, tr.Trusted("sub.example.com"))
+	assert.False(t, tr.Trusted(""))
+}
@@ -0,0 +1,58 @@
+// Package twtxt writes feeds in the twtxt format, one status per line.
+//
+// See https://twtxt.readthedocs.io/en/latest/user/twtxtfile.html.
+package twtxt
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Twt is a single status.
+type Twt struct {
+	Created time.Time
+	Text    string
+}
+
+// Metadata is written as comments at the top of the file.
+type Metadata struct {
+	Nick        string
+	URL         string
+	Avatar      string
+	Description string
+}
+
+// clean makes text fit on a single line, since each line is a twt.
+func clean(text string) string {
+	text = strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n"))
+	text = strings.ReplaceAll(text, "\t", " ")
+	return strings.ReplaceAll(text, "\n", " ")
+}
+
+// Write writes meta and twts to w, oldest twt first.
+func Write(w io.Writer, meta Metadata, twts []Twt) error {
+	for _, kv := range [][2]string{
+		{"nick", meta.Nick},
+		{"url", meta.URL},
+		{"avatar", meta.Avatar},
+		{"description", meta.Description},
+	} {
+		if kv[1] == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "# %s = %s\n", kv[0], clean(kv[1])); err != nil {
+			return err
+		}
+	}
+	sorted := append([]Twt{}, twts...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Created.Before(sorted[j].Created) })
+	for _, t := range sorted {
+		if _, err := fmt.Fprintf(w, "%s\t%s\n", t.Created.Format(time.RFC3339), clean(t.Text)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package twtxt
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+	var b bytes.Buffer
+	err := Write(&b, Metadata{Nick: "joe", URL: "https://example.com/twtxt.txt"}, []Twt{
+		{Created: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC), Text: "Second\r\nline\twith tab"},
+		{Created: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), Text: " First "},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "# nick = joe\n# url = https://example.com/twtxt.txt\n"+
+		"2020-01-01T00:00:00Z\tFirst\n"+
+		"2020-01-02T00:00:00Z\tSecond line with tab\n", b.String())
+}
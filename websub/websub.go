@@ -0,0 +1,426 @@
+// Package websub subscribes to feeds on external WebSub hubs, so that new
+// items, such as interactions collected by Bridgy or granary, are pushed to
+// us instead of being polled for.
+//
+// See https://www.w3.org/TR/websub/.
+package websub
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/PuerkitoBio/goquery"
+	"google.golang.org/api/iterator"
+
+	"github.com/jcgregorio/go-lib/ds"
+	"github.com/jcgregorio/slog"
+)
+
+const (
+	SUBSCRIPTION ds.Kind = "WebSubSubscription"
+
+	// LEASE is the lease asked of hubs, which may grant a different one.
+	LEASE = 10 * 24 * time.Hour
+
+	// RENEW_BEFORE is how long before a lease expires that it's renewed.
+	RENEW_BEFORE = 24 * time.Hour
+
+	// RETRY_AFTER is how long a hub has to verify a subscription before it
+	// is requested again.
+	RETRY_AFTER = time.Hour
+)
+
+// Subscription states.
+const (
+	PENDING       = "pending"
+	ACTIVE        = "active"
+	DENIED        = "denied"
+	UNSUBSCRIBING = "unsubscribing"
+)
+
+// Subscription is a subscription to a topic, i.e. a feed, on a hub.
+type Subscription struct {
+	ID        string    `datastore:"-"`
+	Topic     string    `datastore:"topic,noindex"`
+	Hub       string    `datastore:"hub,noindex"`
+	Secret    string    `datastore:"secret,noindex"`
+	State     string    `datastore:"state,noindex"`
+	Requested time.Time `datastore:"requested,noindex"`
+	Expires   time.Time `datastore:"expires,noindex"`
+
+	// Received is when content was last pushed.
+	Received time.Time `datastore:"received,noindex"`
+
+	// Reason is why the hub denied the subscription.
+	Reason string `datastore:"reason,noindex"`
+}
+
+// Due returns true if the subscription should be requested again.
+func (s *Subscription) Due(now time.Time) bool {
+	switch s.State {
+	case ACTIVE:
+		return now.Add(RENEW_BEFORE).After(s.Expires)
+	case PENDING:
+		return now.Sub(s.Requested) > RETRY_AFTER
+	}
+	return false
+}
+
+type Subscriber struct {
+	DS  *ds.DS
+	log slog.Logger
+
+	// callback is the URL that hubs verify subscriptions with and push
+	// content to, which is followed by the subscription ID.
+	callback string
+
+	client *http.Client
+
+	// now is replaceable for testing.
+	now func() time.Time
+}
+
+// New returns a Subscriber whose callback URLs start with callback, and
+// which talks to topics and hubs with client.
+func New(ctx context.Context, project, ns, callback string, client *http.Client, log slog.Logger) (*Subscriber, error) {
+	d, err := ds.New(ctx, project, ns)
+	if err != nil {
+		return nil, err
+	}
+	return &Subscriber{
+		DS:       d,
+		log:      log,
+		callback: callback,
+		client:   client,
+		now:      time.Now,
+	}, nil
+}
+
+// ID returns the ID of the subscription to topic.
+func ID(topic string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(topic)))[:32]
+}
+
+func (s *Subscriber) key(id string) *datastore.Key {
+	key := s.DS.NewKey(SUBSCRIPTION)
+	key.Name = id
+	return key
+}
+
+// Get returns the subscription with the given ID.
+func (s *Subscriber) Get(ctx context.Context, id string) (*Subscription, error) {
+	sub := &Subscription{}
+	if err := s.DS.Client.Get(ctx, s.key(id), sub); err != nil {
+		return nil, fmt.Errorf("Failed to load subscription: %s", err)
+	}
+	sub.ID = id
+	return sub, nil
+}
+
+func (s *Subscriber) put(ctx context.Context, sub *Subscription) error {
+	if _, err := s.DS.Client.Put(ctx, s.key(sub.ID), sub); err != nil {
+		return fmt.Errorf("Failed to store subscription: %s", err)
+	}
+	return nil
+}
+
+// List returns all the subscriptions, ordered by topic.
+func (s *Subscriber) List(ctx context.Context) ([]*Subscription, error) {
+	ret := []*Subscription{}
+	it := s.DS.Client.Run(ctx, s.DS.NewQuery(SUBSCRIPTION))
+	for {
+		sub := &Subscription{}
+		key, err := it.Next(sub)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed while reading subscriptions: %s", err)
+		}
+		sub.ID = key.Name
+		ret = append(ret, sub)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Topic < ret[j].Topic })
+	return ret, nil
+}
+
+// parseLinks returns the URLs of the given rel in Link headers.
+func parseLinks(headers []string, rel string) []string {
+	ret := []string{}
+	for _, h := range headers {
+		for _, link := range strings.Split(h, ",") {
+			parts := strings.Split(link, ";")
+			u := strings.Trim(strings.TrimSpace(parts[0]), "<>")
+			for _, p := range parts[1:] {
+				name, value, ok := strings.Cut(strings.TrimSpace(p), "=")
+				if !ok || strings.ToLower(name) != "rel" {
+					continue
+				}
+				for _, r := range strings.Fields(strings.Trim(value, `"`)) {
+					if r == rel {
+						ret = append(ret, u)
+					}
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// Discover returns the hub and canonical URL of topic, from its Link headers
+// or, failing that, the link elements of the HTML or Atom document.
+func (s *Subscriber) Discover(ctx context.Context, topic string) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", topic, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid topic %q: %s", topic, err)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to fetch topic %q: %s", topic, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("Failed to fetch topic %q: %s", topic, resp.Status)
+	}
+	hubs := parseLinks(resp.Header.Values("Link"), "hub")
+	selves := parseLinks(resp.Header.Values("Link"), "self")
+	if len(hubs) == 0 {
+		doc, err := goquery.NewDocumentFromReader(resp.Body)
+		if err != nil {
+			return "", "", fmt.Errorf("Failed to parse topic %q: %s", topic, err)
+		}
+		doc.Find("link[href]").Each(func(i int, sel *goquery.Selection) {
+			href, _ := sel.Attr("href")
+			for _, r := range strings.Fields(sel.AttrOr("rel", "")) {
+				switch r {
+				case "hub":
+					hubs = append(hubs, href)
+				case "self":
+					selves = append(selves, href)
+				}
+			}
+		})
+	}
+	if len(hubs) == 0 {
+		return "", "", fmt.Errorf("Topic %q doesn't advertise a hub.", topic)
+	}
+	base := resp.Request.URL
+	hub, err := base.Parse(hubs[0])
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid hub %q: %s", hubs[0], err)
+	}
+	self := base
+	if len(selves) > 0 {
+		if self, err = base.Parse(selves[0]); err != nil {
+			return "", "", fmt.Errorf("Invalid self link %q: %s", selves[0], err)
+		}
+	}
+	return hub.String(), self.String(), nil
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", b), nil
+}
+
+// request sends a subscribe or unsubscribe request for the subscription to
+// its hub.
+func (s *Subscriber) request(ctx context.Context, sub *Subscription, mode string) error {
+	form := url.Values{
+		"hub.mode":     {mode},
+		"hub.topic":    {sub.Topic},
+		"hub.callback": {s.callback + sub.ID},
+	}
+	if mode == "subscribe" {
+		form.Set("hub.secret", sub.Secret)
+		form.Set("hub.lease_seconds", strconv.Itoa(int(LEASE.Seconds())))
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", sub.Hub, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("Invalid hub %q: %s", sub.Hub, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Failed to %s at %q: %s", mode, sub.Hub, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Failed to %s at %q: %s", mode, sub.Hub, resp.Status)
+	}
+	return nil
+}
+
+// Subscribe asks the hub of topic for a subscription, or renews an existing
+// one. The subscription is pending until the hub verifies it.
+func (s *Subscriber) Subscribe(ctx context.Context, topic string) (*Subscription, error) {
+	hub, self, err := s.Discover(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := s.Get(ctx, ID(topic))
+	if err != nil {
+		sub = &Subscription{ID: ID(topic), State: PENDING}
+	}
+	if sub.State != ACTIVE {
+		sub.State = PENDING
+	}
+	// Renewals keep the secret, so content the hub pushes while the renewal
+	// is being verified still validates.
+	if sub.Secret == "" {
+		if sub.Secret, err = newSecret(); err != nil {
+			return nil, err
+		}
+	}
+	sub.Topic = self
+	sub.Hub = hub
+	sub.Requested = s.now()
+	sub.Reason = ""
+	// Stored before the request, since hubs may verify before replying.
+	if err := s.put(ctx, sub); err != nil {
+		return nil, err
+	}
+	if err := s.request(ctx, sub, "subscribe"); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// Unsubscribe asks the hub to end the subscription, which is removed once the
+// hub verifies that. Subscriptions that were never active are just removed.
+func (s *Subscriber) Unsubscribe(ctx context.Context, id string) error {
+	sub, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if sub.State != ACTIVE && sub.State != UNSUBSCRIBING {
+		if err := s.DS.Client.Delete(ctx, s.key(id)); err != nil {
+			return fmt.Errorf("Failed to remove subscription: %s", err)
+		}
+		return nil
+	}
+	sub.State = UNSUBSCRIBING
+	sub.Requested = s.now()
+	if err := s.put(ctx, sub); err != nil {
+		return err
+	}
+	return s.request(ctx, sub, "unsubscribe")
+}
+
+// Verify handles a hub's verification of intent, or denial, for the
+// subscription with the given ID, returning the challenge to echo back. An
+// error means the request wasn't one we asked for and should get a 404.
+func (s *Subscriber) Verify(ctx context.Context, id string, query url.Values) (string, error) {
+	sub, err := s.Get(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if query.Get("hub.topic") != sub.Topic {
+		return "", fmt.Errorf("Verification for the wrong topic %q.", query.Get("hub.topic"))
+	}
+	challenge := query.Get("hub.challenge")
+	switch query.Get("hub.mode") {
+	case "subscribe":
+		if sub.State != PENDING && sub.State != ACTIVE {
+			return "", fmt.Errorf("Unexpected verification of a %s subscription.", sub.State)
+		}
+		lease, err := strconv.Atoi(query.Get("hub.lease_seconds"))
+		if err != nil || lease <= 0 {
+			return "", fmt.Errorf("Invalid lease %q.", query.Get("hub.lease_seconds"))
+		}
+		if challenge == "" {
+			return "", fmt.Errorf("Missing challenge.")
+		}
+		sub.State = ACTIVE
+		sub.Expires = s.now().Add(time.Duration(lease) * time.Second)
+		return challenge, s.put(ctx, sub)
+	case "unsubscribe":
+		if sub.State != UNSUBSCRIBING {
+			return "", fmt.Errorf("Unexpected unsubscribe of a %s subscription.", sub.State)
+		}
+		if challenge == "" {
+			return "", fmt.Errorf("Missing challenge.")
+		}
+		if err := s.DS.Client.Delete(ctx, s.key(id)); err != nil {
+			return "", fmt.Errorf("Failed to remove subscription: %s", err)
+		}
+		return challenge, nil
+	case "denied":
+		sub.State = DENIED
+		sub.Reason = query.Get("hub.reason")
+		return "", s.put(ctx, sub)
+	}
+	return "", fmt.Errorf("Unknown mode %q.", query.Get("hub.mode"))
+}
+
+// validSignature returns true if signature, an X-Hub-Signature header, is
+// the HMAC of body with secret.
+func validSignature(secret, signature string, body []byte) bool {
+	method, sig, ok := strings.Cut(signature, "=")
+	if !ok {
+		return false
+	}
+	var h func() hash.Hash
+	switch method {
+	case "sha1":
+		h = sha1.New
+	case "sha256":
+		h = sha256.New
+	case "sha384":
+		h = sha512.New384
+	case "sha512":
+		h = sha512.New
+	default:
+		return false
+	}
+	expected, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(expected, mac.Sum(nil))
+}
+
+// Receive reads content pushed by the hub for the subscription with the
+// given ID, returning the subscription and the content. Content that isn't
+// signed with the subscription's secret is rejected.
+func (s *Subscriber) Receive(ctx context.Context, id string, r *http.Request) (*Subscription, []byte, error) {
+	sub, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sub.State != ACTIVE {
+		return nil, nil, fmt.Errorf("Content for a %s subscription.", sub.State)
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to read content: %s", err)
+	}
+	if !validSignature(sub.Secret, r.Header.Get("X-Hub-Signature"), body) {
+		return nil, nil, fmt.Errorf("Content for %q has an invalid signature.", sub.Topic)
+	}
+	sub.Received = s.now()
+	if err := s.put(ctx, sub); err != nil {
+		s.log.Warningf("%s", err)
+	}
+	return sub, body, nil
+}
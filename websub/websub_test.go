@@ -0,0 +1,72 @@
+package websub
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLinks(t *testing.T) {
+	headers := []string{
+		`<https://hub.example.com/>; rel="hub", <https://example.com/feed>; rel="self"`,
+		`<https://other.example.com/>; rel="alternate hub"`,
+	}
+	assert.Equal(t, []string{"https://hub.example.com/", "https://other.example.com/"}, parseLinks(headers, "hub"))
+	assert.Equal(t, []string{"https://example.com/feed"}, parseLinks(headers, "self"))
+	assert.Empty(t, parseLinks(nil, "hub"))
+}
+
+func TestDiscover(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/headers":
+			w.Header().Add("Link", `<https://hub.example.com/>; rel="hub"`)
+			w.Header().Add("Link", `</canonical>; rel="self"`)
+		case "/atom":
+			fmt.Fprint(w, `<feed xmlns="http://www.w3.org/2005/Atom"><link rel="hub" href="https://hub.example.com/"/></feed>`)
+		}
+	}))
+	defer s.Close()
+	sub := &Subscriber{client: s.Client()}
+
+	hub, self, err := sub.Discover(context.Background(), s.URL+"/headers")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://hub.example.com/", hub)
+	assert.Equal(t, s.URL+"/canonical", self)
+
+	hub, self, err = sub.Discover(context.Background(), s.URL+"/atom")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://hub.example.com/", hub)
+	assert.Equal(t, s.URL+"/atom", self)
+
+	_, _, err = sub.Discover(context.Background(), s.URL+"/none")
+	assert.Error(t, err)
+}
+
+func TestValidSignature(t *testing.T) {
+	body := []byte("<feed/>")
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(body)
+	sig := fmt.Sprintf("sha256=%x", mac.Sum(nil))
+	assert.True(t, validSignature("secret", sig, body))
+	assert.False(t, validSignature("wrong", sig, body))
+	assert.False(t, validSignature("secret", sig, []byte("<feed></feed>")))
+	assert.False(t, validSignature("secret", "md5=abc", body))
+	assert.False(t, validSignature("secret", "", body))
+}
+
+func TestDue(t *testing.T) {
+	now := time.Now()
+	assert.False(t, (&Subscription{State: ACTIVE, Expires: now.Add(LEASE)}).Due(now))
+	assert.True(t, (&Subscription{State: ACTIVE, Expires: now.Add(time.Hour)}).Due(now))
+	assert.False(t, (&Subscription{State: PENDING, Requested: now}).Due(now))
+	assert.True(t, (&Subscription{State: PENDING, Requested: now.Add(-2 * RETRY_AFTER)}).Due(now))
+	assert.False(t, (&Subscription{State: DENIED}).Due(now))
+}